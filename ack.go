@@ -0,0 +1,113 @@
+package cryptoengine
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Acknowledgements: once the receiver has decrypted a message, it answers with an ACK which references the sequence number
+// and the hash of the encrypted message. The ACK is itself an encrypted header message, with the reserved AckMessageType,
+// so only the holder of the key can produce it and the sender knows the message has been delivered and decrypted end to end.
+// Format of the ACK text:
+// |version|  => 1 byte
+// |sequence| => 8 bytes (little endian sequence number of the acknowledged message, zero for none)
+// |hash|     => 32 bytes (HashWithContext of the acknowledged encrypted message)
+
+const (
+	AckMessageType = 0x41434b // "ACK", the message type reserved for the acknowledgements
+
+	ackVersion   = 1
+	ackSize      = 1 + 8 + HashSize
+	ackHashLabel = "cryptoengine ack"
+)
+
+var (
+	AckError         = errors.New("The message is not a valid acknowledgement")
+	AckMismatchError = errors.New("The acknowledgement does not match the message")
+)
+
+// The Ack references the acknowledged message
+type Ack struct {
+	Sequence  uint64         // the sequence number of the acknowledged message
+	Hash      [HashSize]byte // the hash of the acknowledged encrypted message
+	Timestamp time.Time      // when the acknowledgement has been created, with a second precision
+}
+
+// This method returns the ACK of a message received from an engine sharing the secret key.
+// received is the encrypted message, as received, and sequence its header sequence number.
+func (engine *CryptoEngine) NewAck(received []byte, sequence uint64) ([]byte, error) {
+	msg, header, err := newAck(received, sequence)
+	if err != nil {
+		return nil, err
+	}
+	return engine.NewEncryptedMessageWithHeader(msg, header)
+}
+
+// This method returns the ACK of a message received from the peer
+func (engine *CryptoEngine) NewAckWithPubKey(received []byte, sequence uint64, verificationEngine VerificationEngine) ([]byte, error) {
+	msg, header, err := newAck(received, sequence)
+	if err != nil {
+		return nil, err
+	}
+	return engine.NewEncryptedMessageWithHeaderAndPubKey(msg, header, verificationEngine)
+}
+
+// This method decrypts an ACK returned by NewAck
+func (engine *CryptoEngine) OpenAck(data []byte) (Ack, error) {
+	msg, header, err := engine.DecryptWithHeader(data)
+	if err != nil {
+		return Ack{}, err
+	}
+	return parseAck(msg, header)
+}
+
+// This method decrypts an ACK returned by NewAckWithPubKey of the peer
+func (engine *CryptoEngine) OpenAckWithPublicKey(data []byte, verificationEngine VerificationEngine) (Ack, error) {
+	msg, header, err := engine.DecryptWithHeaderAndPublicKey(data, verificationEngine)
+	if err != nil {
+		return Ack{}, err
+	}
+	return parseAck(msg, header)
+}
+
+// This method checks the ACK references the encrypted message, as it has been sent
+func (ack Ack) Verify(sent []byte) error {
+	hash := HashWithContext(ackHashLabel, sent)
+	if subtle.ConstantTimeCompare(hash[:], ack.Hash[:]) != 1 {
+		return AckMismatchError
+	}
+	return nil
+}
+
+func newAck(received []byte, sequence uint64) (Message, MessageHeader, error) {
+	if len(received) == 0 {
+		return Message{}, MessageHeader{}, AckError
+	}
+	hash := HashWithContext(ackHashLabel, received)
+
+	text := make([]byte, ackSize)
+	text[0] = ackVersion
+	binary.LittleEndian.PutUint64(text[1:9], sequence)
+	copy(text[9:], hash[:])
+
+	msg, err := NewMessage(string(text), AckMessageType)
+	if err != nil {
+		return Message{}, MessageHeader{}, err
+	}
+	return msg, MessageHeader{Timestamp: time.Now()}, nil
+}
+
+func parseAck(msg *Message, header MessageHeader) (Ack, error) {
+	if msg.Type != AckMessageType || len(msg.Text) != ackSize || msg.Text[0] != ackVersion {
+		return Ack{}, AckError
+	}
+
+	ack := Ack{
+		Sequence:  binary.LittleEndian.Uint64([]byte(msg.Text[1:9])),
+		Timestamp: header.Timestamp,
+	}
+	copy(ack.Hash[:], msg.Text[9:])
+	return ack, nil
+}
@@ -0,0 +1,103 @@
+package cryptoengine
+
+import (
+	"testing"
+)
+
+func TestAck(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Ack", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("please confirm", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{Sequence: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{Sequence: 13})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the receiver acknowledges the message once decrypted
+	if _, header, err := engine.DecryptWithHeader(sent); err != nil || header.Sequence != 12 {
+		t.Fatal(err)
+	}
+	data, err := engine.NewAck(sent, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ack, err := engine.OpenAck(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.Sequence != 12 || ack.Timestamp.IsZero() {
+		t.Fatalf("The acknowledgement is not valid: %+v\n", ack)
+	}
+	if err := ack.Verify(sent); err != nil {
+		t.Fatal(err)
+	}
+	if err := ack.Verify(other); err != AckMismatchError {
+		t.Fatalf("Expected %v, got %v\n", AckMismatchError, err)
+	}
+
+	// a regular message is not an acknowledgement
+	if _, err := engine.OpenAck(other); err != AckError {
+		t.Fatalf("Expected %v, got %v\n", AckError, err)
+	}
+	if _, err := engine.NewAck(nil, 12); err != AckError {
+		t.Fatalf("Expected %v, got %v\n", AckError, err)
+	}
+
+}
+
+func TestAckWithPubKey(t *testing.T) {
+
+	sender, err := InitCryptoEngineWithConfig("Sec51AckSender", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := InitCryptoEngineWithConfig("Sec51AckReceiver", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderVerificationEngine, err := NewVerificationEngineWithKey(sender.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiverVerificationEngine, err := NewVerificationEngineWithKey(receiver.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("please confirm", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, err := sender.NewEncryptedMessageWithHeaderAndPubKey(msg, MessageHeader{Sequence: 3}, receiverVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := receiver.NewAckWithPubKey(sent, 3, senderVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, err := sender.OpenAckWithPublicKey(data, receiverVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.Sequence != 3 {
+		t.Fatalf("Expected the sequence 3, got %d\n", ack.Sequence)
+	}
+	if err := ack.Verify(sent); err != nil {
+		t.Fatal(err)
+	}
+
+}
@@ -0,0 +1,534 @@
+package cryptoengine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"strings"
+)
+
+// Support for the age file format (https://age-encryption.org/v1) with X25519 recipients.
+// The engine asymmetric key pair is a valid age X25519 identity, therefore:
+// - files encrypted with `age -r <engine.AgeRecipient()>` can be decrypted with DecryptAge
+// - files encrypted with EncryptAge can be decrypted with `age -d -i <file containing engine.AgeIdentity()>`
+
+const (
+	ageVersionLine    = "age-encryption.org/v1"
+	ageX25519Label    = "age-encryption.org/v1/X25519"
+	ageRecipientHrp   = "age"
+	ageIdentityHrp    = "AGE-SECRET-KEY-"
+	ageFileKeySize    = 16
+	ageNonceSize      = 16
+	ageChunkSize      = 64 * 1024
+	ageColumnsPerLine = 64
+	ageMaxHeaderLines = 1024 // a header with more lines is considered hostile
+)
+
+var (
+	AgeHeaderError     = errors.New("The age header is not valid")
+	AgeNoIdentityError = errors.New("The age file is not encrypted for this engine")
+	AgeRecipientError  = errors.New("The age recipient is not valid")
+	AgePayloadError    = errors.New("The age payload is corrupted or has been tempered with")
+
+	ageEncoding = base64.RawStdEncoding.Strict()
+)
+
+// a recipient stanza of the age header
+type ageStanza struct {
+	kind string
+	args []string
+	body []byte
+}
+
+// Returns the engine public key encoded as an age recipient: age1...
+func (engine *CryptoEngine) AgeRecipient() string {
+	recipient, _ := bech32Encode(ageRecipientHrp, engine.publicKey[:])
+	return recipient
+}
+
+// Returns the engine private key encoded as an age identity: AGE-SECRET-KEY-1...
+// IMPORTANT: this is the private key of the engine, treat it as such.
+func (engine *CryptoEngine) AgeIdentity() string {
+	identity, _ := bech32Encode(ageIdentityHrp, engine.privateKey[:])
+	return strings.ToUpper(identity)
+}
+
+// Parses an age X25519 recipient (age1...) into a VerificationEngine, which can be passed to EncryptAge
+func ParseAgeRecipient(recipient string) (VerificationEngine, error) {
+	hrp, publicKey, err := bech32Decode(recipient)
+	if err != nil || hrp != ageRecipientHrp || len(publicKey) != keySize {
+		return VerificationEngine{}, AgeRecipientError
+	}
+	return NewVerificationEngineWithKey(publicKey)
+}
+
+// This method reads the clear text from src and writes it to dst in the age format.
+// The file can be decrypted by each of the recipients. When no recipient is given, the file is encrypted for the engine itself.
+func (engine *CryptoEngine) EncryptAge(dst io.Writer, src io.Reader, recipients ...VerificationEngine) error {
+	if err := engine.allow(operationEncrypt); err != nil {
+		return err
+	}
+	// encrypting for the engine itself needs its keys
+	if len(recipients) == 0 {
+		if err := engine.allow(operationOwnKeys); err != nil {
+			return err
+		}
+	}
+
+	header, fileKey, err := engine.ageHeader(recipients)
+	if err != nil {
+		return err
+	}
+
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	return ageEncryptPayload(dst, src, fileKey)
+}
+
+// generates the file key and returns the header with the file key wrapped for each recipient
+func (engine *CryptoEngine) ageHeader(recipients []VerificationEngine) ([]byte, []byte, error) {
+
+	if len(recipients) == 0 {
+		recipients = []VerificationEngine{{publicKey: engine.publicKey}}
+	}
+
+	// generate the file key
+	fileKey := make([]byte, ageFileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, nil, err
+	}
+
+	// wrap the file key for each recipient
+	stanzas := make([]ageStanza, 0, len(recipients))
+	for _, recipient := range recipients {
+		publicKey := recipient.PublicKey()
+		if ConstantTimeEqual(publicKey[:], emptyKey) {
+			return nil, nil, KeyNotValidError
+		}
+
+		stanza, err := ageWrapX25519(fileKey, publicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		stanzas = append(stanzas, stanza)
+	}
+
+	header, err := encodeAgeHeader(stanzas, fileKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, fileKey, nil
+}
+
+// encodes the header with the stanzas and authenticates it with the file key
+func encodeAgeHeader(stanzas []ageStanza, fileKey []byte) ([]byte, error) {
+	var header bytes.Buffer
+	header.WriteString(ageVersionLine + "\n")
+	for _, stanza := range stanzas {
+		writeAgeStanza(&header, stanza)
+	}
+
+	header.WriteString("---")
+	mac, err := ageHeaderMAC(fileKey, header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	header.WriteString(" " + ageEncoding.EncodeToString(mac) + "\n")
+
+	return header.Bytes(), nil
+}
+
+// writes the payload nonce and the sealed chunks of the clear text
+func ageEncryptPayload(dst io.Writer, src io.Reader, fileKey []byte) error {
+
+	// derive the payload key from a fresh nonce
+	nonce := make([]byte, ageNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	return ageWritePayload(dst, src, fileKey, nonce)
+}
+
+// writes the nonce and the chunks sealed with the payload key derived from it
+func ageWritePayload(dst io.Writer, src io.Reader, fileKey, nonce []byte) error {
+	if _, err := dst.Write(nonce); err != nil {
+		return err
+	}
+
+	payloadKey, err := ageDeriveKey(fileKey, nonce, "payload")
+	if err != nil {
+		return err
+	}
+
+	return ageSealPayload(dst, src, payloadKey)
+}
+
+// This method reads an age file from src, encrypted for the engine public key, and writes the clear text to dst.
+// IMPORTANT: the clear text is written while it is read, so in case of error dst might contain a truncated clear text.
+func (engine *CryptoEngine) DecryptAge(dst io.Writer, src io.Reader) error {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(src)
+
+	fileKey, err := engine.ageOpenHeader(reader)
+	if err != nil {
+		return err
+	}
+
+	return ageDecryptPayload(dst, reader, fileKey)
+}
+
+// reads and verifies the header and returns the file key wrapped for the engine
+func (engine *CryptoEngine) ageOpenHeader(reader *bufio.Reader) ([]byte, error) {
+
+	stanzas, headerWithoutMAC, mac, err := readAgeHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// find the stanza wrapped for the engine key, a malformed X25519 stanza invalidates the header
+	var fileKey []byte
+	for _, stanza := range stanzas {
+		if stanza.kind != "X25519" {
+			continue
+		}
+		fileKey, err = ageUnwrapX25519(stanza, engine.publicKey, engine.privateKey)
+		if err == AgeHeaderError {
+			return nil, err
+		}
+		if err == nil {
+			break
+		}
+	}
+	if fileKey == nil {
+		return nil, AgeNoIdentityError
+	}
+
+	// verify the header
+	expectedMAC, err := ageHeaderMAC(fileKey, headerWithoutMAC)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, expectedMAC) {
+		return nil, AgeHeaderError
+	}
+
+	return fileKey, nil
+}
+
+// reads the payload nonce and opens the chunks
+func ageDecryptPayload(dst io.Writer, reader *bufio.Reader, fileKey []byte) error {
+
+	// derive the payload key, the nonce is still part of the header for age
+	nonce := make([]byte, ageNonceSize)
+	if _, err := io.ReadFull(reader, nonce); err != nil {
+		return AgeHeaderError
+	}
+
+	payloadKey, err := ageDeriveKey(fileKey, nonce, "payload")
+	if err != nil {
+		return err
+	}
+
+	return ageOpenPayload(dst, reader, payloadKey)
+}
+
+// wraps the file key for the recipient public key, with an ephemeral X25519 key
+func ageWrapX25519(fileKey []byte, publicKey [keySize]byte) (ageStanza, error) {
+	stanza := ageStanza{kind: "X25519"}
+
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return stanza, err
+	}
+
+	share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return stanza, err
+	}
+
+	sharedSecret, err := curve25519.X25519(ephemeral, publicKey[:])
+	if err != nil {
+		return stanza, KeyNotValidError
+	}
+
+	wrappingKey, err := ageDeriveKey(sharedSecret, append(share, publicKey[:]...), ageX25519Label)
+	if err != nil {
+		return stanza, err
+	}
+
+	aead, err := chacha20poly1305.New(wrappingKey)
+	if err != nil {
+		return stanza, err
+	}
+
+	stanza.args = []string{ageEncoding.EncodeToString(share)}
+	stanza.body = aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)
+	return stanza, nil
+}
+
+// unwraps the file key from an X25519 stanza with the engine key pair
+func ageUnwrapX25519(stanza ageStanza, publicKey, privateKey [keySize]byte) ([]byte, error) {
+	if len(stanza.args) != 1 || len(stanza.body) != ageFileKeySize+chacha20poly1305.Overhead {
+		return nil, AgeHeaderError
+	}
+
+	share, err := ageEncoding.DecodeString(stanza.args[0])
+	if err != nil || len(share) != curve25519.PointSize {
+		return nil, AgeHeaderError
+	}
+
+	// X25519 fails on low order points, which would give an all zero shared secret
+	sharedSecret, err := curve25519.X25519(privateKey[:], share)
+	if err != nil {
+		return nil, AgeHeaderError
+	}
+
+	wrappingKey, err := ageDeriveKey(sharedSecret, append(share, publicKey[:]...), ageX25519Label)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.New(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), stanza.body, nil)
+}
+
+// HKDF-SHA256 expansion to a 32 bytes key, as used by age
+func ageDeriveKey(secret, salt []byte, info string) ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// HMAC-SHA256 of the header up to and including the "---"
+func ageHeaderMAC(fileKey, header []byte) ([]byte, error) {
+	hmacKey, err := ageDeriveKey(fileKey, nil, "header")
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, hmacKey)
+	h.Write(header)
+	return h.Sum(nil), nil
+}
+
+// writes the stanza: the arguments line followed by the body wrapped at 64 columns
+// the last line of the body is always shorter than 64 columns, even if that means writing an empty line
+func writeAgeStanza(w *bytes.Buffer, stanza ageStanza) {
+	w.WriteString("-> " + stanza.kind)
+	for _, arg := range stanza.args {
+		w.WriteString(" " + arg)
+	}
+	w.WriteString("\n")
+
+	body := ageEncoding.EncodeToString(stanza.body)
+	for len(body) >= ageColumnsPerLine {
+		w.WriteString(body[:ageColumnsPerLine] + "\n")
+		body = body[ageColumnsPerLine:]
+	}
+	w.WriteString(body + "\n")
+}
+
+// reads and parses the header, returns the stanzas, the header bytes covered by the MAC and the MAC
+func readAgeHeader(r *bufio.Reader) ([]ageStanza, []byte, []byte, error) {
+	var header bytes.Buffer
+	var stanzas []ageStanza
+
+	readLine := func() (string, error) {
+		if header.Len() > ageMaxHeaderLines*(ageColumnsPerLine+1) {
+			return "", AgeHeaderError
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", AgeHeaderError
+		}
+		header.WriteString(line)
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+
+	line, err := readLine()
+	if err != nil || line != ageVersionLine {
+		return nil, nil, nil, AgeHeaderError
+	}
+
+	for {
+		line, err = readLine()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		// end of the header
+		if strings.HasPrefix(line, "--- ") {
+			mac, err := ageEncoding.DecodeString(line[4:])
+			if err != nil || len(mac) != sha256.Size || len(stanzas) == 0 {
+				return nil, nil, nil, AgeHeaderError
+			}
+			// the MAC covers everything up to and including the "---"
+			covered := header.Bytes()[:header.Len()-len(line)-1+3]
+			return stanzas, covered, mac, nil
+		}
+
+		if !strings.HasPrefix(line, "-> ") {
+			return nil, nil, nil, AgeHeaderError
+		}
+
+		// the type and the arguments are not empty strings of printable ASCII characters
+		fields := strings.Split(line[3:], " ")
+		for _, field := range fields {
+			if !isAgeArgument(field) {
+				return nil, nil, nil, AgeHeaderError
+			}
+		}
+		stanza := ageStanza{kind: fields[0], args: fields[1:]}
+
+		// the body ends with the first line shorter than 64 columns
+		var body strings.Builder
+		for {
+			line, err = readLine()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if len(line) > ageColumnsPerLine {
+				return nil, nil, nil, AgeHeaderError
+			}
+			body.WriteString(line)
+			if len(line) < ageColumnsPerLine {
+				break
+			}
+		}
+
+		if stanza.body, err = ageEncoding.DecodeString(body.String()); err != nil {
+			return nil, nil, nil, AgeHeaderError
+		}
+		stanzas = append(stanzas, stanza)
+	}
+}
+
+// returns true if the argument is not empty and made of printable ASCII characters only
+func isAgeArgument(argument string) bool {
+	if argument == "" {
+		return false
+	}
+	for i := 0; i < len(argument); i++ {
+		if argument[i] < 33 || argument[i] > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the STREAM nonce: 11 bytes big endian counter and the last chunk flag
+func ageChunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	for i := 10; i >= 3; i-- {
+		nonce[i] = byte(counter)
+		counter >>= 8
+	}
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encrypts the payload in chunks of 64KB
+// the last chunk is marked as such, and it's empty only when the whole payload is empty
+func ageSealPayload(dst io.Writer, src io.Reader, payloadKey []byte) error {
+	aead, err := chacha20poly1305.New(payloadKey)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(src, ageChunkSize+1)
+	chunk := make([]byte, ageChunkSize)
+	sealed := make([]byte, 0, ageChunkSize+chacha20poly1305.Overhead)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		// it's the last chunk if there is nothing else to read
+		last := err != nil
+		if !last {
+			if _, err := reader.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		sealed = aead.Seal(sealed[:0], ageChunkNonce(counter, last), chunk[:n], nil)
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// decrypts the payload chunk by chunk and writes the clear text to dst
+func ageOpenPayload(dst io.Writer, src *bufio.Reader, payloadKey []byte) error {
+	aead, err := chacha20poly1305.New(payloadKey)
+	if err != nil {
+		return err
+	}
+
+	sealed := make([]byte, ageChunkSize+chacha20poly1305.Overhead)
+	chunk := make([]byte, 0, ageChunkSize)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(src, sealed)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if n < chacha20poly1305.Overhead {
+			return AgePayloadError
+		}
+
+		// a short chunk must be the last one, a full one is the last only when the stream ends
+		last := err != nil
+		if !last {
+			if _, err := src.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		chunk, err = aead.Open(chunk[:0], ageChunkNonce(counter, last), sealed[:n], nil)
+		if err != nil {
+			return AgePayloadError
+		}
+
+		// only an empty payload can have an empty last chunk
+		if last && len(chunk) == 0 && counter > 0 {
+			return AgePayloadError
+		}
+
+		if _, err := dst.Write(chunk); err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
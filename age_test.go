@@ -0,0 +1,261 @@
+package cryptoengine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/crypto/curve25519"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBech32(t *testing.T) {
+
+	// BIP 173 test vectors
+	valid := []string{
+		"A12UEL5L",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	}
+	for _, s := range valid {
+		if _, _, err := bech32Decode(s); err != nil {
+			t.Errorf("%s should be a valid bech32 string\n", s)
+		}
+	}
+
+	invalid := []string{
+		"A1G7SGD8",     // wrong checksum
+		"A12UEl5L",     // mixed case
+		"pzry9x0s0muk", // no separator
+	}
+	for _, s := range invalid {
+		if _, _, err := bech32Decode(s); err == nil {
+			t.Errorf("%s should not be a valid bech32 string\n", s)
+		}
+	}
+
+}
+
+func TestAgeRecipientAndIdentity(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipient := engine.AgeRecipient()
+	if !strings.HasPrefix(recipient, "age1") {
+		t.Errorf("Unexpected age recipient: %s\n", recipient)
+	}
+
+	if !strings.HasPrefix(engine.AgeIdentity(), "AGE-SECRET-KEY-1") {
+		t.Error("Unexpected age identity format")
+	}
+
+	verificationEngine, err := ParseAgeRecipient(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey := verificationEngine.PublicKey()
+	if !bytes.Equal(publicKey[:], engine.PublicKey()) {
+		t.Fatal("The age recipient does not round trip")
+	}
+
+	if _, err := ParseAgeRecipient(strings.ToUpper(engine.AgeIdentity())); err != AgeRecipientError {
+		t.Errorf("The expected error is: AgeRecipientError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestAgeEncryption(t *testing.T) {
+
+	firstEngine, err := InitCryptoEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondEngine, err := InitCryptoEngine("Sec51Peer2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondVerificationEngine, err := NewVerificationEngine("Sec51Peer2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thirdEngine, err := InitCryptoEngine("Sec51Peer3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// empty, less than a chunk, exactly one chunk and several chunks
+	for _, size := range []int{0, 100, ageChunkSize, 3*ageChunkSize + 7} {
+		clearText := make([]byte, size)
+		if _, err := rand.Read(clearText); err != nil {
+			t.Fatal(err)
+		}
+
+		var encrypted bytes.Buffer
+		if err := firstEngine.EncryptAge(&encrypted, bytes.NewReader(clearText), secondVerificationEngine); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(encrypted.String(), "age-encryption.org/v1\n-> X25519 ") {
+			t.Fatal("Unexpected age header")
+		}
+
+		var decrypted bytes.Buffer
+		if err := secondEngine.DecryptAge(&decrypted, bytes.NewReader(encrypted.Bytes())); err != nil {
+			t.Fatalf("Size %d: %s\n", size, err)
+		}
+
+		if !bytes.Equal(decrypted.Bytes(), clearText) {
+			t.Fatalf("Size %d: age encryption/decryption broken\n", size)
+		}
+
+		// the file is not encrypted for the third engine
+		if err := thirdEngine.DecryptAge(&decrypted, bytes.NewReader(encrypted.Bytes())); err != AgeNoIdentityError {
+			t.Errorf("The expected error is: AgeNoIdentityError, instead we've got: %v\n", err)
+		}
+
+		// tamper with the last byte of the payload
+		tampered := encrypted.Bytes()
+		tampered[len(tampered)-1] ^= 0xff
+		if err := secondEngine.DecryptAge(&decrypted, bytes.NewReader(tampered)); err != AgePayloadError {
+			t.Errorf("The expected error is: AgePayloadError, instead we've got: %v\n", err)
+		}
+	}
+
+}
+
+func TestAgeTruncatedPayload(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encrypted bytes.Buffer
+	if err := engine.EncryptAge(&encrypted, bytes.NewReader(make([]byte, 2*ageChunkSize+10))); err != nil {
+		t.Fatal(err)
+	}
+
+	// drop the last chunk, the previous one is not marked as last
+	truncated := encrypted.Bytes()[:encrypted.Len()-10-16]
+	var decrypted bytes.Buffer
+	if err := engine.DecryptAge(&decrypted, bytes.NewReader(truncated)); err != AgePayloadError {
+		t.Errorf("The expected error is: AgePayloadError, instead we've got: %v\n", err)
+	}
+
+}
+
+// the errors DecryptAge returns for the outcomes of the age testkit
+var ageTestkitErrors = map[string]error{
+	"success":         nil,
+	"no match":        AgeNoIdentityError,
+	"HMAC failure":    AgeHeaderError,
+	"header failure":  AgeHeaderError,
+	"payload failure": AgePayloadError,
+}
+
+// test vectors of the age testkit (https://github.com/C2SP/CCTV/tree/main/age) in testdata/age,
+// without the armored and the scrypt ones which are not supported
+func TestAgeTestkit(t *testing.T) {
+
+	files, err := filepath.Glob("testdata/age/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("The age test vectors are missing")
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the textual header, an empty line and the age file
+		separator := bytes.Index(data, []byte("\n\n"))
+		if separator < 0 {
+			t.Fatalf("%s: the test vector is not valid\n", file)
+		}
+		vector := make(map[string]string)
+		for _, line := range strings.Split(string(data[:separator]), "\n") {
+			fields := strings.SplitN(line, ": ", 2)
+			if len(fields) != 2 {
+				t.Fatalf("%s: the test vector is not valid\n", file)
+			}
+			vector[fields[0]] = fields[1]
+		}
+		ageFile := data[separator+2:]
+
+		expected, ok := ageTestkitErrors[vector["expect"]]
+		if !ok {
+			t.Fatalf("%s: unexpected outcome %s\n", file, vector["expect"])
+		}
+
+		hrp, privateKey, err := bech32Decode(vector["identity"])
+		if err != nil || hrp != strings.ToLower(ageIdentityHrp) || len(privateKey) != keySize {
+			t.Fatalf("%s: the identity is not valid\n", file)
+		}
+		engine := &CryptoEngine{}
+		copy(engine.privateKey[:], privateKey)
+		publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(engine.publicKey[:], publicKey)
+
+		// decryption
+		var decrypted bytes.Buffer
+		if err := engine.DecryptAge(&decrypted, bytes.NewReader(ageFile)); err != expected {
+			t.Errorf("%s: the expected error is: %v, instead we've got: %v\n", file, expected, err)
+			continue
+		}
+		if expected != nil {
+			continue
+		}
+		payloadHash := sha256.Sum256(decrypted.Bytes())
+		if hex.EncodeToString(payloadHash[:]) != vector["payload"] {
+			t.Errorf("%s: the decrypted payload does not match\n", file)
+			continue
+		}
+
+		// encryption: the same file key, stanzas and nonce give the same file
+		fileKey, err := hex.DecodeString(vector["file key"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(bytes.NewReader(ageFile))
+		stanzas, _, _, err := readAgeHeader(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := make([]byte, ageNonceSize)
+		if _, err := io.ReadFull(reader, nonce); err != nil {
+			t.Fatal(err)
+		}
+
+		encrypted, err := encodeAgeHeader(stanzas, fileKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := bytes.NewBuffer(encrypted)
+		if err := ageWritePayload(payload, &decrypted, fileKey, nonce); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(payload.Bytes(), ageFile) {
+			t.Errorf("%s: the encrypted file does not match\n", file)
+		}
+	}
+
+}
@@ -0,0 +1,47 @@
+package cryptoengine
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"errors"
+)
+
+// Keyed MAC, the NaCl crypto_auth construction: HMAC-SHA-512 truncated to 256 bits, keyed with a subkey derived from the engine secret key.
+// It protects the integrity and the authenticity of data which must remain readable: only the engines sharing the secret key
+// can compute or verify the MAC.
+
+const (
+	authSubKeyLabel = "auth"
+	MACSize         = 32 // size in bytes of the MACs returned by Authenticate
+)
+
+var (
+	MACError = errors.New("The MAC is not valid")
+)
+
+// This method returns the MAC of the data
+func (engine *CryptoEngine) Authenticate(data []byte) ([]byte, error) {
+	key, err := engine.deriveSubKey(authSubKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return authenticate(key, data), nil
+}
+
+// This method verifies, in constant time, that the MAC has been computed by Authenticate for the data
+func (engine *CryptoEngine) VerifyMAC(data, mac []byte) error {
+	key, err := engine.deriveSubKey(authSubKeyLabel)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(authenticate(key, data), mac) {
+		return MACError
+	}
+	return nil
+}
+
+func authenticate(key [keySize]byte, data []byte) []byte {
+	mac := hmac.New(sha512.New, key[:])
+	mac.Write(data)
+	return mac.Sum(nil)[:MACSize]
+}
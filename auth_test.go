@@ -0,0 +1,58 @@
+package cryptoengine
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	mac, err := engine.Authenticate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mac) != MACSize {
+		t.Fatal("The MAC size is not valid")
+	}
+
+	if err := engine.VerifyMAC(data, mac); err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered data
+	if err := engine.VerifyMAC([]byte("The quick brown fox jumps over the lazy cat"), mac); err != MACError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MACError, err)
+	}
+
+	// truncated MAC
+	if err := engine.VerifyMAC(data, mac[:16]); err != MACError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MACError, err)
+	}
+
+	// another secret key
+	other, err := InitCryptoEngine("Sec51Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.VerifyMAC(data, mac); err != MACError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MACError, err)
+	}
+}
+
+// HMAC-SHA-512 test case 2 of RFC 4231, truncated to 256 bits like in the NaCl crypto_auth tests
+func TestAuthenticateVector(t *testing.T) {
+
+	var key [keySize]byte
+	copy(key[:], "Jefe")
+
+	expected := "164b7a7bfcf819e2e395fbe73b56e0a387bd64222e831fd610270cd7ea250554"
+	if mac := hex.EncodeToString(authenticate(key, []byte("what do ya want for nothing?"))); mac != expected {
+		t.Errorf("The expected MAC is: %s, instead we've got: %s\n", expected, mac)
+	}
+}
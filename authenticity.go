@@ -0,0 +1,103 @@
+package cryptoengine
+
+import (
+	"errors"
+)
+
+// Deniable or non-repudiable messages between two engines: the protocol designer chooses whether the receiver
+// can prove to a third party who wrote a message.
+//
+// Deniable mode, NewDeniableMessage: the message is encrypted with a key derived from the X25519 shared secret
+// of the two engines, like NewEncryptedMessageWithPubKey and NewEncryptedMessageWithHeaderAndPubKey. The receiver knows
+// the sender wrote it, because only the two engines can compute the key, but the receiver can compute the same key
+// and forge any message: the message proves nothing to anybody else. This is the right default for private conversations.
+//
+// Non-repudiable mode, NewNonRepudiableMessage: the message and its header are signed with the Ed25519 signing key
+// of the sender, like NewSignedMessage, with the public key of the recipient in the header, then the signed message
+// is encrypted like in the deniable mode. The receiver gets the signed message as a proof, which anyone with the
+// signing public key of the sender can verify with VerifyNonRepudiationProof, for instance an arbiter of a dispute.
+// The recipient in the signature prevents the receiver from forwarding the proof as if it had been sent to somebody else.
+// The encrypted message uses the SuiteNonRepudiable suite, so it cannot be opened as a deniable message and vice versa.
+
+var (
+	NonRepudiationRecipientError = errors.New("The signed message has been sent to another recipient")
+)
+
+// This method encrypts the message for the peer in the deniable mode: the peer cannot prove to anybody else who wrote it.
+// It works exactly like NewEncryptedMessageWithHeaderAndPubKey.
+func (engine *CryptoEngine) NewDeniableMessage(msg Message, header MessageHeader, verificationEngine VerificationEngine) ([]byte, error) {
+	return engine.NewEncryptedMessageWithHeaderAndPubKey(msg, header, verificationEngine)
+}
+
+// This method decrypts a message returned by NewDeniableMessage.
+// It works exactly like DecryptWithHeaderAndPublicKey.
+func (engine *CryptoEngine) OpenDeniableMessage(data []byte, verificationEngine VerificationEngine) (*Message, MessageHeader, error) {
+	return engine.DecryptWithHeaderAndPublicKey(data, verificationEngine)
+}
+
+// This method signs the message for the peer and encrypts it in the non-repudiable mode: the peer can prove to anybody that the engine wrote it.
+// The header is signed with the message, the KeyID and the Checksum are also used for the encrypted message.
+func (engine *CryptoEngine) NewNonRepudiableMessage(msg Message, header MessageHeader, verificationEngine VerificationEngine) ([]byte, error) {
+	peerPublicKey := verificationEngine.PublicKey()
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return nil, KeyNotValidError
+	}
+
+	// the checksum protects the encrypted message, not the proof
+	signedHeader := header
+	signedHeader.Recipient = peerPublicKey[:]
+	signedHeader.Checksum = 0
+	signed, err := engine.NewSignedMessage(msg, signedHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := engine.headerPublicKey(peerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := engine.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	envelope := MessageHeader{KeyID: header.KeyID, Checksum: header.Checksum, Suite: SuiteNonRepudiable}
+	return engine.sealWithHeader(key, nonce, Message{Version: tcpVersion, Text: string(signed)}, envelope)
+}
+
+// This method decrypts a message returned by NewNonRepudiableMessage and verifies its signature with the signing key of the peer.
+// It returns the message, its signed header and the proof the peer wrote it, which VerifyNonRepudiationProof verifies.
+func (engine *CryptoEngine) OpenNonRepudiableMessage(data []byte, verificationEngine VerificationEngine) (*Message, MessageHeader, []byte, error) {
+	peerPublicKey := verificationEngine.PublicKey()
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return nil, MessageHeader{}, nil, KeyNotValidError
+	}
+
+	key, err := engine.headerPublicKey(peerPublicKey)
+	if err != nil {
+		return nil, MessageHeader{}, nil, err
+	}
+	envelope, _, err := engine.openWithHeader(key, SuiteNonRepudiable, peerReplayIdentifier(peerPublicKey), data)
+	if err != nil {
+		return nil, MessageHeader{}, nil, err
+	}
+
+	proof := []byte(envelope.Text)
+	msg, header, err := verificationEngine.VerifyNonRepudiationProof(proof, engine.publicKey)
+	if err != nil {
+		return nil, MessageHeader{}, nil, err
+	}
+	return msg, header, proof, nil
+}
+
+// This method verifies the proof returned by OpenNonRepudiableMessage: the peer signed the message for the recipient public key.
+// It returns the message and its signed header.
+func (e VerificationEngine) VerifyNonRepudiationProof(proof []byte, recipientPublicKey [keySize]byte) (*Message, MessageHeader, error) {
+	msg, header, err := e.VerifySignedMessage(proof)
+	if err != nil {
+		return nil, MessageHeader{}, err
+	}
+	if !ConstantTimeEqual(header.Recipient, recipientPublicKey[:]) {
+		return nil, MessageHeader{}, NonRepudiationRecipientError
+	}
+	return msg, header, nil
+}
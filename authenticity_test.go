@@ -0,0 +1,69 @@
+package cryptoengine
+
+import (
+	"testing"
+)
+
+func TestNonRepudiableMessage(t *testing.T) {
+
+	alice, err := InitCryptoEngineWithConfig("Sec51Alice", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := InitCryptoEngineWithConfig("Sec51Bob", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceKeys, err := NewVerificationEngineWithKeys(alice.PublicKey(), alice.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobKeys, err := NewVerificationEngineWithKeys(bob.PublicKey(), bob.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("I owe Bob 10 coins", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := alice.NewNonRepudiableMessage(msg, MessageHeader{Sequence: 7, Checksum: ChecksumCRC32C}, bobKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, header, proof, err := bob.OpenNonRepudiableMessage(data, aliceKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text || decrypted.Type != msg.Type || header.Sequence != 7 {
+		t.Fatalf("The non repudiable message does not match: %+v %+v\n", decrypted, header)
+	}
+
+	// a third party verifies the proof with the public keys only
+	if proven, _, err := aliceKeys.VerifyNonRepudiationProof(proof, bob.publicKey); err != nil || proven.Text != msg.Text {
+		t.Fatalf("The proof should be verified: %v\n", err)
+	}
+	if _, _, err := aliceKeys.VerifyNonRepudiationProof(proof, alice.publicKey); err != NonRepudiationRecipientError {
+		t.Fatalf("Expected %v, got %v\n", NonRepudiationRecipientError, err)
+	}
+	if _, _, err := bobKeys.VerifyNonRepudiationProof(proof, bob.publicKey); err != SignatureError {
+		t.Fatalf("Expected %v, got %v\n", SignatureError, err)
+	}
+
+	// the modes cannot be confused
+	if _, _, err := bob.OpenDeniableMessage(data, aliceKeys); err != HeaderSuiteError {
+		t.Fatalf("Expected %v, got %v\n", HeaderSuiteError, err)
+	}
+	deniable, err := alice.NewDeniableMessage(msg, MessageHeader{}, bobKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := bob.OpenNonRepudiableMessage(deniable, aliceKeys); err != HeaderSuiteError {
+		t.Fatalf("Expected %v, got %v\n", HeaderSuiteError, err)
+	}
+	if decrypted, _, err := bob.OpenDeniableMessage(deniable, aliceKeys); err != nil || decrypted.Text != msg.Text {
+		t.Fatalf("The deniable message should be decrypted: %v\n", err)
+	}
+
+}
@@ -0,0 +1,57 @@
+package cryptoengine
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Bitcoin base58 encoding, used by the multibase base58btc strings of the peer public keys
+
+const (
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+var (
+	Base58Error = errors.New("The base58 string is not valid")
+	base58Radix = big.NewInt(58)
+)
+
+func base58Encode(data []byte) string {
+	value := new(big.Int).SetBytes(data)
+	modulo := new(big.Int)
+	var encoded []byte
+	for value.Sign() > 0 {
+		value.DivMod(value, base58Radix, modulo)
+		encoded = append(encoded, base58Alphabet[modulo.Int64()])
+	}
+	// each leading zero byte is encoded as the first character
+	for i := 0; i < len(data) && data[i] == 0; i++ {
+		encoded = append(encoded, base58Alphabet[0])
+	}
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	if s == "" {
+		return nil, Base58Error
+	}
+	value := new(big.Int)
+	for i := 0; i < len(s); i++ {
+		digit := strings.IndexByte(base58Alphabet, s[i])
+		if digit < 0 {
+			return nil, Base58Error
+		}
+		value.Mul(value, base58Radix)
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), value.Bytes()...), nil
+}
@@ -0,0 +1,83 @@
+package cryptoengine
+
+import (
+	"context"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// This method encrypts a batch of messages using the symmetric key.
+// The nonces for the whole batch are derived at once, which is considerably faster than calling NewEncryptedMessage
+// for each message when encrypting thousands of small records.
+// If one of the messages exceeds the maximum size, nothing is encrypted and the error is returned.
+func (engine *CryptoEngine) EncryptBatch(msgs []Message) ([]EncryptedMessage, error) {
+
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+
+	// serialize and validate all the messages first
+	clearTexts := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		clearTexts[i] = msg.toBytes()
+		if encryptedMessageSize(len(clearTexts[i])) > engine.maxMessageSize() {
+			return nil, MessageTooLargeError
+		}
+	}
+
+	// derive the nonces for the whole batch
+	nonces, err := engine.DeriveNonces(len(msgs))
+	if err != nil {
+		return nil, err
+	}
+
+	// seal the messages concurrently, each worker writes at its own index so the order is preserved
+	encryptedMessages := make([]EncryptedMessage, len(msgs))
+	runWorkers(len(msgs), engine.parallelism(), func(i int) {
+		m := &encryptedMessages[i]
+		m.nonce = nonces[i]
+		m.data = secretbox.Seal(nil, clearTexts[i], &m.nonce, &engine.secretKey)
+		m.length = uint64(len(m.data) + len(m.nonce) + 8)
+	})
+
+	return encryptedMessages, nil
+}
+
+// This method reserves n nonce counters and derives their nonces at once: a single HKDF pass expands up to 340 nonces,
+// instead of one HKDF setup per nonce. The nonces are unique, each of them must be used for a single encryption with the engine keys.
+func (engine *CryptoEngine) DeriveNonces(n int) ([][nonceSize]byte, error) {
+	return engine.deriveNoncesContext(context.Background(), n)
+}
+
+func (engine *CryptoEngine) deriveNoncesContext(ctx context.Context, n int) ([][nonceSize]byte, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	first, salt, err := engine.reserveCountersContext(ctx, uint64(n))
+	if err != nil {
+		return nil, err
+	}
+	return deriveNonces(engine.nonceKey, salt, engine.context, first, n)
+}
+
+// returns the next nonce of the prefetched block, a new block is derived when it's exhausted
+func (engine *CryptoEngine) prefetchedNonce(ctx context.Context) ([nonceSize]byte, error) {
+	engine.nonceMutex.Lock()
+	defer engine.nonceMutex.Unlock()
+
+	if len(engine.prefetchedNonces) == 0 {
+		size := engine.config.NoncePrefetch
+		if size > noncesPerPass {
+			size = noncesPerPass
+		}
+		nonces, err := engine.deriveNoncesContext(ctx, size)
+		if err != nil {
+			return [nonceSize]byte{}, err
+		}
+		engine.prefetchedNonces = nonces
+	}
+
+	nonce := engine.prefetchedNonces[0]
+	engine.prefetchedNonces[0] = [nonceSize]byte{}
+	engine.prefetchedNonces = engine.prefetchedNonces[1:]
+	return nonce, nil
+}
@@ -0,0 +1,110 @@
+package cryptoengine
+
+import (
+	"encoding/hex"
+	"strconv"
+	"testing"
+)
+
+func TestEncryptBatch(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bigger than a single HKDF pass
+	total := noncesPerPass + 10
+	msgs := make([]Message, total)
+	for i := range msgs {
+		msgs[i], err = NewMessage("record "+strconv.Itoa(i), i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encryptedMessages, err := engine.EncryptBatch(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(encryptedMessages) != total {
+		t.Fatalf("Expected %d encrypted messages, got %d\n", total, len(encryptedMessages))
+	}
+
+	nonces := make(map[string]bool)
+	for i, encryptedMessage := range encryptedMessages {
+		nonce := hex.EncodeToString(encryptedMessage.nonce[:])
+		if nonces[nonce] {
+			t.Fatal("The batch encryption has generated a duplicated nonce !!!")
+		}
+		nonces[nonce] = true
+
+		messageBytes, err := encryptedMessage.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := engine.Decrypt(messageBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decrypted.Text != msgs[i].Text || decrypted.Type != msgs[i].Type {
+			t.Fatal("Batch encryption/decryption broken")
+		}
+	}
+
+}
+
+func TestNoncePrefetch(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Prefetch", Config{KeyStore: NewMemoryKeyStore(), NoncePrefetch: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("prefetched", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonces := make(map[[nonceSize]byte]bool)
+	for i := 0; i < 40; i++ {
+		encryptedMessage, err := engine.NewEncryptedMessage(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonces[encryptedMessage.nonce] {
+			t.Fatal("The prefetched nonces have been reused !!!")
+		}
+		nonces[encryptedMessage.nonce] = true
+
+		messageBytes, err := encryptedMessage.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted, err := engine.Decrypt(messageBytes); err != nil || decrypted.Text != msg.Text {
+			t.Fatalf("Prefetched nonce encryption/decryption broken: %v\n", err)
+		}
+	}
+
+	// the counters are reserved by block
+	if counter, _ := engine.NonceUsage(); counter != 48 {
+		t.Fatalf("Expected 3 blocks of 16 counters, got %d\n", counter)
+	}
+
+	derived, err := engine.DeriveNonces(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nonce := range derived {
+		if nonces[nonce] {
+			t.Fatal("The derived nonces have been reused !!!")
+		}
+		nonces[nonce] = true
+	}
+	if len(nonces) != 45 {
+		t.Fatalf("Expected 45 nonces, got %d\n", len(nonces))
+	}
+
+}
@@ -0,0 +1,132 @@
+package cryptoengine
+
+import (
+	"errors"
+	"strings"
+)
+
+// BIP 173 bech32 encoding, used by the age recipient and identity strings
+
+const (
+	bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+var (
+	Bech32Error = errors.New("The bech32 string is not valid")
+)
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+// regroups the bits of data from fromBits to toBits per element
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	maxValue := uint32(1)<<toBits - 1
+	out := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, b := range data {
+		if uint32(b)>>fromBits != 0 {
+			return nil, Bech32Error
+		}
+		acc = acc<<fromBits | uint32(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxValue))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(toBits-bits)&maxValue))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxValue != 0 {
+		return nil, Bech32Error
+	}
+	return out, nil
+}
+
+// encodes the data with the human readable part hrp, the result is lower case
+func bech32Encode(hrp string, data []byte) (string, error) {
+	hrp = strings.ToLower(hrp)
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	polymod := bech32Polymod(append(append(bech32HrpExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+
+	var encoded strings.Builder
+	encoded.WriteString(hrp)
+	encoded.WriteByte('1')
+	for _, v := range values {
+		encoded.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		encoded.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return encoded.String(), nil
+}
+
+// decodes a bech32 string and returns the lower case human readable part and the data
+// mixed case strings are rejected, as mandated by BIP 173
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, Bech32Error
+	}
+	s = strings.ToLower(s)
+
+	separator := strings.LastIndex(s, "1")
+	if separator < 1 || separator+7 > len(s) {
+		return "", nil, Bech32Error
+	}
+
+	hrp := s[:separator]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, Bech32Error
+		}
+	}
+
+	values := make([]byte, 0, len(s)-separator-1)
+	for i := separator + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, Bech32Error
+		}
+		values = append(values, byte(v))
+	}
+
+	if bech32Polymod(append(bech32HrpExpand(hrp), values...)) != 1 {
+		return "", nil, Bech32Error
+	}
+
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
@@ -0,0 +1,125 @@
+// Package boltstore provides a cryptoengine key store and counter store backed by an embedded bbolt database.
+//
+// All the keys and counters are stored in a single file and every write is a transaction,
+// so a crash never leaves a partially written key or a counter which has gone backwards.
+//
+//	store, err := boltstore.Open("/var/lib/app/keys.db")
+//	engine, err := cryptoengine.InitCryptoEngineWithConfig("app", cryptoengine.Config{KeyStore: store, CounterStore: store})
+package boltstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/sec51/cryptoengine"
+	bolt "go.etcd.io/bbolt"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	openTimeout = 5 * time.Second // how long Open waits for the lock of a database opened by another process
+)
+
+var (
+	keysBucket     = []byte("keys")
+	countersBucket = []byte("counters")
+
+	CounterFormatError = errors.New("The stored counter is not valid")
+)
+
+// The Store implements the cryptoengine.KeyStore and cryptoengine.CounterStore interfaces
+type Store struct {
+	db *bolt.DB
+}
+
+// This function opens, or creates, the database file and returns the store
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := New(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// This function returns a store using the database, creating its buckets if they do not exist
+func New(db *bolt.DB) (*Store, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{keysBucket, countersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// This method closes the database
+func (store *Store) Close() error {
+	return store.db.Close()
+}
+
+// This method returns a copy of the key, the bbolt values are valid only during the transaction
+func (store *Store) ReadKey(name string) ([]byte, error) {
+	var key []byte
+	err := store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(keysBucket).Get([]byte(name))
+		if value == nil {
+			return cryptoengine.KeyNotFoundError
+		}
+		key = append([]byte{}, value...)
+		return nil
+	})
+	return key, err
+}
+
+// This method stores the key, unless it already exists
+func (store *Store) WriteKey(name string, data []byte) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(keysBucket)
+		if bucket.Get([]byte(name)) != nil {
+			return os.ErrExist
+		}
+		return bucket.Put([]byte(name), data)
+	})
+}
+
+// This method deletes the key
+func (store *Store) DeleteKey(name string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(keysBucket).Delete([]byte(name))
+	})
+}
+
+// This method reserves n counters for the context. The counters are stored as 8 bytes big endian integers.
+func (store *Store) ReserveCounters(context string, n uint64) (uint64, error) {
+	var first uint64
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(countersBucket)
+
+		if value := bucket.Get([]byte(context)); value != nil {
+			if len(value) != 8 {
+				return CounterFormatError
+			}
+			first = binary.BigEndian.Uint64(value)
+		}
+		if first > math.MaxUint64-n {
+			return cryptoengine.CounterOverflowError
+		}
+
+		var next [8]byte
+		binary.BigEndian.PutUint64(next[:], first+n)
+		return bucket.Put([]byte(context), next[:])
+	})
+	return first, err
+}
@@ -0,0 +1,101 @@
+package boltstore
+
+import (
+	"bytes"
+	"github.com/sec51/cryptoengine"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// make sure the store implements the cryptoengine interfaces
+var (
+	_ cryptoengine.KeyStore     = &Store{}
+	_ cryptoengine.CounterStore = &Store{}
+)
+
+func TestStore(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	store, err := Open(filepath.Join(directory, "keys.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	config := cryptoengine.Config{KeyStore: store, CounterStore: store}
+	engine, err := cryptoengine.InitCryptoEngineWithConfig("Sec51Bolt", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := store.ReadKey("sec51bolt_public.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(publicKey, engine.PublicKey()) {
+		t.Fatal("The key has not been stored in the database")
+	}
+
+	// the keys are loaded back
+	reloaded, err := cryptoengine.InitCryptoEngineWithConfig("Sec51Bolt", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reloaded.PublicKey(), engine.PublicKey()) {
+		t.Fatal("The keys have not been loaded from the database")
+	}
+
+	if err := store.WriteKey("sec51bolt_public.key", make([]byte, 32)); err != os.ErrExist {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", os.ErrExist, err)
+	}
+
+	if err := store.DeleteKey("sec51bolt_public.key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadKey("sec51bolt_public.key"); err != cryptoengine.KeyNotFoundError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", cryptoengine.KeyNotFoundError, err)
+	}
+}
+
+func TestStoreCounters(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	store, err := Open(filepath.Join(directory, "counters.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	first, err := store.ReserveCounters("sec51bolt", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.ReserveCounters("sec51bolt", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.ReserveCounters("other", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != 0 || second != 1024 || other != 0 {
+		t.Fatal("The counters have not been reserved correctly")
+	}
+
+	if _, err := store.ReserveCounters("sec51bolt", ^uint64(0)); err != cryptoengine.CounterOverflowError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", cryptoengine.CounterOverflowError, err)
+	}
+}
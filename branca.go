@@ -0,0 +1,126 @@
+package cryptoengine
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Branca tokens (https://branca.io): compact, URL safe and timestamped encrypted tokens,
+// sealed with IETF XChaCha20-Poly1305 under a key derived from the engine secret key.
+// A token is the base62 encoding of: version (0xBA)|timestamp (4 bytes)|nonce (24 bytes)|ciphertext|tag (16 bytes)
+// The header, the first 29 bytes, is the additional data of the AEAD.
+
+const (
+	brancaSubKeyLabel = "branca"
+	brancaVersion     = 0xBA
+	brancaHeaderSize  = 1 + 4 + chacha20poly1305.NonceSizeX
+)
+
+var (
+	BrancaError        = errors.New("The branca token is not valid")
+	BrancaExpiredError = errors.New("The branca token has expired")
+)
+
+// This method encrypts the payload into a branca token, timestamped with the current time
+func (engine *CryptoEngine) EncryptBranca(payload []byte) (string, error) {
+	if err := engine.allow(operationEncrypt); err != nil {
+		return "", err
+	}
+	key, err := engine.deriveSubKey(brancaSubKeyLabel)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return brancaEncode(key[:], payload, uint32(time.Now().Unix()), nonce)
+}
+
+// This method decrypts the branca token and returns the payload and the time the token has been created at.
+// When ttl is not zero, the tokens older than ttl are rejected with BrancaExpiredError.
+func (engine *CryptoEngine) DecryptBranca(token string, ttl time.Duration) ([]byte, time.Time, error) {
+	key, err := engine.deriveSubKey(brancaSubKeyLabel)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	payload, timestamp, err := brancaDecode(key[:], token)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	created := time.Unix(int64(timestamp), 0)
+	if ttl != 0 && time.Now().After(created.Add(ttl)) {
+		return nil, time.Time{}, BrancaExpiredError
+	}
+	return payload, created, nil
+}
+
+func brancaEncode(key, payload []byte, timestamp uint32, nonce []byte) (string, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+
+	token := make([]byte, brancaHeaderSize, brancaHeaderSize+len(payload)+chacha20poly1305.Overhead)
+	token[0] = brancaVersion
+	binary.BigEndian.PutUint32(token[1:5], timestamp)
+	copy(token[5:], nonce)
+
+	token = aead.Seal(token, nonce, payload, token[:brancaHeaderSize])
+	return base62Encode(token), nil
+}
+
+func brancaDecode(key []byte, token string) ([]byte, uint32, error) {
+	data, ok := base62Decode(token)
+	if !ok || len(data) < brancaHeaderSize+chacha20poly1305.Overhead || data[0] != brancaVersion {
+		return nil, 0, BrancaError
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	payload, err := aead.Open(nil, data[5:brancaHeaderSize], data[brancaHeaderSize:], data[:brancaHeaderSize])
+	if err != nil {
+		return nil, 0, BrancaError
+	}
+	return payload, binary.BigEndian.Uint32(data[1:5]), nil
+}
+
+// the big package uses the 0-9a-zA-Z alphabet, branca 0-9A-Za-z: swapping the case of the letters converts between the two
+func swapCase(r rune) rune {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return r - 'a' + 'A'
+	case r >= 'A' && r <= 'Z':
+		return r - 'A' + 'a'
+	}
+	return r
+}
+
+// the tokens always start with the version byte, so there are no leading zeros to preserve
+func base62Encode(data []byte) string {
+	return strings.Map(swapCase, new(big.Int).SetBytes(data).Text(62))
+}
+
+func base62Decode(encoded string) ([]byte, bool) {
+	for _, r := range encoded {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return nil, false
+		}
+	}
+	number, ok := new(big.Int).SetString(strings.Map(swapCase, encoded), 62)
+	if !ok {
+		return nil, false
+	}
+	return number.Bytes(), true
+}
@@ -0,0 +1,101 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// test vectors of the branca specification
+var brancaTestVectors = []struct {
+	token     string
+	timestamp uint32
+	nonce     string
+	payload   string
+}{
+	{"870S4BYxgHw0KnP3W9fgVUHEhT5g86vJ17etaC5Kh5uIraWHCI1psNQGv298ZmjPwoYbjDQ9chy2z", 0, "beefbeefbeefbeefbeefbeefbeefbeefbeefbeefbeefbeef", "Hello world!"},
+	{"875GH233T7IYrxtgXxlQBYiFobZMQdHAT51vChKsAIYCFxZtL1evV54vYqLyZtQ0ekPHt8kJHQp0a", 123206400, "", "Hello world!"},
+}
+
+func TestBrancaTestVectors(t *testing.T) {
+
+	key := []byte("supersecretkeyyoushouldnotcommit")
+
+	for _, vector := range brancaTestVectors {
+		payload, timestamp, err := brancaDecode(key, vector.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(payload) != vector.payload || timestamp != vector.timestamp {
+			t.Fatalf("The token %s has not been decoded correctly\n", vector.token)
+		}
+
+		if vector.nonce == "" {
+			continue
+		}
+		nonce, _ := hex.DecodeString(vector.nonce)
+		token, err := brancaEncode(key, []byte(vector.payload), vector.timestamp, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != vector.token {
+			t.Fatalf("The expected token is: %s, instead we've got: %s\n", vector.token, token)
+		}
+	}
+}
+
+func TestBranca(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := engine.EncryptBranca([]byte("user=alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, created, err := engine.DecryptBranca(token, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload, []byte("user=alice")) {
+		t.Fatal("The decrypted payload does not match the original one")
+	}
+	if time.Since(created) > time.Minute {
+		t.Fatal("The token timestamp is not valid")
+	}
+
+	// expired tokens
+	key, err := engine.deriveSubKey(brancaSubKeyLabel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := brancaEncode(key[:], []byte("user=alice"), uint32(time.Now().Add(-time.Hour).Unix()), make([]byte, 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := engine.DecryptBranca(old, time.Minute); err != BrancaExpiredError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", BrancaExpiredError, err)
+	}
+	if _, _, err := engine.DecryptBranca(old, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered tokens
+	tampered := []byte(token)
+	if tampered[len(tampered)-1] == '0' {
+		tampered[len(tampered)-1] = '1'
+	} else {
+		tampered[len(tampered)-1] = '0'
+	}
+	if _, _, err := engine.DecryptBranca(string(tampered), 0); err != BrancaError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", BrancaError, err)
+	}
+
+	if _, _, err := engine.DecryptBranca("not-base62", 0); err != BrancaError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", BrancaError, err)
+	}
+}
@@ -0,0 +1,235 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Minimal CBOR (RFC 8949) support: only the types needed by the COSE structures.
+// Integers are decoded as int64, byte strings as []byte, text strings as string, arrays as []interface{},
+// maps as map[interface{}]interface{} and tags as cborTag. Indefinite lengths and floats are not supported.
+
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborTagType  = 6
+	cborSimple   = 7
+
+	cborFalse = 20
+	cborTrue  = 21
+	cborNull  = 22
+
+	cborMaxDepth = 16 // nested structures deeper than this are rejected
+)
+
+var (
+	CBORError = errors.New("The CBOR data is not valid")
+)
+
+// a tagged CBOR value
+type cborTag struct {
+	number uint64
+	value  interface{}
+}
+
+// a CBOR map with its keys in the order they have to be encoded
+type cborPairs []interface{}
+
+// encodes the value, supported types: int, int64, uint64, []byte, string, []interface{}, cborPairs, cborTag, bool and nil
+func cborEncode(value interface{}) []byte {
+	var buffer bytes.Buffer
+	cborWrite(&buffer, value)
+	return buffer.Bytes()
+}
+
+func cborWrite(buffer *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		buffer.WriteByte(cborSimple<<5 | cborNull)
+	case bool:
+		if v {
+			buffer.WriteByte(cborSimple<<5 | cborTrue)
+		} else {
+			buffer.WriteByte(cborSimple<<5 | cborFalse)
+		}
+	case int:
+		cborWrite(buffer, int64(v))
+	case int64:
+		if v >= 0 {
+			cborWriteHead(buffer, cborUnsigned, uint64(v))
+		} else {
+			cborWriteHead(buffer, cborNegative, uint64(-1-v))
+		}
+	case uint64:
+		cborWriteHead(buffer, cborUnsigned, v)
+	case []byte:
+		cborWriteHead(buffer, cborBytes, uint64(len(v)))
+		buffer.Write(v)
+	case string:
+		cborWriteHead(buffer, cborText, uint64(len(v)))
+		buffer.WriteString(v)
+	case []interface{}:
+		cborWriteHead(buffer, cborArray, uint64(len(v)))
+		for _, item := range v {
+			cborWrite(buffer, item)
+		}
+	case cborPairs:
+		cborWriteHead(buffer, cborMap, uint64(len(v)/2))
+		for _, item := range v {
+			cborWrite(buffer, item)
+		}
+	case cborTag:
+		cborWriteHead(buffer, cborTagType, v.number)
+		cborWrite(buffer, v.value)
+	default:
+		panic("cbor: unsupported type")
+	}
+}
+
+// writes the major type and the argument with the shortest encoding
+func cborWriteHead(buffer *bytes.Buffer, major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		buffer.WriteByte(major | byte(n))
+	case n <= 0xff:
+		buffer.WriteByte(major | 24)
+		buffer.WriteByte(byte(n))
+	case n <= 0xffff:
+		buffer.WriteByte(major | 25)
+		binary.Write(buffer, binary.BigEndian, uint16(n))
+	case n <= 0xffffffff:
+		buffer.WriteByte(major | 26)
+		binary.Write(buffer, binary.BigEndian, uint32(n))
+	default:
+		buffer.WriteByte(major | 27)
+		binary.Write(buffer, binary.BigEndian, n)
+	}
+}
+
+// decodes exactly one CBOR item, trailing bytes are an error
+func cborDecode(data []byte) (interface{}, error) {
+	value, rest, err := cborRead(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, CBORError
+	}
+	return value, nil
+}
+
+func cborRead(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth || len(data) == 0 {
+		return nil, nil, CBORError
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	// simple values
+	if major == cborSimple {
+		switch info {
+		case cborFalse:
+			return false, data, nil
+		case cborTrue:
+			return true, data, nil
+		case cborNull:
+			return nil, data, nil
+		}
+		return nil, nil, CBORError
+	}
+
+	// the argument
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info == 24 && len(data) >= 1:
+		n, data = uint64(data[0]), data[1:]
+	case info == 25 && len(data) >= 2:
+		n, data = uint64(binary.BigEndian.Uint16(data)), data[2:]
+	case info == 26 && len(data) >= 4:
+		n, data = uint64(binary.BigEndian.Uint32(data)), data[4:]
+	case info == 27 && len(data) >= 8:
+		n, data = binary.BigEndian.Uint64(data), data[8:]
+	default:
+		return nil, nil, CBORError
+	}
+
+	switch major {
+	case cborUnsigned:
+		if n > 1<<63-1 {
+			return nil, nil, CBORError
+		}
+		return int64(n), data, nil
+	case cborNegative:
+		if n > 1<<63-1 {
+			return nil, nil, CBORError
+		}
+		return -1 - int64(n), data, nil
+	case cborBytes, cborText:
+		if n > uint64(len(data)) {
+			return nil, nil, CBORError
+		}
+		if major == cborText {
+			return string(data[:n]), data[n:], nil
+		}
+		return append([]byte{}, data[:n]...), data[n:], nil
+	case cborArray:
+		// each item takes at least one byte, this prevents huge allocations
+		if n > uint64(len(data)) {
+			return nil, nil, CBORError
+		}
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var item interface{}
+			var err error
+			if item, data, err = cborRead(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case cborMap:
+		if n > uint64(len(data))/2 {
+			return nil, nil, CBORError
+		}
+		pairs := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var key, value interface{}
+			var err error
+			if key, data, err = cborRead(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			// only integers and text strings can be used as keys
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, CBORError
+			}
+			if value, data, err = cborRead(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			if _, duplicated := pairs[key]; duplicated {
+				return nil, nil, CBORError
+			}
+			pairs[key] = value
+		}
+		return pairs, data, nil
+	case cborTagType:
+		value, rest, err := cborRead(data, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cborTag{number: n, value: value}, rest, nil
+	}
+
+	return nil, nil, CBORError
+}
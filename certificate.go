@@ -0,0 +1,157 @@
+package cryptoengine
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// X.509 certificates binding the peer keys to an identity.
+// The engine acts as an internal CA: the certificate subject key is the peer Ed25519 signing key
+// and the X25519 encryption key travels in a non critical extension, so the CA signature covers both.
+// Certificates are DER encoded.
+
+var (
+	CertificateError      = errors.New("The certificate is not valid")
+	CertificateChainError = errors.New("The certificate chain could not be verified")
+
+	// the extension is identified by the X25519 algorithm identifier (RFC 8410)
+	certificateX25519Extension = asn1.ObjectIdentifier{1, 3, 101, 110}
+)
+
+// This method returns the self-signed CA certificate of the engine, valid from now for the given duration.
+// Peers add it to their roots in order to validate the certificates issued with IssueCertificate.
+func (engine *CryptoEngine) CACertificate(validity time.Duration) ([]byte, error) {
+	template, err := engine.caTemplate(validity)
+	if err != nil {
+		return nil, err
+	}
+	return x509.CreateCertificate(rand.Reader, template, template, engine.signingKey.Public(), engine.signingKey)
+}
+
+// This method issues a certificate for the peer keys held by the verification engine, signed by the engine CA.
+// The verification engine needs both the public key and the signing public key.
+func (engine *CryptoEngine) IssueCertificate(commonName string, verificationEngine VerificationEngine, validity time.Duration) ([]byte, error) {
+
+	publicKey := verificationEngine.PublicKey()
+	signingPublicKey := verificationEngine.SigningPublicKey()
+	if ConstantTimeEqual(publicKey[:], emptyKey) || ConstantTimeEqual(signingPublicKey[:], emptyKey) {
+		return nil, KeyNotValidError
+	}
+
+	parent, err := engine.caTemplate(validity)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := certificateSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	extension, err := asn1.Marshal(publicKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:    serialNumber,
+		Subject:         pkix.Name{CommonName: commonName},
+		NotBefore:       parent.NotBefore,
+		NotAfter:        parent.NotAfter,
+		KeyUsage:        x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+		SubjectKeyId:    certificateKeyId(signingPublicKey[:]),
+		ExtraExtensions: []pkix.Extension{{Id: certificateX25519Extension, Value: extension}},
+	}
+
+	return x509.CreateCertificate(rand.Reader, template, parent, ed25519.PublicKey(signingPublicKey[:]), engine.signingKey)
+}
+
+// This function validates the certificate chain against the roots and returns the verification engine of the peer keys the certificate holds.
+// The intermediates are optional DER encoded CA certificates.
+func NewVerificationEngineWithCertificate(certificate []byte, roots *x509.CertPool, intermediates ...[]byte) (VerificationEngine, error) {
+
+	engine := VerificationEngine{}
+
+	leaf, err := x509.ParseCertificate(certificate)
+	if err != nil {
+		return engine, CertificateError
+	}
+
+	intermediatesPool := x509.NewCertPool()
+	for _, data := range intermediates {
+		intermediate, err := x509.ParseCertificate(data)
+		if err != nil {
+			return engine, CertificateError
+		}
+		intermediatesPool.AddCert(intermediate)
+	}
+
+	options := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediatesPool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := leaf.Verify(options); err != nil {
+		return engine, CertificateChainError
+	}
+
+	// extract the keys
+	signingPublicKey, ok := leaf.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return engine, CertificateError
+	}
+
+	var publicKey []byte
+	for _, extension := range leaf.Extensions {
+		if !extension.Id.Equal(certificateX25519Extension) {
+			continue
+		}
+		if rest, err := asn1.Unmarshal(extension.Value, &publicKey); err != nil || len(rest) != 0 {
+			return engine, CertificateError
+		}
+	}
+
+	if len(publicKey) != keySize {
+		return engine, CertificateError
+	}
+
+	return NewVerificationEngineWithKeys(publicKey, signingPublicKey)
+}
+
+// the CA template: the subject is the engine context, so it's the same for every certificate the engine issues
+func (engine *CryptoEngine) caTemplate(validity time.Duration) (*x509.Certificate, error) {
+	serialNumber, err := certificateSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: engine.context},
+		NotBefore:             now,
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          certificateKeyId(engine.SigningPublicKey()),
+	}, nil
+}
+
+// random 128 bits serial number
+func certificateSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// the key identifier is the SHA-1 hash of the public key (RFC 5280 section 4.2.1.2)
+func certificateKeyId(publicKey []byte) []byte {
+	hash := sha1.Sum(publicKey)
+	return hash[:]
+}
@@ -0,0 +1,157 @@
+package cryptoengine
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestCertificate(t *testing.T) {
+
+	ca, err := InitCryptoEngine("Sec51CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer, err := InitCryptoEngine("Sec51CertificatePeer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender, err := InitCryptoEngine("Sec51CertificateSender")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerVerificationEngine, err := NewVerificationEngine("Sec51CertificatePeer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	senderVerificationEngine, err := NewVerificationEngine("Sec51CertificateSender")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCertificate, err := ca.CACertificate(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := ca.IssueCertificate("peer.sec51.com", peerVerificationEngine, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := x509.ParseCertificate(caCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	verificationEngine, err := NewVerificationEngineWithCertificate(certificate, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if verificationEngine.PublicKey() != peerVerificationEngine.PublicKey() {
+		t.Fatal("The certificate public key is not the peer public key")
+	}
+
+	if verificationEngine.SigningPublicKey() != peerVerificationEngine.SigningPublicKey() {
+		t.Fatal("The certificate signing public key is not the peer signing public key")
+	}
+
+	// encrypt for the peer with the key extracted from the certificate
+	message, err := NewMessage("The quick brown fox jumps over the lazy dog", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedMessage, err := sender.NewEncryptedMessageWithPubKey(message, verificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messageBytes, err := encryptedMessage.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := peer.DecryptWithPublicKey(messageBytes, senderVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decrypted.Text != message.Text {
+		t.Fatal("The decrypted message is not the original one")
+	}
+
+	// the certificate signature verifies with the extracted signing key
+	if err := verificationEngine.Verify([]byte("data"), peer.Sign([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestCertificateErrors(t *testing.T) {
+
+	ca, err := InitCryptoEngine("Sec51CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherCA, err := InitCryptoEngine("Sec51OtherCA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerVerificationEngine, err := NewVerificationEngine("Sec51CertificatePeer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherCertificate, err := otherCA.CACertificate(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherRoot, err := x509.ParseCertificate(otherCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(otherRoot)
+
+	// issued by a different CA
+	certificate, err := ca.IssueCertificate("peer.sec51.com", peerVerificationEngine, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewVerificationEngineWithCertificate(certificate, roots); err != CertificateChainError {
+		t.Errorf("The expected error is: CertificateChainError, instead we've got: %v\n", err)
+	}
+
+	// expired
+	expired, err := otherCA.IssueCertificate("peer.sec51.com", peerVerificationEngine, -time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewVerificationEngineWithCertificate(expired, roots); err != CertificateChainError {
+		t.Errorf("The expected error is: CertificateChainError, instead we've got: %v\n", err)
+	}
+
+	// not a certificate
+	if _, err := NewVerificationEngineWithCertificate([]byte("garbage"), roots); err != CertificateError {
+		t.Errorf("The expected error is: CertificateError, instead we've got: %v\n", err)
+	}
+
+	// the peer keys are required
+	if _, err := ca.IssueCertificate("peer.sec51.com", VerificationEngine{}, time.Hour); err != KeyNotValidError {
+		t.Errorf("The expected error is: KeyNotValidError, instead we've got: %v\n", err)
+	}
+
+}
@@ -0,0 +1,139 @@
+package cryptoengine
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"time"
+)
+
+// Challenge-response proof of possession of the engine private key.
+// The server sends a challenge, the client proves it holds the private key of the public key it claims
+// with a MAC keyed by the X25519 shared secret of the two engines, and the server verifies it.
+// The challenges are stateless: they are authenticated with a key derived from the server secret key and expire after challengeValidity.
+// A server which needs to prevent the replay of a proof within the validity window has to remember the challenges already used.
+
+const (
+	challengeSubKeyLabel = "challenge"
+	challengeProofLabel  = "cryptoengine proof of identity"
+	challengeRandomSize  = 32
+	challengeSize        = challengeRandomSize + 8 + sha256.Size
+	challengeValidity    = time.Minute
+)
+
+var (
+	ChallengeError        = errors.New("The challenge is not valid")
+	ChallengeExpiredError = errors.New("The challenge has expired")
+	ProofError            = errors.New("The proof of identity is not valid")
+)
+
+// This method generates a challenge to send to a peer which claims a public key
+func (engine *CryptoEngine) GenerateChallenge() ([]byte, error) {
+	return engine.generateChallenge(time.Now())
+}
+
+// This method answers the challenge of the verifier, proving the engine holds the private key of its public key
+func (engine *CryptoEngine) ProveIdentity(challenge []byte, verifier VerificationEngine) ([]byte, error) {
+	if len(challenge) != challengeSize {
+		return nil, ChallengeError
+	}
+
+	verifierPublicKey := verifier.PublicKey()
+	return proofOfIdentity(engine.privateKey, verifierPublicKey, engine.publicKey, verifierPublicKey, challenge)
+}
+
+// This method verifies the proof of the peer, which claims the public key of the prover verification engine.
+// The challenge must have been generated by this engine and must not be expired.
+func (engine *CryptoEngine) VerifyProof(challenge, proof []byte, prover VerificationEngine) error {
+	if err := engine.checkChallenge(challenge, time.Now()); err != nil {
+		return err
+	}
+
+	proverPublicKey := prover.PublicKey()
+	expected, err := proofOfIdentity(engine.privateKey, proverPublicKey, proverPublicKey, engine.publicKey, challenge)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(expected, proof) != 1 {
+		return ProofError
+	}
+	return nil
+}
+
+// challenge: random|timestamp|mac
+func (engine *CryptoEngine) generateChallenge(now time.Time) ([]byte, error) {
+	challenge := make([]byte, challengeRandomSize+8, challengeSize)
+	if _, err := rand.Read(challenge[:challengeRandomSize]); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint64(challenge[challengeRandomSize:], uint64(now.Unix()))
+
+	mac, err := engine.challengeMac(challenge)
+	if err != nil {
+		return nil, err
+	}
+	return append(challenge, mac...), nil
+}
+
+// checks the challenge has been generated by the engine and is still valid
+func (engine *CryptoEngine) checkChallenge(challenge []byte, now time.Time) error {
+	if len(challenge) != challengeSize {
+		return ChallengeError
+	}
+
+	mac, err := engine.challengeMac(challenge[:challengeRandomSize+8])
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, challenge[challengeRandomSize+8:]) {
+		return ChallengeError
+	}
+
+	issued := time.Unix(int64(binary.BigEndian.Uint64(challenge[challengeRandomSize:])), 0)
+	if now.Before(issued.Add(-challengeValidity)) || now.After(issued.Add(challengeValidity)) {
+		return ChallengeExpiredError
+	}
+	return nil
+}
+
+func (engine *CryptoEngine) challengeMac(data []byte) ([]byte, error) {
+	key, err := engine.deriveSubKey(challengeSubKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// the proof is the MAC of the challenge and of both public keys, keyed by the X25519 shared secret
+// it's computed by both parties: with their own private key and the public key of the other one
+func proofOfIdentity(privateKey, peerPublicKey, proverPublicKey, verifierPublicKey [keySize]byte, challenge []byte) ([]byte, error) {
+	if ConstantTimeEqual(proverPublicKey[:], emptyKey) || ConstantTimeEqual(verifierPublicKey[:], emptyKey) {
+		return nil, KeyNotValidError
+	}
+
+	// X25519 fails on low order points
+	sharedSecret, err := curve25519.X25519(privateKey[:], peerPublicKey[:])
+	if err != nil {
+		return nil, KeyNotValidError
+	}
+
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, nil, []byte(challengeProofLabel)), key); err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(challenge)
+	mac.Write(proverPublicKey[:])
+	mac.Write(verifierPublicKey[:])
+	return mac.Sum(nil), nil
+}
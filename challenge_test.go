@@ -0,0 +1,89 @@
+package cryptoengine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChallengeResponse(t *testing.T) {
+
+	server, err := InitCryptoEngine("Sec51ChallengeServer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := InitCryptoEngine("Sec51ChallengeClient")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := InitCryptoEngine("Sec51ChallengeOther")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverVerificationEngine, err := NewVerificationEngine("Sec51ChallengeServer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientVerificationEngine, err := NewVerificationEngine("Sec51ChallengeClient")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge, err := server.GenerateChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := client.ProveIdentity(challenge, serverVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.VerifyProof(challenge, proof, clientVerificationEngine); err != nil {
+		t.Fatal(err)
+	}
+
+	// another engine cannot claim the client public key
+	forged, err := other.ProveIdentity(challenge, serverVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.VerifyProof(challenge, forged, clientVerificationEngine); err != ProofError {
+		t.Errorf("The expected error is: ProofError, instead we've got: %v\n", err)
+	}
+
+	// the challenge must come from the server
+	challenge, err = other.GenerateChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err = client.ProveIdentity(challenge, serverVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.VerifyProof(challenge, proof, clientVerificationEngine); err != ChallengeError {
+		t.Errorf("The expected error is: ChallengeError, instead we've got: %v\n", err)
+	}
+
+	// expired challenge
+	challenge, err = server.generateChallenge(time.Now().Add(-2 * challengeValidity))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err = client.ProveIdentity(challenge, serverVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.VerifyProof(challenge, proof, clientVerificationEngine); err != ChallengeExpiredError {
+		t.Errorf("The expected error is: ChallengeExpiredError, instead we've got: %v\n", err)
+	}
+
+}
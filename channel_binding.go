@@ -0,0 +1,33 @@
+package cryptoengine
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+// Channel binding to an outer TLS connection (tls-exporter, RFC 9266).
+// Both ends of the same TLS session derive the same value, while a proxy which terminates and re-establishes TLS
+// leaves the two ends with different values, so a handshake bound to it fails.
+
+const (
+	tlsExporterLabel = "EXPORTER-Channel-Binding"
+	tlsExporterSize  = 32
+)
+
+var (
+	ChannelBindingError = errors.New("The TLS connection does not support the tls-exporter channel binding")
+)
+
+// This function returns the tls-exporter channel binding of the TLS connection state.
+// Only TLS 1.3 is supported: with older versions the exporter is not unique to the session.
+func TLSChannelBinding(state tls.ConnectionState) ([]byte, error) {
+	if !state.HandshakeComplete || state.Version != tls.VersionTLS13 {
+		return nil, ChannelBindingError
+	}
+
+	binding, err := state.ExportKeyingMaterial(tlsExporterLabel, nil, tlsExporterSize)
+	if err != nil {
+		return nil, ChannelBindingError
+	}
+	return binding, nil
+}
@@ -0,0 +1,152 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// returns the connection states of both ends of a TLS connection with the given maximum version
+func testTLSConnectionStates(t *testing.T, maxVersion uint16) (tls.ConnectionState, tls.ConnectionState) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sec51.com"},
+		DNSNames:     []string{"sec51.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	server := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{certificate}, PrivateKey: key}},
+		MaxVersion:   maxVersion,
+	})
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true, MaxVersion: maxVersion})
+	// closing the TLS connections would block on the close_notify alerts, nobody reads the pipe
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Handshake()
+	}()
+
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	return client.ConnectionState(), server.ConnectionState()
+}
+
+func TestTLSChannelBinding(t *testing.T) {
+
+	clientState, serverState := testTLSConnectionStates(t, tls.VersionTLS13)
+
+	clientBinding, err := TLSChannelBinding(clientState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverBinding, err := TLSChannelBinding(serverState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(clientBinding, serverBinding) {
+		t.Fatal("The channel bindings of the two ends do not match")
+	}
+
+	// another TLS session, as seen behind a TLS terminating proxy
+	_, proxyState := testTLSConnectionStates(t, tls.VersionTLS13)
+	proxyBinding, err := TLSChannelBinding(proxyState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, err := InitCryptoEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := InitCryptoEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceVerificationEngine, err := NewVerificationEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bobVerificationEngine, err := NewVerificationEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// same TLS session
+	initiator, hello, err := alice.InitiateHandshakeWithBinding(bobVerificationEngine, clientBinding)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responder, keyShare, err := bob.RespondHandshakeWithBinding(aliceVerificationEngine, serverBinding, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	confirm, err := initiator.Finish(keyShare)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := responder.Confirm(confirm); err != nil {
+		t.Fatal(err)
+	}
+
+	// different TLS sessions
+	initiator, hello, err = alice.InitiateHandshakeWithBinding(bobVerificationEngine, clientBinding)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, keyShare, err = bob.RespondHandshakeWithBinding(aliceVerificationEngine, proxyBinding, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := initiator.Finish(keyShare); err != SignatureError {
+		t.Errorf("The expected error is: SignatureError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestTLSChannelBindingVersion(t *testing.T) {
+
+	clientState, _ := testTLSConnectionStates(t, tls.VersionTLS12)
+
+	if _, err := TLSChannelBinding(clientState); err != ChannelBindingError {
+		t.Errorf("The expected error is: ChannelBindingError, instead we've got: %v\n", err)
+	}
+
+}
@@ -0,0 +1,63 @@
+// The cryptoengine-config command encrypts and decrypts configuration files with the secret key of a cryptoengine identifier,
+// in the format read by CryptoEngine.LoadEncryptedConfig.
+//
+//	cryptoengine-config -id <identifier> encrypt config.yaml > config.yaml.enc
+//	cryptoengine-config -id <identifier> decrypt config.yaml.enc
+//
+// The keys are read from the folder set in the SEC51_KEYPATH environment variable, ./keys by default.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sec51/cryptoengine"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	identifier := flag.String("id", "", "the communication identifier of the engine whose secret key is used")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s -id <identifier> encrypt|decrypt <file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *identifier == "" || flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*identifier, flag.Arg(0), flag.Arg(1)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(identifier, command, path string) error {
+	engine, err := cryptoengine.InitCryptoEngine(identifier)
+	if err != nil {
+		return err
+	}
+
+	input, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var output []byte
+	switch command {
+	case "encrypt":
+		output, err = engine.EncryptConfig(input)
+	case "decrypt":
+		output, err = engine.DecryptConfig(input)
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(output)
+	return err
+}
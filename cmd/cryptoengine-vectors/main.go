@@ -0,0 +1,27 @@
+// The cryptoengine-vectors command prints the cross implementation test vectors as JSON,
+// the content of testdata/vectors.json.
+//
+//	cryptoengine-vectors > testdata/vectors.json
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sec51/cryptoengine"
+	"os"
+)
+
+func main() {
+	vectors, err := cryptoengine.GenerateTestVectors()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(vectors); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
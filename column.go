@@ -0,0 +1,270 @@
+package cryptoengine
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Transparent column encryption for database/sql: EncryptedString and EncryptedBytes implement driver.Valuer and sql.Scanner,
+// so they encrypt on write and decrypt on read when used as query arguments and scan destinations.
+// They can be used as well as field types by ORMs which support them, for instance with ent: field.String("email").GoType(cryptoengine.EncryptedString{})
+// The values are sealed with the secret key of the engine registered under their Context name; the empty name is the default engine.
+// Several versions of the engine can be registered under the same name to rotate the keys:
+// the values are written with the newest version, which is stored with them, and read with the version they have been written with.
+// EncryptedString is stored as an armored string (see EncryptArmored) and EncryptedBytes as the raw encrypted message bytes.
+// Values written with a key version other than zero are stored as: ce1:<version>:<base64 encrypted message>
+// Empty values are stored as they are.
+//
+//	RegisterColumnEngine("", engine)
+//	db.Exec("INSERT INTO users (email) VALUES (?)", EncryptedString{String: email, Valid: true})
+//	var email EncryptedString
+//	db.QueryRow("SELECT email FROM users").Scan(&email)
+
+var (
+	ColumnEngineError = errors.New("The column engine is not registered")
+	ColumnTypeError   = errors.New("The column value type is not valid")
+
+	columnEngines      = make(map[string]map[uint32]*CryptoEngine)
+	columnEnginesMutex sync.RWMutex
+)
+
+// This function registers the engine used by the encrypted columns with the given context name, with key version zero.
+// A nil engine removes the registration.
+func RegisterColumnEngine(name string, engine *CryptoEngine) {
+	RegisterColumnEngineVersion(name, 0, engine)
+}
+
+// This function registers a version of the engine used by the encrypted columns with the given context name.
+// New values are encrypted with the highest registered version. A nil engine removes the registration of the version.
+func RegisterColumnEngineVersion(name string, version uint32, engine *CryptoEngine) {
+	columnEnginesMutex.Lock()
+	defer columnEnginesMutex.Unlock()
+
+	versions := columnEngines[name]
+	if engine == nil {
+		delete(versions, version)
+		if len(versions) == 0 {
+			delete(columnEngines, name)
+		}
+		return
+	}
+
+	if versions == nil {
+		versions = make(map[uint32]*CryptoEngine)
+		columnEngines[name] = versions
+	}
+	versions[version] = engine
+}
+
+// returns the newest engine registered with the context name and its version
+func currentColumnEngine(name string) (*CryptoEngine, uint32, error) {
+	columnEnginesMutex.RLock()
+	defer columnEnginesMutex.RUnlock()
+
+	var current *CryptoEngine
+	var currentVersion uint32
+	for version, engine := range columnEngines[name] {
+		if current == nil || version > currentVersion {
+			current, currentVersion = engine, version
+		}
+	}
+	if current == nil {
+		return nil, 0, ColumnEngineError
+	}
+	return current, currentVersion, nil
+}
+
+// returns the version of the engine registered with the context name
+func columnEngine(name string, version uint32) (*CryptoEngine, error) {
+	columnEnginesMutex.RLock()
+	defer columnEnginesMutex.RUnlock()
+	engine, ok := columnEngines[name][version]
+	if !ok {
+		return nil, ColumnEngineError
+	}
+	return engine, nil
+}
+
+// This function encrypts the data with the newest engine registered with the context name and returns the armored string
+func EncryptColumn(name string, data []byte) (string, error) {
+	engine, version, err := currentColumnEngine(name)
+	if err != nil {
+		return "", err
+	}
+
+	armored, err := engine.EncryptArmored(data)
+	if err != nil {
+		return "", err
+	}
+
+	if version != 0 {
+		armored = armoredPrefix + strconv.FormatUint(uint64(version), 10) + ":" + armored[len(armoredPrefix):]
+	}
+	return armored, nil
+}
+
+// This function decrypts an armored string returned by EncryptColumn and returns the data and the key version it has been encrypted with
+func DecryptColumn(name string, armored string) ([]byte, uint32, error) {
+	if !strings.HasPrefix(armored, armoredPrefix) {
+		return nil, 0, ArmoredError
+	}
+
+	// the base64 alphabet does not contain colons
+	var version uint32
+	if index := strings.IndexByte(armored[len(armoredPrefix):], ':'); index >= 0 {
+		parsed, err := strconv.ParseUint(armored[len(armoredPrefix):len(armoredPrefix)+index], 10, 32)
+		if err != nil {
+			return nil, 0, ArmoredError
+		}
+		version = uint32(parsed)
+		armored = armoredPrefix + armored[len(armoredPrefix)+index+1:]
+	}
+
+	engine, err := columnEngine(name, version)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, err := engine.DecryptArmored(armored)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, version, nil
+}
+
+// An encrypted nullable string column, like sql.NullString
+type EncryptedString struct {
+	String     string
+	Valid      bool   // Valid is true if String is not NULL
+	Context    string // the name of the registered engine, empty for the default one
+	KeyVersion uint32 // the key version the scanned value has been encrypted with
+}
+
+// This method encrypts the string, it implements the driver.Valuer interface
+func (s EncryptedString) Value() (driver.Value, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	if s.String == "" {
+		return "", nil
+	}
+	return EncryptColumn(s.Context, []byte(s.String))
+}
+
+// This method decrypts the column value, it implements the sql.Scanner interface
+func (s *EncryptedString) Scan(src interface{}) error {
+	s.String, s.Valid, s.KeyVersion = "", false, 0
+
+	var armored string
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case string:
+		armored = src
+	case []byte:
+		armored = string(src)
+	default:
+		return ColumnTypeError
+	}
+
+	if armored == "" {
+		s.Valid = true
+		return nil
+	}
+
+	clearText, version, err := DecryptColumn(s.Context, armored)
+	if err != nil {
+		return err
+	}
+	s.String, s.Valid, s.KeyVersion = string(clearText), true, version
+	return nil
+}
+
+// An encrypted binary column. A nil Bytes is NULL.
+type EncryptedBytes struct {
+	Bytes      []byte
+	Context    string // the name of the registered engine, empty for the default one
+	KeyVersion uint32 // the key version the scanned value has been encrypted with
+}
+
+// This method encrypts the bytes, it implements the driver.Valuer interface
+func (b EncryptedBytes) Value() (driver.Value, error) {
+	if b.Bytes == nil {
+		return nil, nil
+	}
+	if len(b.Bytes) == 0 {
+		return []byte{}, nil
+	}
+
+	engine, version, err := currentColumnEngine(b.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	// the key version is only stored in the armored form
+	if version != 0 {
+		armored, err := EncryptColumn(b.Context, b.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(armored), nil
+	}
+
+	msg, err := NewMessage(string(b.Bytes), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	return encrypted.ToBytes()
+}
+
+// This method decrypts the column value, it implements the sql.Scanner interface
+func (b *EncryptedBytes) Scan(src interface{}) error {
+	b.Bytes, b.KeyVersion = nil, 0
+
+	var encryptedBytes []byte
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		encryptedBytes = src
+	case string:
+		encryptedBytes = []byte(src)
+	default:
+		return ColumnTypeError
+	}
+
+	if len(encryptedBytes) == 0 {
+		b.Bytes = []byte{}
+		return nil
+	}
+
+	// a raw message cannot start with the armored prefix: its length field would exceed the default maximum message size
+	if strings.HasPrefix(string(encryptedBytes), armoredPrefix) {
+		data, version, err := DecryptColumn(b.Context, string(encryptedBytes))
+		if err != nil {
+			return err
+		}
+		b.Bytes, b.KeyVersion = data, version
+		return nil
+	}
+
+	engine, err := columnEngine(b.Context, 0)
+	if err != nil {
+		return err
+	}
+
+	msg, err := engine.Decrypt(encryptedBytes)
+	if err != nil {
+		return err
+	}
+	b.Bytes = []byte(msg.Text)
+	return nil
+}
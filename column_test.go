@@ -0,0 +1,158 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+// make sure the types implement the database/sql interfaces
+var (
+	_ driver.Valuer = EncryptedString{}
+	_ sql.Scanner   = &EncryptedString{}
+	_ driver.Valuer = EncryptedBytes{}
+	_ sql.Scanner   = &EncryptedBytes{}
+)
+
+func TestEncryptedColumns(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterColumnEngine("", engine)
+	RegisterColumnEngine("users", engine)
+	defer RegisterColumnEngine("", nil)
+	defer RegisterColumnEngine("users", nil)
+
+	// string column
+	value, err := EncryptedString{String: "alice@example.com", Valid: true, Context: "users"}.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value == "alice@example.com" {
+		t.Fatal("The column value has not been encrypted")
+	}
+
+	scanned := EncryptedString{Context: "users"}
+	if err := scanned.Scan([]byte(value.(string))); err != nil {
+		t.Fatal(err)
+	}
+	if !scanned.Valid || scanned.String != "alice@example.com" {
+		t.Fatal("The scanned string does not match the original one")
+	}
+
+	// NULL
+	if value, err := (EncryptedString{}).Value(); err != nil || value != nil {
+		t.Fatal("The invalid string is not NULL")
+	}
+	if err := scanned.Scan(nil); err != nil || scanned.Valid {
+		t.Fatal("The NULL column has not been scanned as invalid")
+	}
+
+	// binary column, with the default engine
+	value, err = EncryptedBytes{Bytes: []byte{0, 1, 2, 3}}.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scannedBytes EncryptedBytes
+	if err := scannedBytes.Scan(value); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(scannedBytes.Bytes, []byte{0, 1, 2, 3}) {
+		t.Fatal("The scanned bytes do not match the original ones")
+	}
+
+	// tampered values are rejected
+	tampered := value.([]byte)
+	tampered[len(tampered)-1] ^= 1
+	if err := scannedBytes.Scan(tampered); err != MessageDecryptionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageDecryptionError, err)
+	}
+
+	// the engine must be registered
+	if _, err := (EncryptedString{String: "secret", Valid: true, Context: "unknown"}).Value(); err != ColumnEngineError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ColumnEngineError, err)
+	}
+
+	if err := scannedBytes.Scan(42); err != ColumnTypeError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ColumnTypeError, err)
+	}
+}
+
+func TestEncryptedColumnsKeyVersions(t *testing.T) {
+
+	oldEngine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newEngine, err := InitCryptoEngine("Sec51Rotated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterColumnEngine("rotation", oldEngine)
+	defer RegisterColumnEngineVersion("rotation", 0, nil)
+
+	oldValue, err := EncryptedString{String: "alice@example.com", Valid: true, Context: "rotation"}.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldBytes, err := EncryptedBytes{Bytes: []byte("secret"), Context: "rotation"}.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate the key: new values are written with the new version, old ones are still readable
+	RegisterColumnEngineVersion("rotation", 2, newEngine)
+	defer RegisterColumnEngineVersion("rotation", 2, nil)
+
+	newValue, err := EncryptedString{String: "bob@example.com", Valid: true, Context: "rotation"}.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(newValue.(string), armoredPrefix+"2:") {
+		t.Fatal("The key version has not been stored with the value")
+	}
+
+	scanned := EncryptedString{Context: "rotation"}
+	if err := scanned.Scan(oldValue); err != nil {
+		t.Fatal(err)
+	}
+	if scanned.String != "alice@example.com" || scanned.KeyVersion != 0 {
+		t.Fatal("The value encrypted with the old key version has not been decrypted")
+	}
+
+	if err := scanned.Scan(newValue); err != nil {
+		t.Fatal(err)
+	}
+	if scanned.String != "bob@example.com" || scanned.KeyVersion != 2 {
+		t.Fatal("The value encrypted with the new key version has not been decrypted")
+	}
+
+	newBytes, err := EncryptedBytes{Bytes: []byte("secret"), Context: "rotation"}.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scannedBytes := EncryptedBytes{Context: "rotation"}
+	for version, value := range []driver.Value{oldBytes, newBytes} {
+		if err := scannedBytes.Scan(value); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(scannedBytes.Bytes, []byte("secret")) || scannedBytes.KeyVersion != uint32(version*2) {
+			t.Fatal("The scanned bytes do not match the original ones")
+		}
+	}
+
+	// once the old version is removed its values cannot be read anymore
+	RegisterColumnEngineVersion("rotation", 0, nil)
+	if err := scanned.Scan(oldValue); err != ColumnEngineError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ColumnEngineError, err)
+	}
+}
@@ -0,0 +1,82 @@
+package cryptoengine
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+)
+
+// Commit and reveal, for instance for sealed bid auctions or lotteries: a party publishes the commitment of a value first,
+// and reveals the value later. The commitment is hiding, it leaks nothing about the value thanks to a random nonce,
+// and binding, the party cannot reveal another value without finding a hash collision.
+// The commitments of an engine are bound to its signing public key as well, so another party cannot copy the commitment
+// and claim it once the value is revealed.
+// commitment: HashWithContext of |committer signing public key (zeros for anonymous commitments)|nonce|value|
+// reveal:     |nonce|value|
+
+const (
+	commitmentNonceSize = 32
+	commitmentLabel     = "cryptoengine commitment"
+)
+
+var (
+	CommitmentError = errors.New("The revealed value does not match the commitment")
+)
+
+// This function commits to the value and returns the commitment, to publish now, and the reveal, to publish later
+func Commit(value []byte) ([HashSize]byte, []byte, error) {
+	return commit([keySize]byte{}, value)
+}
+
+// This function verifies the reveal of an anonymous commitment and returns the committed value
+func VerifyCommitment(commitment [HashSize]byte, reveal []byte) ([]byte, error) {
+	return verifyCommitment([keySize]byte{}, commitment, reveal)
+}
+
+// This method commits to the value on behalf of the engine, see Commit
+func (engine *CryptoEngine) Commit(value []byte) ([HashSize]byte, []byte, error) {
+	if err := engine.allow(operationOwnKeys); err != nil {
+		return [HashSize]byte{}, nil, err
+	}
+	var committer [keySize]byte
+	copy(committer[:], engine.SigningPublicKey())
+	return commit(committer, value)
+}
+
+// This method verifies the reveal of a commitment made by the peer engine and returns the committed value
+func (e VerificationEngine) VerifyCommitment(commitment [HashSize]byte, reveal []byte) ([]byte, error) {
+	if e.signingPublicKey == [keySize]byte{} {
+		return nil, KeyNotValidError
+	}
+	return verifyCommitment(e.signingPublicKey, commitment, reveal)
+}
+
+func commit(committer [keySize]byte, value []byte) ([HashSize]byte, []byte, error) {
+	reveal := make([]byte, commitmentNonceSize, commitmentNonceSize+len(value))
+	if _, err := rand.Read(reveal); err != nil {
+		return [HashSize]byte{}, nil, err
+	}
+	reveal = append(reveal, value...)
+	return commitmentHash(committer, reveal), reveal, nil
+}
+
+func verifyCommitment(committer [keySize]byte, commitment [HashSize]byte, reveal []byte) ([]byte, error) {
+	if len(reveal) < commitmentNonceSize {
+		return nil, CommitmentError
+	}
+	expected := commitmentHash(committer, reveal)
+	if subtle.ConstantTimeCompare(expected[:], commitment[:]) != 1 {
+		return nil, CommitmentError
+	}
+	return reveal[commitmentNonceSize:], nil
+}
+
+func commitmentHash(committer [keySize]byte, reveal []byte) [HashSize]byte {
+	h := NewHashWithContext(commitmentLabel)
+	h.Write(committer[:])
+	h.Write(reveal)
+
+	var sum [HashSize]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
@@ -0,0 +1,79 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCommitment(t *testing.T) {
+
+	commitment, reveal, err := Commit([]byte("bid: 100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := VerifyCommitment(commitment, reveal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, []byte("bid: 100")) {
+		t.Fatalf("Expected the committed value, got %q\n", value)
+	}
+
+	// the same value gives different commitments
+	other, _, err := Commit([]byte("bid: 100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == commitment {
+		t.Fatal("The commitments should be hiding")
+	}
+
+	// another value cannot be revealed
+	tampered := append([]byte{}, reveal...)
+	tampered[len(tampered)-1] = '1'
+	if _, err := VerifyCommitment(commitment, tampered); err != CommitmentError {
+		t.Fatalf("Expected %v, got %v\n", CommitmentError, err)
+	}
+	if _, err := VerifyCommitment(commitment, reveal[:8]); err != CommitmentError {
+		t.Fatalf("Expected %v, got %v\n", CommitmentError, err)
+	}
+
+}
+
+func TestEngineCommitment(t *testing.T) {
+
+	alice, err := InitCryptoEngineWithConfig("Sec51CommitAlice", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := InitCryptoEngineWithConfig("Sec51CommitBob", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceVerificationEngine, err := NewVerificationEngineWithKeys(alice.PublicKey(), alice.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobVerificationEngine, err := NewVerificationEngineWithKeys(bob.PublicKey(), bob.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commitment, reveal, err := alice.Commit([]byte("ticket 42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := aliceVerificationEngine.VerifyCommitment(commitment, reveal); err != nil || string(value) != "ticket 42" {
+		t.Fatalf("The commitment of alice is not valid: %v\n", err)
+	}
+
+	// bob cannot claim the commitment of alice, and it's not an anonymous commitment
+	if _, err := bobVerificationEngine.VerifyCommitment(commitment, reveal); err != CommitmentError {
+		t.Fatalf("Expected %v, got %v\n", CommitmentError, err)
+	}
+	if _, err := VerifyCommitment(commitment, reveal); err != CommitmentError {
+		t.Fatalf("Expected %v, got %v\n", CommitmentError, err)
+	}
+
+}
@@ -0,0 +1,60 @@
+package cryptoengine
+
+import (
+	"time"
+)
+
+// The Config struct holds the optional settings of a CryptoEngine.
+// The zero value is valid and gives the same behaviour as InitCryptoEngine.
+type Config struct {
+	LegacyParsing  bool          // accept messages from older senders: the length field and the message version are not validated
+	MaxMessageSize uint64        // maximum size in bytes of a serialized encrypted message, both when encrypting and when parsing. Zero means 64MB
+	Parallelism    int           // amount of goroutines used to seal batches and chunks concurrently. Zero means GOMAXPROCS, one disables it
+	NoncePrefetch  int           // amount of nonces derived at once and used by the next encryptions, at most 340. Zero or one derives each nonce on its own
+	KeyStore       KeyStore      // where the keys are loaded from and generated into. Nil means the files of the keys folder
+	CounterStore   CounterStore  // where the nonce counters are reserved from. Nil means the counters are kept in memory and restart from zero
+	ReplayCache    ReplayCache   // where the nonces of the decrypted messages are remembered to reject the replayed ones. Nil disables the replay protection
+	ReplayWindow   time.Duration // how long the nonces are remembered. Zero means 24 hours
+	Role           Role          // what the engine is allowed to do with its keys. Zero means both encrypt and decrypt
+
+	StrictIdentifiers bool   // rejects the identifiers which are not valid or which collide with another identifier after the sanitization
+	ManifestKey       []byte // the machine or master secret of the key files manifest, which detects the modified key files. Nil disables the detection
+	MasterKey         []byte // the master key the keys are encrypted with before being stored, see EncryptedKeyStore. Nil stores the keys in clear
+
+	SaltRotation SaltRotationPolicy // when the nonce salt is rotated automatically, see SaltRotationPolicy. Zero means only with RotateSalt
+
+	NonceLimit             uint64             // the nonce counter value the engine stops encrypting at, see NonceExhaustedError. Zero means math.MaxUint64
+	NonceWarningThresholds []float64          // the increasing fractions of the NonceLimit which call NonceWarning. Nil means 50%, 75%, 90% and 99%
+	NonceWarning           func(NonceWarning) // called in its own goroutine when the nonce counter crosses a threshold. Nil disables the warnings
+}
+
+// The ParseOptions struct defines how strictly a message is parsed from bytes.
+// The zero value is the strict mode.
+type ParseOptions struct {
+	Legacy  bool   // lenient mode: skips the length consistency check and the version whitelist
+	MaxSize uint64 // maximum size in bytes of the data to parse. Zero means 64MB
+}
+
+// returns the parse options matching the engine configuration
+func (engine *CryptoEngine) parseOptions() ParseOptions {
+	return ParseOptions{
+		Legacy:  engine.config.LegacyParsing,
+		MaxSize: engine.maxMessageSize(),
+	}
+}
+
+// returns the maximum size of a serialized message the engine encrypts or parses
+func (engine *CryptoEngine) maxMessageSize() uint64 {
+	if engine.config.MaxMessageSize == 0 {
+		return maxMessageSize
+	}
+	return engine.config.MaxMessageSize
+}
+
+// returns the maximum size of the data to parse
+func (options ParseOptions) maxSize() uint64 {
+	if options.MaxSize == 0 {
+		return maxMessageSize
+	}
+	return options.MaxSize
+}
@@ -0,0 +1,77 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+)
+
+// Encrypted configuration files: a YAML, JSON or any other configuration file is encrypted with the engine secret key
+// and stored as a PEM armored block, so it can be committed and decrypted only in memory when the application starts.
+//
+//	-----BEGIN CRYPTOENGINE ENCRYPTED CONFIG-----
+//	<base64 encrypted message>
+//	-----END CRYPTOENGINE ENCRYPTED CONFIG-----
+
+const (
+	configPemType = "CRYPTOENGINE ENCRYPTED CONFIG"
+)
+
+var (
+	ConfigFormatError = errors.New("The encrypted config file is not valid")
+)
+
+// This method encrypts the content of a configuration file and returns the armored encrypted file
+func (engine *CryptoEngine) EncryptConfig(config []byte) ([]byte, error) {
+	msg, err := NewMessage(string(config), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: configPemType, Bytes: encryptedBytes}), nil
+}
+
+// This method decrypts an armored encrypted file returned by EncryptConfig
+func (engine *CryptoEngine) DecryptConfig(armored []byte) ([]byte, error) {
+	block, rest := pem.Decode(armored)
+	if block == nil || block.Type != configPemType || len(bytes.TrimSpace(rest)) != 0 {
+		return nil, ConfigFormatError
+	}
+
+	msg, err := engine.Decrypt(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(msg.Text), nil
+}
+
+// This method reads and decrypts the encrypted configuration file at path, then decodes it into v with the unmarshal function,
+// for instance json.Unmarshal or yaml.Unmarshal. The decrypted content is wiped once decoded.
+func (engine *CryptoEngine) LoadEncryptedConfig(path string, unmarshal func([]byte, interface{}) error, v interface{}) error {
+	armored, err := readFile(path)
+	if err != nil {
+		return err
+	}
+
+	config, err := engine.DecryptConfig(armored)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for i := range config {
+			config[i] = 0
+		}
+	}()
+
+	return unmarshal(config, v)
+}
@@ -0,0 +1,69 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedConfig(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := []byte(`{"database": "postgres://app:s3cr3t@db/app", "port": 8080}`)
+	armored, err := engine.EncryptConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(armored, []byte("s3cr3t")) || !bytes.HasPrefix(armored, []byte("-----BEGIN "+configPemType+"-----")) {
+		t.Fatal("The config has not been armored")
+	}
+
+	decrypted, err := engine.DecryptConfig(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, config) {
+		t.Fatal("The decrypted config does not match the original one")
+	}
+
+	// load it from a file
+	directory, err := ioutil.TempDir("", "cryptoengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "config.json.enc")
+	if err := ioutil.WriteFile(path, armored, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var settings struct {
+		Database string `json:"database"`
+		Port     int    `json:"port"`
+	}
+	if err := engine.LoadEncryptedConfig(path, json.Unmarshal, &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Database != "postgres://app:s3cr3t@db/app" || settings.Port != 8080 {
+		t.Fatal("The config has not been loaded")
+	}
+
+	// a clear text config is rejected
+	if _, err := engine.DecryptConfig(config); err != ConfigFormatError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ConfigFormatError, err)
+	}
+
+	// trailing data after the armored block is rejected
+	if _, err := engine.DecryptConfig(append(armored, []byte("database: other")...)); err != ConfigFormatError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ConfigFormatError, err)
+	}
+}
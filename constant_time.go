@@ -0,0 +1,64 @@
+package cryptoengine
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// Constant time comparisons: keys, MACs and fingerprints must never be compared with bytes.Equal or bytes.Compare,
+// which return as soon as a byte differs and leak through the timing how many leading bytes matched.
+// The fingerprints are short, human comparable digests of the public keys, to verify a peer over another channel.
+
+const (
+	fingerprintContext = "cryptoengine fingerprint"
+	fingerprintGroup   = 4 // hex digits per group of the formatted fingerprint
+)
+
+// This function reports whether a and b are equal, in a time which depends only on their length
+func ConstantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// This function returns the fingerprint of the public key: the hex digits of its hash, in groups of 4 separated by spaces
+func Fingerprint(publicKey []byte) string {
+	sum := HashWithContext(fingerprintContext, publicKey)
+	digits := []byte(hex.EncodeToString(sum[:]))
+
+	var fingerprint strings.Builder
+	for i := 0; i < len(digits); i += fingerprintGroup {
+		if i > 0 {
+			fingerprint.WriteByte(' ')
+		}
+		fingerprint.Write(digits[i : i+fingerprintGroup])
+	}
+	return fingerprint.String()
+}
+
+// This function compares two fingerprints in constant time.
+// The case, the spaces and the colons are ignored, so a fingerprint typed or read back by a user can be compared.
+func CompareFingerprints(a, b string) bool {
+	return ConstantTimeEqual([]byte(normalizeFingerprint(a)), []byte(normalizeFingerprint(b)))
+}
+
+// This method returns the fingerprint of the peer public key
+func (engine VerificationEngine) Fingerprint() string {
+	return Fingerprint(engine.publicKey[:])
+}
+
+// This method returns the fingerprint of the engine public key
+func (engine *CryptoEngine) Fingerprint() string {
+	return Fingerprint(engine.publicKey[:])
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == ' ' || r == ':':
+			return -1
+		case r >= 'A' && r <= 'F':
+			return r - 'A' + 'a'
+		}
+		return r
+	}, fingerprint)
+}
@@ -0,0 +1,56 @@
+package cryptoengine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConstantTimeEqual(t *testing.T) {
+
+	if !ConstantTimeEqual([]byte("mac"), []byte("mac")) {
+		t.Fatal("The equal slices have not been reported as equal")
+	}
+	if ConstantTimeEqual([]byte("mac"), []byte("mad")) || ConstantTimeEqual([]byte("mac"), []byte("ma")) {
+		t.Fatal("The different slices have been reported as equal")
+	}
+	if !ConstantTimeEqual(nil, []byte{}) {
+		t.Fatal("The empty slices have not been reported as equal")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verificationEngine, err := NewVerificationEngineWithKey(engine.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint := engine.Fingerprint()
+	if fingerprint != verificationEngine.Fingerprint() {
+		t.Fatal("The fingerprints of the same public key do not match")
+	}
+
+	// 16 groups of 4 hex digits
+	groups := strings.Split(fingerprint, " ")
+	if len(groups) != 16 || len(groups[0]) != 4 {
+		t.Fatalf("The fingerprint format is not valid: %s\n", fingerprint)
+	}
+
+	// typed back by a user
+	typed := strings.ToUpper(strings.Replace(fingerprint, " ", ":", -1))
+	if !CompareFingerprints(fingerprint, typed) {
+		t.Fatal("The fingerprint typed back does not match")
+	}
+
+	other, err := InitCryptoEngine("Sec51Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CompareFingerprints(fingerprint, other.Fingerprint()) {
+		t.Fatal("The fingerprints of different public keys match")
+	}
+}
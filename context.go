@@ -0,0 +1,95 @@
+package cryptoengine
+
+import (
+	"context"
+	"time"
+)
+
+// context.Context aware variants: the keys, the nonce counters and the replay cache can live on the network,
+// in a KMS, Vault or a distributed store, so the engine operations which reach them honor the cancellation and the deadline of a context.
+// The stores implementing the optional Context interfaces receive the context,
+// for the others the context is checked before each operation.
+
+// The ContextKeyStore interface is implemented by the key stores whose operations can be cancelled
+type ContextKeyStore interface {
+	KeyStore
+	ReadKeyContext(ctx context.Context, name string) ([]byte, error)
+	WriteKeyContext(ctx context.Context, name string, data []byte) error
+	DeleteKeyContext(ctx context.Context, name string) error
+}
+
+// The ContextCounterStore interface is implemented by the counter stores whose reservations can be cancelled
+type ContextCounterStore interface {
+	CounterStore
+	ReserveCountersContext(ctx context.Context, context string, n uint64) (uint64, error)
+}
+
+// The ContextReplayCache interface is implemented by the replay caches whose operations can be cancelled
+type ContextReplayCache interface {
+	ReplayCache
+	RememberContext(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// a KeyStore bound to a context
+type contextKeyStore struct {
+	ctx   context.Context
+	store KeyStore
+}
+
+// binds the store to the context, the background context needs no wrapping
+func storeWithContext(ctx context.Context, store KeyStore) KeyStore {
+	if ctx == context.Background() {
+		return store
+	}
+	return &contextKeyStore{ctx: ctx, store: store}
+}
+
+func (store *contextKeyStore) ReadKey(name string) ([]byte, error) {
+	if contextStore, ok := store.store.(ContextKeyStore); ok {
+		return contextStore.ReadKeyContext(store.ctx, name)
+	}
+	if err := store.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return store.store.ReadKey(name)
+}
+
+func (store *contextKeyStore) WriteKey(name string, data []byte) error {
+	if contextStore, ok := store.store.(ContextKeyStore); ok {
+		return contextStore.WriteKeyContext(store.ctx, name, data)
+	}
+	if err := store.ctx.Err(); err != nil {
+		return err
+	}
+	return store.store.WriteKey(name, data)
+}
+
+func (store *contextKeyStore) DeleteKey(name string) error {
+	if contextStore, ok := store.store.(ContextKeyStore); ok {
+		return contextStore.DeleteKeyContext(store.ctx, name)
+	}
+	if err := store.ctx.Err(); err != nil {
+		return err
+	}
+	return store.store.DeleteKey(name)
+}
+
+func reserveCountersContext(ctx context.Context, store CounterStore, context string, n uint64) (uint64, error) {
+	if contextStore, ok := store.(ContextCounterStore); ok {
+		return contextStore.ReserveCountersContext(ctx, context, n)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return store.ReserveCounters(context, n)
+}
+
+func rememberContext(ctx context.Context, cache ReplayCache, key string, ttl time.Duration) (bool, error) {
+	if contextCache, ok := cache.(ContextReplayCache); ok {
+		return contextCache.RememberContext(ctx, key, ttl)
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return cache.Remember(key, ttl)
+}
@@ -0,0 +1,81 @@
+package cryptoengine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// a counter store which blocks until the context is done, like an unreachable network store
+type blockingCounterStore struct {
+	*MemoryKeyStore
+}
+
+func (store blockingCounterStore) ReserveCountersContext(ctx context.Context, context string, n uint64) (uint64, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestInitCryptoEngineContext(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := InitCryptoEngineContext(ctx, "Sec51Context", Config{KeyStore: NewMemoryKeyStore()}); err != context.Canceled {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", context.Canceled, err)
+	}
+
+	engine, err := InitCryptoEngineContext(context.Background(), "Sec51Context", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if engine.PublicKey() == nil {
+		t.Fatal("The engine has not been initialized")
+	}
+}
+
+func TestEncryptDecryptContext(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	engine, err := InitCryptoEngineWithConfig("Sec51Context", Config{KeyStore: store, CounterStore: blockingCounterStore{store}, ReplayCache: NewMemoryReplayCache()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := engine.EncryptContext(ctx, msg); err != context.DeadlineExceeded {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", context.DeadlineExceeded, err)
+	}
+
+	// the same engine, with a counter store which answers
+	engine.config.CounterStore = store
+	encrypted, err := engine.EncryptContext(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the replay cache is not reached once the context is cancelled
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := engine.DecryptContext(cancelled, encryptedBytes); err != context.Canceled {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", context.Canceled, err)
+	}
+
+	decrypted, err := engine.DecryptContext(context.Background(), encryptedBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text {
+		t.Fatal("The decrypted message does not match the original one")
+	}
+}
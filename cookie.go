@@ -0,0 +1,152 @@
+package cryptoengine
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"net/http"
+	"time"
+)
+
+// Encrypted cookies: the values are sealed with XChaCha20-Poly1305 under a key derived from the engine secret key.
+// The expiration time is sealed with the value and the cookie name and the user agent of the client are authenticated,
+// so a cookie cannot be renamed, moved to another client with a different user agent or used after it expires.
+// An encoded value is the unpadded base64url encoding of: version (1 byte)|expiration (8 bytes)|nonce (24 bytes)|sealed value
+
+const (
+	cookieSubKeyLabel = "cookie"
+	cookieVersion     = 1
+	cookieHeaderSize  = 1 + 8
+	maxCookieSize     = 4096 // browsers do not store bigger cookies
+)
+
+var (
+	CookieError         = errors.New("The cookie is not valid")
+	CookieExpiredError  = errors.New("The cookie has expired")
+	CookieTooLargeError = errors.New("The encoded cookie exceeds the maximum cookie size")
+	cookieEncoding      = base64.RawURLEncoding
+)
+
+// The CookieCodec seals and opens the cookie values with the engine secret key
+type CookieCodec struct {
+	engine        *CryptoEngine
+	maxAge        time.Duration
+	bindUserAgent bool
+}
+
+// This method returns a cookie codec whose cookies expire after maxAge.
+// When bindUserAgent is true the cookies can be opened only in the requests with the same User-Agent header.
+func (engine *CryptoEngine) NewCookieCodec(maxAge time.Duration, bindUserAgent bool) *CookieCodec {
+	return &CookieCodec{
+		engine:        engine,
+		maxAge:        maxAge,
+		bindUserAgent: bindUserAgent,
+	}
+}
+
+// This method seals the value of the cookie with the given name for the user agent
+func (codec *CookieCodec) Encode(name string, value []byte, userAgent string) (string, error) {
+	return codec.encode(name, value, userAgent, time.Now())
+}
+
+// This method opens the value of the cookie with the given name, sealed for the user agent
+func (codec *CookieCodec) Decode(name, encoded, userAgent string) ([]byte, error) {
+	return codec.decode(name, encoded, userAgent, time.Now())
+}
+
+// This method seals the value into the cookie and adds it to the response.
+// The expiration of the cookie is set to the one sealed with the value.
+func (codec *CookieCodec) SetCookie(w http.ResponseWriter, r *http.Request, cookie *http.Cookie, value []byte) error {
+	now := time.Now()
+	encoded, err := codec.encode(cookie.Name, value, r.UserAgent(), now)
+	if err != nil {
+		return err
+	}
+
+	cookie.Value = encoded
+	cookie.Expires = now.Add(codec.maxAge)
+	cookie.MaxAge = int(codec.maxAge / time.Second)
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// This method reads the cookie with the given name from the request and opens its value
+func (codec *CookieCodec) Cookie(r *http.Request, name string) ([]byte, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(name, cookie.Value, r.UserAgent())
+}
+
+func (codec *CookieCodec) encode(name string, value []byte, userAgent string, now time.Time) (string, error) {
+	aead, err := codec.aead()
+	if err != nil {
+		return "", err
+	}
+
+	sealed := make([]byte, cookieHeaderSize+chacha20poly1305.NonceSizeX, cookieHeaderSize+chacha20poly1305.NonceSizeX+len(value)+chacha20poly1305.Overhead)
+	sealed[0] = cookieVersion
+	binary.BigEndian.PutUint64(sealed[1:cookieHeaderSize], uint64(now.Add(codec.maxAge).Unix()))
+
+	nonce := sealed[cookieHeaderSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed = aead.Seal(sealed, nonce, value, codec.additionalData(name, userAgent, sealed[:cookieHeaderSize]))
+
+	encoded := cookieEncoding.EncodeToString(sealed)
+	if len(name)+1+len(encoded) > maxCookieSize {
+		return "", CookieTooLargeError
+	}
+	return encoded, nil
+}
+
+func (codec *CookieCodec) decode(name, encoded, userAgent string, now time.Time) ([]byte, error) {
+	if len(name)+1+len(encoded) > maxCookieSize {
+		return nil, CookieError
+	}
+
+	sealed, err := cookieEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < cookieHeaderSize+chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead || sealed[0] != cookieVersion {
+		return nil, CookieError
+	}
+
+	aead, err := codec.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := sealed[cookieHeaderSize : cookieHeaderSize+chacha20poly1305.NonceSizeX]
+	value, err := aead.Open(nil, nonce, sealed[cookieHeaderSize+chacha20poly1305.NonceSizeX:], codec.additionalData(name, userAgent, sealed[:cookieHeaderSize]))
+	if err != nil {
+		return nil, CookieError
+	}
+
+	// the expiration is authenticated, so it's checked only once the cookie has been opened
+	expiration := time.Unix(int64(binary.BigEndian.Uint64(sealed[1:cookieHeaderSize])), 0)
+	if !now.Before(expiration) {
+		return nil, CookieExpiredError
+	}
+	return value, nil
+}
+
+func (codec *CookieCodec) aead() (cipher.AEAD, error) {
+	key, err := codec.engine.deriveSubKey(cookieSubKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key[:])
+}
+
+// the cookie name, the user agent when bound and the header are authenticated
+func (codec *CookieCodec) additionalData(name, userAgent string, header []byte) []byte {
+	if !codec.bindUserAgent {
+		userAgent = ""
+	}
+	return pae([]byte(name), []byte(userAgent), header)
+}
@@ -0,0 +1,107 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCookieCodec(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec := engine.NewCookieCodec(time.Hour, true)
+	value := []byte("session=42")
+
+	encoded, err := codec.Encode("session", value, "Mozilla/5.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := codec.Decode("session", encoded, "Mozilla/5.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, value) {
+		t.Fatal("The decoded cookie value does not match the original one")
+	}
+
+	// the cookie is bound to its name and to the user agent
+	if _, err := codec.Decode("other", encoded, "Mozilla/5.0"); err != CookieError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", CookieError, err)
+	}
+	if _, err := codec.Decode("session", encoded, "curl/8.0"); err != CookieError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", CookieError, err)
+	}
+
+	// without the binding any user agent can open it
+	unbound := engine.NewCookieCodec(time.Hour, false)
+	encoded, err = unbound.Encode("session", value, "Mozilla/5.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unbound.Decode("session", encoded, "curl/8.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	// expired cookies are rejected
+	encoded, err = codec.encode("session", value, "Mozilla/5.0", time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codec.Decode("session", encoded, "Mozilla/5.0"); err != CookieExpiredError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", CookieExpiredError, err)
+	}
+
+	if _, err := codec.Encode("session", make([]byte, maxCookieSize), ""); err != CookieTooLargeError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", CookieTooLargeError, err)
+	}
+
+	if _, err := codec.Decode("session", "not a cookie", ""); err != CookieError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", CookieError, err)
+	}
+}
+
+func TestCookieCodecHTTP(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec := engine.NewCookieCodec(time.Hour, true)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("User-Agent", "Mozilla/5.0")
+	recorder := httptest.NewRecorder()
+
+	if err := codec.SetCookie(recorder, request, &http.Cookie{Name: "session", HttpOnly: true, Secure: true}, []byte("user=alice")); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge != 3600 {
+		t.Fatal("The cookie has not been set")
+	}
+
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("User-Agent", "Mozilla/5.0")
+	request.AddCookie(cookies[0])
+
+	value, err := codec.Cookie(request, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "user=alice" {
+		t.Fatal("The cookie value does not match the original one")
+	}
+
+	if _, err := codec.Cookie(request, "missing"); err != http.ErrNoCookie {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", http.ErrNoCookie, err)
+	}
+}
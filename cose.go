@@ -0,0 +1,327 @@
+package cryptoengine
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+// COSE (RFC 9052 / RFC 9053) structures:
+// - COSE_Encrypt with a single recipient, direct key agreement ECDH-ES + HKDF-256 over X25519 and A256GCM content encryption
+// - COSE_Sign1 with EdDSA (Ed25519) signatures
+
+const (
+	coseEncryptTag = 96
+	coseSign1Tag   = 18
+
+	// header labels
+	coseHeaderAlgorithm = 1
+	coseHeaderIV        = 5
+	coseHeaderEphemeral = -1
+
+	// algorithms
+	coseAlgorithmA256GCM = 3
+	coseAlgorithmECDHES  = -25 // ECDH-ES + HKDF-256
+	coseAlgorithmEdDSA   = -8
+
+	// COSE_Key
+	coseKeyType      = 1
+	coseKeyTypeOKP   = 1
+	coseKeyCurve     = -1
+	coseCurveX25519  = 4
+	coseKeyX         = -2
+	coseKeyBits      = 256
+	coseGCMNonceSize = 12
+)
+
+var (
+	COSEFormatError = errors.New("The COSE structure is not valid")
+)
+
+// This method encrypts the payload for the peer public key as a tagged COSE_Encrypt structure.
+// The externalAAD is authenticated but not transmitted, the receiver has to provide the same value.
+func (engine *CryptoEngine) EncryptCOSE(payload, externalAAD []byte, verificationEngine VerificationEngine) ([]byte, error) {
+	if err := engine.allow(operationEncrypt); err != nil {
+		return nil, err
+	}
+	return encryptCOSE(payload, externalAAD, verificationEngine.PublicKey())
+}
+
+// the sender is anonymous: the key agreement uses only an ephemeral key
+func encryptCOSE(payload, externalAAD []byte, peerPublicKey [keySize]byte) ([]byte, error) {
+
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return nil, KeyNotValidError
+	}
+
+	// ephemeral key agreement
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, err
+	}
+
+	ephemeralPublic, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedSecret, err := curve25519.X25519(ephemeral, peerPublicKey[:])
+	if err != nil {
+		return nil, KeyNotValidError
+	}
+
+	recipientProtected := cborEncode(cborPairs{int64(coseHeaderAlgorithm), int64(coseAlgorithmECDHES)})
+	gcm, err := coseCipher(sharedSecret, recipientProtected)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, coseGCMNonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	protected := cborEncode(cborPairs{int64(coseHeaderAlgorithm), int64(coseAlgorithmA256GCM)})
+	ciphertext := gcm.Seal(nil, iv, payload, coseEncStructure(protected, externalAAD))
+
+	ephemeralKey := cborPairs{
+		int64(coseKeyType), int64(coseKeyTypeOKP),
+		int64(coseKeyCurve), int64(coseCurveX25519),
+		int64(coseKeyX), ephemeralPublic,
+	}
+
+	recipient := []interface{}{
+		recipientProtected,
+		cborPairs{int64(coseHeaderEphemeral), ephemeralKey},
+		[]byte{},
+	}
+
+	return cborEncode(cborTag{
+		number: coseEncryptTag,
+		value: []interface{}{
+			protected,
+			cborPairs{int64(coseHeaderIV), iv},
+			ciphertext,
+			[]interface{}{recipient},
+		},
+	}), nil
+}
+
+// This method decrypts a COSE_Encrypt structure created for the engine public key
+func (engine *CryptoEngine) DecryptCOSE(data, externalAAD []byte) ([]byte, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, err
+	}
+
+	items, err := coseStructure(data, coseEncryptTag, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, unprotected, err := coseHeaders(items)
+	if err != nil {
+		return nil, err
+	}
+
+	if protected[int64(coseHeaderAlgorithm)] != int64(coseAlgorithmA256GCM) {
+		return nil, COSEFormatError
+	}
+
+	iv, ok := unprotected[int64(coseHeaderIV)].([]byte)
+	if !ok || len(iv) != coseGCMNonceSize {
+		return nil, COSEFormatError
+	}
+
+	ciphertext, ok := items[2].([]byte)
+	if !ok {
+		return nil, COSEFormatError
+	}
+
+	// exactly one recipient with direct key agreement
+	recipients, ok := items[3].([]interface{})
+	if !ok || len(recipients) != 1 {
+		return nil, COSEFormatError
+	}
+
+	recipient, ok := recipients[0].([]interface{})
+	if !ok || len(recipient) != 3 {
+		return nil, COSEFormatError
+	}
+
+	recipientProtected, recipientUnprotected, err := coseHeaders(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipientProtected[int64(coseHeaderAlgorithm)] != int64(coseAlgorithmECDHES) {
+		return nil, COSEFormatError
+	}
+
+	ephemeralKey, ok := recipientUnprotected[int64(coseHeaderEphemeral)].(map[interface{}]interface{})
+	if !ok || ephemeralKey[int64(coseKeyType)] != int64(coseKeyTypeOKP) || ephemeralKey[int64(coseKeyCurve)] != int64(coseCurveX25519) {
+		return nil, COSEFormatError
+	}
+
+	ephemeralPublic, ok := ephemeralKey[int64(coseKeyX)].([]byte)
+	if !ok || len(ephemeralPublic) != curve25519.PointSize {
+		return nil, COSEFormatError
+	}
+
+	// X25519 fails on low order points
+	sharedSecret, err := curve25519.X25519(engine.privateKey[:], ephemeralPublic)
+	if err != nil {
+		return nil, COSEFormatError
+	}
+
+	gcm, err := coseCipher(sharedSecret, recipient[0].([]byte))
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := gcm.Open(nil, iv, ciphertext, coseEncStructure(items[0].([]byte), externalAAD))
+	if err != nil {
+		return nil, MessageDecryptionError
+	}
+	return payload, nil
+}
+
+// This method signs the payload with the engine signing key and returns a tagged COSE_Sign1 structure.
+// The payload is embedded in the structure.
+func (engine *CryptoEngine) SignCOSE(payload, externalAAD []byte) ([]byte, error) {
+	protected := cborEncode(cborPairs{int64(coseHeaderAlgorithm), int64(coseAlgorithmEdDSA)})
+	signature := engine.Sign(coseSigStructure(protected, externalAAD, payload))
+
+	return cborEncode(cborTag{
+		number: coseSign1Tag,
+		value: []interface{}{
+			protected,
+			cborPairs{},
+			payload,
+			signature,
+		},
+	}), nil
+}
+
+// This method verifies a COSE_Sign1 structure with the peer signing public key and returns the payload
+func (e VerificationEngine) VerifyCOSE(data, externalAAD []byte) ([]byte, error) {
+
+	items, err := coseStructure(data, coseSign1Tag, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, _, err := coseHeaders(items)
+	if err != nil {
+		return nil, err
+	}
+
+	if protected[int64(coseHeaderAlgorithm)] != int64(coseAlgorithmEdDSA) {
+		return nil, COSEFormatError
+	}
+
+	payload, ok := items[2].([]byte)
+	if !ok {
+		return nil, COSEFormatError
+	}
+
+	signature, ok := items[3].([]byte)
+	if !ok {
+		return nil, COSEFormatError
+	}
+
+	if err := e.Verify(coseSigStructure(items[0].([]byte), externalAAD, payload), signature); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// decodes the structure, checks the tag (which is optional) and the amount of items
+func coseStructure(data []byte, tag uint64, size int) ([]interface{}, error) {
+	value, err := cborDecode(data)
+	if err != nil {
+		return nil, COSEFormatError
+	}
+
+	if tagged, ok := value.(cborTag); ok {
+		if tagged.number != tag {
+			return nil, COSEFormatError
+		}
+		value = tagged.value
+	}
+
+	items, ok := value.([]interface{})
+	if !ok || len(items) != size {
+		return nil, COSEFormatError
+	}
+	return items, nil
+}
+
+// returns the decoded protected headers and the unprotected headers, the first two items of every COSE structure
+func coseHeaders(items []interface{}) (map[interface{}]interface{}, map[interface{}]interface{}, error) {
+	protectedBytes, ok := items[0].([]byte)
+	if !ok {
+		return nil, nil, COSEFormatError
+	}
+
+	protected := map[interface{}]interface{}{}
+	if len(protectedBytes) > 0 {
+		value, err := cborDecode(protectedBytes)
+		if err != nil {
+			return nil, nil, COSEFormatError
+		}
+		if protected, ok = value.(map[interface{}]interface{}); !ok {
+			return nil, nil, COSEFormatError
+		}
+	}
+
+	unprotected, ok := items[1].(map[interface{}]interface{})
+	if !ok {
+		return nil, nil, COSEFormatError
+	}
+
+	return protected, unprotected, nil
+}
+
+// Enc_structure = ["Encrypt", protected, external_aad]
+func coseEncStructure(protected, externalAAD []byte) []byte {
+	if externalAAD == nil {
+		externalAAD = []byte{}
+	}
+	return cborEncode([]interface{}{"Encrypt", protected, externalAAD})
+}
+
+// Sig_structure = ["Signature1", protected, external_aad, payload]
+func coseSigStructure(protected, externalAAD, payload []byte) []byte {
+	if externalAAD == nil {
+		externalAAD = []byte{}
+	}
+	return cborEncode([]interface{}{"Signature1", protected, externalAAD, payload})
+}
+
+// derives the content key with HKDF-SHA256 over the COSE_KDF_Context and returns the AES-GCM cipher
+func coseCipher(sharedSecret, recipientProtected []byte) (cipher.AEAD, error) {
+	empty := []interface{}{nil, nil, nil}
+	context := cborEncode([]interface{}{
+		int64(coseAlgorithmA256GCM),
+		empty,
+		empty,
+		[]interface{}{int64(coseKeyBits), recipientProtected},
+	})
+
+	key := make([]byte, coseKeyBits/8)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, nil, context), key); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
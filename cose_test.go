@@ -0,0 +1,140 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestCBOR(t *testing.T) {
+
+	// RFC 8949 appendix A examples
+	vectors := []struct {
+		value   interface{}
+		encoded string
+	}{
+		{int64(0), "00"},
+		{int64(23), "17"},
+		{int64(24), "1818"},
+		{int64(1000), "1903e8"},
+		{int64(-1), "20"},
+		{int64(-1000), "3903e7"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"IETF", "6449455446"},
+		{[]interface{}{int64(1), []interface{}{int64(2), int64(3)}}, "8201820203"},
+		{cborPairs{int64(1), int64(2), int64(3), int64(4)}, "a201020304"},
+		{nil, "f6"},
+	}
+
+	for _, vector := range vectors {
+		encoded := hex.EncodeToString(cborEncode(vector.value))
+		if encoded != vector.encoded {
+			t.Errorf("Expected %s, got %s\n", vector.encoded, encoded)
+		}
+
+		decoded, err := hex.DecodeString(vector.encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cborDecode(decoded); err != nil {
+			t.Errorf("Could not decode %s: %s\n", vector.encoded, err)
+		}
+	}
+
+	// an array announcing more items than available
+	if _, err := cborDecode([]byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}); err != CBORError {
+		t.Errorf("The expected error is: CBORError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestCOSEEncrypt(t *testing.T) {
+
+	firstEngine, err := InitCryptoEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondEngine, err := InitCryptoEngine("Sec51Peer2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondVerificationEngine, err := NewVerificationEngine("Sec51Peer2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte("The quick brown fox jumps over the lazy dog")
+	aad := []byte("device-42")
+
+	encrypted, err := firstEngine.EncryptCOSE(payload, aad, secondVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tag 96
+	if encrypted[0] != 0xd8 || encrypted[1] != coseEncryptTag {
+		t.Fatal("The COSE_Encrypt structure is not tagged")
+	}
+
+	decrypted, err := secondEngine.DecryptCOSE(encrypted, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, payload) {
+		t.Fatal("COSE encryption/decryption broken")
+	}
+
+	if _, err := secondEngine.DecryptCOSE(encrypted, []byte("device-43")); err != MessageDecryptionError {
+		t.Errorf("The expected error is: MessageDecryptionError, instead we've got: %v\n", err)
+	}
+
+	if _, err := firstEngine.DecryptCOSE(encrypted, aad); err != MessageDecryptionError {
+		t.Errorf("The expected error is: MessageDecryptionError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestCOSESign1(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verificationEngine, err := NewVerificationEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte("The quick brown fox jumps over the lazy dog")
+	signed, err := engine.SignCOSE(payload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tag 18
+	if signed[0] != 0xd2 {
+		t.Fatal("The COSE_Sign1 structure is not tagged")
+	}
+
+	verified, err := verificationEngine.VerifyCOSE(signed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(verified, payload) {
+		t.Fatal("COSE signing/verification broken")
+	}
+
+	if _, err := verificationEngine.VerifyCOSE(signed, []byte("aad")); err != SignatureError {
+		t.Errorf("The expected error is: SignatureError, instead we've got: %v\n", err)
+	}
+
+	if _, err := verificationEngine.VerifyCOSE(payload, nil); err != COSEFormatError {
+		t.Errorf("The expected error is: COSEFormatError, instead we've got: %v\n", err)
+	}
+
+}
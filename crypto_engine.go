@@ -1,27 +1,29 @@
 package cryptoengine
 
 import (
-	"bytes"
+	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"golang.org/x/crypto/nacl/box"
 	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/text/unicode/norm"
 	"log"
-	"math"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
-	nonceSize           = 24 // this is the nonce size, required by NaCl
-	keySize             = 32 // this is the nonce size, required by NaCl
-	rotateSaltAfterDays = 7  // this is the amount of days the salt is valid - if it crosses this amount a new salt is generated
-	tcpVersion          = 0  // this is the current TCP version
+	nonceSize      = 24               // this is the nonce size, required by NaCl
+	keySize        = 32               // this is the nonce size, required by NaCl
+	tcpVersion     = 0                // this is the current TCP version
+	maxMessageSize = 64 * 1024 * 1024 // this is the maximum size in bytes of a serialized encrypted message (64MB)
 )
 
 var (
@@ -31,10 +33,19 @@ var (
 	KeyGenerationError     = errors.New("Could not generate random key")
 	MessageDecryptionError = errors.New("Could not verify the message. Message has been tempered with!")
 	MessageParsingError    = errors.New("Could not parse the Message from bytes")
+	MessageLengthError     = errors.New("The message length field does not match the size of the message")
+	MessageTooLargeError   = errors.New("The message exceeds the maximum allowed size")
+	MessageVersionError    = errors.New("The message version is not supported")
+	SignatureError         = errors.New("The signature is not valid")
 	messageEmpty           = errors.New("Can not encrypt an empty message")
 	whiteSpaceRegEx        = regexp.MustCompile("\\s")
 	emptyKey               = make([]byte, keySize)
 
+	// message versions accepted by the strict parser
+	supportedVersions = map[int]bool{
+		tcpVersion: true,
+	}
+
 	// salt for derivating keys
 	saltSuffixFormat = "%s_salt.key" // this is the salt file,for instance: sec51_salt.key
 
@@ -47,6 +58,10 @@ var (
 
 	// nonce secret key
 	nonceSuffixFormat = "%s_nonce.key" // this is the secret key crypto file used for generating nonces,for instance: sec51_nonce.key
+
+	// signing keys
+	signingPublicSuffixFormat  = "%s_signing_public.key"  // this is the Ed25519 public key file, for instance: sec51_signing_public.key
+	signingPrivateSuffixFormat = "%s_signing_private.key" // this is the Ed25519 private key seed file, for instance: sec51_signing_private.key
 )
 
 // This is the basic object which needs to be instanciated for encrypting messages
@@ -58,12 +73,22 @@ type CryptoEngine struct {
 	publicKey        [keySize]byte            // cached asymmetric public key
 	privateKey       [keySize]byte            // cached asymmetric private key
 	secretKey        [keySize]byte            // secret key used for symmetric encryption
-	salt             [keySize]byte            // salt for deriving the random nonces
+	salt             [keySize]byte            // salt for deriving the random nonces, guarded by the counterMutex once initialized
+	saltCreated      time.Time                // when the salt was generated, for the salt rotation policy
+	saltMessages     uint64                   // the amount of nonces derived from the salt since the engine has been initialized
+	saltStored       bool                     // the salt is loaded from the key store, so a rotated salt is stored there
 	nonceKey         [keySize]byte            // this key is used for deriving the random nonces. It's different from the privateKey
 	mutex            sync.Mutex               // this mutex is used ti make sure that in case the engine is used by multiple thread the pre-shared key is correctly generated
 	preSharedKeysMap map[string][keySize]byte // this map holds the combination hash of peer public key as the map key and the preshared key as value used to encrypt
 	counter          uint64                   // this is the counter which is appended to the HKDF at each call
 	counterMutex     sync.Mutex               // this is the counter mutex for a safe incrementation (TODO: look into atomic)
+	counterBlockEnd  uint64                   // the end of the block of counters reserved from the counter store, if any
+	nonceWarnings    int                      // the amount of nonce warning thresholds already crossed
+	prefetchedNonces [][nonceSize]byte        // the nonces derived in advance and not used yet, see Config.NoncePrefetch
+	nonceMutex       sync.Mutex               // this mutex guards the prefetched nonces
+	successor        *CryptoEngine            // the engine which replaced this one on reload, the counters are reserved from it
+	config           Config                   // the optional settings the engine has been initialized with
+	signingKey       ed25519.PrivateKey       // Ed25519 private key used for signing
 }
 
 // This function initialize all the necessary information to carry out a secure communication
@@ -75,46 +100,121 @@ type CryptoEngine struct {
 //   with different end points, you can differrentiate the key by having different unique communicationIdentifier.
 //   It, also, loads the already created keys back in memory based on the communicationIdentifier
 // - it does the same with the asymmetric keys
-// The communicationIdentifier parameter is URL unescape, trimmed, set to lower case and all the white spaces are replaced with an underscore.
+// The communicationIdentifier parameter is URL unescape, normalized with NFKC, trimmed, set to lower case and all the white spaces are replaced with an underscore.
 // The publicKey parameter can be nil. In that case the CryptoEngine assumes it has been instanciated for symmetric crypto usage.
 func InitCryptoEngine(communicationIdentifier string) (*CryptoEngine, error) {
+	return InitCryptoEngineWithConfig(communicationIdentifier, Config{})
+}
+
+// This function works exactly like InitCryptoEngine, but it allows to tune the behaviour of the engine via the Config struct.
+func InitCryptoEngineWithConfig(communicationIdentifier string, config Config) (*CryptoEngine, error) {
+	return InitCryptoEngineContext(context.Background(), communicationIdentifier, config)
+}
+
+// This function works like InitCryptoEngineWithConfig, the keys are loaded from the key store within the context deadline.
+func InitCryptoEngineContext(ctx context.Context, communicationIdentifier string, config Config) (*CryptoEngine, error) {
 	// define an error object
 	var err error
 	// create a new crypto engine object
 	ce := new(CryptoEngine)
+	ce.config = config
 
 	// sanitize the communicationIdentifier
-	ce.context = sanitizeIdentifier(communicationIdentifier)
+	if ce.context, err = keyContext(communicationIdentifier); err != nil {
+		return nil, err
+	}
+	if config.StrictIdentifiers {
+		if _, err := ValidateIdentifier(communicationIdentifier); err != nil {
+			return nil, err
+		}
+	}
+
+	// init the map
+	ce.preSharedKeysMap = make(map[string][keySize]byte)
+
+	switch config.Role {
+	case RoleFull, RoleDecryptOnly:
+	case RoleEncryptOnly:
+		// no key is loaded
+		if err := ce.generateEphemeralKeys(); err != nil {
+			return nil, err
+		}
+		return ce, nil
+	default:
+		return nil, RoleError
+	}
+
+	if config.MasterKey != nil && len(config.MasterKey) < minMasterKeySize {
+		return nil, MasterKeyError
+	}
+
+	// the keys are loaded from the configured key store
+	store := storeWithContext(ctx, config.keyStore())
+
+	// make sure the keys have not been generated for another identifier
+	if config.StrictIdentifiers {
+		if err := checkIdentifierCollision(store, communicationIdentifier, ce.context); err != nil {
+			return nil, err
+		}
+	}
+
+	// the manifest is written only for the keys generated now
+	generated := false
+	if config.ManifestKey != nil {
+		missing, err := manifestKeysMissing(store, ce.context)
+		if err != nil {
+			return nil, err
+		}
+		generated = missing
+	}
 
 	// load or generate the salt
-	salt, err := loadSalt(ce.context)
+	salt, err := loadSalt(store, ce.context)
 	if err != nil {
 		return nil, err
 	}
 	ce.salt = salt
+	ce.saltStored = true
+
+	// the creation time of the salt is needed only to rotate it after an interval
+	if config.SaltRotation.Interval > 0 {
+		if ce.saltCreated, err = loadSaltInfo(store, ce.context); err != nil {
+			return nil, err
+		}
+	}
 
 	// load or generate the corresponding public/private key pair
-	ce.publicKey, ce.privateKey, err = loadKeyPairs(ce.context)
+	ce.publicKey, ce.privateKey, err = loadKeyPairs(store, ce.context)
 	if err != nil {
 		return nil, err
 	}
 
 	// load or generate the secret key
-	secretKey, err := loadSecretKey(ce.context)
+	secretKey, err := loadSecretKey(store, ce.context)
 	if err != nil {
 		return nil, err
 	}
 	ce.secretKey = secretKey
 
 	// load the nonce key
-	nonceKey, err := loadNonceKey(ce.context)
+	nonceKey, err := loadNonceKey(store, ce.context)
 	if err != nil {
 		return nil, err
 	}
 	ce.nonceKey = nonceKey
 
-	// init the map
-	ce.preSharedKeysMap = make(map[string][keySize]byte)
+	// load or generate the signing key
+	ce.signingKey, err = loadSigningKey(store, ce.context)
+	if err != nil {
+		return nil, err
+	}
+
+	// make sure the key files have not been modified
+	if config.ManifestKey != nil {
+		if err := verifyManifest(store, ce.context, config.ManifestKey, generated); err != nil {
+			return nil, err
+		}
+	}
 
 	// finally return the CryptoEngine instance
 	return ce, nil
@@ -124,6 +224,9 @@ func InitCryptoEngine(communicationIdentifier string) (*CryptoEngine, error) {
 // this function reads nonceSize random data
 func generateSalt() ([keySize]byte, error) {
 	var data32 [keySize]byte
+	if err := checkEntropy(); err != nil {
+		return data32, err
+	}
 	data := make([]byte, keySize)
 	_, err := rand.Read(data)
 	if err != nil {
@@ -139,6 +242,9 @@ func generateSalt() ([keySize]byte, error) {
 // this function reads keySize random data
 func generateSecretKey() ([keySize]byte, error) {
 	var data32 [keySize]byte
+	if err := checkEntropy(); err != nil {
+		return data32, err
+	}
 	data := make([]byte, keySize)
 	_, err := rand.Read(data)
 	if err != nil {
@@ -152,89 +258,28 @@ func generateSecretKey() ([keySize]byte, error) {
 }
 
 // load the salt random bytes from the id_salt.key
-// if the file does not exist, create a new one
-// if the file is older than N days (default 2) generate a new one and overwrite the old
-// TODO: rotate the salt file
-func loadSalt(id string) ([keySize]byte, error) {
-
-	var salt [keySize]byte
-
-	saltFile := fmt.Sprintf(saltSuffixFormat, id)
-	if keyFileExists(saltFile) {
-		return readKey(saltFile, keysFolderPrefixFormat)
-	}
-
-	// generate the random salt
-	salt, err := generateSalt()
-	if err != nil {
-		return salt, err
-	}
-
-	// write the salt to the file with its prefix
-	if err := writeKey(saltFile, keysFolderPrefixFormat, salt[:]); err != nil {
-		return salt, err
-	}
-
-	// return the salt and no error
-	return salt, nil
+// if the key does not exist, create a new one
+// the salt is rotated by the engine, according to the SaltRotationPolicy of the Config
+func loadSalt(store KeyStore, id string) ([keySize]byte, error) {
+	return loadOrGenerateKey(store, saltSuffixFormat, id, generateSalt)
 }
 
-// load the key random bytes from the id_secret.key
-// if the file does not exist, create a new one
-func loadSecretKey(id string) ([keySize]byte, error) {
-
-	var key [keySize]byte
-
-	keyFile := fmt.Sprintf(secretSuffixFormat, id)
-	if keyFileExists(keyFile) {
-		return readKey(keyFile, keysFolderPrefixFormat)
-	}
-
-	// generate the random salt
-	key, err := generateSecretKey()
-	if err != nil {
-		return key, err
-	}
-
-	// write the salt to the file with its prefix
-	if err := writeKey(keyFile, keysFolderPrefixFormat, key[:]); err != nil {
-		return key, err
-	}
-
-	// return the salt and no error
-	return key, nil
+// load the key random bytes from the id_secret.key, a 64 bytes key is extracted to 32 bytes
+// if the key does not exist, create a new one
+func loadSecretKey(store KeyStore, id string) ([keySize]byte, error) {
+	return loadOrGenerateSecretKey(store, secretSuffixFormat, id, secretKeyExtractLabel)
 }
 
-// load the nonce key random bytes from the id_nonce.key
-// if the file does not exist, create a new one
-func loadNonceKey(id string) ([keySize]byte, error) {
-
-	var nonceKey [keySize]byte
-
-	nonceFile := fmt.Sprintf(nonceSuffixFormat, id)
-	if keyFileExists(nonceFile) {
-		return readKey(nonceFile, keysFolderPrefixFormat)
-	}
-
-	// generate the random salt
-	nonceKey, err := generateSecretKey()
-	if err != nil {
-		return nonceKey, err
-	}
-
-	// write the salt to the file with its prefix
-	if err := writeKey(nonceFile, keysFolderPrefixFormat, nonceKey[:]); err != nil {
-		return nonceKey, err
-	}
-
-	// return the salt and no error
-	return nonceKey, nil
+// load the nonce key random bytes from the id_nonce.key, a 64 bytes key is extracted to 32 bytes
+// if the key does not exist, create a new one
+func loadNonceKey(store KeyStore, id string) ([keySize]byte, error) {
+	return loadOrGenerateSecretKey(store, nonceSuffixFormat, id, nonceKeyExtractLabel)
 }
 
 // load the key pair, public and private keys, the id_public.key, id_private.key
-// if the files do not exist, create them
+// if the keys do not exist, create them
 // Returns the publicKey, privateKey, error
-func loadKeyPairs(id string) ([keySize]byte, [keySize]byte, error) {
+func loadKeyPairs(store KeyStore, id string) ([keySize]byte, [keySize]byte, error) {
 
 	var private [keySize]byte
 	var public [keySize]byte
@@ -242,27 +287,25 @@ func loadKeyPairs(id string) ([keySize]byte, [keySize]byte, error) {
 
 	// try to load the private key
 	privateFile := fmt.Sprintf(privateSuffixFormat, id)
-	if keyFileExists(privateFile) {
-		if private, err = readKey(privateFile, keysFolderPrefixFormat); err != nil {
-			return public, private, err
-		}
+	if private, err = readStoreKey(store, privateFile); err != nil && err != KeyNotFoundError {
+		return public, private, err
 	}
+
 	// try to load the public key and if it succeed, then return both the keys
 	publicFile := fmt.Sprintf(publicKeySuffixFormat, id)
-	if keyFileExists(publicFile) {
-		if public, err = readKey(publicFile, keysFolderPrefixFormat); err != nil {
-			return public, private, err
-		}
-
+	if public, err = readStoreKey(store, publicFile); err != KeyNotFoundError {
 		// if we reached here, it means that both the private and the public key
 		// existed and loaded successfully
 		return public, private, err
 	}
 
 	// if we reached here then, we need to cerate the key pair
+	if err := checkEntropy(); err != nil {
+		return public, private, err
+	}
 	tempPublic, tempPrivate, err := box.GenerateKey(rand.Reader)
 
-	// check for errors first, otherwise continue and store the keys to files
+	// check for errors first, otherwise continue and store the keys
 	if err != nil {
 		return public, private, err
 	}
@@ -271,16 +314,16 @@ func loadKeyPairs(id string) ([keySize]byte, [keySize]byte, error) {
 	private = *tempPrivate
 
 	// write the public key first
-	if err := writeKey(publicFile, keysFolderPrefixFormat, public[:]); err != nil {
+	if err := store.WriteKey(publicFile, public[:]); err != nil {
 		return public, private, err
 	}
 
 	// write the private
-	if err := writeKey(privateFile, keysFolderPrefixFormat, private[:]); err != nil {
+	if err := store.WriteKey(privateFile, private[:]); err != nil {
 		// delete the public key, otherwise we remain in an unwanted state
 		// the delete can fail as well, therefore we print an error
-		if err := deleteFile(publicFile); err != nil {
-			log.Printf("[SEVERE] - The private key for asymmetric encryption, %s, failed to be persisted. \nWhile trying to cleanup also the public key previosuly stored, %s, the operation failed as well.\nWe are now in an unrecoverable state.Please delete both files manually: %s - %s", privateFile, publicFile, privateFile, publicFile)
+		if err := store.DeleteKey(publicFile); err != nil {
+			log.Printf("[SEVERE] - The private key for asymmetric encryption, %s, failed to be persisted. \nWhile trying to cleanup also the public key previosuly stored, %s, the operation failed as well.\nWe are now in an unrecoverable state.Please delete both keys manually: %s - %s", privateFile, publicFile, privateFile, publicFile)
 			return public, private, err
 		}
 		return public, private, err
@@ -292,13 +335,17 @@ func loadKeyPairs(id string) ([keySize]byte, [keySize]byte, error) {
 }
 
 // Sanitizes the input of the communicationIdentifier
-// The input is URL unescape, trimmed, set to lower case and all the white spaces are replaced with an underscore.
-// TODO: evaluate the QueryUnescape error
+// The input is URL unescape, normalized with NFKC, trimmed, set to lower case and all the white spaces are replaced with an underscore.
+// The NFKC normalization maps the visually identical identifiers sent by different clients, for instance with composed or decomposed accents
+// or with full width characters, to the same keys.
+// The QueryUnescape error is ignored, the strict identifiers mode of the Config validates the identifier instead: see ValidateIdentifier
 func sanitizeIdentifier(id string) string {
 	// unescape in case it;s URL encoded
 	unescaped, _ := url.QueryUnescape(id)
+	// normalize the unicode characters
+	normalized := norm.NFKC.String(unescaped)
 	// trim white spaces
-	trimmed := strings.TrimSpace(unescaped)
+	trimmed := strings.TrimSpace(normalized)
 	// make lower case
 	lowered := strings.ToLower(trimmed)
 	// replace the white spaces with _
@@ -306,23 +353,76 @@ func sanitizeIdentifier(id string) string {
 	return cleaned
 }
 
-func (engine *CryptoEngine) fetchAndIncrement() string {
+// derives the nonce of the next counter value
+func (engine *CryptoEngine) nextNonce() ([nonceSize]byte, error) {
+	return engine.nextNonceContext(context.Background())
+}
+
+func (engine *CryptoEngine) nextNonceContext(ctx context.Context) ([nonceSize]byte, error) {
+	// the nonces are derived in blocks when the Config enables it
+	if engine.config.NoncePrefetch > 1 {
+		return engine.prefetchedNonce(ctx)
+	}
+	counter, salt, err := engine.reserveCountersContext(ctx, 1)
+	if err != nil {
+		return [nonceSize]byte{}, err
+	}
+	return deriveNonce(engine.nonceKey, salt, engine.context, strconv.FormatUint(counter, 10))
+}
+
+// reserves n consecutive counter values and returns the first one, with the salt the nonces are derived from
+// the range never wraps around: if it does not fit before the nonce limit NonceExhaustedError is returned
+// with a counter store the values are taken from the block reserved from the store, a new block is reserved when it's exhausted
+func (engine *CryptoEngine) reserveCounters(n uint64) (uint64, [keySize]byte, error) {
+	return engine.reserveCountersContext(context.Background(), n)
+}
+
+func (engine *CryptoEngine) reserveCountersContext(ctx context.Context, n uint64) (uint64, [keySize]byte, error) {
+	var salt [keySize]byte
+	// the nonces are needed only to encrypt with the engine keys
+	if err := engine.allow(operationEncrypt, operationOwnKeys); err != nil {
+		return 0, salt, err
+	}
+
 	engine.counterMutex.Lock()
+	// the engine has been reloaded: the counters are shared with the new engine
+	if successor := engine.successor; successor != nil {
+		engine.counterMutex.Unlock()
+		return successor.reserveCountersContext(ctx, n)
+	}
 	defer engine.counterMutex.Unlock()
 
-	// first read the current value
-	// reset the counter
-	if engine.counter == math.MaxUint64 {
-		engine.counter = 0
+	if engine.config.CounterStore != nil {
+		if engine.counterBlockEnd-engine.counter < n {
+			size := uint64(counterBlockSize)
+			if n > size {
+				size = n
+			}
+			first, err := reserveCountersContext(ctx, engine.config.CounterStore, engine.context, size)
+			if err != nil {
+				return 0, salt, err
+			}
+			engine.counter, engine.counterBlockEnd = first, first+size
+		}
+	}
+
+	// never reuse a nonce: stop before the limit
+	if err := engine.checkNonceLimit(engine.counter, n); err != nil {
+		return 0, salt, err
+	}
+
+	// rotate the salt first if the policy requires it
+	salt, err := engine.currentSalt(n)
+	if err != nil {
+		return 0, salt, err
 	}
 
-	// convert the counter to string
-	counterString := strconv.FormatUint(engine.counter, 10)
+	first := engine.counter
 
-	// increment the counter
-	engine.counter += 1
+	// increment the counter by the reserved amount
+	engine.counter += n
 
-	return counterString
+	return first, salt, nil
 }
 
 // Gives access to the public key
@@ -331,19 +431,34 @@ func (engine *CryptoEngine) PublicKey() []byte {
 }
 
 // This method accepts a message , then encrypts its Version+Type+Text using a symmetric key
-func (engine *CryptoEngine) NewEncryptedMessage(msg message) (EncryptedMessage, error) {
+func (engine *CryptoEngine) NewEncryptedMessage(msg Message) (EncryptedMessage, error) {
+	return engine.EncryptContext(context.Background(), msg)
+}
+
+// This method works like NewEncryptedMessage, the nonce counters are reserved from the counter store within the context deadline
+func (engine *CryptoEngine) EncryptContext(ctx context.Context, msg Message) (EncryptedMessage, error) {
 
 	m := EncryptedMessage{}
 
+	// serialize the message into a pooled buffer
+	buffer := getClearTextBuffer(msg)
+	defer putClearTextBuffer(buffer)
+	msgBytes := *buffer
+
+	// make sure the message, once encrypted, does not exceed the maximum size
+	if encryptedMessageSize(len(msgBytes)) > engine.maxMessageSize() {
+		return m, MessageTooLargeError
+	}
+
 	// derive nonce
-	nonce, err := deriveNonce(engine.nonceKey, engine.salt, engine.context, engine.fetchAndIncrement())
+	nonce, err := engine.nextNonceContext(ctx)
 	if err != nil {
 		return m, err
 	}
 
 	m.nonce = nonce
 
-	encryptedData := secretbox.Seal(nil, msg.toBytes(), &m.nonce, &engine.secretKey)
+	encryptedData := secretbox.Seal(nil, msgBytes, &m.nonce, &engine.secretKey)
 
 	// assign the encrypted data to the message
 	m.data = encryptedData
@@ -358,7 +473,16 @@ func (engine *CryptoEngine) NewEncryptedMessage(msg message) (EncryptedMessage,
 // This method accepts the message as byte slice and the public key of the receiver of the messae,
 // then encrypts it using the asymmetric key public key.
 // If the public key is not privisioned and does not have the required length of 32 bytes it raises an exception.
-func (engine *CryptoEngine) NewEncryptedMessageWithPubKey(msg message, verificationEngine VerificationEngine) (EncryptedMessage, error) {
+// The payload is not encrypted with the public key itself: the X25519 shared key is computed once per peer and cached,
+// then the payload is sealed with XSalsa20-Poly1305, like NewEncryptedMessage. The large payloads are therefore encrypted
+// at the secretbox speed already, wrapping a random key for each message would only add overhead.
+// To encrypt a large payload once for several recipients, use EncryptEnvelope.
+func (engine *CryptoEngine) NewEncryptedMessageWithPubKey(msg Message, verificationEngine VerificationEngine) (EncryptedMessage, error) {
+	return engine.EncryptWithPubKeyContext(context.Background(), msg, verificationEngine)
+}
+
+// This method works like NewEncryptedMessageWithPubKey, the nonce counters are reserved from the counter store within the context deadline
+func (engine *CryptoEngine) EncryptWithPubKeyContext(ctx context.Context, msg Message, verificationEngine VerificationEngine) (EncryptedMessage, error) {
 
 	encryptedMessage := EncryptedMessage{}
 
@@ -371,12 +495,18 @@ func (engine *CryptoEngine) NewEncryptedMessageWithPubKey(msg message, verificat
 	}
 
 	// check the peerPublicKey is not empty (all zeros)
-	if bytes.Compare(peerPublicKey[:], emptyKey) == 0 {
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
 		return encryptedMessage, KeyNotValidError
 	}
 
+	// make sure the message, once encrypted, does not exceed the maximum size
+	msgBytes := msg.toBytes()
+	if encryptedMessageSize(len(msgBytes)) > engine.maxMessageSize() {
+		return encryptedMessage, MessageTooLargeError
+	}
+
 	// derive nonce
-	nonce, err := deriveNonce(engine.nonceKey, engine.salt, engine.context, engine.fetchAndIncrement())
+	nonce, err := engine.nextNonceContext(ctx)
 	if err != nil {
 		return encryptedMessage, err
 	}
@@ -384,44 +514,9 @@ func (engine *CryptoEngine) NewEncryptedMessageWithPubKey(msg message, verificat
 	// set the nonce to the encrypted message
 	encryptedMessage.nonce = nonce
 
-	// calculate the hash of the peer public key
-	sha224String := fmt.Sprintf("%x", sha256.Sum224(peerPublicKey[:]))
-
-	// lock the mutex
-	engine.mutex.Lock()
-
-	// check if the pre sgared key is already present in the map
-	if preSharedKey, ok := engine.preSharedKeysMap[sha224String]; ok { // means the key is there
-		// unlock the mutex
-		engine.mutex.Unlock()
-
-		// encrypt with the pre-computed key
-		encryptedData := box.SealAfterPrecomputation(nil, msg.toBytes(), &nonce, &preSharedKey)
-
-		// assign the encrypted data to the message
-		encryptedMessage.data = encryptedData
-
-	} else { // means the key is not there
-
-		// generate the key
-		// init the buffer
-		preSharedKey = [keySize]byte{}
-
-		// precompute the share key
-		box.Precompute(&preSharedKey, &peerPublicKey, &engine.privateKey)
-
-		// assign it to the map
-		engine.preSharedKeysMap[sha224String] = preSharedKey
-
-		// unlock the mutex once the map has the sharedKey set
-		engine.mutex.Unlock()
-
-		// encrypt with the pre-computed key
-		encryptedData := box.SealAfterPrecomputation(nil, msg.toBytes(), &nonce, &preSharedKey)
-
-		// assign the encrypted data to the message
-		encryptedMessage.data = encryptedData
-	}
+	// get the pre-shared key and encrypt with it
+	preSharedKey := engine.preSharedKey(peerPublicKey)
+	encryptedMessage.data = box.SealAfterPrecomputation(nil, msgBytes, &nonce, &preSharedKey)
 
 	// calculate the size of the message
 	encryptedMessage.length = uint64(len(encryptedMessage.data) + len(encryptedMessage.nonce) + 8)
@@ -431,13 +526,21 @@ func (engine *CryptoEngine) NewEncryptedMessageWithPubKey(msg message, verificat
 }
 
 // This method is used to decrypt messages where symmetrci encryption is used
-func (engine *CryptoEngine) Decrypt(encryptedBytes []byte) (*message, error) {
+func (engine *CryptoEngine) Decrypt(encryptedBytes []byte) (*Message, error) {
+	return engine.DecryptContext(context.Background(), encryptedBytes)
+}
+
+// This method works like Decrypt, the nonce is checked against the replay cache within the context deadline
+func (engine *CryptoEngine) DecryptContext(ctx context.Context, encryptedBytes []byte) (*Message, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, err
+	}
 
 	var err error
-	msg := new(message)
+	msg := new(Message)
 
 	// convert the bytes to an encrypted message
-	encryptedMessage, err := encryptedMessageFromBytes(encryptedBytes)
+	encryptedMessage, err := encryptedMessageFromBytes(encryptedBytes, engine.parseOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -449,14 +552,27 @@ func (engine *CryptoEngine) Decrypt(encryptedBytes []byte) (*message, error) {
 		return nil, MessageDecryptionError
 	}
 
+	// the nonce is remembered only once the message is authenticated
+	if err := engine.checkReplay(ctx, "secret", encryptedMessage.nonce); err != nil {
+		return nil, err
+	}
+
 	// means we successfully managed to decrypt
-	msg, err = messageFromBytes(decryptedMessageBytes)
-	return msg, nil
+	msg, err = messageFromBytes(decryptedMessageBytes, engine.parseOptions())
+	return msg, err
 
 }
 
 // This method is used to decrypt messages where symmetrci encryption is used
-func (engine *CryptoEngine) DecryptWithPublicKey(encryptedBytes []byte, verificationEngine VerificationEngine) (*message, error) {
+func (engine *CryptoEngine) DecryptWithPublicKey(encryptedBytes []byte, verificationEngine VerificationEngine) (*Message, error) {
+	return engine.DecryptWithPublicKeyContext(context.Background(), encryptedBytes, verificationEngine)
+}
+
+// This method works like DecryptWithPublicKey, the nonce is checked against the replay cache within the context deadline
+func (engine *CryptoEngine) DecryptWithPublicKeyContext(ctx context.Context, encryptedBytes []byte, verificationEngine VerificationEngine) (*Message, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, err
+	}
 
 	var err error
 
@@ -464,7 +580,7 @@ func (engine *CryptoEngine) DecryptWithPublicKey(encryptedBytes []byte, verifica
 	peerPublicKey := verificationEngine.PublicKey()
 
 	// convert the bytes to an encrypted message
-	encryptedMessage, err := encryptedMessageFromBytes(encryptedBytes)
+	encryptedMessage, err := encryptedMessageFromBytes(encryptedBytes, engine.parseOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -474,32 +590,44 @@ func (engine *CryptoEngine) DecryptWithPublicKey(encryptedBytes []byte, verifica
 		return nil, KeyNotValidError
 	}
 
+	// get the pre-shared key and decrypt with it
+	preSharedKey := engine.preSharedKey(peerPublicKey)
+	messageBytes, err := decryptWithPreShared(preSharedKey, encryptedMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := engine.checkReplay(ctx, peerReplayIdentifier(peerPublicKey), encryptedMessage.nonce); err != nil {
+		return nil, err
+	}
+	return messageFromBytes(messageBytes, engine.parseOptions())
+
+}
+
+// Returns the pre-shared key between the engine private key and the peer public key.
+// The key is computed only once per peer and cached in the preSharedKeysMap.
+func (engine *CryptoEngine) preSharedKey(peerPublicKey [keySize]byte) [keySize]byte {
+
 	// calculate the hash of the peer public key
 	sha224String := fmt.Sprintf("%x", sha256.Sum224(peerPublicKey[:]))
 
 	// lock the mutex
 	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
 
-	// check if the pre sgared key is already present in the map
+	// check if the pre shared key is already present in the map
 	if preSharedKey, ok := engine.preSharedKeysMap[sha224String]; ok { // means the key is there
-		// unlock the mutex
-		engine.mutex.Unlock()
+		return preSharedKey
+	}
 
-		messageBytes, err := decryptWithPreShared(preSharedKey, encryptedMessage)
-		if err != nil {
-			return nil, err
-		}
-		return messageFromBytes(messageBytes)
+	// precompute the shared key
+	var preSharedKey [keySize]byte
+	box.Precompute(&preSharedKey, &peerPublicKey, &engine.privateKey)
 
-	} else {
-		// otherwise decrypt with the standard box open function
-		messageBytes, valid := box.Open(nil, encryptedMessage.data, &encryptedMessage.nonce, &peerPublicKey, &engine.privateKey)
-		if !valid {
-			return nil, MessageDecryptionError
-		}
-		return messageFromBytes(messageBytes)
-	}
+	// assign it to the map
+	engine.preSharedKeysMap[sha224String] = preSharedKey
 
+	return preSharedKey
 }
 
 func decryptWithPreShared(preSharedKey [keySize]byte, m EncryptedMessage) ([]byte, error) {
@@ -54,7 +54,7 @@ func TestSecretKeyEncryption(t *testing.T) {
 	}
 
 	// parse the bytes
-	storedMessage, err := encryptedMessageFromBytes(storedData)
+	storedMessage, err := MessageFromBytes(storedData)
 	if err != nil {
 		cleanUp()
 		t.Fatal(err)
@@ -152,7 +152,7 @@ func TestPublicKeyEncryption(t *testing.T) {
 	}
 
 	// parse the bytes
-	storedMessage, err := encryptedMessageFromBytes(storedData)
+	storedMessage, err := MessageFromBytes(storedData)
 	if err != nil {
 		cleanUp()
 		t.Fatal(err)
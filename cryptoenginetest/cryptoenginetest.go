@@ -0,0 +1,67 @@
+// Package cryptoenginetest provides cryptoengine fixtures for the tests: ephemeral engines whose keys are written
+// in the temporary directory of the test, removed automatically when the test ends,
+// so the tests do not leave key files in the repository.
+//
+//	func TestExchange(t *testing.T) {
+//		alice, bob := cryptoenginetest.NewPeers(t)
+//		encrypted, err := alice.Engine.NewEncryptedMessageWithPubKey(message, bob.VerificationEngine)
+//		...
+//	}
+package cryptoenginetest
+
+import (
+	"github.com/sec51/cryptoengine"
+	"strings"
+	"testing"
+)
+
+// The Peer is an ephemeral engine and the verification engine the other peers know it by
+type Peer struct {
+	Engine             *cryptoengine.CryptoEngine
+	VerificationEngine cryptoengine.VerificationEngine // the public key and the signing public key of the engine
+}
+
+// This function returns an engine named after the test, with its keys in the temporary directory of the test
+func NewEphemeralEngine(t testing.TB) *cryptoengine.CryptoEngine {
+	t.Helper()
+	return NewEphemeralEngineWithConfig(t, t.Name(), cryptoengine.Config{})
+}
+
+// This function returns an engine with the identifier and the configuration.
+// When the configuration has no key store, the keys are stored in the temporary directory of the test.
+func NewEphemeralEngineWithConfig(t testing.TB, identifier string, config cryptoengine.Config) *cryptoengine.CryptoEngine {
+	t.Helper()
+
+	if config.KeyStore == nil {
+		store, err := cryptoengine.NewFileKeyStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.KeyStore = store
+	}
+
+	// the names of the subtests contain slashes
+	engine, err := cryptoengine.InitCryptoEngineWithConfig(strings.Replace(identifier, "/", "_", -1), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+// This function returns two ephemeral peers which know each other public keys
+func NewPeers(t testing.TB) (Peer, Peer) {
+	t.Helper()
+	return NewPeer(t, t.Name()+"_alice"), NewPeer(t, t.Name()+"_bob")
+}
+
+// This function returns an ephemeral peer with the identifier
+func NewPeer(t testing.TB, identifier string) Peer {
+	t.Helper()
+
+	engine := NewEphemeralEngineWithConfig(t, identifier, cryptoengine.Config{})
+	verificationEngine, err := cryptoengine.NewVerificationEngineWithKeys(engine.PublicKey(), engine.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Peer{Engine: engine, VerificationEngine: verificationEngine}
+}
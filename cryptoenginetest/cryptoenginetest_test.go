@@ -0,0 +1,69 @@
+package cryptoenginetest
+
+import (
+	"github.com/sec51/cryptoengine"
+	"testing"
+)
+
+func TestEphemeralEngine(t *testing.T) {
+
+	engine := NewEphemeralEngine(t)
+
+	msg, err := cryptoengine.NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := engine.Decrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text {
+		t.Fatal("The decrypted message does not match the original one")
+	}
+
+	// each test gets its own keys
+	t.Run("subtest", func(t *testing.T) {
+		if NewEphemeralEngine(t).Fingerprint() == engine.Fingerprint() {
+			t.Fatal("The engines of different tests share the same keys")
+		}
+	})
+
+}
+
+func TestPeers(t *testing.T) {
+
+	alice, bob := NewPeers(t)
+
+	msg, err := cryptoengine.NewMessage("to bob", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := alice.Engine.NewEncryptedMessageWithPubKey(msg, bob.VerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := bob.Engine.DecryptWithPublicKey(data, alice.VerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text {
+		t.Fatal("The decrypted message does not match the original one")
+	}
+
+	if err := alice.VerificationEngine.Verify([]byte("data"), alice.Engine.Sign([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+
+}
@@ -0,0 +1,168 @@
+package cryptoengine
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// Data key caching for high volume envelope encryption, as the AWS Encryption SDK caching materials manager does:
+// the data key and its wrapped copies are reused for up to maxMessages envelopes or maxAge,
+// whichever comes first, so the wrapping work is done once per batch of messages instead of once per message.
+// Each envelope still gets its own random nonce. On the decryption side the unwrapped data keys are cached with the same limits.
+// Reusing a data key means that compromising it exposes all the envelopes sealed with it: keep the limits small.
+
+const (
+	dataKeyCacheMaxEntries = 1024
+)
+
+// The DataKeyCache wraps an engine and caches the envelope data keys
+type DataKeyCache struct {
+	engine      *CryptoEngine
+	maxMessages uint64
+	maxAge      time.Duration
+	mutex       sync.Mutex
+	entries     map[[sha256.Size]byte]*cachedDataKey
+}
+
+// a data key, with the header it has been wrapped in when used for encryption
+type cachedDataKey struct {
+	dataKey  [keySize]byte
+	header   []byte
+	created  time.Time
+	messages uint64
+}
+
+// This method returns a data key cache for the engine.
+// A data key is used for at most maxMessages envelopes and for at most maxAge.
+func (engine *CryptoEngine) NewDataKeyCache(maxMessages uint64, maxAge time.Duration) *DataKeyCache {
+	return &DataKeyCache{
+		engine:      engine,
+		maxMessages: maxMessages,
+		maxAge:      maxAge,
+		entries:     make(map[[sha256.Size]byte]*cachedDataKey),
+	}
+}
+
+// This method encrypts the payload for the recipients like CryptoEngine.EncryptEnvelope,
+// reusing the cached data key of the same set of recipients when it's still valid
+func (cache *DataKeyCache) EncryptEnvelope(payload []byte, recipients ...VerificationEngine) ([]byte, error) {
+	if len(recipients) == 0 || len(recipients) > envelopeMaxRecipients {
+		return nil, EnvelopeRecipientError
+	}
+
+	// the recipients, in order, identify the cache entry
+	hash := sha256.New()
+	hash.Write([]byte("encrypt"))
+	for _, recipient := range recipients {
+		publicKey := recipient.PublicKey()
+		hash.Write(publicKey[:])
+	}
+	var id [sha256.Size]byte
+	copy(id[:], hash.Sum(nil))
+
+	cache.mutex.Lock()
+	entry := cache.get(id)
+	if entry == nil {
+		dataKey, err := GenerateDataKey()
+		if err != nil {
+			cache.mutex.Unlock()
+			return nil, err
+		}
+		header, err := cache.engine.envelopeHeader(dataKey, recipients)
+		if err != nil {
+			cache.mutex.Unlock()
+			return nil, err
+		}
+		entry = &cachedDataKey{dataKey: dataKey, header: header, created: time.Now()}
+		cache.put(id, entry)
+	}
+	entry.messages++
+	dataKey, header := entry.dataKey, entry.header
+	cache.mutex.Unlock()
+
+	return sealEnvelope(header, dataKey, payload)
+}
+
+// This method decrypts the envelope like CryptoEngine.DecryptEnvelope, reusing the data key unwrapped for the same header
+func (cache *DataKeyCache) DecryptEnvelope(envelope []byte) ([]byte, VerificationEngine, error) {
+	sender, recipients, headerSize, err := parseEnvelopeHeader(envelope)
+	if err != nil {
+		return nil, VerificationEngine{}, err
+	}
+
+	// the header, with the wrapped keys, identifies the data key
+	hash := sha256.New()
+	hash.Write([]byte("decrypt"))
+	hash.Write(envelope[:headerSize])
+	var id [sha256.Size]byte
+	copy(id[:], hash.Sum(nil))
+
+	cache.mutex.Lock()
+	entry := cache.get(id)
+	if entry == nil {
+		dataKey, err := cache.engine.unwrapEnvelopeKey(sender, recipients)
+		if err != nil {
+			cache.mutex.Unlock()
+			return nil, VerificationEngine{}, err
+		}
+		entry = &cachedDataKey{dataKey: dataKey, created: time.Now()}
+		cache.put(id, entry)
+	}
+	entry.messages++
+	dataKey := entry.dataKey
+	cache.mutex.Unlock()
+
+	payload, err := openEnvelope(envelope, headerSize, dataKey)
+	if err != nil {
+		return nil, VerificationEngine{}, err
+	}
+	return payload, sender, nil
+}
+
+// This method removes and wipes all the cached data keys
+func (cache *DataKeyCache) Clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	for id := range cache.entries {
+		cache.evict(id)
+	}
+}
+
+// returns the entry if it's still within the limits, the caller holds the mutex
+func (cache *DataKeyCache) get(id [sha256.Size]byte) *cachedDataKey {
+	entry, ok := cache.entries[id]
+	if !ok {
+		return nil
+	}
+	if entry.messages >= cache.maxMessages || time.Since(entry.created) >= cache.maxAge {
+		cache.evict(id)
+		return nil
+	}
+	return entry
+}
+
+// adds the entry, evicting the expired ones or an arbitrary one when the cache is full
+func (cache *DataKeyCache) put(id [sha256.Size]byte, entry *cachedDataKey) {
+	if len(cache.entries) >= dataKeyCacheMaxEntries {
+		for key := range cache.entries {
+			cache.get(key)
+		}
+		for key := range cache.entries {
+			if len(cache.entries) < dataKeyCacheMaxEntries {
+				break
+			}
+			cache.evict(key)
+		}
+	}
+	cache.entries[id] = entry
+}
+
+func (cache *DataKeyCache) evict(id [sha256.Size]byte) {
+	if entry, ok := cache.entries[id]; ok {
+		for i := range entry.dataKey {
+			entry.dataKey[i] = 0
+		}
+		delete(cache.entries, id)
+	}
+}
@@ -0,0 +1,89 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDataKeyCache(t *testing.T) {
+
+	sender, err := InitCryptoEngine("Sec51EnvelopeSender")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, err := InitCryptoEngine("Sec51EnvelopeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceVerificationEngine, err := NewVerificationEngine("Sec51EnvelopeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := sender.NewDataKeyCache(2, time.Hour)
+	decryptionCache := alice.NewDataKeyCache(2, time.Hour)
+
+	payload := []byte("The quick brown fox jumps over the lazy dog")
+	var envelopes [][]byte
+	for i := 0; i < 3; i++ {
+		envelope, err := cache.EncryptEnvelope(payload, aliceVerificationEngine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelopes = append(envelopes, envelope)
+
+		// the envelopes are compatible with the engine
+		decrypted, _, err := alice.DecryptEnvelope(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, payload) {
+			t.Fatal("The decrypted payload is not the original one")
+		}
+
+		if decrypted, _, err = decryptionCache.DecryptEnvelope(envelope); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, payload) {
+			t.Fatal("The decrypted payload is not the original one")
+		}
+	}
+
+	headerSize := len(envelopes[0]) - len(payload) - 24 - 16
+	if !bytes.Equal(envelopes[0][:headerSize], envelopes[1][:headerSize]) {
+		t.Fatal("The data key has not been reused")
+	}
+
+	if bytes.Equal(envelopes[1][:headerSize], envelopes[2][:headerSize]) {
+		t.Fatal("The data key has been used for more than the maximum amount of messages")
+	}
+
+	if bytes.Equal(envelopes[0][headerSize:], envelopes[1][headerSize:]) {
+		t.Fatal("The nonce has been reused")
+	}
+
+	// expired data keys are not reused
+	cache = sender.NewDataKeyCache(100, time.Nanosecond)
+	first, err := cache.EncryptEnvelope(payload, aliceVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	second, err := cache.EncryptEnvelope(payload, aliceVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(first[:headerSize], second[:headerSize]) {
+		t.Fatal("The expired data key has been reused")
+	}
+
+	cache.Clear()
+	if len(cache.entries) != 0 {
+		t.Fatal("The cache has not been cleared")
+	}
+
+}
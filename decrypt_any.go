@@ -0,0 +1,73 @@
+package cryptoengine
+
+import (
+	"errors"
+)
+
+// Decryption from a set of candidate senders: an inbound gateway serving many senders receives messages encrypted with their public keys,
+// without knowing which peer sent each one. DecryptFromAny tries the candidates with their precomputed shared keys, which are cached
+// by the engine, and reports the peer which authenticated the message. When the message has a header with a key ID, the peer with the
+// same ID is tried first, so a gateway whose senders set their key ID usually decrypts with a single attempt.
+// The key ID is only a hint: the peer is authenticated by the decryption, never by its key ID.
+
+var (
+	PeerNotFoundError = errors.New("The message has not been encrypted by any of the peers")
+)
+
+// The PeerKey struct is a candidate sender of DecryptFromAny
+type PeerKey struct {
+	ID   string             // identifier of the peer, matched with the key ID of the message header
+	Peer VerificationEngine // the public key of the peer
+}
+
+// This method decrypts a message encrypted for the engine by one of the peers, in the legacy format or with a header,
+// and returns the peer which encrypted it
+func (engine *CryptoEngine) DecryptFromAny(data []byte, peers []PeerKey) (*Message, PeerKey, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, PeerKey{}, err
+	}
+
+	decrypt := engine.DecryptWithPublicKey
+	if isHeaderMessage(data) {
+		header, _, err := parseHeader(data)
+		if err != nil {
+			return nil, PeerKey{}, err
+		}
+		if header.Suite != SuitePublicKey {
+			return nil, PeerKey{}, HeaderSuiteError
+		}
+		peers = hintedPeers(peers, string(header.KeyID))
+		decrypt = func(data []byte, verificationEngine VerificationEngine) (*Message, error) {
+			msg, _, err := engine.DecryptWithHeaderAndPublicKey(data, verificationEngine)
+			return msg, err
+		}
+	}
+
+	for _, peer := range peers {
+		msg, err := decrypt(data, peer.Peer)
+		if err == nil {
+			return msg, peer, nil
+		}
+		// the other errors do not depend on the peer, or the message has been decrypted
+		if err != MessageDecryptionError && err != KeyNotValidError {
+			return nil, PeerKey{}, err
+		}
+	}
+	return nil, PeerKey{}, PeerNotFoundError
+}
+
+// returns the peers with the peer matching the key ID first, the slice of the caller is not modified
+func hintedPeers(peers []PeerKey, keyID string) []PeerKey {
+	if keyID == "" {
+		return peers
+	}
+	for i, peer := range peers {
+		if peer.ID == keyID {
+			hinted := make([]PeerKey, 0, len(peers))
+			hinted = append(hinted, peer)
+			hinted = append(hinted, peers[:i]...)
+			return append(hinted, peers[i+1:]...)
+		}
+	}
+	return peers
+}
@@ -0,0 +1,91 @@
+package cryptoengine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDecryptFromAny(t *testing.T) {
+
+	gateway, err := InitCryptoEngineWithConfig("Sec51Gateway", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gatewayVerificationEngine, err := NewVerificationEngineWithKey(gateway.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var senders []*CryptoEngine
+	var peers []PeerKey
+	for i := 0; i < 5; i++ {
+		sender, err := InitCryptoEngineWithConfig(fmt.Sprintf("Sec51Sender%d", i), Config{KeyStore: NewMemoryKeyStore()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		verificationEngine, err := NewVerificationEngineWithKey(sender.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		senders = append(senders, sender)
+		peers = append(peers, PeerKey{ID: fmt.Sprintf("sender-%d", i), Peer: verificationEngine})
+	}
+
+	msg, err := NewMessage("telemetry", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// legacy format
+	encrypted, err := senders[3].NewEncryptedMessageWithPubKey(msg, gatewayVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, peer, err := gateway.DecryptFromAny(encryptedBytes, peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text || peer.ID != "sender-3" {
+		t.Fatalf("The message has not been attributed to its sender: %s\n", peer.ID)
+	}
+
+	// format with a header, with the key ID hint
+	data, err := senders[4].NewEncryptedMessageWithHeaderAndPubKey(msg, MessageHeader{KeyID: []byte("sender-4")}, gatewayVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, peer, err = gateway.DecryptFromAny(data, peers); err != nil || peer.ID != "sender-4" {
+		t.Fatalf("The message has not been attributed to its sender: %s %v\n", peer.ID, err)
+	}
+	if peers[0].ID != "sender-0" {
+		t.Fatal("The peers of the caller have been reordered")
+	}
+
+	// a wrong hint does not matter: the peer is authenticated by the decryption
+	data, err = senders[1].NewEncryptedMessageWithHeaderAndPubKey(msg, MessageHeader{KeyID: []byte("sender-2")}, gatewayVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, peer, err = gateway.DecryptFromAny(data, peers); err != nil || peer.ID != "sender-1" {
+		t.Fatalf("The message has not been attributed to its sender: %s %v\n", peer.ID, err)
+	}
+
+	// unknown sender
+	if _, _, err := gateway.DecryptFromAny(encryptedBytes, peers[:3]); err != PeerNotFoundError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", PeerNotFoundError, err)
+	}
+
+	// the messages encrypted with the secret key have no sender
+	secret, err := gateway.NewEncryptedMessageWithHeader(msg, MessageHeader{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := gateway.DecryptFromAny(secret, peers); err != HeaderSuiteError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", HeaderSuiteError, err)
+	}
+
+}
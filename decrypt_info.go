@@ -0,0 +1,86 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"time"
+)
+
+// Decryption with metadata: Decrypt returns only the message, DecryptWithInfo returns as well how the message was protected,
+// so the application can make policy decisions about what it just opened, for instance reject the legacy format
+// or the messages encrypted with a retired key ID. Both the legacy format and the format with a header are accepted.
+
+// The DecryptInfo struct describes a decrypted message. The fields the format does not carry have their zero value.
+type DecryptInfo struct {
+	Suite           uint8           // SuiteSecretKey or SuitePublicKey
+	Legacy          bool            // the message has the legacy format, without a header
+	Version         int             // version of the decrypted Message
+	KeyID           []byte          // the key identifier of the header
+	Nonce           [nonceSize]byte // the nonce of the message
+	SenderPublicKey [keySize]byte   // the public key of the peer which encrypted the message, zero with the secret key suite
+	Timestamp       time.Time       // the timestamp of the header
+	Sequence        uint64          // the sequence number of the header
+	Header          MessageHeader   // the whole header, for the other fields
+}
+
+// This method decrypts a message encrypted with the engine secret key, with or without a header, and describes it
+func (engine *CryptoEngine) DecryptWithInfo(data []byte) (*Message, DecryptInfo, error) {
+	if !isHeaderMessage(data) {
+		msg, err := engine.Decrypt(data)
+		if err != nil {
+			return nil, DecryptInfo{}, err
+		}
+		return msg, legacyDecryptInfo(msg, SuiteSecretKey, data, [keySize]byte{}), nil
+	}
+
+	msg, header, err := engine.DecryptWithHeader(data)
+	if err != nil {
+		return nil, DecryptInfo{}, err
+	}
+	return msg, headerDecryptInfo(msg, header, data, [keySize]byte{}), nil
+}
+
+// This method decrypts a message encrypted for the engine by the peer, with or without a header, and describes it
+func (engine *CryptoEngine) DecryptWithPublicKeyAndInfo(data []byte, verificationEngine VerificationEngine) (*Message, DecryptInfo, error) {
+	if !isHeaderMessage(data) {
+		msg, err := engine.DecryptWithPublicKey(data, verificationEngine)
+		if err != nil {
+			return nil, DecryptInfo{}, err
+		}
+		return msg, legacyDecryptInfo(msg, SuitePublicKey, data, verificationEngine.PublicKey()), nil
+	}
+
+	msg, header, err := engine.DecryptWithHeaderAndPublicKey(data, verificationEngine)
+	if err != nil {
+		return nil, DecryptInfo{}, err
+	}
+	return msg, headerDecryptInfo(msg, header, data, verificationEngine.PublicKey()), nil
+}
+
+// the legacy format starts with its length, which cannot be the magic within the maximum message size
+func isHeaderMessage(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(headerMagic))
+}
+
+// the data has already been parsed by the decryption
+func legacyDecryptInfo(msg *Message, suite uint8, data []byte, sender [keySize]byte) DecryptInfo {
+	info := DecryptInfo{Suite: suite, Legacy: true, Version: msg.Version, SenderPublicKey: sender}
+	copy(info.Nonce[:], data[8:])
+	return info
+}
+
+// the data has already been parsed by the decryption
+func headerDecryptInfo(msg *Message, header MessageHeader, data []byte, sender [keySize]byte) DecryptInfo {
+	info := DecryptInfo{
+		Suite:           header.Suite,
+		Version:         msg.Version,
+		KeyID:           header.KeyID,
+		SenderPublicKey: sender,
+		Timestamp:       header.Timestamp,
+		Sequence:        header.Sequence,
+		Header:          header,
+	}
+	if _, headerSize, err := parseHeader(data); err == nil {
+		copy(info.Nonce[:], data[headerSize:])
+	}
+	return info
+}
@@ -0,0 +1,111 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDecryptWithInfo(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Info", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := NewMessage("policy", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// legacy format
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, info, err := engine.DecryptWithInfo(encryptedBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text || !info.Legacy || info.Suite != SuiteSecretKey || info.Version != tcpVersion || info.Nonce != encrypted.nonce {
+		t.Fatalf("The info of the legacy message is not valid: %+v\n", info)
+	}
+
+	// format with a header
+	header := MessageHeader{KeyID: []byte("2024-02"), Timestamp: time.Unix(1700000000, 0), Sequence: 42}
+	data, err := engine.NewEncryptedMessageWithHeader(msg, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, info, err = engine.DecryptWithInfo(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text || info.Legacy || info.Suite != SuiteSecretKey || !bytes.Equal(info.KeyID, header.KeyID) ||
+		!info.Timestamp.Equal(header.Timestamp) || info.Sequence != 42 || info.Nonce == [nonceSize]byte{} || info.SenderPublicKey != [keySize]byte{} {
+		t.Fatalf("The info of the message with a header is not valid: %+v\n", info)
+	}
+
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 1
+	if _, _, err := engine.DecryptWithInfo(tampered); err != MessageDecryptionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageDecryptionError, err)
+	}
+
+}
+
+func TestDecryptWithPublicKeyAndInfo(t *testing.T) {
+
+	sender, err := InitCryptoEngineWithConfig("Sec51InfoSender", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := InitCryptoEngineWithConfig("Sec51InfoReceiver", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderVerificationEngine, err := NewVerificationEngineWithKey(sender.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiverVerificationEngine, err := NewVerificationEngineWithKey(receiver.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := NewMessage("policy", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := sender.NewEncryptedMessageWithPubKey(msg, receiverVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, info, err := receiver.DecryptWithPublicKeyAndInfo(encryptedBytes, senderVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Legacy || info.Suite != SuitePublicKey || info.SenderPublicKey != senderVerificationEngine.PublicKey() {
+		t.Fatalf("The info of the legacy message is not valid: %+v\n", info)
+	}
+
+	data, err := sender.NewEncryptedMessageWithHeaderAndPubKey(msg, MessageHeader{Sequence: 7}, receiverVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, info, err = receiver.DecryptWithPublicKeyAndInfo(data, senderVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Legacy || info.Suite != SuitePublicKey || info.Sequence != 7 || info.SenderPublicKey != senderVerificationEngine.PublicKey() {
+		t.Fatalf("The info of the message with a header is not valid: %+v\n", info)
+	}
+
+}
@@ -0,0 +1,184 @@
+// Package discovery finds the cryptoengine peers of the local network with mDNS and adds them to the peer registry.
+//
+// Each engine advertises its public keys, in the format of cryptoengine.DNSKeyRecord, with its fingerprint and its identifier.
+// Anyone on the local network can advertise any key, so a discovered peer is trusted only once its fingerprint has been confirmed,
+// out of band, by the user: Trust asks the Confirm function before registering each new peer.
+//
+//	advertiser, err := discovery.Advertise(engine, "laptop", 4242)
+//	defer advertiser.Close()
+//
+//	peers, err := discovery.Discover(3 * time.Second)
+//	trusted, err := discovery.Trust(store, peers, discovery.TerminalConfirm(os.Stdin, os.Stdout))
+package discovery
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/mdns"
+	"github.com/sec51/cryptoengine"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	Service = "_cryptoengine._udp" // the mDNS service of the engines
+
+	identifierField  = "id="
+	fingerprintField = "f="
+	keyRecordField   = "v="
+)
+
+var (
+	PeerRecordError     = errors.New("The advertised peer record is not valid")
+	PeerKeyChangedError = errors.New("A discovered peer advertises another key than the one registered: it has not been trusted")
+)
+
+// The Peer struct is an engine discovered on the local network. It's NOT trusted until its fingerprint is confirmed.
+type Peer struct {
+	Identifier  string                          // the identifier the peer advertises, used as the context of the peer registry
+	Fingerprint string                          // the fingerprint of the peer public key, to confirm out of band
+	Host        string                          // the host name of the peer
+	Addr        net.IP                          // the address of the peer
+	Port        int                             // the port the peer advertises
+	Engine      cryptoengine.VerificationEngine // the public keys of the peer
+}
+
+// The Advertiser advertises the engine on the local network until it's closed
+type Advertiser struct {
+	server *mdns.Server
+}
+
+// This function advertises the public keys of the engine under the identifier, with the port of the service using the engine
+func Advertise(engine *cryptoengine.CryptoEngine, identifier string, port int) (*Advertiser, error) {
+	if identifier == "" || strings.ContainsAny(identifier, ".") {
+		return nil, PeerRecordError
+	}
+
+	txt := []string{
+		engine.DNSKeyRecord(),
+		fingerprintField + engine.Fingerprint(),
+		identifierField + identifier,
+	}
+	service, err := mdns.NewMDNSService(identifier, Service, "", "", port, nil, txt)
+	if err != nil {
+		return nil, err
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		return nil, err
+	}
+	return &Advertiser{server: server}, nil
+}
+
+// This method stops advertising the engine
+func (advertiser *Advertiser) Close() error {
+	return advertiser.server.Shutdown()
+}
+
+// This function queries the local network for the advertised engines during the timeout.
+// The records which are not valid, or whose fingerprint does not match their key, are ignored.
+func Discover(timeout time.Duration) ([]Peer, error) {
+	entries := make(chan *mdns.ServiceEntry, 16)
+	params := mdns.DefaultParams(Service)
+	params.Timeout = timeout
+	params.Entries = entries
+
+	queried := make(chan error, 1)
+	go func() {
+		queried <- mdns.Query(params)
+		close(entries)
+	}()
+
+	seen := make(map[string]bool)
+	var peers []Peer
+	for entry := range entries {
+		peer, err := peerFromEntry(entry)
+		if err != nil || seen[peer.Identifier+peer.Fingerprint] {
+			continue
+		}
+		seen[peer.Identifier+peer.Fingerprint] = true
+		peers = append(peers, peer)
+	}
+	return peers, <-queried
+}
+
+// This function adds the confirmed peers to the peer registry of the store, see cryptoengine.RegisterPeer.
+// The peers already registered with the same key are skipped without asking, the peers registered with another key are never trusted.
+// It returns the peers registered, and PeerKeyChangedError when a peer advertised another key than the registered one.
+func Trust(store cryptoengine.KeyStore, peers []Peer, confirm func(Peer) bool) ([]Peer, error) {
+	var trusted []Peer
+	var keyChanged error
+	for _, peer := range peers {
+		registered, err := cryptoengine.NewVerificationEngineFromStore(store, peer.Identifier)
+		if err != nil {
+			return trusted, err
+		}
+		if registered.PublicKey() != [32]byte{} {
+			if !cryptoengine.CompareFingerprints(registered.Fingerprint(), peer.Fingerprint) {
+				keyChanged = PeerKeyChangedError
+			}
+			continue
+		}
+
+		if !confirm(peer) {
+			continue
+		}
+		// another peer with the same identifier has been registered meanwhile
+		if err := cryptoengine.RegisterPeer(store, peer.Identifier, peer.Engine); err == os.ErrExist {
+			keyChanged = PeerKeyChangedError
+			continue
+		} else if err != nil {
+			return trusted, err
+		}
+		trusted = append(trusted, peer)
+	}
+	return trusted, keyChanged
+}
+
+// This function returns a Confirm function for Trust which asks the user on the terminal to compare the fingerprint
+func TerminalConfirm(in io.Reader, out io.Writer) func(Peer) bool {
+	reader := bufio.NewReader(in)
+	return func(peer Peer) bool {
+		fmt.Fprintf(out, "Peer %q discovered at %s (%s)\nFingerprint: %s\nDoes it match the fingerprint shown by the peer? [y/N] ", peer.Identifier, peer.Host, peer.Addr, peer.Fingerprint)
+		answer, _ := reader.ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+}
+
+// parses the TXT fields of the entry, the advertised fingerprint must match the key
+func peerFromEntry(entry *mdns.ServiceEntry) (Peer, error) {
+	peer := Peer{Host: entry.Host, Addr: entry.AddrV4, Port: entry.Port}
+	if peer.Addr == nil {
+		peer.Addr = entry.AddrV6
+	}
+
+	var record string
+	for _, field := range entry.InfoFields {
+		switch {
+		case strings.HasPrefix(field, identifierField):
+			peer.Identifier = strings.TrimPrefix(field, identifierField)
+		case strings.HasPrefix(field, fingerprintField):
+			peer.Fingerprint = strings.TrimPrefix(field, fingerprintField)
+		case strings.HasPrefix(field, keyRecordField):
+			record = field
+		}
+	}
+	if peer.Identifier == "" || peer.Fingerprint == "" || record == "" {
+		return peer, PeerRecordError
+	}
+
+	engine, err := cryptoengine.ParseDNSKeyRecord(record)
+	if err != nil {
+		return peer, err
+	}
+	if !cryptoengine.CompareFingerprints(engine.Fingerprint(), peer.Fingerprint) {
+		return peer, PeerRecordError
+	}
+	peer.Engine = engine
+	return peer, nil
+}
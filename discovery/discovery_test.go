@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"bytes"
+	"github.com/hashicorp/mdns"
+	"github.com/sec51/cryptoengine"
+	"net"
+	"strings"
+	"testing"
+)
+
+func testEntry(t *testing.T, identifier string) (*mdns.ServiceEntry, *cryptoengine.CryptoEngine) {
+	engine, err := cryptoengine.InitCryptoEngineWithConfig(identifier, cryptoengine.Config{KeyStore: cryptoengine.NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &mdns.ServiceEntry{
+		Host:       identifier + ".local.",
+		AddrV4:     net.IPv4(192, 168, 1, 10),
+		Port:       4242,
+		InfoFields: []string{engine.DNSKeyRecord(), fingerprintField + engine.Fingerprint(), identifierField + identifier},
+	}
+	return entry, engine
+}
+
+func TestPeerFromEntry(t *testing.T) {
+
+	entry, engine := testEntry(t, "laptop")
+	peer, err := peerFromEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.Identifier != "laptop" || peer.Port != 4242 || !peer.Addr.Equal(entry.AddrV4) || peer.Fingerprint != engine.Fingerprint() {
+		t.Fatalf("The peer does not match the entry: %+v\n", peer)
+	}
+	if peerPublicKey := peer.Engine.PublicKey(); !bytes.Equal(peerPublicKey[:], engine.PublicKey()) {
+		t.Fatal("The peer public key does not match the advertised one")
+	}
+
+	// the fingerprint must match the key
+	_, other := testEntry(t, "other")
+	entry.InfoFields[1] = fingerprintField + other.Fingerprint()
+	if _, err := peerFromEntry(entry); err != PeerRecordError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", PeerRecordError, err)
+	}
+
+	entry.InfoFields = entry.InfoFields[:1]
+	if _, err := peerFromEntry(entry); err != PeerRecordError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", PeerRecordError, err)
+	}
+
+}
+
+func TestTrust(t *testing.T) {
+
+	var peers []Peer
+	for _, identifier := range []string{"laptop", "phone", "printer"} {
+		entry, _ := testEntry(t, identifier)
+		peer, err := peerFromEntry(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, peer)
+	}
+
+	// the user confirms the laptop and the phone only
+	store := cryptoengine.NewMemoryKeyStore()
+	var asked []string
+	confirm := func(peer Peer) bool {
+		asked = append(asked, peer.Identifier)
+		return peer.Identifier != "printer"
+	}
+	trusted, err := Trust(store, peers, confirm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trusted) != 2 || len(asked) != 3 {
+		t.Fatalf("The confirmed peers have not been trusted: %d trusted, %d asked\n", len(trusted), len(asked))
+	}
+	registered, err := cryptoengine.NewVerificationEngineFromStore(store, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered.Fingerprint() != peers[0].Fingerprint {
+		t.Fatal("The trusted peer has not been registered")
+	}
+
+	// the registered peers are not asked again, a peer advertising another key is never trusted
+	impostor, _ := testEntry(t, "laptop")
+	impostorPeer, err := peerFromEntry(impostor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asked = nil
+	trusted, err = Trust(store, append(peers[:2], impostorPeer), confirm)
+	if err != PeerKeyChangedError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", PeerKeyChangedError, err)
+	}
+	if len(trusted) != 0 || len(asked) != 0 {
+		t.Fatalf("The registered peers have been asked again: %v\n", asked)
+	}
+
+}
+
+func TestTerminalConfirm(t *testing.T) {
+
+	entry, _ := testEntry(t, "laptop")
+	peer, err := peerFromEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	confirm := TerminalConfirm(strings.NewReader("yes\nn\n"), &out)
+	if !confirm(peer) {
+		t.Fatal("The peer has not been confirmed")
+	}
+	if confirm(peer) {
+		t.Fatal("The peer has been confirmed")
+	}
+	if !strings.Contains(out.String(), peer.Fingerprint) {
+		t.Fatal("The fingerprint has not been shown")
+	}
+
+}
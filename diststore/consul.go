@@ -0,0 +1,43 @@
+package diststore
+
+import (
+	"context"
+	"github.com/hashicorp/consul/api"
+)
+
+// Consul implementation of the KV interface: the version of a key is its modify index
+type consulKV struct {
+	kv *api.KV
+}
+
+// This function returns the KV interface of the Consul client, for instance NewConsulKV(client.KV())
+func NewConsulKV(kv *api.KV) KV {
+	return &consulKV{kv: kv}
+}
+
+func (store *consulKV) Get(ctx context.Context, key string) ([]byte, uint64, error) {
+	// the reads must be consistent, otherwise a stale counter would be swapped in vain
+	pair, _, err := store.kv.Get(key, (&api.QueryOptions{RequireConsistent: true}).WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	if pair == nil {
+		return nil, 0, nil
+	}
+	// consul returns nil for empty values
+	if pair.Value == nil {
+		return []byte{}, pair.ModifyIndex, nil
+	}
+	return pair.Value, pair.ModifyIndex, nil
+}
+
+// a modify index of 0 makes consul store the value only if the key does not exist
+func (store *consulKV) CompareAndSwap(ctx context.Context, key string, value []byte, version uint64) (bool, error) {
+	stored, _, err := store.kv.CAS(&api.KVPair{Key: key, Value: value, ModifyIndex: version}, (&api.WriteOptions{}).WithContext(ctx))
+	return stored, err
+}
+
+func (store *consulKV) Delete(ctx context.Context, key string) error {
+	_, err := store.kv.Delete(key, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
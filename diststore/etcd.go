@@ -0,0 +1,46 @@
+package diststore
+
+import (
+	"context"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcd implementation of the KV interface: the version of a key is its modification revision
+type etcdKV struct {
+	kv clientv3.KV
+}
+
+// This function returns the KV interface of the etcd client
+func NewEtcdKV(kv clientv3.KV) KV {
+	return &etcdKV{kv: kv}
+}
+
+func (store *etcdKV) Get(ctx context.Context, key string) ([]byte, uint64, error) {
+	response, err := store.kv.Get(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(response.Kvs) == 0 {
+		return nil, 0, nil
+	}
+	return response.Kvs[0].Value, uint64(response.Kvs[0].ModRevision), nil
+}
+
+func (store *etcdKV) CompareAndSwap(ctx context.Context, key string, value []byte, version uint64) (bool, error) {
+	// a key which does not exist has a creation revision of 0
+	condition := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	if version != 0 {
+		condition = clientv3.Compare(clientv3.ModRevision(key), "=", int64(version))
+	}
+
+	response, err := store.kv.Txn(ctx).If(condition).Then(clientv3.OpPut(key, string(value))).Commit()
+	if err != nil {
+		return false, err
+	}
+	return response.Succeeded, nil
+}
+
+func (store *etcdKV) Delete(ctx context.Context, key string) error {
+	_, err := store.kv.Delete(ctx, key)
+	return err
+}
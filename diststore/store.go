@@ -0,0 +1,147 @@
+// Package diststore provides a cryptoengine key store and counter store backed by a distributed key value store, etcd or Consul.
+//
+// A fleet of stateless instances sharing one logical identity initializes its engines with the same store:
+// the first instance generates and stores the keys, the others load them.
+// Each instance reserves its own ranges of nonce counters with a compare and swap on the shared counter,
+// so two instances never use the same nonce.
+//
+//	store := diststore.New(diststore.NewEtcdKV(client), "cryptoengine/")
+//	engine, err := cryptoengine.InitCryptoEngineWithConfig("app", cryptoengine.Config{KeyStore: store, CounterStore: store})
+package diststore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/sec51/cryptoengine"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	keysPrefix     = "keys/"
+	countersPrefix = "counters/"
+	requestTimeout = 10 * time.Second // maximum duration of an operation, including the compare and swap retries
+)
+
+var (
+	CounterFormatError = errors.New("The stored counter is not valid")
+)
+
+// The KV interface is the subset of a distributed key value store used by the Store
+type KV interface {
+	// returns the value and its version, or a nil value when the key does not exist
+	Get(ctx context.Context, key string) ([]byte, uint64, error)
+	// stores the value only if the current version of the key matches: version 0 means the key must not exist.
+	// Returns false when the version does not match.
+	CompareAndSwap(ctx context.Context, key string, value []byte, version uint64) (bool, error)
+	// deletes the key
+	Delete(ctx context.Context, key string) error
+}
+
+// The Store implements the cryptoengine.KeyStore and cryptoengine.CounterStore interfaces
+type Store struct {
+	kv     KV
+	prefix string
+}
+
+// This function returns a store which keeps the keys and the counters under the prefix of the key value store
+func New(kv KV, prefix string) *Store {
+	return &Store{kv: kv, prefix: prefix}
+}
+
+// This method returns the key or cryptoengine.KeyNotFoundError
+func (store *Store) ReadKey(name string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return store.ReadKeyContext(ctx, name)
+}
+
+// This method returns the key or cryptoengine.KeyNotFoundError, within the context deadline
+func (store *Store) ReadKeyContext(ctx context.Context, name string) ([]byte, error) {
+	key, _, err := store.kv.Get(ctx, store.prefix+keysPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, cryptoengine.KeyNotFoundError
+	}
+	return key, nil
+}
+
+// This method stores the key, unless another instance already stored it
+func (store *Store) WriteKey(name string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return store.WriteKeyContext(ctx, name, data)
+}
+
+// This method stores the key, unless another instance already stored it, within the context deadline
+func (store *Store) WriteKeyContext(ctx context.Context, name string, data []byte) error {
+	stored, err := store.kv.CompareAndSwap(ctx, store.prefix+keysPrefix+name, data, 0)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return os.ErrExist
+	}
+	return nil
+}
+
+// This method deletes the key
+func (store *Store) DeleteKey(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return store.DeleteKeyContext(ctx, name)
+}
+
+// This method deletes the key within the context deadline
+func (store *Store) DeleteKeyContext(ctx context.Context, name string) error {
+	return store.kv.Delete(ctx, store.prefix+keysPrefix+name)
+}
+
+// This method reserves n counters for the engine context.
+// The counter is stored as an 8 bytes big endian integer and swapped until no other instance has changed it meanwhile.
+func (store *Store) ReserveCounters(name string, n uint64) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return store.ReserveCountersContext(ctx, name, n)
+}
+
+// This method reserves n counters for the engine context, retrying the compare and swap until the context is done
+func (store *Store) ReserveCountersContext(ctx context.Context, name string, n uint64) (uint64, error) {
+	key := store.prefix + countersPrefix + name
+	for {
+		value, version, err := store.kv.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		var first uint64
+		if value != nil {
+			if len(value) != 8 {
+				return 0, CounterFormatError
+			}
+			first = binary.BigEndian.Uint64(value)
+		}
+		if first > math.MaxUint64-n {
+			return 0, cryptoengine.CounterOverflowError
+		}
+
+		var next [8]byte
+		binary.BigEndian.PutUint64(next[:], first+n)
+		swapped, err := store.kv.CompareAndSwap(ctx, key, next[:], version)
+		if err != nil {
+			return 0, err
+		}
+		if swapped {
+			return first, nil
+		}
+
+		// another instance reserved a range meanwhile
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package diststore
+
+import (
+	"bytes"
+	"context"
+	"github.com/sec51/cryptoengine"
+	"os"
+	"sync"
+	"testing"
+)
+
+// make sure the store implements the cryptoengine interfaces
+var (
+	_ cryptoengine.ContextKeyStore     = &Store{}
+	_ cryptoengine.ContextCounterStore = &Store{}
+)
+
+type memoryValue struct {
+	data    []byte
+	version uint64
+}
+
+// in memory KV with the compare and swap semantics of etcd and Consul
+type memoryKV struct {
+	mutex    sync.Mutex
+	values   map[string]memoryValue
+	revision uint64
+}
+
+func newMemoryKV() *memoryKV {
+	return &memoryKV{values: make(map[string]memoryValue)}
+}
+
+func (kv *memoryKV) Get(ctx context.Context, key string) ([]byte, uint64, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	value, ok := kv.values[key]
+	if !ok {
+		return nil, 0, nil
+	}
+	return append([]byte{}, value.data...), value.version, nil
+}
+
+func (kv *memoryKV) CompareAndSwap(ctx context.Context, key string, data []byte, version uint64) (bool, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	if kv.values[key].version != version {
+		return false, nil
+	}
+	kv.revision++
+	kv.values[key] = memoryValue{data: append([]byte{}, data...), version: kv.revision}
+	return true, nil
+}
+
+func (kv *memoryKV) Delete(ctx context.Context, key string) error {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	delete(kv.values, key)
+	return nil
+}
+
+func TestStore(t *testing.T) {
+
+	kv := newMemoryKV()
+
+	// two instances sharing the same identity
+	first := New(kv, "cryptoengine/")
+	firstEngine, err := cryptoengine.InitCryptoEngineWithConfig("Sec51Fleet", cryptoengine.Config{KeyStore: first, CounterStore: first})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := New(kv, "cryptoengine/")
+	secondEngine, err := cryptoengine.InitCryptoEngineWithConfig("Sec51Fleet", cryptoengine.Config{KeyStore: second, CounterStore: second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(firstEngine.PublicKey(), secondEngine.PublicKey()) {
+		t.Fatal("The instances have not loaded the same keys")
+	}
+
+	// a message encrypted by an instance is decrypted by the other
+	msg, err := cryptoengine.NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := firstEngine.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secondEngine.Decrypt(encryptedBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.WriteKey("sec51fleet_public.key", make([]byte, 32)); err != os.ErrExist {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", os.ErrExist, err)
+	}
+
+	if err := first.DeleteKey("sec51fleet_public.key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.ReadKey("sec51fleet_public.key"); err != cryptoengine.KeyNotFoundError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", cryptoengine.KeyNotFoundError, err)
+	}
+}
+
+func TestStoreCounters(t *testing.T) {
+
+	kv := newMemoryKV()
+	instances := []*Store{New(kv, "a/"), New(kv, "a/"), New(kv, "a/"), New(kv, "a/")}
+
+	// the instances reserve ranges concurrently: no range can be reserved twice
+	var mutex sync.Mutex
+	reserved := make(map[uint64]bool)
+
+	var wg sync.WaitGroup
+	for _, store := range instances {
+		wg.Add(1)
+		go func(store *Store) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				first, err := store.ReserveCounters("sec51fleet", 1024)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mutex.Lock()
+				if reserved[first] {
+					t.Errorf("The counter range starting at %d has been reserved twice\n", first)
+				}
+				reserved[first] = true
+				mutex.Unlock()
+			}
+		}(store)
+	}
+	wg.Wait()
+
+	if len(reserved) != 200 {
+		t.Fatal("The counter ranges have not been reserved")
+	}
+
+	if _, err := instances[0].ReserveCounters("sec51fleet", ^uint64(0)); err != cryptoengine.CounterOverflowError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", cryptoengine.CounterOverflowError, err)
+	}
+
+	// the counters are isolated by prefix
+	if first, err := New(kv, "b/").ReserveCounters("sec51fleet", 1); err != nil || first != 0 {
+		t.Fatal("The counters of another prefix have been shared")
+	}
+}
@@ -0,0 +1,362 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Peer key discovery via DNS.
+// The keys are published, DKIM style, in a TXT record at _cryptoengine.<hostname>:
+// v=ce1; k=<base64 X25519 public key>; s=<base64 Ed25519 signing public key>
+// The signing key is optional.
+//
+// When the KeyResolver has a Server, the query is sent directly to it with the DNSSEC OK bit set
+// and the Authenticated Data bit of the response tells whether the records were DNSSEC validated.
+// The server must be a trusted validating resolver, ideally on the loopback interface,
+// because the AD bit itself is not authenticated on the wire.
+
+const (
+	dnsKeyPrefix     = "_cryptoengine."
+	dnsRecordVersion = "ce1"
+	dnsDefaultPort   = "53"
+	dnsTimeout       = 5 * time.Second
+
+	dnsTypeTXT   = 16
+	dnsTypeOPT   = 41
+	dnsClassIN   = 1
+	dnsUDPSize   = 4096
+	dnsHeaderLen = 12
+
+	// header flags
+	dnsFlagResponse  = 1 << 15
+	dnsFlagTruncated = 1 << 9
+	dnsFlagRecursion = 1 << 8
+	dnsFlagAD        = 1 << 5
+	dnsFlagDO        = 1 << 15 // in the OPT record TTL
+
+	dnsRcodeNXDomain = 3
+)
+
+var (
+	DNSKeyNotFoundError = errors.New("No cryptoengine key record has been found for the hostname")
+	DNSRecordError      = errors.New("The cryptoengine key record is not valid")
+	DNSResponseError    = errors.New("The DNS response is not valid")
+	DNSSECError         = errors.New("The DNS response has not been DNSSEC validated")
+)
+
+// The KeyResolver fetches the peer keys published in DNS.
+// The zero value uses the system resolver, without DNSSEC validation.
+type KeyResolver struct {
+	Server        string        // address of a DNSSEC validating resolver, e.g. 127.0.0.1:53. Empty means the system resolver
+	RequireDNSSEC bool          // reject the responses without the Authenticated Data bit. Requires Server
+	Timeout       time.Duration // timeout of each query. Zero means 5 seconds
+}
+
+// This method fetches the keys of the hostname and returns the verification engine holding them
+func (resolver KeyResolver) Resolve(hostname string) (VerificationEngine, error) {
+
+	name := dnsKeyPrefix + strings.TrimSuffix(hostname, ".")
+
+	var records []string
+	var err error
+	if resolver.Server == "" {
+		if resolver.RequireDNSSEC {
+			return VerificationEngine{}, DNSSECError
+		}
+		if records, err = net.LookupTXT(name); err != nil {
+			if dnsError, ok := err.(*net.DNSError); ok && dnsError.IsNotFound {
+				return VerificationEngine{}, DNSKeyNotFoundError
+			}
+			return VerificationEngine{}, err
+		}
+	} else {
+		var authenticated bool
+		if records, authenticated, err = resolver.lookupTXT(name); err != nil {
+			return VerificationEngine{}, err
+		}
+		if resolver.RequireDNSSEC && !authenticated {
+			return VerificationEngine{}, DNSSECError
+		}
+	}
+
+	// only one key record per hostname is allowed, other TXT records are ignored
+	var engine VerificationEngine
+	found := false
+	for _, record := range records {
+		if !strings.HasPrefix(record, "v="+dnsRecordVersion+";") {
+			continue
+		}
+		if found {
+			return VerificationEngine{}, DNSRecordError
+		}
+		if engine, err = parseDNSKeyRecord(record); err != nil {
+			return VerificationEngine{}, err
+		}
+		found = true
+	}
+
+	if !found {
+		return VerificationEngine{}, DNSKeyNotFoundError
+	}
+	return engine, nil
+}
+
+// This method resolves the keys of the hostname and encrypts the message for it, like NewEncryptedMessageWithPubKey
+func (engine *CryptoEngine) NewEncryptedMessageForHost(msg Message, hostname string, resolver KeyResolver) (EncryptedMessage, error) {
+	verificationEngine, err := resolver.Resolve(hostname)
+	if err != nil {
+		return EncryptedMessage{}, err
+	}
+	return engine.NewEncryptedMessageWithPubKey(msg, verificationEngine)
+}
+
+// This method returns the TXT record value to publish at _cryptoengine.<hostname>
+func (engine *CryptoEngine) DNSKeyRecord() string {
+	return "v=" + dnsRecordVersion +
+		"; k=" + base64.StdEncoding.EncodeToString(engine.publicKey[:]) +
+		"; s=" + base64.StdEncoding.EncodeToString(engine.SigningPublicKey())
+}
+
+// This function parses a record returned by DNSKeyRecord, for instance advertised by another transport than DNS
+func ParseDNSKeyRecord(record string) (VerificationEngine, error) {
+	return parseDNSKeyRecord(record)
+}
+
+// parses the tag=value list of the record
+func parseDNSKeyRecord(record string) (VerificationEngine, error) {
+	tags := map[string]string{}
+	for _, field := range strings.Split(record, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return VerificationEngine{}, DNSRecordError
+		}
+		tag := strings.TrimSpace(parts[0])
+		if _, duplicated := tags[tag]; duplicated {
+			return VerificationEngine{}, DNSRecordError
+		}
+		tags[tag] = strings.TrimSpace(parts[1])
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(tags["k"])
+	if err != nil || len(publicKey) != keySize {
+		return VerificationEngine{}, DNSRecordError
+	}
+
+	signing, ok := tags["s"]
+	if !ok {
+		engine, err := NewVerificationEngineWithKey(publicKey)
+		if err != nil {
+			return engine, DNSRecordError
+		}
+		return engine, nil
+	}
+
+	signingPublicKey, err := base64.StdEncoding.DecodeString(signing)
+	if err != nil || len(signingPublicKey) != keySize {
+		return VerificationEngine{}, DNSRecordError
+	}
+
+	engine, err := NewVerificationEngineWithKeys(publicKey, signingPublicKey)
+	if err != nil {
+		return engine, DNSRecordError
+	}
+	return engine, nil
+}
+
+// queries the server for the TXT records of the name, over UDP and over TCP when the response is truncated
+// it returns the records and whether the response had the Authenticated Data bit set
+func (resolver KeyResolver) lookupTXT(name string) ([]string, bool, error) {
+
+	server := resolver.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, dnsDefaultPort)
+	}
+
+	timeout := resolver.Timeout
+	if timeout == 0 {
+		timeout = dnsTimeout
+	}
+
+	query, id, err := dnsQuery(name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	response, err := dnsExchange("udp", server, query, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(response) >= dnsHeaderLen && binary.BigEndian.Uint16(response[2:])&dnsFlagTruncated != 0 {
+		if response, err = dnsExchange("tcp", server, query, timeout); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return dnsParseTXT(response, id)
+}
+
+// sends the query and reads the response, TCP messages are prefixed by their length
+func dnsExchange(network, server string, query []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		response := make([]byte, dnsUDPSize)
+		n, err := conn.Read(response)
+		if err != nil {
+			return nil, err
+		}
+		return response[:n], nil
+	}
+
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(query)))
+	if _, err := conn.Write(append(length, query...)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// builds the TXT query with an OPT record setting the DNSSEC OK bit
+func dnsQuery(name string) ([]byte, uint16, error) {
+	idBytes := make([]byte, 2)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes)
+
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.BigEndian, []uint16{id, dnsFlagRecursion | dnsFlagAD, 1, 0, 0, 1})
+
+	// question
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, DNSRecordError
+		}
+		buffer.WriteByte(byte(len(label)))
+		buffer.WriteString(label)
+	}
+	buffer.WriteByte(0)
+	binary.Write(&buffer, binary.BigEndian, []uint16{dnsTypeTXT, dnsClassIN})
+
+	// OPT pseudo record: root name, type, UDP payload size, extended rcode and flags, no data
+	buffer.WriteByte(0)
+	binary.Write(&buffer, binary.BigEndian, []uint16{dnsTypeOPT, dnsUDPSize, 0, dnsFlagDO, 0})
+
+	return buffer.Bytes(), id, nil
+}
+
+// parses the response and returns the TXT records of the answer section
+// the character strings of each record are concatenated
+func dnsParseTXT(response []byte, id uint16) ([]string, bool, error) {
+	if len(response) < dnsHeaderLen {
+		return nil, false, DNSResponseError
+	}
+
+	flags := binary.BigEndian.Uint16(response[2:])
+	if binary.BigEndian.Uint16(response) != id || flags&dnsFlagResponse == 0 {
+		return nil, false, DNSResponseError
+	}
+
+	switch flags & 0xf {
+	case 0:
+	case dnsRcodeNXDomain:
+		return nil, false, DNSKeyNotFoundError
+	default:
+		return nil, false, DNSResponseError
+	}
+
+	questions := binary.BigEndian.Uint16(response[4:])
+	answers := binary.BigEndian.Uint16(response[6:])
+	offset := dnsHeaderLen
+
+	var err error
+	for i := uint16(0); i < questions; i++ {
+		if offset, err = dnsSkipName(response, offset); err != nil {
+			return nil, false, err
+		}
+		offset += 4
+	}
+
+	var records []string
+	for i := uint16(0); i < answers; i++ {
+		if offset, err = dnsSkipName(response, offset); err != nil {
+			return nil, false, err
+		}
+		if offset+10 > len(response) {
+			return nil, false, DNSResponseError
+		}
+		recordType := binary.BigEndian.Uint16(response[offset:])
+		length := int(binary.BigEndian.Uint16(response[offset+8:]))
+		offset += 10
+		if offset+length > len(response) {
+			return nil, false, DNSResponseError
+		}
+
+		// CNAME and RRSIG records are skipped
+		if recordType == dnsTypeTXT {
+			var record strings.Builder
+			data := response[offset : offset+length]
+			for len(data) > 0 {
+				n := int(data[0])
+				if 1+n > len(data) {
+					return nil, false, DNSResponseError
+				}
+				record.Write(data[1 : 1+n])
+				data = data[1+n:]
+			}
+			records = append(records, record.String())
+		}
+		offset += length
+	}
+
+	return records, flags&dnsFlagAD != 0, nil
+}
+
+// returns the offset after the name, compression pointers end the name
+func dnsSkipName(response []byte, offset int) (int, error) {
+	for {
+		if offset >= len(response) {
+			return 0, DNSResponseError
+		}
+		length := int(response[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			if offset+2 > len(response) {
+				return 0, DNSResponseError
+			}
+			return offset + 2, nil
+		case length&0xc0 != 0:
+			return 0, DNSResponseError
+		}
+		offset += 1 + length
+	}
+}
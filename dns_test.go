@@ -0,0 +1,170 @@
+package cryptoengine
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// starts a DNS server answering every query with the TXT records, it returns the server address
+func startTestDNSServer(t *testing.T, records []string, authenticated bool, rcode uint16) string {
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer conn.Close()
+		query := make([]byte, dnsUDPSize)
+		n, addr, err := conn.ReadFrom(query)
+		if err != nil {
+			return
+		}
+		query = query[:n]
+
+		// the question ends after the name, type and class
+		questionEnd, err := dnsSkipName(query, dnsHeaderLen)
+		if err != nil {
+			return
+		}
+		questionEnd += 4
+
+		flags := uint16(dnsFlagResponse|dnsFlagRecursion|1<<7) | rcode
+		if authenticated {
+			flags |= dnsFlagAD
+		}
+
+		response := make([]byte, dnsHeaderLen)
+		binary.BigEndian.PutUint16(response, binary.BigEndian.Uint16(query))
+		binary.BigEndian.PutUint16(response[2:], flags)
+		binary.BigEndian.PutUint16(response[4:], 1)
+		binary.BigEndian.PutUint16(response[6:], uint16(len(records)))
+		response = append(response, query[dnsHeaderLen:questionEnd]...)
+
+		for _, record := range records {
+			// split in character strings of at most 255 bytes
+			var data []byte
+			for len(record) > 0 {
+				n := len(record)
+				if n > 255 {
+					n = 255
+				}
+				data = append(data, byte(n))
+				data = append(data, record[:n]...)
+				record = record[n:]
+			}
+
+			answer := []byte{0xc0, dnsHeaderLen}
+			answer = append(answer, 0, dnsTypeTXT, 0, dnsClassIN, 0, 0, 1, 0)
+			answer = append(answer, byte(len(data)>>8), byte(len(data)))
+			response = append(response, answer...)
+			response = append(response, data...)
+		}
+
+		conn.WriteTo(response, addr)
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestDNSKeyResolver(t *testing.T) {
+
+	peer, err := InitCryptoEngine("Sec51DNSPeer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender, err := InitCryptoEngine("Sec51DNSSender")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	senderVerificationEngine, err := NewVerificationEngine("Sec51DNSSender")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []string{"google-site-verification=abc", peer.DNSKeyRecord()}
+	resolver := KeyResolver{Server: startTestDNSServer(t, records, true, 0), RequireDNSSEC: true}
+
+	message, err := NewMessage("The quick brown fox jumps over the lazy dog", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedMessage, err := sender.NewEncryptedMessageForHost(message, "peer.sec51.com", resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messageBytes, err := encryptedMessage.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := peer.DecryptWithPublicKey(messageBytes, senderVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decrypted.Text != message.Text {
+		t.Fatal("The decrypted message is not the original one")
+	}
+
+	// the signing key is published too
+	resolver.Server = startTestDNSServer(t, records, true, 0)
+	verificationEngine, err := resolver.Resolve("peer.sec51.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verificationEngine.Verify([]byte("data"), peer.Sign([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestDNSKeyResolverErrors(t *testing.T) {
+
+	peer, err := InitCryptoEngine("Sec51DNSPeer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not DNSSEC validated
+	resolver := KeyResolver{Server: startTestDNSServer(t, []string{peer.DNSKeyRecord()}, false, 0), RequireDNSSEC: true}
+	if _, err := resolver.Resolve("peer.sec51.com"); err != DNSSECError {
+		t.Errorf("The expected error is: DNSSECError, instead we've got: %v\n", err)
+	}
+
+	// DNSSEC requires a validating resolver
+	if _, err := (KeyResolver{RequireDNSSEC: true}).Resolve("peer.sec51.com"); err != DNSSECError {
+		t.Errorf("The expected error is: DNSSECError, instead we've got: %v\n", err)
+	}
+
+	// the hostname does not exist
+	resolver = KeyResolver{Server: startTestDNSServer(t, nil, true, dnsRcodeNXDomain)}
+	if _, err := resolver.Resolve("peer.sec51.com"); err != DNSKeyNotFoundError {
+		t.Errorf("The expected error is: DNSKeyNotFoundError, instead we've got: %v\n", err)
+	}
+
+	// no key record
+	resolver = KeyResolver{Server: startTestDNSServer(t, []string{"v=spf1 -all"}, true, 0)}
+	if _, err := resolver.Resolve("peer.sec51.com"); err != DNSKeyNotFoundError {
+		t.Errorf("The expected error is: DNSKeyNotFoundError, instead we've got: %v\n", err)
+	}
+
+	// invalid key
+	resolver = KeyResolver{Server: startTestDNSServer(t, []string{"v=ce1; k=AAAA"}, true, 0)}
+	if _, err := resolver.Resolve("peer.sec51.com"); err != DNSRecordError {
+		t.Errorf("The expected error is: DNSRecordError, instead we've got: %v\n", err)
+	}
+
+	// more than one key record
+	resolver = KeyResolver{Server: startTestDNSServer(t, []string{peer.DNSKeyRecord(), peer.DNSKeyRecord()}, true, 0)}
+	if _, err := resolver.Resolve("peer.sec51.com"); err != DNSRecordError {
+		t.Errorf("The expected error is: DNSRecordError, instead we've got: %v\n", err)
+	}
+
+}
@@ -0,0 +1,90 @@
+//go:build go1.20
+// +build go1.20
+
+package cryptoengine
+
+import (
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Interoperability with the crypto/ecdh package of the standard library: the engine asymmetric keys are X25519 keys,
+// so they convert to and from *ecdh.PublicKey and *ecdh.PrivateKey of the ecdh.X25519 curve without any change.
+// ecdh.PrivateKey.ECDH with the peer key computes the same shared secret NaCl box computes before hashing it.
+
+var (
+	ECDHCurveError = errors.New("The ECDH key is not an X25519 key")
+)
+
+// This method returns the engine public key as an X25519 *ecdh.PublicKey
+func (engine *CryptoEngine) ECDHPublicKey() (*ecdh.PublicKey, error) {
+	return ecdh.X25519().NewPublicKey(engine.publicKey[:])
+}
+
+// This method returns the engine private key as an X25519 *ecdh.PrivateKey
+func (engine *CryptoEngine) ECDHPrivateKey() (*ecdh.PrivateKey, error) {
+	if err := engine.allow(operationOwnKeys); err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(engine.privateKey[:])
+}
+
+// This method returns the peer public key as an X25519 *ecdh.PublicKey
+func (e VerificationEngine) ECDHPublicKey() (*ecdh.PublicKey, error) {
+	return ecdh.X25519().NewPublicKey(e.publicKey[:])
+}
+
+// This function instantiates the verification engine with an X25519 *ecdh.PublicKey
+func NewVerificationEngineWithECDH(publicKey *ecdh.PublicKey) (VerificationEngine, error) {
+	if publicKey == nil || publicKey.Curve() != ecdh.X25519() {
+		return VerificationEngine{}, ECDHCurveError
+	}
+	return NewVerificationEngineWithKey(publicKey.Bytes())
+}
+
+// This function stores an X25519 *ecdh.PrivateKey and its public key as the asymmetric keys of the communication identifier,
+// so InitCryptoEngineWithConfig loads them instead of generating new ones.
+// If the keys already exist os.ErrExist is returned. With a ManifestKey, the other key files are generated and the manifest is updated.
+func ImportECDHPrivateKey(communicationIdentifier string, config Config, privateKey *ecdh.PrivateKey) error {
+	if privateKey == nil || privateKey.Curve() != ecdh.X25519() {
+		return ECDHCurveError
+	}
+	if config.MasterKey != nil && len(config.MasterKey) < minMasterKeySize {
+		return MasterKeyError
+	}
+	if config.ManifestKey != nil && len(config.ManifestKey) < minManifestKeySize {
+		return ManifestKeyError
+	}
+
+	id, err := keyContext(communicationIdentifier)
+	if err != nil {
+		return err
+	}
+	store := config.keyStore()
+	files := []struct {
+		format string
+		data   []byte
+	}{
+		{publicKeySuffixFormat, privateKey.PublicKey().Bytes()},
+		{privateSuffixFormat, privateKey.Bytes()},
+	}
+
+	// check first, so we do not end up with only one of the keys written
+	for _, file := range files {
+		if _, err := store.ReadKey(fmt.Sprintf(file.format, id)); err != KeyNotFoundError {
+			if err == nil {
+				return os.ErrExist
+			}
+			return err
+		}
+	}
+
+	for _, file := range files {
+		if err := store.WriteKey(fmt.Sprintf(file.format, id), file.data); err != nil {
+			return err
+		}
+	}
+	return updateImportManifest(store, id, config)
+}
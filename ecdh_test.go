@@ -0,0 +1,113 @@
+//go:build go1.20
+// +build go1.20
+
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"os"
+	"testing"
+)
+
+func TestECDHKeys(t *testing.T) {
+
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{KeyStore: NewMemoryKeyStore()}
+	if err := ImportECDHPrivateKey("Sec51ECDH", config, privateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportECDHPrivateKey("Sec51ECDH", config, privateKey); err != os.ErrExist {
+		t.Fatalf("Expected %v, got %v\n", os.ErrExist, err)
+	}
+
+	engine, err := InitCryptoEngineWithConfig("Sec51ECDH", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(engine.PublicKey(), privateKey.PublicKey().Bytes()) {
+		t.Fatal("The imported key pair has not been loaded")
+	}
+	exported, err := engine.ECDHPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exported.Equal(privateKey) {
+		t.Fatal("The exported private key does not match the imported one")
+	}
+
+	// the peer keys and the shared secrets match box
+	peer, err := InitCryptoEngineWithConfig("Sec51ECDHPeer", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerPublicKey, err := peer.ECDHPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verificationEngine, err := NewVerificationEngineWithECDH(peerPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKey, err := verificationEngine.ECDHPublicKey(); err != nil || !publicKey.Equal(peerPublicKey) {
+		t.Fatalf("The peer public key does not round trip: %v\n", err)
+	}
+
+	msg, err := NewMessage("X25519", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessageWithPubKey(msg, verificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engineKey, err := NewVerificationEngineWithKey(privateKey.PublicKey().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := peer.DecryptWithPublicKey(encryptedBytes, engineKey); err != nil || decrypted.Text != msg.Text {
+		t.Fatalf("The imported keys do not work with box: %v\n", err)
+	}
+
+	// only the X25519 keys are accepted
+	p256, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewVerificationEngineWithECDH(p256.PublicKey()); err != ECDHCurveError {
+		t.Fatalf("Expected %v, got %v\n", ECDHCurveError, err)
+	}
+	if err := ImportECDHPrivateKey("Sec51ECDHP256", config, p256); err != ECDHCurveError {
+		t.Fatalf("Expected %v, got %v\n", ECDHCurveError, err)
+	}
+
+}
+
+func TestImportECDHPrivateKeyWithManifest(t *testing.T) {
+
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{KeyStore: NewMemoryKeyStore(), ManifestKey: []byte("Sec51 ECDH manifest key")}
+	if err := ImportECDHPrivateKey("Sec51ECDHManifest", config, privateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := InitCryptoEngineWithConfig("Sec51ECDHManifest", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(engine.PublicKey(), privateKey.PublicKey().Bytes()) {
+		t.Fatal("The imported key pair has not been loaded")
+	}
+
+}
@@ -0,0 +1,253 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"os"
+	"strings"
+)
+
+// Key files encrypted at rest: the EncryptedKeyStore encrypts each key under a master key before storing it into another key store,
+// so a leaked keys folder alone is useless. The master key is kept outside the keys folder: in the environment (MasterKeyFromEnv),
+// in a KMS (MasterKeyFromKMS) or derived from a passphrase (MasterKeyFromPassphrase). Config.MasterKey encrypts the keys of an engine.
+// Each stored key is:
+// |magic|      => 4 bytes (0x89 CEE)
+// |version|    => 1 byte
+// |nonce|      => 24 bytes
+// |ciphertext| => XChaCha20-Poly1305 of the key, authenticating the name of the key, so the keys cannot be swapped
+// The encryption key is derived with HKDF-SHA-256 from the master key.
+
+const (
+	encryptedKeyMagic     = "\x89CEE"
+	encryptedKeyVersion   = 1
+	encryptedKeyLabel     = "cryptoengine key store encryption"
+	minMasterKeySize      = 16
+	masterKeySaltName     = "master_salt.key" // the salt of the passphrase master key, it's not secret and it's stored in clear
+	MasterKeyEnv          = "SEC51_MASTER_KEY"
+	encryptedKeyHeaderLen = len(encryptedKeyMagic) + 1 + chacha20poly1305.NonceSizeX
+)
+
+var (
+	MasterKeyError       = errors.New("The master key must be at least 16 bytes")
+	MasterKeyEnvError    = errors.New("The environment variable of the master key is not set or it's not valid base64")
+	KeyDecryptError      = errors.New("The key could not be decrypted with the master key")
+	KeyNotEncryptedError = errors.New("The key is not encrypted with the master key")
+)
+
+// The MasterKeyDecrypter interface is implemented by the KMS clients: it decrypts the master key wrapped by the KMS
+type MasterKeyDecrypter interface {
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// The EncryptedKeyStore encrypts the keys under a master key and stores them into another key store
+type EncryptedKeyStore struct {
+	store KeyStore
+	key   [keySize]byte
+}
+
+// This function returns a key store which encrypts the keys under the master key and stores them into the store
+func NewEncryptedKeyStore(store KeyStore, masterKey []byte) (*EncryptedKeyStore, error) {
+	if len(masterKey) < minMasterKeySize {
+		return nil, MasterKeyError
+	}
+	return newEncryptedKeyStore(store, masterKey), nil
+}
+
+func newEncryptedKeyStore(store KeyStore, masterKey []byte) *EncryptedKeyStore {
+	encrypted := &EncryptedKeyStore{store: store}
+	// the master key has been validated, reading 32 bytes from the HKDF cannot fail
+	io.ReadFull(hkdf.New(sha256.New, masterKey, nil, []byte(encryptedKeyLabel)), encrypted.key[:])
+	return encrypted
+}
+
+// This function reads the base64 encoded master key from the environment variable. An empty name means SEC51_MASTER_KEY.
+func MasterKeyFromEnv(name string) ([]byte, error) {
+	if name == "" {
+		name = MasterKeyEnv
+	}
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return nil, MasterKeyEnvError
+	}
+	masterKey, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, MasterKeyEnvError
+	}
+	if len(masterKey) < minMasterKeySize {
+		return nil, MasterKeyError
+	}
+	return masterKey, nil
+}
+
+// This function decrypts the master key wrapped by the KMS
+func MasterKeyFromKMS(kms MasterKeyDecrypter, wrappedKey []byte) ([]byte, error) {
+	masterKey, err := kms.Decrypt(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(masterKey) < minMasterKeySize {
+		return nil, MasterKeyError
+	}
+	return masterKey, nil
+}
+
+// This function derives the master key from the passphrase with Argon2id.
+// The salt is generated the first time and stored in clear into the store, which is the one the EncryptedKeyStore wraps.
+func MasterKeyFromPassphrase(store KeyStore, passphrase string) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, PasswordError
+	}
+
+	salt, err := loadOrGenerateKey(store, "%s", masterKeySaltName, generateSalt)
+	if err != nil {
+		return nil, err
+	}
+	return argon2.IDKey([]byte(passphrase), salt[:], passwordArgon2Time, passwordArgon2Memory, passwordArgon2Threads, keySize), nil
+}
+
+// This method decrypts the key read from the underlying store
+func (store *EncryptedKeyStore) ReadKey(name string) ([]byte, error) {
+	data, err := store.store.ReadKey(name)
+	if err != nil {
+		return nil, err
+	}
+	return store.open(name, data)
+}
+
+// This method encrypts the key and stores it into the underlying store
+func (store *EncryptedKeyStore) WriteKey(name string, data []byte) error {
+	sealed, err := store.seal(name, data)
+	if err != nil {
+		return err
+	}
+	return store.store.WriteKey(name, sealed)
+}
+
+// This method deletes the key from the underlying store
+func (store *EncryptedKeyStore) DeleteKey(name string) error {
+	return store.store.DeleteKey(name)
+}
+
+// This method lists the keys of the underlying store, which must implement KeyLister
+func (store *EncryptedKeyStore) ListKeys(prefix string) ([]string, error) {
+	lister, ok := store.store.(KeyLister)
+	if !ok {
+		return nil, KeyListError
+	}
+	names, err := lister.ListKeys(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// the salt of the passphrase is not a key of the store
+	keys := names[:0]
+	for _, name := range names {
+		if name != masterKeySaltName {
+			keys = append(keys, name)
+		}
+	}
+	return keys, nil
+}
+
+// This method encrypts the keys stored in clear before the master key was introduced, the underlying store must implement KeyLister.
+// The keys already encrypted are left untouched. It returns the names of the keys encrypted.
+func (store *EncryptedKeyStore) EncryptExistingKeys() ([]string, error) {
+	names, err := store.ListKeys("")
+	if err != nil {
+		return nil, err
+	}
+
+	var encrypted []string
+	for _, name := range names {
+		data, err := store.store.ReadKey(name)
+		if err != nil {
+			return encrypted, err
+		}
+		if isEncryptedKey(data) {
+			continue
+		}
+
+		if err := store.replaceKey(name, data); err != nil {
+			return encrypted, err
+		}
+		encrypted = append(encrypted, name)
+	}
+	return encrypted, nil
+}
+
+// replaces the key in clear with the encrypted one, the key in clear is never lost
+func (store *EncryptedKeyStore) replaceKey(name string, data []byte) error {
+	sealed, err := store.seal(name, data)
+	if err != nil {
+		return err
+	}
+
+	// the key files are swapped atomically
+	if files, ok := store.store.(*FileKeyStore); ok {
+		return files.migrateKeyFile(name, sealed)
+	}
+
+	// the other key stores never overwrite a key: it's deleted and written again, restoring the key in clear on failure
+	if err := store.store.DeleteKey(name); err != nil {
+		return err
+	}
+	if err := store.store.WriteKey(name, sealed); err != nil {
+		if restoreErr := store.store.WriteKey(name, data); restoreErr != nil {
+			return restoreErr
+		}
+		return err
+	}
+	return nil
+}
+
+func (store *EncryptedKeyStore) seal(name string, data []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(store.key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, encryptedKeyHeaderLen, encryptedKeyHeaderLen+len(data)+aead.Overhead())
+	copy(sealed, encryptedKeyMagic)
+	sealed[len(encryptedKeyMagic)] = encryptedKeyVersion
+	nonce := sealed[len(encryptedKeyMagic)+1:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, nonce, data, encryptedKeyAdditionalData(sealed[:len(encryptedKeyMagic)+1], name)), nil
+}
+
+func (store *EncryptedKeyStore) open(name string, data []byte) ([]byte, error) {
+	if !isEncryptedKey(data) {
+		return nil, KeyNotEncryptedError
+	}
+	if data[len(encryptedKeyMagic)] != encryptedKeyVersion {
+		return nil, KeyDecryptError
+	}
+
+	aead, err := chacha20poly1305.NewX(store.key[:])
+	if err != nil {
+		return nil, err
+	}
+	nonce := data[len(encryptedKeyMagic)+1 : encryptedKeyHeaderLen]
+	key, err := aead.Open(nil, nonce, data[encryptedKeyHeaderLen:], encryptedKeyAdditionalData(data[:len(encryptedKeyMagic)+1], name))
+	if err != nil {
+		return nil, KeyDecryptError
+	}
+	return key, nil
+}
+
+// the header and the name of the key are authenticated
+func encryptedKeyAdditionalData(header []byte, name string) []byte {
+	return append(append([]byte{}, header...), name...)
+}
+
+func isEncryptedKey(data []byte) bool {
+	return len(data) >= encryptedKeyHeaderLen && bytes.HasPrefix(data, []byte(encryptedKeyMagic))
+}
@@ -0,0 +1,240 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a KMS which unwraps the master key by xoring it
+type testKMS struct{}
+
+func (testKMS) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, errors.New("kms: the ciphertext is empty")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	for i := range ciphertext {
+		plaintext[i] = ciphertext[i] ^ 0x51
+	}
+	return plaintext, nil
+}
+
+func TestEncryptedKeyStore(t *testing.T) {
+
+	folder := t.TempDir()
+	files, err := NewFileKeyStore(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	masterKey := bytes.Repeat([]byte{7}, 32)
+	if _, err := InitCryptoEngineWithConfig("Sec51MasterKey", Config{KeyStore: files, MasterKey: masterKey[:8]}); err != MasterKeyError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MasterKeyError, err)
+	}
+
+	engine, err := InitCryptoEngineWithConfig("Sec51MasterKey", Config{KeyStore: files, MasterKey: masterKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key files do not contain the keys
+	secretKey := engine.secretKey
+	data, err := ioutil.ReadFile(filepath.Join(folder, "sec51masterkey_secret.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, secretKey[:]) {
+		t.Fatal("The secret key is stored in clear")
+	}
+
+	// the same master key loads the same keys
+	reloaded, err := InitCryptoEngineWithConfig("Sec51MasterKey", Config{KeyStore: files, MasterKey: masterKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.secretKey != secretKey || reloaded.Fingerprint() != engine.Fingerprint() {
+		t.Fatal("The keys loaded with the master key do not match")
+	}
+
+	// the keys folder alone is useless
+	if _, err := InitCryptoEngineWithConfig("Sec51MasterKey", Config{KeyStore: files, MasterKey: bytes.Repeat([]byte{8}, 32)}); err != KeyDecryptError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyDecryptError, err)
+	}
+
+	// the encrypted keys cannot be swapped
+	store, err := NewEncryptedKeyStore(NewMemoryKeyStore(), masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteKey("a.key", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := store.store.ReadKey("a.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.store.WriteKey("b.key", sealed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadKey("b.key"); err != KeyDecryptError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyDecryptError, err)
+	}
+	if err := store.store.WriteKey("clear.key", []byte("clear")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadKey("clear.key"); err != KeyNotEncryptedError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyNotEncryptedError, err)
+	}
+
+}
+
+func TestEncryptExistingKeys(t *testing.T) {
+
+	memory := NewMemoryKeyStore()
+	engine, err := InitCryptoEngineWithConfig("Sec51Clear", Config{KeyStore: memory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	masterKey := bytes.Repeat([]byte{9}, 32)
+	store, err := NewEncryptedKeyStore(memory, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := store.EncryptExistingKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted) == 0 {
+		t.Fatal("No key has been encrypted")
+	}
+	if encrypted, err := store.EncryptExistingKeys(); err != nil || len(encrypted) != 0 {
+		t.Fatalf("The keys have been encrypted twice: %v %v\n", encrypted, err)
+	}
+
+	migrated, err := InitCryptoEngineWithConfig("Sec51Clear", Config{KeyStore: memory, MasterKey: masterKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Fingerprint() != engine.Fingerprint() {
+		t.Fatal("The encrypted keys do not match the keys in clear")
+	}
+
+	// the key files are swapped
+	files, err := NewFileKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err = InitCryptoEngineWithConfig("Sec51Clear", Config{KeyStore: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newEncryptedKeyStore(files, masterKey).EncryptExistingKeys(); err != nil {
+		t.Fatal(err)
+	}
+	migrated, err = InitCryptoEngineWithConfig("Sec51Clear", Config{KeyStore: files, MasterKey: masterKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Fingerprint() != engine.Fingerprint() {
+		t.Fatal("The encrypted key files do not match the keys in clear")
+	}
+
+}
+
+// a key store which cannot store the encrypted keys
+type sealedWriteFailingKeyStore struct {
+	*MemoryKeyStore
+}
+
+func (store sealedWriteFailingKeyStore) WriteKey(name string, data []byte) error {
+	if isEncryptedKey(data) {
+		return errKeyStoreUnavailable
+	}
+	return store.MemoryKeyStore.WriteKey(name, data)
+}
+
+func TestEncryptExistingKeysFailure(t *testing.T) {
+
+	memory := NewMemoryKeyStore()
+	engine, err := InitCryptoEngineWithConfig("Sec51Clear", Config{KeyStore: memory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys in clear are restored when the encrypted keys cannot be written
+	store := newEncryptedKeyStore(sealedWriteFailingKeyStore{memory}, bytes.Repeat([]byte{9}, 32))
+	if _, err := store.EncryptExistingKeys(); err != errKeyStoreUnavailable {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", errKeyStoreUnavailable, err)
+	}
+	reloaded, err := InitCryptoEngineWithConfig("Sec51Clear", Config{KeyStore: memory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Fingerprint() != engine.Fingerprint() {
+		t.Fatal("The keys in clear have been lost")
+	}
+
+}
+
+func TestMasterKeySources(t *testing.T) {
+
+	masterKey := bytes.Repeat([]byte{5}, 32)
+
+	// environment
+	os.Setenv("SEC51_TEST_MASTER_KEY", base64.StdEncoding.EncodeToString(masterKey))
+	defer os.Unsetenv("SEC51_TEST_MASTER_KEY")
+	fromEnv, err := MasterKeyFromEnv("SEC51_TEST_MASTER_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fromEnv, masterKey) {
+		t.Fatal("The master key read from the environment does not match")
+	}
+	if _, err := MasterKeyFromEnv("SEC51_TEST_MISSING_MASTER_KEY"); err != MasterKeyEnvError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MasterKeyEnvError, err)
+	}
+
+	// KMS
+	wrapped, _ := testKMS{}.Decrypt(masterKey)
+	fromKMS, err := MasterKeyFromKMS(testKMS{}, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fromKMS, masterKey) {
+		t.Fatal("The master key decrypted by the KMS does not match")
+	}
+	if _, err := MasterKeyFromKMS(testKMS{}, wrapped[:4]); err != MasterKeyError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MasterKeyError, err)
+	}
+
+	// passphrase: the salt is generated once and it's not listed as a key
+	memory := NewMemoryKeyStore()
+	first, err := MasterKeyFromPassphrase(memory, "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := MasterKeyFromPassphrase(memory, "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatal("The passphrase derived different master keys")
+	}
+	if _, err := MasterKeyFromPassphrase(memory, ""); err != PasswordError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", PasswordError, err)
+	}
+	store, err := NewEncryptedKeyStore(memory, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := store.ListKeys(""); err != nil || len(keys) != 0 {
+		t.Fatalf("The salt of the passphrase is listed as a key: %v %v\n", keys, err)
+	}
+
+}
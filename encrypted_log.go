@@ -0,0 +1,226 @@
+package cryptoengine
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"io"
+	"os"
+	"sync"
+)
+
+// Tamper evident logs, for instance audit or event logs: the EncryptedLog appends sealed records to a file and chains them
+// with a MAC, each record authenticating the MAC of the previous one. A record which is modified, removed, reordered
+// or copied from another log breaks the chain. Removing the last records cannot be detected from the file alone:
+// the Head of the log must be kept elsewhere, for instance signed or sent to a remote storage, and compared by the reader.
+// Record format:
+// |length|   => 4 bytes (little endian size of the rest of the record)
+// |sequence| => 8 bytes (little endian sequence number of the record, starting at 1)
+// |nonce|    => 24 bytes
+// |sealed|   => N bytes (secretbox of the clear text)
+// |mac|      => 32 bytes (HMAC-SHA-256 of the previous mac, the sequence, the nonce and the sealed data)
+// The previous mac of the first record is all zeros.
+
+const (
+	logMACSize             = sha256.Size
+	logRecordOverhead      = 8 + nonceSize + secretbox.Overhead + logMACSize
+	logEncryptionLabel     = "log encryption"
+	logAuthenticationLabel = "log authentication"
+)
+
+var (
+	LogRecordError    = errors.New("The encrypted log record is corrupted or has been tampered with")
+	LogTruncatedError = errors.New("The encrypted log ends with an incomplete record")
+	LogHeadError      = errors.New("The encrypted log does not end with the expected head")
+)
+
+// The EncryptedLog appends sealed records to a file. It's safe for concurrent use.
+type EncryptedLog struct {
+	file     *os.File
+	engine   *CryptoEngine
+	key      [keySize]byte
+	macKey   [keySize]byte
+	mutex    sync.Mutex
+	sequence uint64           // the sequence number of the last record
+	mac      [logMACSize]byte // the mac of the last record
+}
+
+// The EncryptedLogReader verifies the chain of the records of an encrypted log and decrypts them
+type EncryptedLogReader struct {
+	reader         *bufio.Reader
+	key            [keySize]byte
+	macKey         [keySize]byte
+	maxMessageSize uint64
+	sequence       uint64
+	mac            [logMACSize]byte
+}
+
+// This method opens the encrypted log at the path, or creates it. The existing records are verified first,
+// so the new records continue the chain.
+func (engine *CryptoEngine) OpenEncryptedLog(path string) (*EncryptedLog, error) {
+	key, macKey, err := engine.logKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := engine.newEncryptedLogReader(file, key, macKey)
+	for {
+		if _, _, err := reader.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return &EncryptedLog{
+		file:     file,
+		engine:   engine,
+		key:      key,
+		macKey:   macKey,
+		sequence: reader.sequence,
+		mac:      reader.mac,
+	}, nil
+}
+
+// This method returns a reader of the records of an encrypted log of the engine
+func (engine *CryptoEngine) NewEncryptedLogReader(r io.Reader) (*EncryptedLogReader, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, err
+	}
+	key, macKey, err := engine.logKeys()
+	if err != nil {
+		return nil, err
+	}
+	return engine.newEncryptedLogReader(r, key, macKey), nil
+}
+
+func (engine *CryptoEngine) newEncryptedLogReader(r io.Reader, key, macKey [keySize]byte) *EncryptedLogReader {
+	return &EncryptedLogReader{
+		reader:         bufio.NewReader(r),
+		key:            key,
+		macKey:         macKey,
+		maxMessageSize: engine.maxMessageSize(),
+	}
+}
+
+// This method seals the record, appends it to the log and syncs the file. It returns the sequence number of the record.
+func (encryptedLog *EncryptedLog) Append(record []byte) (uint64, error) {
+	if uint64(4+logRecordOverhead+len(record)) > encryptedLog.engine.maxMessageSize() {
+		return 0, MessageTooLargeError
+	}
+	nonce, err := encryptedLog.engine.nextNonce()
+	if err != nil {
+		return 0, err
+	}
+
+	encryptedLog.mutex.Lock()
+	defer encryptedLog.mutex.Unlock()
+
+	sequence := encryptedLog.sequence + 1
+	data := make([]byte, 4+8, 4+logRecordOverhead+len(record))
+	binary.LittleEndian.PutUint32(data, uint32(logRecordOverhead+len(record)))
+	binary.LittleEndian.PutUint64(data[4:], sequence)
+	data = append(data, nonce[:]...)
+	data = secretbox.Seal(data, record, &nonce, &encryptedLog.key)
+	mac := logRecordMAC(encryptedLog.macKey, encryptedLog.mac, data[4:])
+	data = append(data, mac[:]...)
+
+	if _, err := encryptedLog.file.Write(data); err != nil {
+		return 0, err
+	}
+	if err := encryptedLog.file.Sync(); err != nil {
+		return 0, err
+	}
+	encryptedLog.sequence = sequence
+	encryptedLog.mac = mac
+	return sequence, nil
+}
+
+// This method returns the sequence number and the mac of the last record, to check the log has not been truncated
+func (encryptedLog *EncryptedLog) Head() (uint64, [logMACSize]byte) {
+	encryptedLog.mutex.Lock()
+	defer encryptedLog.mutex.Unlock()
+	return encryptedLog.sequence, encryptedLog.mac
+}
+
+// This method closes the file of the log
+func (encryptedLog *EncryptedLog) Close() error {
+	return encryptedLog.file.Close()
+}
+
+// This method verifies and decrypts the next record. It returns io.EOF after the last record.
+func (reader *EncryptedLogReader) Next() (uint64, []byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(reader.reader, length[:]); err == io.EOF {
+		return 0, nil, io.EOF
+	} else if err != nil {
+		return 0, nil, LogTruncatedError
+	}
+
+	size := uint64(binary.LittleEndian.Uint32(length[:]))
+	if size < logRecordOverhead || 4+size > reader.maxMessageSize {
+		return 0, nil, LogRecordError
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(reader.reader, data); err != nil {
+		return 0, nil, LogTruncatedError
+	}
+
+	// the chain is verified before the decryption
+	body, recordMAC := data[:size-logMACSize], data[size-logMACSize:]
+	mac := logRecordMAC(reader.macKey, reader.mac, body)
+	if !hmac.Equal(mac[:], recordMAC) {
+		return 0, nil, LogRecordError
+	}
+	sequence := binary.LittleEndian.Uint64(body)
+	if sequence != reader.sequence+1 {
+		return 0, nil, LogRecordError
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], body[8:])
+	record, ok := secretbox.Open(nil, body[8+nonceSize:], &nonce, &reader.key)
+	if !ok {
+		return 0, nil, LogRecordError
+	}
+
+	reader.sequence = sequence
+	reader.mac = mac
+	return sequence, record, nil
+}
+
+// This method checks the records read so far end with the head, returned by Head when the log was written
+func (reader *EncryptedLogReader) VerifyHead(sequence uint64, mac [logMACSize]byte) error {
+	if reader.sequence != sequence || !hmac.Equal(reader.mac[:], mac[:]) {
+		return LogHeadError
+	}
+	return nil
+}
+
+// derives the encryption and the authentication keys of the logs
+func (engine *CryptoEngine) logKeys() ([keySize]byte, [keySize]byte, error) {
+	key, err := engine.deriveSubKey(logEncryptionLabel)
+	if err != nil {
+		return key, key, err
+	}
+	macKey, err := engine.deriveSubKey(logAuthenticationLabel)
+	return key, macKey, err
+}
+
+func logRecordMAC(key [keySize]byte, previous [logMACSize]byte, body []byte) [logMACSize]byte {
+	var sum [logMACSize]byte
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write(previous[:])
+	mac.Write(body)
+	copy(sum[:], mac.Sum(nil))
+	return sum
+}
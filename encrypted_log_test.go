@@ -0,0 +1,163 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedLog(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Log", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	encryptedLog, err := engine.OpenEncryptedLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		sequence, err := encryptedLog.Append([]byte(fmt.Sprintf("event %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sequence != uint64(i) {
+			t.Fatalf("Expected the sequence %d, got %d\n", i, sequence)
+		}
+	}
+	if err := encryptedLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reopened log continues the chain
+	encryptedLog, err = engine.OpenEncryptedLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sequence, err := encryptedLog.Append([]byte("event 4")); err != nil || sequence != 4 {
+		t.Fatalf("Expected the sequence 4, got %d: %v\n", sequence, err)
+	}
+	headSequence, headMAC := encryptedLog.Head()
+	if err := encryptedLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := engine.NewEncryptedLogReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; ; i++ {
+		sequence, record, err := reader.Next()
+		if err == io.EOF {
+			if i != 5 {
+				t.Fatalf("Expected 4 records, got %d\n", i-1)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sequence != uint64(i) || string(record) != fmt.Sprintf("event %d", i) {
+			t.Fatalf("The record %d does not match: %d %q\n", i, sequence, record)
+		}
+	}
+	if err := reader.VerifyHead(headSequence, headMAC); err != nil {
+		t.Fatal(err)
+	}
+
+	// the truncation is detected against the head
+	recordSize := len(data) / 4
+	reader, err = engine.NewEncryptedLogReader(bytes.NewReader(data[:3*recordSize]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, _, err := reader.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := reader.VerifyHead(headSequence, headMAC); err != LogHeadError {
+		t.Fatalf("Expected %v, got %v\n", LogHeadError, err)
+	}
+
+}
+
+func TestEncryptedLogTampering(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Log", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	encryptedLog, err := engine.OpenEncryptedLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := encryptedLog.Append([]byte(fmt.Sprintf("event %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := encryptedLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSize := len(data) / 3
+
+	readAll := func(data []byte) error {
+		reader, err := engine.NewEncryptedLogReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			if _, _, err := reader.Next(); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+
+	// a modified record
+	modified := append([]byte{}, data...)
+	modified[recordSize+20] ^= 0x01
+	if err := readAll(modified); err != LogRecordError {
+		t.Fatalf("Expected %v, got %v\n", LogRecordError, err)
+	}
+
+	// a removed record
+	removed := append(append([]byte{}, data[:recordSize]...), data[2*recordSize:]...)
+	if err := readAll(removed); err != LogRecordError {
+		t.Fatalf("Expected %v, got %v\n", LogRecordError, err)
+	}
+
+	// an incomplete record
+	if err := readAll(data[:len(data)-1]); err != LogTruncatedError {
+		t.Fatalf("Expected %v, got %v\n", LogTruncatedError, err)
+	}
+
+	// the log of another engine
+	other, err := InitCryptoEngineWithConfig("Sec51OtherLog", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.OpenEncryptedLog(path); err != LogRecordError {
+		t.Fatalf("Expected %v, got %v\n", LogRecordError, err)
+	}
+
+}
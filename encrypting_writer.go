@@ -0,0 +1,305 @@
+package cryptoengine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"io"
+	"math"
+	"sync"
+)
+
+// Incremental encryption for the streams which are appended continuously, like the logs shipped to a remote storage:
+// the EncryptingWriter buffers the clear text and seals it in chunks, on Flush or when the buffer is full.
+// Close writes an authenticated terminator, so the DecryptingReader detects a stream which has been truncated,
+// and the chunks cannot be reordered, dropped or replayed from another stream.
+// Format:
+// |version|     => 1 byte
+// |nonce|       => 15 bytes (prefix of the nonces of the chunks, derived like the nonce of a message)
+// |chunks|      => N chunks
+// Chunk:
+// |length|      => 4 bytes (little endian size of the sealed data)
+// |sealed|      => N bytes (secretbox of the clear text, the nonce is the prefix, the 8 bytes little endian counter and the last flag)
+// The terminator is the last chunk, with an empty clear text.
+
+const (
+	encryptingStreamVersion = 1
+	streamNoncePrefixSize   = nonceSize - 8 - 1
+	streamHeaderSize        = 1 + streamNoncePrefixSize
+	streamChunkSize         = 64 * 1024 // the maximum size of the clear text of a chunk
+)
+
+var (
+	StreamClosedError    = errors.New("The encrypted stream has been closed")
+	StreamHeaderError    = errors.New("The encrypted stream header is not valid")
+	StreamChunkError     = errors.New("The encrypted stream chunk is corrupted or has been tempered with")
+	StreamTruncatedError = errors.New("The encrypted stream ended before its terminator")
+)
+
+// The EncryptingWriter encrypts the clear text written to it into an encrypted stream.
+// It's safe for concurrent use.
+type EncryptingWriter struct {
+	writer  io.Writer       // the underlying stream
+	key     [keySize]byte   // the secret key or the key shared with the peer
+	nonce   [nonceSize]byte // the prefix and the counter of the next chunk
+	counter uint64          // the counter of the next chunk
+	buffer  []byte          // the clear text not sealed yet
+	sealed  []byte          // the buffer the chunks are sealed into
+	err     error           // sticky error, once a write failed the stream cannot be continued
+	mutex   sync.Mutex
+}
+
+// The DecryptingReader verifies and decrypts the encrypted stream of an EncryptingWriter
+type DecryptingReader struct {
+	reader  *bufio.Reader
+	key     [keySize]byte
+	nonce   [nonceSize]byte
+	counter uint64
+	chunk   []byte // the clear text of the current chunk not read yet
+	sealed  []byte
+	done    bool  // the terminator has been read
+	err     error // sticky error
+}
+
+// This method returns a writer which encrypts the stream with the secret key of the engine.
+// The stream header is written to w immediately.
+func (engine *CryptoEngine) NewEncryptingWriter(w io.Writer) (*EncryptingWriter, error) {
+	return engine.newEncryptingWriter(w, engine.secretKey)
+}
+
+// This method returns a writer which encrypts the stream for the peer
+func (engine *CryptoEngine) NewEncryptingWriterWithPubKey(w io.Writer, verificationEngine VerificationEngine) (*EncryptingWriter, error) {
+	key, err := engine.streamPublicKey(verificationEngine)
+	if err != nil {
+		return nil, err
+	}
+	return engine.newEncryptingWriter(w, key)
+}
+
+// This method returns a reader of the stream encrypted with the secret key of the engine
+func (engine *CryptoEngine) NewDecryptingReader(r io.Reader) (*DecryptingReader, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, err
+	}
+	return &DecryptingReader{reader: bufio.NewReader(r), key: engine.secretKey}, nil
+}
+
+// This method returns a reader of the stream the peer encrypted for the engine
+func (engine *CryptoEngine) NewDecryptingReaderWithPubKey(r io.Reader, verificationEngine VerificationEngine) (*DecryptingReader, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, err
+	}
+	key, err := engine.streamPublicKey(verificationEngine)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptingReader{reader: bufio.NewReader(r), key: key}, nil
+}
+
+func (engine *CryptoEngine) newEncryptingWriter(w io.Writer, key [keySize]byte) (*EncryptingWriter, error) {
+	// the nonce prefix is unique like the nonce of a message
+	nonce, err := engine.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &EncryptingWriter{writer: w, key: key}
+	copy(writer.nonce[:], nonce[:streamNoncePrefixSize])
+
+	header := make([]byte, 0, streamHeaderSize)
+	header = append(header, encryptingStreamVersion)
+	header = append(header, writer.nonce[:streamNoncePrefixSize]...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// returns the key shared with the peer
+func (engine *CryptoEngine) streamPublicKey(verificationEngine VerificationEngine) ([keySize]byte, error) {
+	peerPublicKey := verificationEngine.PublicKey()
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return peerPublicKey, KeyNotValidError
+	}
+	return engine.preSharedKey(peerPublicKey), nil
+}
+
+// This method buffers the clear text, the full chunks are sealed and written to the stream right away
+func (writer *EncryptingWriter) Write(data []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.err != nil {
+		return 0, writer.err
+	}
+
+	written := 0
+	for len(data) > 0 {
+		n := streamChunkSize - len(writer.buffer)
+		if n > len(data) {
+			n = len(data)
+		}
+		writer.buffer = append(writer.buffer, data[:n]...)
+		data = data[n:]
+		written += n
+
+		if len(writer.buffer) == streamChunkSize {
+			if err := writer.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// This method seals the buffered clear text into a chunk and writes it to the stream,
+// so that everything written so far can be decrypted by the reader
+func (writer *EncryptingWriter) Flush() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.err != nil {
+		return writer.err
+	}
+	if len(writer.buffer) == 0 {
+		return nil
+	}
+	return writer.seal(false)
+}
+
+// This method flushes the buffered clear text and writes the terminator of the stream.
+// The underlying writer is not closed.
+func (writer *EncryptingWriter) Close() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.err != nil {
+		if writer.err == StreamClosedError {
+			return nil
+		}
+		return writer.err
+	}
+	if len(writer.buffer) > 0 {
+		if err := writer.seal(false); err != nil {
+			return err
+		}
+	}
+	if err := writer.seal(true); err != nil {
+		return err
+	}
+	writer.err = StreamClosedError
+	return nil
+}
+
+// seals the buffer into a chunk and writes it, any error is sticky
+func (writer *EncryptingWriter) seal(last bool) error {
+	if writer.counter == math.MaxUint64 {
+		writer.err = StreamChunkError
+		return writer.err
+	}
+
+	nonce := streamChunkNonce(writer.nonce, writer.counter, last)
+	writer.sealed = append(writer.sealed[:0], 0, 0, 0, 0)
+	writer.sealed = secretbox.Seal(writer.sealed, writer.buffer, &nonce, &writer.key)
+	binary.LittleEndian.PutUint32(writer.sealed, uint32(len(writer.sealed)-4))
+
+	if _, err := writer.writer.Write(writer.sealed); err != nil {
+		writer.err = err
+		return err
+	}
+
+	// wipe the clear text
+	for i := range writer.buffer {
+		writer.buffer[i] = 0
+	}
+	writer.buffer = writer.buffer[:0]
+	writer.counter++
+	return nil
+}
+
+// This method reads the clear text of the stream.
+// io.EOF is returned only after the terminator, StreamTruncatedError when the stream ends before it.
+func (reader *DecryptingReader) Read(p []byte) (int, error) {
+	for len(reader.chunk) == 0 {
+		if reader.err != nil {
+			return 0, reader.err
+		}
+		if reader.done {
+			return 0, io.EOF
+		}
+		reader.err = reader.open()
+	}
+
+	n := copy(p, reader.chunk)
+	reader.chunk = reader.chunk[n:]
+	return n, nil
+}
+
+// reads and opens the next chunk
+func (reader *DecryptingReader) open() error {
+	if reader.counter == 0 {
+		var header [streamHeaderSize]byte
+		if _, err := io.ReadFull(reader.reader, header[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return StreamHeaderError
+			}
+			return err
+		}
+		if header[0] != encryptingStreamVersion {
+			return StreamHeaderError
+		}
+		copy(reader.nonce[:], header[1:])
+	}
+
+	var length [4]byte
+	if _, err := io.ReadFull(reader.reader, length[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return StreamTruncatedError
+		}
+		return err
+	}
+
+	// validate the length before allocating the buffer
+	size := binary.LittleEndian.Uint32(length[:])
+	if size < secretbox.Overhead || size > streamChunkSize+secretbox.Overhead {
+		return StreamChunkError
+	}
+	if cap(reader.sealed) < int(size) {
+		reader.sealed = make([]byte, size)
+	}
+	reader.sealed = reader.sealed[:size]
+	if _, err := io.ReadFull(reader.reader, reader.sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return StreamTruncatedError
+		}
+		return err
+	}
+
+	// a chunk opens either as a data chunk or as the terminator
+	var ok bool
+	nonce := streamChunkNonce(reader.nonce, reader.counter, false)
+	reader.chunk, ok = secretbox.Open(reader.chunk[:0], reader.sealed, &nonce, &reader.key)
+	if !ok {
+		nonce = streamChunkNonce(reader.nonce, reader.counter, true)
+		reader.chunk, ok = secretbox.Open(reader.chunk[:0], reader.sealed, &nonce, &reader.key)
+		if !ok || len(reader.chunk) != 0 {
+			return StreamChunkError
+		}
+		reader.done = true
+	}
+	reader.counter++
+	return nil
+}
+
+// returns the nonce of the chunk: the stream prefix, the counter and the last flag
+func streamChunkNonce(prefix [nonceSize]byte, counter uint64, last bool) [nonceSize]byte {
+	nonce := prefix
+	binary.LittleEndian.PutUint64(nonce[streamNoncePrefixSize:], counter)
+	if last {
+		nonce[nonceSize-1] = 1
+	} else {
+		nonce[nonceSize-1] = 0
+	}
+	return nonce
+}
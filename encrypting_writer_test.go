@@ -0,0 +1,181 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"golang.org/x/crypto/nacl/secretbox"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestEncryptingWriter(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stream bytes.Buffer
+	writer, err := engine.NewEncryptingWriter(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is written until the flush
+	if _, err := writer.Write([]byte("first line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if stream.Len() != streamHeaderSize {
+		t.Fatalf("The clear text has been written before the flush: %d bytes\n", stream.Len())
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the flushed lines can be decrypted while the stream is still open
+	reader, err := engine.NewDecryptingReader(bytes.NewReader(stream.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := make([]byte, len("first line\n"))
+	if _, err := io.ReadFull(reader, line); err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != "first line\n" {
+		t.Fatalf("The decrypted data does not match the original one: %q\n", line)
+	}
+	if _, err := reader.Read(line); err != StreamTruncatedError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", StreamTruncatedError, err)
+	}
+
+	// bigger than a chunk
+	big := bytes.Repeat([]byte("0123456789"), streamChunkSize/5)
+	if _, err := writer.Write(big); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write([]byte("late")); err != StreamClosedError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", StreamClosedError, err)
+	}
+
+	reader, err = engine.NewDecryptingReader(bytes.NewReader(stream.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, append([]byte("first line\n"), big...)) {
+		t.Fatal("The decrypted data does not match the original one")
+	}
+
+}
+
+func TestEncryptingWriterWithPubKey(t *testing.T) {
+
+	alice, err := InitCryptoEngine("Sec51Alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := InitCryptoEngine("Sec51Bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceVerificationEngine, err := NewVerificationEngineWithKey(alice.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobVerificationEngine, err := NewVerificationEngineWithKey(bob.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stream bytes.Buffer
+	writer, err := alice.NewEncryptingWriterWithPubKey(&stream, bobVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte("for bob"))
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := bob.NewDecryptingReaderWithPubKey(&stream, aliceVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "for bob" {
+		t.Fatalf("The decrypted data does not match the original one: %q\n", data)
+	}
+
+}
+
+func TestDecryptingReaderTampering(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stream bytes.Buffer
+	writer, err := engine.NewEncryptingWriter(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte("first"))
+	writer.Flush()
+	writer.Write([]byte("second"))
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := stream.Bytes()
+
+	chunkSize := 4 + 5 + secretbox.Overhead
+	first := data[streamHeaderSize : streamHeaderSize+chunkSize]
+	rest := data[streamHeaderSize+chunkSize:]
+
+	cases := map[string][]byte{
+		// the terminator has been dropped
+		"truncated": data[:len(data)-4-secretbox.Overhead],
+		// the first chunk has been dropped
+		"dropped": append(append([]byte{}, data[:streamHeaderSize]...), rest...),
+		// the first chunk has been replayed
+		"replayed": append(append(append([]byte{}, data[:streamHeaderSize+chunkSize]...), first...), rest...),
+	}
+
+	for name, tampered := range cases {
+		reader, err := engine.NewDecryptingReader(bytes.NewReader(tampered))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(reader); err == nil {
+			t.Errorf("%s: the tampered stream has been decrypted\n", name)
+		}
+	}
+
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)-1] ^= 1
+	reader, err := engine.NewDecryptingReader(bytes.NewReader(flipped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(reader); err != StreamChunkError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", StreamChunkError, err)
+	}
+
+	reader, err = engine.NewDecryptingReader(bytes.NewReader([]byte{2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(reader); err != StreamHeaderError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", StreamHeaderError, err)
+	}
+
+}
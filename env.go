@@ -0,0 +1,68 @@
+package cryptoengine
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// Secrets in environment variables, for 12-factor deployments: the value of a variable is an armored ciphertext
+// wrapped in the ENC[...] marker, for instance DB_PASSWORD=ENC[ce1:...], and it's decrypted with the engine secret key at startup.
+
+const (
+	envMarkerPrefix = "ENC["
+	envMarkerSuffix = "]"
+)
+
+var (
+	EnvVariableError = errors.New("The encrypted environment variable is not valid")
+)
+
+// This method encrypts the value and wraps it in the ENC[...] marker, ready to be set in an environment variable
+func (engine *CryptoEngine) EncryptEnv(value string) (string, error) {
+	armored, err := engine.EncryptArmored([]byte(value))
+	if err != nil {
+		return "", err
+	}
+	return envMarkerPrefix + armored + envMarkerSuffix, nil
+}
+
+// This method decrypts the environment variables whose name starts with the prefix and whose value is wrapped in the ENC[...] marker.
+// It returns the decrypted values by variable name. The other variables are ignored.
+func (engine *CryptoEngine) DecryptEnv(prefix string) (map[string]string, error) {
+	decrypted := make(map[string]string)
+	for _, variable := range os.Environ() {
+		separator := strings.IndexByte(variable, '=')
+		if separator < 0 {
+			continue
+		}
+
+		name, value := variable[:separator], variable[separator+1:]
+		if !strings.HasPrefix(name, prefix) || !strings.HasPrefix(value, envMarkerPrefix) || !strings.HasSuffix(value, envMarkerSuffix) {
+			continue
+		}
+
+		clearText, err := engine.DecryptArmored(value[len(envMarkerPrefix) : len(value)-len(envMarkerSuffix)])
+		if err != nil {
+			return nil, EnvVariableError
+		}
+		decrypted[name] = string(clearText)
+	}
+	return decrypted, nil
+}
+
+// This method decrypts the environment variables like DecryptEnv and sets them back with their clear text value.
+// No variable is modified if any of them can not be decrypted.
+func (engine *CryptoEngine) SetDecryptedEnv(prefix string) error {
+	decrypted, err := engine.DecryptEnv(prefix)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range decrypted {
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package cryptoengine
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDecryptEnv(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := engine.EncryptEnv("s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("SEC51_TEST_DB_PASSWORD", encrypted)
+	os.Setenv("SEC51_TEST_DB_HOST", "localhost")
+	os.Setenv("OTHER_TEST_PASSWORD", encrypted)
+	defer os.Unsetenv("SEC51_TEST_DB_PASSWORD")
+	defer os.Unsetenv("SEC51_TEST_DB_HOST")
+	defer os.Unsetenv("OTHER_TEST_PASSWORD")
+
+	decrypted, err := engine.DecryptEnv("SEC51_TEST_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decrypted) != 1 || decrypted["SEC51_TEST_DB_PASSWORD"] != "s3cr3t" {
+		t.Fatal("The environment variables have not been decrypted")
+	}
+
+	if err := engine.SetDecryptedEnv("SEC51_TEST_"); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("SEC51_TEST_DB_PASSWORD") != "s3cr3t" || os.Getenv("SEC51_TEST_DB_HOST") != "localhost" || os.Getenv("OTHER_TEST_PASSWORD") != encrypted {
+		t.Fatal("The environment variables have not been set back")
+	}
+
+	// a tampered variable is rejected
+	os.Setenv("SEC51_TEST_API_KEY", "ENC[ce1:AAAA]")
+	defer os.Unsetenv("SEC51_TEST_API_KEY")
+	if _, err := engine.DecryptEnv("SEC51_TEST_"); err != EnvVariableError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", EnvVariableError, err)
+	}
+}
@@ -0,0 +1,206 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"io"
+)
+
+// Envelope encryption for large blobs: the payload is sealed once with a fresh data key
+// and only the data key is wrapped, with WrapKeyWithPubKey, for each recipient.
+//
+// version (1 byte)|sender public key|recipients count (2 bytes)
+// for each recipient: public key|wrapped key length (2 bytes)|wrapped key
+// nonce (24 bytes)|XChaCha20-Poly1305 sealed payload, authenticating all the previous bytes
+
+const (
+	envelopeVersion       = 1
+	envelopeMaxRecipients = 1024
+)
+
+var (
+	EnvelopeFormatError    = errors.New("The envelope is not valid")
+	EnvelopeRecipientError = errors.New("The envelope has not been encrypted for this engine")
+)
+
+// This method encrypts the payload for the recipients. The sender can decrypt the envelope as well.
+func (engine *CryptoEngine) EncryptEnvelope(payload []byte, recipients ...VerificationEngine) ([]byte, error) {
+	if err := engine.allow(operationEncrypt, operationOwnKeys); err != nil {
+		return nil, err
+	}
+	if len(recipients) == 0 || len(recipients) > envelopeMaxRecipients {
+		return nil, EnvelopeRecipientError
+	}
+
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := engine.envelopeHeader(dataKey, recipients)
+	if err != nil {
+		return nil, err
+	}
+	return sealEnvelope(header, dataKey, payload)
+}
+
+// the version, the sender and the data key wrapped for each recipient
+func (engine *CryptoEngine) envelopeHeader(dataKey [keySize]byte, recipients []VerificationEngine) ([]byte, error) {
+	var header bytes.Buffer
+	header.WriteByte(envelopeVersion)
+	header.Write(engine.publicKey[:])
+	binary.Write(&header, binary.BigEndian, uint16(len(recipients)))
+
+	for _, recipient := range recipients {
+		wrapped, err := engine.WrapKeyWithPubKey(dataKey[:], recipient)
+		if err != nil {
+			return nil, err
+		}
+		publicKey := recipient.PublicKey()
+		header.Write(publicKey[:])
+		binary.Write(&header, binary.BigEndian, uint16(len(wrapped)))
+		header.Write(wrapped)
+	}
+	return header.Bytes(), nil
+}
+
+// appends the random nonce and the sealed payload to the header
+func sealEnvelope(header []byte, dataKey [keySize]byte, payload []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(dataKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := make([]byte, len(header), len(header)+chacha20poly1305.NonceSizeX+len(payload)+chacha20poly1305.Overhead)
+	copy(envelope, header)
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	envelope = append(envelope, nonce...)
+
+	return aead.Seal(envelope, nonce, payload, envelope), nil
+}
+
+// This method decrypts an envelope encrypted for the engine, or by the engine, and returns the payload and the sender
+func (engine *CryptoEngine) DecryptEnvelope(envelope []byte) ([]byte, VerificationEngine, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, VerificationEngine{}, err
+	}
+	sender, recipients, headerSize, err := parseEnvelopeHeader(envelope)
+	if err != nil {
+		return nil, VerificationEngine{}, err
+	}
+
+	dataKey, err := engine.unwrapEnvelopeKey(sender, recipients)
+	if err != nil {
+		return nil, VerificationEngine{}, err
+	}
+
+	payload, err := openEnvelope(envelope, headerSize, dataKey)
+	if err != nil {
+		return nil, VerificationEngine{}, err
+	}
+	return payload, sender, nil
+}
+
+// a recipient entry of the envelope header
+type envelopeRecipient struct {
+	publicKey []byte
+	wrapped   []byte
+}
+
+// parses the header and returns the sender, the recipients and the size of the header, nonce excluded
+func parseEnvelopeHeader(envelope []byte) (VerificationEngine, []envelopeRecipient, int, error) {
+	reader := bytes.NewReader(envelope)
+
+	version, err := reader.ReadByte()
+	if err != nil || version != envelopeVersion {
+		return VerificationEngine{}, nil, 0, EnvelopeFormatError
+	}
+
+	senderPublicKey := make([]byte, keySize)
+	var count uint16
+	if _, err := io.ReadFull(reader, senderPublicKey); err != nil {
+		return VerificationEngine{}, nil, 0, EnvelopeFormatError
+	}
+	if err := binary.Read(reader, binary.BigEndian, &count); err != nil || count == 0 || count > envelopeMaxRecipients {
+		return VerificationEngine{}, nil, 0, EnvelopeFormatError
+	}
+
+	sender, err := NewVerificationEngineWithKey(senderPublicKey)
+	if err != nil {
+		return VerificationEngine{}, nil, 0, EnvelopeFormatError
+	}
+
+	recipients := make([]envelopeRecipient, count)
+	for i := range recipients {
+		recipients[i].publicKey = make([]byte, keySize)
+		var length uint16
+		if _, err := io.ReadFull(reader, recipients[i].publicKey); err != nil {
+			return VerificationEngine{}, nil, 0, EnvelopeFormatError
+		}
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil || int(length) > reader.Len() {
+			return VerificationEngine{}, nil, 0, EnvelopeFormatError
+		}
+		recipients[i].wrapped = make([]byte, length)
+		io.ReadFull(reader, recipients[i].wrapped)
+	}
+
+	return sender, recipients, len(envelope) - reader.Len(), nil
+}
+
+// unwraps the data key: the one wrapped for the engine, or any of them when the engine is the sender
+func (engine *CryptoEngine) unwrapEnvelopeKey(sender VerificationEngine, recipients []envelopeRecipient) ([keySize]byte, error) {
+	var dataKey [keySize]byte
+
+	senderPublicKey := sender.PublicKey()
+	isSender := senderPublicKey == engine.publicKey
+
+	for _, recipient := range recipients {
+		peer := sender
+		if isSender {
+			var err error
+			if peer, err = NewVerificationEngineWithKey(recipient.publicKey); err != nil {
+				return dataKey, EnvelopeFormatError
+			}
+		} else if !bytes.Equal(recipient.publicKey, engine.publicKey[:]) {
+			continue
+		}
+
+		key, err := engine.UnwrapKeyWithPublicKey(recipient.wrapped, peer)
+		if err != nil {
+			return dataKey, err
+		}
+		if len(key) != keySize {
+			return dataKey, EnvelopeFormatError
+		}
+		copy(dataKey[:], key)
+		return dataKey, nil
+	}
+
+	return dataKey, EnvelopeRecipientError
+}
+
+// opens the payload which follows the header
+func openEnvelope(envelope []byte, headerSize int, dataKey [keySize]byte) ([]byte, error) {
+	if len(envelope)-headerSize < chacha20poly1305.NonceSizeX {
+		return nil, EnvelopeFormatError
+	}
+
+	aead, err := chacha20poly1305.NewX(dataKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := envelope[headerSize : headerSize+chacha20poly1305.NonceSizeX]
+	payload, err := aead.Open(nil, nonce, envelope[headerSize+chacha20poly1305.NonceSizeX:], envelope[:headerSize+chacha20poly1305.NonceSizeX])
+	if err != nil {
+		return nil, MessageDecryptionError
+	}
+	return payload, nil
+}
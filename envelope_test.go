@@ -0,0 +1,80 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+
+	sender, err := InitCryptoEngine("Sec51EnvelopeSender")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, err := InitCryptoEngine("Sec51EnvelopeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := InitCryptoEngine("Sec51EnvelopeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mallory, err := InitCryptoEngine("Sec51EnvelopeMallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceVerificationEngine, err := NewVerificationEngine("Sec51EnvelopeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bobVerificationEngine, err := NewVerificationEngine("Sec51EnvelopeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog"), 1000)
+	envelope, err := sender.EncryptEnvelope(payload, aliceVerificationEngine, bobVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, engine := range []*CryptoEngine{alice, bob, sender} {
+		decrypted, from, err := engine.DecryptEnvelope(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(decrypted, payload) {
+			t.Fatal("The decrypted payload is not the original one")
+		}
+
+		fromPublicKey := from.PublicKey()
+		if !bytes.Equal(fromPublicKey[:], sender.PublicKey()) {
+			t.Fatal("The sender of the envelope is not valid")
+		}
+	}
+
+	if _, _, err := mallory.DecryptEnvelope(envelope); err != EnvelopeRecipientError {
+		t.Errorf("The expected error is: EnvelopeRecipientError, instead we've got: %v\n", err)
+	}
+
+	// the header is authenticated
+	envelope[len(envelope)-1] ^= 1
+	if _, _, err := alice.DecryptEnvelope(envelope); err != MessageDecryptionError {
+		t.Errorf("The expected error is: MessageDecryptionError, instead we've got: %v\n", err)
+	}
+
+	if _, _, err := alice.DecryptEnvelope(envelope[:40]); err != EnvelopeFormatError {
+		t.Errorf("The expected error is: EnvelopeFormatError, instead we've got: %v\n", err)
+	}
+
+	if _, err := sender.EncryptEnvelope(payload); err != EnvelopeRecipientError {
+		t.Errorf("The expected error is: EnvelopeRecipientError, instead we've got: %v\n", err)
+	}
+
+}
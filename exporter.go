@@ -0,0 +1,72 @@
+package cryptoengine
+
+import (
+	"crypto/sha256"
+	"errors"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+// Keying material exporter, for the subsystems which need their own keys bound to a key exchange, like an SRTP stack.
+// The keys are derived with HKDF-SHA-256, the info is the exporter domain followed by the length prefixed label and the length,
+// so different labels, or the same label with different lengths, never produce related keys,
+// and the exported keys are independent from the keys the engine itself uses.
+
+const (
+	exporterHandshakeLabel   = "cryptoengine exporter handshake"
+	exporterPrecomputedLabel = "cryptoengine exporter precomputed"
+	exporterMaxLength        = 255 * sha256.Size // the HKDF-SHA-256 limit
+	exporterMaxLabelLength   = 255
+)
+
+var (
+	ExporterLabelError  = errors.New("The exporter label must be between 1 and 255 bytes")
+	ExporterLengthError = errors.New("The exported keying material length is not valid")
+)
+
+// This method derives length bytes of keying material from the session key, once the handshake is completed.
+// Both peers export the same material for the same label and length.
+func (h *Handshake) ExportKeyingMaterial(label string, length int) ([]byte, error) {
+	if h.state != handshakeCompleted {
+		return nil, HandshakeStateError
+	}
+	return exportKeyingMaterial(h.sessionKey[:], exporterHandshakeLabel, label, length)
+}
+
+// This method derives length bytes of keying material from the key precomputed with the peer public key.
+// The precomputed key is static: the material is the same every time, use a handshake to obtain fresh keys for each session.
+func (engine *CryptoEngine) ExportKeyingMaterial(verificationEngine VerificationEngine, label string, length int) ([]byte, error) {
+	if err := engine.allow(operationOwnKeys); err != nil {
+		return nil, err
+	}
+
+	peerPublicKey := verificationEngine.PublicKey()
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return nil, KeyNotValidError
+	}
+
+	sharedKey := engine.preSharedKey(peerPublicKey)
+	return exportKeyingMaterial(sharedKey[:], exporterPrecomputedLabel, label, length)
+}
+
+func exportKeyingMaterial(secret []byte, domain, label string, length int) ([]byte, error) {
+	if len(label) == 0 || len(label) > exporterMaxLabelLength {
+		return nil, ExporterLabelError
+	}
+	if length <= 0 || length > exporterMaxLength {
+		return nil, ExporterLengthError
+	}
+
+	// domain|label length|label|length
+	info := make([]byte, 0, len(domain)+1+len(label)+2)
+	info = append(info, domain...)
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, byte(length>>8), byte(length))
+
+	material := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, info), material); err != nil {
+		return nil, err
+	}
+	return material, nil
+}
@@ -0,0 +1,114 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHandshakeExportKeyingMaterial(t *testing.T) {
+
+	alice, err := InitCryptoEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := InitCryptoEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceVerificationEngine, err := NewVerificationEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobVerificationEngine, err := NewVerificationEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initiator, hello, err := alice.InitiateHandshake(bobVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := initiator.ExportKeyingMaterial("EXTRACTOR-dtls_srtp", 60); err != HandshakeStateError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", HandshakeStateError, err)
+	}
+
+	responder, keyShare, err := bob.RespondHandshake(aliceVerificationEngine, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirm, err := initiator.Finish(keyShare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := responder.Confirm(confirm); err != nil {
+		t.Fatal(err)
+	}
+
+	initiatorMaterial, err := initiator.ExportKeyingMaterial("EXTRACTOR-dtls_srtp", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responderMaterial, err := responder.ExportKeyingMaterial("EXTRACTOR-dtls_srtp", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(initiatorMaterial) != 60 || !bytes.Equal(initiatorMaterial, responderMaterial) {
+		t.Fatal("The peers exported different keying material")
+	}
+
+	// the material is bound to the label and to the length
+	otherLabel, _ := initiator.ExportKeyingMaterial("other", 60)
+	shorter, _ := initiator.ExportKeyingMaterial("EXTRACTOR-dtls_srtp", 32)
+	sessionKey, _ := initiator.SessionKey()
+	if bytes.Equal(otherLabel, initiatorMaterial) || bytes.Equal(shorter, initiatorMaterial[:32]) || bytes.Equal(sessionKey[:], initiatorMaterial[:keySize]) {
+		t.Fatal("The exported keying material is not domain separated")
+	}
+
+	if _, err := initiator.ExportKeyingMaterial("", 32); err != ExporterLabelError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ExporterLabelError, err)
+	}
+	if _, err := initiator.ExportKeyingMaterial("label", 0); err != ExporterLengthError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ExporterLengthError, err)
+	}
+	if _, err := initiator.ExportKeyingMaterial("label", exporterMaxLength+1); err != ExporterLengthError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ExporterLengthError, err)
+	}
+
+}
+
+func TestPrecomputedExportKeyingMaterial(t *testing.T) {
+
+	alice, err := InitCryptoEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := InitCryptoEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceVerificationEngine, err := NewVerificationEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobVerificationEngine, err := NewVerificationEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceMaterial, err := alice.ExportKeyingMaterial(bobVerificationEngine, "srtp", 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobMaterial, err := bob.ExportKeyingMaterial(aliceVerificationEngine, "srtp", 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(aliceMaterial, bobMaterial) {
+		t.Fatal("The peers exported different keying material")
+	}
+
+	if _, err := alice.ExportKeyingMaterial(VerificationEngine{}, "srtp", 30); err != KeyNotValidError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyNotValidError, err)
+	}
+
+}
@@ -1,8 +1,11 @@
 package cryptoengine
 
 import (
+	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -11,9 +14,14 @@ import (
 
 const (
 	testKeyPath = "test_keys"
+
+	shredPasses     = 3         // the amount of times the content of a shredded file is overwritten
+	shredBufferSize = 32 * 1024 // the size of the writes of a pass
 )
 
 var (
+	ShredError = errors.New("Only the regular files can be shredded")
+
 	keyPath                    string
 	keysFolderPrefixFormat     string
 	testKeysFolderPrefixFormat string
@@ -29,6 +37,9 @@ func init() {
 
 	keysFolderPrefixFormat = filepath.Join(keyPath, "%s")
 	testKeysFolderPrefixFormat = filepath.Join(testKeyPath, "%s")
+	if !createKeyFolderOnInit {
+		return
+	}
 	if err := createBaseKeyFolder(keyPath); err != nil {
 		log.Println(err)
 	}
@@ -71,7 +82,8 @@ func writeFile(filename string, data []byte) error {
 
 }
 
-// Read the key file into a 32 byte array
+// Read the key file into a 32 byte array. The file must hold exactly a 32 bytes key, in the legacy or in the current format:
+// a malformed, truncated or too long file returns a KeyFileCorruptionError
 func readKey(filename, pathFormat string) ([keySize]byte, error) {
 	var data32 [keySize]byte
 
@@ -80,15 +92,15 @@ func readKey(filename, pathFormat string) ([keySize]byte, error) {
 	if err != nil {
 		return data32, err
 	}
-	// decode from hex
-	dst := make([]byte, len(data))
-	_, err = hex.Decode(dst, data) //.StdEncoding.Decode(dst, data)
+	key, _, err := decodeKeyFile(filename, data)
 	if err != nil {
 		return data32, err
 	}
-	// fill in the 32 byte array witht he data and return it
-	copy(data32[:], dst[:keySize])
-	return data32, err
+	if len(key) != keySize {
+		return data32, &KeyFileCorruptionError{Name: filename, Reason: fmt.Sprintf("%d bytes key, expected %d", len(key), keySize), Err: KeySizeError}
+	}
+	copy(data32[:], key)
+	return data32, nil
 }
 
 // Write the key file hex encoded
@@ -99,12 +111,87 @@ func writeKey(filename, pathFormat string, data []byte) error {
 	return writeFile(filePath, dst)
 }
 
-// Check if the file or directory exists and then deletes it
+// Check if the file or directory exists and then deletes it, the regular files are shredded
 func deleteFile(filename string) error {
-	if fileExists(filename) {
-		return os.Remove(filename)
+	info, err := os.Lstat(filename)
+	if err != nil {
+		return nil
+	}
+	if info.Mode().IsRegular() {
+		return ShredFile(filename)
+	}
+	return os.Remove(filename)
+}
+
+// This function overwrites the file with random data several times, then truncates, renames and removes it,
+// for the clear text files and the retired key files. The key files are shredded whenever a FileKeyStore deletes them.
+// It's a best effort: the SSDs remap the writes to other blocks, and the journaling or copy on write file systems,
+// the snapshots and the backups can keep copies of the data. Encrypt the disk to protect against those.
+func ShredFile(filename string) error {
+	info, err := os.Lstat(filename)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return ShredError
+	}
+
+	// the key files are read only
+	if err := os.Chmod(filename, 0600); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := overwriteFile(file, info.Size()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// the name is shredded as well, as far as the file system allows it
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err == nil {
+		renamed := filepath.Join(filepath.Dir(filename), fmt.Sprintf(".%x", name))
+		if err := os.Rename(filename, renamed); err == nil {
+			filename = renamed
+		}
+	}
+	return os.Remove(filename)
+}
+
+// overwrites the content of the file, syncing each pass to the disk, and truncates it
+func overwriteFile(file *os.File, size int64) error {
+	buffer := make([]byte, shredBufferSize)
+	for pass := 0; pass < shredPasses; pass++ {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		for written := int64(0); written < size; {
+			chunk := buffer
+			if remaining := size - written; remaining < int64(len(chunk)) {
+				chunk = chunk[:remaining]
+			}
+			if _, err := rand.Read(chunk); err != nil {
+				return err
+			}
+			n, err := file.Write(chunk)
+			if err != nil {
+				return err
+			}
+			written += int64(n)
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
 	}
-	return nil
+	return file.Sync()
 }
 
 func createBaseKeyFolder(path string) error {
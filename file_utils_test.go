@@ -3,7 +3,9 @@ package cryptoengine
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -109,3 +111,45 @@ func TestKeyFileUtils(t *testing.T) {
 	}
 
 }
+
+func TestShredFile(t *testing.T) {
+
+	folder := t.TempDir()
+	filename := filepath.Join(folder, "plain.txt")
+	if err := writeFile(filename, bytes.Repeat([]byte("secret"), 10000)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ShredFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(filename) {
+		t.Fatal("The shredded file should have been removed")
+	}
+	if entries, err := ioutil.ReadDir(folder); err != nil || len(entries) != 0 {
+		t.Fatalf("The folder should be empty: %v %v\n", entries, err)
+	}
+
+	if err := ShredFile(filename); !os.IsNotExist(err) {
+		t.Fatalf("Expected a not exist error, got %v\n", err)
+	}
+	if err := ShredFile(folder); err != ShredError {
+		t.Fatalf("Expected %v, got %v\n", ShredError, err)
+	}
+
+	// the file key store shreds the deleted keys
+	store, err := NewFileKeyStore(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteKey("retired.key", make([]byte, keySize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteKey("retired.key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadKey("retired.key"); err != KeyNotFoundError {
+		t.Fatalf("Expected %v, got %v\n", KeyNotFoundError, err)
+	}
+
+}
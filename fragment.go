@@ -0,0 +1,260 @@
+package cryptoengine
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"sync"
+	"time"
+)
+
+// Fragmentation for the transports with small frame limits, like LoRa, BLE or UDP: the Fragmenter splits an encrypted message
+// into fragments of at most the MTU and the Reassembler of the peer puts them back together, in any order.
+// Each fragment is authenticated on its own, so forged fragments are dropped before they take memory in the Reassembler.
+// Format:
+// |version| => 1 byte
+// |id|      => 8 bytes (random identifier of the message)
+// |seq|     => 2 bytes (little endian index of the fragment)
+// |total|   => 2 bytes (little endian amount of fragments)
+// |payload| => N bytes
+// |mac|     => 16 bytes (HMAC-SHA-256 of all the above, truncated)
+
+const (
+	fragmentVersion        = 1
+	fragmentIdSize         = 8
+	fragmentHeaderSize     = 1 + fragmentIdSize + 2 + 2
+	fragmentMACSize        = 16
+	fragmentOverhead       = fragmentHeaderSize + fragmentMACSize
+	fragmentMaxCount       = 65535
+	fragmentSubKeyLabel    = "fragment"
+	fragmentPublicKeyLabel = "cryptoengine fragment public key"
+	reassemblerMaxPending  = 256 // amount of messages reassembled at once
+)
+
+var (
+	MTUError           = errors.New("The MTU is too small to hold a fragment")
+	FragmentError      = errors.New("The fragment is not valid")
+	FragmentCountError = errors.New("The data needs more fragments than allowed")
+	FragmentLimitError = errors.New("Too many messages are being reassembled")
+)
+
+// The Fragmenter splits the data into authenticated fragments
+type Fragmenter struct {
+	key [keySize]byte
+	mtu int
+}
+
+// The Reassembler verifies the fragments and returns the data once all its fragments have been received.
+// The messages which are not complete within the timeout are dropped. It's safe for concurrent use.
+type Reassembler struct {
+	key            [keySize]byte
+	timeout        time.Duration
+	maxMessageSize uint64
+	mutex          sync.Mutex
+	pending        map[[fragmentIdSize]byte]*pendingMessage
+}
+
+// the fragments received of a message
+type pendingMessage struct {
+	fragments [][]byte
+	received  int
+	size      uint64
+	expires   time.Time
+}
+
+// This method returns a fragmenter keyed with the engine secret key, for a Reassembler of an engine sharing it
+func (engine *CryptoEngine) NewFragmenter(mtu int) (*Fragmenter, error) {
+	key, err := engine.deriveSubKey(fragmentSubKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return newFragmenter(key, mtu)
+}
+
+// This method returns a fragmenter keyed with the key shared with the peer, for the Reassembler of the peer
+func (engine *CryptoEngine) NewFragmenterWithPubKey(mtu int, verificationEngine VerificationEngine) (*Fragmenter, error) {
+	key, err := engine.fragmentPublicKey(verificationEngine.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return newFragmenter(key, mtu)
+}
+
+// This method returns a reassembler for the fragments of a Fragmenter keyed with the engine secret key
+func (engine *CryptoEngine) NewReassembler(timeout time.Duration) (*Reassembler, error) {
+	key, err := engine.deriveSubKey(fragmentSubKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return engine.newReassembler(key, timeout), nil
+}
+
+// This method returns a reassembler for the fragments of the Fragmenter of the peer
+func (engine *CryptoEngine) NewReassemblerWithPubKey(timeout time.Duration, verificationEngine VerificationEngine) (*Reassembler, error) {
+	key, err := engine.fragmentPublicKey(verificationEngine.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return engine.newReassembler(key, timeout), nil
+}
+
+func newFragmenter(key [keySize]byte, mtu int) (*Fragmenter, error) {
+	if mtu <= fragmentOverhead {
+		return nil, MTUError
+	}
+	return &Fragmenter{key: key, mtu: mtu}, nil
+}
+
+func (engine *CryptoEngine) newReassembler(key [keySize]byte, timeout time.Duration) *Reassembler {
+	return &Reassembler{
+		key:            key,
+		timeout:        timeout,
+		maxMessageSize: engine.maxMessageSize(),
+		pending:        make(map[[fragmentIdSize]byte]*pendingMessage),
+	}
+}
+
+// derives the fragments key from the key shared with the peer
+func (engine *CryptoEngine) fragmentPublicKey(peerPublicKey [keySize]byte) ([keySize]byte, error) {
+	var key [keySize]byte
+	sharedKey := engine.preSharedKey(peerPublicKey)
+	kdf := hkdf.New(sha256.New, sharedKey[:], nil, []byte(fragmentPublicKeyLabel))
+	if _, err := io.ReadFull(kdf, key[:]); err != nil {
+		return key, err
+	}
+	return key, nil
+}
+
+// This method splits the data, for instance a serialized EncryptedMessage, into fragments of at most the MTU
+func (fragmenter *Fragmenter) Fragment(data []byte) ([][]byte, error) {
+	payloadSize := fragmenter.mtu - fragmentOverhead
+	total := (len(data) + payloadSize - 1) / payloadSize
+	if total == 0 {
+		total = 1
+	}
+	if total > fragmentMaxCount {
+		return nil, FragmentCountError
+	}
+
+	var id [fragmentIdSize]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	fragments := make([][]byte, total)
+	for seq := range fragments {
+		payload := data[seq*payloadSize:]
+		if len(payload) > payloadSize {
+			payload = payload[:payloadSize]
+		}
+
+		fragment := make([]byte, fragmentHeaderSize, fragmentOverhead+len(payload))
+		fragment[0] = fragmentVersion
+		copy(fragment[1:], id[:])
+		binary.LittleEndian.PutUint16(fragment[1+fragmentIdSize:], uint16(seq))
+		binary.LittleEndian.PutUint16(fragment[3+fragmentIdSize:], uint16(total))
+		fragment = append(fragment, payload...)
+		fragments[seq] = append(fragment, fragmentMAC(fragmenter.key, fragment)...)
+	}
+	return fragments, nil
+}
+
+// This method adds the fragment and returns the data once all the fragments of the message have been received,
+// or nil while some are missing. The duplicated fragments are ignored.
+func (reassembler *Reassembler) Add(fragment []byte) ([]byte, error) {
+	return reassembler.add(fragment, time.Now())
+}
+
+// This method returns the amount of messages which are waiting for fragments
+func (reassembler *Reassembler) Pending() int {
+	reassembler.mutex.Lock()
+	defer reassembler.mutex.Unlock()
+	reassembler.expire(time.Now())
+	return len(reassembler.pending)
+}
+
+func (reassembler *Reassembler) add(fragment []byte, now time.Time) ([]byte, error) {
+	if len(fragment) < fragmentOverhead || fragment[0] != fragmentVersion {
+		return nil, FragmentError
+	}
+
+	// verify the fragment before keeping anything of it
+	body := fragment[:len(fragment)-fragmentMACSize]
+	if !hmac.Equal(fragmentMAC(reassembler.key, body), fragment[len(body):]) {
+		return nil, FragmentError
+	}
+
+	var id [fragmentIdSize]byte
+	copy(id[:], body[1:])
+	seq := int(binary.LittleEndian.Uint16(body[1+fragmentIdSize:]))
+	total := int(binary.LittleEndian.Uint16(body[3+fragmentIdSize:]))
+	payload := body[fragmentHeaderSize:]
+	if total == 0 || seq >= total {
+		return nil, FragmentError
+	}
+
+	// the message fits in a single fragment
+	if total == 1 {
+		return append([]byte{}, payload...), nil
+	}
+
+	reassembler.mutex.Lock()
+	defer reassembler.mutex.Unlock()
+	reassembler.expire(now)
+
+	message, ok := reassembler.pending[id]
+	if !ok {
+		if len(reassembler.pending) >= reassemblerMaxPending {
+			return nil, FragmentLimitError
+		}
+		message = &pendingMessage{
+			fragments: make([][]byte, total),
+			expires:   now.Add(reassembler.timeout),
+		}
+		reassembler.pending[id] = message
+	}
+
+	if len(message.fragments) != total {
+		return nil, FragmentError
+	}
+	if message.fragments[seq] != nil {
+		return nil, nil
+	}
+
+	message.size += uint64(len(payload))
+	if message.size > reassembler.maxMessageSize {
+		delete(reassembler.pending, id)
+		return nil, MessageTooLargeError
+	}
+	message.fragments[seq] = append([]byte{}, payload...)
+	message.received++
+	if message.received < total {
+		return nil, nil
+	}
+
+	delete(reassembler.pending, id)
+	data := make([]byte, 0, message.size)
+	for _, payload := range message.fragments {
+		data = append(data, payload...)
+	}
+	return data, nil
+}
+
+// drops the messages which have not been completed within the timeout
+func (reassembler *Reassembler) expire(now time.Time) {
+	for id, message := range reassembler.pending {
+		if now.After(message.expires) {
+			delete(reassembler.pending, id)
+		}
+	}
+}
+
+func fragmentMAC(key [keySize]byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write(data)
+	return mac.Sum(nil)[:fragmentMACSize]
+}
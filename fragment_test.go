@@ -0,0 +1,202 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFragmentation(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog, many times over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a LoRa frame
+	fragmenter, err := engine.NewFragmenter(51)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragments, err := fragmenter.Fragment(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fragments) < 2 {
+		t.Fatalf("Expected several fragments, instead we've got %d\n", len(fragments))
+	}
+	for _, fragment := range fragments {
+		if len(fragment) > 51 {
+			t.Fatalf("The fragment exceeds the MTU: %d bytes\n", len(fragment))
+		}
+	}
+
+	reassembler, err := engine.NewReassembler(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// out of order, with a duplicate and a forged fragment
+	forged := append([]byte{}, fragments[0]...)
+	forged[fragmentHeaderSize] ^= 1
+	if _, err := reassembler.Add(forged); err != FragmentError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", FragmentError, err)
+	}
+
+	var reassembled []byte
+	for i := len(fragments) - 1; i >= 0; i-- {
+		result, err := reassembler.Add(fragments[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && result != nil {
+			t.Fatal("The data has been returned before all the fragments have been received")
+		}
+		if i == len(fragments)-1 {
+			if result, err := reassembler.Add(fragments[i]); result != nil || err != nil {
+				t.Fatal("The duplicated fragment has not been ignored")
+			}
+		}
+		reassembled = result
+	}
+
+	if !bytes.Equal(reassembled, data) {
+		t.Fatal("The reassembled data does not match the original one")
+	}
+	if reassembler.Pending() != 0 {
+		t.Fatal("The reassembled message is still pending")
+	}
+
+	decrypted, err := engine.Decrypt(reassembled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text {
+		t.Fatal("The decrypted message does not match the original one")
+	}
+
+	if _, err := engine.NewFragmenter(fragmentOverhead); err != MTUError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MTUError, err)
+	}
+
+}
+
+func TestFragmentationPublicKey(t *testing.T) {
+
+	alice, err := InitCryptoEngine("Sec51FragmentAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := InitCryptoEngine("Sec51FragmentBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alicePeer, err := NewVerificationEngineWithKey(alice.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobPeer, err := NewVerificationEngineWithKey(bob.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragmenter, err := alice.NewFragmenterWithPubKey(100, bobPeer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("fragment"), 50)
+	fragments, err := fragmenter.Fragment(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reassembler, err := bob.NewReassemblerWithPubKey(time.Minute, alicePeer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reassembled []byte
+	for _, fragment := range fragments {
+		if reassembled, err = reassembler.Add(fragment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(reassembled, data) {
+		t.Fatal("The reassembled data does not match the original one")
+	}
+
+	// the fragments of another key are rejected
+	other, err := bob.NewReassembler(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Add(fragments[0]); err != FragmentError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", FragmentError, err)
+	}
+
+}
+
+func TestReassemblerTimeout(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragmenter, err := engine.NewFragmenter(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragments, err := fragmenter.Fragment(bytes.Repeat([]byte{1}, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reassembler, err := engine.NewReassembler(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if _, err := reassembler.add(fragments[0], now); err != nil {
+		t.Fatal(err)
+	}
+
+	// the message has expired: the late fragments start a new one
+	for _, fragment := range fragments[1:] {
+		if result, err := reassembler.add(fragment, now.Add(2*time.Second)); result != nil || err != nil {
+			t.Fatal("The expired message has been reassembled")
+		}
+	}
+
+	// too many messages at once
+	if reassembler, err = engine.NewReassembler(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < reassemblerMaxPending; i++ {
+		fragments, err := fragmenter.Fragment(bytes.Repeat([]byte{1}, 200))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reassembler.Add(fragments[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := reassembler.Add(fragments[0]); err != FragmentLimitError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", FragmentLimitError, err)
+	}
+
+}
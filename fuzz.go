@@ -0,0 +1,95 @@
+//go:build gofuzz
+// +build gofuzz
+
+package cryptoengine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Fuzz entry points for go-fuzz (https://github.com/dvyukov/go-fuzz), built only with the gofuzz tag.
+// The work directory of each entry point, with its corpus, is in the fuzzing folder:
+//
+//	go-fuzz-build -func FuzzDecrypt github.com/sec51/cryptoengine
+//	go-fuzz -bin cryptoengine-fuzz.zip -workdir fuzzing/decrypt
+//
+// The messages are decrypted by the deterministic engines of the test vectors, so the vectors are valid inputs of the corpus.
+
+var (
+	fuzzOnce      sync.Once
+	fuzzSender    *CryptoEngine
+	fuzzRecipient *CryptoEngine
+	fuzzPeer      VerificationEngine
+	fuzzKeyFolder string
+)
+
+// creates the engines and the key folder shared by all the runs
+func fuzzSetup() {
+	fuzzOnce.Do(func() {
+		var err error
+		if fuzzSender, err = vectorEngine("sender"); err != nil {
+			panic(err)
+		}
+		if fuzzRecipient, err = vectorEngine("recipient"); err != nil {
+			panic(err)
+		}
+		if fuzzPeer, err = NewVerificationEngineWithKey(fuzzSender.PublicKey()); err != nil {
+			panic(err)
+		}
+		if fuzzKeyFolder, err = ioutil.TempDir("", "cryptoengine-fuzz"); err != nil {
+			panic(err)
+		}
+	})
+}
+
+// This function parses the data as an encrypted message
+func FuzzMessageFromBytes(data []byte) int {
+	if _, err := MessageFromBytes(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// This function decrypts the data with all the decryption methods of the message formats
+func FuzzDecrypt(data []byte) int {
+	fuzzSetup()
+
+	result := 0
+	if _, err := fuzzSender.Decrypt(data); err == nil {
+		result = 1
+	}
+	if _, err := fuzzRecipient.DecryptWithPublicKey(data, fuzzPeer); err == nil {
+		result = 1
+	}
+	if _, _, err := fuzzSender.DecryptWithHeader(data); err == nil {
+		result = 1
+	}
+	if _, _, err := fuzzRecipient.DecryptWithHeaderAndPublicKey(data, fuzzPeer); err == nil {
+		result = 1
+	}
+	return result
+}
+
+// This function reads the data as a key file, with readKey and with the FileKeyStore
+func FuzzReadKey(data []byte) int {
+	fuzzSetup()
+
+	const name = "fuzz_secret.key"
+	path := filepath.Join(fuzzKeyFolder, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		panic(err)
+	}
+	defer os.Remove(path)
+
+	result := 0
+	if _, err := readKey(name, filepath.Join(fuzzKeyFolder, "%s")); err == nil {
+		result = 1
+	}
+	if _, err := readStoreKey(&FileKeyStore{path: fuzzKeyFolder}, name); err == nil {
+		result = 1
+	}
+	return result
+}
@@ -0,0 +1,111 @@
+// Package gormcrypto provides a GORM serializer which encrypts the model fields with the cryptoengine column engines.
+//
+// The serializer encrypts the string and []byte fields with the newest engine registered with cryptoengine.RegisterColumnEngineVersion
+// and stores them as armored strings, together with the key version, so that the values written before a key rotation can still be read.
+// The key version a value has been read with can be copied in another field of the model, named with the keyversion tag setting,
+// to find the records which need to be re-encrypted.
+//
+//	schema.RegisterSerializer("encrypted", gormcrypto.Serializer{})
+//
+//	type User struct {
+//		ID              uint
+//		Email           string `gorm:"serializer:encrypted;keyversion:EmailKeyVersion"`
+//		EmailKeyVersion uint32 `gorm:"-"`
+//	}
+package gormcrypto
+
+import (
+	"context"
+	"errors"
+	"github.com/sec51/cryptoengine"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+const (
+	keyVersionTagSetting = "KEYVERSION"
+)
+
+var (
+	FieldTypeError  = errors.New("The encrypted field is not a string or []byte")
+	ValueTypeError  = errors.New("The encrypted column value type is not valid")
+	KeyVersionError = errors.New("The key version field is not a uint32")
+)
+
+// The Serializer encrypts the fields with the column engine registered under the Context name, empty for the default one.
+// It implements the schema.SerializerInterface of GORM.
+type Serializer struct {
+	Context string
+}
+
+// This method decrypts the column value and sets the model field
+func (serializer Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	fieldValue := reflect.New(field.FieldType).Elem()
+
+	var armored string
+	switch dbValue := dbValue.(type) {
+	case nil:
+	case string:
+		armored = dbValue
+	case []byte:
+		armored = string(dbValue)
+	default:
+		return ValueTypeError
+	}
+
+	var clearText []byte
+	var version uint32
+	if armored != "" {
+		var err error
+		if clearText, version, err = cryptoengine.DecryptColumn(serializer.Context, armored); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case field.FieldType.Kind() == reflect.String:
+		fieldValue.SetString(string(clearText))
+	case field.FieldType.Kind() == reflect.Slice && field.FieldType.Elem().Kind() == reflect.Uint8:
+		if dbValue != nil {
+			fieldValue.SetBytes(append([]byte{}, clearText...))
+		}
+	default:
+		return FieldTypeError
+	}
+	field.ReflectValueOf(ctx, dst).Set(fieldValue)
+
+	// copy the key version in the field named in the tag settings, if any
+	if name := field.TagSettings[keyVersionTagSetting]; name != "" {
+		versionField := reflect.Indirect(dst).FieldByName(name)
+		if !versionField.IsValid() || versionField.Kind() != reflect.Uint32 || !versionField.CanSet() {
+			return KeyVersionError
+		}
+		versionField.SetUint(uint64(version))
+	}
+
+	return nil
+}
+
+// This method encrypts the model field and returns the column value
+func (serializer Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	var clearText []byte
+	switch fieldValue := fieldValue.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		clearText = []byte(fieldValue)
+	case []byte:
+		if fieldValue == nil {
+			return nil, nil
+		}
+		clearText = fieldValue
+	default:
+		return nil, FieldTypeError
+	}
+
+	// empty values are stored as they are
+	if len(clearText) == 0 {
+		return "", nil
+	}
+	return cryptoengine.EncryptColumn(serializer.Context, clearText)
+}
@@ -0,0 +1,114 @@
+package gormcrypto
+
+import (
+	"context"
+	"github.com/sec51/cryptoengine"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// make sure the serializer implements the GORM interface
+var _ schema.SerializerInterface = Serializer{}
+
+type user struct {
+	ID              uint
+	Email           string `gorm:"serializer:encrypted;keyversion:EmailKeyVersion"`
+	EmailKeyVersion uint32 `gorm:"-"`
+	Token           []byte `gorm:"serializer:encrypted"`
+}
+
+// returns the schema field of the user struct, as GORM parses it
+func userField(name string) *schema.Field {
+	structField, _ := reflect.TypeOf(user{}).FieldByName(name)
+	field := &schema.Field{
+		Name:        name,
+		FieldType:   structField.Type,
+		Tag:         structField.Tag,
+		TagSettings: map[string]string{},
+		ReflectValueOf: func(ctx context.Context, value reflect.Value) reflect.Value {
+			return reflect.Indirect(value).FieldByName(name)
+		},
+	}
+	if name == "Email" {
+		field.TagSettings[keyVersionTagSetting] = "EmailKeyVersion"
+	}
+	return field
+}
+
+func TestSerializer(t *testing.T) {
+
+	oldEngine, err := cryptoengine.InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newEngine, err := cryptoengine.InitCryptoEngine("Sec51Rotated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cryptoengine.RegisterColumnEngineVersion("gorm", 1, oldEngine)
+	defer cryptoengine.RegisterColumnEngineVersion("gorm", 1, nil)
+
+	serializer := Serializer{Context: "gorm"}
+	ctx := context.Background()
+
+	model := user{ID: 1, Email: "alice@example.com", Token: []byte{1, 2, 3}}
+	emailValue, err := serializer.Value(ctx, userField("Email"), reflect.ValueOf(&model), model.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenValue, err := serializer.Value(ctx, userField("Token"), reflect.ValueOf(&model), model.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(emailValue.(string), "ce1:1:") {
+		t.Fatal("The field has not been encrypted with the key version")
+	}
+
+	// rotate the key: the old values are still readable
+	cryptoengine.RegisterColumnEngineVersion("gorm", 2, newEngine)
+	defer cryptoengine.RegisterColumnEngineVersion("gorm", 2, nil)
+
+	var scanned user
+	if err := serializer.Scan(ctx, userField("Email"), reflect.ValueOf(&scanned), []byte(emailValue.(string))); err != nil {
+		t.Fatal(err)
+	}
+	if err := serializer.Scan(ctx, userField("Token"), reflect.ValueOf(&scanned), tokenValue); err != nil {
+		t.Fatal(err)
+	}
+
+	if scanned.Email != model.Email || !reflect.DeepEqual(scanned.Token, model.Token) {
+		t.Fatal("The decrypted fields do not match the original ones")
+	}
+
+	if scanned.EmailKeyVersion != 1 {
+		t.Fatal("The key version field has not been set")
+	}
+
+	emailValue, err = serializer.Value(ctx, userField("Email"), reflect.ValueOf(&scanned), scanned.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serializer.Scan(ctx, userField("Email"), reflect.ValueOf(&scanned), emailValue); err != nil {
+		t.Fatal(err)
+	}
+	if scanned.Email != model.Email || scanned.EmailKeyVersion != 2 {
+		t.Fatal("The field has not been re-encrypted with the new key version")
+	}
+
+	// NULL columns
+	if err := serializer.Scan(ctx, userField("Token"), reflect.ValueOf(&scanned), nil); err != nil {
+		t.Fatal(err)
+	}
+	if scanned.Token != nil {
+		t.Fatal("The NULL column has not been scanned as nil")
+	}
+
+	if err := serializer.Scan(ctx, userField("Email"), reflect.ValueOf(&scanned), 42); err != ValueTypeError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ValueTypeError, err)
+	}
+}
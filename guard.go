@@ -0,0 +1,151 @@
+package cryptoengine
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Decryption failure rate limiting: an endpoint which decrypts the messages of untrusted sources is an oracle
+// an attacker can probe with forged messages. The DecryptionGuard counts the MessageDecryptionError of each source,
+// for instance a peer identifier or a remote address, and once a source reaches the allowed failures it's locked out:
+// its messages are rejected without being decrypted for the lockout duration, which doubles at each further failure.
+// A successful decryption resets the source, and the failures are forgotten after the maximum lockout.
+
+const (
+	guardMaxSources  = 65536 // amount of sources tracked at once, the expired and then arbitrary ones are dropped above it
+	guardLockoutCaps = 16    // the lockout doubles at most 16 times
+)
+
+var (
+	DecryptionLockedError = errors.New("Too many decryption failures: the source is temporarily locked out")
+)
+
+// The DecryptionGuard wraps an engine and locks out the sources of repeated decryption failures. It's safe for concurrent use.
+type DecryptionGuard struct {
+	engine      *CryptoEngine
+	maxFailures int
+	lockout     time.Duration
+	mutex       sync.Mutex
+	sources     map[string]*guardSource
+}
+
+// the failures of a source
+type guardSource struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// This method returns a guard which locks a source out for lockout after maxFailures consecutive decryption failures.
+// Each further failure doubles the lockout.
+func (engine *CryptoEngine) NewDecryptionGuard(maxFailures int, lockout time.Duration) *DecryptionGuard {
+	if maxFailures < 1 {
+		maxFailures = 1
+	}
+	return &DecryptionGuard{
+		engine:      engine,
+		maxFailures: maxFailures,
+		lockout:     lockout,
+		sources:     make(map[string]*guardSource),
+	}
+}
+
+// This method decrypts the message of the source like CryptoEngine.Decrypt, unless the source is locked out
+func (guard *DecryptionGuard) Decrypt(source string, encryptedBytes []byte) (*Message, error) {
+	return guard.decrypt(source, time.Now(), func() (*Message, error) {
+		return guard.engine.Decrypt(encryptedBytes)
+	})
+}
+
+// This method decrypts the message of the source like CryptoEngine.DecryptWithPublicKey, unless the source is locked out
+func (guard *DecryptionGuard) DecryptWithPublicKey(source string, encryptedBytes []byte, verificationEngine VerificationEngine) (*Message, error) {
+	return guard.decrypt(source, time.Now(), func() (*Message, error) {
+		return guard.engine.DecryptWithPublicKey(encryptedBytes, verificationEngine)
+	})
+}
+
+// This method returns the time until which the source is locked out, the zero time when it's not
+func (guard *DecryptionGuard) LockedUntil(source string) time.Time {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+	if state, ok := guard.sources[source]; ok && time.Now().Before(state.lockedUntil) {
+		return state.lockedUntil
+	}
+	return time.Time{}
+}
+
+// This method forgets the failures of the source, lifting its lockout
+func (guard *DecryptionGuard) Reset(source string) {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+	delete(guard.sources, source)
+}
+
+func (guard *DecryptionGuard) decrypt(source string, now time.Time, decrypt func() (*Message, error)) (*Message, error) {
+	if guard.locked(source, now) {
+		return nil, DecryptionLockedError
+	}
+
+	msg, err := decrypt()
+	switch err {
+	case nil:
+		guard.Reset(source)
+	case MessageDecryptionError:
+		guard.fail(source, now)
+	}
+	return msg, err
+}
+
+func (guard *DecryptionGuard) locked(source string, now time.Time) bool {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+	state, ok := guard.sources[source]
+	return ok && now.Before(state.lockedUntil)
+}
+
+// counts the failure and locks the source out once it reaches the allowed failures
+func (guard *DecryptionGuard) fail(source string, now time.Time) {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	state, ok := guard.sources[source]
+	if !ok || guard.expired(state, now) {
+		guard.prune(now)
+		state = &guardSource{}
+		guard.sources[source] = state
+	}
+
+	state.failures++
+	state.lastFailure = now
+
+	if excess := state.failures - guard.maxFailures; excess >= 0 {
+		if excess > guardLockoutCaps {
+			excess = guardLockoutCaps
+		}
+		state.lockedUntil = now.Add(guard.lockout << uint(excess))
+	}
+}
+
+// the failures are forgotten once the source could have been locked out for the maximum lockout
+func (guard *DecryptionGuard) expired(state *guardSource, now time.Time) bool {
+	return now.Sub(state.lastFailure) > guard.lockout<<guardLockoutCaps && !now.Before(state.lockedUntil)
+}
+
+// drops the expired sources when the guard is full, then arbitrary ones, the caller holds the mutex
+func (guard *DecryptionGuard) prune(now time.Time) {
+	if len(guard.sources) < guardMaxSources {
+		return
+	}
+	for source, state := range guard.sources {
+		if guard.expired(state, now) {
+			delete(guard.sources, source)
+		}
+	}
+	for source := range guard.sources {
+		if len(guard.sources) < guardMaxSources {
+			break
+		}
+		delete(guard.sources, source)
+	}
+}
@@ -0,0 +1,86 @@
+package cryptoengine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecryptionGuard(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := append([]byte{}, valid...)
+	forged[len(forged)-1] ^= 1
+
+	guard := engine.NewDecryptionGuard(3, time.Minute)
+	now := time.Now()
+	decrypt := func(source string, data []byte, at time.Time) error {
+		_, err := guard.decrypt(source, at, func() (*Message, error) {
+			return engine.Decrypt(data)
+		})
+		return err
+	}
+
+	// the allowed failures
+	for i := 0; i < 3; i++ {
+		if err := decrypt("attacker", forged, now); err != MessageDecryptionError {
+			t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageDecryptionError, err)
+		}
+	}
+
+	// locked out, even the valid messages are rejected without being decrypted
+	if err := decrypt("attacker", valid, now.Add(30*time.Second)); err != DecryptionLockedError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", DecryptionLockedError, err)
+	}
+
+	// the other sources are not affected
+	if err := decrypt("client", valid, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// once the lockout expires a further failure doubles it
+	if err := decrypt("attacker", forged, now.Add(time.Minute)); err != MessageDecryptionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageDecryptionError, err)
+	}
+	if err := decrypt("attacker", valid, now.Add(time.Minute+90*time.Second)); err != DecryptionLockedError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", DecryptionLockedError, err)
+	}
+
+	// a successful decryption resets the source
+	if err := decrypt("attacker", valid, now.Add(3*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := decrypt("attacker", forged, now.Add(3*time.Minute)); err != MessageDecryptionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageDecryptionError, err)
+	}
+	if err := decrypt("attacker", valid, now.Add(3*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lockout can be lifted
+	for i := 0; i < 3; i++ {
+		guard.Decrypt("locked", forged)
+	}
+	if guard.LockedUntil("locked").IsZero() {
+		t.Fatal("The source has not been locked out")
+	}
+	guard.Reset("locked")
+	if _, err := guard.Decrypt("locked", valid); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,401 @@
+package cryptoengine
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"sync"
+)
+
+// Authenticated key exchange between two engines, over any byte transport.
+// It's a SIGMA style exchange: ephemeral X25519 keys, Ed25519 signatures of the transcript with the engines signing keys
+// and HMAC key confirmation in both directions.
+//
+// initiator                                            responder
+// hello:     type|random|ephemeral|count|versions ->
+//                                                <-    key share: type|random|ephemeral|version|signature|mac
+// confirm:   type|signature|mac                   ->
+//
+// Both sides must know the peer signing public key in advance (the VerificationEngine).
+//
+// The initiator offers the protocol versions it supports and the responder selects the highest one it supports too.
+// The offered and the selected versions are part of the signed transcript, so an active attacker who removes the offers
+// or changes the selection makes the handshake fail instead of downgrading the peers.
+// A hello without the versions, from an initiator older than the negotiation, is answered with version 1 and a key share without the version.
+
+const (
+	handshakeHello    = 1
+	handshakeKeyShare = 2
+	handshakeConfirm  = 3
+
+	handshakeRandomSize = 32
+	handshakeMacSize    = sha256.Size
+
+	handshakeHelloSize    = 1 + handshakeRandomSize + curve25519.PointSize
+	handshakeKeyShareSize = handshakeHelloSize + ed25519.SignatureSize + handshakeMacSize
+	handshakeConfirmSize  = 1 + ed25519.SignatureSize + handshakeMacSize
+
+	handshakeInitiatorLabel = "cryptoengine handshake initiator"
+	handshakeResponderLabel = "cryptoengine handshake responder"
+	handshakeSessionLabel   = "cryptoengine handshake session"
+
+	HandshakeVersion1 = 1
+)
+
+var (
+	HandshakeError             = errors.New("The handshake message is not valid")
+	HandshakeStateError        = errors.New("The handshake is not in the expected state")
+	HandshakeConfirmationError = errors.New("The handshake key confirmation failed")
+	HandshakeVersionError      = errors.New("The peers do not support a common handshake version")
+
+	// the supported handshake versions, in descending order of preference
+	handshakeVersions = []uint8{HandshakeVersion1}
+)
+
+// handshake states
+const (
+	handshakeStarted = iota
+	handshakeWaitingKeyShare
+	handshakeWaitingConfirm
+	handshakeCompleted
+	handshakeFailed
+)
+
+// The Handshake holds the state of a key exchange with a peer
+type Handshake struct {
+	engine         *CryptoEngine
+	peer           VerificationEngine
+	state          int
+	initiator      bool          // true on the side which sent the hello
+	ephemeral      []byte        // ephemeral X25519 private key
+	secret         []byte        // the ephemeral Diffie-Hellman shared secret
+	transcript     []byte        // the messages exchanged so far, without the confirmation macs
+	channelBinding []byte        // the optional channel binding of the outer transport, signed but never sent
+	offered        []uint8       // the versions offered by the initiator, empty for a hello without the versions
+	version        uint8         // the selected version
+	sessionKey     [keySize]byte // the established session key
+	muxOnce        sync.Once     // a single Mux runs over the session, see NewMux
+}
+
+// This method starts a handshake with the peer and returns the hello message to send to it
+func (engine *CryptoEngine) InitiateHandshake(peer VerificationEngine) (*Handshake, []byte, error) {
+	return engine.InitiateHandshakeWithBinding(peer, nil)
+}
+
+// This method starts a handshake bound to the outer channel, see TLSChannelBinding.
+// Both peers must use the same binding, otherwise the handshake fails with SignatureError.
+func (engine *CryptoEngine) InitiateHandshakeWithBinding(peer VerificationEngine, channelBinding []byte) (*Handshake, []byte, error) {
+
+	h, err := engine.newHandshake(peer, channelBinding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hello, err := h.share(handshakeHello)
+	if err != nil {
+		return nil, nil, err
+	}
+	h.initiator = true
+	h.offered = append([]uint8{}, handshakeVersions...)
+	hello = append(append(hello, uint8(len(h.offered))), h.offered...)
+
+	h.transcript = append(h.transcript, hello...)
+	h.state = handshakeWaitingKeyShare
+	return h, hello, nil
+}
+
+// This method answers the hello message of the peer and returns the key share message to send back.
+// The handshake is completed once the confirm message of the peer is verified with Confirm.
+func (engine *CryptoEngine) RespondHandshake(peer VerificationEngine, hello []byte) (*Handshake, []byte, error) {
+	return engine.RespondHandshakeWithBinding(peer, nil, hello)
+}
+
+// This method answers the hello message of a handshake bound to the outer channel, see InitiateHandshakeWithBinding
+func (engine *CryptoEngine) RespondHandshakeWithBinding(peer VerificationEngine, channelBinding, hello []byte) (*Handshake, []byte, error) {
+
+	if len(hello) < handshakeHelloSize || hello[0] != handshakeHello {
+		return nil, nil, HandshakeError
+	}
+
+	h, err := engine.newHandshake(peer, channelBinding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if h.offered, err = parseHandshakeVersions(hello[handshakeHelloSize:]); err != nil {
+		return nil, nil, err
+	}
+
+	share, err := h.share(handshakeKeyShare)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h.version = HandshakeVersion1
+	if len(h.offered) > 0 {
+		if h.version, err = selectHandshakeVersion(h.offered); err != nil {
+			return nil, nil, err
+		}
+		share = append(share, h.version)
+	}
+
+	// X25519 fails on low order points
+	if h.secret, err = curve25519.X25519(h.ephemeral, hello[1+handshakeRandomSize:handshakeHelloSize]); err != nil {
+		return nil, nil, HandshakeError
+	}
+
+	h.transcript = append(append(h.transcript, hello...), share...)
+	signature := engine.Sign(h.signedTranscript(handshakeResponderLabel))
+	h.transcript = append(h.transcript, signature...)
+
+	mac, err := h.mac(handshakeResponderLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h.state = handshakeWaitingConfirm
+	return h, append(append(share, signature...), mac...), nil
+}
+
+// This method verifies the key share message of the peer and returns the confirm message to send to it.
+// When it succeeds the handshake is completed on the initiator side.
+func (h *Handshake) Finish(keyShare []byte) ([]byte, error) {
+
+	if h.state != handshakeWaitingKeyShare {
+		return nil, HandshakeStateError
+	}
+	// any failure is final
+	h.state = handshakeFailed
+
+	// the key share carries the version selected among the offered ones
+	shareSize := handshakeHelloSize + 1
+	if len(keyShare) != shareSize+ed25519.SignatureSize+handshakeMacSize || keyShare[0] != handshakeKeyShare {
+		return nil, HandshakeError
+	}
+
+	share := keyShare[:shareSize]
+	signature := keyShare[shareSize : shareSize+ed25519.SignatureSize]
+	peerMac := keyShare[shareSize+ed25519.SignatureSize:]
+
+	h.version = share[handshakeHelloSize]
+	if !containsHandshakeVersion(h.offered, h.version) {
+		return nil, HandshakeVersionError
+	}
+
+	var err error
+	if h.secret, err = curve25519.X25519(h.ephemeral, share[1+handshakeRandomSize:handshakeHelloSize]); err != nil {
+		return nil, HandshakeError
+	}
+
+	h.transcript = append(h.transcript, share...)
+	if err := h.peer.Verify(h.signedTranscript(handshakeResponderLabel), signature); err != nil {
+		return nil, err
+	}
+	h.transcript = append(h.transcript, signature...)
+
+	if err := h.checkMac(handshakeResponderLabel, peerMac); err != nil {
+		return nil, err
+	}
+
+	// confirm message
+	confirm := []byte{handshakeConfirm}
+	signature = h.engine.Sign(h.signedTranscript(handshakeInitiatorLabel))
+	h.transcript = append(h.transcript, confirm...)
+	h.transcript = append(h.transcript, signature...)
+
+	mac, err := h.mac(handshakeInitiatorLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.complete(); err != nil {
+		return nil, err
+	}
+	return append(append(confirm, signature...), mac...), nil
+}
+
+// This method verifies the confirm message of the peer. When it succeeds the handshake is completed on the responder side.
+func (h *Handshake) Confirm(confirm []byte) error {
+
+	if h.state != handshakeWaitingConfirm {
+		return HandshakeStateError
+	}
+	h.state = handshakeFailed
+
+	if len(confirm) != handshakeConfirmSize || confirm[0] != handshakeConfirm {
+		return HandshakeError
+	}
+
+	signature := confirm[1 : 1+ed25519.SignatureSize]
+	peerMac := confirm[1+ed25519.SignatureSize:]
+
+	if err := h.peer.Verify(h.signedTranscript(handshakeInitiatorLabel), signature); err != nil {
+		return err
+	}
+	h.transcript = append(h.transcript, confirm[:1+ed25519.SignatureSize]...)
+
+	if err := h.checkMac(handshakeInitiatorLabel, peerMac); err != nil {
+		return err
+	}
+
+	return h.complete()
+}
+
+// This method returns the session key, once the handshake is completed.
+// Both peers obtain the same key, bound to the whole transcript.
+func (h *Handshake) SessionKey() ([keySize]byte, error) {
+	if h.state != handshakeCompleted {
+		return [keySize]byte{}, HandshakeStateError
+	}
+	return h.sessionKey, nil
+}
+
+// derives an independent key from the session key, for the protocol named by the label
+func sessionSubKey(sessionKey [keySize]byte, label string) ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sessionKey[:], nil, []byte(label)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// This method returns the peer verification engine the handshake authenticates
+func (h *Handshake) Peer() VerificationEngine {
+	return h.peer
+}
+
+// This method returns the negotiated protocol version, once the handshake is completed
+func (h *Handshake) Version() (uint8, error) {
+	if h.state != handshakeCompleted {
+		return 0, HandshakeStateError
+	}
+	return h.version, nil
+}
+
+// parses the versions offered in the hello: count|versions. No versions at all is a hello older than the negotiation
+func parseHandshakeVersions(data []byte) ([]uint8, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if data[0] == 0 || len(data) != 1+int(data[0]) {
+		return nil, HandshakeError
+	}
+	return append([]uint8{}, data[1:]...), nil
+}
+
+// selects the most preferred supported version among the offered ones
+func selectHandshakeVersion(offered []uint8) (uint8, error) {
+	for _, version := range handshakeVersions {
+		if containsHandshakeVersion(offered, version) {
+			return version, nil
+		}
+	}
+	return 0, HandshakeVersionError
+}
+
+func containsHandshakeVersion(versions []uint8, version uint8) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (engine *CryptoEngine) newHandshake(peer VerificationEngine, channelBinding []byte) (*Handshake, error) {
+	signingPublicKey := peer.SigningPublicKey()
+	if ConstantTimeEqual(signingPublicKey[:], emptyKey) {
+		return nil, KeyNotValidError
+	}
+
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, err
+	}
+
+	return &Handshake{
+		engine:         engine,
+		peer:           peer,
+		ephemeral:      ephemeral,
+		channelBinding: append([]byte{}, channelBinding...),
+		state:          handshakeStarted,
+	}, nil
+}
+
+// builds the hello or the key share prefix: type|random|ephemeral public key
+func (h *Handshake) share(messageType byte) ([]byte, error) {
+	message := make([]byte, 1+handshakeRandomSize, handshakeKeyShareSize)
+	message[0] = messageType
+	if _, err := rand.Read(message[1:]); err != nil {
+		return nil, err
+	}
+
+	ephemeralPublic, err := curve25519.X25519(h.ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return append(message, ephemeralPublic...), nil
+}
+
+// what each side signs: the label, the hash of the transcript and the channel binding
+func (h *Handshake) signedTranscript(label string) []byte {
+	hash := sha256.Sum256(h.transcript)
+	signed := append([]byte(label), hash[:]...)
+	return append(signed, h.channelBinding...)
+}
+
+// derives a key from the shared secret, bound to the current transcript
+func (h *Handshake) deriveKey(label string) ([]byte, error) {
+	hash := sha256.Sum256(h.transcript)
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, h.secret, hash[:], []byte(label)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// the key confirmation mac of the current transcript
+func (h *Handshake) mac(label string) ([]byte, error) {
+	key, err := h.deriveKey(label)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(h.transcript)
+	return mac.Sum(nil), nil
+}
+
+func (h *Handshake) checkMac(label string, peerMac []byte) error {
+	mac, err := h.mac(label)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(mac, peerMac) != 1 {
+		return HandshakeConfirmationError
+	}
+	return nil
+}
+
+// derives the session key and wipes the ephemeral secrets
+func (h *Handshake) complete() error {
+	key, err := h.deriveKey(handshakeSessionLabel)
+	if err != nil {
+		return err
+	}
+	copy(h.sessionKey[:], key)
+
+	for i := range h.ephemeral {
+		h.ephemeral[i] = 0
+	}
+	for i := range h.secret {
+		h.secret[i] = 0
+	}
+	h.state = handshakeCompleted
+	return nil
+}
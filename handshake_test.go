@@ -0,0 +1,255 @@
+package cryptoengine
+
+import (
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+
+	alice, err := InitCryptoEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := InitCryptoEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceVerificationEngine, err := NewVerificationEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bobVerificationEngine, err := NewVerificationEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initiator, hello, err := alice.InitiateHandshake(bobVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := initiator.SessionKey(); err != HandshakeStateError {
+		t.Errorf("The expected error is: HandshakeStateError, instead we've got: %v\n", err)
+	}
+
+	responder, keyShare, err := bob.RespondHandshake(aliceVerificationEngine, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	confirm, err := initiator.Finish(keyShare)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := responder.Confirm(confirm); err != nil {
+		t.Fatal(err)
+	}
+
+	initiatorKey, err := initiator.SessionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responderKey, err := responder.SessionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if initiatorKey != responderKey {
+		t.Fatal("The session keys do not match")
+	}
+
+	// the messages cannot be replayed
+	if _, err := initiator.Finish(keyShare); err != HandshakeStateError {
+		t.Errorf("The expected error is: HandshakeStateError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestHandshakeErrors(t *testing.T) {
+
+	alice, err := InitCryptoEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := InitCryptoEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mallory, err := InitCryptoEngine("Sec51HandshakeMallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceVerificationEngine, err := NewVerificationEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bobVerificationEngine, err := NewVerificationEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer signing key is required
+	if _, _, err := alice.InitiateHandshake(VerificationEngine{}); err != KeyNotValidError {
+		t.Errorf("The expected error is: KeyNotValidError, instead we've got: %v\n", err)
+	}
+
+	// mallory answers in place of bob
+	initiator, hello, err := alice.InitiateHandshake(bobVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, keyShare, err := mallory.RespondHandshake(aliceVerificationEngine, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := initiator.Finish(keyShare); err != SignatureError {
+		t.Errorf("The expected error is: SignatureError, instead we've got: %v\n", err)
+	}
+
+	// tampered key share
+	initiator, hello, err = alice.InitiateHandshake(bobVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responder, keyShare, err := bob.RespondHandshake(aliceVerificationEngine, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyShare[len(keyShare)-1] ^= 1
+	if _, err := initiator.Finish(keyShare); err != HandshakeConfirmationError {
+		t.Errorf("The expected error is: HandshakeConfirmationError, instead we've got: %v\n", err)
+	}
+
+	// the responder does not accept a truncated confirm
+	if err := responder.Confirm([]byte{handshakeConfirm}); err != HandshakeError {
+		t.Errorf("The expected error is: HandshakeError, instead we've got: %v\n", err)
+	}
+
+	if _, err := responder.SessionKey(); err != HandshakeStateError {
+		t.Errorf("The expected error is: HandshakeStateError, instead we've got: %v\n", err)
+	}
+
+	if _, _, err := bob.RespondHandshake(aliceVerificationEngine, []byte("hello")); err != HandshakeError {
+		t.Errorf("The expected error is: HandshakeError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestHandshakeVersionNegotiation(t *testing.T) {
+
+	alice, err := InitCryptoEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := InitCryptoEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceVerificationEngine, err := NewVerificationEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bobVerificationEngine, err := NewVerificationEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a newer version 2 is supported by both peers
+	defer func(versions []uint8) { handshakeVersions = versions }(handshakeVersions)
+	handshakeVersions = []uint8{2, HandshakeVersion1}
+
+	initiator, hello, err := alice.InitiateHandshake(bobVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, keyShare, err := bob.RespondHandshake(aliceVerificationEngine, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirm, err := initiator.Finish(keyShare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := responder.Confirm(confirm); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := initiator.Version(); err != nil || version != 2 {
+		t.Errorf("The expected version is: 2, instead we've got: %d (%v)\n", version, err)
+	}
+	if version, err := responder.Version(); err != nil || version != 2 {
+		t.Errorf("The expected version is: 2, instead we've got: %d (%v)\n", version, err)
+	}
+
+	// the attacker removes version 2 from the offers
+	initiator, hello, err = alice.InitiateHandshake(bobVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped := append(append([]byte{}, hello[:handshakeHelloSize]...), 1, HandshakeVersion1)
+	_, keyShare, err = bob.RespondHandshake(aliceVerificationEngine, stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := initiator.Finish(keyShare); err != SignatureError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", SignatureError, err)
+	}
+
+	// the attacker changes the selected version
+	initiator, hello, err = alice.InitiateHandshake(bobVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, keyShare, err = bob.RespondHandshake(aliceVerificationEngine, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyShare[handshakeHelloSize] = HandshakeVersion1
+	if _, err := initiator.Finish(keyShare); err != SignatureError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", SignatureError, err)
+	}
+
+	// a version which has not been offered
+	initiator, hello, err = alice.InitiateHandshake(bobVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, keyShare, err = bob.RespondHandshake(aliceVerificationEngine, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyShare[handshakeHelloSize] = 3
+	if _, err := initiator.Finish(keyShare); err != HandshakeVersionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", HandshakeVersionError, err)
+	}
+
+	// no common version
+	if _, _, err := bob.RespondHandshake(aliceVerificationEngine, append(append([]byte{}, hello[:handshakeHelloSize]...), 1, 3)); err != HandshakeVersionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", HandshakeVersionError, err)
+	}
+
+	// a hello without the versions is answered with version 1
+	_, keyShare, err = bob.RespondHandshake(aliceVerificationEngine, hello[:handshakeHelloSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyShare) != handshakeKeyShareSize {
+		t.Errorf("The expected key share size is: %d, instead we've got: %d\n", handshakeKeyShareSize, len(keyShare))
+	}
+
+}
@@ -0,0 +1,64 @@
+package cryptoengine
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"golang.org/x/crypto/blake2b"
+	"hash"
+)
+
+// Hashing with domain separation: the hashes of different contexts never collide, even for the same data,
+// so an application cannot mix up, for instance, the hash of a file and the hash of an identifier.
+// The context, for instance "myapp file v1", is hashed before the data with its length:
+// little endian length of the context (8 bytes)|context|data
+
+const (
+	HashSize = 32 // size in bytes of the hashes
+)
+
+// This function returns the BLAKE2b-256 hash of the data, without domain separation
+func Hash(data []byte) [HashSize]byte {
+	return blake2b.Sum256(data)
+}
+
+// This function returns the BLAKE2b-256 hash of the data in the context
+func HashWithContext(context string, data []byte) [HashSize]byte {
+	var sum [HashSize]byte
+	h := NewHashWithContext(context)
+	h.Write(data)
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// This function returns the SHA-256 hash of the data in the context, for the systems which do not support BLAKE2b
+func SHA256WithContext(context string, data []byte) [HashSize]byte {
+	var sum [HashSize]byte
+	h := &contextHash{Hash: sha256.New(), context: context}
+	h.Reset()
+	h.Write(data)
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// This function returns a BLAKE2b-256 hash.Hash in the context, to hash streams of data
+func NewHashWithContext(context string) hash.Hash {
+	// blake2b.New256 fails only with keys longer than 64 bytes
+	blake, _ := blake2b.New256(nil)
+	h := &contextHash{Hash: blake, context: context}
+	h.Reset()
+	return h
+}
+
+// a hash which writes the context prefix whenever it's reset
+type contextHash struct {
+	hash.Hash
+	context string
+}
+
+func (h *contextHash) Reset() {
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(len(h.context)))
+	h.Hash.Reset()
+	h.Hash.Write(length[:])
+	h.Hash.Write([]byte(h.context))
+}
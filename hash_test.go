@@ -0,0 +1,49 @@
+package cryptoengine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+
+	// BLAKE2b-256 of the empty string
+	expected := "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"
+	if sum := Hash(nil); hex.EncodeToString(sum[:]) != expected {
+		t.Errorf("The expected hash is: %s, instead we've got: %x\n", expected, sum)
+	}
+
+	data := []byte("The quick brown fox jumps over the lazy dog")
+
+	// the contexts separate the domains
+	file := HashWithContext("file", data)
+	identifier := HashWithContext("identifier", data)
+	if file == identifier || file == Hash(data) {
+		t.Fatal("The hashes of different contexts are equal")
+	}
+
+	// the context is length prefixed: moving bytes between the context and the data changes the hash
+	if HashWithContext("ab", []byte("c")) == HashWithContext("a", []byte("bc")) {
+		t.Fatal("The context is not separated from the data")
+	}
+
+	// streaming
+	h := NewHashWithContext("file")
+	h.Write(data[:10])
+	h.Write(data[10:])
+	if hex.EncodeToString(h.Sum(nil)) != hex.EncodeToString(file[:]) {
+		t.Fatal("The streamed hash does not match")
+	}
+	h.Reset()
+	h.Write(data)
+	if hex.EncodeToString(h.Sum(nil)) != hex.EncodeToString(file[:]) {
+		t.Fatal("The hash has not been reset to the context")
+	}
+
+	// SHA-256
+	prefixed := append([]byte{4, 0, 0, 0, 0, 0, 0, 0}, "file"...)
+	if SHA256WithContext("file", data) != sha256.Sum256(append(prefixed, data...)) {
+		t.Fatal("The SHA-256 hash in the context is not valid")
+	}
+}
@@ -0,0 +1,466 @@
+package cryptoengine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"hash/crc32"
+	"io"
+	"sort"
+	"time"
+)
+
+// Messages with an authenticated header: the header carries the metadata of the message, in clear,
+// and it's the associated data of the XChaCha20-Poly1305 encryption, so it cannot be modified without the message failing to decrypt.
+// The header is a list of fields, each one tag (1 byte)|length (2 bytes little endian)|value, in ascending tag order and at most once.
+// New fields can be added without forking the format: the receivers ignore the unknown fields, unless their tag is critical (0x80 and above).
+//
+//	|magic|         => 4 bytes ("CEX1")
+//	|header length| => 4 bytes (uint32 little endian)
+//	|header|        => N bytes (the fields)
+//	|nonce|         => 24 bytes
+//	|ciphertext|    => M bytes (the serialized message and the 16 bytes tag)
+//	|checksum|      => 4 bytes (optional CRC32C little endian of all the above)
+//
+// The checksum is announced by a critical field of the header, so the older receivers reject the message instead of failing to decrypt it.
+// It's not a security measure, the attacker can recompute it: it tells the corruption in transit, a truncated frame or flipped bits,
+// apart from the tampering, so the transport can ask for the message again without a failed AEAD verification.
+// The corruption of the header itself is still reported as a HeaderError or a MessageDecryptionError.
+//
+// The validity period of the message, NotBefore and NotAfter, is enforced by the receiver after the decryption,
+// so tokens and commands expire on their own. Their fields are critical: the older receivers reject the message instead of ignoring its expiry.
+
+const (
+	headerMagic          = "CEX1"
+	headerPrefixSize     = len(headerMagic) + 4
+	maxHeaderSize        = 16 * 1024
+	headerCriticalTag    = 0x80
+	headerSubKeyLabel    = "header"
+	headerPublicKeyLabel = "cryptoengine header public key"
+
+	// the fields of the header
+	headerTagKeyID     = 1
+	headerTagSuite     = 2
+	headerTagAAD       = 3
+	headerTagTimestamp = 4
+	headerTagFlags     = 5
+	headerTagSequence  = 6
+	headerTagRecipient = 7
+	headerTagChecksum  = headerCriticalTag
+	headerTagNotBefore = headerCriticalTag + 1
+	headerTagNotAfter  = headerCriticalTag + 2
+	checksumSize       = 4
+
+	// the suites: how the message key is obtained, the cipher is always XChaCha20-Poly1305
+	SuiteSecretKey     = 1 // subkey of the engine secret key
+	SuitePublicKey     = 2 // key derived from the X25519 shared secret of the two engines
+	SuiteSigned        = 3 // not encrypted, signed with the Ed25519 signing key of the sender: see NewSignedMessage
+	SuiteNonRepudiable = 4 // a signed message encrypted like SuitePublicKey: see NewNonRepudiableMessage
+
+	// the checksums of the message
+	ChecksumCRC32C = 1
+)
+
+var (
+	HeaderError         = errors.New("The message header is not valid")
+	HeaderCriticalError = errors.New("The message header contains an unsupported critical field")
+	HeaderSuiteError    = errors.New("The message suite does not match the decryption method")
+	ChecksumError       = errors.New("The message checksum does not match: the message has been corrupted in transit")
+	MessageExpiredError = errors.New("The message has expired")
+	MessageEarlyError   = errors.New("The message is not valid yet")
+
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// The MessageHeader struct holds the authenticated, but not encrypted, metadata of a message.
+// The zero values are not serialized.
+type MessageHeader struct {
+	KeyID      []byte           // identifier of the key the message is encrypted with, for instance to select it among rotated keys
+	Suite      uint8            // set by the engine when encrypting
+	AAD        []byte           // application data which must be bound to the message
+	Timestamp  time.Time        // serialized with a second precision
+	Flags      uint32           // application defined flags
+	Sequence   uint64           // application defined sequence number of the message, zero for none
+	Recipient  []byte           // the public key of the engine a signed message is intended for, see NewNonRepudiableMessage
+	Checksum   uint8            // ChecksumCRC32C to append the checksum of the message, zero for none
+	NotBefore  time.Time        // the message is rejected before this time, serialized with a second precision
+	NotAfter   time.Time        // the message is rejected after this time, serialized with a second precision
+	Extensions map[uint8][]byte // other fields, with the tags unknown to this version. The tags of the fields above are ignored
+}
+
+// This method encrypts the message with a subkey of the secret key and the authenticated header
+func (engine *CryptoEngine) NewEncryptedMessageWithHeader(msg Message, header MessageHeader) ([]byte, error) {
+	key, err := engine.deriveSubKey(headerSubKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := engine.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	header.Suite = SuiteSecretKey
+	return engine.sealWithHeader(key, nonce, msg, header)
+}
+
+// This method encrypts the message for the peer and the authenticated header
+func (engine *CryptoEngine) NewEncryptedMessageWithHeaderAndPubKey(msg Message, header MessageHeader, verificationEngine VerificationEngine) ([]byte, error) {
+	peerPublicKey := verificationEngine.PublicKey()
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return nil, KeyNotValidError
+	}
+
+	key, err := engine.headerPublicKey(peerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := engine.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	header.Suite = SuitePublicKey
+	return engine.sealWithHeader(key, nonce, msg, header)
+}
+
+// This method decrypts a message returned by NewEncryptedMessageWithHeader and returns its header
+func (engine *CryptoEngine) DecryptWithHeader(data []byte) (*Message, MessageHeader, error) {
+	key, err := engine.deriveSubKey(headerSubKeyLabel)
+	if err != nil {
+		return nil, MessageHeader{}, err
+	}
+	return engine.openWithHeader(key, SuiteSecretKey, "secret", data)
+}
+
+// This method decrypts a message returned by NewEncryptedMessageWithHeaderAndPubKey and returns its header
+func (engine *CryptoEngine) DecryptWithHeaderAndPublicKey(data []byte, verificationEngine VerificationEngine) (*Message, MessageHeader, error) {
+	peerPublicKey := verificationEngine.PublicKey()
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return nil, MessageHeader{}, KeyNotValidError
+	}
+
+	key, err := engine.headerPublicKey(peerPublicKey)
+	if err != nil {
+		return nil, MessageHeader{}, err
+	}
+	return engine.openWithHeader(key, SuitePublicKey, peerReplayIdentifier(peerPublicKey), data)
+}
+
+func (engine *CryptoEngine) sealWithHeader(key [keySize]byte, nonce [nonceSize]byte, msg Message, header MessageHeader) ([]byte, error) {
+	headerBytes, err := header.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := getClearTextBuffer(msg)
+	defer putClearTextBuffer(buffer)
+	msgBytes := *buffer
+
+	size := uint64(headerPrefixSize+len(headerBytes)+chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead) + uint64(len(msgBytes))
+	if header.Checksum != 0 {
+		size += checksumSize
+	}
+	if size > engine.maxMessageSize() {
+		return nil, MessageTooLargeError
+	}
+
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, size)
+	data = append(data, headerMagic...)
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(headerBytes)))
+	data = append(data, length[:]...)
+	data = append(data, headerBytes...)
+	associatedData := data
+	data = append(data, nonce[:]...)
+	data = aead.Seal(data, nonce[:], msgBytes, associatedData)
+
+	return header.appendChecksum(data), nil
+}
+
+func (engine *CryptoEngine) openWithHeader(key [keySize]byte, suite uint8, peer string, data []byte) (*Message, MessageHeader, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, MessageHeader{}, err
+	}
+	if uint64(len(data)) > engine.maxMessageSize() {
+		return nil, MessageHeader{}, MessageTooLargeError
+	}
+
+	header, headerSize, err := parseHeader(data)
+	if err != nil {
+		return nil, MessageHeader{}, err
+	}
+
+	// the corruption is detected before the decryption
+	data, err = header.removeChecksum(data, headerSize)
+	if err != nil {
+		return nil, MessageHeader{}, err
+	}
+
+	if header.Suite != suite {
+		return nil, MessageHeader{}, HeaderSuiteError
+	}
+	if len(data) < headerSize+chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead {
+		return nil, MessageHeader{}, MessageParsingError
+	}
+
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, MessageHeader{}, err
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], data[headerSize:])
+	msgBytes, err := aead.Open(nil, nonce[:], data[headerSize+nonceSize:], data[:headerSize])
+	if err != nil {
+		return nil, MessageHeader{}, MessageDecryptionError
+	}
+
+	// the validity is checked once the header is authenticated, the expired messages are not remembered by the replay cache
+	if err := header.checkValidity(time.Now()); err != nil {
+		return nil, MessageHeader{}, err
+	}
+
+	if err := engine.checkReplay(context.Background(), peer, nonce); err != nil {
+		return nil, MessageHeader{}, err
+	}
+
+	msg, err := messageFromBytes(msgBytes, engine.parseOptions())
+	if err != nil {
+		return nil, MessageHeader{}, err
+	}
+	return msg, header, nil
+}
+
+// the key of the public key suite, derived from the shared key of the two engines
+func (engine *CryptoEngine) headerPublicKey(peerPublicKey [keySize]byte) ([keySize]byte, error) {
+	var key [keySize]byte
+	sharedKey := engine.preSharedKey(peerPublicKey)
+	kdf := hkdf.New(sha256.New, sharedKey[:], nil, []byte(headerPublicKeyLabel))
+	if _, err := io.ReadFull(kdf, key[:]); err != nil {
+		return key, err
+	}
+	return key, nil
+}
+
+// serializes the fields of the header, in ascending tag order
+func (header MessageHeader) marshal() ([]byte, error) {
+	fields := make(map[uint8][]byte)
+	for tag, value := range header.Extensions {
+		fields[tag] = value
+	}
+	delete(fields, headerTagKeyID)
+	delete(fields, headerTagSuite)
+	delete(fields, headerTagAAD)
+	delete(fields, headerTagTimestamp)
+	delete(fields, headerTagFlags)
+	delete(fields, headerTagSequence)
+	delete(fields, headerTagRecipient)
+	delete(fields, headerTagChecksum)
+	delete(fields, headerTagNotBefore)
+	delete(fields, headerTagNotAfter)
+
+	if len(header.KeyID) != 0 {
+		fields[headerTagKeyID] = header.KeyID
+	}
+	if header.Suite != 0 {
+		fields[headerTagSuite] = []byte{header.Suite}
+	}
+	if len(header.AAD) != 0 {
+		fields[headerTagAAD] = header.AAD
+	}
+	if !header.Timestamp.IsZero() {
+		fields[headerTagTimestamp] = marshalHeaderTime(header.Timestamp)
+	}
+	if header.Flags != 0 {
+		flags := make([]byte, 4)
+		binary.LittleEndian.PutUint32(flags, header.Flags)
+		fields[headerTagFlags] = flags
+	}
+	if header.Sequence != 0 {
+		sequence := make([]byte, 8)
+		binary.LittleEndian.PutUint64(sequence, header.Sequence)
+		fields[headerTagSequence] = sequence
+	}
+	if len(header.Recipient) != 0 {
+		if len(header.Recipient) != keySize {
+			return nil, HeaderError
+		}
+		fields[headerTagRecipient] = header.Recipient
+	}
+	if header.Checksum != 0 {
+		if header.Checksum != ChecksumCRC32C {
+			return nil, HeaderError
+		}
+		fields[headerTagChecksum] = []byte{header.Checksum}
+	}
+	if !header.NotBefore.IsZero() {
+		fields[headerTagNotBefore] = marshalHeaderTime(header.NotBefore)
+	}
+	if !header.NotAfter.IsZero() {
+		if !header.NotBefore.IsZero() && header.NotAfter.Before(header.NotBefore) {
+			return nil, HeaderError
+		}
+		fields[headerTagNotAfter] = marshalHeaderTime(header.NotAfter)
+	}
+
+	tags := make([]int, 0, len(fields))
+	for tag := range fields {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+
+	var data []byte
+	for _, tag := range tags {
+		value := fields[uint8(tag)]
+		if len(value) > 0xffff {
+			return nil, HeaderError
+		}
+		var field [3]byte
+		field[0] = uint8(tag)
+		binary.LittleEndian.PutUint16(field[1:], uint16(len(value)))
+		data = append(data, field[:]...)
+		data = append(data, value...)
+	}
+	if len(data) > maxHeaderSize {
+		return nil, HeaderError
+	}
+	return data, nil
+}
+
+// parses the header of the message and returns it with the size of the magic, the length and the fields
+func parseHeader(data []byte) (MessageHeader, int, error) {
+	header := MessageHeader{}
+	if len(data) < headerPrefixSize || string(data[:len(headerMagic)]) != headerMagic {
+		return header, 0, HeaderError
+	}
+
+	length := binary.LittleEndian.Uint32(data[len(headerMagic):headerPrefixSize])
+	if length > maxHeaderSize || uint64(len(data)-headerPrefixSize) < uint64(length) {
+		return header, 0, HeaderError
+	}
+	fields := data[headerPrefixSize : headerPrefixSize+int(length)]
+
+	previous := -1
+	for len(fields) > 0 {
+		if len(fields) < 3 {
+			return header, 0, HeaderError
+		}
+		tag := fields[0]
+		size := int(binary.LittleEndian.Uint16(fields[1:3]))
+		if int(tag) <= previous || len(fields) < 3+size {
+			return header, 0, HeaderError
+		}
+		previous = int(tag)
+		value := fields[3 : 3+size]
+		fields = fields[3+size:]
+
+		switch tag {
+		case headerTagKeyID:
+			header.KeyID = value
+		case headerTagSuite:
+			if size != 1 {
+				return header, 0, HeaderError
+			}
+			header.Suite = value[0]
+		case headerTagAAD:
+			header.AAD = value
+		case headerTagTimestamp:
+			if size != 8 {
+				return header, 0, HeaderError
+			}
+			header.Timestamp = time.Unix(int64(binary.LittleEndian.Uint64(value)), 0)
+		case headerTagFlags:
+			if size != 4 {
+				return header, 0, HeaderError
+			}
+			header.Flags = binary.LittleEndian.Uint32(value)
+		case headerTagSequence:
+			if size != 8 {
+				return header, 0, HeaderError
+			}
+			header.Sequence = binary.LittleEndian.Uint64(value)
+		case headerTagRecipient:
+			if size != keySize {
+				return header, 0, HeaderError
+			}
+			header.Recipient = value
+		case headerTagChecksum:
+			if size != 1 {
+				return header, 0, HeaderError
+			}
+			if value[0] != ChecksumCRC32C {
+				return header, 0, HeaderCriticalError
+			}
+			header.Checksum = value[0]
+		case headerTagNotBefore:
+			if size != 8 {
+				return header, 0, HeaderError
+			}
+			header.NotBefore = time.Unix(int64(binary.LittleEndian.Uint64(value)), 0)
+		case headerTagNotAfter:
+			if size != 8 {
+				return header, 0, HeaderError
+			}
+			header.NotAfter = time.Unix(int64(binary.LittleEndian.Uint64(value)), 0)
+		default:
+			if tag >= headerCriticalTag {
+				return header, 0, HeaderCriticalError
+			}
+			if header.Extensions == nil {
+				header.Extensions = make(map[uint8][]byte)
+			}
+			header.Extensions[tag] = value
+		}
+	}
+	return header, headerPrefixSize + int(length), nil
+}
+
+// appends the checksum announced by the header to the message
+func (header MessageHeader) appendChecksum(data []byte) []byte {
+	if header.Checksum == 0 {
+		return data
+	}
+	var checksum [checksumSize]byte
+	binary.LittleEndian.PutUint32(checksum[:], crc32.Checksum(data, castagnoliTable))
+	return append(data, checksum[:]...)
+}
+
+// verifies the checksum announced by the header and returns the message without it
+func (header MessageHeader) removeChecksum(data []byte, headerSize int) ([]byte, error) {
+	if header.Checksum == 0 {
+		return data, nil
+	}
+	if len(data) < headerSize+checksumSize {
+		return nil, ChecksumError
+	}
+	checksum := binary.LittleEndian.Uint32(data[len(data)-checksumSize:])
+	data = data[:len(data)-checksumSize]
+	if crc32.Checksum(data, castagnoliTable) != checksum {
+		return nil, ChecksumError
+	}
+	return data, nil
+}
+
+// returns an error when the message is used outside of its validity period
+func (header MessageHeader) checkValidity(now time.Time) error {
+	if !header.NotBefore.IsZero() && now.Before(header.NotBefore) {
+		return MessageEarlyError
+	}
+	if !header.NotAfter.IsZero() && now.After(header.NotAfter) {
+		return MessageExpiredError
+	}
+	return nil
+}
+
+// serializes the time as the unix time in seconds, 8 bytes little endian
+func marshalHeaderTime(t time.Time) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(t.Unix()))
+	return data
+}
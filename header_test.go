@@ -0,0 +1,235 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+func TestMessageWithHeader(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := MessageHeader{
+		KeyID:      []byte("2024-01"),
+		AAD:        []byte("user 42"),
+		Timestamp:  time.Unix(1700000000, 0),
+		Flags:      3,
+		Extensions: map[uint8][]byte{0x10: []byte("future field")},
+	}
+	data, err := engine.NewEncryptedMessageWithHeader(msg, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, decryptedHeader, err := engine.DecryptWithHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text || decrypted.Type != 1 {
+		t.Fatal("The decrypted message does not match the original one")
+	}
+	if !bytes.Equal(decryptedHeader.KeyID, header.KeyID) || !bytes.Equal(decryptedHeader.AAD, header.AAD) || !decryptedHeader.Timestamp.Equal(header.Timestamp) ||
+		decryptedHeader.Flags != 3 || decryptedHeader.Suite != SuiteSecretKey || !bytes.Equal(decryptedHeader.Extensions[0x10], []byte("future field")) {
+		t.Fatal("The decrypted header does not match the original one")
+	}
+
+	// the header is authenticated: flip a byte of the AAD, which is in clear
+	index := bytes.Index(data, []byte("user 42"))
+	tampered := append([]byte{}, data...)
+	tampered[index] ^= 1
+	if _, _, err := engine.DecryptWithHeader(tampered); err != MessageDecryptionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageDecryptionError, err)
+	}
+
+	// the suite must match the decryption method
+	verificationEngine, err := NewVerificationEngineWithKey(engine.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := engine.DecryptWithHeaderAndPublicKey(data, verificationEngine); err != HeaderSuiteError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", HeaderSuiteError, err)
+	}
+
+	// unknown critical fields are rejected
+	critical, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{Extensions: map[uint8][]byte{0x90: []byte("must understand")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := engine.DecryptWithHeader(critical); err != HeaderCriticalError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", HeaderCriticalError, err)
+	}
+
+	// the legacy format is not accepted
+	legacy, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyBytes, err := legacy.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := engine.DecryptWithHeader(legacyBytes); err != HeaderError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", HeaderError, err)
+	}
+}
+
+func TestMessageWithHeaderAndPubKey(t *testing.T) {
+
+	alice, err := InitCryptoEngine("Sec51Alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := InitCryptoEngine("Sec51Bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceVerification, err := NewVerificationEngineWithKey(alice.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobVerification, err := NewVerificationEngineWithKey(bob.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := alice.NewEncryptedMessageWithHeaderAndPubKey(msg, MessageHeader{KeyID: []byte("alice")}, bobVerification)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, header, err := bob.DecryptWithHeaderAndPublicKey(data, aliceVerification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text || string(header.KeyID) != "alice" || header.Suite != SuitePublicKey {
+		t.Fatal("The decrypted message does not match the original one")
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+
+	for _, invalid := range [][]byte{
+		nil,
+		[]byte("CEX2\x00\x00\x00\x00"),
+		[]byte("CEX1\x10\x00\x00\x00"), // the header exceeds the data
+		[]byte("CEX1\x08\x00\x00\x00\x02\x01\x00\x01\x01\x01\x00a"), // fields out of order
+		[]byte("CEX1\x08\x00\x00\x00\x01\x01\x00a\x01\x01\x00a"),    // repeated field
+		[]byte("CEX1\x05\x00\x00\x00\x02\x02\x00\x01\x01"),          // suite size
+		[]byte("CEX1\x06\x00\x00\x00\x01\x01\x00a\x05\x00"),         // truncated field
+	} {
+		if _, _, err := parseHeader(invalid); err != HeaderError {
+			t.Errorf("The expected error is: %v, instead we've got: %v\n", HeaderError, err)
+		}
+	}
+}
+
+func TestMessageWithChecksum(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{Checksum: ChecksumCRC32C})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, header, err := engine.DecryptWithHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text || header.Checksum != ChecksumCRC32C {
+		t.Fatal("The message with the checksum has not been decrypted")
+	}
+
+	// corruption in transit: a truncated frame or a flipped bit
+	if _, _, err := engine.DecryptWithHeader(data[:len(data)-10]); err != ChecksumError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ChecksumError, err)
+	}
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-20] ^= 1
+	if _, _, err := engine.DecryptWithHeader(corrupted); err != ChecksumError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ChecksumError, err)
+	}
+
+	// tampering: the checksum has been recomputed, the decryption fails
+	tampered := append([]byte{}, corrupted[:len(corrupted)-checksumSize]...)
+	var checksum [checksumSize]byte
+	binary.LittleEndian.PutUint32(checksum[:], crc32.Checksum(tampered, castagnoliTable))
+	tampered = append(tampered, checksum[:]...)
+	if _, _, err := engine.DecryptWithHeader(tampered); err != MessageDecryptionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageDecryptionError, err)
+	}
+
+	if _, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{Checksum: 2}); err != HeaderError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", HeaderError, err)
+	}
+
+}
+
+func TestMessageValidity(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Validity", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := NewMessage("reboot the server", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	valid, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{NotBefore: now.Add(-time.Minute), NotAfter: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, header, err := engine.DecryptWithHeader(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.NotBefore.Unix() != now.Add(-time.Minute).Unix() || header.NotAfter.Unix() != now.Add(time.Hour).Unix() {
+		t.Fatal("The validity period of the header does not match the original one")
+	}
+
+	expired, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{NotAfter: now.Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := engine.DecryptWithHeader(expired); err != MessageExpiredError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageExpiredError, err)
+	}
+
+	early, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{NotBefore: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := engine.DecryptWithHeader(early); err != MessageEarlyError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageEarlyError, err)
+	}
+
+	if _, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{NotBefore: now, NotAfter: now.Add(-time.Hour)}); err != HeaderError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", HeaderError, err)
+	}
+
+}
@@ -0,0 +1,121 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"io"
+	"time"
+)
+
+// Entropy health check: before generating keys, and when HealthCheck is called, a few blocks are read from crypto/rand
+// and the check fails if the reads block for too long or if the blocks repeat or are constant,
+// which is what a broken or a not yet seeded random generator, for instance in a freshly booted VM or container, looks like.
+// It cannot prove the randomness is good, it only catches the generators which are obviously broken.
+
+const (
+	entropySamples    = 4
+	entropySampleSize = 32
+	entropyTimeout    = 5 * time.Second // how long the random generator can block
+)
+
+var (
+	EntropyError        = errors.New("The random generator returned repeated or constant data")
+	EntropyTimeoutError = errors.New("The random generator blocked for too long")
+	SelfTestError       = errors.New("The cryptographic self test failed")
+)
+
+// This method verifies the random generator and the engine keys can seal, open, sign and verify.
+// It's meant for the readiness probes of the orchestration, before the service accepts traffic.
+// It does not use the nonce counters, so it can be called periodically.
+func (engine *CryptoEngine) HealthCheck() error {
+	if err := checkEntropy(); err != nil {
+		return err
+	}
+
+	probe := make([]byte, entropySampleSize)
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(probe); err != nil {
+		return err
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+
+	// symmetric encryption with the secret key
+	sealed := secretbox.Seal(nil, probe, &nonce, &engine.secretKey)
+	if opened, ok := secretbox.Open(nil, sealed, &nonce, &engine.secretKey); !ok || !bytes.Equal(opened, probe) {
+		return SelfTestError
+	}
+
+	// the public key matches the private key
+	public, err := curve25519.X25519(engine.privateKey[:], curve25519.Basepoint)
+	if err != nil || !bytes.Equal(public, engine.publicKey[:]) {
+		return SelfTestError
+	}
+
+	// public key encryption with the key pair, the engine encrypts for itself
+	var sharedKey [keySize]byte
+	box.Precompute(&sharedKey, &engine.publicKey, &engine.privateKey)
+	sealed = box.SealAfterPrecomputation(nil, probe, &nonce, &sharedKey)
+	if opened, ok := box.Open(nil, sealed, &nonce, &engine.publicKey, &engine.privateKey); !ok || !bytes.Equal(opened, probe) {
+		return SelfTestError
+	}
+
+	// signature with the signing key
+	if engine.signingKey != nil {
+		signature := ed25519.Sign(engine.signingKey, probe)
+		if !ed25519.Verify(engine.signingKey.Public().(ed25519.PublicKey), probe, signature) {
+			return SelfTestError
+		}
+	}
+	return nil
+}
+
+// verifies crypto/rand before generating keys
+func checkEntropy() error {
+	return checkEntropySource(rand.Reader, entropyTimeout)
+}
+
+// reads the samples from the source, they must arrive within the timeout, be different from each other and not constant.
+// When the source blocks, the goroutine reading it stays blocked until the source returns.
+func checkEntropySource(source io.Reader, timeout time.Duration) error {
+	type result struct {
+		samples []byte
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		samples := make([]byte, entropySamples*entropySampleSize)
+		_, err := io.ReadFull(source, samples)
+		done <- result{samples, err}
+	}()
+
+	var samples []byte
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return r.err
+		}
+		samples = r.samples
+	case <-time.After(timeout):
+		return EntropyTimeoutError
+	}
+
+	for i := 0; i < entropySamples; i++ {
+		sample := samples[i*entropySampleSize : (i+1)*entropySampleSize]
+		if bytes.Count(sample, sample[:1]) == entropySampleSize {
+			return EntropyError
+		}
+		for j := 0; j < i; j++ {
+			if bytes.Equal(sample, samples[j*entropySampleSize:(j+1)*entropySampleSize]) {
+				return EntropyError
+			}
+		}
+	}
+	return nil
+}
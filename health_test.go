@@ -0,0 +1,69 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+)
+
+// a source which never returns
+type blockingReader struct {
+	unblock chan struct{}
+}
+
+func (r blockingReader) Read(p []byte) (int, error) {
+	<-r.unblock
+	return 0, io.EOF
+}
+
+func TestCheckEntropySource(t *testing.T) {
+
+	if err := checkEntropySource(rand.Reader, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// constant data
+	if err := checkEntropySource(bytes.NewReader(make([]byte, 1024)), time.Second); err != EntropyError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", EntropyError, err)
+	}
+
+	// repeated blocks
+	block := make([]byte, entropySampleSize)
+	rand.Read(block)
+	if err := checkEntropySource(bytes.NewReader(bytes.Repeat(block, entropySamples)), time.Second); err != EntropyError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", EntropyError, err)
+	}
+
+	// the source blocks
+	reader := blockingReader{unblock: make(chan struct{})}
+	defer close(reader.unblock)
+	if err := checkEntropySource(reader, 10*time.Millisecond); err != EntropyTimeoutError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", EntropyTimeoutError, err)
+	}
+
+	// the source fails
+	if err := checkEntropySource(bytes.NewReader(nil), time.Second); err != io.EOF {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", io.EOF, err)
+	}
+
+}
+
+func TestHealthCheck(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Health", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.HealthCheck(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the self test detects keys which do not match
+	engine.publicKey[0] ^= 1
+	if err := engine.HealthCheck(); err != SelfTestError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", SelfTestError, err)
+	}
+
+}
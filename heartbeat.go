@@ -0,0 +1,177 @@
+package cryptoengine
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Heartbeats over a session established with a Handshake: each peer emits small authenticated pings at a regular interval
+// and watches the pings of the other one. The peer is dead when no valid ping arrives within the timeout, and it has been
+// replaced when a ping fails the authentication, for instance because the peer restarted with a new session or another
+// host took over its address.
+// Format:
+// |type|     => 1 byte
+// |sequence| => 8 bytes (little endian counter of the pings of the sender)
+// |mac|      => 16 bytes (HMAC-SHA-256 of all the above with the key of the sender, truncated)
+
+const (
+	heartbeatPing    = 1
+	heartbeatMACSize = 16
+	heartbeatSize    = 1 + 8 + heartbeatMACSize
+
+	heartbeatInitiatorLabel = "cryptoengine heartbeat initiator"
+	heartbeatResponderLabel = "cryptoengine heartbeat responder"
+)
+
+// heartbeat statuses
+const (
+	HeartbeatAlive    = iota // a valid ping has been received within the timeout
+	HeartbeatDead            // no valid ping has been received within the timeout
+	HeartbeatReplaced        // a ping failed the authentication
+)
+
+var (
+	HeartbeatError       = errors.New("The heartbeat message is not valid")
+	HeartbeatReplayError = errors.New("The heartbeat message has already been received")
+	PeerDeadError        = errors.New("The peer did not send a heartbeat within the timeout")
+	PeerReplacedError    = errors.New("The peer heartbeat failed the authentication: the peer has been replaced")
+)
+
+// The Heartbeat emits the pings of this peer and tracks the pings of the other one. It's safe for concurrent use.
+type Heartbeat struct {
+	sendKey    []byte
+	receiveKey []byte
+	interval   time.Duration
+	timeout    time.Duration
+	mutex      sync.Mutex
+	sent       uint64    // the sequence number of the last ping emitted
+	received   uint64    // the sequence number of the last ping received
+	lastSeen   time.Time // when the last valid ping has been received
+	replaced   bool
+}
+
+// This method returns a heartbeat for the completed handshake: a ping is emitted every interval
+// and the peer is dead when it does not send one within the timeout
+func (h *Handshake) NewHeartbeat(interval, timeout time.Duration) (*Heartbeat, error) {
+	sessionKey, err := h.SessionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	initiatorKey, err := sessionSubKey(sessionKey, heartbeatInitiatorLabel)
+	if err != nil {
+		return nil, err
+	}
+	responderKey, err := sessionSubKey(sessionKey, heartbeatResponderLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	heartbeat := &Heartbeat{
+		sendKey:    initiatorKey,
+		receiveKey: responderKey,
+		interval:   interval,
+		timeout:    timeout,
+		lastSeen:   time.Now(),
+	}
+	if !h.initiator {
+		heartbeat.sendKey, heartbeat.receiveKey = responderKey, initiatorKey
+	}
+	return heartbeat, nil
+}
+
+// This method returns the next ping to send to the peer
+func (heartbeat *Heartbeat) Ping() []byte {
+	heartbeat.mutex.Lock()
+	heartbeat.sent++
+	sequence := heartbeat.sent
+	heartbeat.mutex.Unlock()
+
+	ping := make([]byte, 1+8, heartbeatSize)
+	ping[0] = heartbeatPing
+	binary.LittleEndian.PutUint64(ping[1:], sequence)
+	return append(ping, heartbeatMAC(heartbeat.sendKey, ping)...)
+}
+
+// This method verifies a ping of the peer. A ping which fails the authentication marks the peer as replaced.
+func (heartbeat *Heartbeat) Receive(ping []byte) error {
+	return heartbeat.receive(ping, time.Now())
+}
+
+// This method returns HeartbeatAlive, HeartbeatDead or HeartbeatReplaced
+func (heartbeat *Heartbeat) Status() int {
+	return heartbeat.status(time.Now())
+}
+
+// This method emits a ping every interval with send, until the context is done, the peer is dead or replaced, or send fails.
+// The pings of the peer must be passed to Receive meanwhile.
+func (heartbeat *Heartbeat) Run(ctx context.Context, send func(ping []byte) error) error {
+	ticker := time.NewTicker(heartbeat.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := send(heartbeat.Ping()); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			switch heartbeat.status(now) {
+			case HeartbeatDead:
+				return PeerDeadError
+			case HeartbeatReplaced:
+				return PeerReplacedError
+			}
+		}
+	}
+}
+
+func (heartbeat *Heartbeat) receive(ping []byte, now time.Time) error {
+	if len(ping) != heartbeatSize || ping[0] != heartbeatPing {
+		return HeartbeatError
+	}
+
+	heartbeat.mutex.Lock()
+	defer heartbeat.mutex.Unlock()
+
+	mac := heartbeatMAC(heartbeat.receiveKey, ping[:1+8])
+	if subtle.ConstantTimeCompare(mac, ping[1+8:]) != 1 {
+		heartbeat.replaced = true
+		return PeerReplacedError
+	}
+
+	sequence := binary.LittleEndian.Uint64(ping[1:])
+	if sequence <= heartbeat.received {
+		return HeartbeatReplayError
+	}
+	heartbeat.received = sequence
+	heartbeat.lastSeen = now
+	return nil
+}
+
+func (heartbeat *Heartbeat) status(now time.Time) int {
+	heartbeat.mutex.Lock()
+	defer heartbeat.mutex.Unlock()
+
+	if heartbeat.replaced {
+		return HeartbeatReplaced
+	}
+	if now.Sub(heartbeat.lastSeen) > heartbeat.timeout {
+		return HeartbeatDead
+	}
+	return HeartbeatAlive
+}
+
+func heartbeatMAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)[:heartbeatMACSize]
+}
@@ -0,0 +1,131 @@
+package cryptoengine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// completes a handshake between two engines and returns both sides
+func completedHandshakes(t *testing.T) (*Handshake, *Handshake) {
+	alice, err := InitCryptoEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := InitCryptoEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceVerificationEngine, err := NewVerificationEngine("Sec51HandshakeAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobVerificationEngine, err := NewVerificationEngine("Sec51HandshakeBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initiator, hello, err := alice.InitiateHandshake(bobVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, keyShare, err := bob.RespondHandshake(aliceVerificationEngine, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirm, err := initiator.Finish(keyShare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := responder.Confirm(confirm); err != nil {
+		t.Fatal(err)
+	}
+	return initiator, responder
+}
+
+func TestHeartbeat(t *testing.T) {
+
+	initiator, responder := completedHandshakes(t)
+
+	alice, err := initiator.NewHeartbeat(time.Second, 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := responder.NewHeartbeat(time.Second, 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ping := alice.Ping()
+	if err := bob.Receive(ping); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Receive(bob.Ping()); err != nil {
+		t.Fatal(err)
+	}
+	if bob.Status() != HeartbeatAlive || alice.Status() != HeartbeatAlive {
+		t.Fatal("The peers should be alive")
+	}
+
+	// the pings cannot be replayed, nor reflected to their sender
+	if err := bob.Receive(ping); err != HeartbeatReplayError {
+		t.Fatalf("Expected %v, got %v\n", HeartbeatReplayError, err)
+	}
+	if err := alice.Receive(alice.Ping()); err != PeerReplacedError {
+		t.Fatalf("Expected %v, got %v\n", PeerReplacedError, err)
+	}
+	if alice.Status() != HeartbeatReplaced {
+		t.Fatal("The peer should be replaced")
+	}
+
+	// the peer is dead once the timeout elapses without a ping
+	now := time.Now()
+	if err := bob.receive(alice.Ping(), now); err != nil {
+		t.Fatal(err)
+	}
+	if bob.status(now.Add(3*time.Second)) != HeartbeatAlive || bob.status(now.Add(4*time.Second)) != HeartbeatDead {
+		t.Fatal("The peer should be dead after the timeout")
+	}
+
+	if err := bob.Receive(ping[1:]); err != HeartbeatError {
+		t.Fatalf("Expected %v, got %v\n", HeartbeatError, err)
+	}
+
+	// the heartbeat needs a completed handshake
+	alicePending, _, err := initiator.engine.InitiateHandshake(initiator.peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alicePending.NewHeartbeat(time.Second, 3*time.Second); err != HandshakeStateError {
+		t.Fatalf("Expected %v, got %v\n", HandshakeStateError, err)
+	}
+
+}
+
+func TestHeartbeatRun(t *testing.T) {
+
+	initiator, responder := completedHandshakes(t)
+
+	alice, err := initiator.NewHeartbeat(10*time.Millisecond, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := responder.NewHeartbeat(10*time.Millisecond, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bob receives the pings of alice, but alice never hears back
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = alice.Run(ctx, func(ping []byte) error {
+		return bob.Receive(ping)
+	})
+	if err != PeerDeadError {
+		t.Fatalf("Expected %v, got %v\n", PeerDeadError, err)
+	}
+	if bob.Status() != HeartbeatAlive {
+		t.Fatal("Alice should be alive for bob")
+	}
+
+}
@@ -5,6 +5,12 @@ import (
 	"errors"
 	"golang.org/x/crypto/hkdf"
 	"io"
+	"strconv"
+)
+
+const (
+	// HKDF can expand at most 255 * hash size bytes, with SHA256 this is 340 nonces per pass
+	noncesPerPass = 255 * sha256.Size / nonceSize
 )
 
 // IMPORTANT !!!
@@ -31,3 +37,51 @@ func deriveNonce(masterKey [keySize]byte, salt [keySize]byte, context string, co
 	return data24, nil
 
 }
+
+// Derives n nonces for the counter range starting at firstCounter.
+// Each pass of the HKDF expands up to noncesPerPass nonces, the info is the context, the "batch" separator and the first counter of the pass,
+// so it never overlaps with the info used by deriveNonce, which is the context followed by the counter digits only.
+func deriveNonces(masterKey [keySize]byte, salt [keySize]byte, context string, firstCounter uint64, n int) ([][nonceSize]byte, error) {
+	nonces := make([][nonceSize]byte, n)
+	// Underlying hash function to use
+	hash := sha256.New
+
+	for start := 0; start < n; start += noncesPerPass {
+		end := start + noncesPerPass
+		if end > n {
+			end = n
+		}
+
+		// Create the key derivation function for this pass
+		info := context + "batch" + strconv.FormatUint(firstCounter+uint64(start), 10)
+		hkdf := hkdf.New(hash, masterKey[:], salt[:], []byte(info))
+
+		// Generate all the nonces of the pass at once
+		data := make([]byte, (end-start)*nonceSize)
+		if _, err := io.ReadFull(hkdf, data); err != nil {
+			return nil, err
+		}
+
+		for i := start; i < end; i++ {
+			offset := (i - start) * nonceSize
+			copy(nonces[i][:], data[offset:offset+nonceSize])
+		}
+	}
+
+	return nonces, nil
+}
+
+// Derives a 32 bytes subkey from the engine secret key for the given purpose.
+// Each feature of the package uses its own label, so a key is never used with two different algorithms.
+func (engine *CryptoEngine) deriveSubKey(label string) ([keySize]byte, error) {
+	var subKey [keySize]byte
+	if err := engine.allow(operationOwnKeys); err != nil {
+		return subKey, err
+	}
+
+	hkdf := hkdf.New(sha256.New, engine.secretKey[:], nil, []byte("cryptoengine "+label))
+	if _, err := io.ReadFull(hkdf, subKey[:]); err != nil {
+		return subKey, err
+	}
+	return subKey, nil
+}
@@ -0,0 +1,110 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+	"net/url"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// The strict identifiers mode validates the communication identifier instead of silently sanitizing it:
+// the sanitized identifier names the key files, so two identifiers which sanitize to the same name share the same keys.
+// In strict mode the identifier is recorded in the key store the first time, and a different identifier
+// mapping to the same keys afterwards is rejected with IdentifierCollisionError.
+// In both modes the identifiers ending with a suffix reserved for the key files are rejected with IdentifierReservedError:
+// the signing public key of "x" is "x_signing_public.key", which would also be the public key of "x_signing".
+
+const (
+	identifierSuffixFormat = "%s_identifier.key" // the identifier the keys have been generated for, for instance: sec51_identifier.key
+)
+
+var (
+	IdentifierError          = errors.New("The communication identifier is not valid")
+	IdentifierEscapeError    = errors.New("The communication identifier is not correctly URL escaped")
+	IdentifierCollisionError = errors.New("The communication identifier collides with another identifier after the sanitization")
+	IdentifierReservedError  = errors.New("The communication identifier ends with a suffix reserved for the key files")
+
+	// the key file suffixes which end with another key file suffix, like "_signing" + "_public.key"
+	// or "_password" + "_salt.key" or "_signed" + "_prekey_%d.key": an identifier ending with them would name the key files of another one
+	reservedIdentifierSuffixes = []string{"_signing", "_password", "_signed"}
+)
+
+// This function validates the communication identifier like the strict identifiers mode does and returns it sanitized.
+// It does not detect the collisions, since they depend on the key store.
+func ValidateIdentifier(communicationIdentifier string) (string, error) {
+	unescaped, err := url.QueryUnescape(communicationIdentifier)
+	if err != nil {
+		return "", IdentifierEscapeError
+	}
+
+	sanitized, err := keyContext(communicationIdentifier)
+	if err != nil {
+		return "", err
+	}
+	if sanitized == "" || sanitized == "." || sanitized == ".." {
+		return "", IdentifierError
+	}
+
+	// the identifier names files: no path separators nor control characters, also after the normalization
+	for _, r := range unescaped + norm.NFKC.String(unescaped) {
+		if r == '/' || r == '\\' || r == unicode.ReplacementChar || unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return "", IdentifierError
+		}
+	}
+	return sanitized, nil
+}
+
+// sanitizes the identifier which names the key files, the identifiers ending with a reserved suffix are rejected
+func keyContext(communicationIdentifier string) (string, error) {
+	context := sanitizeIdentifier(communicationIdentifier)
+	for _, suffix := range reservedIdentifierSuffixes {
+		if strings.HasSuffix(context, suffix) {
+			return "", IdentifierReservedError
+		}
+	}
+	return context, nil
+}
+
+// This function converts an internationalized hostname to its ASCII form, for instance bücher.example to xn--bcher-kva.example,
+// so that a peer named by its hostname gets the same keys whether the client sends the unicode or the punycode form.
+// Use it on the identifiers which are hostnames, before passing them to InitCryptoEngine or NewVerificationEngine.
+func PunycodeIdentifier(hostname string) (string, error) {
+	ascii, err := idna.Lookup.ToASCII(hostname)
+	if err != nil {
+		return "", IdentifierError
+	}
+	return ascii, nil
+}
+
+// records the identifier in the store the first time and verifies it matches afterwards.
+// The unescaped and normalized identifier is compared, so only the URL escaping and the unicode normalization can differ.
+func checkIdentifierCollision(store KeyStore, communicationIdentifier, context string) error {
+	unescaped, err := url.QueryUnescape(communicationIdentifier)
+	if err != nil {
+		return IdentifierEscapeError
+	}
+	unescaped = norm.NFKC.String(unescaped)
+	name := fmt.Sprintf(identifierSuffixFormat, context)
+
+	recorded, err := store.ReadKey(name)
+	if err == KeyNotFoundError {
+		// another instance sharing the store recorded it first: compare with its identifier
+		if err = store.WriteKey(name, []byte(unescaped)); err != os.ErrExist {
+			return err
+		}
+		recorded, err = store.ReadKey(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(recorded, []byte(unescaped)) {
+		return IdentifierCollisionError
+	}
+	return nil
+}
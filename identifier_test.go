@@ -0,0 +1,179 @@
+package cryptoengine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateIdentifier(t *testing.T) {
+
+	valid := map[string]string{
+		"Sec51":           "sec51",
+		"sec51%20service": "sec51_service",
+		" Sec51 Service ": "sec51_service",
+	}
+	for id, expected := range valid {
+		sanitized, err := ValidateIdentifier(id)
+		if err != nil {
+			t.Errorf("%q: %v\n", id, err)
+		}
+		if sanitized != expected {
+			t.Errorf("%q: the expected identifier is %q, instead we've got %q\n", id, expected, sanitized)
+		}
+	}
+
+	notValid := map[string]error{
+		"sec51%zz":      IdentifierEscapeError,
+		"":              IdentifierError,
+		"   ":           IdentifierError,
+		"..":            IdentifierError,
+		"../sec51":      IdentifierError,
+		"sec51%2Fother": IdentifierError,
+		"sec51\\other":  IdentifierError,
+		"sec51\x00":     IdentifierError,
+		"sec51%FF":      IdentifierError,
+	}
+	for id, expected := range notValid {
+		if _, err := ValidateIdentifier(id); err != expected {
+			t.Errorf("%q: the expected error is: %v, instead we've got: %v\n", id, expected, err)
+		}
+	}
+
+}
+
+func TestStrictIdentifiers(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	config := Config{KeyStore: store, StrictIdentifiers: true}
+
+	if _, err := InitCryptoEngineWithConfig("Sec51 Service", config); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same identifier, or the same one URL escaped, loads the same keys
+	if _, err := InitCryptoEngineWithConfig("Sec51 Service", config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InitCryptoEngineWithConfig("Sec51%20Service", config); err != nil {
+		t.Fatal(err)
+	}
+
+	// a different identifier sanitized to the same keys
+	if _, err := InitCryptoEngineWithConfig("sec51_service", config); err != IdentifierCollisionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", IdentifierCollisionError, err)
+	}
+	if _, err := InitCryptoEngineWithConfig("SEC51 SERVICE", config); err != IdentifierCollisionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", IdentifierCollisionError, err)
+	}
+
+	if _, err := InitCryptoEngineWithConfig("sec51%zz", config); err != IdentifierEscapeError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", IdentifierEscapeError, err)
+	}
+
+	// the default mode keeps sanitizing silently
+	if _, err := InitCryptoEngineWithConfig("sec51_service", Config{KeyStore: store}); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestIdentifierNormalization(t *testing.T) {
+
+	// composed and decomposed accents, full width and ligature characters
+	equivalent := [][]string{
+		{"café", "cafe\u0301", "caf%C3%A9"},
+		{"sec51", "ｓｅｃ５１"},
+		{"office", "oﬃce"},
+	}
+	for _, identifiers := range equivalent {
+		for _, id := range identifiers[1:] {
+			if sanitizeIdentifier(id) != sanitizeIdentifier(identifiers[0]) {
+				t.Errorf("%q and %q are not mapped to the same identifier\n", id, identifiers[0])
+			}
+		}
+	}
+
+	// the normalization cannot introduce a path separator
+	if _, err := ValidateIdentifier("sec51／other"); err != IdentifierError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", IdentifierError, err)
+	}
+
+	// the same peer name sent by different clients loads the same keys, also in strict mode
+	store := NewMemoryKeyStore()
+	composed, err := InitCryptoEngineWithConfig("café", Config{KeyStore: store, StrictIdentifiers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decomposed, err := InitCryptoEngineWithConfig("cafe\u0301", Config{KeyStore: store, StrictIdentifiers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if composed.Fingerprint() != decomposed.Fingerprint() {
+		t.Fatal("The equivalent identifiers loaded different keys")
+	}
+
+}
+
+func TestPunycodeIdentifier(t *testing.T) {
+
+	unicode, err := PunycodeIdentifier("bücher.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ascii, err := PunycodeIdentifier("xn--bcher-kva.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unicode != "xn--bcher-kva.example" || ascii != unicode {
+		t.Errorf("The hostnames are not converted to the same identifier: %q and %q\n", unicode, ascii)
+	}
+
+	if _, err := PunycodeIdentifier("not a hostname"); err != IdentifierError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", IdentifierError, err)
+	}
+
+}
+
+func TestReservedIdentifiers(t *testing.T) {
+
+	// the signing public key of zz would be loaded as the public key of zz_signing
+	store := NewMemoryKeyStore()
+	if _, err := InitCryptoEngineWithConfig("zz", Config{KeyStore: store}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"zz_signing", "ZZ Signing", "zz_password", "zz_signed"} {
+		if _, err := InitCryptoEngineWithConfig(id, Config{KeyStore: store}); err != IdentifierReservedError {
+			t.Errorf("The expected error is: %v, instead we've got: %v\n", IdentifierReservedError, err)
+		}
+		if _, err := NewVerificationEngineFromStore(store, id); err != IdentifierReservedError {
+			t.Errorf("The expected error is: %v, instead we've got: %v\n", IdentifierReservedError, err)
+		}
+		if _, err := ValidateIdentifier(id); err != IdentifierReservedError {
+			t.Errorf("The expected error is: %v, instead we've got: %v\n", IdentifierReservedError, err)
+		}
+	}
+	if _, err := InitCryptoEngineWithConfig("zz_signing_key", Config{KeyStore: store}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every key file suffix which ends with another one is reserved
+	formats := []string{
+		saltSuffixFormat, secretSuffixFormat, publicKeySuffixFormat, privateSuffixFormat, nonceSuffixFormat,
+		signingPublicSuffixFormat, signingPrivateSuffixFormat, manifestSuffixFormat, saltInfoSuffixFormat,
+		identifierSuffixFormat, passwordSaltSuffixFormat, passwordCheckSuffixFormat,
+		signedPrekeySuffixFormat, oneTimePrekeySuffixFormat, keyServerPinSuffixFormat,
+	}
+	for _, format := range formats {
+		for _, other := range formats {
+			suffix, otherSuffix := strings.TrimPrefix(format, "%s"), strings.TrimPrefix(other, "%s")
+			if format == other || !strings.HasSuffix(suffix, otherSuffix) {
+				continue
+			}
+			reserved := strings.TrimSuffix(suffix, otherSuffix)
+			if _, err := keyContext("x" + reserved); err != IdentifierReservedError {
+				t.Errorf("The identifier suffix %q of %q is not reserved\n", reserved, format)
+			}
+		}
+	}
+
+}
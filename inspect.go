@@ -0,0 +1,60 @@
+package cryptoengine
+
+// Header peek: the routers and the brokers which forward the messages they cannot open still need their metadata,
+// for instance the key ID to pick the destination. InspectMessage parses the fields in clear without decrypting the message.
+// IMPORTANT: nothing returned by InspectMessage is authenticated until the message is decrypted or its signature verified,
+// so it must only be used for routing, never for security decisions.
+
+const (
+	FormatLegacy = 0 // |length|nonce|ciphertext|, see EncryptedMessage
+	FormatHeader = 1 // |magic|header length|header|nonce|ciphertext|, see MessageHeader
+)
+
+// The MessageInfo struct holds the fields in clear of a message, they are NOT authenticated
+type MessageInfo struct {
+	Format int             // FormatLegacy or FormatHeader
+	Length uint64          // the size of the message in bytes
+	Nonce  [nonceSize]byte // the nonce of the message, zero for a signed message which has none
+	Header MessageHeader   // the header fields, with the key ID and the suite. Empty for the legacy format
+}
+
+// This function parses the fields in clear of an encrypted or signed message, without decrypting it.
+// The message is parsed in the strict mode, and when the header announces a checksum it's verified.
+func InspectMessage(data []byte) (MessageInfo, error) {
+	info := MessageInfo{Length: uint64(len(data))}
+
+	if !isHeaderMessage(data) {
+		encryptedMessage, err := encryptedMessageFromBytes(data, ParseOptions{})
+		if err != nil {
+			return MessageInfo{}, err
+		}
+		return encryptedMessage.Header(), nil
+	}
+
+	if info.Length > maxMessageSize {
+		return MessageInfo{}, MessageTooLargeError
+	}
+	header, headerSize, err := parseHeader(data)
+	if err != nil {
+		return MessageInfo{}, err
+	}
+	data, err = header.removeChecksum(data, headerSize)
+	if err != nil {
+		return MessageInfo{}, err
+	}
+
+	info.Format = FormatHeader
+	info.Header = header
+	if header.Suite != SuiteSigned {
+		if len(data) < headerSize+nonceSize {
+			return MessageInfo{}, MessageParsingError
+		}
+		copy(info.Nonce[:], data[headerSize:])
+	}
+	return info, nil
+}
+
+// This method returns the fields in clear of the legacy message, they are NOT authenticated
+func (m EncryptedMessage) Header() MessageInfo {
+	return MessageInfo{Format: FormatLegacy, Length: m.length, Nonce: m.nonce}
+}
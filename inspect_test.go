@@ -0,0 +1,79 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestInspectMessage(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Inspect", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := NewMessage("route me", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// legacy format
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := InspectMessage(encryptedBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != FormatLegacy || info.Length != uint64(len(encryptedBytes)) || info.Nonce != encrypted.nonce || encrypted.Header().Nonce != info.Nonce {
+		t.Fatalf("The info of the legacy message is not valid: %+v\n", info)
+	}
+	if _, err := InspectMessage(encryptedBytes[:len(encryptedBytes)-1]); !errors.Is(err, MessageLengthError) {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageLengthError, err)
+	}
+
+	// format with a header
+	data, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{KeyID: []byte("tenant-7"), Checksum: ChecksumCRC32C})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = InspectMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != FormatHeader || info.Header.Suite != SuiteSecretKey || !bytes.Equal(info.Header.KeyID, []byte("tenant-7")) || info.Nonce == [nonceSize]byte{} {
+		t.Fatalf("The info of the message with a header is not valid: %+v\n", info)
+	}
+	_, decryptInfo, err := engine.DecryptWithInfo(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decryptInfo.Nonce != info.Nonce {
+		t.Fatal("The nonce inspected does not match the nonce decrypted")
+	}
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-5] ^= 1
+	if _, err := InspectMessage(corrupted); err != ChecksumError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ChecksumError, err)
+	}
+
+	// signed messages have no nonce
+	signed, err := engine.NewSignedMessage(msg, MessageHeader{KeyID: []byte("broadcast")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = InspectMessage(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Header.Suite != SuiteSigned || info.Nonce != [nonceSize]byte{} {
+		t.Fatalf("The info of the signed message is not valid: %+v\n", info)
+	}
+
+}
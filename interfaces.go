@@ -0,0 +1,21 @@
+package cryptoengine
+
+// Small interfaces of the CryptoEngine methods: the application code can depend on them
+// and its tests can substitute fakes, which do not need the key files of a real engine.
+
+// The Encryptor interface encrypts the messages, with the secret key or for a peer
+type Encryptor interface {
+	NewEncryptedMessage(msg Message) (EncryptedMessage, error)
+	NewEncryptedMessageWithPubKey(msg Message, verificationEngine VerificationEngine) (EncryptedMessage, error)
+}
+
+// The Decryptor interface decrypts the serialized messages, encrypted with the secret key or by a peer
+type Decryptor interface {
+	Decrypt(encryptedBytes []byte) (*Message, error)
+	DecryptWithPublicKey(encryptedBytes []byte, verificationEngine VerificationEngine) (*Message, error)
+}
+
+// The PublicKeyer interface gives access to the public key to share with the peers
+type PublicKeyer interface {
+	PublicKey() []byte
+}
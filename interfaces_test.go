@@ -0,0 +1,75 @@
+package cryptoengine
+
+import (
+	"testing"
+)
+
+// make sure the engine implements the interfaces
+var (
+	_ Encryptor   = &CryptoEngine{}
+	_ Decryptor   = &CryptoEngine{}
+	_ PublicKeyer = &CryptoEngine{}
+)
+
+// a fake decryptor, which does not need any key
+type fakeDecryptor struct {
+	message Message
+}
+
+func (fake fakeDecryptor) Decrypt(encryptedBytes []byte) (*Message, error) {
+	if len(encryptedBytes) == 0 {
+		return nil, MessageDecryptionError
+	}
+	return &fake.message, nil
+}
+
+func (fake fakeDecryptor) DecryptWithPublicKey(encryptedBytes []byte, verificationEngine VerificationEngine) (*Message, error) {
+	return fake.Decrypt(encryptedBytes)
+}
+
+// application code depending on the interface
+func decryptText(decryptor Decryptor, data []byte) (string, error) {
+	msg, err := decryptor.Decrypt(data)
+	if err != nil {
+		return "", err
+	}
+	return msg.Text, nil
+}
+
+func TestInterfaces(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encryptor Encryptor = engine
+	encrypted, err := encryptor.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, decryptor := range []Decryptor{engine, fakeDecryptor{message: msg}} {
+		text, err := decryptText(decryptor, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != msg.Text {
+			t.Errorf("The expected text is: %s, instead we've got: %s\n", msg.Text, text)
+		}
+	}
+
+	if _, err := decryptText(fakeDecryptor{}, nil); err != MessageDecryptionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageDecryptionError, err)
+	}
+
+}
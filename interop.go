@@ -0,0 +1,127 @@
+package cryptoengine
+
+import (
+	"context"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// The libsodium interoperability mode.
+// The raw format is the one produced by crypto_secretbox_easy and crypto_box_easy, with the nonce prepended:
+// |nonce| => 24 bytes
+// |mac|   => 16 bytes
+// |data|  => N bytes
+// There is no length, version or type header, so the clear text is exchanged as is.
+// This is the format expected, for example, by PyNaCl (SecretBox / Box encrypt and decrypt) and libsodium.js.
+
+// This method encrypts the clear text with the symmetric key and returns it in the raw libsodium format
+func (engine *CryptoEngine) SealRaw(clearText []byte) ([]byte, error) {
+
+	if encryptedMessageSize(len(clearText)) > engine.maxMessageSize() {
+		return nil, MessageTooLargeError
+	}
+
+	// derive nonce
+	nonce, err := engine.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	// nonce || secretbox
+	out := make([]byte, nonceSize, nonceSize+secretbox.Overhead+len(clearText))
+	copy(out, nonce[:])
+	return secretbox.Seal(out, clearText, &nonce, &engine.secretKey), nil
+}
+
+// This method decrypts a raw libsodium secretbox, encrypted with the symmetric key
+func (engine *CryptoEngine) OpenRaw(data []byte) ([]byte, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, err
+	}
+
+	nonce, sealed, err := splitRaw(data, engine.maxMessageSize())
+	if err != nil {
+		return nil, err
+	}
+
+	clearText, valid := secretbox.Open(nil, sealed, &nonce, &engine.secretKey)
+	if !valid {
+		return nil, MessageDecryptionError
+	}
+
+	// the nonce is remembered only once the message is authenticated
+	if err := engine.checkReplay(context.Background(), "secret", nonce); err != nil {
+		return nil, err
+	}
+	return clearText, nil
+}
+
+// This method encrypts the clear text for the peer public key and returns it in the raw libsodium format
+func (engine *CryptoEngine) SealRawWithPubKey(clearText []byte, verificationEngine VerificationEngine) ([]byte, error) {
+
+	// get the peer public key
+	peerPublicKey := verificationEngine.PublicKey()
+
+	// check the peerPublicKey is not empty (all zeros)
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return nil, KeyNotValidError
+	}
+
+	if encryptedMessageSize(len(clearText)) > engine.maxMessageSize() {
+		return nil, MessageTooLargeError
+	}
+
+	// derive nonce
+	nonce, err := engine.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	// nonce || box
+	preSharedKey := engine.preSharedKey(peerPublicKey)
+	out := make([]byte, nonceSize, nonceSize+box.Overhead+len(clearText))
+	copy(out, nonce[:])
+	return box.SealAfterPrecomputation(out, clearText, &nonce, &preSharedKey), nil
+}
+
+// This method decrypts a raw libsodium box, sent by the peer
+func (engine *CryptoEngine) OpenRawWithPublicKey(data []byte, verificationEngine VerificationEngine) ([]byte, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, err
+	}
+
+	// get the peer public key
+	peerPublicKey := verificationEngine.PublicKey()
+
+	nonce, sealed, err := splitRaw(data, engine.maxMessageSize())
+	if err != nil {
+		return nil, err
+	}
+
+	preSharedKey := engine.preSharedKey(peerPublicKey)
+	clearText, valid := box.OpenAfterPrecomputation(nil, sealed, &nonce, &preSharedKey)
+	if !valid {
+		return nil, MessageDecryptionError
+	}
+
+	if err := engine.checkReplay(context.Background(), peerReplayIdentifier(peerPublicKey), nonce); err != nil {
+		return nil, err
+	}
+	return clearText, nil
+}
+
+// splits the raw format into the nonce and the sealed data
+func splitRaw(data []byte, maxSize uint64) ([nonceSize]byte, []byte, error) {
+	var nonce [nonceSize]byte
+
+	if len(data) < nonceSize+secretbox.Overhead {
+		return nonce, nil, MessageParsingError
+	}
+
+	if uint64(len(data)) > maxSize {
+		return nonce, nil, MessageTooLargeError
+	}
+
+	copy(nonce[:], data[:nonceSize])
+	return nonce, data[nonceSize:], nil
+}
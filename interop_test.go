@@ -0,0 +1,160 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"testing"
+)
+
+func TestRawSecretBox(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clearText := []byte("The quick brown fox jumps over the lazy dog")
+	sealed, err := engine.SealRaw(clearText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the raw format is nonce || crypto_secretbox_easy
+	if len(sealed) != nonceSize+secretbox.Overhead+len(clearText) {
+		t.Fatal("The raw secretbox has an unexpected size")
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[:nonceSize])
+	opened, valid := secretbox.Open(nil, sealed[nonceSize:], &nonce, &engine.secretKey)
+	if !valid || !bytes.Equal(opened, clearText) {
+		t.Fatal("The raw secretbox is not compatible with crypto_secretbox_easy")
+	}
+
+	opened, err = engine.OpenRaw(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, clearText) {
+		t.Fatal("Raw secretbox encryption/decryption broken")
+	}
+
+	// tampered data
+	sealed[len(sealed)-1] ^= 0xff
+	if _, err := engine.OpenRaw(sealed); err != MessageDecryptionError {
+		t.Errorf("The expected error is: MessageDecryptionError, instead we've got: %v\n", err)
+	}
+
+	// too short
+	if _, err := engine.OpenRaw(sealed[:nonceSize]); err != MessageParsingError {
+		t.Errorf("The expected error is: MessageParsingError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestRawBox(t *testing.T) {
+
+	firstEngine, err := InitCryptoEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstVerificationEngine, err := NewVerificationEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondEngine, err := InitCryptoEngine("Sec51Peer2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondVerificationEngine, err := NewVerificationEngine("Sec51Peer2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clearText := []byte("The quick brown fox jumps over the lazy dog")
+	sealed, err := firstEngine.SealRawWithPubKey(clearText, secondVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the raw format is nonce || crypto_box_easy
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[:nonceSize])
+	opened, valid := box.Open(nil, sealed[nonceSize:], &nonce, &firstEngine.publicKey, &secondEngine.privateKey)
+	if !valid || !bytes.Equal(opened, clearText) {
+		t.Fatal("The raw box is not compatible with crypto_box_easy")
+	}
+
+	opened, err = secondEngine.OpenRawWithPublicKey(sealed, firstVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, clearText) {
+		t.Fatal("Raw box encryption/decryption broken")
+	}
+
+}
+
+func TestRawRoleAndReplay(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	backend, err := InitCryptoEngineWithConfig("Sec51RawBackend", Config{KeyStore: store, ReplayCache: NewMemoryReplayCache()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := InitCryptoEngineWithConfig("Sec51RawPeer", Config{KeyStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edge, err := InitCryptoEngineWithConfig("Sec51RawEdge", Config{KeyStore: NewMemoryKeyStore(), Role: RoleEncryptOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendVerificationEngine, err := NewVerificationEngineWithKey(backend.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerVerificationEngine, err := NewVerificationEngineWithKey(peer.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clearText := []byte("The quick brown fox jumps over the lazy dog")
+	sealed, err := backend.SealRaw(clearText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxed, err := peer.SealRawWithPubKey(clearText, backendVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an encrypt only engine cannot open the raw messages
+	if _, err := edge.OpenRaw(sealed); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+	if _, err := edge.OpenRawWithPublicKey(boxed, peerVerificationEngine); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+
+	// the raw messages are opened only once
+	if _, err := backend.OpenRaw(sealed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.OpenRaw(sealed); err != ReplayError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ReplayError, err)
+	}
+	if _, err := backend.OpenRawWithPublicKey(boxed, peerVerificationEngine); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.OpenRawWithPublicKey(boxed, peerVerificationEngine); err != ReplayError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ReplayError, err)
+	}
+
+}
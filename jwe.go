@@ -0,0 +1,217 @@
+package cryptoengine
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/curve25519"
+	"strings"
+)
+
+// JWE (RFC 7516) compact serialization, with direct key agreement ECDH-ES over X25519 (RFC 8037)
+// and AES-256-GCM content encryption, as supported by the common JOSE libraries.
+
+const (
+	jweAlgorithm  = "ECDH-ES"
+	jweEncryption = "A256GCM"
+	jweKeyType    = "OKP"
+	jweCurve      = "X25519"
+	jweKeyBits    = 256
+)
+
+var (
+	JWEFormatError = errors.New("The JWE token is not valid")
+
+	jweEncoding = base64.RawURLEncoding
+)
+
+// the JWE protected header
+type jweHeader struct {
+	Algorithm  string        `json:"alg"`
+	Encryption string        `json:"enc"`
+	Ephemeral  jweEphemeral  `json:"epk"`
+	KeyId      string        `json:"kid,omitempty"`
+	Critical   []interface{} `json:"crit,omitempty"`
+	Zip        string        `json:"zip,omitempty"`
+}
+
+// the ephemeral public key in JWK format
+type jweEphemeral struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+}
+
+// This method encrypts the payload for the peer public key and returns a compact serialized JWE token.
+// A new ephemeral key pair is generated for each token, so the sender is not authenticated by the token itself.
+func (engine *CryptoEngine) EncryptJWE(payload []byte, verificationEngine VerificationEngine) (string, error) {
+	if err := engine.allow(operationEncrypt); err != nil {
+		return "", err
+	}
+
+	peerPublicKey := verificationEngine.PublicKey()
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return "", KeyNotValidError
+	}
+
+	// ephemeral key agreement
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return "", err
+	}
+
+	ephemeralPublic, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+
+	sharedSecret, err := curve25519.X25519(ephemeral, peerPublicKey[:])
+	if err != nil {
+		return "", KeyNotValidError
+	}
+
+	// protected header
+	header := jweHeader{
+		Algorithm:  jweAlgorithm,
+		Encryption: jweEncryption,
+		Ephemeral: jweEphemeral{
+			KeyType: jweKeyType,
+			Curve:   jweCurve,
+			X:       jweEncoding.EncodeToString(ephemeralPublic),
+		},
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := jweEncoding.EncodeToString(headerJSON)
+
+	// content encryption
+	gcm, err := jweCipher(sharedSecret)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	// the encoded header is the additional authenticated data
+	sealed := gcm.Seal(nil, iv, payload, []byte(encodedHeader))
+	ciphertext := sealed[:len(sealed)-gcm.Overhead()]
+	tag := sealed[len(sealed)-gcm.Overhead():]
+
+	// header.encrypted_key.iv.ciphertext.tag - the encrypted key is empty for direct key agreement
+	return strings.Join([]string{
+		encodedHeader,
+		"",
+		jweEncoding.EncodeToString(iv),
+		jweEncoding.EncodeToString(ciphertext),
+		jweEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// This method decrypts a compact serialized JWE token, encrypted for the engine public key
+func (engine *CryptoEngine) DecryptJWE(token string) ([]byte, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		return nil, JWEFormatError
+	}
+
+	headerJSON, err := jweEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, JWEFormatError
+	}
+
+	header := jweHeader{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, JWEFormatError
+	}
+
+	// only the algorithms produced by EncryptJWE are accepted, critical extensions and compression are not supported
+	if header.Algorithm != jweAlgorithm || header.Encryption != jweEncryption || len(header.Critical) > 0 || header.Zip != "" {
+		return nil, JWEFormatError
+	}
+
+	if header.Ephemeral.KeyType != jweKeyType || header.Ephemeral.Curve != jweCurve {
+		return nil, JWEFormatError
+	}
+
+	ephemeralPublic, err := jweEncoding.DecodeString(header.Ephemeral.X)
+	if err != nil || len(ephemeralPublic) != curve25519.PointSize {
+		return nil, JWEFormatError
+	}
+
+	iv, err := jweEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, JWEFormatError
+	}
+
+	ciphertext, err := jweEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, JWEFormatError
+	}
+
+	tag, err := jweEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, JWEFormatError
+	}
+
+	// X25519 fails on low order points
+	sharedSecret, err := curve25519.X25519(engine.privateKey[:], ephemeralPublic)
+	if err != nil {
+		return nil, JWEFormatError
+	}
+
+	gcm, err := jweCipher(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return nil, JWEFormatError
+	}
+
+	payload, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, MessageDecryptionError
+	}
+	return payload, nil
+}
+
+// derives the content encryption key with the Concat KDF (RFC 7518 section 4.6.2) and returns the AES-GCM cipher
+func jweCipher(sharedSecret []byte) (cipher.AEAD, error) {
+	hash := sha256.New()
+
+	// one round is enough for a 256 bits key
+	binary.Write(hash, binary.BigEndian, uint32(1))
+	hash.Write(sharedSecret)
+
+	// AlgorithmID: for direct key agreement it's the enc value
+	binary.Write(hash, binary.BigEndian, uint32(len(jweEncryption)))
+	hash.Write([]byte(jweEncryption))
+
+	// PartyUInfo and PartyVInfo are empty
+	binary.Write(hash, binary.BigEndian, uint32(0))
+	binary.Write(hash, binary.BigEndian, uint32(0))
+
+	// SuppPubInfo: the key length in bits
+	binary.Write(hash, binary.BigEndian, uint32(jweKeyBits))
+
+	block, err := aes.NewCipher(hash.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,75 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJWE(t *testing.T) {
+
+	firstEngine, err := InitCryptoEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondEngine, err := InitCryptoEngine("Sec51Peer2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondVerificationEngine, err := NewVerificationEngine("Sec51Peer2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"sub":"sec51","msg":"The quick brown fox jumps over the lazy dog"}`)
+	token, err := firstEngine.EncryptJWE(payload, secondVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		t.Fatalf("Unexpected compact serialization: %s\n", token)
+	}
+
+	// check the protected header
+	headerJSON, err := jweEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := map[string]interface{}{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		t.Fatal(err)
+	}
+	if header["alg"] != "ECDH-ES" || header["enc"] != "A256GCM" {
+		t.Errorf("Unexpected JWE header: %s\n", headerJSON)
+	}
+
+	decrypted, err := secondEngine.DecryptJWE(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, payload) {
+		t.Fatal("JWE encryption/decryption broken")
+	}
+
+	// the token is not for the first engine
+	if _, err := firstEngine.DecryptJWE(token); err != MessageDecryptionError {
+		t.Errorf("The expected error is: MessageDecryptionError, instead we've got: %v\n", err)
+	}
+
+	// the header is authenticated
+	tampered := jweEncoding.EncodeToString(bytes.Replace(headerJSON, []byte(`"enc":"A256GCM"`), []byte(`"enc":"A256GCM","kid":"x"`), 1)) + token[len(parts[0]):]
+	if _, err := secondEngine.DecryptJWE(tampered); err != MessageDecryptionError {
+		t.Errorf("The expected error is: MessageDecryptionError, instead we've got: %v\n", err)
+	}
+
+	if _, err := secondEngine.DecryptJWE("a.b.c"); err != JWEFormatError {
+		t.Errorf("The expected error is: JWEFormatError, instead we've got: %v\n", err)
+	}
+
+}
@@ -0,0 +1,190 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Key file format of the FileKeyStore. The legacy key files hold only the hex encoded key, so a corrupted file cannot be told apart
+// from a valid key and the format cannot evolve. The key files are now written with a header and a checksum:
+// |magic|    => 4 bytes (0x89 CEK, never valid hex, so the legacy files are recognized)
+// |version|  => 1 byte
+// |type|     => 1 byte (the KeyType)
+// |created|  => 8 bytes (little endian unix time in seconds)
+// |length|   => 4 bytes (little endian size of the key)
+// |key|      => N bytes
+// |checksum| => 4 bytes (CRC-32C of all the above)
+// The legacy files are still read, and rewritten in the new format the first time they are read.
+// The checksum detects the corruption only, the manifest of the Config.ManifestKey detects the tampering.
+
+const (
+	keyFileMagic        = "\x89CEK"
+	keyFileVersion      = 1
+	keyFileHeaderSize   = 4 + 1 + 1 + 8 + 4
+	keyFileChecksumSize = 4
+)
+
+// The KeyType identifies the purpose of a key in its key file
+type KeyType uint8
+
+const (
+	KeyTypeOther KeyType = iota
+	KeyTypeSecret
+	KeyTypePrivate
+	KeyTypePublic
+	KeyTypeSalt
+	KeyTypeNonce
+	KeyTypeSigningPrivate
+	KeyTypeSigningPublic
+)
+
+var (
+	KeyFileError        = errors.New("The key file is corrupted")
+	KeyFileVersionError = errors.New("The key file version is not supported")
+
+	// the type of the keys by the suffix of their name, the longest suffixes first
+	keyTypeSuffixes = []struct {
+		suffix  string
+		keyType KeyType
+	}{
+		{"_signing_private.key", KeyTypeSigningPrivate},
+		{"_signing_public.key", KeyTypeSigningPublic},
+		{"_secret.key", KeyTypeSecret},
+		{"_private.key", KeyTypePrivate},
+		{"_public.key", KeyTypePublic},
+		{"_salt.key", KeyTypeSalt},
+		{"_nonce.key", KeyTypeNonce},
+	}
+)
+
+// The KeyFileCorruptionError describes why a key file could not be read.
+// It wraps KeyFileError, KeyFileVersionError or KeySizeError, test it with errors.Is
+type KeyFileCorruptionError struct {
+	Name   string // the name of the key file
+	Reason string // what is wrong with the file
+	Err    error  // the generic error
+}
+
+func (e *KeyFileCorruptionError) Error() string {
+	return fmt.Sprintf("%v (%s: %s)", e.Err, e.Name, e.Reason)
+}
+
+func (e *KeyFileCorruptionError) Unwrap() error {
+	return e.Err
+}
+
+// The KeyFileInfo describes a key file
+type KeyFileInfo struct {
+	Version uint8     // the format version, zero for a legacy file
+	Type    KeyType   // the purpose of the key, KeyTypeOther for a legacy file
+	Created time.Time // when the key has been written, zero for a legacy file
+}
+
+// This method returns the header of the key file, without migrating a legacy file
+func (store *FileKeyStore) KeyInfo(name string) (KeyFileInfo, error) {
+	data, err := readFile(store.filePath(name))
+	if os.IsNotExist(err) {
+		return KeyFileInfo{}, KeyNotFoundError
+	}
+	if err != nil {
+		return KeyFileInfo{}, err
+	}
+	_, info, err := decodeKeyFile(name, data)
+	return info, err
+}
+
+// rewrites a legacy key file in the current format, the file is replaced atomically
+func (store *FileKeyStore) migrateKeyFile(name string, key []byte) error {
+	temporary := filepath.Join(store.path, "."+name+".tmp")
+	deleteFile(temporary)
+	if err := writeFile(temporary, encodeKeyFile(keyTypeOf(name), time.Now(), key)); err != nil {
+		return err
+	}
+	if err := os.Rename(temporary, store.filePath(name)); err != nil {
+		deleteFile(temporary)
+		return err
+	}
+	return nil
+}
+
+// returns the type of the key from its name
+func keyTypeOf(name string) KeyType {
+	for _, suffix := range keyTypeSuffixes {
+		if strings.HasSuffix(name, suffix.suffix) {
+			return suffix.keyType
+		}
+	}
+	return KeyTypeOther
+}
+
+func encodeKeyFile(keyType KeyType, created time.Time, key []byte) []byte {
+	data := make([]byte, keyFileHeaderSize, keyFileHeaderSize+len(key)+keyFileChecksumSize)
+	copy(data, keyFileMagic)
+	data[4] = keyFileVersion
+	data[5] = byte(keyType)
+	binary.LittleEndian.PutUint64(data[6:], uint64(created.Unix()))
+	binary.LittleEndian.PutUint32(data[14:], uint32(len(key)))
+	data = append(data, key...)
+
+	var checksum [keyFileChecksumSize]byte
+	binary.LittleEndian.PutUint32(checksum[:], crc32.Checksum(data, castagnoliTable))
+	return append(data, checksum[:]...)
+}
+
+// decodes a key file, in the current or in the legacy format
+func decodeKeyFile(name string, data []byte) ([]byte, KeyFileInfo, error) {
+	var info KeyFileInfo
+	corrupted := func(err error, format string, args ...interface{}) ([]byte, KeyFileInfo, error) {
+		return nil, info, &KeyFileCorruptionError{Name: name, Reason: fmt.Sprintf(format, args...), Err: err}
+	}
+
+	// legacy file: only the hex encoded key, the trailing new line of a file edited by hand is ignored
+	if !bytes.HasPrefix(data, []byte(keyFileMagic)) {
+		data = bytes.TrimRight(data, "\r\n")
+		if len(data) == 0 {
+			return corrupted(KeyFileError, "the legacy file is empty")
+		}
+		if len(data)%2 != 0 {
+			return corrupted(KeyFileError, "the legacy file has an odd amount of hex digits: %d", len(data))
+		}
+		key := make([]byte, hex.DecodedLen(len(data)))
+		if _, err := hex.Decode(key, data); err != nil {
+			return corrupted(KeyFileError, "the legacy file is not hex encoded")
+		}
+		return key, info, nil
+	}
+
+	if len(data) < keyFileHeaderSize+keyFileChecksumSize {
+		return corrupted(KeyFileError, "%d bytes, the minimum is %d", len(data), keyFileHeaderSize+keyFileChecksumSize)
+	}
+	if data[4] != keyFileVersion {
+		return corrupted(KeyFileVersionError, "version %d", data[4])
+	}
+	length := binary.LittleEndian.Uint32(data[14:])
+	if uint64(length) != uint64(len(data)-keyFileHeaderSize-keyFileChecksumSize) {
+		return corrupted(KeyFileError, "the key length is %d, the file holds %d bytes", length, len(data)-keyFileHeaderSize-keyFileChecksumSize)
+	}
+	body := data[:len(data)-keyFileChecksumSize]
+	if crc32.Checksum(body, castagnoliTable) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return corrupted(KeyFileError, "the checksum does not match")
+	}
+
+	info.Version = data[4]
+	info.Type = KeyType(data[5])
+	info.Created = time.Unix(int64(binary.LittleEndian.Uint64(data[6:])), 0)
+	return append([]byte{}, body[keyFileHeaderSize:]...), info, nil
+}
+
+// logs the legacy key files which could not be migrated, they are still read in the legacy format
+func logMigrationError(name string, err error) {
+	log.Printf("[WARNING] - The legacy key file %s could not be rewritten in the current format: %v", name, err)
+}
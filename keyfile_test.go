@@ -0,0 +1,174 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyFileFormat(t *testing.T) {
+
+	folder := t.TempDir()
+	store, err := NewFileKeyStore(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := generateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteKey("sec51_secret.key", key[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := store.KeyInfo("sec51_secret.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != keyFileVersion || info.Type != KeyTypeSecret || time.Since(info.Created) > time.Minute {
+		t.Fatalf("The key file header is not valid: %+v\n", info)
+	}
+
+	stored, err := store.ReadKey("sec51_secret.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, key[:]) {
+		t.Fatal("The key read does not match the one written")
+	}
+
+	// a corrupted key file is detected
+	data, err := ioutil.ReadFile(filepath.Join(folder, "sec51_secret.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := append([]byte{}, data...)
+	corrupted[keyFileHeaderSize] ^= 1
+	if err := ioutil.WriteFile(filepath.Join(folder, "corrupted_secret.key"), corrupted, 0400); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadKey("corrupted_secret.key"); !errors.Is(err, KeyFileError) {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyFileError, err)
+	}
+
+	truncated := data[:len(data)-1]
+	if err := ioutil.WriteFile(filepath.Join(folder, "truncated_secret.key"), truncated, 0400); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadKey("truncated_secret.key"); !errors.Is(err, KeyFileError) {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyFileError, err)
+	}
+
+	future := append([]byte{}, data...)
+	future[4] = keyFileVersion + 1
+	if err := ioutil.WriteFile(filepath.Join(folder, "future_secret.key"), future, 0400); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadKey("future_secret.key"); !errors.Is(err, KeyFileVersionError) {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyFileVersionError, err)
+	}
+
+}
+
+func TestLegacyKeyFileMigration(t *testing.T) {
+
+	folder := t.TempDir()
+	store, err := NewFileKeyStore(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a key file written by an older version
+	key, err := generateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeKey("sec51_nonce.key", filepath.Join(folder, "%s"), key[:]); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := store.KeyInfo("sec51_nonce.key"); err != nil || info.Version != 0 {
+		t.Fatalf("The legacy key file is not recognized: %+v %v\n", info, err)
+	}
+
+	stored, err := store.ReadKey("sec51_nonce.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, key[:]) {
+		t.Fatal("The legacy key does not match the one written")
+	}
+
+	// the file has been rewritten in the current format
+	info, err := store.KeyInfo("sec51_nonce.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != keyFileVersion || info.Type != KeyTypeNonce {
+		t.Fatalf("The legacy key file has not been migrated: %+v\n", info)
+	}
+	if stored, err := store.ReadKey("sec51_nonce.key"); err != nil || !bytes.Equal(stored, key[:]) {
+		t.Fatalf("The migrated key does not match the legacy one: %v\n", err)
+	}
+	if keys, _ := store.ListKeys(""); len(keys) != 1 {
+		t.Fatalf("The migration left other files in the folder: %v\n", keys)
+	}
+
+	// a legacy file which is not hex is corrupted
+	if err := ioutil.WriteFile(filepath.Join(folder, "sec51_salt.key"), []byte("not a key"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadKey("sec51_salt.key"); !errors.Is(err, KeyFileError) {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyFileError, err)
+	}
+
+}
+
+func TestReadKeyCorruption(t *testing.T) {
+
+	folder := t.TempDir()
+	pathFormat := filepath.Join(folder, "%s")
+
+	key, err := generateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := []byte(hex.EncodeToString(key[:]))
+
+	// a trailing new line is accepted
+	if err := ioutil.WriteFile(filepath.Join(folder, "newline_secret.key"), append(encoded, '\n'), 0400); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := readKey("newline_secret.key", pathFormat); err != nil || stored != key {
+		t.Fatalf("The key with a trailing new line is not valid: %v\n", err)
+	}
+
+	files := map[string]struct {
+		data []byte
+		err  error
+	}{
+		"empty_secret.key":     {nil, KeyFileError},
+		"short_secret.key":     {encoded[:10], KeySizeError},
+		"truncated_secret.key": {encoded[:40], KeySizeError},
+		"long_secret.key":      {append(encoded, "abcd"...), KeySizeError},
+		"odd_secret.key":       {encoded[:63], KeyFileError},
+		"binary_secret.key":    {bytes.Repeat([]byte{0xff}, 64), KeyFileError},
+		"header_secret.key":    {[]byte(keyFileMagic), KeyFileError},
+		"other_secret.key":     {encodeKeyFile(KeyTypeSecret, time.Now(), key[:16]), KeySizeError},
+	}
+	for name, file := range files {
+		if err := ioutil.WriteFile(filepath.Join(folder, name), file.data, 0400); err != nil {
+			t.Fatal(err)
+		}
+		_, err := readKey(name, pathFormat)
+		var corruption *KeyFileCorruptionError
+		if !errors.As(err, &corruption) || !errors.Is(err, file.err) || corruption.Name != name {
+			t.Errorf("%s: the expected error is: %v, instead we've got: %v\n", name, file.err, err)
+		}
+	}
+
+}
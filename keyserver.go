@@ -0,0 +1,275 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A tiny keyserver protocol to publish and discover the engines public keys.
+// The key bundles are JSON documents signed with the Ed25519 signing key of the engine:
+// GET {url}/keys/{identifier} returns the bundle (404 if unknown)
+// PUT {url}/keys/{identifier} publishes the bundle (204 on success)
+// The server pins the signing key of each identifier: a bundle can only be replaced by a newer one signed by the same key.
+// The client verifies the signature of every bundle it fetches. The signature alone does not protect from a malicious server,
+// which can serve a bundle signed by its own key: with a Pins key store the client pins the signing key of each identifier
+// the first time it fetches it (trust on first use), and rejects the bundles signed by another key afterwards.
+
+const (
+	keyBundleDomain   = "cryptoengine key bundle v1"
+	keyServerPath     = "/keys/"
+	keyServerMaxBody  = 64 * 1024
+	keyServerMimeType = "application/json"
+
+	keyServerPinSuffixFormat = "%s_keyserver_pin.key" // the signing public key pinned by the client, for instance: sec51_keyserver_pin.key
+)
+
+var (
+	KeyBundleError         = errors.New("The key bundle is not valid")
+	KeyServerNotFoundError = errors.New("The key bundle has not been found on the keyserver")
+	KeyServerError         = errors.New("The keyserver returned an unexpected response")
+	KeyServerPinError      = errors.New("The key bundle is not signed by the pinned signing key")
+)
+
+// The KeyBundle holds the public keys of an engine, signed by its signing key
+type KeyBundle struct {
+	Identifier       string `json:"id"`
+	PublicKey        []byte `json:"public_key"`
+	SigningPublicKey []byte `json:"signing_public_key"`
+	Timestamp        int64  `json:"timestamp"` // unix time of the bundle creation, used to order the updates
+	Signature        []byte `json:"signature"`
+}
+
+// This method returns the signed key bundle of the engine, identified by the engine context
+func (engine *CryptoEngine) KeyBundle() KeyBundle {
+	bundle := KeyBundle{
+		Identifier:       engine.context,
+		PublicKey:        engine.PublicKey(),
+		SigningPublicKey: engine.SigningPublicKey(),
+		Timestamp:        time.Now().Unix(),
+	}
+	bundle.Signature = engine.Sign(bundle.signedBytes())
+	return bundle
+}
+
+// This method verifies the bundle signature and returns the verification engine holding its keys
+func (bundle KeyBundle) VerificationEngine() (VerificationEngine, error) {
+	if bundle.Identifier == "" || len(bundle.PublicKey) != keySize || len(bundle.SigningPublicKey) != ed25519.PublicKeySize {
+		return VerificationEngine{}, KeyBundleError
+	}
+
+	engine, err := NewVerificationEngineWithKeys(bundle.PublicKey, bundle.SigningPublicKey)
+	if err != nil {
+		return engine, KeyBundleError
+	}
+
+	if err := engine.Verify(bundle.signedBytes(), bundle.Signature); err != nil {
+		return VerificationEngine{}, KeyBundleError
+	}
+	return engine, nil
+}
+
+// the signature covers every field of the bundle
+func (bundle KeyBundle) signedBytes() []byte {
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], uint64(bundle.Timestamp))
+	return pae([]byte(keyBundleDomain), []byte(bundle.Identifier), bundle.PublicKey, bundle.SigningPublicKey, timestamp[:])
+}
+
+// The KeyServerClient publishes and fetches key bundles
+type KeyServerClient struct {
+	URL        string       // base URL of the keyserver
+	HTTPClient *http.Client // nil means http.DefaultClient
+	Pins       KeyStore     // where the signing keys are pinned the first time they are fetched. Nil means the server is trusted
+}
+
+// This method publishes the key bundle of the engine
+func (client KeyServerClient) Publish(engine *CryptoEngine) error {
+	bundle := engine.KeyBundle()
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPut, client.bundleURL(bundle.Identifier), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", keyServerMimeType)
+
+	response, err := client.httpClient().Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent {
+		return KeyServerError
+	}
+	return nil
+}
+
+// This method fetches the key bundle of the identifier and returns the verification engine holding its keys.
+// The bundle must be signed and must belong to the identifier. With Pins, it must be signed by the pinned signing key,
+// otherwise KeyServerPinError is returned.
+func (client KeyServerClient) Fetch(identifier string) (VerificationEngine, error) {
+	identifier = sanitizeIdentifier(identifier)
+
+	response, err := client.httpClient().Get(client.bundleURL(identifier))
+	if err != nil {
+		return VerificationEngine{}, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return VerificationEngine{}, KeyServerNotFoundError
+	default:
+		return VerificationEngine{}, KeyServerError
+	}
+
+	bundle, err := readKeyBundle(response.Body)
+	if err != nil {
+		return VerificationEngine{}, err
+	}
+
+	if bundle.Identifier != identifier {
+		return VerificationEngine{}, KeyBundleError
+	}
+	engine, err := bundle.VerificationEngine()
+	if err != nil {
+		return engine, err
+	}
+
+	if client.Pins != nil {
+		if err := client.pin(identifier, bundle.SigningPublicKey); err != nil {
+			return VerificationEngine{}, err
+		}
+	}
+	return engine, nil
+}
+
+// pins the signing key of the identifier the first time, then checks it matches the pinned one
+func (client KeyServerClient) pin(identifier string, signingPublicKey []byte) error {
+	context, err := keyContext(identifier)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf(keyServerPinSuffixFormat, context)
+
+	pinned, err := client.Pins.ReadKey(name)
+	if err == KeyNotFoundError {
+		// another client sharing the store pinned it first: check against its pin
+		if err = client.Pins.WriteKey(name, signingPublicKey); err != os.ErrExist {
+			return err
+		}
+		pinned, err = client.Pins.ReadKey(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !ConstantTimeEqual(pinned, signingPublicKey) {
+		return KeyServerPinError
+	}
+	return nil
+}
+
+func (client KeyServerClient) httpClient() *http.Client {
+	if client.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return client.HTTPClient
+}
+
+func (client KeyServerClient) bundleURL(identifier string) string {
+	return strings.TrimSuffix(client.URL, "/") + keyServerPath + url.PathEscape(identifier)
+}
+
+// The KeyServer is an in memory keyserver, it implements http.Handler
+type KeyServer struct {
+	mutex   sync.RWMutex
+	bundles map[string]KeyBundle
+}
+
+// This function instantiate an empty keyserver
+func NewKeyServer() *KeyServer {
+	return &KeyServer{bundles: make(map[string]KeyBundle)}
+}
+
+func (server *KeyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, keyServerPath) {
+		http.NotFound(w, r)
+		return
+	}
+	identifier := sanitizeIdentifier(strings.TrimPrefix(r.URL.Path, keyServerPath))
+
+	switch r.Method {
+	case http.MethodGet:
+		server.mutex.RLock()
+		bundle, ok := server.bundles[identifier]
+		server.mutex.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", keyServerMimeType)
+		json.NewEncoder(w).Encode(bundle)
+
+	case http.MethodPut:
+		bundle, err := readKeyBundle(r.Body)
+		if err != nil || bundle.Identifier != identifier {
+			http.Error(w, KeyBundleError.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := bundle.VerificationEngine(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+
+		// the signing key is pinned and the updates must be newer
+		if current, ok := server.bundles[identifier]; ok {
+			if !bytes.Equal(current.SigningPublicKey, bundle.SigningPublicKey) || bundle.Timestamp < current.Timestamp {
+				http.Error(w, "The key bundle conflicts with the published one", http.StatusConflict)
+				return
+			}
+		}
+		server.bundles[identifier] = bundle
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// reads and decodes a bundle, limiting the size of the body
+func readKeyBundle(r io.Reader) (KeyBundle, error) {
+	bundle := KeyBundle{}
+	data, err := ioutil.ReadAll(io.LimitReader(r, keyServerMaxBody+1))
+	if err != nil {
+		return bundle, err
+	}
+	if len(data) > keyServerMaxBody {
+		return bundle, KeyBundleError
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return bundle, KeyBundleError
+	}
+	return bundle, nil
+}
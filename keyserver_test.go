@@ -0,0 +1,168 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeyServer(t *testing.T) {
+
+	server := httptest.NewServer(NewKeyServer())
+	defer server.Close()
+
+	client := KeyServerClient{URL: server.URL}
+
+	engine, err := InitCryptoEngine("Sec51KeyServer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Fetch("Sec51KeyServer"); err != KeyServerNotFoundError {
+		t.Errorf("The expected error is: KeyServerNotFoundError, instead we've got: %v\n", err)
+	}
+
+	if err := client.Publish(engine); err != nil {
+		t.Fatal(err)
+	}
+
+	// publishing again is an update
+	if err := client.Publish(engine); err != nil {
+		t.Fatal(err)
+	}
+
+	verificationEngine, err := client.Fetch("Sec51KeyServer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey := verificationEngine.PublicKey()
+	if !bytes.Equal(publicKey[:], engine.PublicKey()) {
+		t.Fatal("The fetched public key is not the engine public key")
+	}
+
+	if err := verificationEngine.Verify([]byte("data"), engine.Sign([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestKeyServerErrors(t *testing.T) {
+
+	server := httptest.NewServer(NewKeyServer())
+	defer server.Close()
+
+	engine, err := InitCryptoEngine("Sec51KeyServer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := InitCryptoEngine("Sec51OtherKeyServer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (KeyServerClient{URL: server.URL}).Publish(engine); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(bundle KeyBundle) int {
+		data, err := json.Marshal(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request, err := http.NewRequest(http.MethodPut, server.URL+"/keys/Sec51KeyServer", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	// a different signing key cannot take over the identifier
+	bundle := other.KeyBundle()
+	bundle.Identifier = "sec51keyserver"
+	bundle.Signature = other.Sign(bundle.signedBytes())
+	if status := put(bundle); status != http.StatusConflict {
+		t.Errorf("The expected status is: 409, instead we've got: %d\n", status)
+	}
+
+	// older bundles are rejected
+	bundle = engine.KeyBundle()
+	bundle.Timestamp -= 3600
+	bundle.Signature = engine.Sign(bundle.signedBytes())
+	if status := put(bundle); status != http.StatusConflict {
+		t.Errorf("The expected status is: 409, instead we've got: %d\n", status)
+	}
+
+	// tampered bundles are rejected
+	bundle = engine.KeyBundle()
+	bundle.PublicKey = other.PublicKey()
+	if status := put(bundle); status != http.StatusBadRequest {
+		t.Errorf("The expected status is: 400, instead we've got: %d\n", status)
+	}
+
+	if _, err := bundle.VerificationEngine(); err != KeyBundleError {
+		t.Errorf("The expected error is: KeyBundleError, instead we've got: %v\n", err)
+	}
+
+	// the client checks the identifier of the bundle
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(other.KeyBundle())
+	})
+	lying := httptest.NewServer(mux)
+	defer lying.Close()
+
+	if _, err := (KeyServerClient{URL: lying.URL}).Fetch("Sec51KeyServer"); err != KeyBundleError {
+		t.Errorf("The expected error is: KeyBundleError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestKeyServerPinning(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51KeyServerPin", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the attacker controls the server and signs a bundle for the same identifier with its own keys
+	attacker, err := InitCryptoEngineWithConfig("Sec51KeyServerPin", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := engine.KeyBundle()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(served)
+	}))
+	defer server.Close()
+
+	pins := NewMemoryKeyStore()
+	client := KeyServerClient{URL: server.URL, Pins: pins}
+	if _, err := client.Fetch("Sec51KeyServerPin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the substituted bundle is validly self-signed, only the pin detects it
+	served = attacker.KeyBundle()
+	if _, err := (KeyServerClient{URL: server.URL}).Fetch("Sec51KeyServerPin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Fetch("Sec51KeyServerPin"); err != KeyServerPinError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyServerPinError, err)
+	}
+
+	// a newer bundle signed by the pinned key is accepted
+	served = engine.KeyBundle()
+	if _, err := client.Fetch("Sec51KeyServerPin"); err != nil {
+		t.Fatal(err)
+	}
+
+}
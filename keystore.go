@@ -0,0 +1,291 @@
+package cryptoengine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key and counter persistence.
+// The KeyStore holds the keys an engine is initialized with: the salt, the secret, nonce, asymmetric and signing keys.
+// By default they are stored in the files of the keys folder, one file per key.
+// The CounterStore persists the nonce counters: the engine reserves blocks of counters from it,
+// so the counters are never reused after a restart or by several instances sharing the same keys.
+
+const (
+	counterBlockSize = 1024 // amount of counters reserved at once from the counter store
+)
+
+var (
+	KeyNotFoundError     = errors.New("The key does not exist in the key store")
+	CounterOverflowError = errors.New("The counter store has no more counters available")
+	KeyListError         = errors.New("The key store cannot list its keys")
+)
+
+// The KeyStore interface persists the engine keys by name, for instance sec51_secret.key
+type KeyStore interface {
+	// returns the key or KeyNotFoundError when it does not exist
+	ReadKey(name string) ([]byte, error)
+	// stores the key, or returns os.ErrExist when it already exists: the keys are never overwritten
+	WriteKey(name string, data []byte) error
+	// deletes the key, deleting a key which does not exist is not an error
+	DeleteKey(name string) error
+}
+
+// The KeyLister interface is implemented by the key stores which can list their keys, the X3DH prekeys need it
+type KeyLister interface {
+	// returns the names of the keys starting with the prefix
+	ListKeys(prefix string) ([]string, error)
+}
+
+// The CounterStore interface persists the nonce counters of the engines by context
+type CounterStore interface {
+	// reserves n consecutive counters for the context and returns the first one
+	ReserveCounters(context string, n uint64) (uint64, error)
+}
+
+// The FileKeyStore stores each key in a read only file of the folder, with a header and a checksum: see KeyFileInfo
+type FileKeyStore struct {
+	path string
+}
+
+// This function returns a key store which stores the keys in the folder, creating it if needed
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	if err := createBaseKeyFolder(path); err != nil {
+		return nil, err
+	}
+	return &FileKeyStore{path: path}, nil
+}
+
+// This method reads the key file, the legacy hex encoded files are rewritten in the current format
+func (store *FileKeyStore) ReadKey(name string) ([]byte, error) {
+	data, err := readFile(store.filePath(name))
+	if os.IsNotExist(err) {
+		return nil, KeyNotFoundError
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, info, err := decodeKeyFile(name, data)
+	if err != nil {
+		return nil, err
+	}
+	if info.Version == 0 {
+		if err := store.migrateKeyFile(name, key); err != nil {
+			logMigrationError(name, err)
+		}
+	}
+	return key, nil
+}
+
+// This method writes the key file
+func (store *FileKeyStore) WriteKey(name string, data []byte) error {
+	return writeFile(store.filePath(name), encodeKeyFile(keyTypeOf(name), time.Now(), data))
+}
+
+// This method deletes the key file
+func (store *FileKeyStore) DeleteKey(name string) error {
+	return deleteFile(store.filePath(name))
+}
+
+// This method lists the key files of the folder starting with the prefix
+func (store *FileKeyStore) ListKeys(prefix string) ([]string, error) {
+	folder, err := os.Open(store.path)
+	if err != nil {
+		return nil, err
+	}
+	defer folder.Close()
+
+	names, err := folder.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			keys = append(keys, name)
+		}
+	}
+	return keys, nil
+}
+
+func (store *FileKeyStore) filePath(name string) string {
+	return filepath.Join(store.path, name)
+}
+
+// The MemoryKeyStore keeps the keys and the counters in memory only: the engines using it are ephemeral
+type MemoryKeyStore struct {
+	mutex    sync.Mutex
+	keys     map[string][]byte
+	counters map[string]uint64
+}
+
+// This function returns an empty in memory key and counter store
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{
+		keys:     make(map[string][]byte),
+		counters: make(map[string]uint64),
+	}
+}
+
+// This method returns a copy of the key
+func (store *MemoryKeyStore) ReadKey(name string) ([]byte, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	key, ok := store.keys[name]
+	if !ok {
+		return nil, KeyNotFoundError
+	}
+	return append([]byte{}, key...), nil
+}
+
+// This method stores a copy of the key
+func (store *MemoryKeyStore) WriteKey(name string, data []byte) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.keys[name]; ok {
+		return os.ErrExist
+	}
+	store.keys[name] = append([]byte{}, data...)
+	return nil
+}
+
+// This method wipes and deletes the key
+func (store *MemoryKeyStore) DeleteKey(name string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	key := store.keys[name]
+	for i := range key {
+		key[i] = 0
+	}
+	delete(store.keys, name)
+	return nil
+}
+
+// This method lists the keys starting with the prefix
+func (store *MemoryKeyStore) ListKeys(prefix string) ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	var keys []string
+	for name := range store.keys {
+		if strings.HasPrefix(name, prefix) {
+			keys = append(keys, name)
+		}
+	}
+	return keys, nil
+}
+
+// This method reserves n counters for the context
+func (store *MemoryKeyStore) ReserveCounters(context string, n uint64) (uint64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	first := store.counters[context]
+	if first > math.MaxUint64-n {
+		return 0, CounterOverflowError
+	}
+	store.counters[context] = first + n
+	return first, nil
+}
+
+// returns the key store configured or the default one of the platform, encrypting the keys when there is a master key
+func (config Config) keyStore() KeyStore {
+	store := config.KeyStore
+	if store == nil {
+		store = defaultKeyStore()
+	}
+	if config.MasterKey != nil {
+		return newEncryptedKeyStore(store, config.MasterKey)
+	}
+	return store
+}
+
+// reads a 32 bytes key from the store
+func readStoreKey(store KeyStore, name string) ([keySize]byte, error) {
+	var key [keySize]byte
+	data, err := store.ReadKey(name)
+	if err != nil {
+		return key, err
+	}
+	if len(data) != keySize {
+		return key, KeySizeError
+	}
+	copy(key[:], data)
+	return key, nil
+}
+
+// loads the key from the store, or generates and stores it if it does not exist
+func loadOrGenerateKey(store KeyStore, nameFormat, id string, generate func() ([keySize]byte, error)) ([keySize]byte, error) {
+	name := fmt.Sprintf(nameFormat, id)
+
+	key, err := readStoreKey(store, name)
+	if err != KeyNotFoundError {
+		return key, err
+	}
+
+	if key, err = generate(); err != nil {
+		return key, err
+	}
+
+	// another instance sharing the store stored the key first: use its key
+	if err := store.WriteKey(name, key[:]); err == os.ErrExist {
+		return readStoreKey(store, name)
+	} else if err != nil {
+		return key, err
+	}
+	return key, nil
+}
+
+// This function loads the public keys of the peer from the key store, like NewVerificationEngine does from the keys folder
+func NewVerificationEngineFromStore(store KeyStore, context string) (VerificationEngine, error) {
+	engine := VerificationEngine{}
+	if context == "" {
+		return engine, errors.New("Context cannot be empty when initializing the Verification Engine")
+	}
+	id, err := keyContext(context)
+	if err != nil {
+		return engine, err
+	}
+
+	publicKey, err := readStoreKey(store, fmt.Sprintf(publicKeySuffixFormat, id))
+	if err != nil && err != KeyNotFoundError {
+		return engine, err
+	}
+	engine.publicKey = publicKey
+
+	signingPublicKey, err := readStoreKey(store, fmt.Sprintf(signingPublicSuffixFormat, id))
+	if err != nil && err != KeyNotFoundError {
+		return engine, err
+	}
+	engine.signingPublicKey = signingPublicKey
+
+	return engine, nil
+}
+
+// This function stores the public keys of the peer, so they can be loaded back with NewVerificationEngineFromStore
+func RegisterPeer(store KeyStore, context string, peer VerificationEngine) error {
+	if context == "" {
+		return errors.New("Context cannot be empty when registering a peer")
+	}
+	id, err := keyContext(context)
+	if err != nil {
+		return err
+	}
+
+	publicKey := peer.PublicKey()
+	if err := store.WriteKey(fmt.Sprintf(publicKeySuffixFormat, id), publicKey[:]); err != nil {
+		return err
+	}
+
+	// the signing public key is optional
+	if signingPublicKey := peer.SigningPublicKey(); signingPublicKey != [keySize]byte{} {
+		return store.WriteKey(fmt.Sprintf(signingPublicSuffixFormat, id), signingPublicKey[:])
+	}
+	return nil
+}
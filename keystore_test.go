@@ -0,0 +1,182 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMemoryKeyStore(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	config := Config{KeyStore: store, CounterStore: store}
+
+	first, err := InitCryptoEngineWithConfig("Sec51Store", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys are loaded back from the store
+	second, err := InitCryptoEngineWithConfig("Sec51Store", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.secretKey != second.secretKey || first.privateKey != second.privateKey || !bytes.Equal(first.signingKey, second.signingKey) {
+		t.Fatal("The keys have not been loaded from the store")
+	}
+
+	// the keys are not written to the keys folder
+	if keyFileExists("sec51store_secret.key") {
+		t.Fatal("The key has been written to the keys folder")
+	}
+
+	// the two instances share the keys but never the nonces
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonces := make(map[[nonceSize]byte]bool)
+	for i := 0; i < 2*counterBlockSize; i++ {
+		for _, engine := range []*CryptoEngine{first, second} {
+			encrypted, err := engine.NewEncryptedMessage(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nonces[encrypted.nonce] {
+				t.Fatal("The nonce has been reused")
+			}
+			nonces[encrypted.nonce] = true
+		}
+	}
+
+	encrypted, err := first.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Decrypt(encryptedBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.WriteKey("sec51store_secret.key", make([]byte, keySize)); err != os.ErrExist {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", os.ErrExist, err)
+	}
+}
+
+func TestFileKeyStore(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "cryptoengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	store, err := NewFileKeyStore(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.ReadKey("missing.key"); err != KeyNotFoundError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyNotFoundError, err)
+	}
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Store", Config{KeyStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secretKey, err := store.ReadKey("sec51store_secret.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secretKey, engine.secretKey[:]) {
+		t.Fatal("The key has not been written to the folder")
+	}
+
+	if err := store.DeleteKey("sec51store_secret.key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadKey("sec51store_secret.key"); err != KeyNotFoundError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyNotFoundError, err)
+	}
+}
+
+func TestRegisterPeer(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+
+	peer, err := InitCryptoEngine("Sec51Peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerVerificationEngine, err := NewVerificationEngine("Sec51Peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterPeer(store, "Sec51Peer", peerVerificationEngine); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewVerificationEngineFromStore(store, "sec51peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.PublicKey() != peer.publicKey || !bytes.Equal(loaded.signingPublicKey[:], peer.SigningPublicKey()) {
+		t.Fatal("The peer has not been loaded from the store")
+	}
+}
+
+func TestCounterStoreOverflow(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	store.counters["sec51overflow"] = ^uint64(0) - 10
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Overflow", Config{KeyStore: store, CounterStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.NewEncryptedMessage(msg); err != CounterOverflowError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", CounterOverflowError, err)
+	}
+}
+
+// simulates another instance storing the key between the read and the write
+type racingKeyStore struct {
+	*MemoryKeyStore
+	winner []byte
+}
+
+func (store *racingKeyStore) WriteKey(name string, data []byte) error {
+	if err := store.MemoryKeyStore.WriteKey(name, store.winner); err != nil {
+		return err
+	}
+	return os.ErrExist
+}
+
+func TestLoadOrGenerateKeyRace(t *testing.T) {
+
+	winner := bytes.Repeat([]byte{7}, keySize)
+	store := &racingKeyStore{MemoryKeyStore: NewMemoryKeyStore(), winner: winner}
+
+	key, err := loadSecretKey(store, "sec51race")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key[:], winner) {
+		t.Fatal("The key stored by the other instance has not been loaded")
+	}
+}
@@ -0,0 +1,78 @@
+// Package keywatch reloads a cryptoengine.ReloadingEngine when the key files of its folder change,
+// for instance when another process or the command line tool rotates them.
+//
+//	engine, err := cryptoengine.NewReloadingEngine("app", cryptoengine.Config{})
+//	go keywatch.Watch(ctx, engine, "keys", func(err error) { log.Println(err) })
+//
+// The rotation writes several files: the engine is reloaded once the folder has been quiet for a short delay,
+// and when the keys cannot be loaded yet the current engine is kept and the next change tries again.
+package keywatch
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sec51/cryptoengine"
+	"path/filepath"
+	"time"
+)
+
+const (
+	keyFileExtension = ".key"
+	settleDelay      = 200 * time.Millisecond // how long the folder must be quiet before reloading
+)
+
+// This function watches the folder and reloads the engine when a key file is created, written, removed or renamed.
+// It blocks until the context is done. The errors of the reloads and of the watcher are passed to onError, which can be nil.
+func Watch(ctx context.Context, engine *cryptoengine.ReloadingEngine, folder string, onError func(error)) error {
+	return watch(ctx, engine, folder, settleDelay, onError)
+}
+
+func watch(ctx context.Context, engine *cryptoengine.ReloadingEngine, folder string, delay time.Duration, onError func(error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(folder); err != nil {
+		return err
+	}
+
+	report := func(err error) {
+		if onError != nil {
+			onError(err)
+		}
+	}
+
+	// the timer fires once the folder has been quiet for the delay
+	settle := time.NewTimer(delay)
+	settle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Ext(event.Name) != keyFileExtension || event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			settle.Stop()
+			settle.Reset(delay)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			report(err)
+
+		case <-settle.C:
+			if err := engine.Reload(); err != nil {
+				report(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package keywatch
+
+import (
+	"context"
+	"github.com/sec51/cryptoengine"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+
+	folder := t.TempDir()
+	store, err := cryptoengine.NewFileKeyStore(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := cryptoengine.NewReloadingEngine("Sec51Watch", cryptoengine.Config{KeyStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := engine.Engine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- watch(ctx, engine, folder, 20*time.Millisecond, func(err error) { t.Log(err) })
+	}()
+
+	// another process rotates the keys of the engine
+	time.Sleep(100 * time.Millisecond)
+	for _, name := range []string{"sec51watch_public.key", "sec51watch_private.key", "sec51watch_secret.key"} {
+		if err := store.DeleteKey(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotated, err := cryptoengine.InitCryptoEngineWithConfig("Sec51Watch", cryptoengine.Config{KeyStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for engine.Engine() == current && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if engine.Engine().Fingerprint() != rotated.Fingerprint() {
+		t.Fatal("The rotated keys have not been reloaded")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", context.Canceled, err)
+	}
+
+}
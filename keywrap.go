@@ -0,0 +1,101 @@
+package cryptoengine
+
+import (
+	"errors"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Key wrapping for envelope encryption: data encryption keys (DEK) are generated by the application,
+// wrapped under a key encryption key (KEK) and stored next to the data they encrypt.
+// The KEK is either a subkey of the engine secret key or the pre-shared key with a peer.
+// A wrapped key is: type (1 byte)|nonce (24 bytes)|sealed key
+
+const (
+	wrapSubKeyLabel   = "key wrapping"
+	wrapTypeSecret    = 1
+	wrapTypePublicKey = 2
+	maxWrappedKeySize = 64
+)
+
+var (
+	WrappedKeyError = errors.New("The wrapped key is not valid")
+)
+
+// This function generates a new random 32 bytes data encryption key
+func GenerateDataKey() ([keySize]byte, error) {
+	return generateSecretKey()
+}
+
+// This method wraps the data key with a key derived from the engine secret key.
+// Only this engine can unwrap it.
+func (engine *CryptoEngine) WrapKey(dataKey []byte) ([]byte, error) {
+	kek, err := engine.deriveSubKey(wrapSubKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return engine.wrapKey(wrapTypeSecret, dataKey, &kek)
+}
+
+// This method wraps the data key for the peer: both the peer and this engine can unwrap it
+func (engine *CryptoEngine) WrapKeyWithPubKey(dataKey []byte, verificationEngine VerificationEngine) ([]byte, error) {
+	peerPublicKey := verificationEngine.PublicKey()
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return nil, KeyNotValidError
+	}
+
+	preSharedKey := engine.preSharedKey(peerPublicKey)
+	return engine.wrapKey(wrapTypePublicKey, dataKey, &preSharedKey)
+}
+
+// This method unwraps a data key wrapped with WrapKey
+func (engine *CryptoEngine) UnwrapKey(wrapped []byte) ([]byte, error) {
+	kek, err := engine.deriveSubKey(wrapSubKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapKey(wrapTypeSecret, wrapped, &kek)
+}
+
+// This method unwraps a data key wrapped by the peer with WrapKeyWithPubKey
+func (engine *CryptoEngine) UnwrapKeyWithPublicKey(wrapped []byte, verificationEngine VerificationEngine) ([]byte, error) {
+	peerPublicKey := verificationEngine.PublicKey()
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return nil, KeyNotValidError
+	}
+
+	preSharedKey := engine.preSharedKey(peerPublicKey)
+	return unwrapKey(wrapTypePublicKey, wrapped, &preSharedKey)
+}
+
+func (engine *CryptoEngine) wrapKey(wrapType byte, dataKey []byte, kek *[keySize]byte) ([]byte, error) {
+	if len(dataKey) == 0 || len(dataKey) > maxWrappedKeySize {
+		return nil, KeySizeError
+	}
+
+	nonce, err := engine.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := make([]byte, 1, 1+nonceSize+len(dataKey)+secretbox.Overhead)
+	wrapped[0] = wrapType
+	wrapped = append(wrapped, nonce[:]...)
+
+	// with the pre-shared key this is what box.SealAfterPrecomputation does
+	return secretbox.Seal(wrapped, dataKey, &nonce, kek), nil
+}
+
+func unwrapKey(wrapType byte, wrapped []byte, kek *[keySize]byte) ([]byte, error) {
+	if len(wrapped) <= 1+nonceSize+secretbox.Overhead || len(wrapped) > 1+nonceSize+secretbox.Overhead+maxWrappedKeySize || wrapped[0] != wrapType {
+		return nil, WrappedKeyError
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], wrapped[1:1+nonceSize])
+
+	dataKey, ok := secretbox.Open(nil, wrapped[1+nonceSize:], &nonce, kek)
+	if !ok {
+		return nil, MessageDecryptionError
+	}
+	return dataKey, nil
+}
@@ -0,0 +1,115 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrapKey(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped, err := engine.WrapKey(dataKey[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := engine.UnwrapKey(wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(unwrapped, dataKey[:]) {
+		t.Fatal("The unwrapped key is not the data key")
+	}
+
+	// a key wrapped for a peer cannot be unwrapped with the secret key
+	if _, err := InitCryptoEngine("Sec51Peer"); err != nil {
+		t.Fatal(err)
+	}
+
+	peerVerificationEngine, err := NewVerificationEngine("Sec51Peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerWrapped, err := engine.WrapKeyWithPubKey(dataKey[:], peerVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.UnwrapKey(peerWrapped); err != WrappedKeyError {
+		t.Errorf("The expected error is: WrappedKeyError, instead we've got: %v\n", err)
+	}
+
+	wrapped[len(wrapped)-1] ^= 1
+	if _, err := engine.UnwrapKey(wrapped); err != MessageDecryptionError {
+		t.Errorf("The expected error is: MessageDecryptionError, instead we've got: %v\n", err)
+	}
+
+	if _, err := engine.WrapKey(make([]byte, maxWrappedKeySize+1)); err != KeySizeError {
+		t.Errorf("The expected error is: KeySizeError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestWrapKeyWithPubKey(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer, err := InitCryptoEngine("Sec51Peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verificationEngine, err := NewVerificationEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerVerificationEngine, err := NewVerificationEngine("Sec51Peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped, err := engine.WrapKeyWithPubKey(dataKey[:], peerVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := peer.UnwrapKeyWithPublicKey(wrapped, verificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(unwrapped, dataKey[:]) {
+		t.Fatal("The unwrapped key is not the data key")
+	}
+
+	// the sender can unwrap it as well
+	unwrapped, err = engine.UnwrapKeyWithPublicKey(wrapped, peerVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(unwrapped, dataKey[:]) {
+		t.Fatal("The unwrapped key is not the data key")
+	}
+
+}
@@ -0,0 +1,126 @@
+package cryptoengine
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kubernetes Secrets and ConfigMaps.
+//
+// At pod startup, the values armored by the engine (ce1:... or ENC[ce1:...]) are decrypted with the engine secret key,
+// either from the data of a Secret or from the files of a mounted Secret volume.
+//
+// Like sealed-secrets, a value can be sealed for the public key of a cluster engine, held only by a controller,
+// and committed next to the manifests. The sealed value is bound to the namespace and the name of the Secret,
+// so it can be unsealed only into that Secret. A sealed value is: sealed1:<base64 COSE_Encrypt>
+
+const (
+	sealedSecretPrefix = "sealed1:"
+	sealedSecretLabel  = "cryptoengine kubernetes secret"
+)
+
+var (
+	SealedSecretError = errors.New("The sealed secret value is not valid")
+)
+
+// This function seals the value for the cluster engine public key, bound to the namespace and the name of the Secret
+func SealSecretValue(value []byte, cluster VerificationEngine, namespace, name string) (string, error) {
+	sealed, err := encryptCOSE(value, sealedSecretScope(namespace, name), cluster.PublicKey())
+	if err != nil {
+		return "", err
+	}
+	return sealedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// This method unseals a value sealed with SealSecretValue for the engine public key and the same Secret
+func (engine *CryptoEngine) UnsealSecretValue(sealed, namespace, name string) ([]byte, error) {
+	if !strings.HasPrefix(sealed, sealedSecretPrefix) {
+		return nil, SealedSecretError
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed[len(sealedSecretPrefix):])
+	if err != nil {
+		return nil, SealedSecretError
+	}
+	return engine.DecryptCOSE(data, sealedSecretScope(namespace, name))
+}
+
+// This method unseals all the values of a sealed Secret and returns the data of the Secret to create.
+// It's meant to be used by a controller which holds the cluster engine.
+func (engine *CryptoEngine) UnsealSecretData(sealed map[string]string, namespace, name string) (map[string][]byte, error) {
+	data := make(map[string][]byte, len(sealed))
+	for key, value := range sealed {
+		clearText, err := engine.UnsealSecretValue(value, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = clearText
+	}
+	return data, nil
+}
+
+// This method decrypts the armored values of the data of a Secret or ConfigMap, the other values are returned as they are
+func (engine *CryptoEngine) DecryptSecretData(data map[string][]byte) (map[string][]byte, error) {
+	decrypted := make(map[string][]byte, len(data))
+	for key, value := range data {
+		clearText, err := engine.decryptSecretValue(value)
+		if err != nil {
+			return nil, err
+		}
+		decrypted[key] = clearText
+	}
+	return decrypted, nil
+}
+
+// This method reads the files of a mounted Secret or ConfigMap volume and decrypts them like DecryptSecretData.
+// The entries are returned by file name; the directories and the hidden files Kubernetes uses for the atomic updates are skipped.
+func (engine *CryptoEngine) DecryptMountedSecrets(directory string) (map[string][]byte, error) {
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		// the keys are symlinks to the files of the current ..data directory
+		path := filepath.Join(directory, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		if data[entry.Name()], err = readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return engine.DecryptSecretData(data)
+}
+
+// decrypts the value if it's armored, with or without the ENC[...] marker
+func (engine *CryptoEngine) decryptSecretValue(value []byte) ([]byte, error) {
+	armored := strings.TrimSpace(string(value))
+	if strings.HasPrefix(armored, envMarkerPrefix) && strings.HasSuffix(armored, envMarkerSuffix) {
+		armored = armored[len(envMarkerPrefix) : len(armored)-len(envMarkerSuffix)]
+	}
+
+	if !strings.HasPrefix(armored, armoredPrefix) {
+		return value, nil
+	}
+	return engine.DecryptArmored(armored)
+}
+
+// the namespace and the name of the Secret are authenticated with the sealed value
+func sealedSecretScope(namespace, name string) []byte {
+	return pae([]byte(sealedSecretLabel), []byte(namespace), []byte(name))
+}
@@ -0,0 +1,115 @@
+package cryptoengine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecryptSecretData(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	armored, err := engine.EncryptArmored([]byte("s3cr3t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marked, err := engine.EncryptEnv("t0k3n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := engine.DecryptSecretData(map[string][]byte{
+		"password": []byte(armored),
+		"token":    []byte(marked + "\n"),
+		"host":     []byte("db.example.com"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data["password"]) != "s3cr3t" || string(data["token"]) != "t0k3n" || string(data["host"]) != "db.example.com" {
+		t.Fatal("The secret data has not been decrypted")
+	}
+
+	// mounted volume, with the layout created by the kubelet
+	directory, err := ioutil.TempDir("", "cryptoengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	dataDirectory := filepath.Join(directory, "..2026_10_16_00_00_00.000000000")
+	if err := os.Mkdir(dataDirectory, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dataDirectory, "password"), []byte(armored), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Base(dataDirectory), filepath.Join(directory, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..data", "password"), filepath.Join(directory, "password")); err != nil {
+		t.Fatal(err)
+	}
+
+	mounted, err := engine.DecryptMountedSecrets(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounted) != 1 || string(mounted["password"]) != "s3cr3t" {
+		t.Fatal("The mounted secrets have not been decrypted")
+	}
+}
+
+func TestSealSecretValue(t *testing.T) {
+
+	cluster, err := InitCryptoEngine("Sec51Cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clusterVerificationEngine, err := NewVerificationEngine("Sec51Cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := SealSecretValue([]byte("s3cr3t"), clusterVerificationEngine, "production", "database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := cluster.UnsealSecretData(map[string]string{"password": sealed}, "production", "database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data["password"]) != "s3cr3t" {
+		t.Fatal("The sealed secret has not been unsealed")
+	}
+
+	// the value is bound to the namespace and the name of the secret
+	if _, err := cluster.UnsealSecretValue(sealed, "staging", "database"); err == nil {
+		t.Fatal("The sealed value has been unsealed in another namespace")
+	}
+	if _, err := cluster.UnsealSecretValue(sealed, "production", "other"); err == nil {
+		t.Fatal("The sealed value has been unsealed into another secret")
+	}
+
+	// only the cluster engine can unseal it
+	other, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.UnsealSecretValue(sealed, "production", "database"); err == nil {
+		t.Fatal("The sealed value has been unsealed by another engine")
+	}
+
+	if _, err := cluster.UnsealSecretValue("s3cr3t", "production", "database"); err != SealedSecretError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", SealedSecretError, err)
+	}
+}
@@ -0,0 +1,148 @@
+package cryptoengine
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Multi-identity servers: the EngineManager initializes the engine of a communication identifier the first time it's requested
+// and caches it, so a server handling many tenants loads the keys of each tenant once.
+// The amount of cached engines is bounded: the least recently used engine is dropped when the limit is reached
+// and initialized again, from the key store, the next time it's requested.
+// Only the engines whose Config has a CounterStore are dropped: an engine initialized again without it would restart
+// its nonce counter from zero, with the same keys and salt, and reuse the nonces. Without a CounterStore the engines
+// stay cached, and an engine removed with Remove hands its counter over to the engine initialized after it, like Reload.
+
+const (
+	defaultMaxEngines = 1024
+)
+
+// The EngineManager caches the engines by communication identifier. It's safe for concurrent use.
+type EngineManager struct {
+	config     Config
+	maxEngines int
+	mutex      sync.Mutex
+	engines    map[string]*list.Element
+	lru        *list.List                // most recently used first
+	retired    map[string]*managedEngine // the engines removed without a CounterStore, their counter is handed over to the next engine
+}
+
+// an engine of the manager: ready is closed once the initialization has completed, successfully or not
+type managedEngine struct {
+	context string
+	ready   chan struct{}
+	engine  *CryptoEngine
+	err     error
+}
+
+// This function returns a manager which initializes the engines with the config and caches at most maxEngines of them.
+// Zero means 1024 engines. The limit applies only to a Config with a CounterStore.
+func NewEngineManager(config Config, maxEngines int) *EngineManager {
+	if maxEngines <= 0 {
+		maxEngines = defaultMaxEngines
+	}
+	return &EngineManager{
+		config:     config,
+		maxEngines: maxEngines,
+		engines:    make(map[string]*list.Element),
+		lru:        list.New(),
+		retired:    make(map[string]*managedEngine),
+	}
+}
+
+// This method returns the engine of the communication identifier, initializing it if it's not cached.
+// Concurrent calls for the same identifier wait for a single initialization.
+func (manager *EngineManager) Engine(communicationIdentifier string) (*CryptoEngine, error) {
+	context := sanitizeIdentifier(communicationIdentifier)
+
+	manager.mutex.Lock()
+	if element, ok := manager.engines[context]; ok {
+		manager.lru.MoveToFront(element)
+		managed := element.Value.(*managedEngine)
+		manager.mutex.Unlock()
+
+		<-managed.ready
+		return managed.engine, managed.err
+	}
+
+	managed := &managedEngine{context: context, ready: make(chan struct{})}
+	manager.engines[context] = manager.lru.PushFront(managed)
+	manager.evict()
+	previous := manager.retired[context]
+	delete(manager.retired, context)
+	manager.mutex.Unlock()
+
+	// the keys are loaded without holding the mutex, they can come from a slow key store
+	managed.engine, managed.err = InitCryptoEngineWithConfig(communicationIdentifier, manager.config)
+
+	// the removed engine and the new one share the counter, so the nonces are not reused
+	if previous != nil && managed.err == nil {
+		<-previous.ready
+		if previous.engine != nil {
+			previous.engine.handOver(managed.engine)
+		}
+	}
+	close(managed.ready)
+
+	// the failures are not cached, the next call tries again
+	if managed.err != nil {
+		manager.mutex.Lock()
+		if element, ok := manager.engines[context]; ok && element.Value == managed {
+			manager.remove(element)
+		}
+		if previous != nil {
+			manager.retired[context] = previous
+		}
+		manager.mutex.Unlock()
+	}
+	return managed.engine, managed.err
+}
+
+// This method drops the cached engine of the communication identifier, for instance once its keys have been rotated.
+// Without a CounterStore the engine initialized next takes its nonce counter over, and the removed engine reserves
+// its nonces from the new one.
+func (manager *EngineManager) Remove(communicationIdentifier string) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	element, ok := manager.engines[sanitizeIdentifier(communicationIdentifier)]
+	if !ok {
+		return
+	}
+	if manager.config.CounterStore == nil {
+		managed := element.Value.(*managedEngine)
+		manager.retired[managed.context] = managed
+	}
+	manager.remove(element)
+}
+
+// This method returns the amount of cached engines
+func (manager *EngineManager) Len() int {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return len(manager.engines)
+}
+
+// drops all the cached engines, once their keys have been deleted
+func (manager *EngineManager) clear() {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.engines = make(map[string]*list.Element)
+	manager.retired = make(map[string]*managedEngine)
+	manager.lru.Init()
+}
+
+// drops the least recently used engines above the limit, the caller holds the mutex.
+// The engines without a CounterStore are never dropped, they would reuse their nonces once initialized again.
+func (manager *EngineManager) evict() {
+	if manager.config.CounterStore == nil {
+		return
+	}
+	for len(manager.engines) > manager.maxEngines {
+		manager.remove(manager.lru.Back())
+	}
+}
+
+func (manager *EngineManager) remove(element *list.Element) {
+	manager.lru.Remove(element)
+	delete(manager.engines, element.Value.(*managedEngine).context)
+}
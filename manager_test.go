@@ -0,0 +1,179 @@
+package cryptoengine
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestEngineManager(t *testing.T) {
+
+	// only the engines with a counter store are evicted
+	store := NewMemoryKeyStore()
+	manager := NewEngineManager(Config{KeyStore: store, CounterStore: store}, 2)
+
+	first, err := manager.Engine("Tenant One")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the identifiers are sanitized like InitCryptoEngine does
+	cached, err := manager.Engine("tenant_one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != first {
+		t.Fatal("The engine has not been cached")
+	}
+
+	if _, err := manager.Engine("tenant two"); err != nil {
+		t.Fatal(err)
+	}
+
+	// tenant one is the least recently used one
+	if _, err := manager.Engine("tenant three"); err != nil {
+		t.Fatal(err)
+	}
+	if manager.Len() != 2 {
+		t.Fatal("The amount of cached engines is not bounded")
+	}
+
+	reloaded, err := manager.Engine("tenant one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded == first {
+		t.Fatal("The least recently used engine has not been evicted")
+	}
+	if string(reloaded.PublicKey()) != string(first.PublicKey()) {
+		t.Fatal("The evicted engine has not been loaded again with the same keys")
+	}
+
+	manager.Remove("tenant one")
+	if manager.Len() != 1 {
+		t.Fatal("The engine has not been removed")
+	}
+}
+
+func TestEngineManagerNonces(t *testing.T) {
+
+	// without a counter store the engines are not evicted
+	manager := NewEngineManager(Config{KeyStore: NewMemoryKeyStore()}, 1)
+	first, err := manager.Engine("tenant one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Engine("tenant two"); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := manager.Engine("tenant one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != first {
+		t.Fatal("The engine without a counter store has been evicted")
+	}
+
+	// the removed engine and the new one share the counter
+	before, err := first.nextNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Remove("tenant one")
+	second, err := manager.Engine("tenant one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Fatal("The engine has not been removed")
+	}
+	after, err := second.nextNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := first.nextNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after || before == old || after == old {
+		t.Fatal("A nonce has been reused after the engine has been removed")
+	}
+
+	// with a counter store the evicted engine reserves new counters
+	store := NewMemoryKeyStore()
+	manager = NewEngineManager(Config{KeyStore: store, CounterStore: store}, 1)
+	first, err = manager.Engine("tenant one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err = first.nextNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Engine("tenant two"); err != nil {
+		t.Fatal(err)
+	}
+	second, err = manager.Engine("tenant one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Fatal("The least recently used engine has not been evicted")
+	}
+	after, err = second.nextNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Fatal("A nonce has been reused after the engine has been evicted")
+	}
+}
+
+func TestEngineManagerConcurrency(t *testing.T) {
+
+	manager := NewEngineManager(Config{KeyStore: NewMemoryKeyStore()}, 0)
+
+	engines := make([]*CryptoEngine, 16)
+	var wg sync.WaitGroup
+	for i := range engines {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			engine, err := manager.Engine("concurrent tenant")
+			if err != nil {
+				t.Error(err)
+			}
+			engines[i] = engine
+		}(i)
+	}
+	wg.Wait()
+
+	for _, engine := range engines {
+		if engine != engines[0] {
+			t.Fatal("The engine has been initialized more than once")
+		}
+	}
+}
+
+// a key store which is not reachable
+type failingKeyStore struct {
+	*MemoryKeyStore
+}
+
+var errKeyStoreUnavailable = errors.New("key store unavailable")
+
+func (store failingKeyStore) ReadKey(name string) ([]byte, error) {
+	return nil, errKeyStoreUnavailable
+}
+
+func TestEngineManagerFailure(t *testing.T) {
+
+	manager := NewEngineManager(Config{KeyStore: failingKeyStore{NewMemoryKeyStore()}}, 0)
+
+	if _, err := manager.Engine("tenant"); err != errKeyStoreUnavailable {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", errKeyStoreUnavailable, err)
+	}
+	if manager.Len() != 0 {
+		t.Fatal("The failed initialization has been cached")
+	}
+}
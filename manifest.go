@@ -0,0 +1,162 @@
+package cryptoengine
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Key files tamper detection: when the Config has a ManifestKey, a machine or master secret kept outside the keys folder,
+// for instance in a KMS or in the environment, the engine stores an HMAC-SHA-256 of all its key files, the manifest.
+// InitCryptoEngine verifies it after loading the keys, so a key file modified or substituted by an attacker is detected
+// instead of being loaded silently. The manifest is written only when the engine generates the keys itself:
+// key files without a manifest are rejected, otherwise an attacker would delete the manifest along with the substitution.
+// For the keys which existed before the ManifestKey was configured, and after a legitimate rotation of the key files,
+// the operator or the rotating tool calls UpdateManifest.
+
+const (
+	manifestSuffixFormat = "%s_manifest.key" // the MAC of the key files, for instance: sec51_manifest.key
+	manifestLabel        = "cryptoengine key manifest"
+	minManifestKeySize   = 16
+)
+
+var (
+	KeyTamperedError = errors.New("The key files have been modified: they do not match the manifest")
+	ManifestKeyError = errors.New("The manifest key must be at least 16 bytes")
+
+	// the key files covered by the manifest, in order
+	manifestKeyFormats = []string{
+		saltSuffixFormat,
+		publicKeySuffixFormat,
+		privateSuffixFormat,
+		secretSuffixFormat,
+		nonceSuffixFormat,
+		signingPublicSuffixFormat,
+		signingPrivateSuffixFormat,
+	}
+)
+
+// This function computes the manifest of the current key files of the communication identifier and stores it,
+// replacing the previous one. Call it after rotating the key files on purpose.
+func UpdateManifest(store KeyStore, communicationIdentifier string, manifestKey []byte) error {
+	context, err := keyContext(communicationIdentifier)
+	if err != nil {
+		return err
+	}
+	return updateManifest(store, context, manifestKey)
+}
+
+// stores the manifest of the key files of the sanitized identifier
+func updateManifest(store KeyStore, context string, manifestKey []byte) error {
+	manifest, err := keyManifest(store, context, manifestKey)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf(manifestSuffixFormat, context)
+	if err := store.DeleteKey(name); err != nil {
+		return err
+	}
+	return store.WriteKey(name, manifest)
+}
+
+// stores the manifest of imported keys, when the config has a ManifestKey. The import writes only some of the key files:
+// the other ones are generated first, like InitCryptoEngine does, so the manifest covers all of them.
+func updateImportManifest(store KeyStore, context string, config Config) error {
+	if config.ManifestKey == nil {
+		return nil
+	}
+	if _, err := loadSalt(store, context); err != nil {
+		return err
+	}
+	if _, _, err := loadKeyPairs(store, context); err != nil {
+		return err
+	}
+	if _, err := loadSecretKey(store, context); err != nil {
+		return err
+	}
+	if _, err := loadNonceKey(store, context); err != nil {
+		return err
+	}
+	if _, err := loadSigningKey(store, context); err != nil {
+		return err
+	}
+	return updateManifest(store, context, config.ManifestKey)
+}
+
+// verifies the key files match the manifest, the manifest is stored only when the engine generated the keys
+func verifyManifest(store KeyStore, context string, manifestKey []byte, generated bool) error {
+	manifest, err := keyManifest(store, context, manifestKey)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf(manifestSuffixFormat, context)
+	stored, err := store.ReadKey(name)
+	if err == KeyNotFoundError {
+		// the keys were already there: the manifest has been deleted
+		if !generated {
+			return KeyTamperedError
+		}
+		// another instance sharing the store wrote it first: verify against its manifest
+		if err = store.WriteKey(name, manifest); err != os.ErrExist {
+			return err
+		}
+		stored, err = store.ReadKey(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(stored, manifest) {
+		return KeyTamperedError
+	}
+	return nil
+}
+
+// whether none of the key files covered by the manifest exists yet, so the engine generates all of them
+func manifestKeysMissing(store KeyStore, context string) (bool, error) {
+	for _, format := range manifestKeyFormats {
+		_, err := store.ReadKey(fmt.Sprintf(format, context))
+		if err == nil {
+			return false, nil
+		}
+		if err != KeyNotFoundError {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// the MAC of the context and of the name, the length and the content of each key file
+func keyManifest(store KeyStore, context string, manifestKey []byte) ([]byte, error) {
+	if len(manifestKey) < minManifestKeySize {
+		return nil, ManifestKeyError
+	}
+
+	mac := hmac.New(sha256.New, manifestKey)
+	mac.Write([]byte(manifestLabel))
+	writeManifestField(mac, []byte(context))
+	for _, format := range manifestKeyFormats {
+		name := fmt.Sprintf(format, context)
+		data, err := store.ReadKey(name)
+		if err != nil {
+			return nil, err
+		}
+		writeManifestField(mac, []byte(name))
+		writeManifestField(mac, data)
+	}
+	return mac.Sum(nil), nil
+}
+
+// writes the field prefixed with its length, so the fields cannot be shifted into each other
+func writeManifestField(mac io.Writer, field []byte) {
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(len(field)))
+	mac.Write(length[:])
+	mac.Write(field)
+}
@@ -0,0 +1,89 @@
+package cryptoengine
+
+import (
+	"testing"
+)
+
+func TestKeyManifest(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	manifestKey := []byte("machine secret of the host")
+	config := Config{KeyStore: store, ManifestKey: manifestKey}
+
+	// the manifest is written the first time
+	engine, err := InitCryptoEngineWithConfig("Sec51Manifest", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadKey("sec51manifest_manifest.key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InitCryptoEngineWithConfig("Sec51Manifest", config); err != nil {
+		t.Fatal(err)
+	}
+
+	// an attacker substitutes the secret key
+	secretKey, err := generateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.DeleteKey("sec51manifest_secret.key")
+	store.WriteKey("sec51manifest_secret.key", secretKey[:])
+	if _, err := InitCryptoEngineWithConfig("Sec51Manifest", config); err != KeyTamperedError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyTamperedError, err)
+	}
+
+	// the manifest cannot be recomputed without the manifest key
+	if err := UpdateManifest(store, "Sec51Manifest", []byte("another machine secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InitCryptoEngineWithConfig("Sec51Manifest", config); err != KeyTamperedError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyTamperedError, err)
+	}
+
+	// a legitimate rotation updates the manifest
+	if err := UpdateManifest(store, "Sec51Manifest", manifestKey); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := InitCryptoEngineWithConfig("Sec51Manifest", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.secretKey != secretKey || rotated.Fingerprint() != engine.Fingerprint() {
+		t.Fatal("The rotated keys have not been loaded")
+	}
+
+	// an attacker substitutes the secret key and deletes the manifest
+	substitute, err := generateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.DeleteKey("sec51manifest_secret.key")
+	store.WriteKey("sec51manifest_secret.key", substitute[:])
+	store.DeleteKey("sec51manifest_manifest.key")
+	if _, err := InitCryptoEngineWithConfig("Sec51Manifest", config); err != KeyTamperedError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyTamperedError, err)
+	}
+	if _, err := store.ReadKey("sec51manifest_manifest.key"); err != KeyNotFoundError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyNotFoundError, err)
+	}
+
+	// the keys which existed before the manifest key was configured need an explicit update
+	if _, err := InitCryptoEngineWithConfig("Sec51Existing", Config{KeyStore: store}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InitCryptoEngineWithConfig("Sec51Existing", config); err != KeyTamperedError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyTamperedError, err)
+	}
+	if err := UpdateManifest(store, "Sec51Existing", manifestKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InitCryptoEngineWithConfig("Sec51Existing", config); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := InitCryptoEngineWithConfig("Sec51Manifest", Config{KeyStore: store, ManifestKey: []byte("short")}); err != ManifestKeyError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ManifestKeyError, err)
+	}
+
+}
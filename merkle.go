@@ -0,0 +1,205 @@
+package cryptoengine
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Batch integrity with a Merkle tree: the sender builds the tree over a batch of encrypted messages and signs its root,
+// and each receiver verifies its own message with an inclusion proof against the signed root, without the rest of the batch.
+// The leaves and the inner nodes are hashed in distinct contexts, so a leaf cannot be passed off as a node.
+// On a level with an odd amount of nodes, the last one is promoted to the next level unchanged.
+// The signature covers the size of the batch and the root.
+// Proof format:
+// |size|   => 8 bytes (little endian amount of leaves)
+// |index|  => 8 bytes (little endian index of the leaf)
+// |hashes| => 32 bytes each (the siblings of the path, from the leaf to the root)
+
+const (
+	merkleLeafLabel      = "cryptoengine merkle leaf"
+	merkleNodeLabel      = "cryptoengine merkle node"
+	merkleSignatureLabel = "cryptoengine merkle root"
+	merkleProofPrefix    = 8 + 8
+)
+
+var (
+	MerkleEmptyError = errors.New("The Merkle tree needs at least one leaf")
+	MerkleIndexError = errors.New("The leaf index is out of the Merkle tree")
+	MerkleProofError = errors.New("The Merkle proof is not valid")
+)
+
+// The MerkleTree holds the hashes of all the levels, from the leaves to the root
+type MerkleTree struct {
+	levels [][][HashSize]byte
+}
+
+// The MerkleProof holds the path from a leaf to the root
+type MerkleProof struct {
+	Size   int              // the amount of leaves of the tree
+	Index  int              // the index of the leaf
+	Hashes [][HashSize]byte // the siblings of the path, from the leaf to the root
+}
+
+// This function builds the Merkle tree of the data, for instance serialized encrypted messages
+func NewMerkleTree(leaves [][]byte) (*MerkleTree, error) {
+	if len(leaves) == 0 {
+		return nil, MerkleEmptyError
+	}
+
+	level := make([][HashSize]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = HashWithContext(merkleLeafLabel, leaf)
+	}
+
+	tree := &MerkleTree{levels: [][][HashSize]byte{level}}
+	for len(level) > 1 {
+		next := make([][HashSize]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, merkleNode(level[i], level[i+1]))
+			}
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+	return tree, nil
+}
+
+// This function builds the Merkle tree of a batch of encrypted messages, for instance returned by EncryptBatch
+func NewMerkleTreeFromMessages(msgs []EncryptedMessage) (*MerkleTree, error) {
+	leaves := make([][]byte, len(msgs))
+	for i, m := range msgs {
+		data, err := m.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = data
+	}
+	return NewMerkleTree(leaves)
+}
+
+// This method returns the root hash of the tree
+func (tree *MerkleTree) Root() [HashSize]byte {
+	return tree.levels[len(tree.levels)-1][0]
+}
+
+// This method returns the amount of leaves of the tree
+func (tree *MerkleTree) Size() int {
+	return len(tree.levels[0])
+}
+
+// This method returns the inclusion proof of the leaf at the index
+func (tree *MerkleTree) Proof(index int) (MerkleProof, error) {
+	if index < 0 || index >= tree.Size() {
+		return MerkleProof{}, MerkleIndexError
+	}
+
+	proof := MerkleProof{Size: tree.Size(), Index: index}
+	for _, level := range tree.levels[:len(tree.levels)-1] {
+		// the promoted node has no sibling
+		if sibling := index ^ 1; sibling < len(level) {
+			proof.Hashes = append(proof.Hashes, level[sibling])
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// This method checks the leaf is part of the tree with the root
+func (proof MerkleProof) Verify(root [HashSize]byte, leaf []byte) error {
+	if proof.Size <= 0 || proof.Index < 0 || proof.Index >= proof.Size {
+		return MerkleProofError
+	}
+
+	hash := HashWithContext(merkleLeafLabel, leaf)
+	hashes := proof.Hashes
+	for index, size := proof.Index, proof.Size; size > 1; index, size = index/2, (size+1)/2 {
+		if index^1 >= size {
+			continue
+		}
+		if len(hashes) == 0 {
+			return MerkleProofError
+		}
+		if index%2 == 0 {
+			hash = merkleNode(hash, hashes[0])
+		} else {
+			hash = merkleNode(hashes[0], hash)
+		}
+		hashes = hashes[1:]
+	}
+
+	if len(hashes) != 0 || !ConstantTimeEqual(hash[:], root[:]) {
+		return MerkleProofError
+	}
+	return nil
+}
+
+// This method serializes the proof, to send it along with the leaf
+func (proof MerkleProof) Bytes() []byte {
+	data := make([]byte, merkleProofPrefix, merkleProofPrefix+len(proof.Hashes)*HashSize)
+	binary.LittleEndian.PutUint64(data, uint64(proof.Size))
+	binary.LittleEndian.PutUint64(data[8:], uint64(proof.Index))
+	for _, hash := range proof.Hashes {
+		data = append(data, hash[:]...)
+	}
+	return data
+}
+
+// This function parses a proof serialized with Bytes
+func ParseMerkleProof(data []byte) (MerkleProof, error) {
+	if len(data) < merkleProofPrefix || (len(data)-merkleProofPrefix)%HashSize != 0 {
+		return MerkleProof{}, MerkleProofError
+	}
+
+	size, index := binary.LittleEndian.Uint64(data), binary.LittleEndian.Uint64(data[8:])
+	if size == 0 || size > math.MaxInt32 || index >= size {
+		return MerkleProof{}, MerkleProofError
+	}
+
+	proof := MerkleProof{Size: int(size), Index: int(index)}
+	for data = data[merkleProofPrefix:]; len(data) > 0; data = data[HashSize:] {
+		var hash [HashSize]byte
+		copy(hash[:], data)
+		proof.Hashes = append(proof.Hashes, hash)
+	}
+	return proof, nil
+}
+
+// This method signs the size and the root of the tree with the engine signing key
+func (engine *CryptoEngine) SignMerkleTree(tree *MerkleTree) ([]byte, error) {
+	if err := engine.allow(operationOwnKeys); err != nil {
+		return nil, err
+	}
+	return engine.Sign(merkleSignedData(tree.Size(), tree.Root())), nil
+}
+
+// This method verifies the signature of the root by the peer, then the inclusion of the leaf with the proof
+func (e VerificationEngine) VerifyMerkleProof(root [HashSize]byte, signature []byte, proof MerkleProof, leaf []byte) error {
+	if len(signature) != ed25519.SignatureSize {
+		return SignatureError
+	}
+	if err := e.Verify(merkleSignedData(proof.Size, root), signature); err != nil {
+		return err
+	}
+	return proof.Verify(root, leaf)
+}
+
+func merkleNode(left, right [HashSize]byte) [HashSize]byte {
+	data := make([]byte, 0, 2*HashSize)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return HashWithContext(merkleNodeLabel, data)
+}
+
+func merkleSignedData(size int, root [HashSize]byte) []byte {
+	data := make([]byte, 0, len(merkleSignatureLabel)+8+HashSize)
+	data = append(data, merkleSignatureLabel...)
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(size))
+	data = append(data, length[:]...)
+	return append(data, root[:]...)
+}
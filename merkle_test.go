@@ -0,0 +1,116 @@
+package cryptoengine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMerkleTree(t *testing.T) {
+
+	for size := 1; size <= 9; size++ {
+		leaves := make([][]byte, size)
+		for i := range leaves {
+			leaves[i] = []byte(fmt.Sprintf("leaf %d", i))
+		}
+
+		tree, err := NewMerkleTree(leaves)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.Size() != size {
+			t.Fatalf("Expected %d leaves, got %d\n", size, tree.Size())
+		}
+
+		for i, leaf := range leaves {
+			proof, err := tree.Proof(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParseMerkleProof(proof.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := parsed.Verify(tree.Root(), leaf); err != nil {
+				t.Fatalf("The proof of the leaf %d of %d is not valid: %v\n", i, size, err)
+			}
+
+			// the proof does not hold for another leaf
+			if err := proof.Verify(tree.Root(), []byte("forged")); err != MerkleProofError {
+				t.Fatalf("Expected %v, got %v\n", MerkleProofError, err)
+			}
+			if size > 1 {
+				proof.Index = (i + 1) % size
+				if err := proof.Verify(tree.Root(), leaf); err != MerkleProofError {
+					t.Fatalf("Expected %v, got %v\n", MerkleProofError, err)
+				}
+			}
+		}
+	}
+
+	if _, err := NewMerkleTree(nil); err != MerkleEmptyError {
+		t.Fatalf("Expected %v, got %v\n", MerkleEmptyError, err)
+	}
+	tree, err := NewMerkleTree([][]byte{[]byte("leaf")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Proof(1); err != MerkleIndexError {
+		t.Fatalf("Expected %v, got %v\n", MerkleIndexError, err)
+	}
+	if _, err := ParseMerkleProof(make([]byte, 17)); err != MerkleProofError {
+		t.Fatalf("Expected %v, got %v\n", MerkleProofError, err)
+	}
+
+}
+
+func TestSignedMerkleTree(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Merkle", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verificationEngine, err := NewVerificationEngineWithKeys(engine.PublicKey(), engine.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := make([]Message, 5)
+	for i := range msgs {
+		if msgs[i], err = NewMessage(fmt.Sprintf("record %d", i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch, err := engine.EncryptBatch(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := NewMerkleTreeFromMessages(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := engine.SignMerkleTree(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the receiver of the third message verifies it alone
+	data, err := batch[2].ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := tree.Proof(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verificationEngine.VerifyMerkleProof(tree.Root(), signature, proof, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the size of the batch is signed
+	proof.Size = 4
+	if err := verificationEngine.VerifyMerkleProof(tree.Root(), signature, proof, data); err != SignatureError {
+		t.Fatalf("Expected %v, got %v\n", SignatureError, err)
+	}
+
+}
@@ -3,17 +3,18 @@ package cryptoengine
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"github.com/sec51/convert/smallendian"
-	"math"
+	"golang.org/x/crypto/nacl/secretbox"
 )
 
-// This struct encapsulate the ecnrypted message in a TCP packet, in an easily parseable format
-// We assume the data is always encrypted
+// The Message is the plaintext envelope which is encrypted: the application payload with its type and the envelope version.
+// The version and the type survive the encryption round-trip, so the receiver can tell the payload formats apart.
 // Format:
-// |version| => 8 bytes (uint64 total message length)
-// |type| 	 => 4 bytes (int message version)
+// |version| => 4 bytes (int message version)
+// |type| 	 => 4 bytes (int message type)
 // |message| => N bytes ([]byte message)
-type message struct {
+type Message struct {
 	Version int    // version of the message, done to support backward compatibility
 	Type    int    // message type - this can be ised on the receiver part to process different types
 	Text    string // the encrypted message
@@ -32,8 +33,8 @@ type EncryptedMessage struct {
 // Create a new message with a clear text and the message type
 // messageType: is an identifier to distinguish the messages on the receiver and parse them
 // for example if zero is a JSON message and 1 is XML, then the received can parse different formats with different methods
-func NewMessage(clearText string, messageType int) (message, error) {
-	m := message{}
+func NewMessage(clearText string, messageType int) (Message, error) {
+	m := Message{}
 	if clearText == "" {
 		return m, errors.New("Clear text cannot be empty")
 	}
@@ -44,101 +45,126 @@ func NewMessage(clearText string, messageType int) (message, error) {
 	return m, nil
 }
 
-func (m message) toBytes() []byte {
-	var buffer bytes.Buffer
+func (m Message) toBytes() []byte {
+	return m.appendBytes(make([]byte, 0, 8+len(m.Text)))
+}
+
+// appends the serialized message to dst and returns the extended slice
+func (m Message) appendBytes(dst []byte) []byte {
 
 	// version
 	versionBytes := smallendian.ToInt(m.Version)
-	buffer.Write(versionBytes[:])
+	dst = append(dst, versionBytes[:]...)
 
 	// type
 	typeBytes := smallendian.ToInt(m.Type)
-	buffer.Write(typeBytes[:])
+	dst = append(dst, typeBytes[:]...)
 
 	// message
-	buffer.WriteString(m.Text)
+	return append(dst, m.Text...)
+}
+
+// The ParseError describes why the data could not be parsed into a message.
+// It wraps MessageParsingError, MessageLengthError, MessageTooLargeError or MessageVersionError, test it with errors.Is
+type ParseError struct {
+	Field  string // the field which is not valid: data, length, message or version
+	Offset int    // the offset of the field in the data
+	Reason string // what is wrong with the field
+	Err    error  // the generic error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v (%s at offset %d: %s)", e.Err, e.Field, e.Offset, e.Reason)
+}
 
-	return buffer.Bytes()
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Parse the bytes coming from the network into an EncryptedMessage, using the strict mode.
+// The length field must match the size of the data and the data cannot exceed the maximum message size.
+func MessageFromBytes(data []byte) (EncryptedMessage, error) {
+	return encryptedMessageFromBytes(data, ParseOptions{})
+}
+
+// Parse the bytes coming from the network into an EncryptedMessage, with the given options.
+// Set options.Legacy to true to accept messages from older senders, which are not strictly validated.
+func ParseMessage(data []byte, options ParseOptions) (EncryptedMessage, error) {
+	return encryptedMessageFromBytes(data, options)
 }
 
 // Parse the bytes coming from the network and extract
 // |length| => 8
 // |nonce|	=> nonce size
 // |message| => message
-func encryptedMessageFromBytes(data []byte) (EncryptedMessage, error) {
+// The maximum message size is always enforced, the length consistency only in strict mode.
+// The fields are validated against the size of the data: nothing is allocated and the data is not copied.
+func encryptedMessageFromBytes(data []byte, options ParseOptions) (EncryptedMessage, error) {
 
-	var err error
 	var lengthData [8]byte
-	var nonceData [nonceSize]byte
 	minimumDataSize := 8 + nonceSize
 	m := EncryptedMessage{}
 
-	// check if the data is smaller than 36 which is the minimum
-	if data == nil {
-		return m, MessageParsingError
-	}
-
+	// check if the data is smaller than 33 which is the minimum
 	if len(data) < minimumDataSize+1 {
-		return m, MessageParsingError
+		return m, &ParseError{Field: "data", Reason: fmt.Sprintf("%d bytes, the minimum is %d", len(data), minimumDataSize+1), Err: MessageParsingError}
 	}
 
-	lenght := data[:8]
-	nonce := data[8 : 8+nonceSize] // 24 bytes
-	message := data[minimumDataSize:]
-
-	total := copy(lengthData[:], lenght)
-	if total != 8 {
-		return m, MessageParsingError
+	if uint64(len(data)) > options.maxSize() {
+		return m, &ParseError{Field: "data", Reason: fmt.Sprintf("%d bytes, the maximum is %d", len(data), options.maxSize()), Err: MessageTooLargeError}
 	}
 
-	total = copy(nonceData[:], nonce)
-	if total != nonceSize {
-		return m, MessageParsingError
+	copy(lengthData[:], data[:8])
+	m.length = smallendian.FromUint64(lengthData)
+
+	// the length field must match the amount of bytes received
+	if !options.Legacy && m.length != uint64(len(data)) {
+		return m, &ParseError{Field: "length", Reason: fmt.Sprintf("%d, the data is %d bytes", m.length, len(data)), Err: MessageLengthError}
 	}
 
-	m.length = smallendian.FromUint64(lengthData)
-	m.nonce = nonceData
-	m.data = message
-	return m, err
+	copy(m.nonce[:], data[8:minimumDataSize])
+	m.data = data[minimumDataSize:]
+	return m, nil
 
 }
 
 // This function separates the associated data once decrypted
-func messageFromBytes(data []byte) (*message, error) {
+// In strict mode only the versions listed in supportedVersions are accepted
+func messageFromBytes(data []byte, options ParseOptions) (*Message, error) {
 
-	var err error
 	var versionData [4]byte
 	var typeData [4]byte
 	minimumDataSize := 4 + 4
-	m := new(message)
-
-	// check if the data is smaller than 36 which is the minimum
-	if data == nil {
-		return nil, MessageParsingError
-	}
+	m := new(Message)
 
+	// check if the data is smaller than 9 which is the minimum
 	if len(data) < minimumDataSize+1 {
-		return nil, MessageParsingError
+		return nil, &ParseError{Field: "message", Reason: fmt.Sprintf("%d bytes, the minimum is %d", len(data), minimumDataSize+1), Err: MessageParsingError}
 	}
 
-	version := data[:4]
-	typeMsg := data[4:8]
-	message := data[8:]
-
-	total := copy(versionData[:], version)
-	if total != 4 {
-		return nil, MessageParsingError
-	}
+	copy(versionData[:], data[:4])
+	copy(typeData[:], data[4:8])
 
-	total = copy(typeData[:], typeMsg)
-	if total != 4 {
-		return nil, MessageParsingError
+	m.Version = smallendian.FromInt(versionData)
+	if !options.Legacy && !supportedVersions[m.Version] {
+		return nil, &ParseError{Field: "version", Reason: fmt.Sprintf("version %d is not supported", m.Version), Err: MessageVersionError}
 	}
 
-	m.Version = smallendian.FromInt(versionData)
 	m.Type = smallendian.FromInt(typeData)
-	m.Text = string(message)
-	return m, err
+	m.Text = string(data[8:])
+	return m, nil
+}
+
+// returns the size of the serialized encrypted message, given the size of the encrypted data
+// |length| + |nonce| + |data|
+func encryptedMessageLength(dataSize int) uint64 {
+	return uint64(8 + nonceSize + dataSize)
+}
+
+// returns the size of the serialized encrypted message, given the size of the clear text bytes
+// |length| + |nonce| + |secretbox overhead| + |data|
+func encryptedMessageSize(clearTextSize int) uint64 {
+	return encryptedMessageLength(secretbox.Overhead + clearTextSize)
 }
 
 // STRUCTURE
@@ -148,15 +174,13 @@ func messageFromBytes(data []byte) (*message, error) {
 // |size| => 8 bytes (uint64 total message length)
 // |type| 	 => 4 bytes (int message version)
 // |message| => N bytes ([]byte message)
+// The length is computed from the data: a message parsed in legacy mode with a wrong length field is written with the right one.
 func (m EncryptedMessage) ToBytes() ([]byte, error) {
-	if m.length > math.MaxUint64 {
-		return nil, errors.New("The message exceeds the maximum allowed sized: uint64 MAX")
-	}
-
+	// the maximum size is enforced by the engine at encryption time
 	var buffer bytes.Buffer
 
 	// length
-	lengthBytes := smallendian.ToUint64(m.length)
+	lengthBytes := smallendian.ToUint64(encryptedMessageLength(len(m.data)))
 	buffer.Write(lengthBytes[:])
 
 	// nonce
@@ -0,0 +1,204 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"errors"
+	"github.com/sec51/convert/smallendian"
+	"testing"
+)
+
+func TestStrictMessageParsing(t *testing.T) {
+
+	message, err := NewMessage("The quick brown fox jumps over the lazy dog", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedMessage, err := engine.NewEncryptedMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messageBytes, err := encryptedMessage.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a well formed message is parsed
+	if _, err := MessageFromBytes(messageBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	// forge the length field
+	forgedLength := smallendian.ToUint64(uint64(len(messageBytes) + 1))
+	forged := make([]byte, len(messageBytes))
+	copy(forged, messageBytes)
+	copy(forged[:8], forgedLength[:])
+
+	if _, err := MessageFromBytes(forged); !errors.Is(err, MessageLengthError) {
+		t.Errorf("The expected error is: MessageLengthError, instead we've got: %v\n", err)
+	}
+
+	if _, err := engine.Decrypt(forged); !errors.Is(err, MessageLengthError) {
+		t.Errorf("The expected error is: MessageLengthError, instead we've got: %v\n", err)
+	}
+
+	// the legacy mode accepts it, and writes it again with the right length
+	legacy, err := ParseMessage(forged, ParseOptions{Legacy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyBytes, err := legacy.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(legacyBytes, messageBytes) {
+		t.Fatal("The legacy message has not been written with the right length")
+	}
+
+	// messages bigger than the maximum size are always rejected
+	huge := make([]byte, maxMessageSize+1)
+	if _, err := ParseMessage(huge, ParseOptions{Legacy: true}); !errors.Is(err, MessageTooLargeError) {
+		t.Errorf("The expected error is: MessageTooLargeError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestMessageVersionWhitelist(t *testing.T) {
+
+	message, err := NewMessage("The quick brown fox jumps over the lazy dog", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.Version = tcpVersion + 1
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacyEngine, err := InitCryptoEngineWithConfig("Sec51", Config{LegacyParsing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedMessage, err := engine.NewEncryptedMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messageBytes, err := encryptedMessage.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.Decrypt(messageBytes); !errors.Is(err, MessageVersionError) {
+		t.Errorf("The expected error is: MessageVersionError, instead we've got: %v\n", err)
+	}
+
+	decrypted, err := legacyEngine.Decrypt(messageBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decrypted.Version != message.Version {
+		t.Error("The legacy mode should preserve the message version")
+	}
+
+}
+
+func TestMaxMessageSize(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51", Config{MaxMessageSize: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verificationEngine, err := NewVerificationEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8 bytes of version and type + 8 bytes of length + the nonce + the overhead + the text == 128
+	small, err := NewMessage(string(make([]byte, 128-16-nonceSize-16)), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	big, err := NewMessage(string(make([]byte, 128-16-nonceSize-16+1)), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedMessage, err := engine.NewEncryptedMessage(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.NewEncryptedMessage(big); err != MessageTooLargeError {
+		t.Errorf("The expected error is: MessageTooLargeError, instead we've got: %v\n", err)
+	}
+
+	if _, err := engine.NewEncryptedMessageWithPubKey(big, verificationEngine); err != MessageTooLargeError {
+		t.Errorf("The expected error is: MessageTooLargeError, instead we've got: %v\n", err)
+	}
+
+	messageBytes, err := encryptedMessage.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.Decrypt(messageBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	// an engine with a smaller limit refuses to parse it
+	smallEngine, err := InitCryptoEngineWithConfig("Sec51", Config{MaxMessageSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := smallEngine.Decrypt(messageBytes); !errors.Is(err, MessageTooLargeError) {
+		t.Errorf("The expected error is: MessageTooLargeError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestMessageEnvelope(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the applications can build and pass around the envelope
+	envelopes := []Message{
+		{Version: tcpVersion, Type: 0, Text: "{\"json\": true}"},
+		{Version: tcpVersion, Type: 1, Text: "<xml/>"},
+	}
+
+	for _, envelope := range envelopes {
+		encrypted, err := engine.NewEncryptedMessage(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := encrypted.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := engine.Decrypt(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *decrypted != envelope {
+			t.Errorf("The expected envelope is: %+v, instead we've got: %+v\n", envelope, *decrypted)
+		}
+	}
+
+}
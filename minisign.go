@@ -0,0 +1,201 @@
+package cryptoengine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Signatures compatible with minisign and signify (https://jedisct1.github.io/minisign/).
+// Files signed with SignMinisign can be verified with:
+// minisign -Vm <file> -P <MinisignPublicKey().Base64()>
+// and files signed by minisign can be verified with MinisignPublicKey.Verify
+
+const (
+	minisignKeyIdSize           = 8
+	minisignUntrustedPrefix     = "untrusted comment: "
+	minisignTrustedPrefix       = "trusted comment: "
+	minisignUntrustedComment    = "signature from cryptoengine secret key"
+	minisignMaxTrustedComment   = 1024
+	minisignLegacyAlgorithm     = "Ed" // the file is signed as is
+	minisignPrehashedAlgorithm  = "ED" // the BLAKE2b-512 hash of the file is signed
+	minisignPublicKeyAlgorithm  = "Ed"
+	minisignPublicKeyComment    = "minisign public key %s"
+	minisignPublicKeyDecodedLen = 2 + minisignKeyIdSize + ed25519.PublicKeySize
+	minisignSignatureDecodedLen = 2 + minisignKeyIdSize + ed25519.SignatureSize
+)
+
+var (
+	MinisignFormatError = errors.New("The minisign data is not valid")
+	MinisignKeyIdError  = errors.New("The minisign signature was created with a different key")
+)
+
+// The MinisignPublicKey holds an Ed25519 public key together with its minisign key id
+type MinisignPublicKey struct {
+	keyId     [minisignKeyIdSize]byte
+	publicKey [ed25519.PublicKeySize]byte
+}
+
+// Returns the engine signing public key in minisign format.
+// The key id is derived from the public key itself, so it's stable across restarts.
+func (engine *CryptoEngine) MinisignPublicKey() MinisignPublicKey {
+	key := MinisignPublicKey{}
+	copy(key.publicKey[:], engine.SigningPublicKey())
+	hash := blake2b.Sum256(key.publicKey[:])
+	copy(key.keyId[:], hash[:minisignKeyIdSize])
+	return key
+}
+
+// Parses a minisign public key: either the whole content of a minisign.pub file or only the base64 line
+func ParseMinisignPublicKey(s string) (MinisignPublicKey, error) {
+	key := MinisignPublicKey{}
+
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	encoded := strings.TrimSpace(lines[len(lines)-1])
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(decoded) != minisignPublicKeyDecodedLen || string(decoded[:2]) != minisignPublicKeyAlgorithm {
+		return key, MinisignFormatError
+	}
+
+	copy(key.keyId[:], decoded[2:2+minisignKeyIdSize])
+	copy(key.publicKey[:], decoded[2+minisignKeyIdSize:])
+	return key, nil
+}
+
+// Returns the key id as printed by minisign: the hexadecimal little endian number
+func (key MinisignPublicKey) KeyId() string {
+	var reversed [minisignKeyIdSize]byte
+	for i := range key.keyId {
+		reversed[i] = key.keyId[minisignKeyIdSize-1-i]
+	}
+	return fmt.Sprintf("%X", reversed[:])
+}
+
+// Returns the base64 encoded public key, as accepted by minisign -P
+func (key MinisignPublicKey) Base64() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(minisignPublicKeyAlgorithm)
+	buffer.Write(key.keyId[:])
+	buffer.Write(key.publicKey[:])
+	return base64.StdEncoding.EncodeToString(buffer.Bytes())
+}
+
+// Returns the content of the minisign.pub file
+func (key MinisignPublicKey) String() string {
+	return minisignUntrustedPrefix + fmt.Sprintf(minisignPublicKeyComment, key.KeyId()) + "\n" + key.Base64() + "\n"
+}
+
+// Signs the content of the reader and returns the content of the .minisig file.
+// The signature uses the pre-hashed algorithm, the default of minisign since version 0.10.
+// When trustedComment is empty, the current timestamp is used, as minisign does.
+func (engine *CryptoEngine) SignMinisign(r io.Reader, trustedComment string) ([]byte, error) {
+
+	if trustedComment == "" {
+		trustedComment = fmt.Sprintf("timestamp:%d", time.Now().Unix())
+	}
+
+	if len(trustedComment) > minisignMaxTrustedComment || strings.ContainsAny(trustedComment, "\r\n") {
+		return nil, MinisignFormatError
+	}
+
+	// hash the content
+	hash, err := blake2b.New512(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(hash, r); err != nil {
+		return nil, err
+	}
+
+	key := engine.MinisignPublicKey()
+	signature := engine.Sign(hash.Sum(nil))
+
+	// the global signature covers the signature and the trusted comment
+	globalSignature := engine.Sign(append(append([]byte{}, signature...), trustedComment...))
+
+	var encodedSignature bytes.Buffer
+	encodedSignature.WriteString(minisignPrehashedAlgorithm)
+	encodedSignature.Write(key.keyId[:])
+	encodedSignature.Write(signature)
+
+	var buffer bytes.Buffer
+	buffer.WriteString(minisignUntrustedPrefix + minisignUntrustedComment + "\n")
+	buffer.WriteString(base64.StdEncoding.EncodeToString(encodedSignature.Bytes()) + "\n")
+	buffer.WriteString(minisignTrustedPrefix + trustedComment + "\n")
+	buffer.WriteString(base64.StdEncoding.EncodeToString(globalSignature) + "\n")
+
+	return buffer.Bytes(), nil
+}
+
+// Verifies the minisign signature of the content of the reader and returns the trusted comment.
+// Both the legacy and the pre-hashed signatures are supported.
+func (key MinisignPublicKey) Verify(r io.Reader, minisig []byte) (string, error) {
+
+	scanner := bufio.NewScanner(bytes.NewReader(minisig))
+	var lines []string
+	for scanner.Scan() && len(lines) < 4 {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], minisignUntrustedPrefix) || !strings.HasPrefix(lines[2], minisignTrustedPrefix) {
+		return "", MinisignFormatError
+	}
+
+	encodedSignature, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(encodedSignature) != minisignSignatureDecodedLen {
+		return "", MinisignFormatError
+	}
+
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSignature) != ed25519.SignatureSize {
+		return "", MinisignFormatError
+	}
+
+	algorithm := string(encodedSignature[:2])
+	keyId := encodedSignature[2 : 2+minisignKeyIdSize]
+	signature := encodedSignature[2+minisignKeyIdSize:]
+	trustedComment := lines[2][len(minisignTrustedPrefix):]
+
+	if !bytes.Equal(keyId, key.keyId[:]) {
+		return "", MinisignKeyIdError
+	}
+
+	// compute what has been signed
+	var signed []byte
+	switch algorithm {
+	case minisignLegacyAlgorithm:
+		if signed, err = ioutil.ReadAll(r); err != nil {
+			return "", err
+		}
+	case minisignPrehashedAlgorithm:
+		hash, err := blake2b.New512(nil)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(hash, r); err != nil {
+			return "", err
+		}
+		signed = hash.Sum(nil)
+	default:
+		return "", MinisignFormatError
+	}
+
+	publicKey := ed25519.PublicKey(key.publicKey[:])
+	if !ed25519.Verify(publicKey, signed, signature) {
+		return "", SignatureError
+	}
+
+	if !ed25519.Verify(publicKey, append(append([]byte{}, signature...), trustedComment...), globalSignature) {
+		return "", SignatureError
+	}
+
+	return trustedComment, nil
+}
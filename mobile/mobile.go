@@ -0,0 +1,174 @@
+// Package mobile wraps the cryptoengine for Android and iOS apps, generate the bindings with:
+//
+//	gomobile bind -target=android github.com/sec51/cryptoengine/mobile
+//	gomobile bind -target=ios github.com/sec51/cryptoengine/mobile
+//
+// gomobile only binds a subset of the Go types: the exported surface of this package uses []byte instead of arrays,
+// int instead of the unsigned integers, and functions return at most one value and an error, which becomes an exception in Java
+// and an NSError in Objective-C and Swift. The encrypted messages are serialized in the cryptoengine format,
+// so they can be exchanged with the Go services using the cryptoengine package.
+package mobile
+
+import (
+	"errors"
+	"github.com/sec51/cryptoengine"
+)
+
+var (
+	MessageError = errors.New("The message is not valid")
+	PeerError    = errors.New("The peer is not valid")
+)
+
+// The Engine wraps a cryptoengine.CryptoEngine
+type Engine struct {
+	engine *cryptoengine.CryptoEngine
+}
+
+// The Peer wraps the cryptoengine.VerificationEngine of a remote peer
+type Peer struct {
+	engine cryptoengine.VerificationEngine
+}
+
+// The Message is the clear text and the type of an encrypted message
+type Message struct {
+	Type int
+	Text string
+}
+
+// This function initializes the engine of the identifier, with the keys stored in the folder.
+// The folder must be private to the app, for instance Context.getFilesDir() on Android or the Application Support directory on iOS.
+func NewEngine(identifier, keysFolder string) (*Engine, error) {
+	store, err := cryptoengine.NewFileKeyStore(keysFolder)
+	if err != nil {
+		return nil, err
+	}
+	return newEngine(identifier, cryptoengine.Config{KeyStore: store})
+}
+
+// This function initializes an engine whose keys are kept in memory only, they are lost when the app exits
+func NewEphemeralEngine(identifier string) (*Engine, error) {
+	return newEngine(identifier, cryptoengine.Config{KeyStore: cryptoengine.NewMemoryKeyStore()})
+}
+
+func newEngine(identifier string, config cryptoengine.Config) (*Engine, error) {
+	engine, err := cryptoengine.InitCryptoEngineWithConfig(identifier, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{engine: engine}, nil
+}
+
+// This method returns the X25519 public key of the engine, to share with the peers
+func (e *Engine) PublicKey() []byte {
+	return e.engine.PublicKey()
+}
+
+// This method returns the Ed25519 signing public key of the engine, to share with the peers
+func (e *Engine) SigningPublicKey() []byte {
+	return e.engine.SigningPublicKey()
+}
+
+// This method returns the fingerprint of the engine public key, for the users to compare it out of band
+func (e *Engine) Fingerprint() string {
+	return e.engine.Fingerprint()
+}
+
+// This method signs the data with the engine signing key
+func (e *Engine) Sign(data []byte) []byte {
+	return e.engine.Sign(data)
+}
+
+// This method encrypts the message with the secret key of the engine and returns the serialized encrypted message
+func (e *Engine) Encrypt(message *Message) ([]byte, error) {
+	if message == nil {
+		return nil, MessageError
+	}
+	msg, err := cryptoengine.NewMessage(message.Text, message.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := e.engine.NewEncryptedMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	return encrypted.ToBytes()
+}
+
+// This method decrypts the serialized message encrypted with the secret key of the engine
+func (e *Engine) Decrypt(data []byte) (*Message, error) {
+	msg, err := e.engine.Decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Type: msg.Type, Text: msg.Text}, nil
+}
+
+// This method encrypts the message for the peer and returns the serialized encrypted message
+func (e *Engine) EncryptForPeer(message *Message, peer *Peer) ([]byte, error) {
+	if message == nil {
+		return nil, MessageError
+	}
+	if peer == nil {
+		return nil, PeerError
+	}
+	msg, err := cryptoengine.NewMessage(message.Text, message.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := e.engine.NewEncryptedMessageWithPubKey(msg, peer.engine)
+	if err != nil {
+		return nil, err
+	}
+	return encrypted.ToBytes()
+}
+
+// This method decrypts the serialized message the peer encrypted for the engine
+func (e *Engine) DecryptFromPeer(data []byte, peer *Peer) (*Message, error) {
+	if peer == nil {
+		return nil, PeerError
+	}
+	msg, err := e.engine.DecryptWithPublicKey(data, peer.engine)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Type: msg.Type, Text: msg.Text}, nil
+}
+
+// This function returns the peer with its X25519 public key and its Ed25519 signing public key.
+// The signing public key is optional: pass nil or an empty array if the signatures of the peer are not verified.
+func NewPeer(publicKey, signingPublicKey []byte) (*Peer, error) {
+	var engine cryptoengine.VerificationEngine
+	var err error
+	if len(signingPublicKey) == 0 {
+		engine, err = cryptoengine.NewVerificationEngineWithKey(publicKey)
+	} else {
+		engine, err = cryptoengine.NewVerificationEngineWithKeys(publicKey, signingPublicKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Peer{engine: engine}, nil
+}
+
+// This method returns the X25519 public key of the peer
+func (p *Peer) PublicKey() []byte {
+	publicKey := p.engine.PublicKey()
+	return publicKey[:]
+}
+
+// This method returns the fingerprint of the peer public key
+func (p *Peer) Fingerprint() string {
+	return p.engine.Fingerprint()
+}
+
+// This method verifies the signature of the data with the peer signing public key
+func (p *Peer) Verify(data, signature []byte) error {
+	return p.engine.Verify(data, signature)
+}
+
+// This function returns the message to encrypt
+func NewMessage(text string, messageType int) *Message {
+	return &Message{Type: messageType, Text: text}
+}
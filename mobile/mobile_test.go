@@ -0,0 +1,157 @@
+package mobile
+
+import (
+	"github.com/sec51/cryptoengine"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMobileEngine(t *testing.T) {
+
+	folder, err := ioutil.TempDir("", "cryptoengine-mobile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	alice, err := NewEngine("Sec51MobileAlice", folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys are loaded back from the folder
+	reloaded, err := NewEngine("Sec51MobileAlice", folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Fingerprint() != alice.Fingerprint() {
+		t.Fatal("The keys have not been loaded from the folder")
+	}
+
+	data, err := alice.Encrypt(NewMessage("mobile message", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := reloaded.Decrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Text != "mobile message" || message.Type != 3 {
+		t.Fatal("The decrypted message does not match the original one")
+	}
+
+	// the serialized message is in the cryptoengine format
+	if _, err := cryptoengine.MessageFromBytes(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := alice.Encrypt(nil); err != MessageError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageError, err)
+	}
+
+}
+
+func TestMobilePeers(t *testing.T) {
+
+	alice, err := NewEphemeralEngine("Sec51MobileAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := NewEphemeralEngine("Sec51MobileBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alicePeer, err := NewPeer(alice.PublicKey(), alice.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bobPeer, err := NewPeer(bob.PublicKey(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := alice.EncryptForPeer(NewMessage("to bob", 1), bobPeer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := bob.DecryptFromPeer(data, alicePeer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Text != "to bob" {
+		t.Fatal("The decrypted message does not match the original one")
+	}
+
+	if err := alicePeer.Verify([]byte("data"), alice.Sign([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := alicePeer.Verify([]byte("tampered"), alice.Sign([]byte("data"))); err != cryptoengine.SignatureError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", cryptoengine.SignatureError, err)
+	}
+
+	if _, err := alice.DecryptFromPeer(data, nil); err != PeerError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", PeerError, err)
+	}
+
+}
+
+// gomobile binds the signed integers, strings, booleans, byte slices, the pointers to the exported structs and the errors
+func TestMobileSurface(t *testing.T) {
+
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	supported := func(typ reflect.Type) bool {
+		switch typ.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64, reflect.String, reflect.Bool:
+			return true
+		case reflect.Slice:
+			return typ.Elem().Kind() == reflect.Uint8
+		case reflect.Ptr:
+			return typ.Elem().PkgPath() == reflect.TypeOf(Engine{}).PkgPath()
+		}
+		return typ == errorType
+	}
+
+	check := func(name string, function reflect.Type, first int) {
+		for i := first; i < function.NumIn(); i++ {
+			if !supported(function.In(i)) {
+				t.Errorf("%s: the parameter type %v cannot be bound\n", name, function.In(i))
+			}
+		}
+		if function.NumOut() > 2 || (function.NumOut() == 2 && function.Out(1) != errorType) {
+			t.Errorf("%s: only one value and an error can be returned\n", name)
+		}
+		for i := 0; i < function.NumOut(); i++ {
+			if !supported(function.Out(i)) {
+				t.Errorf("%s: the result type %v cannot be bound\n", name, function.Out(i))
+			}
+		}
+	}
+
+	for _, function := range []interface{}{NewEngine, NewEphemeralEngine, NewPeer, NewMessage} {
+		check(reflect.TypeOf(function).String(), reflect.TypeOf(function), 0)
+	}
+
+	for _, typ := range []reflect.Type{reflect.TypeOf(&Engine{}), reflect.TypeOf(&Peer{})} {
+		for i := 0; i < typ.NumMethod(); i++ {
+			method := typ.Method(i)
+			// the first parameter is the receiver
+			check(method.Name, method.Type, 1)
+		}
+	}
+
+	message := reflect.TypeOf(Message{})
+	for i := 0; i < message.NumField(); i++ {
+		if !supported(message.Field(i).Type) {
+			t.Errorf("Message.%s: the field type %v cannot be bound\n", message.Field(i).Name, message.Field(i).Type)
+		}
+	}
+
+}
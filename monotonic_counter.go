@@ -0,0 +1,163 @@
+package cryptoengine
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Rollback resistant nonce counters: the counter stores keep the next counter on disk, so restoring an old backup
+// or a disk snapshot makes the engine reuse the nonces it already derived. The MonotonicCounterStore reserves the counters
+// from epochs of a monotonic counter which cannot go back: a TPM NV counter (see the tpmcounter package) or another platform counter.
+// Each reservation which does not fit the current epoch of the context increments the monotonic counter, and the counters of
+// the epoch E are the range [E * 2^32, (E + 1) * 2^32). So the same counter is never reserved twice, even after a rollback,
+// and the monotonic counter, which supports a limited amount of writes, is incremented once per start and per 2^32 nonces.
+// Where no hardware counter is available, the FileMonotonicCounter keeps the counter in a file: it does not resist a disk rollback.
+
+const (
+	counterEpochBits = 32
+	counterEpochSize = 1 << counterEpochBits // amount of counters of an epoch
+	counterFileSize  = 8 + 4                 // the counter and its CRC-32C
+)
+
+var (
+	CounterEpochError     = errors.New("The amount of counters reserved at once must be at most 2^32")
+	MonotonicCounterError = errors.New("The monotonic counter went back")
+	CounterFileError      = errors.New("The monotonic counter file is corrupted")
+)
+
+// The MonotonicCounter interface is implemented by the counters which never go back, for instance a TPM NV counter
+type MonotonicCounter interface {
+	// increments the counter and returns its new value
+	Increment() (uint64, error)
+}
+
+// The MonotonicCounterStore is a CounterStore which reserves the counters from the epochs of a monotonic counter.
+// The counters are kept in memory: all the engines of the process must share the same store.
+type MonotonicCounterStore struct {
+	counter MonotonicCounter
+	mutex   sync.Mutex
+	last    uint64                   // the last value of the monotonic counter, to detect a counter going back
+	epochs  map[string]*counterEpoch // the current epoch of each context
+}
+
+// the counters of a context still available in its epoch
+type counterEpoch struct {
+	next uint64
+	end  uint64
+}
+
+// This function returns a counter store which reserves the counters from the epochs of the monotonic counter
+func NewMonotonicCounterStore(counter MonotonicCounter) *MonotonicCounterStore {
+	return &MonotonicCounterStore{counter: counter, epochs: make(map[string]*counterEpoch)}
+}
+
+// This method reserves n counters from the epoch of the context, starting a new epoch when needed
+func (store *MonotonicCounterStore) ReserveCounters(context string, n uint64) (uint64, error) {
+	if n > counterEpochSize {
+		return 0, CounterEpochError
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	epoch, ok := store.epochs[context]
+	if !ok || epoch.end-epoch.next < n {
+		value, err := store.counter.Increment()
+		if err != nil {
+			return 0, err
+		}
+		if value <= store.last {
+			return 0, MonotonicCounterError
+		}
+		store.last = value
+		if value >= math.MaxUint64>>counterEpochBits {
+			return 0, CounterOverflowError
+		}
+
+		epoch = &counterEpoch{next: value << counterEpochBits, end: (value + 1) << counterEpochBits}
+		store.epochs[context] = epoch
+	}
+
+	first := epoch.next
+	epoch.next += n
+	return first, nil
+}
+
+// The FileMonotonicCounter is a MonotonicCounter kept in a file, for the hosts without a hardware counter.
+// The file is replaced atomically and synced at each increment. Only one process must use the file at a time.
+// Restoring an old copy of the file makes the counter go back: prefer a hardware counter where it's available.
+type FileMonotonicCounter struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// This function returns a monotonic counter kept in the file, which is created by the first increment
+func NewFileMonotonicCounter(path string) *FileMonotonicCounter {
+	return &FileMonotonicCounter{path: path}
+}
+
+// This method increments the counter of the file
+func (counter *FileMonotonicCounter) Increment() (uint64, error) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	value, err := counter.read()
+	if err != nil {
+		return 0, err
+	}
+	if value == math.MaxUint64 {
+		return 0, CounterOverflowError
+	}
+	value++
+
+	if err := counter.write(value); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// reads the counter, zero when the file does not exist yet
+func (counter *FileMonotonicCounter) read() (uint64, error) {
+	data, err := readFile(counter.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if len(data) != counterFileSize || crc32.Checksum(data[:8], castagnoliTable) != binary.LittleEndian.Uint32(data[8:]) {
+		return 0, CounterFileError
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+// writes the counter into a temporary file, syncs it and renames it over the counter file
+func (counter *FileMonotonicCounter) write(value uint64) error {
+	var data [counterFileSize]byte
+	binary.LittleEndian.PutUint64(data[:], value)
+	binary.LittleEndian.PutUint32(data[8:], crc32.Checksum(data[:8], castagnoliTable))
+
+	temporary := filepath.Join(filepath.Dir(counter.path), "."+filepath.Base(counter.path)+".tmp")
+	file, err := os.OpenFile(temporary, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data[:]); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporary, counter.path)
+}
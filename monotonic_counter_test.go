@@ -0,0 +1,126 @@
+package cryptoengine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// a monotonic counter in memory
+type testMonotonicCounter struct {
+	value uint64
+}
+
+func (counter *testMonotonicCounter) Increment() (uint64, error) {
+	counter.value++
+	return counter.value, nil
+}
+
+func TestMonotonicCounterStore(t *testing.T) {
+
+	counter := &testMonotonicCounter{}
+	store := NewMonotonicCounterStore(counter)
+
+	first, err := store.ReserveCounters("sec51", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 1<<counterEpochBits {
+		t.Fatalf("The first counter is not the start of the epoch: %d\n", first)
+	}
+	next, err := store.ReserveCounters("sec51", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != first+10 || counter.value != 1 {
+		t.Fatalf("The counters have not been reserved from the same epoch: %d, %d\n", next, counter.value)
+	}
+
+	// each context has its own epoch
+	other, err := store.ReserveCounters("other", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other != 2<<counterEpochBits {
+		t.Fatalf("The other context shares the epoch: %d\n", other)
+	}
+
+	// a restart starts a new epoch, the counters of the previous run are never reserved again
+	restarted := NewMonotonicCounterStore(counter)
+	again, err := restarted.ReserveCounters("sec51", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again < other+10 {
+		t.Fatalf("The counters after the restart overlap the previous ones: %d\n", again)
+	}
+
+	if _, err := store.ReserveCounters("sec51", counterEpochSize+1); err != CounterEpochError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", CounterEpochError, err)
+	}
+
+	// a counter going back is detected
+	counter.value = 0
+	if _, err := store.ReserveCounters("new", 10); err != MonotonicCounterError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MonotonicCounterError, err)
+	}
+
+}
+
+func TestMonotonicCounterStoreEngine(t *testing.T) {
+
+	store := NewMonotonicCounterStore(NewFileMonotonicCounter(filepath.Join(t.TempDir(), "monotonic.counter")))
+	engine, err := InitCryptoEngineWithConfig("Sec51Monotonic", Config{KeyStore: NewMemoryKeyStore(), CounterStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := NewMessage("monotonic", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := engine.Decrypt(encryptedBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != message.Text {
+		t.Fatal("The decrypted message does not match the original one")
+	}
+
+}
+
+func TestFileMonotonicCounter(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "monotonic.counter")
+	counter := NewFileMonotonicCounter(path)
+	for i := uint64(1); i <= 3; i++ {
+		value, err := counter.Increment()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != i {
+			t.Fatalf("The expected counter is: %d, instead we've got: %d\n", i, value)
+		}
+	}
+
+	// the counter survives the restarts
+	if value, err := NewFileMonotonicCounter(path).Increment(); err != nil || value != 4 {
+		t.Fatalf("The counter has not been persisted: %d %v\n", value, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("corrupted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := counter.Increment(); err != CounterFileError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", CounterFileError, err)
+	}
+
+}
@@ -0,0 +1,390 @@
+package cryptoengine
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"io"
+	"math"
+	"sync"
+)
+
+// Stream multiplexing over a single connection, once a Handshake is completed: the Mux runs several logical streams,
+// each one an io.ReadWriteCloser, over one encrypted connection, so the applications do not open a connection per channel.
+// Each direction has its own key, derived from the session key, and its own frame counter, which is the nonce: the frames
+// must arrive in order, without loss, like over TCP. A frame which fails the authentication, arrives out of order
+// or breaks the protocol closes the whole Mux.
+// The streams opened by the initiator of the handshake have odd IDs, the ones opened by the responder even IDs.
+// There is no flow control window: a stream whose reader does not keep up blocks the delivery to the other streams,
+// after muxStreamBuffer frames.
+// Frame format:
+// |length|     => 4 bytes (little endian size of the rest of the frame)
+// |stream id|  => 4 bytes (little endian)
+// |type|       => 1 byte (open, data or close)
+// |ciphertext| => N bytes (ChaCha20-Poly1305 of the payload, the nonce is the frame counter, the associated data is the length, the stream ID and the type)
+
+const (
+	muxFrameOpen  = 1
+	muxFrameData  = 2
+	muxFrameClose = 3
+
+	muxHeaderSize    = 4 + 4 + 1
+	muxMaxPayload    = 32 * 1024
+	muxStreamBuffer  = 64
+	muxAcceptBacklog = 64
+
+	muxInitiatorLabel = "cryptoengine mux initiator"
+	muxResponderLabel = "cryptoengine mux responder"
+)
+
+var (
+	MuxFrameError         = errors.New("The multiplexed frame is not valid")
+	MuxClosedError        = errors.New("The multiplexed connection is closed")
+	MuxStreamClosedError  = errors.New("The multiplexed stream is closed")
+	MuxHandshakeUsedError = errors.New("A Mux has already been created for the handshake")
+)
+
+// The Mux runs the encrypted streams over the connection. It's safe for concurrent use.
+type Mux struct {
+	conn        io.ReadWriteCloser
+	sendAEAD    cipher.AEAD
+	receiveAEAD cipher.AEAD
+	writeMutex  sync.Mutex
+	sendCounter uint64 // the nonce of the next frame sent, guarded by the writeMutex
+	mutex       sync.Mutex
+	streams     map[uint32]*Stream
+	nextID      uint32 // the ID of the next stream opened by this side
+	accept      chan *Stream
+	done        chan struct{}
+	err         error // the reason the Mux has been closed
+	closeOnce   sync.Once
+}
+
+// The Stream is a logical encrypted stream of a Mux
+type Stream struct {
+	id           uint32
+	mux          *Mux
+	frames       chan []byte // the payloads received, closed when the peer closes the stream
+	buffer       []byte      // the rest of the payload partially read
+	readMutex    sync.Mutex
+	closeOnce    sync.Once
+	closed       bool // this side closed the stream, guarded by the mux mutex
+	remoteClosed bool // the peer closed the stream, guarded by the mux mutex
+	writeClosed  bool // the close frame has been sent, guarded by the mux writeMutex
+}
+
+// This method returns a Mux running over the connection, for the completed handshake.
+// Both peers must create it on the two ends of the same connection.
+// A handshake runs a single Mux: its keys and its frame counters depend only on the session key, so a second Mux
+// would reuse the nonces. A new connection, for instance after a reconnection, needs a new handshake,
+// otherwise MuxHandshakeUsedError is returned.
+func (h *Handshake) NewMux(conn io.ReadWriteCloser) (*Mux, error) {
+	sessionKey, err := h.SessionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	first := false
+	h.muxOnce.Do(func() { first = true })
+	if !first {
+		return nil, MuxHandshakeUsedError
+	}
+
+	initiatorKey, err := sessionSubKey(sessionKey, muxInitiatorLabel)
+	if err != nil {
+		return nil, err
+	}
+	responderKey, err := sessionSubKey(sessionKey, muxResponderLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := &Mux{
+		conn:    conn,
+		streams: make(map[uint32]*Stream),
+		nextID:  1,
+		accept:  make(chan *Stream, muxAcceptBacklog),
+		done:    make(chan struct{}),
+	}
+	sendKey, receiveKey := initiatorKey, responderKey
+	if !h.initiator {
+		sendKey, receiveKey = responderKey, initiatorKey
+		mux.nextID = 2
+	}
+	if mux.sendAEAD, err = chacha20poly1305.New(sendKey); err != nil {
+		return nil, err
+	}
+	if mux.receiveAEAD, err = chacha20poly1305.New(receiveKey); err != nil {
+		return nil, err
+	}
+
+	go mux.receive()
+	return mux, nil
+}
+
+// This method opens a new stream, the peer receives it from AcceptStream
+func (mux *Mux) OpenStream() (*Stream, error) {
+	mux.mutex.Lock()
+	if mux.nextID > math.MaxUint32-2 {
+		mux.mutex.Unlock()
+		return nil, MuxClosedError
+	}
+	stream := mux.newStream(mux.nextID)
+	mux.nextID += 2
+	mux.mutex.Unlock()
+
+	if err := stream.writeFrame(muxFrameOpen, nil); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// This method waits for the next stream opened by the peer
+func (mux *Mux) AcceptStream() (*Stream, error) {
+	select {
+	case stream := <-mux.accept:
+		return stream, nil
+	case <-mux.done:
+		return nil, mux.err
+	}
+}
+
+// This method closes the connection and all the streams
+func (mux *Mux) Close() error {
+	mux.close(MuxClosedError)
+	return nil
+}
+
+// This method returns a channel closed when the Mux is closed, by Close or because of an error
+func (mux *Mux) Done() <-chan struct{} {
+	return mux.done
+}
+
+// This method returns the reason the Mux has been closed, nil while it's running
+func (mux *Mux) Err() error {
+	select {
+	case <-mux.done:
+		return mux.err
+	default:
+		return nil
+	}
+}
+
+// This method returns the ID of the stream
+func (stream *Stream) ID() uint32 {
+	return stream.id
+}
+
+// This method reads the data of the stream, io.EOF once the peer closed it
+func (stream *Stream) Read(p []byte) (int, error) {
+	stream.readMutex.Lock()
+	defer stream.readMutex.Unlock()
+
+	for len(stream.buffer) == 0 {
+		var payload []byte
+		ok := true
+		select {
+		case payload, ok = <-stream.frames:
+		case <-stream.mux.done:
+			// the frames received before the Mux has been closed are read first
+			select {
+			case payload, ok = <-stream.frames:
+			default:
+				return 0, stream.mux.err
+			}
+		}
+		if !ok {
+			return 0, io.EOF
+		}
+		stream.buffer = payload
+	}
+	n := copy(p, stream.buffer)
+	stream.buffer = stream.buffer[n:]
+	return n, nil
+}
+
+// This method sends the data over the stream, in frames of at most 32KB
+func (stream *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + muxMaxPayload
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := stream.writeFrame(muxFrameData, p[written:end]); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// This method closes the stream for writing: the peer reads io.EOF once it received the data sent before.
+// The data sent by the peer can still be read.
+func (stream *Stream) Close() error {
+	var err error
+	stream.closeOnce.Do(func() {
+		mux := stream.mux
+		mux.mutex.Lock()
+		stream.closed = true
+		if stream.remoteClosed {
+			delete(mux.streams, stream.id)
+		}
+		mux.mutex.Unlock()
+		err = stream.writeFrame(muxFrameClose, nil)
+	})
+	return err
+}
+
+// sends a frame of the stream, no frame is sent once the close frame has been sent
+func (stream *Stream) writeFrame(frameType byte, payload []byte) error {
+	mux := stream.mux
+	mux.writeMutex.Lock()
+	defer mux.writeMutex.Unlock()
+
+	if stream.writeClosed {
+		return MuxStreamClosedError
+	}
+	if frameType == muxFrameClose {
+		stream.writeClosed = true
+	}
+	return mux.writeFrameLocked(stream.id, frameType, payload)
+}
+
+// registers a stream, the mux mutex must be held
+func (mux *Mux) newStream(id uint32) *Stream {
+	stream := &Stream{id: id, mux: mux, frames: make(chan []byte, muxStreamBuffer)}
+	mux.streams[id] = stream
+	return stream
+}
+
+// seals and sends a frame, the writeMutex must be held
+func (mux *Mux) writeFrameLocked(id uint32, frameType byte, payload []byte) error {
+	select {
+	case <-mux.done:
+		return mux.err
+	default:
+	}
+	if mux.sendCounter == math.MaxUint64 {
+		mux.close(MuxClosedError)
+		return MuxClosedError
+	}
+
+	frame := make([]byte, muxHeaderSize, muxHeaderSize+len(payload)+chacha20poly1305.Overhead)
+	binary.LittleEndian.PutUint32(frame, uint32(4+1+len(payload)+chacha20poly1305.Overhead))
+	binary.LittleEndian.PutUint32(frame[4:], id)
+	frame[8] = frameType
+	frame = mux.sendAEAD.Seal(frame, muxNonce(mux.sendCounter), payload, frame[:muxHeaderSize])
+	mux.sendCounter++
+
+	if _, err := mux.conn.Write(frame); err != nil {
+		mux.close(err)
+		return err
+	}
+	return nil
+}
+
+// reads and dispatches the frames of the peer until the Mux is closed
+func (mux *Mux) receive() {
+	var counter uint64
+	header := make([]byte, muxHeaderSize)
+	for {
+		if _, err := io.ReadFull(mux.conn, header); err != nil {
+			mux.close(err)
+			return
+		}
+		length := binary.LittleEndian.Uint32(header)
+		if length < 4+1+chacha20poly1305.Overhead || length > 4+1+muxMaxPayload+chacha20poly1305.Overhead {
+			mux.close(MuxFrameError)
+			return
+		}
+		sealed := make([]byte, length-4-1)
+		if _, err := io.ReadFull(mux.conn, sealed); err != nil {
+			mux.close(err)
+			return
+		}
+		payload, err := mux.receiveAEAD.Open(sealed[:0], muxNonce(counter), sealed, header)
+		if err != nil || counter == math.MaxUint64 {
+			mux.close(MuxFrameError)
+			return
+		}
+		counter++
+
+		if err := mux.dispatch(binary.LittleEndian.Uint32(header[4:]), header[8], payload); err != nil {
+			mux.close(err)
+			return
+		}
+	}
+}
+
+// delivers an authenticated frame to its stream
+func (mux *Mux) dispatch(id uint32, frameType byte, payload []byte) error {
+	mux.mutex.Lock()
+	stream, ok := mux.streams[id]
+
+	switch frameType {
+	case muxFrameOpen:
+		// the peer opens the streams with the other parity
+		if ok || id == 0 || id%2 == mux.nextID%2 || len(payload) != 0 {
+			mux.mutex.Unlock()
+			return MuxFrameError
+		}
+		stream = mux.newStream(id)
+		mux.mutex.Unlock()
+		select {
+		case mux.accept <- stream:
+			return nil
+		case <-mux.done:
+			return mux.err
+		}
+
+	case muxFrameData:
+		if !ok || stream.remoteClosed {
+			mux.mutex.Unlock()
+			return MuxFrameError
+		}
+		mux.mutex.Unlock()
+		if len(payload) == 0 {
+			return nil
+		}
+		select {
+		case stream.frames <- payload:
+			return nil
+		case <-mux.done:
+			return mux.err
+		}
+
+	case muxFrameClose:
+		if !ok || stream.remoteClosed || len(payload) != 0 {
+			mux.mutex.Unlock()
+			return MuxFrameError
+		}
+		stream.remoteClosed = true
+		if stream.closed {
+			delete(mux.streams, id)
+		}
+		mux.mutex.Unlock()
+		close(stream.frames)
+		return nil
+	}
+
+	mux.mutex.Unlock()
+	return MuxFrameError
+}
+
+// closes the connection, the first error is the reason reported to the streams
+func (mux *Mux) close(err error) {
+	mux.closeOnce.Do(func() {
+		mux.err = err
+		close(mux.done)
+		mux.conn.Close()
+	})
+}
+
+// the nonce of the frame counter
+func muxNonce(counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
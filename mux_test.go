@@ -0,0 +1,133 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMux(t *testing.T) {
+
+	initiator, responder := completedHandshakes(t)
+	aliceConn, bobConn := net.Pipe()
+	alice, err := initiator.NewMux(aliceConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	bob, err := responder.NewMux(bobConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+
+	// a second Mux over the same session would reuse the nonces
+	if _, err := initiator.NewMux(aliceConn); err != MuxHandshakeUsedError {
+		t.Fatalf("Expected %v, got %v\n", MuxHandshakeUsedError, err)
+	}
+
+	// bob echoes each stream
+	go func() {
+		for {
+			stream, err := bob.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(stream, stream)
+				stream.Close()
+			}()
+		}
+	}()
+
+	// several streams at the same time, bigger than a frame
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stream, err := alice.OpenStream()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if stream.ID()%2 != 1 {
+				errs <- fmt.Errorf("The initiator streams should have odd IDs: %d", stream.ID())
+				return
+			}
+			data := make([]byte, muxMaxPayload*2+i)
+			if _, err := rand.Read(data); err != nil {
+				errs <- err
+				return
+			}
+			go func() {
+				stream.Write(data)
+				stream.Close()
+			}()
+			echoed, err := ioutil.ReadAll(stream)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(echoed, data) {
+				errs <- fmt.Errorf("The stream %d echoed %d bytes instead of %d", stream.ID(), len(echoed), len(data))
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// a closed stream cannot be written anymore
+	stream, err := alice.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Write([]byte("late")); err != MuxStreamClosedError {
+		t.Fatalf("Expected %v, got %v\n", MuxStreamClosedError, err)
+	}
+
+}
+
+func TestMuxTampering(t *testing.T) {
+
+	initiator, _ := completedHandshakes(t)
+	aliceConn, attackerConn := net.Pipe()
+	alice, err := initiator.NewMux(aliceConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a frame which is not authenticated closes the Mux
+	frame := make([]byte, muxHeaderSize+32)
+	binary.LittleEndian.PutUint32(frame, uint32(len(frame)-4))
+	binary.LittleEndian.PutUint32(frame[4:], 2)
+	frame[8] = muxFrameOpen
+	go attackerConn.Write(frame)
+
+	select {
+	case <-alice.Done():
+	case <-time.After(time.Second):
+		t.Fatal("The Mux should have been closed")
+	}
+	if alice.Err() != MuxFrameError {
+		t.Fatalf("Expected %v, got %v\n", MuxFrameError, alice.Err())
+	}
+	if _, err := alice.AcceptStream(); err != MuxFrameError {
+		t.Fatalf("Expected %v, got %v\n", MuxFrameError, err)
+	}
+
+}
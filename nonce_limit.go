@@ -0,0 +1,83 @@
+package cryptoengine
+
+import (
+	"errors"
+	"math"
+)
+
+// Nonce space exhaustion: the nonces are derived from the nonce key, the salt and a counter, so the same counter value
+// must never be used twice with the same keys. The engine refuses to encrypt once the counter reaches the NonceLimit
+// of the Config, instead of wrapping around, and calls the NonceWarning callback of the Config when the counter
+// crosses each of the NonceWarningThresholds, so the operators rotate the keys before the hard stop.
+// NonceUsage exposes the counter and the limit, for instance for a gauge metric.
+
+var (
+	NonceExhaustedError = errors.New("The nonce counter reached its limit: the keys must be rotated")
+
+	// the default fractions of the nonce limit which raise a warning
+	defaultNonceWarningThresholds = []float64{0.5, 0.75, 0.9, 0.99}
+)
+
+// The NonceWarning is passed to the NonceWarning callback of the Config when the nonce counter crosses a threshold
+type NonceWarning struct {
+	Context   string  // the sanitized communication identifier of the engine
+	Counter   uint64  // the nonce counter after the reservation which crossed the threshold
+	Limit     uint64  // the nonce limit of the engine
+	Threshold float64 // the crossed fraction of the limit
+}
+
+// This method returns the amount of nonces used so far and the limit the engine stops encrypting at.
+// With a counter store the counter is the end of the block reserved by this engine.
+func (engine *CryptoEngine) NonceUsage() (uint64, uint64) {
+	engine.counterMutex.Lock()
+	if successor := engine.successor; successor != nil {
+		engine.counterMutex.Unlock()
+		return successor.NonceUsage()
+	}
+	defer engine.counterMutex.Unlock()
+	return engine.counter, engine.config.nonceLimit()
+}
+
+// returns the value the nonce counter must not reach
+func (config Config) nonceLimit() uint64 {
+	if config.NonceLimit == 0 {
+		return math.MaxUint64
+	}
+	return config.NonceLimit
+}
+
+// returns the fractions of the nonce limit which raise a warning
+func (config Config) nonceWarningThresholds() []float64 {
+	if config.NonceWarningThresholds == nil {
+		return defaultNonceWarningThresholds
+	}
+	return config.NonceWarningThresholds
+}
+
+// checks the n counters starting at first fit before the limit and raises the warnings of the crossed thresholds
+// the counterMutex must be held, the callback is called in its own goroutine so it can use the engine
+func (engine *CryptoEngine) checkNonceLimit(first, n uint64) error {
+	limit := engine.config.nonceLimit()
+	if n > limit || first > limit-n {
+		return NonceExhaustedError
+	}
+
+	if engine.config.NonceWarning == nil {
+		return nil
+	}
+	thresholds := engine.config.nonceWarningThresholds()
+	for engine.nonceWarnings < len(thresholds) {
+		threshold := thresholds[engine.nonceWarnings]
+		if float64(first+n) < threshold*float64(limit) {
+			break
+		}
+		engine.nonceWarnings++
+		go engine.config.NonceWarning(NonceWarning{
+			Context:   engine.context,
+			Counter:   first + n,
+			Limit:     limit,
+			Threshold: threshold,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package cryptoengine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNonceLimit(t *testing.T) {
+
+	warnings := make(chan NonceWarning, 10)
+	engine, err := InitCryptoEngineWithConfig("Sec51NonceLimit", Config{
+		KeyStore:               NewMemoryKeyStore(),
+		NonceLimit:             10,
+		NonceWarningThresholds: []float64{0.5, 0.9},
+		NonceWarning:           func(warning NonceWarning) { warnings <- warning },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("counted", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := engine.NewEncryptedMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counter, limit := engine.NonceUsage(); counter != 10 || limit != 10 {
+		t.Fatalf("Expected the usage 10 of 10, got %d of %d\n", counter, limit)
+	}
+
+	// the counter never wraps around
+	if _, err := engine.NewEncryptedMessage(msg); err != NonceExhaustedError {
+		t.Fatalf("Expected %v, got %v\n", NonceExhaustedError, err)
+	}
+	if _, err := engine.EncryptBatch([]Message{msg, msg}); err != NonceExhaustedError {
+		t.Fatalf("Expected %v, got %v\n", NonceExhaustedError, err)
+	}
+
+	// each threshold is reported once, the callbacks run concurrently
+	expected := map[float64]NonceWarning{
+		0.5: {Context: "sec51noncelimit", Counter: 5, Limit: 10, Threshold: 0.5},
+		0.9: {Context: "sec51noncelimit", Counter: 9, Limit: 10, Threshold: 0.9},
+	}
+	for range []int{0, 1} {
+		select {
+		case warning := <-warnings:
+			if warning != expected[warning.Threshold] {
+				t.Fatalf("Unexpected warning %+v\n", warning)
+			}
+			delete(expected, warning.Threshold)
+		case <-time.After(time.Second):
+			t.Fatalf("The warnings %+v have not been reported\n", expected)
+		}
+	}
+	select {
+	case warning := <-warnings:
+		t.Fatalf("Unexpected warning %+v\n", warning)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+}
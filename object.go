@@ -0,0 +1,93 @@
+package cryptoengine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// Encrypted objects in object storage (S3, GCS, ...): the uploads are stream encrypted in the age format,
+// the age header is stored in the object metadata and the body holds the chunked payload,
+// so objects of any size are encrypted and decrypted without buffering them in memory.
+// The metadata header followed by the body is a valid age file.
+
+const (
+	objectHeaderMetadataKey = "cryptoengine-age-header" // lower case: S3 and GCS return the metadata keys in lower case
+)
+
+var (
+	ObjectMetadataError = errors.New("The object metadata does not contain a valid encryption header")
+)
+
+// The ObjectStore interface is the minimal object storage client used by PutEncrypted and GetEncrypted.
+// It's implemented with thin adapters around the S3 PutObject/GetObject calls or the GCS object writers and readers.
+type ObjectStore interface {
+	// uploads the body, reading it until io.EOF, with the user defined metadata
+	PutObject(ctx context.Context, key string, body io.Reader, metadata map[string]string) error
+	// returns the body and the user defined metadata of the object
+	GetObject(ctx context.Context, key string) (io.ReadCloser, map[string]string, error)
+}
+
+// This method encrypts the body while it's uploaded to the store, for the recipients or for the engine itself when none is given
+func (engine *CryptoEngine) PutEncrypted(ctx context.Context, store ObjectStore, key string, body io.Reader, recipients ...VerificationEngine) error {
+	header, fileKey, err := engine.ageHeader(recipients)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(ageEncryptPayload(writer, body, fileKey))
+	}()
+
+	metadata := map[string]string{
+		objectHeaderMetadataKey: base64.StdEncoding.EncodeToString(header),
+	}
+	err = store.PutObject(ctx, key, reader, metadata)
+
+	// unblock the encryption in case the store did not read the whole body
+	reader.CloseWithError(io.ErrClosedPipe)
+	return err
+}
+
+// This method downloads the object and returns a reader which decrypts the body while it's read.
+// The reader returns an error if the body has been tampered with or truncated: the clear text read so far must not be trusted until io.EOF.
+func (engine *CryptoEngine) GetEncrypted(ctx context.Context, store ObjectStore, key string) (io.ReadCloser, error) {
+	body, metadata, err := store.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := base64.StdEncoding.DecodeString(metadata[objectHeaderMetadataKey])
+	if err != nil || len(header) == 0 {
+		body.Close()
+		return nil, ObjectMetadataError
+	}
+
+	fileKey, err := engine.ageOpenHeader(bufio.NewReader(bytes.NewReader(header)))
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(ageDecryptPayload(writer, bufio.NewReader(body), fileKey))
+	}()
+
+	return &objectReader{PipeReader: reader, body: body}, nil
+}
+
+// closes the object body together with the decrypting pipe
+type objectReader struct {
+	*io.PipeReader
+	body io.ReadCloser
+}
+
+func (r *objectReader) Close() error {
+	r.PipeReader.Close()
+	return r.body.Close()
+}
@@ -0,0 +1,110 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+// in memory object store
+type memoryObjectStore struct {
+	mutex    sync.Mutex
+	objects  map[string][]byte
+	metadata map[string]map[string]string
+}
+
+func (store *memoryObjectStore) PutObject(ctx context.Context, key string, body io.Reader, metadata map[string]string) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.objects[key] = data
+	store.metadata[key] = metadata
+	return nil
+}
+
+func (store *memoryObjectStore) GetObject(ctx context.Context, key string) (io.ReadCloser, map[string]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	data, ok := store.objects[key]
+	if !ok {
+		return nil, nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), store.metadata[key], nil
+}
+
+func TestEncryptedObjects(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &memoryObjectStore{objects: make(map[string][]byte), metadata: make(map[string]map[string]string)}
+	ctx := context.Background()
+
+	// a few chunks
+	clearText := make([]byte, 3*ageChunkSize+42)
+	if _, err := rand.Read(clearText); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.PutEncrypted(ctx, store, "backup.tar", bytes.NewReader(clearText)); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(store.objects["backup.tar"], clearText[:64]) || store.metadata["backup.tar"][objectHeaderMetadataKey] == "" {
+		t.Fatal("The object has not been encrypted")
+	}
+
+	reader, err := engine.GetEncrypted(ctx, store, "backup.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, clearText) {
+		t.Fatal("The decrypted object does not match the original one")
+	}
+
+	// the metadata header followed by the body is an age file
+	var age bytes.Buffer
+	header, _ := base64.StdEncoding.DecodeString(store.metadata["backup.tar"][objectHeaderMetadataKey])
+	age.Write(header)
+	age.Write(store.objects["backup.tar"])
+	var fromAge bytes.Buffer
+	if err := engine.DecryptAge(&fromAge, &age); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fromAge.Bytes(), clearText) {
+		t.Fatal("The object is not a valid age file")
+	}
+
+	// truncated objects are detected
+	store.objects["backup.tar"] = store.objects["backup.tar"][:ageChunkSize]
+	reader, err = engine.GetEncrypted(ctx, store, "backup.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(reader); err != AgePayloadError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", AgePayloadError, err)
+	}
+	reader.Close()
+
+	// objects without the header are rejected
+	delete(store.metadata["backup.tar"], objectHeaderMetadataKey)
+	if _, err := engine.GetEncrypted(ctx, store, "backup.tar"); err != ObjectMetadataError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ObjectMetadataError, err)
+	}
+}
@@ -0,0 +1,426 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"math/big"
+)
+
+// OPAQUE password authenticated key exchange, following the OPAQUE-3DH design of RFC 9807:
+// the OPRF is P256-SHA256 (see oprf.go), the key stretching function is Argon2id and the 3DH key exchange uses X25519,
+// with the engine key pair as the server static key. It is not meant to be wire compatible with other implementations.
+//
+// Registration:
+// client.RegistrationRequest -> engine.OpaqueRegistrationResponse -> client.FinalizeRegistration -> the server stores the record
+// Login:
+// client.LoginRequest (KE1) -> engine.OpaqueLoginResponse (KE2) -> client.FinishLogin (KE3) -> session.Finish
+//
+// The server never sees the password nor anything derived from it which allows an offline dictionary attack without the OPRF key,
+// which is derived from the engine secret key.
+
+const (
+	opaqueNonceSize    = 32
+	opaqueMacSize      = sha256.Size
+	opaqueEnvelopeSize = opaqueNonceSize + opaqueMacSize
+
+	opaqueRegistrationResponseSize = oprfElementSize + keySize
+	opaqueRecordSize               = keySize + keySize + opaqueEnvelopeSize
+	opaqueCredentialResponseSize   = oprfElementSize + opaqueNonceSize + keySize + opaqueEnvelopeSize
+	opaqueKE1Size                  = oprfElementSize + opaqueNonceSize + keySize
+	opaqueKE2Size                  = opaqueCredentialResponseSize + opaqueNonceSize + keySize + opaqueMacSize
+	opaqueKE3Size                  = opaqueMacSize
+
+	opaqueSubKeyLabel = "opaque oprf seed"
+	opaqueContext     = "cryptoengine"
+
+	// Argon2id parameters: the second recommended option of RFC 9106
+	opaqueArgon2Time    = 3
+	opaqueArgon2Memory  = 64 * 1024
+	opaqueArgon2Threads = 4
+)
+
+var (
+	OpaqueError               = errors.New("The OPAQUE message is not valid")
+	OpaqueAuthenticationError = errors.New("The OPAQUE authentication failed")
+)
+
+// The OpaqueClient holds the client state between the messages of the registration or of the login
+type OpaqueClient struct {
+	password  []byte
+	blind     *big.Int
+	ephemeral []byte // ephemeral X25519 private key of the login
+	ke1       []byte
+}
+
+// The OpaqueServerSession holds the server state between the KE2 and the KE3 messages
+type OpaqueServerSession struct {
+	expectedClientMac []byte
+	sessionKey        [keySize]byte
+	done              bool
+}
+
+// This function instantiate the client side of OPAQUE for the password
+func NewOpaqueClient(password []byte) *OpaqueClient {
+	return &OpaqueClient{password: append([]byte{}, password...)}
+}
+
+// This method returns the registration request to send to the server
+func (client *OpaqueClient) RegistrationRequest() ([]byte, error) {
+	blind, blinded, err := oprfBlind(client.password)
+	if err != nil {
+		return nil, err
+	}
+	client.blind = blind
+	return blinded, nil
+}
+
+// This method evaluates the registration request of the client identified by credentialIdentifier
+func (engine *CryptoEngine) OpaqueRegistrationResponse(credentialIdentifier string, request []byte) ([]byte, error) {
+	oprfKey, err := engine.opaqueOPRFKey(credentialIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	evaluated, err := oprfBlindEvaluate(oprfKey, request)
+	if err != nil {
+		return nil, OpaqueError
+	}
+	return append(evaluated, engine.publicKey[:]...), nil
+}
+
+// This method completes the registration: it returns the record the server has to store for the client
+// and the export key, a secret only the client can recompute at each login
+func (client *OpaqueClient) FinalizeRegistration(response []byte) ([]byte, []byte, error) {
+	if client.blind == nil {
+		return nil, nil, OpaqueError
+	}
+	if len(response) != opaqueRegistrationResponseSize {
+		return nil, nil, OpaqueError
+	}
+
+	randomizedPassword, err := client.randomizedPassword(response[:oprfElementSize])
+	if err != nil {
+		return nil, nil, err
+	}
+	serverPublicKey := response[oprfElementSize:]
+
+	nonce := make([]byte, opaqueNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	_, clientPublicKey, authTag, exportKey, err := opaqueEnvelope(randomizedPassword, nonce, serverPublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var record bytes.Buffer
+	record.Write(clientPublicKey)
+	record.Write(opaqueExpand(randomizedPassword, []byte("MaskingKey"), keySize))
+	record.Write(nonce)
+	record.Write(authTag)
+	return record.Bytes(), exportKey, nil
+}
+
+// This method returns the first login message (KE1) to send to the server
+func (client *OpaqueClient) LoginRequest() ([]byte, error) {
+	blind, blinded, err := oprfBlind(client.password)
+	if err != nil {
+		return nil, err
+	}
+	client.blind = blind
+
+	client.ephemeral = make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(client.ephemeral); err != nil {
+		return nil, err
+	}
+	keyShare, err := curve25519.X25519(client.ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, opaqueNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	client.ke1 = append(append(blinded, nonce...), keyShare...)
+	return client.ke1, nil
+}
+
+// This method answers the KE1 message of the client identified by credentialIdentifier with the KE2 message.
+// When the client is not registered the record is nil: a fake response is returned, so unknown clients cannot be told apart.
+func (engine *CryptoEngine) OpaqueLoginResponse(credentialIdentifier string, record, ke1 []byte) (*OpaqueServerSession, []byte, error) {
+	if len(ke1) != opaqueKE1Size {
+		return nil, nil, OpaqueError
+	}
+
+	seed, err := engine.deriveSubKey(opaqueSubKeyLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var clientPublicKey, maskingKey, envelope []byte
+	if record == nil {
+		fake := opaqueExpand(seed[:], []byte(credentialIdentifier+"FakeRecord"), 2*keySize)
+		if clientPublicKey, err = curve25519.X25519(fake[:keySize], curve25519.Basepoint); err != nil {
+			return nil, nil, err
+		}
+		maskingKey = fake[keySize:]
+		envelope = make([]byte, opaqueEnvelopeSize)
+	} else {
+		if len(record) != opaqueRecordSize {
+			return nil, nil, OpaqueError
+		}
+		clientPublicKey = record[:keySize]
+		maskingKey = record[keySize : 2*keySize]
+		envelope = record[2*keySize:]
+	}
+
+	// credential response: evaluated element, masking nonce and the masked server public key and envelope
+	oprfKey, err := engine.opaqueOPRFKey(credentialIdentifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	evaluated, err := oprfBlindEvaluate(oprfKey, ke1[:oprfElementSize])
+	if err != nil {
+		return nil, nil, OpaqueError
+	}
+
+	maskingNonce := make([]byte, opaqueNonceSize)
+	if _, err := rand.Read(maskingNonce); err != nil {
+		return nil, nil, err
+	}
+	masked := opaqueMask(maskingKey, maskingNonce, append(append([]byte{}, engine.publicKey[:]...), envelope...))
+	credentialResponse := append(append(evaluated, maskingNonce...), masked...)
+
+	// 3DH
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, nil, err
+	}
+	keyShare, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, opaqueNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	clientKeyShare := ke1[oprfElementSize+opaqueNonceSize:]
+	ikm, err := opaqueTripleDH(ephemeral, clientKeyShare, engine.privateKey[:], clientKeyShare, ephemeral, clientPublicKey)
+	if err != nil {
+		return nil, nil, OpaqueError
+	}
+
+	preamble := opaquePreamble(clientPublicKey, ke1, engine.publicKey[:], credentialResponse, nonce, keyShare)
+	serverMac, clientMac, sessionKey := opaqueDeriveKeys(ikm, preamble)
+
+	session := &OpaqueServerSession{expectedClientMac: clientMac}
+	copy(session.sessionKey[:], sessionKey)
+
+	ke2 := append(credentialResponse, nonce...)
+	ke2 = append(ke2, keyShare...)
+	return session, append(ke2, serverMac...), nil
+}
+
+// This method authenticates the server with the KE2 message and returns the KE3 message to send to it,
+// together with the session key and the export key
+func (client *OpaqueClient) FinishLogin(ke2 []byte) ([]byte, [keySize]byte, []byte, error) {
+	var sessionKey [keySize]byte
+
+	if client.blind == nil || client.ke1 == nil {
+		return nil, sessionKey, nil, OpaqueError
+	}
+	if len(ke2) != opaqueKE2Size {
+		return nil, sessionKey, nil, OpaqueError
+	}
+
+	credentialResponse := ke2[:opaqueCredentialResponseSize]
+	nonce := ke2[opaqueCredentialResponseSize : opaqueCredentialResponseSize+opaqueNonceSize]
+	serverKeyShare := ke2[opaqueCredentialResponseSize+opaqueNonceSize : opaqueCredentialResponseSize+opaqueNonceSize+keySize]
+	serverMac := ke2[opaqueCredentialResponseSize+opaqueNonceSize+keySize:]
+
+	// recover the envelope
+	randomizedPassword, err := client.randomizedPassword(credentialResponse[:oprfElementSize])
+	if err != nil {
+		return nil, sessionKey, nil, err
+	}
+
+	maskingKey := opaqueExpand(randomizedPassword, []byte("MaskingKey"), keySize)
+	maskingNonce := credentialResponse[oprfElementSize : oprfElementSize+opaqueNonceSize]
+	unmasked := opaqueMask(maskingKey, maskingNonce, credentialResponse[oprfElementSize+opaqueNonceSize:])
+	serverPublicKey := unmasked[:keySize]
+	envelopeNonce := unmasked[keySize : keySize+opaqueNonceSize]
+
+	clientPrivateKey, clientPublicKey, authTag, exportKey, err := opaqueEnvelope(randomizedPassword, envelopeNonce, serverPublicKey)
+	if err != nil {
+		return nil, sessionKey, nil, err
+	}
+
+	// a wrong password gives a different auth tag
+	if subtle.ConstantTimeCompare(authTag, unmasked[keySize+opaqueNonceSize:]) != 1 {
+		return nil, sessionKey, nil, OpaqueAuthenticationError
+	}
+
+	// 3DH
+	ikm, err := opaqueTripleDH(client.ephemeral, serverKeyShare, client.ephemeral, serverPublicKey, clientPrivateKey, serverKeyShare)
+	if err != nil {
+		return nil, sessionKey, nil, OpaqueError
+	}
+
+	preamble := opaquePreamble(clientPublicKey, client.ke1, serverPublicKey, credentialResponse, nonce, serverKeyShare)
+	expectedServerMac, clientMac, key := opaqueDeriveKeys(ikm, preamble)
+	if !hmac.Equal(expectedServerMac, serverMac) {
+		return nil, sessionKey, nil, OpaqueAuthenticationError
+	}
+
+	// the login state is single use
+	client.blind = nil
+	client.ke1 = nil
+
+	copy(sessionKey[:], key)
+	return clientMac, sessionKey, exportKey, nil
+}
+
+// This method authenticates the client with the KE3 message and returns the session key
+func (session *OpaqueServerSession) Finish(ke3 []byte) ([keySize]byte, error) {
+	if session.done {
+		return [keySize]byte{}, OpaqueError
+	}
+	session.done = true
+
+	if !hmac.Equal(session.expectedClientMac, ke3) {
+		return [keySize]byte{}, OpaqueAuthenticationError
+	}
+	return session.sessionKey, nil
+}
+
+// the OPRF key is bound to the credential identifier
+func (engine *CryptoEngine) opaqueOPRFKey(credentialIdentifier string) (*big.Int, error) {
+	seed, err := engine.deriveSubKey(opaqueSubKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	return oprfDeriveKey(opaqueExpand(seed[:], []byte(credentialIdentifier+"OprfKey"), oprfScalarSize), []byte("OPAQUE-DeriveKeyPair"))
+}
+
+// finalizes the OPRF and stretches the output
+func (client *OpaqueClient) randomizedPassword(evaluated []byte) ([]byte, error) {
+	oprfOutput, err := oprfFinalize(client.password, client.blind, evaluated)
+	if err != nil {
+		return nil, OpaqueError
+	}
+	stretched := argon2.IDKey(oprfOutput, make([]byte, 16), opaqueArgon2Time, opaqueArgon2Memory, opaqueArgon2Threads, sha256.Size)
+	return hkdf.Extract(sha256.New, append(oprfOutput, stretched...), nil), nil
+}
+
+// derives the client key pair from the randomized password and returns it with the envelope auth tag and the export key
+func opaqueEnvelope(randomizedPassword, nonce, serverPublicKey []byte) ([]byte, []byte, []byte, []byte, error) {
+	authKey := opaqueExpand(randomizedPassword, append(append([]byte{}, nonce...), "AuthKey"...), opaqueMacSize)
+	exportKey := opaqueExpand(randomizedPassword, append(append([]byte{}, nonce...), "ExportKey"...), sha256.Size)
+	clientPrivateKey := opaqueExpand(randomizedPassword, append(append([]byte{}, nonce...), "PrivateKey"...), keySize)
+
+	clientPublicKey, err := curve25519.X25519(clientPrivateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	// the identities are the public keys
+	mac := hmac.New(sha256.New, authKey)
+	mac.Write(nonce)
+	mac.Write(serverPublicKey)
+	mac.Write(opaqueLengthPrefixed(serverPublicKey))
+	mac.Write(opaqueLengthPrefixed(clientPublicKey))
+	return clientPrivateKey, clientPublicKey, mac.Sum(nil), exportKey, nil
+}
+
+// XORs the data with the pad derived from the masking key and nonce
+func opaqueMask(maskingKey, maskingNonce, data []byte) []byte {
+	pad := opaqueExpand(maskingKey, append(append([]byte{}, maskingNonce...), "CredentialResponsePad"...), len(data))
+	masked := make([]byte, len(data))
+	for i := range data {
+		masked[i] = data[i] ^ pad[i]
+	}
+	return masked
+}
+
+// concatenates the three X25519 shared secrets
+func opaqueTripleDH(privateKey1, publicKey1, privateKey2, publicKey2, privateKey3, publicKey3 []byte) ([]byte, error) {
+	var ikm []byte
+	for _, pair := range [][2][]byte{{privateKey1, publicKey1}, {privateKey2, publicKey2}, {privateKey3, publicKey3}} {
+		// X25519 fails on low order points
+		secret, err := curve25519.X25519(pair[0], pair[1])
+		if err != nil {
+			return nil, err
+		}
+		ikm = append(ikm, secret...)
+	}
+	return ikm, nil
+}
+
+// the transcript of the key exchange, the identities are the public keys
+func opaquePreamble(clientIdentity, ke1, serverIdentity, credentialResponse, serverNonce, serverKeyShare []byte) []byte {
+	var preamble bytes.Buffer
+	preamble.WriteString("OPAQUEv1-")
+	preamble.Write(opaqueLengthPrefixed([]byte(opaqueContext)))
+	preamble.Write(opaqueLengthPrefixed(clientIdentity))
+	preamble.Write(ke1)
+	preamble.Write(opaqueLengthPrefixed(serverIdentity))
+	preamble.Write(credentialResponse)
+	preamble.Write(serverNonce)
+	preamble.Write(serverKeyShare)
+	return preamble.Bytes()
+}
+
+// returns the server mac, the client mac and the session key
+func opaqueDeriveKeys(ikm, preamble []byte) ([]byte, []byte, []byte) {
+	prk := hkdf.Extract(sha256.New, ikm, nil)
+	preambleHash := sha256.Sum256(preamble)
+
+	handshakeSecret := opaqueExpandLabel(prk, "HandshakeSecret", preambleHash[:], sha256.Size)
+	sessionKey := opaqueExpandLabel(prk, "SessionKey", preambleHash[:], keySize)
+	serverMacKey := opaqueExpandLabel(handshakeSecret, "ServerMAC", nil, opaqueMacSize)
+	clientMacKey := opaqueExpandLabel(handshakeSecret, "ClientMAC", nil, opaqueMacSize)
+
+	mac := hmac.New(sha256.New, serverMacKey)
+	mac.Write(preambleHash[:])
+	serverMac := mac.Sum(nil)
+
+	transcriptHash := sha256.Sum256(append(preamble, serverMac...))
+	mac = hmac.New(sha256.New, clientMacKey)
+	mac.Write(transcriptHash[:])
+	return serverMac, mac.Sum(nil), sessionKey
+}
+
+// Expand-Label: the label is prefixed with OPAQUE- and bound to the length and the context
+func opaqueExpandLabel(secret []byte, label string, context []byte, length int) []byte {
+	label = "OPAQUE-" + label
+	info := make([]byte, 2, 4+len(label)+len(context))
+	binary.BigEndian.PutUint16(info, uint16(length))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, byte(len(context)))
+	info = append(info, context...)
+	return opaqueExpand(secret, info, length)
+}
+
+func opaqueExpand(secret, info []byte, length int) []byte {
+	out := make([]byte, length)
+	io.ReadFull(hkdf.Expand(sha256.New, secret, info), out)
+	return out
+}
+
+func opaqueLengthPrefixed(data []byte) []byte {
+	return append([]byte{byte(len(data) >> 8), byte(len(data))}, data...)
+}
@@ -0,0 +1,140 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"testing"
+)
+
+// registers the password and returns the record stored by the server and the export key
+func opaqueRegister(t *testing.T, server *CryptoEngine, credentialIdentifier string, password []byte) ([]byte, []byte) {
+	client := NewOpaqueClient(password)
+
+	request, err := client.RegistrationRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := server.OpaqueRegistrationResponse(credentialIdentifier, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, exportKey, err := client.FinalizeRegistration(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record, exportKey
+}
+
+func TestOpaque(t *testing.T) {
+
+	server, err := InitCryptoEngine("Sec51OpaqueServer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("correct horse battery staple")
+	record, registrationExportKey := opaqueRegister(t, server, "alice", password)
+
+	if bytes.Contains(record, password) {
+		t.Fatal("The record contains the password")
+	}
+
+	client := NewOpaqueClient(password)
+	ke1, err := client.LoginRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, ke2, err := server.OpaqueLoginResponse("alice", record, ke1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ke3, clientSessionKey, exportKey, err := client.FinishLogin(ke2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverSessionKey, err := session.Finish(ke3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if clientSessionKey != serverSessionKey {
+		t.Fatal("The session keys do not match")
+	}
+
+	if !bytes.Equal(exportKey, registrationExportKey) {
+		t.Fatal("The export key is not the one of the registration")
+	}
+
+	// the session is single use
+	if _, err := session.Finish(ke3); err != OpaqueError {
+		t.Errorf("The expected error is: OpaqueError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestOpaqueWrongPassword(t *testing.T) {
+
+	server, err := InitCryptoEngine("Sec51OpaqueServer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, _ := opaqueRegister(t, server, "bob", []byte("correct horse battery staple"))
+
+	// wrong password
+	client := NewOpaqueClient([]byte("Tr0ub4dor&3"))
+	ke1, err := client.LoginRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ke2, err := server.OpaqueLoginResponse("bob", record, ke1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := client.FinishLogin(ke2); err != OpaqueAuthenticationError {
+		t.Errorf("The expected error is: OpaqueAuthenticationError, instead we've got: %v\n", err)
+	}
+
+	// the record is bound to the credential identifier
+	client = NewOpaqueClient([]byte("correct horse battery staple"))
+	if ke1, err = client.LoginRequest(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ke2, err = server.OpaqueLoginResponse("carol", record, ke1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := client.FinishLogin(ke2); err != OpaqueAuthenticationError {
+		t.Errorf("The expected error is: OpaqueAuthenticationError, instead we've got: %v\n", err)
+	}
+
+	// unknown clients get a fake response
+	if ke1, err = client.LoginRequest(); err != nil {
+		t.Fatal(err)
+	}
+
+	session, ke2, err := server.OpaqueLoginResponse("dave", nil, ke1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ke2) != opaqueKE2Size {
+		t.Fatalf("The fake KE2 has a different size: %d\n", len(ke2))
+	}
+
+	if _, _, _, err := client.FinishLogin(ke2); err != OpaqueAuthenticationError {
+		t.Errorf("The expected error is: OpaqueAuthenticationError, instead we've got: %v\n", err)
+	}
+
+	if _, err := session.Finish(make([]byte, opaqueKE3Size)); err != OpaqueAuthenticationError {
+		t.Errorf("The expected error is: OpaqueAuthenticationError, instead we've got: %v\n", err)
+	}
+
+}
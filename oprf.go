@@ -0,0 +1,265 @@
+package cryptoengine
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// Oblivious pseudorandom function (RFC 9497), OPRF mode with the P256-SHA256 suite,
+// including the hash to curve P256_XMD:SHA-256_SSWU_RO_ (RFC 9380) it needs.
+// It's the building block of the OPAQUE password authenticated key exchange.
+// The arithmetic relies on math/big, which is not constant time.
+
+const (
+	oprfElementSize = 33 // compressed P-256 point
+	oprfScalarSize  = 32
+	oprfContext     = "OPRFV1-\x00-P256-SHA256"
+)
+
+var (
+	OPRFError = errors.New("The OPRF element is not valid")
+
+	p256 = elliptic.P256()
+
+	// the SSWU constants for P-256: A = -3 and Z = -10
+	sswuA = new(big.Int).Sub(p256.Params().P, big.NewInt(3))
+	sswuZ = new(big.Int).Sub(p256.Params().P, big.NewInt(10))
+)
+
+// a P-256 point in affine coordinates
+type oprfElement struct {
+	x, y *big.Int
+}
+
+// expand_message_xmd with SHA-256 (RFC 9380 section 5.3.1)
+func expandMessageXMD(msg, dst []byte, length int) ([]byte, error) {
+	ell := (length + sha256.Size - 1) / sha256.Size
+	if ell > 255 || length > 65535 || len(dst) > 255 {
+		return nil, OPRFError
+	}
+
+	dstPrime := append(append([]byte{}, dst...), byte(len(dst)))
+
+	hash := sha256.New()
+	hash.Write(make([]byte, sha256.BlockSize))
+	hash.Write(msg)
+	binary.Write(hash, binary.BigEndian, uint16(length))
+	hash.Write([]byte{0})
+	hash.Write(dstPrime)
+	b0 := hash.Sum(nil)
+
+	hash.Reset()
+	hash.Write(b0)
+	hash.Write([]byte{1})
+	hash.Write(dstPrime)
+	bi := hash.Sum(nil)
+
+	uniform := append([]byte{}, bi...)
+	for i := 2; i <= ell; i++ {
+		hash.Reset()
+		for j := range bi {
+			bi[j] ^= b0[j]
+		}
+		hash.Write(bi)
+		hash.Write([]byte{byte(i)})
+		hash.Write(dstPrime)
+		bi = hash.Sum(nil)
+		uniform = append(uniform, bi...)
+	}
+	return uniform[:length], nil
+}
+
+// hashes the message to a P-256 point (RFC 9380, hash_to_curve with the random oracle encoding)
+func hashToCurve(msg, dst []byte) (oprfElement, error) {
+	uniform, err := expandMessageXMD(msg, dst, 96)
+	if err != nil {
+		return oprfElement{}, err
+	}
+
+	p := p256.Params().P
+	u0 := new(big.Int).Mod(new(big.Int).SetBytes(uniform[:48]), p)
+	u1 := new(big.Int).Mod(new(big.Int).SetBytes(uniform[48:]), p)
+
+	q0 := mapToCurveSSWU(u0)
+	q1 := mapToCurveSSWU(u1)
+	x, y := p256.Add(q0.x, q0.y, q1.x, q1.y)
+	return oprfElement{x, y}, nil
+}
+
+// hashes the message to a scalar modulo the group order (RFC 9380 hash_to_field)
+func hashToScalar(msg, dst []byte) (*big.Int, error) {
+	uniform, err := expandMessageXMD(msg, dst, 48)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(uniform), p256.Params().N), nil
+}
+
+// the simplified Shallue-van de Woestijne-Ulas method (RFC 9380 section 6.6.2)
+func mapToCurveSSWU(u *big.Int) oprfElement {
+	p := p256.Params().P
+	b := p256.Params().B
+
+	// tv1 = Z^2 * u^4 + Z * u^2
+	u2 := new(big.Int).Mul(u, u)
+	u2.Mod(u2, p)
+	zu2 := new(big.Int).Mul(sswuZ, u2)
+	zu2.Mod(zu2, p)
+	tv1 := new(big.Int).Mul(zu2, zu2)
+	tv1.Add(tv1, zu2)
+	tv1.Mod(tv1, p)
+
+	// x1 = (-B / A) * (1 + 1 / tv1), or B / (Z * A) when tv1 is zero
+	x1 := new(big.Int)
+	if tv1.Sign() == 0 {
+		x1.Mul(sswuZ, sswuA)
+		x1.ModInverse(x1, p)
+		x1.Mul(x1, b)
+	} else {
+		x1.ModInverse(tv1, p)
+		x1.Add(x1, big.NewInt(1))
+		x1.Mul(x1, new(big.Int).Sub(p, b))
+		x1.Mul(x1, new(big.Int).ModInverse(sswuA, p))
+	}
+	x1.Mod(x1, p)
+
+	x := x1
+	y := new(big.Int).ModSqrt(sswuCurve(x1), p)
+	if y == nil {
+		// x2 = Z * u^2 * x1, gx2 is always a square
+		x = new(big.Int).Mul(zu2, x1)
+		x.Mod(x, p)
+		y = new(big.Int).ModSqrt(sswuCurve(x), p)
+	}
+
+	// the sign of y must match the sign of u
+	if u.Bit(0) != y.Bit(0) {
+		y.Sub(p, y)
+	}
+	return oprfElement{x, y}
+}
+
+// x^3 + A * x + B
+func sswuCurve(x *big.Int) *big.Int {
+	p := p256.Params().P
+	gx := new(big.Int).Mul(x, x)
+	gx.Add(gx, sswuA)
+	gx.Mul(gx, x)
+	gx.Add(gx, p256.Params().B)
+	return gx.Mod(gx, p)
+}
+
+func (e oprfElement) scalarMult(k *big.Int) (oprfElement, error) {
+	x, y := p256.ScalarMult(e.x, e.y, k.Bytes())
+	// the point at infinity is returned as (0, 0)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return oprfElement{}, OPRFError
+	}
+	return oprfElement{x, y}, nil
+}
+
+func (e oprfElement) bytes() []byte {
+	return elliptic.MarshalCompressed(p256, e.x, e.y)
+}
+
+// decodes a compressed point, rejecting the points which are not on the curve
+func oprfElementFromBytes(data []byte) (oprfElement, error) {
+	if len(data) != oprfElementSize {
+		return oprfElement{}, OPRFError
+	}
+	x, y := elliptic.UnmarshalCompressed(p256, data)
+	if x == nil {
+		return oprfElement{}, OPRFError
+	}
+	return oprfElement{x, y}, nil
+}
+
+// a random non zero scalar
+func oprfRandomScalar() (*big.Int, error) {
+	for {
+		k, err := rand.Int(rand.Reader, p256.Params().N)
+		if err != nil {
+			return nil, err
+		}
+		if k.Sign() != 0 {
+			return k, nil
+		}
+	}
+}
+
+// deterministically derives the OPRF private key from the seed (RFC 9497 section 3.2.1)
+func oprfDeriveKey(seed, info []byte) (*big.Int, error) {
+	deriveInput := append([]byte{}, seed...)
+	deriveInput = append(deriveInput, byte(len(info)>>8), byte(len(info)))
+	deriveInput = append(deriveInput, info...)
+
+	for counter := 0; counter < 256; counter++ {
+		k, err := hashToScalar(append(deriveInput, byte(counter)), []byte("DeriveKeyPair"+oprfContext))
+		if err != nil {
+			return nil, err
+		}
+		if k.Sign() != 0 {
+			return k, nil
+		}
+	}
+	return nil, OPRFError
+}
+
+// blinds the input: returns the blind and the blinded element
+func oprfBlind(input []byte) (*big.Int, []byte, error) {
+	point, err := hashToCurve(input, []byte("HashToGroup-"+oprfContext))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blind, err := oprfRandomScalar()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blinded, err := point.scalarMult(blind)
+	if err != nil {
+		return nil, nil, err
+	}
+	return blind, blinded.bytes(), nil
+}
+
+// the server side: multiplies the blinded element by the private key
+func oprfBlindEvaluate(key *big.Int, blinded []byte) ([]byte, error) {
+	element, err := oprfElementFromBytes(blinded)
+	if err != nil {
+		return nil, err
+	}
+
+	evaluated, err := element.scalarMult(key)
+	if err != nil {
+		return nil, err
+	}
+	return evaluated.bytes(), nil
+}
+
+// removes the blind and hashes the result together with the input
+func oprfFinalize(input []byte, blind *big.Int, evaluated []byte) ([]byte, error) {
+	element, err := oprfElementFromBytes(evaluated)
+	if err != nil {
+		return nil, err
+	}
+
+	unblinded, err := element.scalarMult(new(big.Int).ModInverse(blind, p256.Params().N))
+	if err != nil {
+		return nil, err
+	}
+	unblindedBytes := unblinded.bytes()
+
+	hash := sha256.New()
+	binary.Write(hash, binary.BigEndian, uint16(len(input)))
+	hash.Write(input)
+	binary.Write(hash, binary.BigEndian, uint16(len(unblindedBytes)))
+	hash.Write(unblindedBytes)
+	hash.Write([]byte("Finalize"))
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,89 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// RFC 9380 appendix K.1
+func TestExpandMessageXMD(t *testing.T) {
+
+	uniform, err := expandMessageXMD([]byte(""), []byte("QUUX-V01-CS02-with-expander-SHA256-128"), 0x20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := hex.DecodeString("68a985b87eb6b46952128911f2a4412bbc302a9d759667f87f7a21d803f07235")
+	if !bytes.Equal(uniform, expected) {
+		t.Fatalf("Expected %x, instead we've got %x\n", expected, uniform)
+	}
+
+}
+
+// RFC 9380 appendix J.1.1
+func TestHashToCurve(t *testing.T) {
+
+	point, err := hashToCurve([]byte(""), []byte("QUUX-V01-CS02-with-P256_XMD:SHA-256_SSWU_RO_"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, _ := new(big.Int).SetString("2c15230b26dbc6fc9a37051158c95b79656e17a1a920b11394ca91c44247d3e4", 16)
+	y, _ := new(big.Int).SetString("8a7a74985cc5c776cdfe4b1f19884970453912e9d31528c060be9ab5c43e8415", 16)
+	if point.x.Cmp(x) != 0 || point.y.Cmp(y) != 0 {
+		t.Fatalf("Expected (%x, %x), instead we've got (%x, %x)\n", x, y, point.x, point.y)
+	}
+
+}
+
+// RFC 9497 appendix A.3.1
+func TestOPRF(t *testing.T) {
+
+	seed := bytes.Repeat([]byte{0xa3}, 32)
+	key, err := oprfDeriveKey(seed, []byte("test key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(key.Bytes()) != "159749d750713afe245d2d39ccfaae8381c53ce92d098a9375ee70739c7ac0bf" {
+		t.Fatalf("Unexpected OPRF key: %x\n", key)
+	}
+
+	input := []byte{0}
+	blind, _ := new(big.Int).SetString("3338fa65ec36e0290022b48eb562889d89dbfa691d1cde91517fa222ed7ad364", 16)
+
+	point, err := hashToCurve(input, []byte("HashToGroup-"+oprfContext))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blinded, err := point.scalarMult(blind)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(blinded.bytes()) != "03723a1e5c09b8b9c18d1dcbca29e8007e95f14f4732d9346d490ffc195110368d" {
+		t.Fatalf("Unexpected blinded element: %x\n", blinded.bytes())
+	}
+
+	evaluated, err := oprfBlindEvaluate(key, blinded.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(evaluated) != "030de02ffec47a1fd53efcdd1c6faf5bdc270912b8749e783c7ca75bb412958832" {
+		t.Fatalf("Unexpected evaluated element: %x\n", evaluated)
+	}
+
+	output, err := oprfFinalize(input, blind, evaluated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(output) != "a0b34de5fa4c5b6da07e72af73cc507cceeb48981b97b7285fc375345fe495dd" {
+		t.Fatalf("Unexpected output: %x\n", output)
+	}
+
+}
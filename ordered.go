@@ -0,0 +1,174 @@
+package cryptoengine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Ordered delivery for the transports which can reorder frames, like UDP: the sender numbers its messages with the
+// header Sequence, starting at 1, and the OrderedChannel of the receiver buffers the messages received ahead of time
+// and delivers them in order. A missing sequence number is a gap: the application can inspect it with Gap, for instance
+// to ask for a retransmission, and give up on it with Skip. When more messages than the limit wait behind a gap,
+// the channel skips it on its own and reports it with a GapError.
+
+const (
+	orderedMaxPending = 256 // amount of messages buffered behind a gap when the limit is zero
+)
+
+var (
+	SequenceError          = errors.New("The message has no sequence number")
+	DuplicateSequenceError = errors.New("The message sequence number has already been received")
+	MessageGapError        = errors.New("Messages are missing and have been skipped")
+)
+
+// The GapError describes the sequence numbers which have been skipped. It wraps MessageGapError, test it with errors.Is
+type GapError struct {
+	From uint64 // the first missing sequence number
+	To   uint64 // the last missing sequence number
+	Err  error  // the generic error
+}
+
+func (e *GapError) Error() string {
+	return fmt.Sprintf("%v (sequence %d to %d)", e.Err, e.From, e.To)
+}
+
+func (e *GapError) Unwrap() error {
+	return e.Err
+}
+
+// The OrderedChannel decrypts the messages of a sender and delivers them in the order of their sequence numbers.
+// It's safe for concurrent use.
+type OrderedChannel struct {
+	engine     *CryptoEngine
+	peer       *VerificationEngine // nil for the messages encrypted with the secret key
+	maxPending int
+	mutex      sync.Mutex
+	next       uint64 // the sequence number of the next message to deliver
+	pending    map[uint64]*Message
+}
+
+// This method returns an ordered channel for the messages of NewEncryptedMessageWithHeader.
+// maxPending is the amount of messages buffered behind a gap, zero for the default.
+func (engine *CryptoEngine) NewOrderedChannel(maxPending int) *OrderedChannel {
+	return engine.newOrderedChannel(nil, maxPending)
+}
+
+// This method returns an ordered channel for the messages of NewEncryptedMessageWithHeaderAndPubKey sent by the peer
+func (engine *CryptoEngine) NewOrderedChannelWithPubKey(maxPending int, verificationEngine VerificationEngine) *OrderedChannel {
+	return engine.newOrderedChannel(&verificationEngine, maxPending)
+}
+
+func (engine *CryptoEngine) newOrderedChannel(peer *VerificationEngine, maxPending int) *OrderedChannel {
+	if maxPending <= 0 {
+		maxPending = orderedMaxPending
+	}
+	return &OrderedChannel{
+		engine:     engine,
+		peer:       peer,
+		maxPending: maxPending,
+		next:       1,
+		pending:    make(map[uint64]*Message),
+	}
+}
+
+// This method decrypts the message and returns the messages which can be delivered in order, possibly none.
+// When the limit of buffered messages is exceeded, the channel skips the gap and returns the delivered messages
+// together with a GapError.
+func (channel *OrderedChannel) Receive(data []byte) ([]*Message, error) {
+	var msg *Message
+	var header MessageHeader
+	var err error
+	if channel.peer == nil {
+		msg, header, err = channel.engine.DecryptWithHeader(data)
+	} else {
+		msg, header, err = channel.engine.DecryptWithHeaderAndPublicKey(data, *channel.peer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	return channel.receive(msg, header.Sequence)
+}
+
+// This method returns the missing sequence numbers the buffered messages wait for, ok is false when there is no gap
+func (channel *OrderedChannel) Gap() (from, to uint64, ok bool) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+
+	if len(channel.pending) == 0 {
+		return 0, 0, false
+	}
+	return channel.next, channel.firstPending() - 1, true
+}
+
+// This method gives up on the current gap and returns the buffered messages which can now be delivered
+func (channel *OrderedChannel) Skip() []*Message {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+
+	if len(channel.pending) == 0 {
+		return nil
+	}
+	channel.next = channel.firstPending()
+	return channel.deliver()
+}
+
+// This method returns the sequence number of the next message to deliver
+func (channel *OrderedChannel) Next() uint64 {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	return channel.next
+}
+
+// This method returns the amount of messages buffered behind a gap
+func (channel *OrderedChannel) Pending() int {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	return len(channel.pending)
+}
+
+func (channel *OrderedChannel) receive(msg *Message, sequence uint64) ([]*Message, error) {
+	if sequence == 0 {
+		return nil, SequenceError
+	}
+	if _, ok := channel.pending[sequence]; ok || sequence < channel.next {
+		return nil, DuplicateSequenceError
+	}
+	channel.pending[sequence] = msg
+	delivered := channel.deliver()
+
+	if len(channel.pending) <= channel.maxPending {
+		return delivered, nil
+	}
+	gap := &GapError{From: channel.next, To: channel.firstPending() - 1, Err: MessageGapError}
+	channel.next = gap.To + 1
+	return append(delivered, channel.deliver()...), gap
+}
+
+// removes the consecutive messages from the next sequence number
+func (channel *OrderedChannel) deliver() []*Message {
+	var delivered []*Message
+	for {
+		msg, ok := channel.pending[channel.next]
+		if !ok {
+			return delivered
+		}
+		delete(channel.pending, channel.next)
+		delivered = append(delivered, msg)
+		channel.next++
+	}
+}
+
+// the lowest buffered sequence number, the pending messages cannot be empty
+func (channel *OrderedChannel) firstPending() uint64 {
+	first := uint64(0)
+	for sequence := range channel.pending {
+		if first == 0 || sequence < first {
+			first = sequence
+		}
+	}
+	return first
+}
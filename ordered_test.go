@@ -0,0 +1,154 @@
+package cryptoengine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestOrderedChannel(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Ordered", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := make([][]byte, 6)
+	for i := range frames {
+		msg, err := NewMessage(fmt.Sprintf("message %d", i+1), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frames[i], err = engine.NewEncryptedMessageWithHeader(msg, MessageHeader{Sequence: uint64(i + 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	channel := engine.NewOrderedChannel(2)
+	expectTexts := func(delivered []*Message, texts ...string) {
+		t.Helper()
+		if len(delivered) != len(texts) {
+			t.Fatalf("Expected %d messages, got %d\n", len(texts), len(delivered))
+		}
+		for i, msg := range delivered {
+			if msg.Text != texts[i] {
+				t.Fatalf("Expected %q, got %q\n", texts[i], msg.Text)
+			}
+		}
+	}
+
+	// the third message arrives before the second one
+	delivered, err := channel.Receive(frames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTexts(delivered, "message 1")
+
+	delivered, err = channel.Receive(frames[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTexts(delivered)
+	if from, to, ok := channel.Gap(); !ok || from != 2 || to != 2 {
+		t.Fatalf("Expected the gap 2 to 2, got %d to %d\n", from, to)
+	}
+
+	delivered, err = channel.Receive(frames[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTexts(delivered, "message 2", "message 3")
+	if _, _, ok := channel.Gap(); ok || channel.Next() != 4 {
+		t.Fatal("The channel should have no gap")
+	}
+
+	// the duplicates are rejected
+	if _, err := channel.Receive(frames[1]); err != DuplicateSequenceError {
+		t.Fatalf("Expected %v, got %v\n", DuplicateSequenceError, err)
+	}
+
+	// the fourth message is lost: the channel skips it once the buffer is full
+	if _, err := channel.Receive(frames[4]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := channel.Receive(frames[4]); err != DuplicateSequenceError {
+		t.Fatalf("Expected %v, got %v\n", DuplicateSequenceError, err)
+	}
+	if _, err := channel.Receive(frames[5]); err != nil {
+		t.Fatal(err)
+	}
+	if channel.Pending() != 2 {
+		t.Fatalf("Expected 2 pending messages, got %d\n", channel.Pending())
+	}
+
+	msg, err := NewMessage("message 8", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	late, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{Sequence: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivered, err = channel.Receive(late)
+	var gap *GapError
+	if !errors.As(err, &gap) || !errors.Is(err, MessageGapError) || gap.From != 4 || gap.To != 4 {
+		t.Fatalf("Expected the gap 4 to 4, got %v\n", err)
+	}
+	expectTexts(delivered, "message 5", "message 6")
+
+	// the application gives up on the seventh message
+	expectTexts(channel.Skip(), "message 8")
+	if channel.Next() != 9 || channel.Pending() != 0 {
+		t.Fatalf("The channel should expect the ninth message, got %d\n", channel.Next())
+	}
+
+	// the messages without a sequence number are rejected
+	unnumbered, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := channel.Receive(unnumbered); err != SequenceError {
+		t.Fatalf("Expected %v, got %v\n", SequenceError, err)
+	}
+
+}
+
+func TestOrderedChannelWithPubKey(t *testing.T) {
+
+	sender, err := InitCryptoEngineWithConfig("Sec51OrderedSender", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := InitCryptoEngineWithConfig("Sec51OrderedReceiver", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderVerificationEngine, err := NewVerificationEngineWithKey(sender.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiverVerificationEngine, err := NewVerificationEngineWithKey(receiver.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channel := receiver.NewOrderedChannelWithPubKey(0, senderVerificationEngine)
+	for _, sequence := range []uint64{2, 1} {
+		msg, err := NewMessage(fmt.Sprintf("message %d", sequence), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := sender.NewEncryptedMessageWithHeaderAndPubKey(msg, MessageHeader{Sequence: sequence}, receiverVerificationEngine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		delivered, err := channel.Receive(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sequence == 1 && (len(delivered) != 2 || delivered[0].Text != "message 1" || delivered[1].Text != "message 2") {
+			t.Fatalf("The messages are not delivered in order: %v\n", delivered)
+		}
+	}
+
+}
@@ -0,0 +1,224 @@
+package cryptoengine
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Pairing with a numeric verification code: the two peers exchange their public keys over an untrusted channel,
+// both display the same 6 to 8 digits code, and the users compare the codes before confirming the pairing.
+// A man in the middle substitutes the keys, so the two peers display different codes.
+// The code is derived from the public and signing public keys of both peers and a random nonce of each peer. The initiator commits
+// to its keys and its nonce before seeing the nonce of the responder, and reveals the nonce afterwards, so the attacker cannot choose
+// its keys or its nonce to make the codes match: it succeeds only with a probability of one over the amount of codes.
+//
+//	initiator -> responder: commit  (1)|public key|signing public key|SHA-256 commitment of the initiator keys and nonce
+//	responder -> initiator: respond (2)|public key|signing public key|responder nonce
+//	initiator -> responder: reveal  (3)|initiator nonce
+//
+// Once both peers display the code, the users compare them and ConfirmPairing registers the peer.
+
+const (
+	pairingCommit  = 1
+	pairingRespond = 2
+	pairingReveal  = 3
+
+	pairingNonceSize   = 32
+	pairingCommitSize  = 1 + 2*keySize + HashSize
+	pairingRespondSize = 1 + 2*keySize + pairingNonceSize
+	pairingRevealSize  = 1 + pairingNonceSize
+
+	pairingCommitmentLabel = "cryptoengine pairing commitment"
+	pairingCodeLabel       = "cryptoengine pairing code"
+
+	MinPairingDigits = 6
+	MaxPairingDigits = 8
+)
+
+var (
+	PairingError       = errors.New("The pairing message is not valid")
+	PairingStateError  = errors.New("The pairing is not in the expected state")
+	PairingDigitsError = errors.New("The pairing code must have between 6 and 8 digits")
+	PairingCommitError = errors.New("The revealed nonce does not match the commitment: the pairing has been tampered with")
+	PairingRejectError = errors.New("The pairing codes do not match")
+	pairingCodeModulus = [...]uint64{MinPairingDigits: 1000000, 10000000, 100000000}
+)
+
+// pairing states
+const (
+	pairingWaitingRespond = iota
+	pairingWaitingReveal
+	pairingCompleted
+	pairingFailed
+)
+
+// The Pairing holds the state of a pairing with a peer
+type Pairing struct {
+	engine     *CryptoEngine
+	initiator  bool
+	digits     int
+	state      int
+	peer       VerificationEngine
+	nonce      [pairingNonceSize]byte // the nonce of this peer
+	peerNonce  [pairingNonceSize]byte // the nonce of the other peer
+	commitment [HashSize]byte         // the commitment of the initiator nonce
+}
+
+// This method starts the pairing and returns the commit message to send to the peer
+func (engine *CryptoEngine) StartPairing(digits int) (*Pairing, []byte, error) {
+	pairing, err := engine.newPairing(true, digits)
+	if err != nil {
+		return nil, nil, err
+	}
+	pairing.commitment = pairing.commit(engine.publicKey, engine.SigningPublicKey(), pairing.nonce)
+	pairing.state = pairingWaitingRespond
+
+	message := make([]byte, 0, pairingCommitSize)
+	message = append(message, pairingCommit)
+	message = append(message, engine.publicKey[:]...)
+	message = append(message, engine.SigningPublicKey()...)
+	message = append(message, pairing.commitment[:]...)
+	return pairing, message, nil
+}
+
+// This method answers the commit message of the initiator and returns the respond message to send back
+func (engine *CryptoEngine) RespondPairing(commit []byte, digits int) (*Pairing, []byte, error) {
+	if len(commit) != pairingCommitSize || commit[0] != pairingCommit {
+		return nil, nil, PairingError
+	}
+	pairing, err := engine.newPairing(false, digits)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pairing.peer, err = NewVerificationEngineWithKeys(commit[1:1+keySize], commit[1+keySize:1+2*keySize]); err != nil {
+		return nil, nil, PairingError
+	}
+	copy(pairing.commitment[:], commit[1+2*keySize:])
+	pairing.state = pairingWaitingReveal
+
+	message := make([]byte, 0, pairingRespondSize)
+	message = append(message, pairingRespond)
+	message = append(message, engine.publicKey[:]...)
+	message = append(message, engine.SigningPublicKey()...)
+	message = append(message, pairing.nonce[:]...)
+	return pairing, message, nil
+}
+
+// This method processes the respond message on the initiator side and returns the reveal message to send to the peer.
+// From now on the initiator can display the code.
+func (p *Pairing) Reveal(respond []byte) ([]byte, error) {
+	if !p.initiator || p.state != pairingWaitingRespond {
+		return nil, PairingStateError
+	}
+	if len(respond) != pairingRespondSize || respond[0] != pairingRespond {
+		p.state = pairingFailed
+		return nil, PairingError
+	}
+	peer, err := NewVerificationEngineWithKeys(respond[1:1+keySize], respond[1+keySize:1+2*keySize])
+	if err != nil {
+		p.state = pairingFailed
+		return nil, PairingError
+	}
+	p.peer = peer
+	copy(p.peerNonce[:], respond[1+2*keySize:])
+	p.state = pairingCompleted
+
+	message := make([]byte, 0, pairingRevealSize)
+	message = append(message, pairingReveal)
+	return append(message, p.nonce[:]...), nil
+}
+
+// This method verifies the reveal message on the responder side against the commitment.
+// From now on the responder can display the code.
+func (p *Pairing) Finish(reveal []byte) error {
+	if p.initiator || p.state != pairingWaitingReveal {
+		return PairingStateError
+	}
+	if len(reveal) != pairingRevealSize || reveal[0] != pairingReveal {
+		p.state = pairingFailed
+		return PairingError
+	}
+	copy(p.peerNonce[:], reveal[1:])
+
+	expected := p.commit(p.peer.publicKey, p.peer.signingPublicKey[:], p.peerNonce)
+	if subtle.ConstantTimeCompare(expected[:], p.commitment[:]) != 1 {
+		p.state = pairingFailed
+		return PairingCommitError
+	}
+	p.state = pairingCompleted
+	return nil
+}
+
+// This method returns the code to display, both peers display the same code when nobody tampered with the pairing
+func (p *Pairing) Code() (string, error) {
+	if p.state != pairingCompleted {
+		return "", PairingStateError
+	}
+
+	// the initiator values first, on both sides
+	initiatorKey, responderKey := p.engine.publicKey, p.peer.publicKey
+	initiatorSigningKey, responderSigningKey := p.engine.SigningPublicKey(), p.peer.signingPublicKey[:]
+	initiatorNonce, responderNonce := p.nonce, p.peerNonce
+	if !p.initiator {
+		initiatorKey, responderKey = responderKey, initiatorKey
+		initiatorSigningKey, responderSigningKey = responderSigningKey, initiatorSigningKey
+		initiatorNonce, responderNonce = responderNonce, initiatorNonce
+	}
+
+	data := make([]byte, 0, 4*keySize+2*pairingNonceSize+1)
+	data = append(data, initiatorKey[:]...)
+	data = append(data, initiatorSigningKey...)
+	data = append(data, responderKey[:]...)
+	data = append(data, responderSigningKey...)
+	data = append(data, initiatorNonce[:]...)
+	data = append(data, responderNonce[:]...)
+	data = append(data, byte(p.digits))
+	sum := HashWithContext(pairingCodeLabel, data)
+
+	code := binary.BigEndian.Uint64(sum[:8]) % pairingCodeModulus[p.digits]
+	return fmt.Sprintf("%0*d", p.digits, code), nil
+}
+
+// This method returns the public keys of the peer. They must not be trusted before the codes have been compared.
+func (p *Pairing) Peer() VerificationEngine {
+	return p.peer
+}
+
+// This method records the comparison of the codes by the user: when they match the peer is registered in the store
+// under the context, see RegisterPeer, otherwise the pairing fails with PairingRejectError
+func (p *Pairing) ConfirmPairing(store KeyStore, context string, codesMatch bool) error {
+	if p.state != pairingCompleted {
+		return PairingStateError
+	}
+	if !codesMatch {
+		p.state = pairingFailed
+		return PairingRejectError
+	}
+	return RegisterPeer(store, context, p.peer)
+}
+
+func (engine *CryptoEngine) newPairing(initiator bool, digits int) (*Pairing, error) {
+	if digits < MinPairingDigits || digits > MaxPairingDigits {
+		return nil, PairingDigitsError
+	}
+	if err := engine.allow(operationOwnKeys); err != nil {
+		return nil, err
+	}
+	pairing := &Pairing{engine: engine, initiator: initiator, digits: digits}
+	if _, err := rand.Read(pairing.nonce[:]); err != nil {
+		return nil, err
+	}
+	return pairing, nil
+}
+
+// the commitment of the initiator to its public key, its signing public key and its nonce
+func (p *Pairing) commit(publicKey [keySize]byte, signingPublicKey []byte, nonce [pairingNonceSize]byte) [HashSize]byte {
+	data := make([]byte, 0, 2*keySize+pairingNonceSize)
+	data = append(data, publicKey[:]...)
+	data = append(data, signingPublicKey...)
+	data = append(data, nonce[:]...)
+	return HashWithContext(pairingCommitmentLabel, data)
+}
@@ -0,0 +1,208 @@
+package cryptoengine
+
+import (
+	"testing"
+)
+
+func TestPairing(t *testing.T) {
+
+	initiatorEngine, err := InitCryptoEngineWithConfig("Sec51PairingInitiator", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	responderEngine, err := InitCryptoEngineWithConfig("Sec51PairingResponder", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initiator, commit, err := initiatorEngine.StartPairing(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, respond, err := responderEngine.RespondPairing(commit, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the responder cannot display the code before the reveal
+	if _, err := responder.Code(); err != PairingStateError {
+		t.Fatalf("Expected %v, got %v\n", PairingStateError, err)
+	}
+
+	reveal, err := initiator.Reveal(respond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := responder.Finish(reveal); err != nil {
+		t.Fatal(err)
+	}
+
+	initiatorCode, err := initiator.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	responderCode, err := responder.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(initiatorCode) != 6 || initiatorCode != responderCode {
+		t.Fatalf("The pairing codes do not match: %s %s\n", initiatorCode, responderCode)
+	}
+
+	// the confirmed peer is registered in the store
+	store := NewMemoryKeyStore()
+	if err := responder.ConfirmPairing(store, "initiator", true); err != nil {
+		t.Fatal(err)
+	}
+	peer, err := NewVerificationEngineFromStore(store, "initiator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.PublicKey() != initiatorEngine.publicKey {
+		t.Fatal("The registered peer does not match the initiator")
+	}
+
+	// a rejected pairing fails
+	if err := initiator.ConfirmPairing(NewMemoryKeyStore(), "responder", false); err != PairingRejectError {
+		t.Fatalf("Expected %v, got %v\n", PairingRejectError, err)
+	}
+	if _, err := initiator.Code(); err != PairingStateError {
+		t.Fatalf("Expected %v, got %v\n", PairingStateError, err)
+	}
+
+}
+
+func TestPairingTampered(t *testing.T) {
+
+	initiatorEngine, err := InitCryptoEngineWithConfig("Sec51PairingInitiator", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	responderEngine, err := InitCryptoEngineWithConfig("Sec51PairingResponder", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attackerEngine, err := InitCryptoEngineWithConfig("Sec51PairingAttacker", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := initiatorEngine.StartPairing(5); err != PairingDigitsError {
+		t.Fatalf("Expected %v, got %v\n", PairingDigitsError, err)
+	}
+
+	// the attacker substitutes its own keys on both sides
+	initiator, commit, err := initiatorEngine.StartPairing(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attackerResponder, attackerRespond, err := attackerEngine.RespondPairing(commit, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attackerInitiator, attackerCommit, err := attackerEngine.StartPairing(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, respond, err := responderEngine.RespondPairing(attackerCommit, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reveal, err := initiator.Reveal(attackerRespond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := attackerResponder.Finish(reveal); err != nil {
+		t.Fatal(err)
+	}
+	attackerReveal, err := attackerInitiator.Reveal(respond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := responder.Finish(attackerReveal); err != nil {
+		t.Fatal(err)
+	}
+
+	initiatorCode, err := initiator.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	responderCode, err := responder.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(initiatorCode) != 8 || initiatorCode == responderCode {
+		t.Fatalf("The pairing codes should not match: %s %s\n", initiatorCode, responderCode)
+	}
+
+	// a reveal which does not match the commitment is rejected
+	initiator, commit, err = initiatorEngine.StartPairing(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, respond, err = responderEngine.RespondPairing(commit, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reveal, err = initiator.Reveal(respond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reveal[len(reveal)-1] ^= 0xff
+	if err := responder.Finish(reveal); err != PairingCommitError {
+		t.Fatalf("Expected %v, got %v\n", PairingCommitError, err)
+	}
+
+	// the attacker substitutes only the signing key of the initiator: the commitment does not match
+	initiator, commit, err = initiatorEngine.StartPairing(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(commit[1+keySize:], attackerEngine.SigningPublicKey())
+	responder, respond, err = responderEngine.RespondPairing(commit, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reveal, err = initiator.Reveal(respond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := responder.Finish(reveal); err != PairingCommitError {
+		t.Fatalf("Expected %v, got %v\n", PairingCommitError, err)
+	}
+
+	// the attacker substitutes only the signing key of the responder: the codes do not match
+	initiator, commit, err = initiatorEngine.StartPairing(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, respond, err = responderEngine.RespondPairing(commit, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(respond[1+keySize:], attackerEngine.SigningPublicKey())
+	reveal, err = initiator.Reveal(respond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := responder.Finish(reveal); err != nil {
+		t.Fatal(err)
+	}
+	initiatorCode, err = initiator.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	responderCode, err = responder.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if initiatorCode == responderCode {
+		t.Fatalf("The pairing codes should not match: %s %s\n", initiatorCode, responderCode)
+	}
+
+	if _, _, err := responderEngine.RespondPairing(commit[1:], 6); err != PairingError {
+		t.Fatalf("Expected %v, got %v\n", PairingError, err)
+	}
+
+}
@@ -0,0 +1,308 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+	"strings"
+	"time"
+)
+
+// PASETO version 4 tokens (https://github.com/paseto-standard/paseto-spec).
+// - v4.local tokens are encrypted with a key derived from the engine secret key, so they can be opened by engines sharing the same secret
+// - v4.public tokens are signed with the engine Ed25519 signing key and verified with the VerificationEngine
+// When the payload is a JSON object, the registered "exp" and "nbf" claims (RFC 3339 timestamps) are enforced.
+
+const (
+	pasetoLocalHeader  = "v4.local."
+	pasetoPublicHeader = "v4.public."
+	pasetoNonceSize    = 32
+	pasetoMacSize      = 32
+	pasetoSubKeyLabel  = "paseto v4.local"
+)
+
+var (
+	PasetoFormatError  = errors.New("The PASETO token is not valid")
+	PasetoExpiredError = errors.New("The PASETO token is expired or not valid yet")
+
+	pasetoEncoding = base64.RawURLEncoding.Strict() // a token has a single encoding
+)
+
+// This method creates a v4.local token: the payload is encrypted and authenticated, the footer and the implicit assertion only authenticated.
+// The footer is visible in the token, the implicit assertion is not part of the token and must be provided again to decrypt it.
+func (engine *CryptoEngine) EncryptPaseto(payload, footer, implicit []byte) (string, error) {
+	if err := engine.allow(operationEncrypt); err != nil {
+		return "", err
+	}
+
+	key, err := engine.deriveSubKey(pasetoSubKeyLabel)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, pasetoNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return pasetoEncrypt(key[:], nonce, payload, footer, implicit)
+}
+
+// This method decrypts a v4.local token, verifies the time claims and returns the payload and the footer
+func (engine *CryptoEngine) DecryptPaseto(token string, implicit []byte) ([]byte, []byte, error) {
+
+	key, err := engine.deriveSubKey(pasetoSubKeyLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	payload, footer, err := pasetoDecrypt(key[:], token, implicit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkPasetoClaims(payload); err != nil {
+		return nil, nil, err
+	}
+
+	return payload, footer, nil
+}
+
+// encrypts the v4.local token with the key and the nonce
+func pasetoEncrypt(key, nonce, payload, footer, implicit []byte) (string, error) {
+
+	encryptionKey, counterNonce, authKey, err := pasetoSplitKey(key, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	stream, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(payload))
+	stream.XORKeyStream(ciphertext, payload)
+
+	tag, err := pasetoMac(authKey, pae([]byte(pasetoLocalHeader), nonce, ciphertext, footer, implicit))
+	if err != nil {
+		return "", err
+	}
+
+	body := append(append(append([]byte{}, nonce...), ciphertext...), tag...)
+	return pasetoToken(pasetoLocalHeader, body, footer), nil
+}
+
+// decrypts the v4.local token with the key, the time claims are not verified
+func pasetoDecrypt(key []byte, token string, implicit []byte) ([]byte, []byte, error) {
+
+	body, footer, err := pasetoSplit(token, pasetoLocalHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(body) < pasetoNonceSize+pasetoMacSize {
+		return nil, nil, PasetoFormatError
+	}
+
+	nonce := body[:pasetoNonceSize]
+	ciphertext := body[pasetoNonceSize : len(body)-pasetoMacSize]
+	tag := body[len(body)-pasetoMacSize:]
+
+	encryptionKey, counterNonce, authKey, err := pasetoSplitKey(key, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expectedTag, err := pasetoMac(authKey, pae([]byte(pasetoLocalHeader), nonce, ciphertext, footer, implicit))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !hmac.Equal(tag, expectedTag) {
+		return nil, nil, MessageDecryptionError
+	}
+
+	stream, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	payload := make([]byte, len(ciphertext))
+	stream.XORKeyStream(payload, ciphertext)
+
+	return payload, footer, nil
+}
+
+// This method creates a v4.public token: the payload is signed, not encrypted
+func (engine *CryptoEngine) SignPaseto(payload, footer, implicit []byte) (string, error) {
+	signature := engine.Sign(pae([]byte(pasetoPublicHeader), payload, footer, implicit))
+	body := append(append([]byte{}, payload...), signature...)
+	return pasetoToken(pasetoPublicHeader, body, footer), nil
+}
+
+// This method verifies a v4.public token with the peer signing public key and returns the payload and the footer
+func (e VerificationEngine) VerifyPaseto(token string, implicit []byte) ([]byte, []byte, error) {
+
+	payload, footer, err := e.verifyPaseto(token, implicit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkPasetoClaims(payload); err != nil {
+		return nil, nil, err
+	}
+
+	return payload, footer, nil
+}
+
+// verifies the signature of the v4.public token, the time claims are not verified
+func (e VerificationEngine) verifyPaseto(token string, implicit []byte) ([]byte, []byte, error) {
+
+	body, footer, err := pasetoSplit(token, pasetoPublicHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(body) < ed25519.SignatureSize {
+		return nil, nil, PasetoFormatError
+	}
+
+	payload := body[:len(body)-ed25519.SignatureSize]
+	signature := body[len(body)-ed25519.SignatureSize:]
+
+	if err := e.Verify(pae([]byte(pasetoPublicHeader), payload, footer, implicit), signature); err != nil {
+		return nil, nil, err
+	}
+
+	return payload, footer, nil
+}
+
+// Pre-Authentication Encoding: the number of pieces and each piece prefixed by its length, as 64 bits little endian with the MSB cleared
+func pae(pieces ...[]byte) []byte {
+	var buffer bytes.Buffer
+	var length [8]byte
+
+	binary.LittleEndian.PutUint64(length[:], uint64(len(pieces))&^(1<<63))
+	buffer.Write(length[:])
+	for _, piece := range pieces {
+		binary.LittleEndian.PutUint64(length[:], uint64(len(piece))&^(1<<63))
+		buffer.Write(length[:])
+		buffer.Write(piece)
+	}
+	return buffer.Bytes()
+}
+
+// derives the encryption key, the XChaCha20 nonce and the authentication key from the key and the random nonce
+func pasetoSplitKey(key, nonce []byte) ([]byte, []byte, []byte, error) {
+	encryption, err := blake2b.New(chacha20.KeySize+chacha20.NonceSizeX, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	encryption.Write([]byte("paseto-encryption-key"))
+	encryption.Write(nonce)
+	tmp := encryption.Sum(nil)
+
+	authKey, err := pasetoMac(key, append([]byte("paseto-auth-key-for-aead"), nonce...))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return tmp[:chacha20.KeySize], tmp[chacha20.KeySize:], authKey, nil
+}
+
+// keyed BLAKE2b with a 32 bytes output
+func pasetoMac(key, data []byte) ([]byte, error) {
+	mac, err := blake2b.New(pasetoMacSize, key)
+	if err != nil {
+		return nil, err
+	}
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// header || base64url(body) [ || "." || base64url(footer) ]
+func pasetoToken(header string, body, footer []byte) string {
+	token := header + pasetoEncoding.EncodeToString(body)
+	if len(footer) > 0 {
+		token += "." + pasetoEncoding.EncodeToString(footer)
+	}
+	return token
+}
+
+// checks the header and returns the decoded body and footer
+func pasetoSplit(token, header string) ([]byte, []byte, error) {
+	if !strings.HasPrefix(token, header) {
+		return nil, nil, PasetoFormatError
+	}
+
+	parts := strings.Split(token[len(header):], ".")
+	if len(parts) > 2 {
+		return nil, nil, PasetoFormatError
+	}
+
+	body, err := pasetoEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, PasetoFormatError
+	}
+
+	var footer []byte
+	if len(parts) == 2 {
+		if footer, err = pasetoEncoding.DecodeString(parts[1]); err != nil {
+			return nil, nil, PasetoFormatError
+		}
+	}
+
+	return body, footer, nil
+}
+
+// enforces the exp and nbf claims when the payload is a JSON object
+func checkPasetoClaims(payload []byte) error {
+	var claims map[string]json.RawMessage
+
+	// not a JSON object: nothing to check
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	if value, ok := claims["exp"]; ok {
+		expiration, err := pasetoTime(value)
+		if err != nil {
+			return err
+		}
+		if now.After(expiration) {
+			return PasetoExpiredError
+		}
+	}
+
+	if value, ok := claims["nbf"]; ok {
+		notBefore, err := pasetoTime(value)
+		if err != nil {
+			return err
+		}
+		if now.Before(notBefore) {
+			return PasetoExpiredError
+		}
+	}
+
+	return nil
+}
+
+// a time claim must be an RFC3339 string, a claim of another type is not ignored
+func pasetoTime(value json.RawMessage) (time.Time, error) {
+	var text string
+	if err := json.Unmarshal(value, &text); err != nil {
+		return time.Time{}, PasetoFormatError
+	}
+	t, err := time.Parse(time.RFC3339, text)
+	if err != nil {
+		return time.Time{}, PasetoFormatError
+	}
+	return t, nil
+}
@@ -0,0 +1,223 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasetoLocal(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"sub":"sec51","exp":"` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`)
+	footer := []byte(`{"kid":"sec51"}`)
+	implicit := []byte("session")
+
+	token, err := engine.EncryptPaseto(payload, footer, implicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(token, "v4.local.") {
+		t.Fatalf("Unexpected PASETO token: %s\n", token)
+	}
+
+	decrypted, decryptedFooter, err := engine.DecryptPaseto(token, implicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, payload) || !bytes.Equal(decryptedFooter, footer) {
+		t.Fatal("PASETO v4.local encryption/decryption broken")
+	}
+
+	// the implicit assertion must match
+	if _, _, err := engine.DecryptPaseto(token, []byte("other")); err != MessageDecryptionError {
+		t.Errorf("The expected error is: MessageDecryptionError, instead we've got: %v\n", err)
+	}
+
+	// an expired token
+	expired, err := engine.EncryptPaseto([]byte(`{"exp":"2015-01-01T00:00:00Z"}`), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := engine.DecryptPaseto(expired, nil); err != PasetoExpiredError {
+		t.Errorf("The expected error is: PasetoExpiredError, instead we've got: %v\n", err)
+	}
+
+	// the time claims which are not RFC3339 strings are rejected, not skipped
+	for _, payload := range []string{`{"exp":1700000000}`, `{"nbf":null}`, `{"sub":"sec51","exp":"tomorrow"}`} {
+		invalid, err := engine.EncryptPaseto([]byte(payload), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := engine.DecryptPaseto(invalid, nil); err != PasetoFormatError {
+			t.Errorf("The expected error is: PasetoFormatError, instead we've got: %v\n", err)
+		}
+	}
+
+	// a payload which is not a JSON object has no claims to check
+	raw, err := engine.EncryptPaseto([]byte("not json"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := engine.DecryptPaseto(raw, nil); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestPasetoPublic(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verificationEngine, err := NewVerificationEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"sub":"sec51"}`)
+	token, err := engine.SignPaseto(payload, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(token, "v4.public.") {
+		t.Fatalf("Unexpected PASETO token: %s\n", token)
+	}
+
+	verified, _, err := verificationEngine.VerifyPaseto(token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(verified, payload) {
+		t.Fatal("PASETO v4.public signing/verification broken")
+	}
+
+	// a v4.local token is not a v4.public one
+	if _, _, err := verificationEngine.VerifyPaseto(strings.Replace(token, "public", "local", 1), nil); err != PasetoFormatError {
+		t.Errorf("The expected error is: PasetoFormatError, instead we've got: %v\n", err)
+	}
+
+	// a token signed by another engine
+	otherEngine, err := InitCryptoEngine("Sec51Peer2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged, err := otherEngine.SignPaseto(payload, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := verificationEngine.VerifyPaseto(forged, nil); err != SignatureError {
+		t.Errorf("The expected error is: SignatureError, instead we've got: %v\n", err)
+	}
+
+}
+
+// test vectors of the PASETO specification (https://github.com/paseto-standard/test-vectors/blob/master/v4.json):
+// the key is the v4.local key or the seed of the v4.public secret key, the vectors without payload must fail
+var pasetoTestVectors = []struct {
+	name     string
+	public   bool
+	key      string
+	nonce    string
+	token    string
+	payload  string
+	footer   string
+	implicit string
+}{
+	{"4-E-1", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "0000000000000000000000000000000000000000000000000000000000000000", "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg", "{\"data\":\"this is a secret message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "", ""},
+	{"4-E-2", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "0000000000000000000000000000000000000000000000000000000000000000", "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvS2csCgglvpk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XIemu9chy3WVKvRBfg6t8wwYHK0ArLxxfZP73W_vfwt5A", "{\"data\":\"this is a hidden message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "", ""},
+	{"4-E-3", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8", "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6-tyebyWG6Ov7kKvBdkrrAJ837lKP3iDag2hzUPHuMKA", "{\"data\":\"this is a secret message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "", ""},
+	{"4-E-4", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8", "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4gt6TiLm55vIH8c_lGxxZpE3AWlH4WTR0v45nsWoU3gQ", "{\"data\":\"this is a hidden message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "", ""},
+	{"4-E-5", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8", "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4x-RMNXtQNbz7FvFZ_G-lFpk5RG3EOrwDL6CgDqcerSQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9", "{\"data\":\"this is a secret message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}", ""},
+	{"4-E-6", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8", "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6pWSA5HX2wjb3P-xLQg5K5feUCX4P2fpVK3ZLWFbMSxQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9", "{\"data\":\"this is a hidden message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}", ""},
+	{"4-E-7", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8", "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t40KCCWLA7GYL9KFHzKlwY9_RnIfRrMQpueydLEAZGGcA.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9", "{\"data\":\"this is a secret message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}", "{\"test-vector\":\"4-E-7\"}"},
+	{"4-E-8", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8", "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t5uvqQbMGlLLNYBc7A6_x7oqnpUK5WLvj24eE4DVPDZjw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9", "{\"data\":\"this is a hidden message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}", "{\"test-vector\":\"4-E-8\"}"},
+	{"4-E-9", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8", "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6tybdlmnMwcDMw0YxA_gFSE_IUWl78aMtOepFYSWYfQA.YXJiaXRyYXJ5LXN0cmluZy10aGF0LWlzbid0LWpzb24", "{\"data\":\"this is a hidden message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "arbitrary-string-that-isn't-json", "{\"test-vector\":\"4-E-9\"}"},
+	{"4-S-1", true, "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774", "", "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA", "{\"data\":\"this is a signed message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "", ""},
+	{"4-S-2", true, "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774", "", "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9v3Jt8mx_TdM2ceTGoqwrh4yDFn0XsHvvV_D0DtwQxVrJEBMl0F2caAdgnpKlt4p7xBnx1HcO-SPo8FPp214HDw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9", "{\"data\":\"this is a signed message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}", ""},
+	{"4-S-3", true, "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774", "", "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9NPWciuD3d0o5eXJXG5pJy-DiVEoyPYWs1YSTwWHNJq6DZD3je5gf-0M4JR9ipdUSJbIovzmBECeaWmaqcaP0DQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9", "{\"data\":\"this is a signed message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}", "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}", "{\"test-vector\":\"4-S-3\"}"},
+	{"4-F-1", true, "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774", "", "v4.local.vngXfCISbnKgiP6VWGuOSlYrFYU300fy9ijW33rznDYgxHNPwWluAY2Bgb0z54CUs6aYYkIJ-bOOOmJHPuX_34Agt_IPlNdGDpRdGNnBz2MpWJvB3cttheEc1uyCEYltj7wBQQYX.YXJiaXRyYXJ5LXN0cmluZy10aGF0LWlzbid0LWpzb24", "", "arbitrary-string-that-isn't-json", "{\"test-vector\":\"4-F-1\"}"},
+	{"4-F-2", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8", "v4.public.eyJpbnZhbGlkIjoidGhpcyBzaG91bGQgbmV2ZXIgZGVjb2RlIn22Sp4gjCaUw0c7EH84ZSm_jN_Qr41MrgLNu5LIBCzUr1pn3Z-Wukg9h3ceplWigpoHaTLcwxj0NsI1vjTh67YB.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9", "", "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}", "{\"test-vector\":\"4-F-2\"}"},
+	{"4-F-3", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "26f7553354482a1d91d4784627854b8da6b8042a7966523c2b404e8dbbe7f7f2", "v3.local.23e_2PiqpQBPvRFKzB0zHhjmxK3sKo2grFZRRLM-U7L0a8uHxuF9RlVz3Ic6WmdUUWTxCaYycwWV1yM8gKbZB2JhygDMKvHQ7eBf8GtF0r3K0Q_gF1PXOxcOgztak1eD1dPe9rLVMSgR0nHJXeIGYVuVrVoLWQ.YXJiaXRyYXJ5LXN0cmluZy10aGF0LWlzbid0LWpzb24", "", "arbitrary-string-that-isn't-json", "{\"test-vector\":\"4-F-3\"}"},
+	{"4-F-4", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8", "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQh", "", "", ""},
+	{"4-F-5", false, "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f", "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8", "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4x-RMNXtQNbz7FvFZ_G-lFpk5RG3EOrwDL6CgDqcerSQ==.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9", "", "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}", ""},
+}
+
+func TestPasetoTestVectors(t *testing.T) {
+
+	for _, vector := range pasetoTestVectors {
+		key, err := hex.DecodeString(vector.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if vector.public {
+			engine := &CryptoEngine{signingKey: ed25519.NewKeyFromSeed(key)}
+			var verificationEngine VerificationEngine
+			copy(verificationEngine.signingPublicKey[:], engine.SigningPublicKey())
+
+			payload, footer, err := verificationEngine.verifyPaseto(vector.token, []byte(vector.implicit))
+			if vector.payload == "" {
+				if err == nil {
+					t.Errorf("%s: the token should not be valid\n", vector.name)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: %s\n", vector.name, err)
+			}
+			if string(payload) != vector.payload || string(footer) != vector.footer {
+				t.Fatalf("%s: the token has not been verified correctly\n", vector.name)
+			}
+
+			token, err := engine.SignPaseto([]byte(vector.payload), []byte(vector.footer), []byte(vector.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != vector.token {
+				t.Fatalf("%s: the token has not been signed correctly\n", vector.name)
+			}
+			continue
+		}
+
+		payload, footer, err := pasetoDecrypt(key, vector.token, []byte(vector.implicit))
+		if vector.payload == "" {
+			if err == nil {
+				t.Errorf("%s: the token should not be valid\n", vector.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s\n", vector.name, err)
+		}
+		if string(payload) != vector.payload || string(footer) != vector.footer {
+			t.Fatalf("%s: the token has not been decrypted correctly\n", vector.name)
+		}
+
+		nonce, _ := hex.DecodeString(vector.nonce)
+		token, err := pasetoEncrypt(key, nonce, []byte(vector.payload), []byte(vector.footer), []byte(vector.implicit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != vector.token {
+			t.Fatalf("%s: the token has not been encrypted correctly\n", vector.name)
+		}
+	}
+
+}
@@ -0,0 +1,172 @@
+package cryptoengine
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"strings"
+)
+
+// Password hashing and password derived engines, with Argon2id.
+// HashPassword returns the hash in the PHC string format, which stores the parameters and the salt with the hash:
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<base64 salt>$<base64 hash>
+//
+// InitCryptoEngineFromPassword derives all the engine keys from the password and a random salt stored in the key store,
+// so the password is the only secret: no private key is ever written to disk.
+// A check value derived from the password is stored as well, to reject a wrong password instead of silently deriving other keys.
+
+const (
+	passwordArgon2Time    = 3
+	passwordArgon2Memory  = 64 * 1024
+	passwordArgon2Threads = 4
+	passwordSaltSize      = 16
+	passwordHashSize      = 32
+)
+
+var (
+	PasswordError           = errors.New("The password is not valid")
+	PasswordHashFormatError = errors.New("The password hash is not valid")
+	passwordEncoding        = base64.RawStdEncoding
+
+	// password derived engines
+	passwordSaltSuffixFormat  = "%s_password_salt.key"  // this is the salt of the password KDF, for instance: sec51_password_salt.key
+	passwordCheckSuffixFormat = "%s_password_check.key" // this is the value which verifies the password, for instance: sec51_password_check.key
+)
+
+// This function hashes the password with Argon2id and a random salt, for storing it
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, passwordArgon2Time, passwordArgon2Memory, passwordArgon2Threads, passwordHashSize)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, passwordArgon2Memory, passwordArgon2Time, passwordArgon2Threads,
+		passwordEncoding.EncodeToString(salt), passwordEncoding.EncodeToString(hash)), nil
+}
+
+// This function verifies the password against a hash returned by HashPassword.
+// The parameters of the hash are used, so the hashes made with older parameters can still be verified.
+func VerifyPassword(password, encoded string) error {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return PasswordHashFormatError
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return PasswordHashFormatError
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time == 0 || threads == 0 {
+		return PasswordHashFormatError
+	}
+
+	salt, err := passwordEncoding.DecodeString(parts[4])
+	if err != nil {
+		return PasswordHashFormatError
+	}
+	hash, err := passwordEncoding.DecodeString(parts[5])
+	if err != nil || len(hash) == 0 {
+		return PasswordHashFormatError
+	}
+
+	if !ConstantTimeEqual(argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(hash))), hash) {
+		return PasswordError
+	}
+	return nil
+}
+
+// This function initializes an engine whose keys are derived from the password.
+// The salt of the password is generated the first time and stored in the keys folder, the same password is needed to initialize the engine again.
+func InitCryptoEngineFromPassword(communicationIdentifier, password string) (*CryptoEngine, error) {
+	return InitCryptoEngineFromPasswordWithConfig(communicationIdentifier, password, Config{})
+}
+
+// This function works exactly like InitCryptoEngineFromPassword, but it allows to tune the behaviour of the engine via the Config struct.
+func InitCryptoEngineFromPasswordWithConfig(communicationIdentifier, password string, config Config) (*CryptoEngine, error) {
+	if password == "" {
+		return nil, PasswordError
+	}
+
+	ce := new(CryptoEngine)
+	ce.config = config
+	context, err := keyContext(communicationIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	ce.context = context
+	store := config.keyStore()
+
+	// the salt is not secret, it only makes the derived keys unique to this engine
+	salt, err := loadOrGenerateKey(store, passwordSaltSuffixFormat, ce.context, generateSecretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	masterKey := argon2.IDKey([]byte(password), salt[:], passwordArgon2Time, passwordArgon2Memory, passwordArgon2Threads, keySize)
+	defer func() {
+		for i := range masterKey {
+			masterKey[i] = 0
+		}
+	}()
+
+	// the check value is stored the first time, then it must match
+	check := hmac.New(sha256.New, masterKey)
+	check.Write([]byte("cryptoengine password check"))
+	var checkValue [keySize]byte
+	copy(checkValue[:], check.Sum(nil))
+	storedCheck, err := loadOrGenerateKey(store, passwordCheckSuffixFormat, ce.context, func() ([keySize]byte, error) {
+		return checkValue, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(storedCheck[:], checkValue[:]) {
+		return nil, PasswordError
+	}
+
+	// each key is expanded from the master key with its own label
+	var signingSeed [keySize]byte
+	derivedKeys := []struct {
+		label string
+		key   *[keySize]byte
+	}{
+		{"secret", &ce.secretKey},
+		{"nonce", &ce.nonceKey},
+		{"salt", &ce.salt},
+		{"private", &ce.privateKey},
+		{"signing", &signingSeed},
+	}
+	for _, derived := range derivedKeys {
+		kdf := hkdf.New(sha256.New, masterKey, nil, []byte("cryptoengine password "+derived.label))
+		if _, err := io.ReadFull(kdf, derived.key[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	publicKey, err := curve25519.X25519(ce.privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	copy(ce.publicKey[:], publicKey)
+
+	ce.signingKey = ed25519.NewKeyFromSeed(signingSeed[:])
+	for i := range signingSeed {
+		signingSeed[i] = 0
+	}
+
+	ce.preSharedKeysMap = make(map[string][keySize]byte)
+	return ce, nil
+}
@@ -0,0 +1,89 @@
+package cryptoengine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+
+	hash, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Fatalf("The password hash format is not valid: %s\n", hash)
+	}
+
+	if err := VerifyPassword("correct horse battery staple", hash); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyPassword("wrong horse battery staple", hash); err != PasswordError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", PasswordError, err)
+	}
+
+	// the salt is random
+	other, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Fatal("The password hash has not been salted")
+	}
+
+	// the parameters are read from the hash
+	weaker := "$argon2id$v=19$m=1024,t=1,p=1$c29tZXNhbHRzb21lc2FsdA$"
+	for _, invalid := range []string{"", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$aGFzaA", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$aGFzaA", weaker, weaker + "!!"} {
+		if err := VerifyPassword("password", invalid); err != PasswordHashFormatError {
+			t.Errorf("The expected error is: %v, instead we've got: %v\n", PasswordHashFormatError, err)
+		}
+	}
+}
+
+func TestInitCryptoEngineFromPassword(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	config := Config{KeyStore: store}
+
+	engine, err := InitCryptoEngineFromPasswordWithConfig("Sec51Password", "correct horse battery staple", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the salt and the check value are stored
+	if len(store.keys) != 2 {
+		t.Fatal("The derived keys have been stored")
+	}
+
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same password derives the same keys
+	reopened, err := InitCryptoEngineFromPasswordWithConfig("Sec51Password", "correct horse battery staple", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Decrypt(encryptedBytes); err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Fingerprint() != engine.Fingerprint() || !ConstantTimeEqual(reopened.SigningPublicKey(), engine.SigningPublicKey()) {
+		t.Fatal("The asymmetric keys have not been derived again")
+	}
+
+	if _, err := InitCryptoEngineFromPasswordWithConfig("Sec51Password", "wrong horse battery staple", config); err != PasswordError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", PasswordError, err)
+	}
+	if _, err := InitCryptoEngineFromPasswordWithConfig("Sec51Password", "", config); err != PasswordError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", PasswordError, err)
+	}
+}
@@ -0,0 +1,95 @@
+package cryptoengine
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Peer public keys as strings: ParsePeerPublicKey accepts the encodings the peers usually publish their X25519 keys with,
+// so the callers do not have to decode them. The key is always 32 bytes, any other size or a trailing garbage is rejected.
+// The encodings are tried in order:
+// - hex, lower or upper case, 64 characters
+// - base64, standard or URL alphabet, with or without padding
+// - multibase: base58btc (z), hex (f, F) or base64 (m, M, u, U), with or without the X25519 multicodec header (0xec 0x01),
+//   like the did:key keys
+// A string valid in several encodings is read with the first one, use the multicodec header to avoid any ambiguity.
+// FormatPeerPublicKey returns the canonical form: base58btc multibase with the multicodec header.
+
+const (
+	multibaseBase58btc = 'z'
+)
+
+var (
+	PeerPublicKeyError = errors.New("The peer public key string is not valid")
+
+	// the multicodec header of the X25519 public keys
+	x25519Multicodec = []byte{0xec, 0x01}
+
+	// the multibase prefixes and their decoding
+	multibaseDecoders = map[byte]func(string) ([]byte, error){
+		multibaseBase58btc: base58Decode,
+		'f':                hex.DecodeString,
+		'F':                hex.DecodeString,
+		'm':                base64.RawStdEncoding.Strict().DecodeString,
+		'M':                base64.StdEncoding.Strict().DecodeString,
+		'u':                base64.RawURLEncoding.Strict().DecodeString,
+		'U':                base64.URLEncoding.Strict().DecodeString,
+	}
+
+	// the plain base64 encodings
+	peerKeyBase64Encodings = []*base64.Encoding{
+		base64.StdEncoding.Strict(),
+		base64.RawStdEncoding.Strict(),
+		base64.URLEncoding.Strict(),
+		base64.RawURLEncoding.Strict(),
+	}
+)
+
+// This function parses the peer public key from its hex, base64 or multibase string into a VerificationEngine.
+// The surrounding white spaces are ignored.
+func ParsePeerPublicKey(s string) (VerificationEngine, error) {
+	publicKey, err := decodePeerPublicKey(strings.TrimSpace(s))
+	if err != nil {
+		return VerificationEngine{}, err
+	}
+	if ConstantTimeEqual(publicKey, emptyKey) {
+		return VerificationEngine{}, PeerPublicKeyError
+	}
+	return NewVerificationEngineWithKey(publicKey)
+}
+
+// This function returns the canonical string of the peer public key: base58btc multibase with the X25519 multicodec header
+func FormatPeerPublicKey(publicKey [keySize]byte) string {
+	return string(multibaseBase58btc) + base58Encode(append(append([]byte{}, x25519Multicodec...), publicKey[:]...))
+}
+
+func decodePeerPublicKey(s string) ([]byte, error) {
+	if len(s) == 2*keySize {
+		if publicKey, err := hex.DecodeString(s); err == nil {
+			return publicKey, nil
+		}
+	}
+
+	for _, encoding := range peerKeyBase64Encodings {
+		if publicKey, err := encoding.DecodeString(s); err == nil && len(publicKey) == keySize {
+			return publicKey, nil
+		}
+	}
+
+	if len(s) > 1 {
+		if decode, ok := multibaseDecoders[s[0]]; ok {
+			if data, err := decode(s[1:]); err == nil {
+				if len(data) == len(x25519Multicodec)+keySize && data[0] == x25519Multicodec[0] && data[1] == x25519Multicodec[1] {
+					return data[len(x25519Multicodec):], nil
+				}
+				if len(data) == keySize {
+					return data, nil
+				}
+			}
+		}
+	}
+
+	return nil, PeerPublicKeyError
+}
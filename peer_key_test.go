@@ -0,0 +1,83 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestBase58(t *testing.T) {
+
+	for _, vector := range []struct {
+		data    []byte
+		encoded string
+	}{
+		{[]byte("hello world"), "StV1DL6CwTryKyV"},
+		{[]byte{0, 0, 1}, "112"},
+		{[]byte{0}, "1"},
+	} {
+		if encoded := base58Encode(vector.data); encoded != vector.encoded {
+			t.Fatalf("Expected %s, got %s\n", vector.encoded, encoded)
+		}
+		if decoded, err := base58Decode(vector.encoded); err != nil || !bytes.Equal(decoded, vector.data) {
+			t.Fatalf("Expected %x, got %x: %v\n", vector.data, decoded, err)
+		}
+	}
+	if _, err := base58Decode("0OIl"); err != Base58Error {
+		t.Fatalf("Expected %v, got %v\n", Base58Error, err)
+	}
+
+}
+
+func TestParsePeerPublicKey(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51PeerKey", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := engine.publicKey
+
+	canonical := FormatPeerPublicKey(publicKey)
+	if !strings.HasPrefix(canonical, "z") {
+		t.Fatalf("The canonical key should be base58btc multibase: %s\n", canonical)
+	}
+
+	for _, encoded := range []string{
+		hex.EncodeToString(publicKey[:]),
+		strings.ToUpper(hex.EncodeToString(publicKey[:])),
+		base64.StdEncoding.EncodeToString(publicKey[:]),
+		base64.RawURLEncoding.EncodeToString(publicKey[:]),
+		"  " + base64.URLEncoding.EncodeToString(publicKey[:]) + "\n",
+		canonical,
+		"f" + hex.EncodeToString(append([]byte{0xec, 0x01}, publicKey[:]...)),
+		"u" + base64.RawURLEncoding.EncodeToString(append([]byte{0xec, 0x01}, publicKey[:]...)),
+	} {
+		peer, err := ParsePeerPublicKey(encoded)
+		if err != nil {
+			t.Fatalf("%q: %v\n", encoded, err)
+		}
+		if peer.PublicKey() != publicKey {
+			t.Fatalf("%q: the key does not match\n", encoded)
+		}
+		if FormatPeerPublicKey(peer.PublicKey()) != canonical {
+			t.Fatalf("%q: the canonical form does not match\n", encoded)
+		}
+	}
+
+	for _, encoded := range []string{
+		"",
+		hex.EncodeToString(publicKey[:31]),
+		hex.EncodeToString(publicKey[:]) + "00",
+		base64.StdEncoding.EncodeToString(append(publicKey[:], 0, 0)),
+		"f" + hex.EncodeToString(append([]byte{0xed, 0x01}, publicKey[:]...)),
+		"x" + hex.EncodeToString(publicKey[:]),
+		hex.EncodeToString(make([]byte, keySize)),
+	} {
+		if _, err := ParsePeerPublicKey(encoded); err != PeerPublicKeyError {
+			t.Fatalf("%q: expected %v, got %v\n", encoded, PeerPublicKeyError, err)
+		}
+	}
+
+}
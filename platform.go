@@ -0,0 +1,20 @@
+//go:build !js
+// +build !js
+
+package cryptoengine
+
+import (
+	"path/filepath"
+)
+
+// the keys are stored in the files of the keys folder, created when the package is loaded
+const createKeyFolderOnInit = true
+
+func defaultKeyStore() KeyStore {
+	return &FileKeyStore{path: keyPath}
+}
+
+// each tenant has its own folder inside the keys folder
+func defaultTenantKeyStore(tenant string) (KeyStore, error) {
+	return NewFileKeyStore(filepath.Join(keyPath, tenantsFolder, tenant))
+}
@@ -0,0 +1,20 @@
+//go:build js
+// +build js
+
+package cryptoengine
+
+// WebAssembly in the browser (GOOS=js GOARCH=wasm): there is no file system, so by default the keys are kept in memory
+// for the lifetime of the page and the keys folder is not created. To persist the keys, configure a KeyStore,
+// for instance one backed by IndexedDB. The randomness comes from crypto/rand, which uses the Web Crypto getRandomValues.
+const createKeyFolderOnInit = false
+
+var browserKeyStore = NewMemoryKeyStore()
+
+func defaultKeyStore() KeyStore {
+	return browserKeyStore
+}
+
+// each tenant has its own memory store
+func defaultTenantKeyStore(tenant string) (KeyStore, error) {
+	return NewMemoryKeyStore(), nil
+}
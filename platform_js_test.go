@@ -0,0 +1,33 @@
+//go:build js
+// +build js
+
+package cryptoengine
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBrowserDefaults(t *testing.T) {
+
+	if _, err := os.Stat(keyPath); err == nil {
+		t.Fatal("The keys folder has been created")
+	}
+
+	engine, err := InitCryptoEngine("Sec51Browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys are kept in memory for the lifetime of the page
+	reloaded, err := InitCryptoEngine("Sec51Browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Fingerprint() != engine.Fingerprint() {
+		t.Fatal("The keys have not been kept in memory")
+	}
+	if _, err := browserKeyStore.ReadKey("sec51browser_secret.key"); err != nil {
+		t.Fatal(err)
+	}
+}
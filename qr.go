@@ -0,0 +1,121 @@
+package cryptoengine
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key exchange with QR codes: to pair a mobile and a desktop peer out of band, one peer shows its public keys in a QR code
+// and the other one scans it. ExportPublicKeyQR returns the text to encode in the QR code, with any QR library, and ParsePublicKeyQR
+// reads the text scanned. The payload is an URI, with the identifier, the keys, the fingerprint and the expiry of the payload:
+//
+//	cryptoengine:pair?v=1&id=<identifier>&k=<public key>&s=<signing public key>&f=<fingerprint>&exp=<unix time>&sig=<signature>
+//
+// The keys and the signature are base64 URL encoded. The signature is the Ed25519 signature of all the fields before it,
+// so a payload cannot be used after its expiry, and the fingerprint lets the user compare it with the one shown by the other peer.
+
+const (
+	qrPrefix    = "cryptoengine:pair?"
+	qrVersion   = "1"
+	qrSignLabel = "cryptoengine qr pairing"
+)
+
+var (
+	QRPayloadError = errors.New("The QR code payload is not valid")
+	QRExpiredError = errors.New("The QR code payload has expired")
+)
+
+// The QRPeer struct is the peer read from a QR code payload
+type QRPeer struct {
+	Identifier  string             // the identifier of the peer, for instance to register it with RegisterPeer
+	Fingerprint string             // the fingerprint of the peer public key
+	Expiry      time.Time          // the payload is not valid after this time
+	Engine      VerificationEngine // the public keys of the peer
+}
+
+// This method returns the QR code payload with the engine public keys, valid for the duration
+func (engine *CryptoEngine) ExportPublicKeyQR(identifier string, validity time.Duration) (string, error) {
+	if err := engine.allow(operationOwnKeys); err != nil {
+		return "", err
+	}
+	if identifier == "" || validity <= 0 {
+		return "", QRPayloadError
+	}
+
+	// the fields are written in a fixed order, the signature covers them as they are written
+	fields := "v=" + qrVersion +
+		"&id=" + url.QueryEscape(identifier) +
+		"&k=" + base64.RawURLEncoding.EncodeToString(engine.publicKey[:]) +
+		"&s=" + base64.RawURLEncoding.EncodeToString(engine.SigningPublicKey()) +
+		"&f=" + normalizeFingerprint(engine.Fingerprint()) +
+		"&exp=" + strconv.FormatInt(time.Now().Add(validity).Unix(), 10)
+	signature := engine.Sign([]byte(qrSignLabel + fields))
+	return qrPrefix + fields + "&sig=" + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// This function parses a payload returned by ExportPublicKeyQR, it verifies its signature, its fingerprint and its expiry
+func ParsePublicKeyQR(payload string) (QRPeer, error) {
+	payload = strings.TrimSpace(payload)
+	index := strings.LastIndex(payload, "&sig=")
+	if !strings.HasPrefix(payload, qrPrefix) || index < 0 {
+		return QRPeer{}, QRPayloadError
+	}
+	signed := payload[len(qrPrefix):index]
+
+	values, err := url.ParseQuery(payload[len(qrPrefix):])
+	if err != nil {
+		return QRPeer{}, QRPayloadError
+	}
+	for _, field := range []string{"v", "id", "k", "s", "f", "exp", "sig"} {
+		if len(values[field]) != 1 {
+			return QRPeer{}, QRPayloadError
+		}
+	}
+	if values.Get("v") != qrVersion || values.Get("id") == "" {
+		return QRPeer{}, QRPayloadError
+	}
+
+	publicKey, err := base64.RawURLEncoding.DecodeString(values.Get("k"))
+	if err != nil || len(publicKey) != keySize {
+		return QRPeer{}, QRPayloadError
+	}
+	signingPublicKey, err := base64.RawURLEncoding.DecodeString(values.Get("s"))
+	if err != nil || len(signingPublicKey) != ed25519.PublicKeySize {
+		return QRPeer{}, QRPayloadError
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(values.Get("sig"))
+	if err != nil {
+		return QRPeer{}, QRPayloadError
+	}
+	expiry, err := strconv.ParseInt(values.Get("exp"), 10, 64)
+	if err != nil {
+		return QRPeer{}, QRPayloadError
+	}
+
+	verificationEngine, err := NewVerificationEngineWithKeys(publicKey, signingPublicKey)
+	if err != nil {
+		return QRPeer{}, QRPayloadError
+	}
+	if err := verificationEngine.Verify([]byte(qrSignLabel+signed), signature); err != nil {
+		return QRPeer{}, err
+	}
+	if !CompareFingerprints(verificationEngine.Fingerprint(), values.Get("f")) {
+		return QRPeer{}, QRPayloadError
+	}
+
+	peer := QRPeer{
+		Identifier:  values.Get("id"),
+		Fingerprint: verificationEngine.Fingerprint(),
+		Expiry:      time.Unix(expiry, 0),
+		Engine:      verificationEngine,
+	}
+	if time.Now().After(peer.Expiry) {
+		return QRPeer{}, QRExpiredError
+	}
+	return peer, nil
+}
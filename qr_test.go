@@ -0,0 +1,72 @@
+package cryptoengine
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicKeyQR(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51QR", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := engine.ExportPublicKeyQR("desktop & co", 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(payload, "cryptoengine:pair?") {
+		t.Fatalf("The payload is not valid: %s\n", payload)
+	}
+
+	peer, err := ParsePublicKeyQR(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.Identifier != "desktop & co" || peer.Fingerprint != engine.Fingerprint() || time.Until(peer.Expiry) > 10*time.Minute {
+		t.Fatalf("The peer does not match the payload: %+v\n", peer)
+	}
+
+	// the peer can encrypt for the engine
+	msg, err := NewMessage("paired", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessageWithPubKey(msg, peer.Engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.DecryptWithPublicKey(encryptedBytes, peer.Engine); err != nil {
+		t.Fatal(err)
+	}
+
+	// the expiry is signed
+	expiry := strings.Index(payload, "&exp=")
+	extended := payload[:expiry+5] + "9" + payload[expiry+5:]
+	if _, err := ParsePublicKeyQR(extended); err != SignatureError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", SignatureError, err)
+	}
+
+	// expired payloads are rejected
+	expired, err := engine.ExportPublicKeyQR("desktop", time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := ParsePublicKeyQR(expired); err != QRExpiredError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", QRExpiredError, err)
+	}
+
+	for _, invalid := range []string{"", "cryptoengine:pair?v=1", "https://example.com/?v=1&sig=", payload + "&k=AAAA", strings.Replace(payload, "v=1", "v=2", 1)} {
+		if _, err := ParsePublicKeyQR(invalid); err != QRPayloadError {
+			t.Errorf("The expected error is: %v, instead we've got: %v\n", QRPayloadError, err)
+		}
+	}
+
+}
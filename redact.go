@@ -0,0 +1,42 @@
+package cryptoengine
+
+import (
+	"fmt"
+)
+
+// Safe formatting: the engines and the messages end up in the logs by accident, with a %v or a %#v.
+// Their String and GoString methods print only the lengths, the versions and the fingerprints,
+// never the keys, the clear text or the ciphertext.
+
+// This method describes the message without its text
+func (m Message) String() string {
+	return fmt.Sprintf("Message{Version: %d, Type: %d, Text: <%d bytes redacted>}", m.Version, m.Type, len(m.Text))
+}
+
+// This method describes the message without its text, for the %#v verb
+func (m Message) GoString() string {
+	return "cryptoengine." + m.String()
+}
+
+// This method describes the encrypted message without its ciphertext
+func (m EncryptedMessage) String() string {
+	return fmt.Sprintf("EncryptedMessage{Length: %d, Ciphertext: <%d bytes redacted>}", m.length, len(m.data))
+}
+
+// This method describes the encrypted message without its ciphertext, for the %#v verb
+func (m EncryptedMessage) GoString() string {
+	return "cryptoengine." + m.String()
+}
+
+// This method describes the engine with its context and its fingerprint, without its keys
+func (engine *CryptoEngine) String() string {
+	if engine == nil {
+		return "CryptoEngine(nil)"
+	}
+	return fmt.Sprintf("CryptoEngine{Context: %q, Fingerprint: %s, Role: %d, Keys: <redacted>}", engine.context, engine.Fingerprint(), engine.config.Role)
+}
+
+// This method describes the engine without its keys, for the %#v verb
+func (engine *CryptoEngine) GoString() string {
+	return "&cryptoengine." + engine.String()
+}
@@ -0,0 +1,54 @@
+package cryptoengine
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRedactedFormatting(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Redact", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := NewMessage("the launch code is 0000", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets := []string{
+		msg.Text,
+		hex.EncodeToString(engine.secretKey[:]),
+		hex.EncodeToString(engine.privateKey[:]),
+		hex.EncodeToString(engine.nonceKey[:]),
+		hex.EncodeToString(engine.signingKey.Seed()),
+		hex.EncodeToString(encrypted.data),
+		fmt.Sprint(engine.secretKey[:]),
+		fmt.Sprint(engine.privateKey[:]),
+		fmt.Sprint(encrypted.data),
+	}
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%x", "%q"} {
+		for _, value := range []interface{}{msg, &msg, encrypted, &encrypted, engine, []interface{}{engine, msg}} {
+			formatted := fmt.Sprintf(verb, value)
+			for _, secret := range secrets {
+				if strings.Contains(formatted, secret) || strings.Contains(formatted, hex.EncodeToString([]byte(secret))) {
+					t.Fatalf("%s of %T leaks a secret: %s\n", verb, value, formatted)
+				}
+			}
+		}
+	}
+
+	if formatted := fmt.Sprint(engine); !strings.Contains(formatted, engine.Fingerprint()) || !strings.Contains(formatted, "sec51redact") {
+		t.Errorf("The engine description is not valid: %s\n", formatted)
+	}
+	if formatted := fmt.Sprint(msg); !strings.Contains(formatted, "23 bytes") {
+		t.Errorf("The message description is not valid: %s\n", formatted)
+	}
+
+}
@@ -0,0 +1,77 @@
+// Package redisstore provides a cryptoengine replay cache and counter store backed by Redis.
+//
+// Clustered receivers handling the same peer share the replay cache, so a message replayed to any of them is rejected,
+// and clustered senders sharing the same keys reserve their nonce counters from the same atomic counter.
+//
+//	store := redisstore.New(redis.NewClient(&redis.Options{Addr: "localhost:6379"}), "cryptoengine:")
+//	engine, err := cryptoengine.InitCryptoEngineWithConfig("app", cryptoengine.Config{ReplayCache: store, CounterStore: store})
+package redisstore
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/sec51/cryptoengine"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	replayPrefix   = "replay:"
+	countersPrefix = "counters:"
+	requestTimeout = 5 * time.Second // maximum duration of a Redis command
+)
+
+// The Store implements the cryptoengine.ReplayCache and cryptoengine.CounterStore interfaces
+type Store struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// This function returns a store which keeps the nonces and the counters under the prefix.
+// The client can be a single node, a sentinel or a cluster client.
+func New(client redis.UniversalClient, prefix string) *Store {
+	return &Store{client: client, prefix: prefix}
+}
+
+// This method remembers the key with SET NX, which atomically fails when the key already exists
+func (store *Store) Remember(key string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return store.RememberContext(ctx, key, ttl)
+}
+
+// This method remembers the key within the context deadline
+func (store *Store) RememberContext(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return store.client.SetNX(ctx, store.prefix+replayPrefix+key, 1, ttl).Result()
+}
+
+// This method reserves n counters for the engine context with INCRBY.
+// Redis counters are signed 64 bits integers, so the store runs out of counters at math.MaxInt64.
+func (store *Store) ReserveCounters(name string, n uint64) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return store.ReserveCountersContext(ctx, name, n)
+}
+
+// This method reserves n counters for the engine context within the context deadline
+func (store *Store) ReserveCountersContext(ctx context.Context, name string, n uint64) (uint64, error) {
+	if n > math.MaxInt64 {
+		return 0, cryptoengine.CounterOverflowError
+	}
+
+	next, err := store.client.IncrBy(ctx, store.prefix+countersPrefix+name, int64(n)).Result()
+	if err != nil {
+		// redis refuses the increments which would overflow, without changing the counter
+		if isOverflow(err) {
+			return 0, cryptoengine.CounterOverflowError
+		}
+		return 0, err
+	}
+	return uint64(next) - n, nil
+}
+
+// redis replies "ERR increment or decrement would overflow" when INCRBY exceeds the 64 bits signed range
+func isOverflow(err error) bool {
+	return strings.Contains(err.Error(), "would overflow")
+}
@@ -0,0 +1,103 @@
+package redisstore
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/sec51/cryptoengine"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// make sure the store implements the cryptoengine interfaces
+var (
+	_ cryptoengine.ContextReplayCache  = &Store{}
+	_ cryptoengine.ContextCounterStore = &Store{}
+)
+
+// the tests need a Redis server: set CRYPTOENGINE_REDIS_ADDR to its address, for instance localhost:6379
+func testStore(t *testing.T) *Store {
+	addr := os.Getenv("CRYPTOENGINE_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("CRYPTOENGINE_REDIS_ADDR is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	prefix := "cryptoengine-test:" + time.Now().Format(time.RFC3339Nano) + ":"
+	return New(client, prefix)
+}
+
+func TestStoreReplayCache(t *testing.T) {
+
+	store := testStore(t)
+	config := cryptoengine.Config{ReplayCache: store, CounterStore: store}
+
+	// two receivers sharing the keys and the cache
+	receiver, err := cryptoengine.InitCryptoEngineWithConfig("Sec51Redis", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherReceiver, err := cryptoengine.InitCryptoEngineWithConfig("Sec51Redis", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := cryptoengine.NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := receiver.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := receiver.Decrypt(encryptedBytes); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherReceiver.Decrypt(encryptedBytes); err != cryptoengine.ReplayError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", cryptoengine.ReplayError, err)
+	}
+
+	// the keys expire
+	if fresh, err := store.Remember("expiring", 100*time.Millisecond); err != nil || !fresh {
+		t.Fatal("The key has not been remembered")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if fresh, err := store.Remember("expiring", time.Minute); err != nil || !fresh {
+		t.Fatal("The expired key has not been forgotten")
+	}
+}
+
+func TestStoreCounters(t *testing.T) {
+
+	store := testStore(t)
+
+	first, err := store.ReserveCounters("sec51redis", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.ReserveCounters("sec51redis", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 0 || second != 1024 {
+		t.Fatal("The counters have not been reserved correctly")
+	}
+
+	if _, err := store.ReserveCounters("sec51redis", math.MaxInt64); err != cryptoengine.CounterOverflowError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", cryptoengine.CounterOverflowError, err)
+	}
+	if _, err := store.ReserveCounters("sec51redis", math.MaxUint64); err != cryptoengine.CounterOverflowError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", cryptoengine.CounterOverflowError, err)
+	}
+}
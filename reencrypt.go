@@ -0,0 +1,136 @@
+package cryptoengine
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Bulk re-encryption for the key rotation: the data at rest encrypted under a retiring key is decrypted and sealed again
+// under the current key, record by record, so the retiring key can be deleted once the pipeline has completed.
+// The records are read from a CiphertextStore in a stable order. After each record the progress is reported with the cursor
+// of the last record processed: when the pipeline is interrupted, by an error or by the context, it resumes from that cursor.
+// The records which are already encrypted under the current key are skipped, so running the pipeline twice is harmless.
+// Only the messages encrypted with the secret key can be re-encrypted, the messages for a peer need the peer to encrypt them again.
+
+var (
+	ReEncryptSuiteError = errors.New("Only the messages encrypted with the secret key can be re-encrypted")
+)
+
+// The CiphertextStore interface gives access to the stored ciphertexts to re-encrypt, for instance the rows of a table
+type CiphertextStore interface {
+	// returns the first record after the cursor, in a stable order, and its cursor. An empty cursor starts from the first record.
+	// It returns io.EOF when there are no more records
+	Next(ctx context.Context, cursor string) (next string, ciphertext []byte, err error)
+	// replaces the ciphertext of the record
+	Replace(ctx context.Context, cursor string, ciphertext []byte) error
+}
+
+// The ReEncryptOptions struct holds the optional settings of the re-encryption. The zero value starts from the first record.
+type ReEncryptOptions struct {
+	Resume   string                  // the cursor of the last record processed by a previous run, to resume from there
+	KeyID    []byte                  // the key ID written in the header of the re-encrypted messages with a header. Nil keeps their key ID
+	Progress func(ReEncryptProgress) // called after each record, for instance to log the progress or to save the cursor
+}
+
+// The ReEncryptProgress struct reports how far the re-encryption has gone
+type ReEncryptProgress struct {
+	Cursor      string // the cursor of the last record processed, pass it as ReEncryptOptions.Resume to resume
+	ReEncrypted uint64 // the records re-encrypted under the current key
+	Skipped     uint64 // the records already encrypted under the current key
+}
+
+// This method decrypts the records of the store encrypted under the retired engine and encrypts them again with the engine secret key.
+// The format of the messages, legacy or with a header, and their header are preserved.
+// It returns the progress, also when it stops on an error.
+func (engine *CryptoEngine) ReEncrypt(ctx context.Context, retired *CryptoEngine, store CiphertextStore, options ReEncryptOptions) (ReEncryptProgress, error) {
+	return reEncrypt(ctx, store, options, func(ciphertext []byte) ([]byte, bool, error) {
+		msg, info, err := retired.DecryptWithInfo(ciphertext)
+		if err == MessageDecryptionError || err == HeaderSuiteError {
+			// already rotated
+			if _, _, currentErr := engine.DecryptWithInfo(ciphertext); currentErr == nil {
+				return nil, false, nil
+			}
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if info.Suite != SuiteSecretKey {
+			return nil, false, ReEncryptSuiteError
+		}
+
+		if info.Legacy {
+			encrypted, err := engine.NewEncryptedMessage(*msg)
+			if err != nil {
+				return nil, false, err
+			}
+			data, err := encrypted.ToBytes()
+			return data, true, err
+		}
+
+		header := info.Header
+		if options.KeyID != nil {
+			header.KeyID = options.KeyID
+		}
+		data, err := engine.NewEncryptedMessageWithHeader(*msg, header)
+		return data, true, err
+	})
+}
+
+// This function encrypts again with the newest engine registered with the context name the records of the store
+// encrypted by EncryptColumn with an older version of the engine
+func ReEncryptColumn(ctx context.Context, name string, store CiphertextStore, options ReEncryptOptions) (ReEncryptProgress, error) {
+	_, currentVersion, err := currentColumnEngine(name)
+	if err != nil {
+		return ReEncryptProgress{}, err
+	}
+
+	return reEncrypt(ctx, store, options, func(ciphertext []byte) ([]byte, bool, error) {
+		data, version, err := DecryptColumn(name, string(ciphertext))
+		if err != nil {
+			return nil, false, err
+		}
+		if version == currentVersion {
+			return nil, false, nil
+		}
+
+		armored, err := EncryptColumn(name, data)
+		return []byte(armored), true, err
+	})
+}
+
+// reads the records after the resume cursor and replaces the ciphertexts resealed. The reseal function returns false for the records to skip
+func reEncrypt(ctx context.Context, store CiphertextStore, options ReEncryptOptions, reseal func([]byte) ([]byte, bool, error)) (ReEncryptProgress, error) {
+	progress := ReEncryptProgress{Cursor: options.Resume}
+	for {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		cursor, ciphertext, err := store.Next(ctx, progress.Cursor)
+		if err == io.EOF {
+			return progress, nil
+		}
+		if err != nil {
+			return progress, err
+		}
+
+		resealed, replace, err := reseal(ciphertext)
+		if err != nil {
+			return progress, err
+		}
+		if replace {
+			if err := store.Replace(ctx, cursor, resealed); err != nil {
+				return progress, err
+			}
+			progress.ReEncrypted++
+		} else {
+			progress.Skipped++
+		}
+
+		progress.Cursor = cursor
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+	}
+}
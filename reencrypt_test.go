@@ -0,0 +1,172 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+)
+
+// a table of ciphertexts in memory, in the order of the cursors
+type testCiphertextStore struct {
+	records  map[string][]byte
+	failNext string // Replace fails once on this cursor
+}
+
+func (store *testCiphertextStore) Next(ctx context.Context, cursor string) (string, []byte, error) {
+	cursors := make([]string, 0, len(store.records))
+	for record := range store.records {
+		cursors = append(cursors, record)
+	}
+	sort.Strings(cursors)
+	for _, next := range cursors {
+		if next > cursor {
+			return next, store.records[next], nil
+		}
+	}
+	return "", nil, io.EOF
+}
+
+func (store *testCiphertextStore) Replace(ctx context.Context, cursor string, ciphertext []byte) error {
+	if cursor == store.failNext {
+		store.failNext = ""
+		return errors.New("database: connection reset")
+	}
+	store.records[cursor] = ciphertext
+	return nil
+}
+
+func TestReEncrypt(t *testing.T) {
+
+	retired, err := InitCryptoEngineWithConfig("Sec51Rotation", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := InitCryptoEngineWithConfig("Sec51Rotation", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &testCiphertextStore{records: make(map[string][]byte), failNext: "record-05"}
+	for i := 0; i < 10; i++ {
+		msg, err := NewMessage(fmt.Sprintf("record %d", i), i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data []byte
+		if i%2 == 0 {
+			encrypted, err := retired.NewEncryptedMessage(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err = encrypted.ToBytes(); err != nil {
+				t.Fatal(err)
+			}
+		} else if data, err = retired.NewEncryptedMessageWithHeader(msg, MessageHeader{KeyID: []byte("v1"), Sequence: uint64(i)}); err != nil {
+			t.Fatal(err)
+		}
+		store.records[fmt.Sprintf("record-%02d", i)] = data
+	}
+
+	// the first run stops on the error of the store
+	var reported []ReEncryptProgress
+	options := ReEncryptOptions{KeyID: []byte("v2"), Progress: func(progress ReEncryptProgress) { reported = append(reported, progress) }}
+	progress, err := current.ReEncrypt(context.Background(), retired, store, options)
+	if err == nil {
+		t.Fatal("The error of the store has not been returned")
+	}
+	if progress.Cursor != "record-04" || progress.ReEncrypted != 5 || len(reported) != 5 {
+		t.Fatalf("The progress is not valid: %+v, %d reports\n", progress, len(reported))
+	}
+
+	// resume
+	options.Resume = progress.Cursor
+	progress, err = current.ReEncrypt(context.Background(), retired, store, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Cursor != "record-09" || progress.ReEncrypted != 5 || progress.Skipped != 0 {
+		t.Fatalf("The progress is not valid: %+v\n", progress)
+	}
+
+	// all the records are readable with the current engine, in their format
+	for i := 0; i < 10; i++ {
+		msg, info, err := current.DecryptWithInfo(store.records[fmt.Sprintf("record-%02d", i)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Text != fmt.Sprintf("record %d", i) || msg.Type != i || info.Legacy != (i%2 == 0) {
+			t.Fatalf("The record %d has not been re-encrypted correctly: %+v\n", i, info)
+		}
+		if !info.Legacy && (!bytes.Equal(info.KeyID, []byte("v2")) || info.Sequence != uint64(i)) {
+			t.Fatalf("The header of the record %d has not been preserved: %+v\n", i, info.Header)
+		}
+	}
+
+	// running again skips everything
+	progress, err = current.ReEncrypt(context.Background(), retired, store, ReEncryptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.ReEncrypted != 0 || progress.Skipped != 10 {
+		t.Fatalf("The records have been re-encrypted twice: %+v\n", progress)
+	}
+
+	// the context stops the pipeline
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := current.ReEncrypt(ctx, retired, store, ReEncryptOptions{}); err != context.Canceled {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", context.Canceled, err)
+	}
+
+}
+
+func TestReEncryptColumn(t *testing.T) {
+
+	v1, err := InitCryptoEngineWithConfig("Sec51ColumnRotation", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := InitCryptoEngineWithConfig("Sec51ColumnRotation", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterColumnEngineVersion("rotation", 1, v1)
+	defer RegisterColumnEngineVersion("rotation", 1, nil)
+	defer RegisterColumnEngineVersion("rotation", 2, nil)
+
+	store := &testCiphertextStore{records: make(map[string][]byte)}
+	for i := 0; i < 3; i++ {
+		armored, err := EncryptColumn("rotation", []byte(fmt.Sprintf("email %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.records[fmt.Sprintf("row-%d", i)] = []byte(armored)
+	}
+
+	RegisterColumnEngineVersion("rotation", 2, v2)
+	progress, err := ReEncryptColumn(context.Background(), "rotation", store, ReEncryptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.ReEncrypted != 3 {
+		t.Fatalf("The progress is not valid: %+v\n", progress)
+	}
+
+	// the retired version can be removed
+	RegisterColumnEngineVersion("rotation", 1, nil)
+	for i := 0; i < 3; i++ {
+		data, version, err := DecryptColumn("rotation", string(store.records[fmt.Sprintf("row-%d", i)]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 2 || string(data) != fmt.Sprintf("email %d", i) {
+			t.Fatalf("The row %d has not been re-encrypted: version %d\n", i, version)
+		}
+	}
+
+}
@@ -0,0 +1,70 @@
+package cryptoengine
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Hot key reload: the keys rotated by another process, or by the command line tool, are picked up without restarting the service.
+// The ReloadingEngine holds the current engine of the identifier: Reload initializes a new engine from the key store
+// and replaces the current one atomically, so the requests in flight finish with the engine they started with.
+// The nonce counter is handed over to the new engine, and the engine replaced forwards its counter reservations to it,
+// so the two engines never derive the same nonce even when the keys did not change.
+// The keywatch package calls Reload when the key files change.
+
+// The ReloadingEngine holds the engine of a communication identifier and reloads it on request. It's safe for concurrent use.
+type ReloadingEngine struct {
+	communicationIdentifier string
+	config                  Config
+	mutex                   sync.Mutex   // makes sure the reloads do not overlap
+	current                 atomic.Value // *CryptoEngine
+}
+
+// This function initializes the engine of the communication identifier, like InitCryptoEngineWithConfig
+func NewReloadingEngine(communicationIdentifier string, config Config) (*ReloadingEngine, error) {
+	engine, err := InitCryptoEngineWithConfig(communicationIdentifier, config)
+	if err != nil {
+		return nil, err
+	}
+
+	reloading := &ReloadingEngine{communicationIdentifier: communicationIdentifier, config: config}
+	reloading.current.Store(engine)
+	return reloading, nil
+}
+
+// This method returns the current engine. Get it for each request instead of keeping it, to use the reloaded keys.
+func (reloading *ReloadingEngine) Engine() *CryptoEngine {
+	return reloading.current.Load().(*CryptoEngine)
+}
+
+// This method loads the keys from the key store again and replaces the current engine.
+// When the keys cannot be loaded the current engine is kept and the error is returned.
+func (reloading *ReloadingEngine) Reload() error {
+	reloading.mutex.Lock()
+	defer reloading.mutex.Unlock()
+
+	engine, err := InitCryptoEngineWithConfig(reloading.communicationIdentifier, reloading.config)
+	if err != nil {
+		return err
+	}
+
+	reloading.Engine().handOver(engine)
+	reloading.current.Store(engine)
+	return nil
+}
+
+// hands the nonce counter over to the successor, from now on the counters are reserved from the successor
+func (engine *CryptoEngine) handOver(successor *CryptoEngine) {
+	engine.counterMutex.Lock()
+	defer engine.counterMutex.Unlock()
+
+	// the counters of a counter store are already shared
+	if engine.config.CounterStore == nil {
+		successor.counter = engine.counter
+	}
+	// the crossed nonce thresholds are not reported twice
+	if successor.nonceWarnings < engine.nonceWarnings {
+		successor.nonceWarnings = engine.nonceWarnings
+	}
+	engine.successor = successor
+}
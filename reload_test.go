@@ -0,0 +1,77 @@
+package cryptoengine
+
+import (
+	"testing"
+)
+
+func TestReloadingEngine(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	reloading, err := NewReloadingEngine("Sec51Reload", Config{KeyStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := reloading.Engine()
+
+	message, err := NewMessage("before the rotation", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another process rotates the secret key
+	secretKey, err := generateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteKey("sec51reload_secret.key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteKey("sec51reload_secret.key", secretKey[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reloading.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := reloading.Engine()
+	if reloaded == engine || reloaded.secretKey != secretKey {
+		t.Fatal("The rotated key has not been loaded")
+	}
+
+	// the message encrypted with the old key cannot be decrypted anymore
+	encryptedBytes, _ := encrypted.ToBytes()
+	if _, err := reloaded.Decrypt(encryptedBytes); err != MessageDecryptionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageDecryptionError, err)
+	}
+
+	// the counter has been handed over and the old engine reserves its counters from the new one
+	if reloaded.counter != 1 {
+		t.Fatalf("The counter has not been handed over: %d\n", reloaded.counter)
+	}
+	first, _, err := engine.reserveCounters(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := reloaded.reserveCounters(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 1 || second != 2 {
+		t.Fatalf("The engines do not share the counter: %d and %d\n", first, second)
+	}
+
+	// the current engine is kept when the keys cannot be loaded
+	store.DeleteKey("sec51reload_secret.key")
+	store.WriteKey("sec51reload_secret.key", []byte("short"))
+	if err := reloading.Reload(); err != KeySizeError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeySizeError, err)
+	}
+	if reloading.Engine() != reloaded {
+		t.Fatal("The engine has been replaced although the keys could not be loaded")
+	}
+
+}
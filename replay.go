@@ -0,0 +1,104 @@
+package cryptoengine
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Anti-replay protection: when the engine is configured with a ReplayCache, Decrypt and DecryptWithPublicKey
+// remember the nonce of each message they authenticate for the replay window and reject the messages whose nonce has already been seen.
+// The nonces are remembered per engine context and per peer, so receivers sharing a cache, for instance a Redis server,
+// reject a message replayed to any of them. A message replayed after the window is not detected.
+
+const (
+	defaultReplayWindow = 24 * time.Hour // how long the nonces are remembered when Config.ReplayWindow is zero
+)
+
+var (
+	ReplayError = errors.New("The message has already been received")
+)
+
+// The ReplayCache interface remembers the nonces of the received messages
+type ReplayCache interface {
+	// remembers the key for ttl and returns false when the key was already remembered
+	Remember(key string, ttl time.Duration) (bool, error)
+}
+
+// The MemoryReplayCache remembers the keys in memory: it protects a single receiver only
+type MemoryReplayCache struct {
+	mutex     sync.Mutex
+	keys      map[string]time.Time
+	pruneSize int
+}
+
+// This function returns an empty in memory replay cache
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{
+		keys:      make(map[string]time.Time),
+		pruneSize: 1024,
+	}
+}
+
+// This method remembers the key until it expires
+func (cache *MemoryReplayCache) Remember(key string, ttl time.Duration) (bool, error) {
+	return cache.remember(key, ttl, time.Now()), nil
+}
+
+func (cache *MemoryReplayCache) remember(key string, ttl time.Duration, now time.Time) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if expiration, ok := cache.keys[key]; ok && now.Before(expiration) {
+		return false
+	}
+
+	// the expired keys are removed once the cache doubles, so the pruning is amortized
+	if len(cache.keys) >= cache.pruneSize {
+		for k, expiration := range cache.keys {
+			if !now.Before(expiration) {
+				delete(cache.keys, k)
+			}
+		}
+		cache.pruneSize = 2 * len(cache.keys)
+		if cache.pruneSize < 1024 {
+			cache.pruneSize = 1024
+		}
+	}
+
+	cache.keys[key] = now.Add(ttl)
+	return true
+}
+
+// returns the replay window of the engine
+func (engine *CryptoEngine) replayWindow() time.Duration {
+	if engine.config.ReplayWindow == 0 {
+		return defaultReplayWindow
+	}
+	return engine.config.ReplayWindow
+}
+
+// rejects the authenticated message when its nonce has already been received from the peer.
+// peer is the hash of the peer public key, or "secret" for the messages encrypted with the secret key.
+func (engine *CryptoEngine) checkReplay(ctx context.Context, peer string, nonce [nonceSize]byte) error {
+	if engine.config.ReplayCache == nil {
+		return nil
+	}
+
+	fresh, err := rememberContext(ctx, engine.config.ReplayCache, fmt.Sprintf("%s:%s:%x", engine.context, peer, nonce), engine.replayWindow())
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ReplayError
+	}
+	return nil
+}
+
+// the peers are identified like in the preSharedKeysMap
+func peerReplayIdentifier(peerPublicKey [keySize]byte) string {
+	return fmt.Sprintf("%x", sha256.Sum224(peerPublicKey[:]))
+}
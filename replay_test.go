@@ -0,0 +1,116 @@
+package cryptoengine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplayProtection(t *testing.T) {
+
+	cache := NewMemoryReplayCache()
+	receiver, err := InitCryptoEngineWithConfig("Sec51Replay", Config{ReplayCache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a second receiver, sharing the cache and the keys
+	otherReceiver, err := InitCryptoEngineWithConfig("Sec51Replay", Config{ReplayCache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// symmetric
+	encrypted, err := receiver.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := receiver.Decrypt(encryptedBytes); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherReceiver.Decrypt(encryptedBytes); err != ReplayError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ReplayError, err)
+	}
+
+	// tampered messages are not remembered
+	tampered, err := receiver.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperedBytes, err := tampered.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperedBytes[len(tamperedBytes)-1] ^= 1
+	if _, err := receiver.Decrypt(tamperedBytes); err != MessageDecryptionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageDecryptionError, err)
+	}
+	tamperedBytes[len(tamperedBytes)-1] ^= 1
+	if _, err := receiver.Decrypt(tamperedBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	// asymmetric
+	sender, err := InitCryptoEngine("Sec51ReplaySender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderVerification, err := NewVerificationEngineWithKey(sender.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiverVerification, err := NewVerificationEngineWithKey(receiver.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err = sender.NewEncryptedMessageWithPubKey(msg, receiverVerification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err = encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := receiver.DecryptWithPublicKey(encryptedBytes, senderVerification); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherReceiver.DecryptWithPublicKey(encryptedBytes, senderVerification); err != ReplayError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ReplayError, err)
+	}
+}
+
+func TestMemoryReplayCacheExpiration(t *testing.T) {
+
+	cache := NewMemoryReplayCache()
+	now := time.Now()
+
+	if !cache.remember("nonce", time.Minute, now) {
+		t.Fatal("The key has not been remembered")
+	}
+	if cache.remember("nonce", time.Minute, now.Add(30*time.Second)) {
+		t.Fatal("The key has been forgotten before it expired")
+	}
+	if !cache.remember("nonce", time.Minute, now.Add(time.Minute)) {
+		t.Fatal("The expired key has not been forgotten")
+	}
+
+	// the expired keys are pruned once the cache is full
+	for i := 0; i < 1023; i++ {
+		cache.remember(string(rune(i)), time.Second, now)
+	}
+	cache.remember("last", time.Minute, now.Add(time.Hour))
+	if len(cache.keys) != 1 {
+		t.Fatal("The expired keys have not been pruned")
+	}
+}
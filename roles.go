@@ -0,0 +1,71 @@
+package cryptoengine
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Key usage policies: an engine can be restricted to encrypt only, for instance on the edge nodes which must never decrypt the traffic,
+// or to decrypt only. The restriction is enforced by the engine methods, which return RoleError.
+//
+// An encrypt only engine does not load nor generate any key from the key store: it encrypts for the peers with the methods
+// based on ephemeral keys, EncryptJWE, EncryptCOSE and EncryptAge. Its own keys are random and never persisted,
+// so nothing can be decrypted with them even through the methods which cannot report the role error, like Sign.
+
+// The Role of an engine defines what it's allowed to do with its keys
+type Role int
+
+const (
+	RoleFull        Role = iota // encrypt and decrypt, the default
+	RoleEncryptOnly             // encrypt for the peers public keys only, no key of the engine is loaded
+	RoleDecryptOnly             // decrypt only
+)
+
+// the operations restricted by the roles
+const (
+	operationEncrypt = iota // encrypt, for the peers or with the engine keys
+	operationDecrypt        // decrypt
+	operationOwnKeys        // use the secret, private or signing key of the engine
+)
+
+var (
+	RoleError = errors.New("The operation is not allowed by the role of the engine")
+)
+
+// returns RoleError if one of the operations is not allowed by the engine role
+func (engine *CryptoEngine) allow(operations ...int) error {
+	for _, operation := range operations {
+		switch engine.config.Role {
+		case RoleEncryptOnly:
+			if operation != operationEncrypt {
+				return RoleError
+			}
+		case RoleDecryptOnly:
+			if operation == operationEncrypt {
+				return RoleError
+			}
+		}
+	}
+	return nil
+}
+
+// fills the engine with random keys which are never persisted, for the encrypt only role
+func (engine *CryptoEngine) generateEphemeralKeys() error {
+	var err error
+	for _, key := range []*[keySize]byte{&engine.salt, &engine.secretKey, &engine.nonceKey} {
+		if *key, err = generateSecretKey(); err != nil {
+			return err
+		}
+	}
+
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	engine.publicKey, engine.privateKey = *public, *private
+
+	_, engine.signingKey, err = ed25519.GenerateKey(rand.Reader)
+	return err
+}
@@ -0,0 +1,127 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptOnlyRole(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	edge, err := InitCryptoEngineWithConfig("Sec51Edge", Config{KeyStore: store, Role: RoleEncryptOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no key has been loaded nor generated
+	if keys, _ := store.ListKeys(""); len(keys) != 0 {
+		t.Fatalf("The encrypt only engine stored %d keys\n", len(keys))
+	}
+
+	backend, err := InitCryptoEngine("Sec51Backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendVerificationEngine, err := NewVerificationEngineWithKey(backend.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the edge encrypts for the backend public key
+	token, err := edge.EncryptJWE([]byte("payload"), backendVerificationEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := backend.DecryptJWE(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload, []byte("payload")) {
+		t.Fatal("The payload does not match the original one")
+	}
+
+	if _, err := edge.DecryptJWE(token); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+
+	// the engine keys are not available
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := edge.NewEncryptedMessage(msg); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+	if _, err := edge.NewEncryptedMessageWithPubKey(msg, backendVerificationEngine); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+	if _, err := edge.Authenticate([]byte("data")); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+
+	encrypted, err := backend.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := edge.Decrypt(data); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+
+}
+
+func TestDecryptOnlyRole(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := InitCryptoEngineWithConfig("Sec51", Config{Role: RoleDecryptOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("The quick brown fox jumps over the lazy dog", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := reader.Decrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != msg.Text {
+		t.Fatal("The decrypted message does not match the original one")
+	}
+
+	if _, err := reader.NewEncryptedMessage(msg); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+	verificationEngine, err := NewVerificationEngineWithKey(engine.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.EncryptJWE([]byte("payload"), verificationEngine); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+	if _, err := reader.EncryptBranca([]byte("payload")); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+
+	if _, err := InitCryptoEngineWithConfig("Sec51", Config{Role: Role(42)}); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+
+}
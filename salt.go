@@ -0,0 +1,154 @@
+package cryptoengine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Salt rotation: the salt only takes part in the derivation of the nonces, which are sent with the messages, so a new salt
+// never prevents the decryption of the messages encrypted with the previous one. The SaltRotationPolicy of the Config
+// rotates it after an interval, after an amount of encrypted messages, or both, whichever comes first.
+// The zero policy never rotates the salt automatically, RotateSalt rotates it on demand.
+// The creation time of the salt is stored next to it in the key store, so the interval survives the restarts.
+// The amount of messages is counted in memory: it restarts from zero with the engine.
+// Salt info format:
+// |version| => 1 byte
+// |created| => 8 bytes (little endian unix time in seconds)
+
+const (
+	saltInfoSuffixFormat = "%s_salt_info.key" // the creation time of the salt, for instance: sec51_salt_info.key
+	saltInfoVersion      = 1
+	saltInfoSize         = 1 + 8
+)
+
+var (
+	SaltInfoError = errors.New("The salt info is corrupted")
+
+	// rotates the salt every 7 days
+	SaltRotationWeekly = SaltRotationPolicy{Interval: 7 * 24 * time.Hour}
+)
+
+// The SaltRotationPolicy defines when the salt is rotated automatically.
+// The zero value disables the automatic rotation.
+type SaltRotationPolicy struct {
+	Interval time.Duration // the salt is rotated once it's older than the interval. Zero means no interval
+	Messages uint64        // the salt is rotated after the amount of nonces derived from it. Zero means no limit
+}
+
+// returns true when the policy rotates the salt automatically
+func (policy SaltRotationPolicy) enabled() bool {
+	return policy.Interval > 0 || policy.Messages > 0
+}
+
+// returns true when a salt created at the given time and used for the amount of messages must be rotated
+func (policy SaltRotationPolicy) due(created time.Time, messages uint64, now time.Time) bool {
+	if policy.Interval > 0 && now.Sub(created) >= policy.Interval {
+		return true
+	}
+	return policy.Messages > 0 && messages >= policy.Messages
+}
+
+// This method replaces the salt with a new random one and stores it with its creation time.
+// The messages encrypted with the previous salt can still be decrypted.
+// When the Config has a ManifestKey, the manifest is updated with the new salt.
+func (engine *CryptoEngine) RotateSalt() error {
+	if err := engine.allow(operationEncrypt, operationOwnKeys); err != nil {
+		return err
+	}
+
+	engine.counterMutex.Lock()
+	// the engine has been reloaded: the salt is rotated on the new engine
+	if successor := engine.successor; successor != nil {
+		engine.counterMutex.Unlock()
+		return successor.RotateSalt()
+	}
+	defer engine.counterMutex.Unlock()
+
+	return engine.rotateSalt(time.Now())
+}
+
+// returns the salt the nonces are derived from, after rotating it if the policy requires it
+// the counterMutex must be held
+func (engine *CryptoEngine) currentSalt(n uint64) ([keySize]byte, error) {
+	policy := engine.config.SaltRotation
+	if policy.enabled() {
+		now := time.Now()
+		// the salts kept in memory start their interval when they are first used
+		if engine.saltCreated.IsZero() {
+			engine.saltCreated = now
+		}
+		if policy.due(engine.saltCreated, engine.saltMessages+n, now) {
+			if err := engine.rotateSalt(now); err != nil {
+				return engine.salt, err
+			}
+		}
+	}
+	engine.saltMessages += n
+	return engine.salt, nil
+}
+
+// generates a new salt and stores it when the engine loaded it from the key store
+// the counterMutex must be held
+func (engine *CryptoEngine) rotateSalt(now time.Time) error {
+	salt, err := generateSalt()
+	if err != nil {
+		return err
+	}
+
+	// the engines whose keys are derived from a password or are ephemeral keep the salt in memory
+	if engine.saltStored {
+		store := engine.config.keyStore()
+		name := fmt.Sprintf(saltSuffixFormat, engine.context)
+		if err := store.DeleteKey(name); err != nil {
+			return err
+		}
+		if err := store.WriteKey(name, salt[:]); err != nil {
+			return err
+		}
+		if err := writeSaltInfo(store, engine.context, now); err != nil {
+			return err
+		}
+		if engine.config.ManifestKey != nil {
+			if err := updateManifest(store, engine.context, engine.config.ManifestKey); err != nil {
+				return err
+			}
+		}
+	}
+
+	engine.salt = salt
+	engine.saltCreated = now
+	engine.saltMessages = 0
+	return nil
+}
+
+// loads the creation time of the salt
+// the salts stored before the info existed are considered created now
+func loadSaltInfo(store KeyStore, id string) (time.Time, error) {
+	data, err := store.ReadKey(fmt.Sprintf(saltInfoSuffixFormat, id))
+	if err == KeyNotFoundError {
+		now := time.Now()
+		return now, writeSaltInfo(store, id, now)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(data) != saltInfoSize || data[0] != saltInfoVersion {
+		return time.Time{}, SaltInfoError
+	}
+	return time.Unix(int64(binary.LittleEndian.Uint64(data[1:])), 0), nil
+}
+
+// stores the creation time of the salt, replacing the previous one
+func writeSaltInfo(store KeyStore, id string, created time.Time) error {
+	data := make([]byte, saltInfoSize)
+	data[0] = saltInfoVersion
+	binary.LittleEndian.PutUint64(data[1:], uint64(created.Unix()))
+
+	name := fmt.Sprintf(saltInfoSuffixFormat, id)
+	if err := store.DeleteKey(name); err != nil {
+		return err
+	}
+	return store.WriteKey(name, data)
+}
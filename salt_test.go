@@ -0,0 +1,107 @@
+package cryptoengine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaltRotationPolicy(t *testing.T) {
+
+	created := time.Now().Add(-time.Hour)
+	if (SaltRotationPolicy{}).due(created, 1<<40, created.Add(365*24*time.Hour)) {
+		t.Fatal("The zero policy should never rotate the salt")
+	}
+	if !SaltRotationWeekly.due(created, 0, created.Add(7*24*time.Hour)) || SaltRotationWeekly.due(created, 0, created.Add(6*24*time.Hour)) {
+		t.Fatal("The weekly policy should rotate the salt after 7 days")
+	}
+	if !(SaltRotationPolicy{Messages: 10}).due(created, 10, created) {
+		t.Fatal("The policy should rotate the salt after 10 messages")
+	}
+
+}
+
+func TestSaltRotation(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	manifestKey := []byte("Sec51 salt rotation manifest key")
+	config := Config{
+		KeyStore:     store,
+		ManifestKey:  manifestKey,
+		SaltRotation: SaltRotationPolicy{Interval: time.Hour, Messages: 3},
+	}
+	engine, err := InitCryptoEngineWithConfig("Sec51Salt", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created, err := loadSaltInfo(store, "sec51salt"); err != nil || created.Unix() != engine.saltCreated.Unix() {
+		t.Fatalf("The salt info should have been stored: %v %v\n", created, err)
+	}
+
+	// the salt is rotated with the third message
+	var encrypted [][]byte
+	salts := make(map[[keySize]byte]bool)
+	for i := 0; i < 4; i++ {
+		salts[engine.salt] = true
+		msg, err := NewMessage("salted", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encryptedMessage, err := engine.NewEncryptedMessage(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := encryptedMessage.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted = append(encrypted, data)
+	}
+	if len(salts) != 2 {
+		t.Fatalf("Expected 2 salts, got %d\n", len(salts))
+	}
+
+	// the rotated salt is stored and matches the manifest
+	reloaded, err := InitCryptoEngineWithConfig("Sec51Salt", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.salt != engine.salt {
+		t.Fatal("The rotated salt should have been stored")
+	}
+	for _, data := range encrypted {
+		if _, err := reloaded.Decrypt(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the expired salt is rotated with the next message
+	reloaded.saltCreated = time.Now().Add(-2 * time.Hour)
+	previous := reloaded.salt
+	if _, err := reloaded.nextNonce(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.salt == previous {
+		t.Fatal("The expired salt should have been rotated")
+	}
+
+	// the manual rotation
+	previous = reloaded.salt
+	if err := reloaded.RotateSalt(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.salt == previous {
+		t.Fatal("The salt should have been rotated")
+	}
+
+	// the corrupted salt info
+	if err := store.DeleteKey("sec51salt_salt_info.key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteKey("sec51salt_salt_info.key", []byte{saltInfoVersion}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InitCryptoEngineWithConfig("Sec51Salt", config); err != SaltInfoError {
+		t.Fatalf("Expected %v, got %v\n", SaltInfoError, err)
+	}
+
+}
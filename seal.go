@@ -0,0 +1,167 @@
+package cryptoengine
+
+import (
+	"context"
+	"fmt"
+	"github.com/sec51/convert/smallendian"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"sync"
+)
+
+const (
+	maxPooledBufferSize = 64 * 1024 // buffers bigger than this are not returned to the pool, so a single huge message does not pin memory
+)
+
+var (
+	// pool of buffers used to serialize the clear text before sealing it
+	clearTextPool = sync.Pool{
+		New: func() interface{} {
+			buffer := make([]byte, 0, 1024)
+			return &buffer
+		},
+	}
+)
+
+// serializes the message into a buffer taken from the pool
+func getClearTextBuffer(msg Message) *[]byte {
+	buffer := clearTextPool.Get().(*[]byte)
+	*buffer = msg.appendBytes((*buffer)[:0])
+	return buffer
+}
+
+// wipes the clear text and puts the buffer back into the pool
+func putClearTextBuffer(buffer *[]byte) {
+	if cap(*buffer) > maxPooledBufferSize {
+		return
+	}
+	data := *buffer
+	for i := range data {
+		data[i] = 0
+	}
+	*buffer = data[:0]
+	clearTextPool.Put(buffer)
+}
+
+// This method encrypts the message with the symmetric key and appends the serialized encrypted message to dst,
+// exactly as ToBytes would produce it.
+// It's meant for high throughput services: when dst has enough capacity no buffer is allocated for the ciphertext.
+// To reuse the storage of a previous output, pass it as dst[:0].
+func (engine *CryptoEngine) SealTo(dst []byte, msg Message) ([]byte, error) {
+	return engine.sealTo(dst, msg, func(out, clearText []byte, nonce *[nonceSize]byte) []byte {
+		return secretbox.Seal(out, clearText, nonce, &engine.secretKey)
+	})
+}
+
+// This method works like SealTo, the message is encrypted with the public key of the peer, like NewEncryptedMessageWithPubKey
+func (engine *CryptoEngine) SealToWithPubKey(dst []byte, msg Message, verificationEngine VerificationEngine) ([]byte, error) {
+	peerPublicKey := verificationEngine.PublicKey()
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return dst, KeyNotValidError
+	}
+
+	preSharedKey := engine.preSharedKey(peerPublicKey)
+	return engine.sealTo(dst, msg, func(out, clearText []byte, nonce *[nonceSize]byte) []byte {
+		return box.SealAfterPrecomputation(out, clearText, nonce, &preSharedKey)
+	})
+}
+
+func (engine *CryptoEngine) sealTo(dst []byte, msg Message, seal func(out, clearText []byte, nonce *[nonceSize]byte) []byte) ([]byte, error) {
+
+	// serialize the message into a pooled buffer
+	buffer := getClearTextBuffer(msg)
+	defer putClearTextBuffer(buffer)
+	msgBytes := *buffer
+
+	// make sure the message, once encrypted, does not exceed the maximum size
+	length := encryptedMessageSize(len(msgBytes))
+	if length > engine.maxMessageSize() {
+		return dst, MessageTooLargeError
+	}
+
+	// derive nonce
+	nonce, err := engine.nextNonce()
+	if err != nil {
+		return dst, err
+	}
+
+	// length
+	lengthBytes := smallendian.ToUint64(length)
+	dst = append(dst, lengthBytes[:]...)
+
+	// nonce
+	dst = append(dst, nonce[:]...)
+
+	// the encrypted data is appended by the cipher directly
+	return seal(dst, msgBytes, &nonce), nil
+}
+
+// This method decrypts a message encrypted with the symmetric key, appends its text to dst and returns the message type.
+// It's the counterpart of SealTo: when dst has enough capacity no buffer is allocated for the clear text.
+// The message version is validated like Decrypt does. On error dst is returned unchanged.
+func (engine *CryptoEngine) OpenTo(dst, encryptedBytes []byte) ([]byte, int, error) {
+	return engine.openTo(dst, encryptedBytes, "secret", func(out []byte, m EncryptedMessage) ([]byte, bool) {
+		return secretbox.Open(out, m.data, &m.nonce, &engine.secretKey)
+	})
+}
+
+// This method works like OpenTo, the message is decrypted with the public key of the peer, like DecryptWithPublicKey
+func (engine *CryptoEngine) OpenToWithPublicKey(dst, encryptedBytes []byte, verificationEngine VerificationEngine) ([]byte, int, error) {
+	peerPublicKey := verificationEngine.PublicKey()
+	if ConstantTimeEqual(peerPublicKey[:], emptyKey) {
+		return dst, 0, KeyNotValidError
+	}
+
+	preSharedKey := engine.preSharedKey(peerPublicKey)
+	return engine.openTo(dst, encryptedBytes, peerReplayIdentifier(peerPublicKey), func(out []byte, m EncryptedMessage) ([]byte, bool) {
+		return box.OpenAfterPrecomputation(out, m.data, &m.nonce, &preSharedKey)
+	})
+}
+
+func (engine *CryptoEngine) openTo(dst, encryptedBytes []byte, peer string, open func(out []byte, m EncryptedMessage) ([]byte, bool)) ([]byte, int, error) {
+	if err := engine.allow(operationDecrypt); err != nil {
+		return dst, 0, err
+	}
+
+	options := engine.parseOptions()
+	encryptedMessage, err := encryptedMessageFromBytes(encryptedBytes, options)
+	if err != nil {
+		return dst, 0, err
+	}
+
+	out, valid := open(dst, encryptedMessage)
+	if !valid {
+		return dst, 0, MessageDecryptionError
+	}
+
+	// the clear text is wiped from the spare capacity of dst if the message is rejected
+	clearText := out[len(dst):]
+	fail := func(err error) ([]byte, int, error) {
+		for i := range clearText {
+			clearText[i] = 0
+		}
+		return dst, 0, err
+	}
+
+	// the nonce is remembered only once the message is authenticated
+	if err := engine.checkReplay(context.Background(), peer, encryptedMessage.nonce); err != nil {
+		return fail(err)
+	}
+
+	// validate the version and the type, then move the text over them
+	if len(clearText) < 4+4+1 {
+		return fail(&ParseError{Field: "message", Reason: fmt.Sprintf("%d bytes, the minimum is %d", len(clearText), 4+4+1), Err: MessageParsingError})
+	}
+	var versionData, typeData [4]byte
+	copy(versionData[:], clearText[:4])
+	copy(typeData[:], clearText[4:8])
+	if version := smallendian.FromInt(versionData); !options.Legacy && !supportedVersions[version] {
+		return fail(&ParseError{Field: "version", Reason: fmt.Sprintf("version %d is not supported", version), Err: MessageVersionError})
+	}
+
+	n := copy(clearText, clearText[8:])
+	for i := n; i < len(clearText); i++ {
+		clearText[i] = 0
+	}
+	return out[:len(dst)+n], smallendian.FromInt(typeData), nil
+}
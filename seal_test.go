@@ -0,0 +1,116 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealTo(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := NewMessage("The quick brown fox jumps over the lazy dog", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := []byte("prefix")
+	dst := make([]byte, len(prefix), 1024)
+	copy(dst, prefix)
+
+	for i := 0; i < 3; i++ {
+		sealed, err := engine.SealTo(dst, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the data already in dst is preserved
+		if !bytes.Equal(sealed[:len(prefix)], prefix) {
+			t.Fatal("SealTo overwrote the content of dst")
+		}
+
+		// no reallocation happened
+		if &sealed[0] != &dst[0] {
+			t.Error("SealTo allocated a new buffer, although dst had enough capacity")
+		}
+
+		decrypted, err := engine.Decrypt(sealed[len(prefix):])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decrypted.Text != message.Text || decrypted.Type != message.Type {
+			t.Fatal("SealTo encryption/decryption broken")
+		}
+	}
+
+}
+
+func TestOpenTo(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51OpenTo", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := InitCryptoEngineWithConfig("Sec51OpenToPeer", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engineKey, err := NewVerificationEngineWithKeys(engine.PublicKey(), engine.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKey, err := NewVerificationEngineWithKeys(peer.PublicKey(), peer.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := NewMessage("The quick brown fox jumps over the lazy dog", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := engine.SealTo(nil, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := []byte("prefix")
+	dst := make([]byte, len(prefix), 1024)
+	copy(dst, prefix)
+	opened, messageType, err := engine.OpenTo(dst, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, append(prefix, message.Text...)) || messageType != message.Type {
+		t.Fatalf("OpenTo encryption/decryption broken: %q %d\n", opened, messageType)
+	}
+	if &opened[0] != &dst[0] {
+		t.Error("OpenTo allocated a new buffer, although dst had enough capacity")
+	}
+
+	// the public key messages
+	sealed, err = engine.SealToWithPubKey(sealed[:0], message, peerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := peer.DecryptWithPublicKey(sealed, engineKey); err != nil || decrypted.Text != message.Text {
+		t.Fatalf("SealToWithPubKey encryption/decryption broken: %v\n", err)
+	}
+	opened, messageType, err = peer.OpenToWithPublicKey(dst[:0], sealed, engineKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(opened) != message.Text || messageType != message.Type {
+		t.Fatalf("OpenToWithPublicKey encryption/decryption broken: %q %d\n", opened, messageType)
+	}
+
+	// the tampered messages leave dst unchanged
+	sealed[len(sealed)-1] ^= 0x01
+	if opened, _, err := peer.OpenToWithPublicKey(dst, sealed, engineKey); err != MessageDecryptionError || len(opened) != len(prefix) {
+		t.Fatalf("Expected %v, got %v\n", MessageDecryptionError, err)
+	}
+
+}
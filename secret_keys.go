@@ -0,0 +1,97 @@
+package cryptoengine
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"os"
+)
+
+// Large secret keys: the secret key and the nonce key files can hold 64 bytes keys, for the integrators who already
+// have 512 bits master secrets. The engine extracts the 32 bytes NaCl keys from them with HKDF-SHA-256,
+// each with its own label, so the entropy of the whole secret is used instead of its first half only.
+// The keys generated by the engine are still 32 bytes long and used as is.
+
+const (
+	LargeKeySize = 64 // size in bytes of the large secret keys
+
+	secretKeyExtractLabel = "cryptoengine secret key extraction"
+	nonceKeyExtractLabel  = "cryptoengine nonce key extraction"
+)
+
+// This function stores the secret key and the nonce key of the communicationIdentifier in the key store of the config,
+// before the engine is initialized with it. Each key is either 32 or 64 bytes long.
+// Existing key files are not overwritten: in that case os.ErrExist is returned.
+// With a ManifestKey, the other key files are generated and the manifest is updated, so the engine accepts the imported keys.
+func ImportSecretKeys(communicationIdentifier string, config Config, secretKey, nonceKey []byte) error {
+	for _, key := range [][]byte{secretKey, nonceKey} {
+		if len(key) != keySize && len(key) != LargeKeySize {
+			return KeySizeError
+		}
+	}
+	if config.MasterKey != nil && len(config.MasterKey) < minMasterKeySize {
+		return MasterKeyError
+	}
+	if config.ManifestKey != nil && len(config.ManifestKey) < minManifestKeySize {
+		return ManifestKeyError
+	}
+
+	id, err := keyContext(communicationIdentifier)
+	if err != nil {
+		return err
+	}
+	store := config.keyStore()
+	files := []struct {
+		format string
+		data   []byte
+	}{
+		{secretSuffixFormat, secretKey},
+		{nonceSuffixFormat, nonceKey},
+	}
+
+	// check first, so we do not end up with only one of the keys written
+	for _, file := range files {
+		if _, err := store.ReadKey(fmt.Sprintf(file.format, id)); err != KeyNotFoundError {
+			if err == nil {
+				return os.ErrExist
+			}
+			return err
+		}
+	}
+
+	for _, file := range files {
+		if err := store.WriteKey(fmt.Sprintf(file.format, id), file.data); err != nil {
+			return err
+		}
+	}
+	return updateImportManifest(store, id, config)
+}
+
+// loads the secret key from the store, or generates and stores a 32 bytes one if it does not exist
+func loadOrGenerateSecretKey(store KeyStore, nameFormat, id, label string) ([keySize]byte, error) {
+	data, err := store.ReadKey(fmt.Sprintf(nameFormat, id))
+	if err == KeyNotFoundError {
+		return loadOrGenerateKey(store, nameFormat, id, generateSecretKey)
+	}
+	if err != nil {
+		return [keySize]byte{}, err
+	}
+	return extractSecretKey(data, label)
+}
+
+// returns the 32 bytes keys as is and extracts the 64 bytes keys
+func extractSecretKey(data []byte, label string) ([keySize]byte, error) {
+	var key [keySize]byte
+	switch len(data) {
+	case keySize:
+		copy(key[:], data)
+	case LargeKeySize:
+		if _, err := io.ReadFull(hkdf.New(sha256.New, data, nil, []byte(label)), key[:]); err != nil {
+			return key, err
+		}
+	default:
+		return key, KeySizeError
+	}
+	return key, nil
+}
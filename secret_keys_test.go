@@ -0,0 +1,119 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"testing"
+)
+
+func TestImportLargeSecretKeys(t *testing.T) {
+
+	secretKey := make([]byte, LargeKeySize)
+	nonceKey := make([]byte, LargeKeySize)
+	if _, err := rand.Read(secretKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rand.Read(nonceKey); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{KeyStore: NewMemoryKeyStore()}
+	if err := ImportSecretKeys("Sec51LargeKeys", config, secretKey, nonceKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportSecretKeys("Sec51LargeKeys", config, secretKey, nonceKey); err != os.ErrExist {
+		t.Fatalf("Expected %v, got %v\n", os.ErrExist, err)
+	}
+
+	engine, err := InitCryptoEngineWithConfig("Sec51LargeKeys", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(engine.secretKey[:], secretKey[:keySize]) || engine.secretKey == engine.nonceKey {
+		t.Fatal("The 64 bytes keys should be extracted")
+	}
+
+	// the same secrets give the same engine keys
+	reloaded, err := InitCryptoEngineWithConfig("Sec51LargeKeys", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.secretKey != engine.secretKey || reloaded.nonceKey != engine.nonceKey {
+		t.Fatal("The extracted keys should be deterministic")
+	}
+
+	msg, err := NewMessage("512 bits of entropy", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := reloaded.Decrypt(encryptedBytes); err != nil || decrypted.Text != msg.Text {
+		t.Fatalf("The message could not be decrypted: %v\n", err)
+	}
+
+}
+
+func TestImportSecretKeysSize(t *testing.T) {
+
+	config := Config{KeyStore: NewMemoryKeyStore()}
+	if err := ImportSecretKeys("Sec51SmallKeys", config, make([]byte, 48), make([]byte, keySize)); err != KeySizeError {
+		t.Fatalf("Expected %v, got %v\n", KeySizeError, err)
+	}
+
+	// the 32 bytes keys are used as is
+	secretKey := bytes.Repeat([]byte{1}, keySize)
+	nonceKey := bytes.Repeat([]byte{2}, keySize)
+	if err := ImportSecretKeys("Sec51SmallKeys", config, secretKey, nonceKey); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := InitCryptoEngineWithConfig("Sec51SmallKeys", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(engine.secretKey[:], secretKey) || !bytes.Equal(engine.nonceKey[:], nonceKey) {
+		t.Fatal("The 32 bytes keys should be used as is")
+	}
+
+	// the other sizes are rejected
+	store := NewMemoryKeyStore()
+	if err := store.WriteKey("sec51oddkeys_secret.key", make([]byte, 48)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InitCryptoEngineWithConfig("Sec51OddKeys", Config{KeyStore: store}); err != KeySizeError {
+		t.Fatalf("Expected %v, got %v\n", KeySizeError, err)
+	}
+
+}
+
+func TestImportSecretKeysWithManifest(t *testing.T) {
+
+	config := Config{KeyStore: NewMemoryKeyStore(), ManifestKey: []byte("Sec51 secret keys manifest key")}
+	secretKey := make([]byte, LargeKeySize)
+	nonceKey := make([]byte, keySize)
+	if _, err := rand.Read(secretKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rand.Read(nonceKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportSecretKeys("Sec51ManifestKeys", config, secretKey, nonceKey); err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := InitCryptoEngineWithConfig("Sec51ManifestKeys", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(engine.nonceKey[:], nonceKey) {
+		t.Fatal("The imported nonce key has not been loaded")
+	}
+
+}
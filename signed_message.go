@@ -0,0 +1,102 @@
+package cryptoengine
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Signed messages in clear: the data which must be readable by anyone, but whose origin must be authenticated,
+// for instance a configuration broadcast, uses the framing of the messages with a header and the SuiteSigned suite.
+// The message is serialized in clear and followed by the Ed25519 signature of the engine, instead of the nonce and the ciphertext:
+//
+//	|magic|         => 4 bytes ("CEX1")
+//	|header length| => 4 bytes (uint32 little endian)
+//	|header|        => N bytes (the fields, the suite is SuiteSigned)
+//	|message|       => M bytes (the serialized message, in clear)
+//	|signature|     => 64 bytes (Ed25519 signature of all the above)
+//	|checksum|      => 4 bytes (optional CRC32C little endian of all the above)
+//
+// The suite is authenticated by the signature, and the engines refuse to decrypt a signed message, so it cannot be confused with an encrypted one.
+// The validity period of the header is enforced like for the encrypted messages.
+
+var (
+	SignedMessageError = errors.New("The signed message is not valid")
+)
+
+// This method signs the message and the header with the engine signing key. The message is NOT encrypted.
+func (engine *CryptoEngine) NewSignedMessage(msg Message, header MessageHeader) ([]byte, error) {
+	if err := engine.allow(operationOwnKeys); err != nil {
+		return nil, err
+	}
+
+	header.Suite = SuiteSigned
+	headerBytes, err := header.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := getClearTextBuffer(msg)
+	defer putClearTextBuffer(buffer)
+	msgBytes := *buffer
+
+	size := uint64(headerPrefixSize+len(headerBytes)+ed25519.SignatureSize) + uint64(len(msgBytes))
+	if header.Checksum != 0 {
+		size += checksumSize
+	}
+	if size > engine.maxMessageSize() {
+		return nil, MessageTooLargeError
+	}
+
+	data := make([]byte, 0, size)
+	data = append(data, headerMagic...)
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(headerBytes)))
+	data = append(data, length[:]...)
+	data = append(data, headerBytes...)
+	data = append(data, msgBytes...)
+	data = append(data, ed25519.Sign(engine.signingKey, data)...)
+
+	return header.appendChecksum(data), nil
+}
+
+// This method verifies the signature of a message returned by NewSignedMessage with the signing key of the peer,
+// and returns the message and its header
+func (e VerificationEngine) VerifySignedMessage(data []byte) (*Message, MessageHeader, error) {
+	if uint64(len(data)) > maxMessageSize {
+		return nil, MessageHeader{}, MessageTooLargeError
+	}
+
+	header, headerSize, err := parseHeader(data)
+	if err != nil {
+		return nil, MessageHeader{}, err
+	}
+
+	data, err = header.removeChecksum(data, headerSize)
+	if err != nil {
+		return nil, MessageHeader{}, err
+	}
+
+	if header.Suite != SuiteSigned {
+		return nil, MessageHeader{}, HeaderSuiteError
+	}
+	if len(data) < headerSize+ed25519.SignatureSize {
+		return nil, MessageHeader{}, SignedMessageError
+	}
+
+	signed := data[:len(data)-ed25519.SignatureSize]
+	if err := e.Verify(signed, data[len(signed):]); err != nil {
+		return nil, MessageHeader{}, err
+	}
+
+	if err := header.checkValidity(time.Now()); err != nil {
+		return nil, MessageHeader{}, err
+	}
+
+	msg, err := messageFromBytes(signed[headerSize:], ParseOptions{})
+	if err != nil {
+		return nil, MessageHeader{}, err
+	}
+	return msg, header, nil
+}
@@ -0,0 +1,95 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSignedMessage(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51Signed", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verificationEngine, err := NewVerificationEngineWithKeys(engine.PublicKey(), engine.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := NewMessage("log_level=debug", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := MessageHeader{KeyID: []byte("config"), Checksum: ChecksumCRC32C, NotAfter: time.Now().Add(time.Hour)}
+	data, err := engine.NewSignedMessage(msg, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the message is readable by anyone
+	if !bytes.Contains(data, []byte(msg.Text)) {
+		t.Fatal("The signed message is not in clear")
+	}
+
+	verified, verifiedHeader, err := verificationEngine.VerifySignedMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.Text != msg.Text || verified.Type != 2 || verifiedHeader.Suite != SuiteSigned || !bytes.Equal(verifiedHeader.KeyID, header.KeyID) {
+		t.Fatal("The verified message does not match the original one")
+	}
+
+	// the text is authenticated: the checksum is recomputed by the attacker
+	index := bytes.Index(data, []byte("debug"))
+	tampered := append([]byte{}, data[:len(data)-checksumSize]...)
+	tampered[index] ^= 1
+	tampered = MessageHeader{Checksum: ChecksumCRC32C}.appendChecksum(tampered)
+	if _, _, err := verificationEngine.VerifySignedMessage(tampered); err != SignatureError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", SignatureError, err)
+	}
+
+	// another signer
+	other, err := InitCryptoEngineWithConfig("Sec51SignedOther", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherVerificationEngine, err := NewVerificationEngineWithKeys(other.PublicKey(), other.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := otherVerificationEngine.VerifySignedMessage(data); err != SignatureError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", SignatureError, err)
+	}
+
+	// a signed message is not an encrypted one, and the other way around
+	if _, _, err := engine.DecryptWithHeader(data); err != HeaderSuiteError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", HeaderSuiteError, err)
+	}
+	encrypted, err := engine.NewEncryptedMessageWithHeader(msg, MessageHeader{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := verificationEngine.VerifySignedMessage(encrypted); err != HeaderSuiteError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", HeaderSuiteError, err)
+	}
+
+	// the validity period is enforced
+	expired, err := engine.NewSignedMessage(msg, MessageHeader{NotAfter: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := verificationEngine.VerifySignedMessage(expired); err != MessageExpiredError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageExpiredError, err)
+	}
+
+	// the encrypt only engines have no signing key
+	encryptOnly, err := InitCryptoEngineWithConfig("Sec51Signed", Config{Role: RoleEncryptOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encryptOnly.NewSignedMessage(msg, MessageHeader{}); err != RoleError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", RoleError, err)
+	}
+
+}
@@ -0,0 +1,72 @@
+package cryptoengine
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log"
+)
+
+// load the Ed25519 signing key from the id_signing_private.key, which holds the seed
+// if the key does not exist, generate a new seed and store it together with the id_signing_public.key
+func loadSigningKey(store KeyStore, id string) (ed25519.PrivateKey, error) {
+
+	privateFile := fmt.Sprintf(signingPrivateSuffixFormat, id)
+	publicFile := fmt.Sprintf(signingPublicSuffixFormat, id)
+
+	seed, err := readStoreKey(store, privateFile)
+	if err == nil {
+		return ed25519.NewKeyFromSeed(seed[:]), nil
+	}
+	if err != KeyNotFoundError {
+		return nil, err
+	}
+
+	// generate the random seed
+	if seed, err = generateSecretKey(); err != nil {
+		return nil, err
+	}
+	signingKey := ed25519.NewKeyFromSeed(seed[:])
+
+	// write the public key first, so the verification engine can load it
+	if err := store.WriteKey(publicFile, signingKey.Public().(ed25519.PublicKey)); err != nil {
+		return nil, err
+	}
+
+	// write the seed
+	if err := store.WriteKey(privateFile, seed[:]); err != nil {
+		// delete the public key, otherwise we remain in an unwanted state
+		if err := store.DeleteKey(publicFile); err != nil {
+			log.Printf("[SEVERE] - The signing private key, %s, failed to be persisted. \nWhile trying to cleanup also the signing public key previosuly stored, %s, the operation failed as well.\nPlease delete both keys manually: %s - %s", privateFile, publicFile, privateFile, publicFile)
+		}
+		return nil, err
+	}
+
+	return signingKey, nil
+}
+
+// Gives access to the Ed25519 signing public key
+func (engine *CryptoEngine) SigningPublicKey() []byte {
+	return engine.signingKey.Public().(ed25519.PublicKey)
+}
+
+// Signs the data with the engine Ed25519 signing key
+func (engine *CryptoEngine) Sign(data []byte) []byte {
+	return ed25519.Sign(engine.signingKey, data)
+}
+
+// Verifies the Ed25519 signature of the data with the peer signing public key
+// It returns KeyNotValidError if the signing key is not provisioned and SignatureError if the signature is not valid
+func (e VerificationEngine) Verify(data, signature []byte) error {
+	if e.signingPublicKey == [keySize]byte{} {
+		return KeyNotValidError
+	}
+	if !ed25519.Verify(ed25519.PublicKey(e.signingPublicKey[:]), data, signature) {
+		return SignatureError
+	}
+	return nil
+}
+
+// Gives access to the peer signing public key
+func (e VerificationEngine) SigningPublicKey() [keySize]byte {
+	return e.signingPublicKey
+}
@@ -0,0 +1,135 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSigning(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the verification engine loads the signing public key from the key files
+	verificationEngine, err := NewVerificationEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	signature := engine.Sign(data)
+
+	if err := verificationEngine.Verify(data, signature); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verificationEngine.Verify(append(data, '!'), signature); err != SignatureError {
+		t.Errorf("The expected error is: SignatureError, instead we've got: %v\n", err)
+	}
+
+	// the same works when the keys are provided directly
+	keysVerificationEngine, err := NewVerificationEngineWithKeys(engine.PublicKey(), engine.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keysVerificationEngine.Verify(data, signature); err != nil {
+		t.Fatal(err)
+	}
+
+	// without the signing key nothing can be verified
+	keyVerificationEngine, err := NewVerificationEngineWithKey(engine.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keyVerificationEngine.Verify(data, signature); err != KeyNotValidError {
+		t.Errorf("The expected error is: KeyNotValidError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestMinisign(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := ParseMinisignPublicKey(engine.MinisignPublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if publicKey != engine.MinisignPublicKey() {
+		t.Fatal("The minisign public key does not round trip")
+	}
+
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	minisig, err := engine.SignMinisign(bytes.NewReader(data), "file:fox.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trustedComment, err := publicKey.Verify(bytes.NewReader(data), minisig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if trustedComment != "file:fox.txt" {
+		t.Errorf("Unexpected trusted comment: %s\n", trustedComment)
+	}
+
+	// tamper with the trusted comment
+	tampered := bytes.Replace(minisig, []byte("fox.txt"), []byte("dog.txt"), 1)
+	if _, err := publicKey.Verify(bytes.NewReader(data), tampered); err != SignatureError {
+		t.Errorf("The expected error is: SignatureError, instead we've got: %v\n", err)
+	}
+
+	// tamper with the data
+	if _, err := publicKey.Verify(strings.NewReader("The quick brown fox"), minisig); err != SignatureError {
+		t.Errorf("The expected error is: SignatureError, instead we've got: %v\n", err)
+	}
+
+	// a signature from another key
+	otherEngine, err := InitCryptoEngine("Sec51Peer2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := otherEngine.MinisignPublicKey().Verify(bytes.NewReader(data), minisig); err != MinisignKeyIdError {
+		t.Errorf("The expected error is: MinisignKeyIdError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestMinisignLegacySignature(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51Peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := engine.MinisignPublicKey()
+
+	// build a legacy signature, where the data is signed as is
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	signature := ed25519.Sign(engine.signingKey, data)
+	trustedComment := "timestamp:0"
+	globalSignature := ed25519.Sign(engine.signingKey, append(append([]byte{}, signature...), trustedComment...))
+
+	encoded := append(append([]byte(minisignLegacyAlgorithm), key.keyId[:]...), signature...)
+	minisig := "untrusted comment: legacy\n" +
+		base64.StdEncoding.EncodeToString(encoded) + "\n" +
+		"trusted comment: " + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSignature) + "\n"
+
+	if _, err := key.Verify(bytes.NewReader(data), []byte(minisig)); err != nil {
+		t.Fatal(err)
+	}
+
+}
@@ -0,0 +1,143 @@
+package cryptoengine
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"os"
+)
+
+// SQLite key store: the keys, the public keys of the registered peers and the nonce counters of all the engines
+// are stored in the tables of a single database file, which is easier to back up and to lock than a folder of key files.
+// The store works with any database/sql SQLite driver, for instance github.com/mattn/go-sqlite3 or modernc.org/sqlite,
+// opened by the application. To encrypt the whole file, open it with an SQLCipher driver.
+
+const (
+	sqliteCreateKeysTable     = "CREATE TABLE IF NOT EXISTS cryptoengine_keys (name TEXT PRIMARY KEY, data BLOB NOT NULL)"
+	sqliteCreateCountersTable = "CREATE TABLE IF NOT EXISTS cryptoengine_counters (context TEXT PRIMARY KEY, next INTEGER NOT NULL)"
+	sqliteSelectKey           = "SELECT data FROM cryptoengine_keys WHERE name = ?"
+	sqliteInsertKey           = "INSERT INTO cryptoengine_keys (name, data) VALUES (?, ?)"
+	sqliteDeleteKey           = "DELETE FROM cryptoengine_keys WHERE name = ?"
+	sqliteListKeys            = "SELECT name FROM cryptoengine_keys WHERE substr(name, 1, ?) = ?"
+	sqliteSelectCounter       = "SELECT next FROM cryptoengine_counters WHERE context = ?"
+	sqliteUpsertCounter       = "INSERT INTO cryptoengine_counters (context, next) VALUES (?, ?) ON CONFLICT (context) DO UPDATE SET next = excluded.next"
+)
+
+// The SQLiteKeyStore is a KeyStore and a CounterStore backed by an SQLite database
+type SQLiteKeyStore struct {
+	db *sql.DB
+}
+
+// This function returns a key store using the SQLite database, creating its tables if they do not exist
+func NewSQLiteKeyStore(db *sql.DB) (*SQLiteKeyStore, error) {
+	for _, statement := range []string{sqliteCreateKeysTable, sqliteCreateCountersTable} {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLiteKeyStore{db: db}, nil
+}
+
+// This method reads the key row
+func (store *SQLiteKeyStore) ReadKey(name string) ([]byte, error) {
+	return store.ReadKeyContext(context.Background(), name)
+}
+
+// This method reads the key row within the context deadline
+func (store *SQLiteKeyStore) ReadKeyContext(ctx context.Context, name string) ([]byte, error) {
+	var data []byte
+	err := store.db.QueryRowContext(ctx, sqliteSelectKey, name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, KeyNotFoundError
+	}
+	return data, err
+}
+
+// This method inserts the key row, in a transaction so an existing key is never overwritten
+func (store *SQLiteKeyStore) WriteKey(name string, data []byte) error {
+	return store.WriteKeyContext(context.Background(), name, data)
+}
+
+// This method inserts the key row within the context deadline
+func (store *SQLiteKeyStore) WriteKeyContext(ctx context.Context, name string, data []byte) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing []byte
+	switch err := tx.QueryRowContext(ctx, sqliteSelectKey, name).Scan(&existing); err {
+	case nil:
+		return os.ErrExist
+	case sql.ErrNoRows:
+	default:
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, sqliteInsertKey, name, data); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// This method deletes the key row
+func (store *SQLiteKeyStore) DeleteKey(name string) error {
+	return store.DeleteKeyContext(context.Background(), name)
+}
+
+// This method deletes the key row within the context deadline
+func (store *SQLiteKeyStore) DeleteKeyContext(ctx context.Context, name string) error {
+	_, err := store.db.ExecContext(ctx, sqliteDeleteKey, name)
+	return err
+}
+
+// This method lists the key rows whose name starts with the prefix
+func (store *SQLiteKeyStore) ListKeys(prefix string) ([]string, error) {
+	rows, err := store.db.Query(sqliteListKeys, len(prefix), prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		keys = append(keys, name)
+	}
+	return keys, rows.Err()
+}
+
+// This method reserves n counters for the context, in a transaction
+func (store *SQLiteKeyStore) ReserveCounters(name string, n uint64) (uint64, error) {
+	return store.ReserveCountersContext(context.Background(), name, n)
+}
+
+// This method reserves n counters for the context within the context deadline
+func (store *SQLiteKeyStore) ReserveCountersContext(ctx context.Context, name string, n uint64) (uint64, error) {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// SQLite integers are signed 64 bits
+	var next int64
+	if err := tx.QueryRowContext(ctx, sqliteSelectCounter, name).Scan(&next); err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if next < 0 || n > math.MaxInt64 || next > math.MaxInt64-int64(n) {
+		return 0, CounterOverflowError
+	}
+
+	if _, err := tx.ExecContext(ctx, sqliteUpsertCounter, name, next+int64(n)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return uint64(next), nil
+}
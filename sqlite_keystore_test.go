@@ -0,0 +1,71 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"testing"
+)
+
+// opens an in memory database with the SQLite driver linked in the test binary, if any
+func openTestSQLite(t *testing.T) *sql.DB {
+	for _, driver := range sql.Drivers() {
+		if driver == "sqlite3" || driver == "sqlite" {
+			db, err := sql.Open(driver, ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.SetMaxOpenConns(1)
+			return db
+		}
+	}
+	t.Skip("No SQLite driver is registered")
+	return nil
+}
+
+func TestSQLiteKeyStore(t *testing.T) {
+
+	db := openTestSQLite(t)
+	defer db.Close()
+
+	store, err := NewSQLiteKeyStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := InitCryptoEngineWithConfig("Sec51SQLite", Config{KeyStore: store, CounterStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secretKey, err := store.ReadKey("sec51sqlite_secret.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secretKey, engine.secretKey[:]) {
+		t.Fatal("The key has not been stored in the database")
+	}
+
+	if err := store.WriteKey("sec51sqlite_secret.key", make([]byte, keySize)); err != os.ErrExist {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", os.ErrExist, err)
+	}
+
+	first, err := store.ReserveCounters("sec51sqlite", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.ReserveCounters("sec51sqlite", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first+10 {
+		t.Fatal("The counters have been reused")
+	}
+
+	if err := store.DeleteKey("sec51sqlite_secret.key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadKey("sec51sqlite_secret.key"); err != KeyNotFoundError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", KeyNotFoundError, err)
+	}
+}
@@ -0,0 +1,188 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/curve25519"
+	"os"
+)
+
+// Import of OpenSSH Ed25519 private keys.
+// The Ed25519 key becomes the engine signing key, and it's converted to X25519 (RFC 7748 / RFC 8032) for the encryption key pair,
+// so operators can reuse the SSH identity they already distribute.
+
+const (
+	sshPrivateKeyPEMType = "OPENSSH PRIVATE KEY"
+	sshKeyMagic          = "openssh-key-v1\x00"
+	sshEd25519KeyType    = "ssh-ed25519"
+)
+
+var (
+	SSHKeyFormatError    = errors.New("The OpenSSH private key is not valid")
+	SSHKeyEncryptedError = errors.New("The OpenSSH private key is encrypted, decrypt it first with: ssh-keygen -p")
+	SSHKeyTypeError      = errors.New("Only OpenSSH Ed25519 private keys can be imported")
+)
+
+// This function imports an OpenSSH Ed25519 private key (the content of id_ed25519) for the communicationIdentifier,
+// in the key store of the config, encrypted with its MasterKey if any. With a ManifestKey, the other key files are generated
+// and the manifest is updated, so InitCryptoEngineWithConfig accepts the imported keys.
+// It writes the key files which InitCryptoEngineWithConfig loads afterwards: the signing key pair is the SSH key itself,
+// the encryption key pair is its X25519 conversion. Existing key files are not overwritten: in that case os.ErrExist is returned.
+func ImportSSHKey(communicationIdentifier string, config Config, data []byte) error {
+
+	signingKey, err := parseSSHEd25519Key(data)
+	if err != nil {
+		return err
+	}
+	if config.MasterKey != nil && len(config.MasterKey) < minMasterKeySize {
+		return MasterKeyError
+	}
+	if config.ManifestKey != nil && len(config.ManifestKey) < minManifestKeySize {
+		return ManifestKeyError
+	}
+
+	public, private := ed25519ToX25519(signingKey)
+	id, err := keyContext(communicationIdentifier)
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		format string
+		data   []byte
+	}{
+		{publicKeySuffixFormat, public[:]},
+		{privateSuffixFormat, private[:]},
+		{signingPublicSuffixFormat, signingKey.Public().(ed25519.PublicKey)},
+		{signingPrivateSuffixFormat, signingKey.Seed()},
+	}
+
+	store := config.keyStore()
+
+	// check first, so we do not end up with only part of the keys written
+	for _, file := range files {
+		if _, err := store.ReadKey(fmt.Sprintf(file.format, id)); err != KeyNotFoundError {
+			if err == nil {
+				return os.ErrExist
+			}
+			return err
+		}
+	}
+
+	for _, file := range files {
+		if err := store.WriteKey(fmt.Sprintf(file.format, id), file.data); err != nil {
+			return err
+		}
+	}
+
+	return updateImportManifest(store, id, config)
+}
+
+// converts the Ed25519 private key to an X25519 key pair: the scalar is the clamped first half of SHA-512(seed)
+func ed25519ToX25519(signingKey ed25519.PrivateKey) ([keySize]byte, [keySize]byte) {
+	var public, private [keySize]byte
+
+	hash := sha512.Sum512(signingKey.Seed())
+	copy(private[:], hash[:keySize])
+	private[0] &= 248
+	private[31] &= 127
+	private[31] |= 64
+
+	curve25519.ScalarBaseMult(&public, &private)
+	return public, private
+}
+
+// parses the PEM encoded openssh-key-v1 format, only unencrypted files with a single Ed25519 key are supported
+func parseSSHEd25519Key(data []byte) (ed25519.PrivateKey, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != sshPrivateKeyPEMType {
+		return nil, SSHKeyFormatError
+	}
+
+	reader := sshReader{data: block.Bytes}
+	if !bytes.HasPrefix(reader.data, []byte(sshKeyMagic)) {
+		return nil, SSHKeyFormatError
+	}
+	reader.data = reader.data[len(sshKeyMagic):]
+
+	cipherName := reader.readString()
+	kdfName := reader.readString()
+	reader.readString() // kdf options
+	keys := reader.readUint32()
+	reader.readString() // public key
+	private := sshReader{data: reader.readString()}
+
+	if reader.err != nil {
+		return nil, SSHKeyFormatError
+	}
+
+	if string(cipherName) != "none" || string(kdfName) != "none" {
+		return nil, SSHKeyEncryptedError
+	}
+
+	if keys != 1 {
+		return nil, SSHKeyFormatError
+	}
+
+	// the two check integers must match, otherwise the key was not decrypted correctly
+	if private.readUint32() != private.readUint32() {
+		return nil, SSHKeyFormatError
+	}
+
+	keyType := private.readString()
+	publicKey := private.readString()
+	privateKey := private.readString()
+
+	if private.err != nil {
+		return nil, SSHKeyFormatError
+	}
+
+	if string(keyType) != sshEd25519KeyType {
+		return nil, SSHKeyTypeError
+	}
+
+	// the private key is the seed followed by the public key
+	if len(publicKey) != ed25519.PublicKeySize || len(privateKey) != ed25519.PrivateKeySize {
+		return nil, SSHKeyFormatError
+	}
+
+	signingKey := ed25519.NewKeyFromSeed(privateKey[:ed25519.SeedSize])
+	if !bytes.Equal(signingKey.Public().(ed25519.PublicKey), publicKey) {
+		return nil, SSHKeyFormatError
+	}
+
+	return signingKey, nil
+}
+
+// reads the SSH wire encoding, the first error is sticky
+type sshReader struct {
+	data []byte
+	err  error
+}
+
+func (r *sshReader) readUint32() uint32 {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = SSHKeyFormatError
+		return 0
+	}
+	n := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return n
+}
+
+func (r *sshReader) readString() []byte {
+	n := r.readUint32()
+	if r.err != nil || uint64(n) > uint64(len(r.data)) {
+		r.err = SSHKeyFormatError
+		return nil
+	}
+	s := r.data[:n]
+	r.data = r.data[n:]
+	return s
+}
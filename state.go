@@ -0,0 +1,194 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/nacl/secretbox"
+	"strings"
+)
+
+// Engine state snapshots, to move an engine to another host or between blue/green deployments:
+// ExportState serializes the engine keys, its nonce counter and, when the key store implements KeyLister,
+// the other keys of the engine (prekeys, password salts...) and the public keys of the registered peers,
+// into a single blob encrypted with a key derived from the passphrase with Argon2id.
+// ImportState writes the keys into the key store of the new host and restores the counter, so the nonces are not reused.
+// The source engine must stop encrypting once its state has been exported.
+// Format:
+// |version| => 1 byte
+// |salt|    => 16 bytes (Argon2id salt)
+// |nonce|   => 24 bytes
+// |state|   => N bytes (secretbox of the JSON state)
+
+const (
+	stateVersion  = 1
+	stateSaltSize = 16
+	stateHeader   = 1 + stateSaltSize + nonceSize
+)
+
+var (
+	StateError           = errors.New("The engine state is not valid")
+	StatePassphraseError = errors.New("The passphrase cannot decrypt the engine state")
+	StateConflictError   = errors.New("The key store already holds different keys for the engine")
+)
+
+// the serialized state of an engine
+type engineState struct {
+	Context string            `json:"context"`
+	Counter uint64            `json:"counter"`
+	Keys    map[string][]byte `json:"keys"` // the keys by name in the key store
+}
+
+// This method exports the engine state encrypted with the passphrase
+func (engine *CryptoEngine) ExportState(passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, PasswordError
+	}
+	if err := engine.allow(operationOwnKeys); err != nil {
+		return nil, err
+	}
+
+	state, err := engine.state()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(plain)
+
+	blob := make([]byte, stateHeader, stateHeader+len(plain)+secretbox.Overhead)
+	blob[0] = stateVersion
+	if _, err := rand.Read(blob[1:stateHeader]); err != nil {
+		return nil, err
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], blob[1+stateSaltSize:])
+	key := stateKey(passphrase, blob[1:1+stateSaltSize])
+	return secretbox.Seal(blob, plain, &nonce, &key), nil
+}
+
+// This function decrypts the state exported by ExportState, writes its keys into the key store of the config
+// and returns the engine, with its nonce counter restored.
+// The keys already in the store are kept when they match, StateConflictError is returned when they differ.
+func ImportState(blob []byte, passphrase string, config Config) (*CryptoEngine, error) {
+	if len(blob) < stateHeader+secretbox.Overhead || blob[0] != stateVersion {
+		return nil, StateError
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], blob[1+stateSaltSize:])
+	key := stateKey(passphrase, blob[1:1+stateSaltSize])
+	plain, ok := secretbox.Open(nil, blob[stateHeader:], &nonce, &key)
+	if !ok {
+		return nil, StatePassphraseError
+	}
+	defer wipe(plain)
+
+	var state engineState
+	if err := json.Unmarshal(plain, &state); err != nil || state.Context == "" {
+		return nil, StateError
+	}
+
+	store := config.keyStore()
+
+	// check all the keys before writing any of them
+	var missing []string
+	for name, data := range state.Keys {
+		existing, err := store.ReadKey(name)
+		if err == KeyNotFoundError {
+			missing = append(missing, name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(existing, data) {
+			return nil, StateConflictError
+		}
+	}
+	for _, name := range missing {
+		if err := store.WriteKey(name, state.Keys[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	engine, err := InitCryptoEngineWithConfig(state.Context, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// the counters of a counter store are already shared by the hosts
+	engine.counterMutex.Lock()
+	if engine.config.CounterStore == nil && state.Counter > engine.counter {
+		engine.counter = state.Counter
+	}
+	engine.counterMutex.Unlock()
+	return engine, nil
+}
+
+// collects the keys and the counter of the engine
+func (engine *CryptoEngine) state() (engineState, error) {
+	state := engineState{Context: engine.context, Keys: make(map[string][]byte)}
+
+	// the keys of the store first: the peers and the other keys of the engine
+	if lister, ok := engine.config.keyStore().(KeyLister); ok {
+		names, err := lister.ListKeys("")
+		if err != nil {
+			return state, err
+		}
+		for _, name := range names {
+			if !strings.HasPrefix(name, engine.context+"_") && !strings.HasSuffix(name, "_public.key") {
+				continue
+			}
+			data, err := engine.config.keyStore().ReadKey(name)
+			if err != nil {
+				return state, err
+			}
+			state.Keys[name] = data
+		}
+	}
+
+	// the salt can be rotated concurrently
+	engine.counterMutex.Lock()
+	salt := engine.salt
+	engine.counterMutex.Unlock()
+
+	// the keys held by the engine, also when the store cannot be listed or the keys are derived from a password
+	for format, key := range map[string][]byte{
+		saltSuffixFormat:           salt[:],
+		publicKeySuffixFormat:      engine.publicKey[:],
+		privateSuffixFormat:        engine.privateKey[:],
+		secretSuffixFormat:         engine.secretKey[:],
+		nonceSuffixFormat:          engine.nonceKey[:],
+		signingPrivateSuffixFormat: engine.signingKey.Seed(),
+		signingPublicSuffixFormat:  []byte(engine.signingKey[keySize:]),
+	} {
+		state.Keys[fmt.Sprintf(format, engine.context)] = append([]byte{}, key...)
+	}
+
+	engine.counterMutex.Lock()
+	state.Counter = engine.counter
+	engine.counterMutex.Unlock()
+	return state, nil
+}
+
+// derives the key of the state from the passphrase
+func stateKey(passphrase string, salt []byte) [keySize]byte {
+	var key [keySize]byte
+	derived := argon2.IDKey([]byte(passphrase), salt, passwordArgon2Time, passwordArgon2Memory, passwordArgon2Threads, keySize)
+	copy(key[:], derived)
+	wipe(derived)
+	return key
+}
+
+func wipe(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
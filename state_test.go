@@ -0,0 +1,101 @@
+package cryptoengine
+
+import (
+	"testing"
+)
+
+func TestExportImportState(t *testing.T) {
+
+	source := NewMemoryKeyStore()
+	engine, err := InitCryptoEngineWithConfig("Sec51State", Config{KeyStore: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a registered peer
+	peer, err := InitCryptoEngineWithConfig("Sec51StatePeer", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerVerificationEngine, err := NewVerificationEngineWithKeys(peer.PublicKey(), peer.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterPeer(source, "Sec51StatePeer", peerVerificationEngine); err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := NewMessage("before the migration", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := engine.NewEncryptedMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := engine.ExportState("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new host
+	destination := NewMemoryKeyStore()
+	if _, err := ImportState(blob, "wrong passphrase", Config{KeyStore: destination}); err != StatePassphraseError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", StatePassphraseError, err)
+	}
+
+	imported, err := ImportState(blob, "correct horse battery staple", Config{KeyStore: destination})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Fingerprint() != engine.Fingerprint() || string(imported.SigningPublicKey()) != string(engine.SigningPublicKey()) {
+		t.Fatal("The imported engine has different keys")
+	}
+	if imported.counter != engine.counter {
+		t.Fatalf("The counter has not been restored: %d instead of %d\n", imported.counter, engine.counter)
+	}
+
+	decrypted, err := imported.Decrypt(encryptedBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Text != message.Text {
+		t.Fatal("The decrypted message does not match the original one")
+	}
+
+	// the peer registry has been migrated
+	loadedPeer, err := NewVerificationEngineFromStore(destination, "Sec51StatePeer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadedPeer.Fingerprint() != peerVerificationEngine.Fingerprint() {
+		t.Fatal("The registered peer has not been imported")
+	}
+
+	// importing twice is fine, a different engine with the same identifier is not
+	if _, err := ImportState(blob, "correct horse battery staple", Config{KeyStore: destination}); err != nil {
+		t.Fatal(err)
+	}
+	other := NewMemoryKeyStore()
+	if _, err := InitCryptoEngineWithConfig("Sec51State", Config{KeyStore: other}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportState(blob, "correct horse battery staple", Config{KeyStore: other}); err != StateConflictError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", StateConflictError, err)
+	}
+
+	// tampering
+	blob[len(blob)-1] ^= 1
+	if _, err := ImportState(blob, "correct horse battery staple", Config{KeyStore: NewMemoryKeyStore()}); err != StatePassphraseError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", StatePassphraseError, err)
+	}
+	if _, err := ImportState(blob[:10], "correct horse battery staple", Config{KeyStore: NewMemoryKeyStore()}); err != StateError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", StateError, err)
+	}
+
+}
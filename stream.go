@@ -0,0 +1,158 @@
+package cryptoengine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/sec51/convert/smallendian"
+	"io"
+	"sync"
+)
+
+const (
+	readBufferSize = 64 * 1024 // the initial size of the buffer of a message read from a stream
+)
+
+// Writes the serialized message to the writer.
+// It implements the io.WriterTo interface, so the message can be sent directly over a network connection.
+func (m EncryptedMessage) WriteTo(w io.Writer) (int64, error) {
+	data, err := m.ToBytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// Reads exactly one length prefixed message from the reader and parses it with the strict mode.
+// It implements the io.ReaderFrom interface.
+// If the reader is at the end of the stream io.EOF is returned, if it ends in the middle of a message io.ErrUnexpectedEOF.
+func (m *EncryptedMessage) ReadFrom(r io.Reader) (int64, error) {
+	return m.readFrom(r, ParseOptions{})
+}
+
+// Reads exactly one length prefixed message from the reader, see EncryptedMessage.ReadFrom
+func ReadMessage(r io.Reader) (EncryptedMessage, error) {
+	m := EncryptedMessage{}
+	_, err := m.ReadFrom(r)
+	return m, err
+}
+
+// reads the length prefix first, validates it against the maximum size and only then reads the rest of the message
+func (m *EncryptedMessage) readFrom(r io.Reader, options ParseOptions) (int64, error) {
+	var lengthData [8]byte
+
+	// read the length
+	n, err := io.ReadFull(r, lengthData[:])
+	if err != nil {
+		return int64(n), err
+	}
+
+	// validate the length before allocating the buffer
+	length := smallendian.FromUint64(lengthData)
+	if length < 8+nonceSize+1 {
+		return int64(n), &ParseError{Field: "length", Reason: fmt.Sprintf("%d, the minimum is %d", length, 8+nonceSize+1), Err: MessageParsingError}
+	}
+	if length > options.maxSize() {
+		return int64(n), &ParseError{Field: "length", Reason: fmt.Sprintf("%d, the maximum is %d", length, options.maxSize()), Err: MessageTooLargeError}
+	}
+
+	// read the rest of the message: the buffer grows with the data actually received,
+	// so a forged length field does not allocate the maximum size upfront
+	initialSize := length
+	if initialSize > readBufferSize {
+		initialSize = readBufferSize
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, initialSize))
+	buffer.Write(lengthData[:])
+	read, err := io.CopyN(buffer, r, int64(length-8))
+	total := int64(n) + read
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return total, err
+	}
+
+	parsed, err := encryptedMessageFromBytes(buffer.Bytes(), options)
+	if err != nil {
+		return total, err
+	}
+	*m = parsed
+	return total, nil
+}
+
+// The MessageReader reads complete encrypted messages from a stream, for instance a net.Conn.
+// Partial reads and multiple messages coalesced in the same read are handled transparently.
+// When a frame exceeds the maximum size, or it is malformed, the reader cannot resynchronize with the stream anymore:
+// the error is returned on every subsequent call and the connection should be closed.
+type MessageReader struct {
+	reader  *bufio.Reader // buffered reader wrapping the stream
+	options ParseOptions  // options used for parsing the frames
+	err     error         // sticky error, once a frame is corrupted the stream cannot be read anymore
+	mutex   sync.Mutex    // makes sure frames are read by one goroutine at a time
+}
+
+// Creates a new MessageReader which parses the frames with the given options
+func NewMessageReader(r io.Reader, options ParseOptions) *MessageReader {
+	return &MessageReader{
+		reader:  bufio.NewReader(r),
+		options: options,
+	}
+}
+
+// Reads the next complete message from the stream.
+// io.EOF is returned when the stream ends cleanly between two messages.
+func (mr *MessageReader) ReadMessage() (EncryptedMessage, error) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	m := EncryptedMessage{}
+	if mr.err != nil {
+		return m, mr.err
+	}
+
+	if _, err := m.readFrom(mr.reader, mr.options); err != nil {
+		// a clean end of the stream is not a corruption
+		if err != io.EOF {
+			mr.err = err
+		}
+		return m, err
+	}
+
+	return m, nil
+}
+
+// The MessageWriter writes complete encrypted messages to a stream, for instance a net.Conn.
+// Each message is written with a single Write call, so it is safe to use it from multiple goroutines.
+type MessageWriter struct {
+	writer  io.Writer    // the underlying stream
+	options ParseOptions // the maximum size is enforced also when writing, so the peer does not reject the frame
+	mutex   sync.Mutex   // makes sure frames are not interleaved
+}
+
+// Creates a new MessageWriter which refuses to write messages bigger than the maximum size of the options
+func NewMessageWriter(w io.Writer, options ParseOptions) *MessageWriter {
+	return &MessageWriter{
+		writer:  w,
+		options: options,
+	}
+}
+
+// Writes the message as one frame to the stream
+func (mw *MessageWriter) WriteMessage(m EncryptedMessage) error {
+	data, err := m.ToBytes()
+	if err != nil {
+		return err
+	}
+
+	if uint64(len(data)) > mw.options.maxSize() {
+		return MessageTooLargeError
+	}
+
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	_, err = mw.writer.Write(data)
+	return err
+}
@@ -0,0 +1,221 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"errors"
+	"github.com/sec51/convert/smallendian"
+	"io"
+	"runtime"
+	"testing"
+)
+
+func TestMessageWriteToReadFrom(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	texts := []string{"The quick brown fox", "jumps over the lazy dog"}
+
+	// write two messages on the same stream
+	for _, text := range texts {
+		message, err := NewMessage(text, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encryptedMessage, err := engine.NewEncryptedMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := encryptedMessage.WriteTo(&buffer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// read them back one at a time
+	for _, text := range texts {
+		encryptedMessage, err := ReadMessage(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		messageBytes, err := encryptedMessage.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := engine.Decrypt(messageBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decrypted.Text != text {
+			t.Errorf("Expected %q, instead we've got %q\n", text, decrypted.Text)
+		}
+	}
+
+	// the stream is over
+	if _, err := ReadMessage(&buffer); err != io.EOF {
+		t.Errorf("The expected error is: io.EOF, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestReadMessageMalformedStream(t *testing.T) {
+
+	// the length prefix announces more data than available
+	length := smallendian.ToUint64(100)
+	truncated := append(length[:], make([]byte, 50)...)
+	if _, err := ReadMessage(bytes.NewReader(truncated)); err != io.ErrUnexpectedEOF {
+		t.Errorf("The expected error is: io.ErrUnexpectedEOF, instead we've got: %v\n", err)
+	}
+
+	// the length prefix is forged to a huge value
+	huge := smallendian.ToUint64(1 << 40)
+	if _, err := ReadMessage(bytes.NewReader(huge[:])); !errors.Is(err, MessageTooLargeError) {
+		t.Errorf("The expected error is: MessageTooLargeError, instead we've got: %v\n", err)
+	}
+
+	// the length prefix is too small to hold a message
+	small := smallendian.ToUint64(8)
+	if _, err := ReadMessage(bytes.NewReader(small[:])); !errors.Is(err, MessageParsingError) {
+		t.Errorf("The expected error is: MessageParsingError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestReadMessageForgedLength(t *testing.T) {
+
+	// the length prefix announces the maximum size but only a few bytes follow:
+	// the buffer grows with the data received instead of being allocated upfront
+	length := smallendian.ToUint64(maxMessageSize)
+	forged := append(length[:], make([]byte, 64)...)
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	before := stats.TotalAlloc
+	if _, err := ReadMessage(bytes.NewReader(forged)); err != io.ErrUnexpectedEOF {
+		t.Errorf("The expected error is: io.ErrUnexpectedEOF, instead we've got: %v\n", err)
+	}
+	runtime.ReadMemStats(&stats)
+	if allocated := stats.TotalAlloc - before; allocated > 2*readBufferSize {
+		t.Errorf("Reading the forged message allocated %d bytes\n", allocated)
+	}
+
+}
+
+func TestParseErrorDetails(t *testing.T) {
+
+	huge := smallendian.ToUint64(1024)
+	_, err := NewMessageReader(bytes.NewReader(huge[:]), ParseOptions{MaxSize: 512}).ReadMessage()
+
+	var parseError *ParseError
+	if !errors.As(err, &parseError) {
+		t.Fatalf("The expected error is: ParseError, instead we've got: %v\n", err)
+	}
+	if parseError.Field != "length" || parseError.Offset != 0 || parseError.Err != MessageTooLargeError {
+		t.Errorf("The parse error details are not valid: %+v\n", parseError)
+	}
+
+	_, err = ParseMessage(make([]byte, 10), ParseOptions{})
+	if !errors.As(err, &parseError) || parseError.Field != "data" || parseError.Err != MessageParsingError {
+		t.Errorf("The expected error is: MessageParsingError, instead we've got: %v\n", err)
+	}
+
+}
+
+// delivers the data to the reader a few bytes at a time
+type slowReader struct {
+	data []byte
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := 3
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestMessageReaderWriter(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// coalesce three frames in the same buffer
+	var buffer bytes.Buffer
+	writer := NewMessageWriter(&buffer, ParseOptions{})
+	for i := 0; i < 3; i++ {
+		message, err := NewMessage("The quick brown fox jumps over the lazy dog", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encryptedMessage, err := engine.NewEncryptedMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := writer.WriteMessage(encryptedMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// read them back with partial reads
+	reader := NewMessageReader(&slowReader{buffer.Bytes()}, ParseOptions{})
+	for i := 0; i < 3; i++ {
+		encryptedMessage, err := reader.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		messageBytes, err := encryptedMessage.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := engine.Decrypt(messageBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decrypted.Type != i {
+			t.Errorf("Messages read out of order: expected type %d, got %d\n", i, decrypted.Type)
+		}
+	}
+
+	if _, err := reader.ReadMessage(); err != io.EOF {
+		t.Errorf("The expected error is: io.EOF, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestMessageReaderOversizedFrame(t *testing.T) {
+
+	huge := smallendian.ToUint64(1024)
+	reader := NewMessageReader(bytes.NewReader(append(huge[:], make([]byte, 1024)...)), ParseOptions{MaxSize: 512})
+
+	if _, err := reader.ReadMessage(); !errors.Is(err, MessageTooLargeError) {
+		t.Errorf("The expected error is: MessageTooLargeError, instead we've got: %v\n", err)
+	}
+
+	// the error is sticky
+	if _, err := reader.ReadMessage(); !errors.Is(err, MessageTooLargeError) {
+		t.Errorf("The expected error is: MessageTooLargeError, instead we've got: %v\n", err)
+	}
+
+}
@@ -0,0 +1,177 @@
+package cryptoengine
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Field level encryption of structs: the string and []byte fields tagged with `crypto:"encrypt"`
+// are sealed with the engine secret key and replaced with an armored string, so that a record can be persisted
+// with its sensitive fields encrypted and the others left in clear.
+// An armored value is: ce1:<base64 encrypted message>
+// Empty fields are left empty. Nested structs and pointers to structs are traversed.
+//
+//	type User struct {
+//		Name  string
+//		Email string `crypto:"encrypt"`
+//	}
+
+const (
+	structTag           = "crypto"
+	structTagEncrypt    = "encrypt"
+	armoredPrefix       = "ce1:"
+	structMaxNestedness = 32
+)
+
+var (
+	StructError      = errors.New("The value is not a pointer to a struct")
+	StructFieldError = errors.New("The field tagged for encryption is not an exported string or []byte")
+	ArmoredError     = errors.New("The armored value is not valid")
+	armoredEncoding  = base64.StdEncoding
+)
+
+// This method encrypts, in place, the fields tagged with `crypto:"encrypt"` of the struct pointed by v
+func (engine *CryptoEngine) EncryptStruct(v interface{}) error {
+	value, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	return engine.walkStruct(value, 0, true)
+}
+
+// This method decrypts, in place, the fields tagged with `crypto:"encrypt"` of the struct pointed by v
+func (engine *CryptoEngine) DecryptStruct(v interface{}) error {
+	value, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	return engine.walkStruct(value, 0, false)
+}
+
+// This method encrypts the data with the engine secret key and returns it as an armored string
+func (engine *CryptoEngine) EncryptArmored(data []byte) (string, error) {
+	msg, err := NewMessage(string(data), 0)
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := engine.NewEncryptedMessage(msg)
+	if err != nil {
+		return "", err
+	}
+
+	encryptedBytes, err := encrypted.ToBytes()
+	if err != nil {
+		return "", err
+	}
+
+	return armoredPrefix + armoredEncoding.EncodeToString(encryptedBytes), nil
+}
+
+// This method decrypts an armored string returned by EncryptArmored
+func (engine *CryptoEngine) DecryptArmored(armored string) ([]byte, error) {
+	if !strings.HasPrefix(armored, armoredPrefix) {
+		return nil, ArmoredError
+	}
+
+	encryptedBytes, err := armoredEncoding.DecodeString(armored[len(armoredPrefix):])
+	if err != nil {
+		return nil, ArmoredError
+	}
+
+	msg, err := engine.Decrypt(encryptedBytes)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(msg.Text), nil
+}
+
+// returns the struct pointed by v
+func structValue(v interface{}) (reflect.Value, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, StructError
+	}
+	return value.Elem(), nil
+}
+
+// encrypts or decrypts the tagged fields and traverses the nested structs
+func (engine *CryptoEngine) walkStruct(value reflect.Value, depth int, encrypt bool) error {
+	if depth > structMaxNestedness {
+		return StructError
+	}
+
+	valueType := value.Type()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		structField := valueType.Field(i)
+
+		if structField.Tag.Get(structTag) != structTagEncrypt {
+			// traverse the nested structs, exported or embedded
+			if !field.CanSet() {
+				continue
+			}
+			if field.Kind() == reflect.Ptr && !field.IsNil() && field.Elem().Kind() == reflect.Struct {
+				field = field.Elem()
+			}
+			if field.Kind() == reflect.Struct {
+				if err := engine.walkStruct(field, depth+1, encrypt); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !field.CanSet() {
+			return StructFieldError
+		}
+
+		if err := engine.transformField(field, encrypt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encrypts or decrypts a tagged field
+func (engine *CryptoEngine) transformField(field reflect.Value, encrypt bool) error {
+	var data []byte
+	switch {
+	case field.Kind() == reflect.String:
+		data = []byte(field.String())
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8:
+		data = field.Bytes()
+	default:
+		return StructFieldError
+	}
+
+	// empty fields are left as they are
+	if len(data) == 0 {
+		return nil
+	}
+
+	var result []byte
+	if encrypt {
+		armored, err := engine.EncryptArmored(data)
+		if err != nil {
+			return err
+		}
+		result = []byte(armored)
+	} else {
+		clearText, err := engine.DecryptArmored(string(data))
+		if err != nil {
+			return err
+		}
+		result = clearText
+	}
+
+	if field.Kind() == reflect.String {
+		field.SetString(string(result))
+	} else {
+		field.SetBytes(result)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type structTestAddress struct {
+	City   string
+	Street string `crypto:"encrypt"`
+}
+
+type structTestUser struct {
+	Name     string
+	Email    string `crypto:"encrypt"`
+	Token    []byte `crypto:"encrypt"`
+	Empty    string `crypto:"encrypt"`
+	Address  structTestAddress
+	Previous *structTestAddress
+}
+
+func TestEncryptStruct(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := structTestUser{
+		Name:     "Alice",
+		Email:    "alice@example.com",
+		Token:    []byte{1, 2, 3},
+		Address:  structTestAddress{City: "Zurich", Street: "Bahnhofstrasse 1"},
+		Previous: &structTestAddress{City: "Basel", Street: "Marktplatz 9"},
+	}
+
+	if err := engine.EncryptStruct(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Name != "Alice" || user.Address.City != "Zurich" || user.Previous.City != "Basel" {
+		t.Fatal("The fields not tagged for encryption have been modified")
+	}
+
+	if !strings.HasPrefix(user.Email, armoredPrefix) || !strings.HasPrefix(string(user.Token), armoredPrefix) ||
+		!strings.HasPrefix(user.Address.Street, armoredPrefix) || !strings.HasPrefix(user.Previous.Street, armoredPrefix) {
+		t.Fatal("The tagged fields have not been encrypted")
+	}
+
+	if user.Empty != "" {
+		t.Fatal("The empty field has been encrypted")
+	}
+
+	if err := engine.DecryptStruct(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Email != "alice@example.com" || !bytes.Equal(user.Token, []byte{1, 2, 3}) ||
+		user.Address.Street != "Bahnhofstrasse 1" || user.Previous.Street != "Marktplatz 9" {
+		t.Fatal("The decrypted fields do not match the original ones")
+	}
+
+	// tampered values are rejected
+	if err := engine.EncryptStruct(&user); err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes, err := armoredEncoding.DecodeString(user.Email[len(armoredPrefix):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBytes[len(encryptedBytes)-1] ^= 1
+	user.Email = armoredPrefix + armoredEncoding.EncodeToString(encryptedBytes)
+	if err := engine.DecryptStruct(&user); err != MessageDecryptionError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", MessageDecryptionError, err)
+	}
+
+	// clear text values are rejected
+	user.Email = "alice@example.com"
+	if err := engine.DecryptStruct(&user); err != ArmoredError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", ArmoredError, err)
+	}
+}
+
+func TestEncryptStructErrors(t *testing.T) {
+
+	engine, err := InitCryptoEngine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := structTestUser{}
+	if err := engine.EncryptStruct(user); err != StructError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", StructError, err)
+	}
+
+	invalid := struct {
+		Age int `crypto:"encrypt"`
+	}{42}
+	if err := engine.EncryptStruct(&invalid); err != StructFieldError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", StructFieldError, err)
+	}
+
+	unexported := struct {
+		secret string `crypto:"encrypt"`
+	}{"secret"}
+	if err := engine.EncryptStruct(&unexported); err != StructFieldError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", StructFieldError, err)
+	}
+}
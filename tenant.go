@@ -0,0 +1,239 @@
+package cryptoengine
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Multi-tenant processes: the TenantManager hosts the engines of many tenants, each one with its own isolated key store,
+// for instance its own folder or its own database, so the same communication identifier in two tenants never shares the keys.
+// The amount of keys a tenant stores can be limited, and the keys of a tenant can be enumerated and deleted on offboarding.
+
+const (
+	tenantsFolder = "tenants" // the folder of the tenants key stores inside the keys folder, for instance: keys/tenants/acme
+)
+
+var (
+	TenantQuotaError = errors.New("The tenant has reached the maximum amount of keys")
+)
+
+// The TenantOptions struct holds the settings shared by the tenants of a TenantManager
+type TenantOptions struct {
+	Config      Config                                // the config of the engines, the KeyStore is replaced by the one of the tenant
+	NewKeyStore func(tenant string) (KeyStore, error) // opens the key store of the tenant. Nil means a folder per tenant inside the keys folder
+	MaxKeys     int                                   // maximum amount of keys stored by each tenant. Zero means no limit, otherwise the key stores must implement KeyLister
+	MaxEngines  int                                   // maximum amount of engines cached for each tenant, only with a CounterStore in the Config. Zero means 1024
+}
+
+// The TenantManager opens the tenants the first time they are requested and caches them. It's safe for concurrent use.
+type TenantManager struct {
+	options TenantOptions
+	mutex   sync.Mutex
+	tenants map[string]*Tenant
+}
+
+// The Tenant holds the key store and the engines of a tenant
+type Tenant struct {
+	name    string
+	store   *tenantKeyStore
+	engines *EngineManager
+}
+
+// the key store of a tenant, it enforces the quota
+type tenantKeyStore struct {
+	store   KeyStore
+	maxKeys int
+	mutex   sync.Mutex
+	keys    int // the amount of keys stored, counted only with a quota
+}
+
+// This function returns a manager which opens the tenants with the options
+func NewTenantManager(options TenantOptions) *TenantManager {
+	return &TenantManager{
+		options: options,
+		tenants: make(map[string]*Tenant),
+	}
+}
+
+// This method returns the tenant, opening its key store if it's not cached.
+// The name is validated like a strict communication identifier, it names the folder of the default key store.
+func (manager *TenantManager) Tenant(name string) (*Tenant, error) {
+	name, err := ValidateIdentifier(name)
+	if err != nil {
+		return nil, err
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if tenant, ok := manager.tenants[name]; ok {
+		return tenant, nil
+	}
+
+	tenant, err := manager.open(name)
+	if err != nil {
+		return nil, err
+	}
+	manager.tenants[name] = tenant
+	return tenant, nil
+}
+
+// This method returns the names of the tenants opened by the manager, sorted
+func (manager *TenantManager) Tenants() []string {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	names := make([]string, 0, len(manager.tenants))
+	for name := range manager.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// This method deletes all the keys of the tenant and drops its engines, for instance when the tenant is offboarded.
+// The key store must implement KeyLister.
+func (manager *TenantManager) DeleteTenant(name string) error {
+	tenant, err := manager.Tenant(name)
+	if err != nil {
+		return err
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if err := tenant.deleteKeys(); err != nil {
+		return err
+	}
+	delete(manager.tenants, tenant.name)
+	return nil
+}
+
+// opens the key store of the tenant and counts its keys when there is a quota
+func (manager *TenantManager) open(name string) (*Tenant, error) {
+	newKeyStore := manager.options.NewKeyStore
+	if newKeyStore == nil {
+		newKeyStore = defaultTenantKeyStore
+	}
+
+	store, err := newKeyStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantStore := &tenantKeyStore{store: store, maxKeys: manager.options.MaxKeys}
+	if tenantStore.maxKeys > 0 {
+		keys, err := tenantStore.ListKeys("")
+		if err != nil {
+			return nil, err
+		}
+		tenantStore.keys = len(keys)
+	}
+
+	config := manager.options.Config
+	config.KeyStore = tenantStore
+	return &Tenant{
+		name:    name,
+		store:   tenantStore,
+		engines: NewEngineManager(config, manager.options.MaxEngines),
+	}, nil
+}
+
+// This method returns the name of the tenant
+func (tenant *Tenant) Name() string {
+	return tenant.name
+}
+
+// This method returns the engine of the communication identifier within the tenant
+func (tenant *Tenant) Engine(communicationIdentifier string) (*CryptoEngine, error) {
+	return tenant.engines.Engine(communicationIdentifier)
+}
+
+// This method returns the key store of the tenant, for instance to create its verification engines with NewVerificationEngineFromStore
+func (tenant *Tenant) KeyStore() KeyStore {
+	return tenant.store
+}
+
+// This method returns the names of the keys of the tenant, sorted. The key store must implement KeyLister.
+func (tenant *Tenant) Keys() ([]string, error) {
+	keys, err := tenant.store.ListKeys("")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// deletes the keys of the tenant and drops the cached engines
+func (tenant *Tenant) deleteKeys() error {
+	keys, err := tenant.store.ListKeys("")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := tenant.store.DeleteKey(key); err != nil {
+			return err
+		}
+	}
+	tenant.engines.clear()
+	return nil
+}
+
+func (store *tenantKeyStore) ReadKey(name string) ([]byte, error) {
+	return store.store.ReadKey(name)
+}
+
+// This method stores the key, unless the tenant has reached its quota
+func (store *tenantKeyStore) WriteKey(name string, data []byte) error {
+	if store.maxKeys <= 0 {
+		return store.store.WriteKey(name, data)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.keys >= store.maxKeys {
+		// an existing key is not counted twice: the underlying store refuses to overwrite it
+		if _, err := store.store.ReadKey(name); err == nil {
+			return os.ErrExist
+		}
+		return TenantQuotaError
+	}
+	if err := store.store.WriteKey(name, data); err != nil {
+		return err
+	}
+	store.keys++
+	return nil
+}
+
+func (store *tenantKeyStore) DeleteKey(name string) error {
+	if store.maxKeys <= 0 {
+		return store.store.DeleteKey(name)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// only the keys which exist are subtracted from the quota
+	_, readErr := store.store.ReadKey(name)
+	if readErr != nil && readErr != KeyNotFoundError {
+		return readErr
+	}
+	if err := store.store.DeleteKey(name); err != nil {
+		return err
+	}
+	if readErr == nil && store.keys > 0 {
+		store.keys--
+	}
+	return nil
+}
+
+func (store *tenantKeyStore) ListKeys(prefix string) ([]string, error) {
+	lister, ok := store.store.(KeyLister)
+	if !ok {
+		return nil, KeyListError
+	}
+	return lister.ListKeys(prefix)
+}
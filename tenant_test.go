@@ -0,0 +1,173 @@
+package cryptoengine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTenantIsolation(t *testing.T) {
+
+	stores := make(map[string]*MemoryKeyStore)
+	manager := NewTenantManager(TenantOptions{
+		NewKeyStore: func(tenant string) (KeyStore, error) {
+			if _, ok := stores[tenant]; !ok {
+				stores[tenant] = NewMemoryKeyStore()
+			}
+			return stores[tenant], nil
+		},
+	})
+
+	acme, err := manager.Tenant("Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globex, err := manager.Tenant("Globex")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same identifier in two tenants has different keys
+	acmeEngine, err := acme.Engine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globexEngine, err := globex.Engine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acmeEngine.Fingerprint() == globexEngine.Fingerprint() {
+		t.Fatal("The tenants share the keys")
+	}
+
+	if tenants := manager.Tenants(); len(tenants) != 2 || tenants[0] != "acme" || tenants[1] != "globex" {
+		t.Fatalf("The tenants are not enumerated: %v\n", tenants)
+	}
+
+	keys, err := acme.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) == 0 {
+		t.Fatal("The keys of the tenant are not enumerated")
+	}
+
+	// offboarding deletes all the keys of the tenant only
+	if err := manager.DeleteTenant("Acme"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := stores["acme"].ListKeys(""); len(keys) != 0 {
+		t.Fatalf("The keys of the deleted tenant are still stored: %v\n", keys)
+	}
+	if keys, _ := stores["globex"].ListKeys(""); len(keys) == 0 {
+		t.Fatal("The keys of the other tenant have been deleted")
+	}
+	if tenants := manager.Tenants(); len(tenants) != 1 {
+		t.Fatalf("The deleted tenant is still cached: %v\n", tenants)
+	}
+
+	// the tenant starts from scratch
+	acme, err = manager.Tenant("Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newEngine, err := acme.Engine("Sec51")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newEngine.Fingerprint() == acmeEngine.Fingerprint() {
+		t.Fatal("The keys of the deleted tenant have been reused")
+	}
+
+	if _, err := manager.Tenant("../other"); err != IdentifierError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", IdentifierError, err)
+	}
+
+}
+
+func TestTenantQuota(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	manager := NewTenantManager(TenantOptions{
+		NewKeyStore: func(tenant string) (KeyStore, error) {
+			return store, nil
+		},
+		MaxKeys: 1000,
+	})
+	tenant, err := manager.Tenant("Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenant.Engine("Sec51"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := tenant.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the existing keys are counted when the tenant is opened
+	manager = NewTenantManager(TenantOptions{
+		NewKeyStore: func(tenant string) (KeyStore, error) {
+			return store, nil
+		},
+		MaxKeys: len(keys),
+	})
+	tenant, err = manager.Tenant("Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys already stored are loaded
+	if _, err := tenant.Engine("Sec51"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenant.Engine("Sec51Other"); err != TenantQuotaError {
+		t.Errorf("The expected error is: %v, instead we've got: %v\n", TenantQuotaError, err)
+	}
+
+	// deleting the keys frees the quota
+	if err := manager.DeleteTenant("Acme"); err != nil {
+		t.Fatal(err)
+	}
+	tenant, err = manager.Tenant("Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenant.Engine("Sec51Other"); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestTenantDefaultKeyStore(t *testing.T) {
+
+	manager := NewTenantManager(TenantOptions{})
+	tenant, err := manager.Tenant("Sec51Tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenant.Engine("Sec51"); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, err := tenant.Keys(); err != nil || len(keys) == 0 {
+		t.Fatalf("The keys of the tenant are not enumerated: %v %v\n", keys, err)
+	}
+
+	if err := manager.DeleteTenant("Sec51Tenant"); err != nil {
+		t.Fatal(err)
+	}
+	tenant, err = manager.Tenant("Sec51Tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := tenant.Keys(); len(keys) != 0 {
+		t.Fatalf("The keys of the deleted tenant are still stored: %v\n", keys)
+	}
+
+	// outside the browser the tenant has its own folder inside the keys folder, remove it
+	folder := filepath.Join(keyPath, tenantsFolder, "sec51tenant")
+	os.RemoveAll(folder)
+
+}
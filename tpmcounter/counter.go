@@ -0,0 +1,95 @@
+// Package tpmcounter provides a cryptoengine.MonotonicCounter backed by a TPM 2.0 NV counter,
+// so the nonce counters never go back, even when the disk of the host is restored from a snapshot.
+//
+// The NV index must be defined beforehand as a counter readable and writable with its own authorization, for instance:
+//
+//	tpm2_nvdefine -C o -a "authread|authwrite|nt=counter" 0x1500016
+//
+// Then the counter store of the engines reserves its counters from the TPM, or from a file where there is no TPM:
+//
+//	counter := tpmcounter.OpenWithFallback(tpmcounter.DefaultDevice, 0x1500016, "", "keys/monotonic.counter")
+//	store := cryptoengine.NewMonotonicCounterStore(counter)
+//	engine, err := cryptoengine.InitCryptoEngineWithConfig("app", cryptoengine.Config{CounterStore: store})
+package tpmcounter
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/sec51/cryptoengine"
+	"io"
+	"log"
+	"sync"
+)
+
+const (
+	DefaultDevice = "/dev/tpmrm0" // the TPM resource manager of Linux
+	counterSize   = 8             // the size of a TPM NV counter
+)
+
+var (
+	CounterSizeError = errors.New("The NV index is not a TPM counter")
+)
+
+// The Counter is a cryptoengine.MonotonicCounter stored in a TPM NV index
+type Counter struct {
+	rw    io.ReadWriteCloser
+	index tpmutil.Handle
+	auth  string
+	mutex sync.Mutex
+}
+
+// This function opens the TPM device and makes sure the NV index is a readable counter
+func Open(device string, index uint32, auth string) (*Counter, error) {
+	rw, err := tpm2.OpenTPM(device)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := &Counter{rw: rw, index: tpmutil.Handle(index), auth: auth}
+	if _, err := counter.read(); err != nil {
+		rw.Close()
+		return nil, err
+	}
+	return counter, nil
+}
+
+// This function opens the TPM counter and falls back to a cryptoengine.FileMonotonicCounter stored in the file
+// when the host has no TPM or the NV index is not available
+func OpenWithFallback(device string, index uint32, auth string, fallbackPath string) cryptoengine.MonotonicCounter {
+	counter, err := Open(device, index, auth)
+	if err != nil {
+		log.Printf("[WARNING] - The TPM counter is not available, the counter is stored in %s and it does not resist a disk rollback: %v", fallbackPath, err)
+		return cryptoengine.NewFileMonotonicCounter(fallbackPath)
+	}
+	return counter
+}
+
+// This method increments the TPM counter and returns its new value
+func (counter *Counter) Increment() (uint64, error) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	if err := tpm2.NVIncrement(counter.rw, counter.index, counter.auth); err != nil {
+		return 0, err
+	}
+	return counter.read()
+}
+
+// This method closes the TPM device
+func (counter *Counter) Close() error {
+	return counter.rw.Close()
+}
+
+// reads the value of the counter, a TPM counter is a big endian 64 bits integer
+func (counter *Counter) read() (uint64, error) {
+	data, err := tpm2.NVReadEx(counter.rw, counter.index, counter.index, counter.auth, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != counterSize {
+		return 0, CounterSizeError
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
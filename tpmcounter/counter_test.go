@@ -0,0 +1,33 @@
+package tpmcounter
+
+import (
+	"github.com/sec51/cryptoengine"
+	"path/filepath"
+	"testing"
+)
+
+// make sure the counter implements the cryptoengine interface
+var _ cryptoengine.MonotonicCounter = &Counter{}
+
+func TestFallback(t *testing.T) {
+
+	directory := t.TempDir()
+	counter := OpenWithFallback(filepath.Join(directory, "tpm"), 0x1500016, "", filepath.Join(directory, "monotonic.counter"))
+	if _, ok := counter.(*cryptoengine.FileMonotonicCounter); !ok {
+		t.Fatalf("The counter without a TPM is not a file counter: %T\n", counter)
+	}
+
+	store := cryptoengine.NewMonotonicCounterStore(counter)
+	first, err := store.ReserveCounters("sec51tpm", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.ReserveCounters("sec51tpm", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first+10 {
+		t.Fatalf("The counters are not consecutive: %d after %d\n", second, first)
+	}
+
+}
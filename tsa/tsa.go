@@ -0,0 +1,201 @@
+// Package tsa attaches RFC 3161 trusted timestamps to the signed messages of cryptoengine, for the audit trails
+// which must prove when the data was signed.
+//
+// The timestamp authority signs the SHA-256 hash of the whole signed message together with the time,
+// and its token is appended to the message:
+//
+//	|magic|          => 4 bytes ("CTS1")
+//	|message length| => 4 bytes (uint32 little endian)
+//	|message|        => N bytes (returned by cryptoengine.NewSignedMessage)
+//	|token|          => M bytes (the DER timestamp token of the authority)
+//
+// The receiver verifies the signature of the peer, then the token against the certificates of the authorities it trusts.
+//
+//	data, err := tsa.Sign(engine, msg, header, tsa.HTTPAuthority{URL: "https://freetsa.org/tsr"})
+//	msg, header, signedAt, err := tsa.Verify(peer, data, roots)
+package tsa
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/digitorus/pkcs7"
+	"github.com/digitorus/timestamp"
+	"github.com/sec51/cryptoengine"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+const (
+	magic      = "CTS1"
+	prefixSize = len(magic) + 4
+
+	maxResponseSize = 64 * 1024 // the largest response accepted from a timestamp authority
+)
+
+var (
+	TimestampError          = errors.New("The timestamped message is not valid")
+	TimestampMismatchError  = errors.New("The timestamp token does not cover the message")
+	TimestampAuthorityError = errors.New("The timestamp token is not signed by a trusted authority")
+)
+
+// The Authority interface issues the timestamp tokens
+type Authority interface {
+	// returns the DER timestamp token of the SHA-256 digest, with the certificate of the authority
+	Timestamp(digest []byte) ([]byte, error)
+}
+
+// The HTTPAuthority requests the tokens from a timestamp authority over HTTP, as described by RFC 3161
+type HTTPAuthority struct {
+	URL    string       // the URL of the authority
+	Client *http.Client // nil for http.DefaultClient
+}
+
+// This method requests the token of the digest from the authority
+func (authority HTTPAuthority) Timestamp(digest []byte) ([]byte, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	request := &timestamp.Request{
+		HashAlgorithm: crypto.SHA256,
+		HashedMessage: digest,
+		Nonce:         nonce,
+		Certificates:  true,
+	}
+	body, err := request.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	client := authority.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Post(authority.URL, "application/timestamp-query", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("The timestamp authority answered with the status %s", response.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	ts, err := timestamp.ParseResponse(data)
+	if err != nil {
+		return nil, err
+	}
+	if ts.Nonce == nil || ts.Nonce.Cmp(nonce) != 0 || !bytes.Equal(ts.HashedMessage, digest) {
+		return nil, TimestampMismatchError
+	}
+	return ts.RawToken, nil
+}
+
+// This function signs the message and the header with the engine, see cryptoengine.NewSignedMessage, and timestamps it
+func Sign(engine *cryptoengine.CryptoEngine, msg cryptoengine.Message, header cryptoengine.MessageHeader, authority Authority) ([]byte, error) {
+	signed, err := engine.NewSignedMessage(msg, header)
+	if err != nil {
+		return nil, err
+	}
+	return Stamp(signed, authority)
+}
+
+// This function timestamps a message returned by cryptoengine.NewSignedMessage
+func Stamp(signed []byte, authority Authority) ([]byte, error) {
+	digest := sha256.Sum256(signed)
+	token, err := authority.Timestamp(digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, prefixSize+len(signed)+len(token))
+	data = append(data, magic...)
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(signed)))
+	data = append(data, length[:]...)
+	data = append(data, signed...)
+	return append(data, token...), nil
+}
+
+// This function verifies the signature of the peer and the timestamp token against the trusted authorities,
+// and returns the message, its header and the time the authority vouches for
+func Verify(peer cryptoengine.VerificationEngine, data []byte, roots *x509.CertPool) (*cryptoengine.Message, cryptoengine.MessageHeader, time.Time, error) {
+	if len(data) < prefixSize || string(data[:len(magic)]) != magic {
+		return nil, cryptoengine.MessageHeader{}, time.Time{}, TimestampError
+	}
+	length := binary.LittleEndian.Uint32(data[len(magic):])
+	if uint64(length) >= uint64(len(data)-prefixSize) {
+		return nil, cryptoengine.MessageHeader{}, time.Time{}, TimestampError
+	}
+	signed, token := data[prefixSize:prefixSize+int(length)], data[prefixSize+int(length):]
+
+	msg, header, err := peer.VerifySignedMessage(signed)
+	if err != nil {
+		return nil, cryptoengine.MessageHeader{}, time.Time{}, err
+	}
+
+	signedAt, err := verifyToken(token, signed, roots)
+	if err != nil {
+		return nil, cryptoengine.MessageHeader{}, time.Time{}, err
+	}
+	return msg, header, signedAt, nil
+}
+
+// verifies the token covers the signed message and is issued by a trusted authority, and returns its time
+func verifyToken(token, signed []byte, roots *x509.CertPool) (time.Time, error) {
+	// the signature of the token is verified with the certificate of the signer it embeds
+	ts, err := timestamp.Parse(token)
+	if err != nil {
+		return time.Time{}, TimestampError
+	}
+
+	digest := sha256.Sum256(signed)
+	if ts.HashAlgorithm != crypto.SHA256 || !bytes.Equal(ts.HashedMessage, digest[:]) {
+		return time.Time{}, TimestampMismatchError
+	}
+
+	// the signer of the token must chain to a trusted authority, at the time of the timestamp
+	p7, err := pkcs7.Parse(token)
+	if err != nil {
+		return time.Time{}, TimestampError
+	}
+	signer := p7.GetOnlySigner()
+	if signer == nil || !isTimestampingCertificate(signer) {
+		return time.Time{}, TimestampAuthorityError
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range p7.Certificates {
+		intermediates.AddCert(certificate)
+	}
+	options := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   ts.Time,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}
+	if _, err := signer.Verify(options); err != nil {
+		return time.Time{}, TimestampAuthorityError
+	}
+	return ts.Time, nil
+}
+
+func isTimestampingCertificate(certificate *x509.Certificate) bool {
+	for _, usage := range certificate.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageTimeStamping {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package tsa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"github.com/digitorus/timestamp"
+	"github.com/sec51/cryptoengine"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// a self signed timestamp authority
+func testAuthority(t *testing.T) (*httptest.Server, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Sec51 TSA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request, err := timestamp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ts := timestamp.Timestamp{
+			HashAlgorithm:     request.HashAlgorithm,
+			HashedMessage:     request.HashedMessage,
+			Time:              time.Now(),
+			Nonce:             request.Nonce,
+			Policy:            asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 51, 1},
+			SerialNumber:      big.NewInt(42),
+			AddTSACertificate: request.Certificates,
+		}
+		response, err := ts.CreateResponse(certificate, key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(response)
+	}))
+	return server, certificate
+}
+
+func TestTimestampedMessage(t *testing.T) {
+
+	server, certificate := testAuthority(t)
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+
+	engine, err := cryptoengine.InitCryptoEngineWithConfig("Sec51TSA", cryptoengine.Config{KeyStore: cryptoengine.NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := cryptoengine.NewVerificationEngineWithKeys(engine.PublicKey(), engine.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := cryptoengine.NewMessage("audit record", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().Add(-time.Second)
+	data, err := Sign(engine, msg, cryptoengine.MessageHeader{Sequence: 1}, HTTPAuthority{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, header, signedAt, err := Verify(peer, data, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.Text != "audit record" || header.Sequence != 1 || signedAt.Before(before) || signedAt.After(time.Now().Add(time.Second)) {
+		t.Fatalf("The timestamped message does not match: %q %d %v\n", verified.Text, header.Sequence, signedAt)
+	}
+
+	// the authority must be trusted
+	if _, _, _, err := Verify(peer, data, x509.NewCertPool()); err != TimestampAuthorityError {
+		t.Fatalf("Expected %v, got %v\n", TimestampAuthorityError, err)
+	}
+
+	// the token cannot be moved to another message
+	other, err := Sign(engine, msg, cryptoengine.MessageHeader{Sequence: 2}, HTTPAuthority{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapped := append(other[:prefixSize+signedLength(other)], data[prefixSize+signedLength(data):]...)
+	if _, _, _, err := Verify(peer, swapped, roots); err != TimestampMismatchError {
+		t.Fatalf("Expected %v, got %v\n", TimestampMismatchError, err)
+	}
+
+	if _, _, _, err := Verify(peer, data[:prefixSize], roots); err != TimestampError {
+		t.Fatalf("Expected %v, got %v\n", TimestampError, err)
+	}
+
+}
+
+// returns the length of the signed message of a timestamped message
+func signedLength(data []byte) int {
+	return int(binary.LittleEndian.Uint32(data[len(magic):]))
+}
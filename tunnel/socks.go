@@ -0,0 +1,156 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+)
+
+// A minimal SOCKS5 proxy (RFC 1928) in front of the tunnel: no authentication, the CONNECT command only.
+// The local applications connect through the proxy and the server of the tunnel dials the targets,
+// so the target names are resolved by the server.
+
+const (
+	socksVersion = 5
+
+	socksNoAuthentication    = 0
+	socksNoAcceptableMethods = 0xff
+	socksConnect             = 1
+
+	socksIPv4   = 1
+	socksDomain = 3
+	socksIPv6   = 4
+
+	socksSucceeded               = 0
+	socksGeneralFailure          = 1
+	socksNotAllowed              = 2
+	socksHostUnreachable         = 4
+	socksCommandNotSupported     = 7
+	socksAddressTypeNotSupported = 8
+)
+
+var (
+	SOCKSError = errors.New("The SOCKS5 request is not valid")
+)
+
+// This function serves a SOCKS5 proxy on the listener, the connections are forwarded through the tunnel.
+// It returns when the listener fails.
+func ServeSOCKS(listener net.Listener, session *Session) error {
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go serveSOCKSConn(local, session)
+	}
+}
+
+func serveSOCKSConn(local net.Conn, session *Session) {
+	target, err := readSOCKSRequest(local)
+	if err != nil {
+		local.Close()
+		return
+	}
+
+	stream, err := session.Connect(target)
+	if err != nil {
+		reply := byte(socksGeneralFailure)
+		switch err {
+		case TargetRefusedError:
+			reply = socksNotAllowed
+		case TargetFailedError:
+			reply = socksHostUnreachable
+		}
+		writeSOCKSReply(local, reply)
+		local.Close()
+		return
+	}
+	if err := writeSOCKSReply(local, socksSucceeded); err != nil {
+		stream.Close()
+		local.Close()
+		return
+	}
+	join(local, stream)
+}
+
+// negotiates the method and reads the CONNECT request, it returns the target "host:port"
+func readSOCKSRequest(conn net.Conn) (string, error) {
+	// version|methods count|methods
+	var greeting [2]byte
+	if _, err := io.ReadFull(conn, greeting[:]); err != nil {
+		return "", err
+	}
+	if greeting[0] != socksVersion || greeting[1] == 0 {
+		return "", SOCKSError
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksNoAcceptableMethods)
+	for _, m := range methods {
+		if m == socksNoAuthentication {
+			method = socksNoAuthentication
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksNoAcceptableMethods {
+		return "", SOCKSError
+	}
+
+	// version|command|reserved|address type|address|port
+	var request [4]byte
+	if _, err := io.ReadFull(conn, request[:]); err != nil {
+		return "", err
+	}
+	if request[0] != socksVersion {
+		return "", SOCKSError
+	}
+	if request[1] != socksConnect {
+		writeSOCKSReply(conn, socksCommandNotSupported)
+		return "", SOCKSError
+	}
+
+	var host string
+	switch request[3] {
+	case socksIPv4, socksIPv6:
+		size := net.IPv4len
+		if request[3] == socksIPv6 {
+			size = net.IPv6len
+		}
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksDomain:
+		var length [1]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		writeSOCKSReply(conn, socksAddressTypeNotSupported)
+		return "", SOCKSError
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// the bound address is not meaningful through the tunnel, it's always 0.0.0.0:0
+func writeSOCKSReply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{socksVersion, reply, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,306 @@
+// Package tunnel forwards TCP connections through an encrypted cryptoengine channel. It's a reference for using
+// the package as a transport layer: a handshake authenticates the two engines over a single TCP connection,
+// then each forwarded connection is a stream of a cryptoengine.Mux.
+//
+// The server accepts the tunnel of a known client engine and dials the targets the client asks for.
+// The client forwards a local port to a fixed target, with Forward, or serves a local SOCKS5 proxy, with ServeSOCKS.
+//
+//	// server
+//	server := &tunnel.Server{Engine: engine, Client: clientKeys, Allow: func(target string) bool { return target == "db:5432" }}
+//	server.Serve(listener)
+//
+//	// client
+//	session, err := tunnel.Dial(engine, serverKeys, "tunnel.example.com:7000")
+//	tunnel.ServeSOCKS(localListener, session)
+//
+// The handshake messages are sent with a 2 bytes little endian length prefix. Each stream starts with the request
+// of the client, the target length (1 byte) and the target "host:port", answered by the status of the server (1 byte).
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/sec51/cryptoengine"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	maxHandshakeMessageSize = 1024
+	maxTargetSize           = 255
+	handshakeTimeout        = 30 * time.Second
+	dialTimeout             = 30 * time.Second
+
+	// the statuses of a stream request
+	statusConnected = 0
+	statusRefused   = 1
+	statusFailed    = 2
+)
+
+var (
+	TunnelHandshakeError = errors.New("The tunnel handshake message is not valid")
+	TargetError          = errors.New("The tunnel target is not valid")
+	TargetRefusedError   = errors.New("The tunnel server refused the target")
+	TargetFailedError    = errors.New("The tunnel server could not connect to the target")
+)
+
+// The Session is the encrypted tunnel of a client to a server. It's safe for concurrent use.
+type Session struct {
+	mux *cryptoengine.Mux
+}
+
+// The Server accepts the tunnels of a client and connects its streams to their targets
+type Server struct {
+	Engine *cryptoengine.CryptoEngine                      // the engine of the server
+	Client cryptoengine.VerificationEngine                 // the public keys of the client allowed to open a tunnel
+	Allow  func(target string) bool                        // whether the client can connect to the target. Nil allows all the targets
+	Dial   func(network, address string) (net.Conn, error) // how the targets are dialed. Nil means net.Dialer with a 30 seconds timeout
+}
+
+// This function connects to the tunnel server at the address and authenticates the two engines
+func Dial(engine *cryptoengine.CryptoEngine, server cryptoengine.VerificationEngine, address string) (*Session, error) {
+	conn, err := net.DialTimeout("tcp", address, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	session, err := NewSession(engine, server, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// This function runs the handshake over the connection to the tunnel server and returns the session
+func NewSession(engine *cryptoengine.CryptoEngine, server cryptoengine.VerificationEngine, conn net.Conn) (*Session, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	handshake, hello, err := engine.InitiateHandshake(server)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeHandshakeMessage(conn, hello); err != nil {
+		return nil, err
+	}
+	keyShare, err := readHandshakeMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	confirm, err := handshake.Finish(keyShare)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeHandshakeMessage(conn, confirm); err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	mux, err := handshake.NewMux(conn)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{mux: mux}, nil
+}
+
+// This method opens a stream to the target "host:port", through the server
+func (session *Session) Connect(target string) (io.ReadWriteCloser, error) {
+	if len(target) == 0 || len(target) > maxTargetSize {
+		return nil, TargetError
+	}
+
+	stream, err := session.mux.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stream.Write(append([]byte{byte(len(target))}, target...)); err != nil {
+		stream.Close()
+		return nil, err
+	}
+
+	var status [1]byte
+	if _, err := io.ReadFull(stream, status[:]); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	switch status[0] {
+	case statusConnected:
+		return stream, nil
+	case statusRefused:
+		stream.Close()
+		return nil, TargetRefusedError
+	default:
+		stream.Close()
+		return nil, TargetFailedError
+	}
+}
+
+// This method returns a channel closed when the tunnel is closed
+func (session *Session) Done() <-chan struct{} {
+	return session.mux.Done()
+}
+
+// This method closes the tunnel and all its streams
+func (session *Session) Close() error {
+	return session.mux.Close()
+}
+
+// This function forwards each connection accepted by the listener to the target, through the tunnel.
+// It returns when the listener fails.
+func Forward(listener net.Listener, session *Session, target string) error {
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			stream, err := session.Connect(target)
+			if err != nil {
+				local.Close()
+				return
+			}
+			join(local, stream)
+		}()
+	}
+}
+
+// This method accepts the tunnels of the client on the listener. It returns when the listener fails.
+func (server *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := server.ServeConn(conn); err != nil {
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// This method runs the handshake of the client over the connection and connects its streams to their targets,
+// until the tunnel is closed
+func (server *Server) ServeConn(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	hello, err := readHandshakeMessage(conn)
+	if err != nil {
+		return err
+	}
+	handshake, keyShare, err := server.Engine.RespondHandshake(server.Client, hello)
+	if err != nil {
+		return err
+	}
+	if err := writeHandshakeMessage(conn, keyShare); err != nil {
+		return err
+	}
+	confirm, err := readHandshakeMessage(conn)
+	if err != nil {
+		return err
+	}
+	if err := handshake.Confirm(confirm); err != nil {
+		return err
+	}
+
+	conn.SetDeadline(time.Time{})
+	mux, err := handshake.NewMux(conn)
+	if err != nil {
+		return err
+	}
+	defer mux.Close()
+
+	for {
+		stream, err := mux.AcceptStream()
+		if err != nil {
+			return nil
+		}
+		go server.serveStream(stream)
+	}
+}
+
+// reads the target of the stream, dials it and joins them
+func (server *Server) serveStream(stream *cryptoengine.Stream) {
+	var length [1]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil || length[0] == 0 {
+		stream.Close()
+		return
+	}
+	target := make([]byte, length[0])
+	if _, err := io.ReadFull(stream, target); err != nil {
+		stream.Close()
+		return
+	}
+
+	if server.Allow != nil && !server.Allow(string(target)) {
+		stream.Write([]byte{statusRefused})
+		stream.Close()
+		return
+	}
+
+	dial := server.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: dialTimeout}).Dial
+	}
+	remote, err := dial("tcp", string(target))
+	if err != nil {
+		stream.Write([]byte{statusFailed})
+		stream.Close()
+		return
+	}
+	if _, err := stream.Write([]byte{statusConnected}); err != nil {
+		remote.Close()
+		stream.Close()
+		return
+	}
+	join(remote, stream)
+}
+
+// copies the data in both directions until both sides are closed
+func join(conn net.Conn, stream io.ReadWriteCloser) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(stream, conn)
+		stream.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(conn, stream)
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		} else {
+			conn.Close()
+		}
+	}()
+	wg.Wait()
+	conn.Close()
+}
+
+func writeHandshakeMessage(w io.Writer, message []byte) error {
+	if len(message) > maxHandshakeMessageSize {
+		return TunnelHandshakeError
+	}
+	data := make([]byte, 2, 2+len(message))
+	binary.LittleEndian.PutUint16(data, uint16(len(message)))
+	_, err := w.Write(append(data, message...))
+	return err
+}
+
+func readHandshakeMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint16(length[:])
+	if size == 0 || size > maxHandshakeMessageSize {
+		return nil, TunnelHandshakeError
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
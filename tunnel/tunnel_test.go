@@ -0,0 +1,169 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/sec51/cryptoengine"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// starts an echo target, a tunnel server allowing only the echo target and a client session connected to it
+func newTunnel(t *testing.T) (*Session, string) {
+
+	client, err := cryptoengine.InitCryptoEngineWithConfig("Sec51Client", cryptoengine.Config{KeyStore: cryptoengine.NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := cryptoengine.InitCryptoEngineWithConfig("Sec51Server", cryptoengine.Config{KeyStore: cryptoengine.NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKeys, err := cryptoengine.NewVerificationEngineWithKeys(client.PublicKey(), client.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKeys, err := cryptoengine.NewVerificationEngineWithKeys(server.PublicKey(), server.SigningPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	target := echo.Addr().String()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	tunnelServer := &Server{Engine: server, Client: clientKeys, Allow: func(address string) bool { return address == target }}
+	go tunnelServer.Serve(listener)
+
+	session, err := Dial(client, serverKeys, listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	return session, target
+}
+
+func TestForward(t *testing.T) {
+
+	session, target := newTunnel(t)
+
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	go Forward(local, session, target)
+
+	conn, err := net.Dial("tcp", local.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data := bytes.Repeat([]byte("tunnel"), 20000)
+	go func() {
+		conn.Write(data)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	echoed, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(echoed, data) {
+		t.Fatalf("Expected %d bytes, got %d\n", len(data), len(echoed))
+	}
+
+	// the server refuses the targets which are not allowed
+	if _, err := session.Connect("127.0.0.1:1"); err != TargetRefusedError {
+		t.Fatalf("Expected %v, got %v\n", TargetRefusedError, err)
+	}
+
+}
+
+func TestSOCKS(t *testing.T) {
+
+	session, target := newTunnel(t)
+
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	go ServeSOCKS(local, session)
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, err := net.LookupPort("tcp", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", local.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// no authentication
+	if _, err := conn.Write([]byte{socksVersion, 1, socksNoAuthentication}); err != nil {
+		t.Fatal(err)
+	}
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(conn, method); err != nil {
+		t.Fatal(err)
+	}
+	if method[1] != socksNoAuthentication {
+		t.Fatalf("Expected %v, got %v\n", socksNoAuthentication, method[1])
+	}
+
+	// CONNECT to the IPv4 address of the echo target
+	request := []byte{socksVersion, socksConnect, 0, socksIPv4}
+	request = append(request, net.ParseIP(host).To4()...)
+	request = append(request, 0, 0)
+	binary.BigEndian.PutUint16(request[len(request)-2:], uint16(portNumber))
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[1] != socksSucceeded {
+		t.Fatalf("Expected %v, got %v\n", socksSucceeded, reply[1])
+	}
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	echoed := make([]byte, 5)
+	if _, err := io.ReadFull(conn, echoed); err != nil {
+		t.Fatal(err)
+	}
+	if string(echoed) != "hello" {
+		t.Fatalf("Expected %v, got %v\n", "hello", string(echoed))
+	}
+
+}
@@ -0,0 +1,216 @@
+package cryptoengine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"strconv"
+	"time"
+)
+
+// Cross implementation test vectors: the byte level serialization of the messages is canonical,
+// the same keys, nonce and message always give the same bytes, so an implementation in another language
+// can be verified against the vectors returned by GenerateTestVectors, or testdata/vectors.json.
+// All the binary values are hex encoded, the integers of the serialized messages are little endian.
+//
+// nonce:             HKDF-SHA256(ikm: nonce key, salt: salt, info: context|decimal counter), 24 bytes
+// secretbox:         length (8 bytes)|nonce (24 bytes)|secretbox(key: secret key, version (4 bytes)|type (4 bytes)|text)
+// box:               length (8 bytes)|nonce (24 bytes)|box(sender private key, recipient public key, version (4 bytes)|type (4 bytes)|text)
+// header-secret-key: the format of NewEncryptedMessageWithHeader, the message key is the subkey of the secret key
+// header-public-key: the format of NewEncryptedMessageWithHeaderAndPubKey, the message key is derived from the box shared key
+
+const (
+	VectorFormatNonce           = "nonce"
+	VectorFormatSecretbox       = "secretbox"
+	VectorFormatBox             = "box"
+	VectorFormatHeaderSecretKey = "header-secret-key"
+	VectorFormatHeaderPublicKey = "header-public-key"
+)
+
+// The TestVector struct holds the inputs and the expected output of a vector
+type TestVector struct {
+	Name                string `json:"name"`
+	Format              string `json:"format"`
+	SecretKey           string `json:"secret_key,omitempty"`
+	NonceKey            string `json:"nonce_key,omitempty"`
+	Salt                string `json:"salt,omitempty"`
+	Context             string `json:"context,omitempty"`
+	Counter             uint64 `json:"counter,omitempty"`
+	SenderPrivateKey    string `json:"sender_private_key,omitempty"`
+	SenderPublicKey     string `json:"sender_public_key,omitempty"`
+	RecipientPrivateKey string `json:"recipient_private_key,omitempty"`
+	RecipientPublicKey  string `json:"recipient_public_key,omitempty"`
+	MessageKey          string `json:"message_key,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
+	MessageType         int    `json:"message_type,omitempty"`
+	Plaintext           string `json:"plaintext,omitempty"`
+	KeyID               string `json:"key_id,omitempty"`
+	AAD                 string `json:"aad,omitempty"`
+	Timestamp           int64  `json:"timestamp,omitempty"`
+	Flags               uint32 `json:"flags,omitempty"`
+	Output              string `json:"output"`
+}
+
+// This function returns the test vectors, generated from fixed keys and nonces
+func GenerateTestVectors() ([]TestVector, error) {
+	sender, err := vectorEngine("sender")
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := vectorEngine("recipient")
+	if err != nil {
+		return nil, err
+	}
+
+	var vectors []TestVector
+
+	// nonce derivation
+	for _, counter := range []uint64{0, 1, 1 << 40} {
+		nonce, err := deriveNonce(sender.nonceKey, sender.salt, sender.context, strconv.FormatUint(counter, 10))
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, TestVector{
+			Name:     "nonce counter " + strconv.FormatUint(counter, 10),
+			Format:   VectorFormatNonce,
+			NonceKey: hex.EncodeToString(sender.nonceKey[:]),
+			Salt:     hex.EncodeToString(sender.salt[:]),
+			Context:  sender.context,
+			Counter:  counter,
+			Output:   hex.EncodeToString(nonce[:]),
+		})
+	}
+
+	messages := []Message{
+		{Version: tcpVersion, Type: 0, Text: "The quick brown fox jumps over the lazy dog"},
+		{Version: tcpVersion, Type: 7, Text: "Größe: 日本語 ✓"},
+	}
+
+	for i, msg := range messages {
+		nonce := vectorNonce("secretbox " + strconv.Itoa(i))
+		data := secretbox.Seal(nil, msg.toBytes(), &nonce, &sender.secretKey)
+		output, err := EncryptedMessage{length: uint64(8 + nonceSize + len(data)), nonce: nonce, data: data}.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, TestVector{
+			Name:        "secretbox message " + strconv.Itoa(i),
+			Format:      VectorFormatSecretbox,
+			SecretKey:   hex.EncodeToString(sender.secretKey[:]),
+			Nonce:       hex.EncodeToString(nonce[:]),
+			MessageType: msg.Type,
+			Plaintext:   msg.Text,
+			Output:      hex.EncodeToString(output),
+		})
+	}
+
+	for i, msg := range messages {
+		nonce := vectorNonce("box " + strconv.Itoa(i))
+		data := box.Seal(nil, msg.toBytes(), &nonce, &recipient.publicKey, &sender.privateKey)
+		output, err := EncryptedMessage{length: uint64(8 + nonceSize + len(data)), nonce: nonce, data: data}.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, TestVector{
+			Name:                "box message " + strconv.Itoa(i),
+			Format:              VectorFormatBox,
+			SenderPrivateKey:    hex.EncodeToString(sender.privateKey[:]),
+			SenderPublicKey:     hex.EncodeToString(sender.publicKey[:]),
+			RecipientPrivateKey: hex.EncodeToString(recipient.privateKey[:]),
+			RecipientPublicKey:  hex.EncodeToString(recipient.publicKey[:]),
+			Nonce:               hex.EncodeToString(nonce[:]),
+			MessageType:         msg.Type,
+			Plaintext:           msg.Text,
+			Output:              hex.EncodeToString(output),
+		})
+	}
+
+	header := MessageHeader{
+		KeyID:     []byte("key-2024"),
+		AAD:       []byte("user 42"),
+		Timestamp: time.Unix(1700000000, 0),
+		Flags:     1,
+	}
+
+	secretKeyHeader := header
+	secretKeyHeader.Suite = SuiteSecretKey
+	messageKey, err := sender.deriveSubKey(headerSubKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	nonce := vectorNonce("header secret key")
+	output, err := sender.sealWithHeader(messageKey, nonce, messages[0], secretKeyHeader)
+	if err != nil {
+		return nil, err
+	}
+	vectors = append(vectors, TestVector{
+		Name:       "header message secret key",
+		Format:     VectorFormatHeaderSecretKey,
+		SecretKey:  hex.EncodeToString(sender.secretKey[:]),
+		MessageKey: hex.EncodeToString(messageKey[:]),
+		Nonce:      hex.EncodeToString(nonce[:]),
+		Plaintext:  messages[0].Text,
+		KeyID:      hex.EncodeToString(header.KeyID),
+		AAD:        hex.EncodeToString(header.AAD),
+		Timestamp:  header.Timestamp.Unix(),
+		Flags:      header.Flags,
+		Output:     hex.EncodeToString(output),
+	})
+
+	publicKeyHeader := header
+	publicKeyHeader.Suite = SuitePublicKey
+	if messageKey, err = sender.headerPublicKey(recipient.publicKey); err != nil {
+		return nil, err
+	}
+	nonce = vectorNonce("header public key")
+	if output, err = sender.sealWithHeader(messageKey, nonce, messages[1], publicKeyHeader); err != nil {
+		return nil, err
+	}
+	vectors = append(vectors, TestVector{
+		Name:                "header message public key",
+		Format:              VectorFormatHeaderPublicKey,
+		SenderPrivateKey:    hex.EncodeToString(sender.privateKey[:]),
+		SenderPublicKey:     hex.EncodeToString(sender.publicKey[:]),
+		RecipientPrivateKey: hex.EncodeToString(recipient.privateKey[:]),
+		RecipientPublicKey:  hex.EncodeToString(recipient.publicKey[:]),
+		MessageKey:          hex.EncodeToString(messageKey[:]),
+		Nonce:               hex.EncodeToString(nonce[:]),
+		MessageType:         messages[1].Type,
+		Plaintext:           messages[1].Text,
+		KeyID:               hex.EncodeToString(header.KeyID),
+		AAD:                 hex.EncodeToString(header.AAD),
+		Timestamp:           header.Timestamp.Unix(),
+		Flags:               header.Flags,
+		Output:              hex.EncodeToString(output),
+	})
+
+	return vectors, nil
+}
+
+// an engine whose keys are the SHA-256 hashes of fixed labels
+func vectorEngine(name string) (*CryptoEngine, error) {
+	engine := &CryptoEngine{
+		context:          "vectors " + name,
+		secretKey:        sha256.Sum256([]byte("cryptoengine test vector " + name + " secret key")),
+		nonceKey:         sha256.Sum256([]byte("cryptoengine test vector " + name + " nonce key")),
+		salt:             sha256.Sum256([]byte("cryptoengine test vector " + name + " salt")),
+		privateKey:       sha256.Sum256([]byte("cryptoengine test vector " + name + " private key")),
+		preSharedKeysMap: make(map[string][keySize]byte),
+	}
+
+	publicKey, err := curve25519.X25519(engine.privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	copy(engine.publicKey[:], publicKey)
+	return engine, nil
+}
+
+func vectorNonce(label string) [nonceSize]byte {
+	var nonce [nonceSize]byte
+	sum := sha256.Sum256([]byte("cryptoengine test vector nonce " + label))
+	copy(nonce[:], sum[:])
+	return nonce
+}
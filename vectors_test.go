@@ -0,0 +1,87 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+// the serialization is canonical: the generated vectors must match the published ones
+func TestVectors(t *testing.T) {
+
+	published, err := ioutil.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vectors, err := GenerateTestVectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(published), generated) {
+		t.Fatal("The generated test vectors do not match testdata/vectors.json")
+	}
+}
+
+// the vectors are decrypted with the public API
+func TestVectorsDecryption(t *testing.T) {
+
+	var vectors []TestVector
+	published, err := ioutil.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(published, &vectors); err != nil {
+		t.Fatal(err)
+	}
+
+	sender, err := vectorEngine("sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := vectorEngine("recipient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderVerification, err := NewVerificationEngineWithKey(sender.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, vector := range vectors {
+		if vector.Format == VectorFormatNonce {
+			continue
+		}
+
+		output, err := hex.DecodeString(vector.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var msg *Message
+		switch vector.Format {
+		case VectorFormatSecretbox:
+			msg, err = sender.Decrypt(output)
+		case VectorFormatBox:
+			msg, err = recipient.DecryptWithPublicKey(output, senderVerification)
+		case VectorFormatHeaderSecretKey:
+			msg, _, err = sender.DecryptWithHeader(output)
+		case VectorFormatHeaderPublicKey:
+			msg, _, err = recipient.DecryptWithHeaderAndPublicKey(output, senderVerification)
+		default:
+			t.Fatalf("Unknown vector format: %s\n", vector.Format)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v\n", vector.Name, err)
+		}
+		if msg.Text != vector.Plaintext || msg.Type != vector.MessageType {
+			t.Fatalf("%s: the decrypted message does not match the plaintext\n", vector.Name)
+		}
+	}
+}
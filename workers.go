@@ -0,0 +1,55 @@
+package cryptoengine
+
+import (
+	"runtime"
+	"sync"
+)
+
+const (
+	minItemsPerWorker = 64 // below this amount of items per worker, the goroutines cost more than they save
+)
+
+// returns the amount of workers used by the engine for batch and multi-chunk operations
+func (engine *CryptoEngine) parallelism() int {
+	if engine.config.Parallelism <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return engine.config.Parallelism
+}
+
+// Calls job for each index from 0 to n-1, using up to workers goroutines.
+// The job must write its result at its own index, so the output order is preserved regardless of the scheduling.
+// Small workloads are executed on the calling goroutine.
+func runWorkers(n, workers int, job func(i int)) {
+	if workers > n/minItemsPerWorker {
+		workers = n / minItemsPerWorker
+	}
+
+	// not worth spawning goroutines
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			job(i)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	indexes := make(chan int, workers)
+
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				job(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+}
@@ -0,0 +1,60 @@
+package cryptoengine
+
+import (
+	"testing"
+)
+
+func TestRunWorkersPreservesOrder(t *testing.T) {
+
+	for _, workers := range []int{1, 2, 8} {
+		results := make([]int, 1000)
+		runWorkers(len(results), workers, func(i int) {
+			results[i] = i * i
+		})
+
+		for i, result := range results {
+			if result != i*i {
+				t.Fatalf("Worker pool with %d workers broken at index %d\n", workers, i)
+			}
+		}
+	}
+
+}
+
+func TestParallelEncryptBatch(t *testing.T) {
+
+	engine, err := InitCryptoEngineWithConfig("Sec51", Config{Parallelism: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := make([]Message, 1000)
+	for i := range msgs {
+		msgs[i], err = NewMessage("The quick brown fox jumps over the lazy dog", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encryptedMessages, err := engine.EncryptBatch(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, encryptedMessage := range encryptedMessages {
+		messageBytes, err := encryptedMessage.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := engine.Decrypt(messageBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decrypted.Type != i {
+			t.Fatalf("Parallel batch encryption did not preserve the order at index %d\n", i)
+		}
+	}
+
+}
@@ -0,0 +1,407 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// X3DH (https://signal.org/docs/specifications/x3dh/) for the asynchronous first contact:
+// the recipient publishes a prekey bundle, with a signed prekey and a set of one-time prekeys,
+// and the sender encrypts to it while the recipient is offline.
+// The identity key is the engine X25519 key pair and the signed prekey is signed with the engine Ed25519 signing key.
+//
+// The prekeys private keys are stored in the key store of the engine, which must implement KeyLister:
+// <id>_signed_prekey_<prekey id>.key and <id>_prekey_<prekey id>.key
+// A one-time prekey is deleted as soon as a message which used it has been decrypted.
+// A message without a one-time prekey, sent when the bundle has run out of them, can be replayed until the signed prekey is rotated:
+// the application must detect the replayed first messages, for instance with the shared key.
+
+const (
+	signedPrekeySuffixFormat  = "%s_signed_prekey_%d.key"
+	oneTimePrekeySuffixFormat = "%s_prekey_%d.key"
+
+	x3dhVersion       = 1
+	x3dhFlagOneTime   = 1
+	x3dhHeaderSize    = 2 + 4 + 4 + keySize + keySize
+	x3dhInfo          = "cryptoengine X3DH"
+	x3dhPrekeyContext = "cryptoengine signed prekey"
+)
+
+// serializes the consumption of the one-time prekeys, so a prekey is used by one message only,
+// even by the engines of the same identifier sharing a key store
+var oneTimePrekeyMutex sync.Mutex
+
+var (
+	PrekeyNotFoundError = errors.New("The prekey is not available, it might have been used already")
+	X3DHFormatError     = errors.New("The X3DH message is not valid")
+)
+
+// The OneTimePrekey is the public part of a one-time prekey
+type OneTimePrekey struct {
+	Id        uint32
+	PublicKey [keySize]byte
+}
+
+// The PrekeyBundle holds what a sender needs to encrypt the first message for the engine.
+// Each one-time prekey should be handed out to one sender only: the distribution service is expected to remove it from the bundle it serves.
+type PrekeyBundle struct {
+	IdentityKey           [keySize]byte // the engine X25519 public key
+	SigningKey            [keySize]byte // the engine Ed25519 signing public key
+	SignedPrekeyId        uint32
+	SignedPrekey          [keySize]byte
+	SignedPrekeySignature []byte
+	OneTimePrekeys        []OneTimePrekey
+}
+
+// This method generates a new signed prekey and returns its id.
+// The ids grow over time and the bundle always publishes the newest signed prekey,
+// the older ones are kept to decrypt the messages still in flight until they are deleted with DeleteSignedPrekey.
+func (engine *CryptoEngine) GenerateSignedPrekey() (uint32, error) {
+	id := uint32(time.Now().Unix())
+	for engine.prekeyExists(fmt.Sprintf(signedPrekeySuffixFormat, engine.context, id)) {
+		id++
+	}
+
+	if err := engine.writePrekey(fmt.Sprintf(signedPrekeySuffixFormat, engine.context, id)); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// This method deletes the signed prekey, after the rotation period
+func (engine *CryptoEngine) DeleteSignedPrekey(id uint32) error {
+	return engine.config.keyStore().DeleteKey(fmt.Sprintf(signedPrekeySuffixFormat, engine.context, id))
+}
+
+// This method generates n new one-time prekeys and returns their ids
+func (engine *CryptoEngine) GenerateOneTimePrekeys(n int) ([]uint32, error) {
+	ids := make([]uint32, 0, n)
+	for len(ids) < n {
+		var idBytes [4]byte
+		if _, err := rand.Read(idBytes[:]); err != nil {
+			return ids, err
+		}
+		id := binary.BigEndian.Uint32(idBytes[:])
+
+		filename := fmt.Sprintf(oneTimePrekeySuffixFormat, engine.context, id)
+		if engine.prekeyExists(filename) {
+			continue
+		}
+		if err := engine.writePrekey(filename); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// This method returns the prekey bundle with the newest signed prekey and all the available one-time prekeys
+func (engine *CryptoEngine) PrekeyBundle() (PrekeyBundle, error) {
+	bundle := PrekeyBundle{}
+	copy(bundle.IdentityKey[:], engine.publicKey[:])
+	copy(bundle.SigningKey[:], engine.SigningPublicKey())
+
+	signedIds, err := engine.prekeyIds(signedPrekeySuffixFormat)
+	if err != nil {
+		return bundle, err
+	}
+	if len(signedIds) == 0 {
+		return bundle, PrekeyNotFoundError
+	}
+
+	bundle.SignedPrekeyId = signedIds[len(signedIds)-1]
+	_, public, err := engine.readPrekey(fmt.Sprintf(signedPrekeySuffixFormat, engine.context, bundle.SignedPrekeyId))
+	if err != nil {
+		return bundle, err
+	}
+	bundle.SignedPrekey = public
+	bundle.SignedPrekeySignature = engine.Sign(signedPrekeyMessage(bundle.SignedPrekeyId, public))
+
+	oneTimeIds, err := engine.prekeyIds(oneTimePrekeySuffixFormat)
+	if err != nil {
+		return bundle, err
+	}
+	for _, id := range oneTimeIds {
+		_, public, err := engine.readPrekey(fmt.Sprintf(oneTimePrekeySuffixFormat, engine.context, id))
+		if err != nil {
+			return bundle, err
+		}
+		bundle.OneTimePrekeys = append(bundle.OneTimePrekeys, OneTimePrekey{Id: id, PublicKey: public})
+	}
+
+	return bundle, nil
+}
+
+// This method encrypts the first message for the owner of the bundle, using the first one-time prekey if any.
+// It returns the message and the shared secret both parties derive, which can key the following exchanges.
+func (engine *CryptoEngine) EncryptX3DH(plainText []byte, bundle PrekeyBundle) ([]byte, [keySize]byte, error) {
+	var sharedKey [keySize]byte
+	if err := engine.allow(operationEncrypt, operationOwnKeys); err != nil {
+		return nil, sharedKey, err
+	}
+
+	if ConstantTimeEqual(bundle.IdentityKey[:], emptyKey) {
+		return nil, sharedKey, KeyNotValidError
+	}
+
+	// the signed prekey must be signed by the recipient
+	recipient, err := NewVerificationEngineWithKeys(bundle.IdentityKey[:], bundle.SigningKey[:])
+	if err != nil {
+		return nil, sharedKey, KeyNotValidError
+	}
+	if err := recipient.Verify(signedPrekeyMessage(bundle.SignedPrekeyId, bundle.SignedPrekey), bundle.SignedPrekeySignature); err != nil {
+		return nil, sharedKey, err
+	}
+
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, sharedKey, err
+	}
+	ephemeralPublic, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, sharedKey, err
+	}
+
+	// header: version|flags|signed prekey id|one-time prekey id|sender identity key|ephemeral key
+	header := make([]byte, 2, x3dhHeaderSize)
+	header[0] = x3dhVersion
+	header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[2:], bundle.SignedPrekeyId)
+	header = append(header, engine.publicKey[:]...)
+	header = append(header, ephemeralPublic...)
+
+	// DH1 = DH(IK_A, SPK_B), DH2 = DH(EK_A, IK_B), DH3 = DH(EK_A, SPK_B), DH4 = DH(EK_A, OPK_B)
+	pairs := [][2][]byte{
+		{engine.privateKey[:], bundle.SignedPrekey[:]},
+		{ephemeral, bundle.IdentityKey[:]},
+		{ephemeral, bundle.SignedPrekey[:]},
+	}
+	if len(bundle.OneTimePrekeys) > 0 {
+		oneTimePrekey := bundle.OneTimePrekeys[0]
+		header[1] |= x3dhFlagOneTime
+		binary.BigEndian.PutUint32(header[6:], oneTimePrekey.Id)
+		pairs = append(pairs, [2][]byte{ephemeral, oneTimePrekey.PublicKey[:]})
+	}
+
+	if sharedKey, err = x3dhSharedKey(pairs); err != nil {
+		return nil, sharedKey, err
+	}
+
+	sealed, err := x3dhSeal(sharedKey, header, bundle.IdentityKey[:], plainText)
+	if err != nil {
+		return nil, sharedKey, err
+	}
+	return append(header, sealed...), sharedKey, nil
+}
+
+// This method decrypts the first message of a sender and returns the plain text, the sender verification engine and the shared secret.
+// The one-time prekey used by the message is deleted, so the same message cannot be decrypted twice.
+// A message without a one-time prekey can be decrypted again: see the replay note above.
+func (engine *CryptoEngine) DecryptX3DH(data []byte) ([]byte, VerificationEngine, [keySize]byte, error) {
+	var sharedKey [keySize]byte
+	if err := engine.allow(operationDecrypt); err != nil {
+		return nil, VerificationEngine{}, sharedKey, err
+	}
+
+	if len(data) < x3dhHeaderSize+chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead || data[0] != x3dhVersion || data[1]&^x3dhFlagOneTime != 0 {
+		return nil, VerificationEngine{}, sharedKey, X3DHFormatError
+	}
+
+	header := data[:x3dhHeaderSize]
+	signedPrekeyId := binary.BigEndian.Uint32(header[2:])
+	oneTimePrekeyId := binary.BigEndian.Uint32(header[6:])
+	senderIdentity := header[10 : 10+keySize]
+	ephemeralPublic := header[10+keySize:]
+
+	sender, err := NewVerificationEngineWithKey(senderIdentity)
+	if err != nil {
+		return nil, VerificationEngine{}, sharedKey, X3DHFormatError
+	}
+
+	signedPrekeyFile := fmt.Sprintf(signedPrekeySuffixFormat, engine.context, signedPrekeyId)
+	signedPrekey, _, err := engine.readPrekey(signedPrekeyFile)
+	if err == KeyNotFoundError {
+		return nil, VerificationEngine{}, sharedKey, PrekeyNotFoundError
+	}
+	if err != nil {
+		return nil, VerificationEngine{}, sharedKey, err
+	}
+
+	pairs := [][2][]byte{
+		{signedPrekey[:], senderIdentity},
+		{engine.privateKey[:], ephemeralPublic},
+		{signedPrekey[:], ephemeralPublic},
+	}
+
+	oneTimePrekeyFile := ""
+	if header[1]&x3dhFlagOneTime != 0 {
+		oneTimePrekeyFile = fmt.Sprintf(oneTimePrekeySuffixFormat, engine.context, oneTimePrekeyId)
+		oneTimePrekey, _, err := engine.readPrekey(oneTimePrekeyFile)
+		if err == KeyNotFoundError {
+			return nil, VerificationEngine{}, sharedKey, PrekeyNotFoundError
+		}
+		if err != nil {
+			return nil, VerificationEngine{}, sharedKey, err
+		}
+		pairs = append(pairs, [2][]byte{oneTimePrekey[:], ephemeralPublic})
+	}
+
+	if sharedKey, err = x3dhSharedKey(pairs); err != nil {
+		return nil, VerificationEngine{}, sharedKey, X3DHFormatError
+	}
+
+	plainText, err := x3dhOpen(sharedKey, header, engine.publicKey[:], data[x3dhHeaderSize:])
+	if err != nil {
+		return nil, VerificationEngine{}, [keySize]byte{}, err
+	}
+
+	// the one-time prekey is consumed only by a valid message, and only once
+	if oneTimePrekeyFile != "" {
+		if err := engine.consumePrekey(oneTimePrekeyFile); err != nil {
+			return nil, VerificationEngine{}, [keySize]byte{}, err
+		}
+	}
+
+	return plainText, sender, sharedKey, nil
+}
+
+// deletes the one-time prekey, the messages decrypted concurrently with the same prekey fail with PrekeyNotFoundError
+func (engine *CryptoEngine) consumePrekey(filename string) error {
+	oneTimePrekeyMutex.Lock()
+	defer oneTimePrekeyMutex.Unlock()
+
+	store := engine.config.keyStore()
+	if _, err := store.ReadKey(filename); err == KeyNotFoundError {
+		return PrekeyNotFoundError
+	} else if err != nil {
+		return err
+	}
+	return store.DeleteKey(filename)
+}
+
+// what the signing key signs to certify the signed prekey
+func signedPrekeyMessage(id uint32, prekey [keySize]byte) []byte {
+	message := append([]byte(x3dhPrekeyContext), 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(message[len(x3dhPrekeyContext):], id)
+	return append(message, prekey[:]...)
+}
+
+// SK = HKDF(F || DH1 || DH2 || DH3 || DH4), F is 32 0xFF bytes for X25519
+func x3dhSharedKey(pairs [][2][]byte) ([keySize]byte, error) {
+	var sharedKey [keySize]byte
+
+	ikm := bytes.Repeat([]byte{0xff}, keySize)
+	for _, pair := range pairs {
+		// X25519 fails on low order points
+		secret, err := curve25519.X25519(pair[0], pair[1])
+		if err != nil {
+			return sharedKey, KeyNotValidError
+		}
+		ikm = append(ikm, secret...)
+	}
+
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, make([]byte, sha256.Size), []byte(x3dhInfo)), sharedKey[:]); err != nil {
+		return sharedKey, err
+	}
+	return sharedKey, nil
+}
+
+// XChaCha20-Poly1305 with a random nonce, the associated data is the header and the recipient identity key
+func x3dhSeal(key [keySize]byte, header, recipientIdentity, plainText []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX, chacha20poly1305.NonceSizeX+len(plainText)+chacha20poly1305.Overhead)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plainText, append(append([]byte{}, header...), recipientIdentity...)), nil
+}
+
+func x3dhOpen(key [keySize]byte, header, recipientIdentity, sealed []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := sealed[:chacha20poly1305.NonceSizeX]
+	plainText, err := aead.Open(nil, nonce, sealed[chacha20poly1305.NonceSizeX:], append(append([]byte{}, header...), recipientIdentity...))
+	if err != nil {
+		return nil, MessageDecryptionError
+	}
+	return plainText, nil
+}
+
+// generates and stores a new prekey private key
+func (engine *CryptoEngine) writePrekey(filename string) error {
+	private, err := generateSecretKey()
+	if err != nil {
+		return err
+	}
+	return engine.config.keyStore().WriteKey(filename, private[:])
+}
+
+// checks whether the prekey is in the key store
+func (engine *CryptoEngine) prekeyExists(filename string) bool {
+	_, err := engine.config.keyStore().ReadKey(filename)
+	return err == nil
+}
+
+// reads the prekey private key and computes its public key
+func (engine *CryptoEngine) readPrekey(filename string) ([keySize]byte, [keySize]byte, error) {
+	var public [keySize]byte
+
+	private, err := readStoreKey(engine.config.keyStore(), filename)
+	if err != nil {
+		return private, public, err
+	}
+
+	publicKey, err := curve25519.X25519(private[:], curve25519.Basepoint)
+	if err != nil {
+		return private, public, err
+	}
+	copy(public[:], publicKey)
+	return private, public, nil
+}
+
+// lists the ids of the prekeys of the engine, in ascending order
+func (engine *CryptoEngine) prekeyIds(format string) ([]uint32, error) {
+	lister, ok := engine.config.keyStore().(KeyLister)
+	if !ok {
+		return nil, KeyListError
+	}
+
+	prefix := fmt.Sprintf(strings.Replace(format, "%d.key", "", 1), engine.context)
+	names, err := lister.ListKeys(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint32
+	for _, name := range names {
+		name = strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".key")
+		id, err := strconv.ParseUint(name, 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
@@ -0,0 +1,280 @@
+package cryptoengine
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestX3DH(t *testing.T) {
+
+	alice, err := InitCryptoEngine("Sec51X3DHAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := InitCryptoEngine("Sec51X3DHBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bob.GenerateSignedPrekey(); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := bob.GenerateOneTimePrekeys(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 3 {
+		t.Fatalf("Expected 3 one-time prekeys, instead we've got %d\n", len(ids))
+	}
+
+	bundle, err := bob.PrekeyBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bundle.OneTimePrekeys) < 3 {
+		t.Fatalf("Expected at least 3 one-time prekeys in the bundle, instead we've got %d\n", len(bundle.OneTimePrekeys))
+	}
+
+	plainText := []byte("The quick brown fox jumps over the lazy dog")
+	message, aliceKey, err := alice.EncryptX3DH(plainText, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, sender, bobKey, err := bob.DecryptX3DH(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, plainText) {
+		t.Fatal("The decrypted message is not the original one")
+	}
+
+	if aliceKey != bobKey {
+		t.Fatal("The shared keys do not match")
+	}
+
+	senderPublicKey := sender.PublicKey()
+	if !bytes.Equal(senderPublicKey[:], alice.PublicKey()) {
+		t.Fatal("The sender is not alice")
+	}
+
+	// the one-time prekey has been consumed
+	if _, _, _, err := bob.DecryptX3DH(message); err != PrekeyNotFoundError {
+		t.Errorf("The expected error is: PrekeyNotFoundError, instead we've got: %v\n", err)
+	}
+
+	// without one-time prekeys
+	bundle.OneTimePrekeys = nil
+	message, _, err = alice.EncryptX3DH(plainText, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decrypted, _, _, err = bob.DecryptX3DH(message); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, plainText) {
+		t.Fatal("The decrypted message is not the original one")
+	}
+
+}
+
+// a key store which holds the first reads of a key until all of them have been made, so the readers overlap
+type barrierKeyStore struct {
+	*MemoryKeyStore
+	name    string
+	mutex   sync.Mutex
+	readers int
+	barrier sync.WaitGroup
+}
+
+func (store *barrierKeyStore) ReadKey(name string) ([]byte, error) {
+	data, err := store.MemoryKeyStore.ReadKey(name)
+	if name == store.name {
+		store.mutex.Lock()
+		wait := store.readers > 0
+		if wait {
+			store.readers--
+			store.barrier.Done()
+		}
+		store.mutex.Unlock()
+		if wait {
+			store.barrier.Wait()
+		}
+	}
+	return data, err
+}
+
+func TestX3DHConcurrentDecryption(t *testing.T) {
+
+	alice, err := InitCryptoEngineWithConfig("Sec51X3DHAlice", Config{KeyStore: NewMemoryKeyStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two engines of bob share the key store, as after a reload
+	store := &barrierKeyStore{MemoryKeyStore: NewMemoryKeyStore()}
+	bob, err := InitCryptoEngineWithConfig("Sec51X3DHBob", Config{KeyStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := InitCryptoEngineWithConfig("Sec51X3DHBob", Config{KeyStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bob.GenerateSignedPrekey(); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := bob.GenerateOneTimePrekeys(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := bob.PrekeyBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, _, err := alice.EncryptX3DH([]byte("data"), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same message is decrypted only once, even when the one-time prekey is read by all of them
+	errs := make(chan error, 4)
+	store.name = fmt.Sprintf(oneTimePrekeySuffixFormat, bob.context, ids[0])
+	store.readers = cap(errs)
+	store.barrier.Add(cap(errs))
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		engine := bob
+		if i%2 == 1 {
+			engine = reloaded
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _, err := engine.DecryptX3DH(message)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	decrypted := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			decrypted++
+		case PrekeyNotFoundError:
+		default:
+			t.Fatal(err)
+		}
+	}
+	if decrypted != 1 {
+		t.Fatalf("Expected 1 decrypted message, instead we've got %d\n", decrypted)
+	}
+
+}
+
+func TestX3DHErrors(t *testing.T) {
+
+	alice, err := InitCryptoEngine("Sec51X3DHAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := InitCryptoEngine("Sec51X3DHBob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bob.GenerateSignedPrekey(); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := bob.PrekeyBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle.OneTimePrekeys = nil
+
+	// the signed prekey has been replaced
+	forged := bundle
+	forged.SignedPrekey[0] ^= 1
+	if _, _, err := alice.EncryptX3DH([]byte("data"), forged); err != SignatureError {
+		t.Errorf("The expected error is: SignatureError, instead we've got: %v\n", err)
+	}
+
+	// tampered message
+	message, _, err := alice.EncryptX3DH([]byte("data"), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message[len(message)-1] ^= 1
+	if _, _, _, err := bob.DecryptX3DH(message); err != MessageDecryptionError {
+		t.Errorf("The expected error is: MessageDecryptionError, instead we've got: %v\n", err)
+	}
+
+	if _, _, _, err := bob.DecryptX3DH(message[:10]); err != X3DHFormatError {
+		t.Errorf("The expected error is: X3DHFormatError, instead we've got: %v\n", err)
+	}
+
+	// the signed prekey has been deleted
+	message, _, err = alice.EncryptX3DH([]byte("data"), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bob.DeleteSignedPrekey(bundle.SignedPrekeyId); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := bob.DecryptX3DH(message); err != PrekeyNotFoundError {
+		t.Errorf("The expected error is: PrekeyNotFoundError, instead we've got: %v\n", err)
+	}
+
+}
+
+func TestX3DHKeyStore(t *testing.T) {
+
+	store := NewMemoryKeyStore()
+	bob, err := InitCryptoEngineWithConfig("Sec51X3DHStore", Config{KeyStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := bob.GenerateSignedPrekey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.GenerateOneTimePrekeys(2); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := bob.PrekeyBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.SignedPrekeyId != id || len(bundle.OneTimePrekeys) != 2 {
+		t.Fatal("The prekeys have not been stored in the key store of the engine")
+	}
+
+	// the prekeys cannot be listed
+	lister, err := InitCryptoEngineWithConfig("Sec51X3DHStore", Config{KeyStore: struct{ KeyStore }{store}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lister.PrekeyBundle(); err != KeyListError {
+		t.Errorf("The expected error is: KeyListError, instead we've got: %v\n", err)
+	}
+
+}